@@ -8,6 +8,6 @@ import (
 
 func main() {
 	if err := cli.NewRootCmd().Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }
@@ -14,6 +14,8 @@ var (
 	ErrBlobNotFound            = errors.New("blob: not found")
 	ErrBadBlob                 = errors.New("blob: bad blob")
 	ErrBadBlobDigest           = errors.New("blob: bad blob digest")
+	ErrColdTierUnavailable     = errors.New("storage: unable to open the cold tier records")
+	ErrInsufficientStorage     = errors.New("storage: insufficient free disk space")
 	ErrUnknownCode             = errors.New("error: unknown error code")
 	ErrBadCACert               = errors.New("tls: invalid ca cert")
 	ErrBadUser                 = errors.New("ldap: non-existent user")
@@ -37,7 +39,76 @@ var (
 	ErrScanNotSupported        = errors.New("search: scanning of image media type not supported")
 	ErrCLITimeout              = errors.New("cli: Query timed out while waiting for results")
 	ErrDuplicateConfigName     = errors.New("cli: cli config name already added")
+	ErrInvalidFormatTemplate   = errors.New("cli: invalid format template")
+	ErrUnknownColumn           = errors.New("cli: unknown table column")
+	ErrInvalidConfigValue      = errors.New("cli: invalid config value")
+	ErrDigestMismatch          = errors.New("cli: content does not match the digest sent by the server")
+	ErrRequestFailed           = errors.New("cli: request to the zot server failed")
+	ErrInvalidSeverity         = errors.New("cli: invalid severity, expected UNKNOWN, LOW, MEDIUM, HIGH or CRITICAL")
+	ErrCVEThresholdExceeded    = errors.New("cli: image has CVEs at or above the severity threshold")
+	ErrCVEScanTimeout          = errors.New("cve: image scan timed out")
 	ErrInvalidRoute            = errors.New("routes: invalid route prefix")
 	ErrImgStoreNotFound        = errors.New("routes: image store not found corresponding to given route")
 	ErrEmptyValue              = errors.New("cache: empty value")
+	ErrImmutableTag            = errors.New("manifest: tag is immutable and can not be overwritten")
+	ErrMediaTypeNotAllowed     = errors.New("manifest: media type not allowed in this repository")
+	ErrManifestNotConvertible  = errors.New("manifest: can't be converted to the requested media type")
+	ErrManifestChanged         = errors.New("manifest: tag now references another manifest")
+	ErrQuotaExceeded           = errors.New("repository: size quota exceeded")
+	ErrNamespaceNotFound       = errors.New("namespace: not found")
+	ErrTenantNotFound          = errors.New("tenant: not found")
+	ErrTenantQuotaExceeded     = errors.New("tenant: storage quota exceeded")
+	ErrSchedulerQueueFull      = errors.New("scheduler: task queue is full")
+	ErrSchedulerBadPriority    = errors.New("scheduler: invalid task priority")
+	ErrSchedulerTaskPending    = errors.New("scheduler: task is already queued or running")
+	ErrWebhookFailed           = errors.New("auth: webhook request failed")
+	ErrSignatureNotFound       = errors.New("signature: no signature found")
+	ErrBadSignature            = errors.New("signature: invalid signature")
+	ErrUntrustedSignature      = errors.New("signature: signer is not trusted")
+	ErrBadEncryptionKey        = errors.New("blob: invalid or mismatching encryption key")
+	ErrBadEncryptedBlob        = errors.New("blob: corrupted encrypted blob")
+	ErrNoActivatedSockets      = errors.New("controller: no sockets passed by systemd socket activation")
+	ErrNoAuthenticatedUser     = errors.New("userprefs: no authenticated user")
+	ErrSyncQueueFull           = errors.New("sync: replication queue is full")
+	ErrSyncPushFailed          = errors.New("sync: downstream registry rejected the push")
+	ErrSyncConflict            = errors.New("sync: downstream registry refused the image")
+	ErrSyncScanNotEnabled      = errors.New("sync: cve policies need the search extension with cve scanning")
+	ErrSyncNotQuarantined      = errors.New("sync: image is not quarantined")
+	ErrSyncRegistryNotFound    = errors.New("sync: no downstream registry with this host")
+	ErrSyncCredentials         = errors.New("sync: unable to get the credentials of the registry")
+	ErrSyncPlatformNotFound    = errors.New("sync: index has none of the platforms replicated to the registry")
+	ErrBadBundle               = errors.New("bundle: invalid bundle")
+	ErrBadSigningKey           = errors.New("bundle: invalid signing key or certificate")
+	ErrAmbiguousDigest         = errors.New("manifest: short digest matches several manifests")
+	ErrRetagScanNotEnabled     = errors.New("retag: cve retag policies need the search extension with cve scanning")
+	ErrRetagImmutableAlias     = errors.New("retag: alias tag is immutable")
+	ErrPushScanNotEnabled      = errors.New("pushscan: scan on push needs the search extension with cve scanning")
+	ErrVulnerableImage         = errors.New("pushscan: image has vulnerabilities at or above the severity threshold")
+	ErrComplianceCheckFailed   = errors.New("compliance: registry doesn't conform to the distribution spec")
+	ErrBenchmarkFailed         = errors.New("cli: benchmark operations failed")
+	ErrStorageInUse            = errors.New("fsck: storage is in use, stop zot first")
+	ErrStorageInconsistent     = errors.New("fsck: storage is inconsistent")
+	ErrSnapshotNotFound        = errors.New("backup: snapshot not found")
+	ErrBadSnapshot             = errors.New("backup: invalid snapshot")
+	ErrRestoreTargetNotEmpty   = errors.New("backup: restore root directory is not empty")
+	ErrChangefeedCursorExpired = errors.New("changefeed: events following the cursor are not kept anymore")
+	ErrReflinkNotSupported     = errors.New("storage: reflinks are not supported on this platform")
+	ErrProvenanceNotFound      = errors.New("provenance: no provenance attestation found")
+	ErrBadAttestation          = errors.New("provenance: invalid attestation")
+	ErrUntrustedBuilder        = errors.New("provenance: builder is not trusted")
+	ErrUnknownImportSource     = errors.New("cli: import source must be harbor, quay or dockerhub")
+	ErrCheckpointMismatch      = errors.New("cli: checkpoint file was written by the import of another source")
+	ErrPlatformNotFound        = errors.New("cli: manifest list has no manifest for the platform")
+	ErrImportIncomplete        = errors.New("cli: some images weren't imported, run the import again to resume")
+	ErrResourceKindUnknown     = errors.New("resources: unknown resource kind")
+	ErrResourceNotFound        = errors.New("resources: not found")
+	ErrResourceConflict        = errors.New("resources: resource version doesn't match, it was changed meanwhile")
+	ErrInsecureHTTP            = errors.New("cli: server only speaks plain HTTP, allow it with --insecure-http or the insecure config variable")
+	ErrIndexManifestNotFound   = errors.New("manifest: index references a manifest unknown to the repository")
+	ErrIndexTooDeep            = errors.New("manifest: indexes are nested too deeply")
+	ErrForeignManifest         = errors.New("manifest: index references a manifest outside the repository")
+	ErrIndexConflict           = errors.New("repository: index.json kept changing while it was updated")
+	ErrExtensionNotEnabled     = errors.New("cli: extension is not enabled on the server")
+	ErrLintViolation           = errors.New("lint: image breaks the lint rules")
+	ErrBadSemverConstraint     = errors.New("semver: invalid version constraint")
 )
@@ -0,0 +1,129 @@
+package errors
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Error is an error reported by the registry with an HTTP status and a distribution spec error code,
+// e.g. MANIFEST_UNKNOWN. Err is its cause, usually one of the errors above, so that Is and As match it,
+// and Message replaces the message of the cause if set.
+type Error struct {
+	Err     error
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	// e.g. "name unknown: repository name not known to registry", as docker reports them
+	if e.Code != "" && e.Message != "" {
+		return strings.ToLower(strings.ReplaceAll(e.Code, "_", " ")) + ": " + e.Message
+	}
+
+	if e.Message != "" {
+		return e.Message
+	}
+
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether any error in err's chain matches target, as the standard errors.Is does.
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target, as the standard errors.As does.
+func As(err error, target interface{}) bool {
+	return errors.As(err, target)
+}
+
+type errorStatus struct {
+	err    error
+	status int
+	code   string
+}
+
+// statuses are the HTTP status and the error code the registry reports errors with, the first error
+// of a code is the one an error response with this code is translated to.
+var statuses = []errorStatus{
+	{ErrUnauthorizedAccess, http.StatusUnauthorized, "UNAUTHORIZED"},
+	{ErrRepoNotFound, http.StatusNotFound, "NAME_UNKNOWN"},
+	{ErrRepoBadVersion, http.StatusNotFound, "NAME_UNKNOWN"},
+	{ErrManifestNotFound, http.StatusNotFound, "MANIFEST_UNKNOWN"},
+	{ErrBadManifest, http.StatusBadRequest, "MANIFEST_INVALID"},
+	{ErrIndexTooDeep, http.StatusBadRequest, "MANIFEST_INVALID"},
+	{ErrForeignManifest, http.StatusBadRequest, "MANIFEST_INVALID"},
+	{ErrIndexManifestNotFound, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN"},
+	{ErrBlobNotFound, http.StatusNotFound, "BLOB_UNKNOWN"},
+	{ErrBadBlobDigest, http.StatusBadRequest, "DIGEST_INVALID"},
+	{ErrUploadNotFound, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN"},
+	{ErrBadUploadRange, http.StatusRequestedRangeNotSatisfiable, "BLOB_UPLOAD_INVALID"},
+	{ErrInsufficientStorage, http.StatusInsufficientStorage, "BLOB_UPLOAD_INVALID"},
+	{ErrImmutableTag, http.StatusConflict, "DENIED"},
+	{ErrMediaTypeNotAllowed, http.StatusUnsupportedMediaType, "MANIFEST_INVALID"},
+	{ErrQuotaExceeded, http.StatusForbidden, "DENIED"},
+	{ErrTenantQuotaExceeded, http.StatusForbidden, "DENIED"},
+}
+
+// StatusOf returns the HTTP status and the error code err is reported with, or
+// http.StatusInternalServerError and an empty code if it is unexpected.
+func StatusOf(err error) (int, string) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Status, e.Code
+	}
+
+	for _, s := range statuses {
+		if errors.Is(err, s.err) {
+			return s.status, s.code
+		}
+	}
+
+	return http.StatusInternalServerError, ""
+}
+
+// FromResponse returns the error of a registry response with an unexpected status, given its body.
+// Errors listed by the body as the distribution spec describes are translated to the errors above,
+// e.g. MANIFEST_UNKNOWN to ErrManifestNotFound, the others are ErrRequestFailed, and keep the message
+// of the registry. Unauthorized responses are always ErrUnauthorizedAccess.
+func FromResponse(status int, body []byte) *Error {
+	e := &Error{Err: ErrRequestFailed, Status: status, Message: strings.TrimSpace(string(body))}
+
+	var list struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if err := json.Unmarshal(body, &list); err == nil && len(list.Errors) > 0 {
+		e.Code, e.Message = list.Errors[0].Code, list.Errors[0].Message
+	}
+
+	switch {
+	case status == http.StatusUnauthorized:
+		// the message of the registry doesn't tell what to do about it
+		e.Err, e.Message = ErrUnauthorizedAccess, ""
+	case e.Code != "":
+		for _, s := range statuses {
+			if s.code == e.Code {
+				e.Err = s.err
+
+				break
+			}
+		}
+	}
+
+	if e.Message == "" && e.Err == ErrRequestFailed {
+		e.Message = http.StatusText(status)
+	}
+
+	return e
+}
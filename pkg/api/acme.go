@@ -0,0 +1,91 @@
+package api
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"path"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const acmeCacheDir = ".acme"
+
+// acmeManager returns the manager obtaining the certificates of the ACME config, listeners sharing
+// a config share its manager.
+func (c *Controller) acmeManager(config *ACMEConfig) *autocert.Manager {
+	if c.acmeManagers == nil {
+		c.acmeManagers = make(map[*ACMEConfig]*autocert.Manager)
+	}
+
+	if m, ok := c.acmeManagers[config]; ok {
+		return m
+	}
+
+	cacheDir := config.CacheDir
+	if cacheDir == "" {
+		cacheDir = path.Join(c.Config.Storage.RootDirectory, acmeCacheDir)
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(config.Domains...),
+		Email:      config.Email,
+	}
+
+	if config.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: config.DirectoryURL}
+	}
+
+	c.acmeManagers[config] = m
+
+	return m
+}
+
+// configureACME serves the certificates obtained via ACME, and answers TLS-ALPN-01 challenges.
+func (c *Controller) configureACME(tlsConfig *tls.Config, config *ACMEConfig, http2 bool) {
+	tlsConfig.GetCertificate = c.acmeManager(config).GetCertificate
+
+	// in order of preference, the challenge protocol is only offered by ACME CAs
+	tlsConfig.NextProtos = []string{"http/1.1", acme.ALPNProto}
+	if http2 {
+		tlsConfig.NextProtos = append([]string{"h2"}, tlsConfig.NextProtos...)
+	}
+}
+
+// listenACMEChallenges creates the listeners answering HTTP-01 challenges, other requests are
+// redirected to HTTPS.
+func (c *Controller) listenACMEChallenges() ([]listener, error) {
+	listeners := make([]listener, 0)
+	addresses := make(map[string]bool)
+
+	for config, m := range c.acmeManagers {
+		if config.HTTPChallengeAddress == "" || addresses[config.HTTPChallengeAddress] {
+			continue
+		}
+
+		l, err := net.Listen("tcp", config.HTTPChallengeAddress)
+		if err != nil {
+			c.Log.Error().Err(err).Str("address", config.HTTPChallengeAddress).Msg("unable to listen")
+
+			for _, l := range listeners {
+				l.listener.Close()
+			}
+
+			return nil, err
+		}
+
+		addresses[config.HTTPChallengeAddress] = true
+		server := &http.Server{
+			Addr:        config.HTTPChallengeAddress,
+			Handler:     m.HTTPHandler(nil),
+			IdleTimeout: idleTimeout,
+		}
+
+		listeners = append(listeners, listener{server: server, listener: l})
+	}
+
+	return listeners, nil
+}
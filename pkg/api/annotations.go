@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/gorilla/mux"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// AnnotationsRoutePrefix is where the annotations of tagged manifests are patched.
+const AnnotationsRoutePrefix = ExtRoutePrefix + "/annotations"
+
+// PatchAnnotations godoc
+// @Summary Add, update or remove annotations of a tagged image manifest
+// @Description Apply a JSON merge patch to the annotations of the manifest of a tag, a null value removes
+// @Description an annotation. The patched manifest has a new digest and the tag is moved to it,
+// @Description If-Match optionally gives the digest the tag must still reference.
+// @Accept  json
+// @Produce json
+// @Param   name          path    string     true        "repository name"
+// @Param   tag           query   string     true        "tag"
+// @Param   If-Match      header  string     false       "digest the tag must reference"
+// @Param   annotations   body    object     true        "annotations to set, null to remove them"
+// @Success 200 {string} string "ok"
+// @Header  200 {object} api.DistContentDigestKey
+// @Failure 400 {string} string "bad request"
+// @Failure 403 {string} string "forbidden"
+// @Failure 404 {string} string "not found"
+// @Failure 412 {string} string "precondition failed"
+// @Failure 500 {string} string "internal server error"
+// @Router /v2/_zot/ext/annotations/{name} [patch].
+func (rh *RouteHandler) PatchAnnotations(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	tag := r.URL.Query().Get("tag")
+
+	// the patched manifest has a new digest, only a tag can follow it
+	if _, err := godigest.Parse(tag); tag == "" || err == nil {
+		WriteJSON(w, http.StatusBadRequest, NewErrorList(NewError(TAG_INVALID, map[string]string{"tag": tag})))
+		return
+	}
+
+	var patch map[string]*string
+
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		WriteJSON(w, http.StatusBadRequest,
+			NewErrorList(NewError(MANIFEST_INVALID, map[string]string{"reference": tag, "reason": err.Error()})))
+
+		return
+	}
+
+	var expected godigest.Digest
+
+	if ifMatch := strings.Trim(r.Header.Get("If-Match"), `"`); ifMatch != "" {
+		digest, err := godigest.Parse(ifMatch)
+		if err != nil {
+			WriteJSON(w, http.StatusBadRequest,
+				NewErrorList(NewError(DIGEST_INVALID, map[string]string{"digest": ifMatch})))
+
+			return
+		}
+
+		expected = digest
+	}
+
+	if rh.c.isImmutableTag(name, tag) {
+		rh.logger(r).Warn().Str("repository", name).Str("tag", tag).Msg("rejecting update of an immutable tag")
+		WriteJSON(w, http.StatusForbidden,
+			NewErrorList(NewError(DENIED, map[string]string{"reference": tag,
+				"reason": errors.ErrImmutableTag.Error()})))
+
+		return
+	}
+
+	span := startStorageSpan(r, "PatchManifestAnnotations", name)
+	digest, err := rh.getImageStore(r, name).PatchManifestAnnotations(name, tag, expected, patch)
+	endSpan(span, err)
+
+	switch {
+	case err == nil:
+	case errors.Is(err, errors.ErrRepoNotFound), errors.Is(err, errors.ErrRepoBadVersion):
+		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
+		return
+	case errors.Is(err, errors.ErrManifestNotFound):
+		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(MANIFEST_UNKNOWN, map[string]string{"reference": tag})))
+		return
+	case errors.Is(err, errors.ErrManifestChanged):
+		WriteJSON(w, http.StatusPreconditionFailed,
+			NewErrorList(NewError(MANIFEST_UNKNOWN, map[string]string{"reference": tag, "reason": err.Error()})))
+
+		return
+	case errors.Is(err, errors.ErrBadManifest):
+		WriteJSON(w, http.StatusBadRequest, NewErrorList(NewError(MANIFEST_INVALID, map[string]string{"reference": tag})))
+		return
+	default:
+		rh.logger(r).Error().Err(err).Msg("unexpected error")
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	if rh.c.replicator != nil {
+		rh.c.replicator.Notify(name, tag)
+	}
+
+	if rh.c.retagger != nil {
+		rh.c.retagger.Notify(name, tag)
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", name, digest))
+	w.Header().Set(DistContentDigestKey, digest.String())
+	w.WriteHeader(http.StatusOK)
+}
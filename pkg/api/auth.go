@@ -2,6 +2,7 @@ package api
 
 import (
 	"bufio"
+	"context"
 	"crypto/x509"
 	"encoding/base64"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/log"
 	"github.com/chartmuseum/auth"
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
@@ -20,18 +22,117 @@ import (
 
 const (
 	bearerAuthDefaultAccessEntryType = "repository"
+	// bearerAdminAction is the action tokens grant on a repository, or on "" for the other endpoints,
+	// to use the admin endpoints
+	bearerAdminAction = "admin"
 )
 
+// withUsername stores the authenticated user in the request context.
+func withUsername(r *http.Request, username string) *http.Request {
+	return r.WithContext(log.WithUsername(r.Context(), username))
+}
+
+// getUsername returns the authenticated user of the request, empty if none.
+func getUsername(r *http.Request) string {
+	return log.GetUsername(r.Context())
+}
+
+// isAdminRequest returns true for admin and debug endpoints which always require authN.
+func isAdminRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, RoutePrefix+AdminRoutePrefix+"/") ||
+		strings.HasPrefix(r.URL.Path, DebugRoutePrefix+"/") || r.URL.Path == RoutePrefix+CVERefreshRoute
+}
+
+// isReadRequest returns true for requests which don't modify anything, search queries included whichever
+// their method, GraphQL clients POST them too.
+func isReadRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead ||
+		(r.Method == http.MethodPost && r.URL.Path == SearchRoute)
+}
+
+func isPasswordAuthEnabled(c *Controller) bool {
+	return c.Config.HTTP.Auth != nil && (c.Config.HTTP.Auth.HTPasswd.Path != "" || c.Config.HTTP.Auth.LDAP != nil)
+}
+
+// isAuthnEnabled tells whether requests are authenticated, by password, bearer token or the auth webhook.
+func isAuthnEnabled(c *Controller) bool {
+	return isPasswordAuthEnabled(c) ||
+		(c.Config.HTTP.Auth != nil && (c.Config.HTTP.Auth.Bearer != nil || c.Config.HTTP.Auth.Webhook != nil))
+}
+
+// withAdminGranted marks a request as granted admin access by the auth webhook or its bearer token.
+func withAdminGranted(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), adminGrantedContextKey, true))
+}
+
+func isAdminGranted(r *http.Request) bool {
+	granted, _ := r.Context().Value(adminGrantedContextKey).(bool)
+
+	return granted
+}
+
+// isAdminUser tells whether the user of a request is one of the admin users, or was granted admin access by
+// the auth webhook or its bearer token. Anyone is if admin access is allowed to all.
+func (c *Controller) isAdminUser(r *http.Request) bool {
+	if c.Config.HTTP.AllowAdminAccess || isAdminGranted(r) {
+		return true
+	}
+
+	username := getUsername(r)
+	if username == "" || c.Config.HTTP.Auth == nil {
+		return false
+	}
+
+	for _, admin := range c.Config.HTTP.Auth.AdminUsers {
+		if admin == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AdminHandler restricts a handler to admin users.
+func AdminHandler(c *Controller, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.isAdminUser(r) {
+			next(w, r)
+			return
+		}
+
+		c.Log.Warn().Str("username", getUsername(r)).Str("path", r.URL.Path).Msg("admin access denied")
+		WriteJSON(w, http.StatusForbidden, NewErrorList(NewError(DENIED)))
+	}
+}
+
 func AuthHandler(c *Controller) mux.MiddlewareFunc {
-	if c.Config.HTTP.Auth != nil &&
+	authHandler := basicAuthHandler
+
+	if c.Config.HTTP.Auth != nil && c.Config.HTTP.Auth.Webhook != nil {
+		authHandler = webhookAuthHandler
+	} else if c.Config.HTTP.Auth != nil &&
 		c.Config.HTTP.Auth.Bearer != nil &&
 		c.Config.HTTP.Auth.Bearer.Cert != "" &&
 		c.Config.HTTP.Auth.Bearer.Realm != "" &&
 		c.Config.HTTP.Auth.Bearer.Service != "" {
-		return bearerAuthHandler(c)
+		authHandler = bearerAuthHandler
 	}
 
-	return basicAuthHandler(c)
+	authenticate := authHandler(c)
+
+	// probes can't authenticate, and pre-signed URLs are checked by PresignHandler
+	return func(next http.Handler) http.Handler {
+		authenticated := authenticate(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if (r.URL.Path == ReadinessRoute && r.Method == http.MethodGet) || isPresigned(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			authenticated.ServeHTTP(w, r)
+		})
+	}
 }
 
 func bearerAuthHandler(c *Controller) mux.MiddlewareFunc {
@@ -52,7 +153,7 @@ func bearerAuthHandler(c *Controller) mux.MiddlewareFunc {
 			name := vars["name"]
 			header := r.Header.Get("Authorization")
 			action := auth.PullAction
-			if m := r.Method; m != http.MethodGet && m != http.MethodHead {
+			if !isReadRequest(r) {
 				action = auth.PushAction
 			}
 			permissions, err := authorizer.Authorize(header, action, name)
@@ -66,6 +167,13 @@ func bearerAuthHandler(c *Controller) mux.MiddlewareFunc {
 				authFail(w, permissions.WWWAuthenticateHeader, 0)
 				return
 			}
+			// admin endpoints also need the admin action, checked by AdminHandler
+			if isAdminRequest(r) {
+				permissions, err = authorizer.Authorize(header, bearerAdminAction, name)
+				if err == nil && permissions.Allowed {
+					r = withAdminGranted(r)
+				}
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -81,18 +189,18 @@ func basicAuthHandler(c *Controller) mux.MiddlewareFunc {
 	realm = "Basic realm=" + strconv.Quote(realm)
 
 	// no password based authN, if neither LDAP nor HTTP BASIC is enabled
-	if c.Config.HTTP.Auth == nil || (c.Config.HTTP.Auth.HTPasswd.Path == "" && c.Config.HTTP.Auth.LDAP == nil) {
+	if !isPasswordAuthEnabled(c) {
 		return func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if c.Config.HTTP.AllowReadAccess &&
-					c.Config.HTTP.TLS.CACert != "" &&
-					r.TLS.VerifiedChains == nil &&
-					r.Method != http.MethodGet && r.Method != http.MethodHead {
+					c.Config.HTTP.mutualTLS() &&
+					(r.TLS == nil || r.TLS.VerifiedChains == nil) &&
+					!isReadRequest(r) {
 					authFail(w, realm, 5)
 					return
 				}
 
-				if (r.Method != http.MethodGet && r.Method != http.MethodHead) && c.Config.HTTP.ReadOnly {
+				if !isReadRequest(r) && c.Config.HTTP.ReadOnly {
 					// Reject modification requests in read-only mode
 					w.WriteHeader(http.StatusMethodNotAllowed)
 					return
@@ -175,13 +283,30 @@ func basicAuthHandler(c *Controller) mux.MiddlewareFunc {
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if (r.Method == http.MethodGet || r.Method == http.MethodHead) && c.Config.HTTP.AllowReadAccess {
+			// session cookies of browser clients, in place of credentials, except to log in again
+			if c.sessions != nil && r.Header.Get("Authorization") == "" && !isLoginRequest(r) {
+				if username, ok := c.sessions.authenticate(r); ok {
+					if !isReadRequest(r) && c.Config.HTTP.ReadOnly && !isSessionRequest(r) {
+						w.WriteHeader(http.StatusMethodNotAllowed)
+						return
+					}
+
+					next.ServeHTTP(w, withUsername(r, username))
+
+					return
+				}
+			}
+
+			// anonymous reads, requests with credentials are authenticated so that the user is known
+			if isReadRequest(r) && c.Config.HTTP.AllowReadAccess &&
+				!isAdminRequest(r) && r.Header.Get("Authorization") == "" {
 				// Process request
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			if (r.Method != http.MethodGet && r.Method != http.MethodHead) && c.Config.HTTP.ReadOnly {
+			// logging in and out is allowed in read-only mode
+			if !isReadRequest(r) && c.Config.HTTP.ReadOnly && !isSessionRequest(r) {
 				// Reject modification requests in read-only mode
 				w.WriteHeader(http.StatusMethodNotAllowed)
 				return
@@ -221,7 +346,7 @@ func basicAuthHandler(c *Controller) mux.MiddlewareFunc {
 			if ok {
 				if err := bcrypt.CompareHashAndPassword([]byte(passphraseHash), []byte(passphrase)); err == nil {
 					// Process request
-					next.ServeHTTP(w, r)
+					next.ServeHTTP(w, withUsername(r, username))
 					return
 				}
 			}
@@ -231,7 +356,7 @@ func basicAuthHandler(c *Controller) mux.MiddlewareFunc {
 				ok, _, err := ldapClient.Authenticate(username, passphrase)
 				if ok && err == nil {
 					// Process request
-					next.ServeHTTP(w, r)
+					next.ServeHTTP(w, withUsername(r, username))
 					return
 				}
 			}
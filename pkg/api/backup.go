@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/anuvu/zot/pkg/storage"
+)
+
+// BackupStatus lists the snapshots of an image store, from the oldest to the latest.
+type BackupStatus struct {
+	Directory string   `json:"directory"`
+	Snapshots []string `json:"snapshots"`
+}
+
+// GetBackups godoc
+// @Summary List backups
+// @Description List the snapshots of each image store
+// @Accept  json
+// @Produce json
+// @Success 200 {object} 	map[string]api.BackupStatus
+// @Failure 500 {string} 	string 				"internal server error"
+// @Router /v2/_zot/admin/backup [get].
+func (rh *RouteHandler) GetBackups(w http.ResponseWriter, r *http.Request) {
+	statuses := make(map[string]BackupStatus)
+
+	for route := range rh.imageStores() {
+		dir := rh.c.Config.Storage.Backup.storeDirectory(route)
+
+		snapshots, err := storage.Snapshots(dir)
+		if err != nil {
+			rh.logger(r).Error().Err(err).Str("backupDir", dir).Msg("unable to list snapshots")
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		statuses[route] = BackupStatus{Directory: dir, Snapshots: snapshots}
+	}
+
+	WriteJSON(w, http.StatusOK, statuses)
+}
+
+// Backup godoc
+// @Summary Back up the image stores
+// @Description Take a consistent snapshot of each image store, pushes wait until it's done. It's incremental
+// @Description unless full is set, only the blobs which weren't backed up yet are copied.
+// @Accept  json
+// @Produce json
+// @Param   full    query    bool    false  "copy all the blobs"
+// @Success 200 {object} 	map[string]storage.BackupReport
+// @Failure 400 {string} 	string 				"bad request"
+// @Failure 500 {string} 	string 				"internal server error"
+// @Router /v2/_zot/admin/backup [post].
+func (rh *RouteHandler) Backup(w http.ResponseWriter, r *http.Request) {
+	full := false
+
+	if value := r.URL.Query().Get("full"); value != "" {
+		var err error
+
+		if full, err = strconv.ParseBool(value); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	reports := make(map[string]storage.BackupReport)
+
+	for route, imgStore := range rh.imageStores() {
+		report, err := imgStore.Backup(rh.c.Config.Storage.Backup.storeDirectory(route), !full)
+		if err != nil {
+			rh.logger(r).Error().Err(err).Str("rootDir", imgStore.RootDir()).Msg("unable to back up image store")
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		reports[route] = report
+	}
+
+	WriteJSON(w, http.StatusOK, reports)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const certReloadInterval = 5 * time.Second
+
+// certReloader serves the certificate of a TLS listener, reloading it when its files change,
+// so that certificates can be rotated without a restart. Established connections are not affected.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	stamp    [2]os.FileInfo
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// GetCertificate returns the current certificate, see tls.Config.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+
+	return cr.cert, nil
+}
+
+func (cr *certReloader) stat() ([2]os.FileInfo, error) {
+	var stamp [2]os.FileInfo
+
+	for i, file := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return stamp, err
+		}
+
+		stamp[i] = info
+	}
+
+	return stamp, nil
+}
+
+// changed returns true if the cert or key file was modified since the last reload.
+func (cr *certReloader) changed() bool {
+	stamp, err := cr.stat()
+	if err != nil {
+		return false
+	}
+
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+
+	for i, info := range stamp {
+		if !info.ModTime().Equal(cr.stamp[i].ModTime()) || info.Size() != cr.stamp[i].Size() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reload loads the cert and key files, the current certificate is kept if they don't match,
+// e.g. while they are being rotated.
+func (cr *certReloader) reload() error {
+	stamp, err := cr.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	cr.cert = &cert
+	cr.stamp = stamp
+
+	return nil
+}
+
+// watchCertificates reloads the certificates of the TLS listeners when their files change or on SIGHUP.
+func (c *Controller) watchCertificates(ctx context.Context) {
+	if len(c.certReloaders) == 0 {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		ticker := time.NewTicker(certReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			var force bool
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				force = true
+			case <-ticker.C:
+			}
+
+			for _, cr := range c.certReloaders {
+				if !force && !cr.changed() {
+					continue
+				}
+
+				if err := cr.reload(); err != nil {
+					c.Log.Error().Err(err).Str("cert", cr.certFile).Msg("unable to reload TLS certificate")
+					continue
+				}
+
+				c.Log.Info().Str("cert", cr.certFile).Msg("reloaded TLS certificate")
+			}
+		}
+	}()
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/changefeed"
+)
+
+// ChangefeedRoute serves the event log of the changes of the repositories.
+const ChangefeedRoute = ExtRoutePrefix + "/changefeed"
+
+const (
+	defaultChangefeedPageSize = 100
+	maxChangefeedPageSize     = 1000
+)
+
+// ChangefeedPage is a page of events, Cursor is where to read the next page from.
+type ChangefeedPage struct {
+	Events []changefeed.Event `json:"events"`
+	Cursor uint64             `json:"cursor"`
+}
+
+// GetChangefeed godoc
+// @Summary List repository changes
+// @Description List the pushes, deletes and tag moves following a cursor, from the oldest event kept if
+// @Description it's 0. A Link header points to the next page if there are more events.
+// @Accept  json
+// @Produce json
+// @Param   cursor  query    int     false  "cursor of the last event read"
+// @Param   n       query    int     false  "maximum number of events, 100 by default"
+// @Success 200 {object} 	api.ChangefeedPage
+// @Failure 400 {string} 	string 				"bad request"
+// @Failure 410 {string} 	string 				"events following the cursor were pruned"
+// @Failure 500 {string} 	string 				"internal server error"
+// @Router /v2/_zot/ext/changefeed [get].
+func (rh *RouteHandler) GetChangefeed(w http.ResponseWriter, r *http.Request) {
+	var (
+		cursor uint64
+		n      = defaultChangefeedPageSize
+		err    error
+	)
+
+	query := r.URL.Query()
+
+	if value := query.Get("cursor"); value != "" {
+		if cursor, err = strconv.ParseUint(value, 10, 64); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if value := query.Get("n"); value != "" {
+		if n, err = strconv.Atoi(value); err != nil || n <= 0 || n > maxChangefeedPageSize {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// the events of the repositories of tenants are only listed to their members
+	events, next, more, err := rh.c.changefeed.Events(cursor, n, func(repo string) bool {
+		return rh.c.isTenantAllowed(r, repo)
+	})
+
+	switch {
+	case err == nil:
+	case errors.Is(err, errors.ErrChangefeedCursorExpired):
+		WriteJSON(w, http.StatusGone, NewErrorList(NewError(UNSUPPORTED, map[string]string{"reason": err.Error()})))
+		return
+	default:
+		rh.logger(r).Error().Err(err).Msg("unable to read changefeed")
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	if more {
+		w.Header().Set("Link", fmt.Sprintf("%s%s?cursor=%d&n=%d; rel=\"next\"", RoutePrefix, ChangefeedRoute, next, n))
+	}
+
+	WriteJSON(w, http.StatusOK, ChangefeedPage{Events: events, Cursor: next})
+}
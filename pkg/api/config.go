@@ -1,9 +1,17 @@
 package api
 
 import (
+	"crypto/tls"
+	"net/url"
+	"path"
+	"time"
+
 	"github.com/anuvu/zot/errors"
 	ext "github.com/anuvu/zot/pkg/extensions"
 	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/netpolicy"
+	"github.com/anuvu/zot/pkg/scheduler"
+	"github.com/anuvu/zot/pkg/storage"
 	"github.com/getlantern/deepcopy"
 	dspec "github.com/opencontainers/distribution-spec"
 )
@@ -12,18 +20,112 @@ import (
 var (
 	Commit     string // nolint: gochecknoglobals
 	BinaryType string // nolint: gochecknoglobals
+	FIPSMode   bool   // nolint: gochecknoglobals // set by builds with a boringcrypto toolchain and the fips tag
 )
 
 type StorageConfig struct {
 	RootDirectory string
+	DedupeMode    string        // hardlink, reflink or auto, the global one if not set
+	GCInterval    time.Duration // periodic GC of all repositories, disabled if not set
 	GC            bool
+	GCOnDelete    bool // remove the blobs of deleted manifests right away rather than on the next GC
 	Dedupe        bool
+	DirectIO      bool // serve blobs with O_DIRECT reads, bypassing the page cache
+	Encryption    *EncryptionConfig
+	DiskSpace     *DiskSpaceConfig // the global one if not set
+	Tiering       *TieringConfig
+	// removal of the tags past their TTL, every 10 minutes by default
+	TagExpiryInterval time.Duration
+	// tags past their TTL which were pulled within it, by tag or digest, are kept along with their image
+	RetainPulledWithin time.Duration
+}
+
+// TieringConfig moves the layers which weren't pulled within ColdAfter, e.g. 720h for 30 days, to
+// ColdDirectory, usually on a cheaper filesystem or a mounted object storage bucket. They stay readable
+// through symlinks and are moved back on their next pull. Tiering runs every Interval, 24 hours by
+// default. Each storage path needs its own ColdDirectory.
+type TieringConfig struct {
+	ColdDirectory string
+	ColdAfter     time.Duration
+	Interval      time.Duration
+}
+
+// IndexPolicyConfig checks the indexes pushed to all the storage paths: indexes may be nested up to MaxDepth,
+// 4 by default, an index of image manifests being of depth 1. The manifests an index references must be
+// pushed to its repository first, unless Sparse is set, e.g. for mirrors which only keep some platforms of
+// multi-arch images. Indexes referencing manifests by URL, outside of their repository, are refused.
+type IndexPolicyConfig struct {
+	MaxDepth int
+	Sparse   bool
+}
+
+// BackupConfig takes snapshots of the storage paths into Directory, each one in a directory of its own
+// named after its route, "_default" for the default one. They are taken on demand through the admin API,
+// and every Interval, starting at startup, if set. Scheduled snapshots are incremental, they only copy the
+// blobs which weren't backed up yet.
+type BackupConfig struct {
+	Directory string
+	Interval  time.Duration
+}
+
+// storeDirectory returns the backup directory of the storage path on route.
+func (b *BackupConfig) storeDirectory(route string) string {
+	if route == "/" {
+		return path.Join(b.Directory, "_default")
+	}
+
+	return path.Join(b.Directory, route)
+}
+
+// ChangefeedConfig keeps a log of the pushes, deletes and tag moves of all the storage paths, served at
+// /v2/_zot/ext/changefeed, for Retention, 7 days by default.
+type ChangefeedConfig struct {
+	Retention time.Duration
+}
+
+// DiskSpaceConfig refuses new blob uploads with 507 Insufficient Storage once the free space of the
+// filesystem of a storage path falls below MinFreeBytes, or MinFreePercent of its size. The free space
+// is checked every CheckInterval, 1 minute by default. With EmergencyGC, all the repositories of the
+// storage path are garbage collected as soon as the free space falls below the threshold.
+type DiskSpaceConfig struct {
+	MinFreeBytes   uint64
+	MinFreePercent float64
+	CheckInterval  time.Duration
+	EmergencyGC    bool
+}
+
+// EncryptionConfig enables AES-GCM encryption at rest of blob contents, manifests and image configs
+// are kept in plaintext. The 32 bytes key is read from KeyFile, or from the output of KeyCommand,
+// e.g. a KMS client decrypting a wrapped key.
+type EncryptionConfig struct {
+	KeyFile    string
+	KeyCommand []string
 }
 
 type TLSConfig struct {
 	Cert   string
 	Key    string
 	CACert string
+	Policy *TLSPolicyConfig
+	ACME   *ACMEConfig // obtains and renews the certificate instead of Cert and Key
+}
+
+// enabled returns true if a certificate is configured or obtained via ACME.
+func (t *TLSConfig) enabled() bool {
+	return t != nil && (t.ACME != nil || (t.Cert != "" && t.Key != ""))
+}
+
+// ACMEConfig obtains and renews the server certificate from an ACME CA, Let's Encrypt by default.
+// TLS-ALPN-01 challenges are answered on the TLS listeners, HTTP-01 challenges on HTTPChallengeAddress
+// if set, e.g. ":80". Certificates and the account key are stored in CacheDir, .acme under the storage
+// root by default.
+type ACMEConfig struct {
+	Domains              []string
+	Email                string
+	DirectoryURL         string
+	CacheDir             string
+	HTTPChallengeAddress string
+	AcceptTOS            bool // the terms of service of the CA must be accepted
 }
 
 type AuthHTPasswd struct {
@@ -31,10 +133,14 @@ type AuthHTPasswd struct {
 }
 
 type AuthConfig struct {
-	FailDelay int
-	HTPasswd  AuthHTPasswd
-	LDAP      *LDAPConfig
-	Bearer    *BearerConfig
+	FailDelay  int
+	HTPasswd   AuthHTPasswd
+	LDAP       *LDAPConfig
+	Bearer     *BearerConfig
+	Webhook    *WebhookConfig
+	Presign    *PresignConfig
+	Session    *SessionConfig
+	AdminUsers []string // users allowed on admin and debug endpoints, along with those the webhook or tokens allow
 }
 
 type BearerConfig struct {
@@ -43,14 +149,88 @@ type BearerConfig struct {
 	Cert    string
 }
 
+// PresignConfig enables short-lived pre-signed URLs to pull or push a digest of a repository without credentials.
+type PresignConfig struct {
+	Secret string        // HMAC key of the URLs, a random one is generated at startup if empty
+	MaxTTL time.Duration // defaults to 1h
+}
+
+// SessionConfig lets browser clients log in once with the htpasswd or LDAP credentials of a user, and then
+// authenticate with a session cookie.
+type SessionConfig struct {
+	Secret string        // HMAC key of the cookies, a random one is generated at startup if empty
+	TTL    time.Duration // defaults to 12h
+}
+
+// WebhookConfig delegates authN/authZ of every request to an external HTTP endpoint.
+type WebhookConfig struct {
+	URL      string
+	Timeout  time.Duration // defaults to 5s
+	CacheTTL time.Duration // decisions are not cached if not set
+	FailOpen bool          // allow requests if the webhook can't be reached, except on admin endpoints
+}
+
+// CORSConfig restricts which browser origins may call the API, unset fields use defaults.
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	MaxAge           int
+	AllowCredentials bool
+}
+
+// ListenerConfig is an additional address the server listens on, each with its own TLS settings.
+// Socket is the path of a unix socket, used instead of Address and Port.
+type ListenerConfig struct {
+	Address string
+	Port    string
+	Socket  string
+	TLS     *TLSConfig
+}
+
 type HTTPConfig struct {
 	Address         string
 	Port            string
 	TLS             *TLSConfig
+	Listeners       []ListenerConfig // the Address/Port listener is disabled if Port is empty
 	Auth            *AuthConfig
+	CORS            *CORSConfig
 	Realm           string
 	AllowReadAccess bool `mapstructure:",omitempty"`
 	ReadOnly        bool `mapstructure:",omitempty"`
+	Debug           bool `mapstructure:",omitempty"` // enables pprof and /debug/storage endpoints
+	// anyone may use the admin and debug endpoints, e.g. on a registry only reachable by its admins
+	AllowAdminAccess bool `mapstructure:",omitempty"`
+	// serves the sockets passed by systemd socket activation with the TLS settings above,
+	// instead of listening on Address/Port
+	SocketActivation bool `mapstructure:",omitempty"`
+	// serves the search and admin gRPC services of pkg/rpc, authenticated like the REST API
+	GRPC *ListenerConfig
+	// CIDR allow and deny rules of the clients of each operation, checked before authN
+	Network *netpolicy.Config
+}
+
+// GetListeners returns all the addresses the server listens on, the Address/Port one first.
+func (h *HTTPConfig) GetListeners() []ListenerConfig {
+	listeners := make([]ListenerConfig, 0, len(h.Listeners)+1)
+
+	if !h.SocketActivation && (h.Port != "" || len(h.Listeners) == 0) {
+		listeners = append(listeners, ListenerConfig{Address: h.Address, Port: h.Port, TLS: h.TLS})
+	}
+
+	return append(listeners, h.Listeners...)
+}
+
+// mutualTLS returns true if any listener authenticates clients with certificates.
+func (h *HTTPConfig) mutualTLS() bool {
+	for _, l := range h.GetListeners() {
+		if l.TLS != nil && l.TLS.CACert != "" {
+			return true
+		}
+	}
+
+	return false
 }
 
 type LDAPConfig struct {
@@ -71,13 +251,103 @@ type LogConfig struct {
 	Level  string
 	Output string
 	Audit  string
+	// Access tunes the HTTP access log: headers, latency buckets and sampling
+	Access *log.AccessConfig
+}
+
+// TagPolicyRule describes which tags of a repository are immutable.
+type TagPolicyRule struct {
+	// Immutable holds regular expressions, a tag matching any of them can't be overwritten once pushed
+	Immutable []string
+	// Exclude holds regular expressions for tags which stay mutable even if they match Immutable
+	Exclude []string
+}
+
+// TagPolicyConfig is the global tag policy with optional per-repository overrides,
+// repositories are matched using path.Match patterns, e.g "dev/*".
+type TagPolicyConfig struct {
+	TagPolicyRule `mapstructure:",squash"`
+	Repositories  map[string]TagPolicyRule
+}
+
+// MediaTypeRule lists the manifest and config media types accepted in a repository, path.Match patterns
+// such as "application/vnd.cncf.helm.*", any media type if empty.
+type MediaTypeRule struct {
+	Manifests []string
+	Configs   []string
+}
+
+// SignatureTrustRule lists the certificates trusted to sign the images of a repository.
+type SignatureTrustRule struct {
+	// TrustStore holds PEM files with the trusted root certificates
+	TrustStore []string
+}
+
+// SignaturePolicyConfig enables verification of notation signatures on pull, for the repositories
+// having a trust store, repositories are matched using path.Match patterns, e.g "prod/*".
+type SignaturePolicyConfig struct {
+	SignatureTrustRule `mapstructure:",squash"`
+	// Mode is either "warn" (default), which only logs unverified pulls, or "enforce", which denies them
+	Mode         string
+	Repositories map[string]SignatureTrustRule
+}
+
+// ProvenanceRule lists the builders trusted to build the images of a repository.
+type ProvenanceRule struct {
+	// Builders holds the ids of the trusted builders, those ending with "*" match the ids starting with the rest
+	Builders []string
+	// Keys holds PEM public keys, one of which must sign the attestations in a DSSE envelope if set
+	Keys []string
+}
+
+// ProvenancePolicyConfig requires a SLSA provenance attestation from a trusted builder to pull the images
+// of the repositories having builders, repositories are matched using path.Match patterns, e.g "prod/*".
+type ProvenancePolicyConfig struct {
+	ProvenanceRule `mapstructure:",squash"`
+	// Mode is either "warn" (default), which only logs unverified pulls, or "enforce", which denies them
+	Mode         string
+	Repositories map[string]ProvenanceRule
+}
+
+// TenantConfig isolates the repositories under a route prefix, e.g. "acme" for "acme/app", in an image
+// store of their own, usually with its own encryption key. Only the members of the tenant and the admin
+// users access them, and their total size is capped to Quota bytes, none if 0. Members and quota can be
+// changed at runtime through the admin API.
+type TenantConfig struct {
+	StorageConfig `mapstructure:",squash"`
+	Members       []string
+	Quota         int64
 }
 
 type GlobalStorageConfig struct {
 	RootDirectory string
+	DedupeMode    string // hardlink by default, reflink or auto
 	Dedupe        bool
 	GC            bool
+	GCOnDelete    bool
+	DirectIO      bool
+	GCInterval    time.Duration // periodic GC of all repositories, disabled if not set
 	SubPaths      map[string]StorageConfig
+	Tenants       map[string]TenantConfig
+	Encryption    *EncryptionConfig
+	DiskSpace     *DiskSpaceConfig
+	Tiering       *TieringConfig
+	Backup        *BackupConfig
+	Changefeed    *ChangefeedConfig
+	TagPolicy     *TagPolicyConfig
+	IndexPolicy   *IndexPolicyConfig
+	Signatures    *SignaturePolicyConfig
+	Provenance    *ProvenancePolicyConfig
+	// media types accepted in the repositories under each prefix, e.g. "prod/", the longest matching one
+	// applies, repositories under none accept any media type
+	MediaTypes map[string]MediaTypeRule
+	// dedupe and encryption at rest of the repositories under each prefix, e.g. "secret/", the longest matching
+	// one applies, repositories under none follow their storage path
+	RepoRules map[string]storage.RepoRule
+	// removal of the tags past their TTL, every 10 minutes by default
+	TagExpiryInterval time.Duration
+	// tags past their TTL which were pulled within it, by tag or digest, are kept along with their image
+	RetainPulledWithin time.Duration
 }
 
 type Config struct {
@@ -88,6 +358,9 @@ type Config struct {
 	HTTP       HTTPConfig
 	Log        *LogConfig
 	Extensions *ext.ExtensionConfig
+	Scheduler  *scheduler.Config
+	// LowMemory runs zot with as little memory as it can, e.g. as a local image cache on ARM devices
+	LowMemory bool
 }
 
 func NewConfig() *Config {
@@ -103,12 +376,20 @@ func NewConfig() *Config {
 
 // Sanitize makes a sanitized copy of the config removing any secrets.
 func (c *Config) Sanitize() *Config {
-	if c.HTTP.Auth != nil && c.HTTP.Auth.LDAP != nil && c.HTTP.Auth.LDAP.BindPassword != "" {
-		s := &Config{}
-		if err := deepcopy.Copy(s, c); err != nil {
-			panic(err)
-		}
+	ldapPassword := c.HTTP.Auth != nil && c.HTTP.Auth.LDAP != nil && c.HTTP.Auth.LDAP.BindPassword != ""
+	syncPasswords := c.Extensions != nil && c.Extensions.Sync != nil && len(c.Extensions.Sync.Registries) > 0
+	presignSecret := c.HTTP.Auth != nil && c.HTTP.Auth.Presign != nil && c.HTTP.Auth.Presign.Secret != ""
 
+	if !ldapPassword && !syncPasswords && !presignSecret {
+		return c
+	}
+
+	s := &Config{}
+	if err := deepcopy.Copy(s, c); err != nil {
+		panic(err)
+	}
+
+	if ldapPassword {
 		s.HTTP.Auth.LDAP = &LDAPConfig{}
 
 		if err := deepcopy.Copy(s.HTTP.Auth.LDAP, c.HTTP.Auth.LDAP); err != nil {
@@ -116,11 +397,21 @@ func (c *Config) Sanitize() *Config {
 		}
 
 		s.HTTP.Auth.LDAP.BindPassword = "******"
+	}
+
+	if presignSecret {
+		s.HTTP.Auth.Presign = &PresignConfig{MaxTTL: c.HTTP.Auth.Presign.MaxTTL, Secret: "******"}
+	}
 
-		return s
+	if syncPasswords {
+		for i := range s.Extensions.Sync.Registries {
+			if s.Extensions.Sync.Registries[i].Password != "" {
+				s.Extensions.Sync.Registries[i].Password = "******"
+			}
+		}
 	}
 
-	return c
+	return s
 }
 
 func (c *Config) Validate(log log.Logger) error {
@@ -133,5 +424,279 @@ func (c *Config) Validate(log log.Logger) error {
 		}
 	}
 
+	// auth webhook configuration
+	if c.HTTP.Auth != nil && c.HTTP.Auth.Webhook != nil {
+		if u, err := url.Parse(c.HTTP.Auth.Webhook.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			log.Error().Str("url", c.HTTP.Auth.Webhook.URL).Msg("invalid auth webhook configuration")
+			return errors.ErrBadConfig
+		}
+	}
+
+	// sessions are issued to the users of HTTP Basic authN
+	if a := c.HTTP.Auth; a != nil && a.Session != nil &&
+		((a.HTPasswd.Path == "" && a.LDAP == nil) || a.Bearer != nil || a.Webhook != nil || a.Session.TTL < 0) {
+		log.Error().Dur("ttl", a.Session.TTL).
+			Msg("invalid session configuration, enable htpasswd or LDAP authN, without bearer or webhook authN")
+
+		return errors.ErrBadConfig
+	}
+
+	// session cookies are only sent over TLS
+	if a := c.HTTP.Auth; a != nil && a.Session != nil {
+		if c.HTTP.SocketActivation && !c.HTTP.TLS.enabled() {
+			log.Error().Msg("invalid session configuration, enable TLS on the activated sockets")
+			return errors.ErrBadConfig
+		}
+
+		for _, l := range c.HTTP.GetListeners() {
+			if !l.TLS.enabled() {
+				log.Error().Str("address", l.Address).Str("port", l.Port).Str("socket", l.Socket).
+					Msg("invalid session configuration, enable TLS on all listeners")
+
+				return errors.ErrBadConfig
+			}
+		}
+	}
+
+	listeners := c.HTTP.GetListeners()
+	if c.HTTP.GRPC != nil {
+		listeners = append(listeners, *c.HTTP.GRPC)
+	}
+
+	// listeners and their TLS versions and cipher suites
+	for _, l := range listeners {
+		if (l.Socket == "") == (l.Port == "") {
+			log.Error().Str("address", l.Address).Str("port", l.Port).Str("socket", l.Socket).
+				Msg("invalid listener configuration, set either port or socket")
+
+			return errors.ErrBadConfig
+		}
+
+		if l.TLS == nil {
+			continue
+		}
+
+		if a := l.TLS.ACME; a != nil && (len(a.Domains) == 0 || !a.AcceptTOS || l.TLS.Cert != "" || l.TLS.Key != "") {
+			log.Error().Strs("domains", a.Domains).Bool("acceptTOS", a.AcceptTOS).
+				Msg("invalid ACME configuration, set domains and acceptTOS, without cert and key")
+
+			return errors.ErrBadConfig
+		}
+
+		if err := ApplyTLSPolicy(&tls.Config{}, l.TLS.Policy); err != nil {
+			log.Error().Err(err).Msg("invalid TLS policy configuration")
+			return errors.ErrBadConfig
+		}
+	}
+
+	// tag immutability rules
+	if c.Storage.TagPolicy != nil {
+		if _, err := NewTagPolicy(c.Storage.TagPolicy); err != nil {
+			log.Error().Err(err).Msg("invalid tag policy configuration")
+			return errors.ErrBadConfig
+		}
+	}
+
+	if c.Storage.IndexPolicy != nil && c.Storage.IndexPolicy.MaxDepth < 0 {
+		log.Error().Int("maxDepth", c.Storage.IndexPolicy.MaxDepth).Msg("invalid index policy configuration")
+		return errors.ErrBadConfig
+	}
+
+	if c.Storage.MediaTypes != nil {
+		if _, err := NewMediaTypePolicy(c.Storage.MediaTypes); err != nil {
+			log.Error().Err(err).Msg("invalid media types configuration")
+			return errors.ErrBadConfig
+		}
+	}
+
+	if err := c.validateTenants(log); err != nil {
+		return err
+	}
+
+	if err := c.validateStoragePaths(log); err != nil {
+		return err
+	}
+
+	if c.Log != nil && c.Log.Access != nil {
+		if err := c.Log.Access.Validate(); err != nil {
+			log.Error().Float64("sampleRate", c.Log.Access.SampleRate).
+				Msg("invalid access log configuration, set a sample rate within [0, 1] and ascending latency buckets")
+
+			return err
+		}
+	}
+
+	if b := c.Storage.Backup; b != nil && (b.Directory == "" || b.Interval < 0) {
+		log.Error().Str("directory", b.Directory).Dur("interval", b.Interval).Msg("invalid backup configuration")
+		return errors.ErrBadConfig
+	}
+
+	return c.validateVerificationPolicies(log)
+}
+
+// validateVerificationPolicies checks the signature and provenance verification policies.
+func (c *Config) validateVerificationPolicies(log log.Logger) error {
+	if c.Storage.Signatures != nil {
+		if _, err := NewSignaturePolicy(c.Storage.Signatures); err != nil {
+			log.Error().Err(err).Msg("invalid signature policy configuration")
+			return errors.ErrBadConfig
+		}
+	}
+
+	if c.Storage.Provenance != nil {
+		if _, err := NewProvenancePolicy(c.Storage.Provenance); err != nil {
+			log.Error().Err(err).Msg("invalid provenance policy configuration")
+			return errors.ErrBadConfig
+		}
+	}
+
+	return nil
+}
+
+// validateStoragePaths checks the configs of each storage path.
+func (c *Config) validateStoragePaths(log log.Logger) error {
+	if err := c.validateEncryption(log); err != nil {
+		return err
+	}
+
+	if err := c.validateDiskSpace(log); err != nil {
+		return err
+	}
+
+	if err := c.validateTiering(log); err != nil {
+		return err
+	}
+
+	return c.validateDedupeMode(log)
+}
+
+// validateEncryption checks that the blob encryption key of each storage path has exactly one source.
+func (c *Config) validateEncryption(log log.Logger) error {
+	encryption := map[string]*EncryptionConfig{c.Storage.RootDirectory: c.Storage.Encryption}
+
+	for _, storageConfig := range c.subPaths() {
+		encryption[storageConfig.RootDirectory] = storageConfig.Encryption
+	}
+
+	for rootDir, e := range encryption {
+		if e != nil && (e.KeyFile == "") == (len(e.KeyCommand) == 0) {
+			log.Error().Str("rootDir", rootDir).Msg("invalid encryption configuration, set either keyFile or keyCommand")
+			return errors.ErrBadConfig
+		}
+	}
+
+	return nil
+}
+
+// validateDedupeMode checks the dedupe mode of each storage path.
+func (c *Config) validateDedupeMode(log log.Logger) error {
+	modes := map[string]string{c.Storage.RootDirectory: c.Storage.DedupeMode}
+
+	for _, storageConfig := range c.subPaths() {
+		modes[storageConfig.RootDirectory] = storageConfig.DedupeMode
+	}
+
+	for rootDir, mode := range modes {
+		switch mode {
+		case "", storage.DedupeHardLink, storage.DedupeReflink, storage.DedupeAuto:
+		default:
+			log.Error().Str("rootDir", rootDir).Str("dedupeMode", mode).
+				Msg("invalid dedupe mode, set hardlink, reflink or auto")
+
+			return errors.ErrBadConfig
+		}
+	}
+
+	return nil
+}
+
+// validateTiering checks that each storage path has its own cold directory.
+func (c *Config) validateTiering(log log.Logger) error {
+	tiering := map[string]*TieringConfig{c.Storage.RootDirectory: c.Storage.Tiering}
+
+	for _, storageConfig := range c.subPaths() {
+		tiering[storageConfig.RootDirectory] = storageConfig.Tiering
+	}
+
+	coldDirs := make(map[string]bool)
+
+	for rootDir, t := range tiering {
+		if t == nil {
+			continue
+		}
+
+		coldDir := path.Clean(t.ColdDirectory)
+
+		if t.ColdDirectory == "" || t.ColdAfter <= 0 || t.Interval < 0 || coldDirs[coldDir] ||
+			tiering[coldDir] != nil || coldDir == path.Clean(rootDir) {
+			log.Error().Str("rootDir", rootDir).Str("coldDirectory", t.ColdDirectory).Dur("coldAfter", t.ColdAfter).
+				Msg("invalid tiering configuration, set a cold directory of its own and coldAfter")
+
+			return errors.ErrBadConfig
+		}
+
+		coldDirs[coldDir] = true
+	}
+
+	return nil
+}
+
+// validateDiskSpace checks the free disk space thresholds of the storage paths.
+func (c *Config) validateDiskSpace(log log.Logger) error {
+	diskSpace := []*DiskSpaceConfig{c.Storage.DiskSpace}
+
+	for _, storageConfig := range c.subPaths() {
+		diskSpace = append(diskSpace, storageConfig.DiskSpace)
+	}
+
+	for _, d := range diskSpace {
+		if d != nil && (d.MinFreePercent < 0 || d.MinFreePercent >= 100 || d.CheckInterval < 0) {
+			log.Error().Float64("minFreePercent", d.MinFreePercent).Dur("checkInterval", d.CheckInterval).
+				Msg("invalid disk space configuration")
+
+			return errors.ErrBadConfig
+		}
+	}
+
+	return nil
+}
+
+// subPaths returns the storage configs of the routes, including the ones of the tenants.
+func (c *Config) subPaths() map[string]StorageConfig {
+	subPaths := make(map[string]StorageConfig, len(c.Storage.SubPaths)+len(c.Storage.Tenants))
+
+	for route, storageConfig := range c.Storage.SubPaths {
+		subPaths[route] = storageConfig
+	}
+
+	for name, tenantConfig := range c.Storage.Tenants {
+		subPaths["/"+name] = tenantConfig.StorageConfig
+	}
+
+	return subPaths
+}
+
+// validateTenants checks that each tenant is a single name component with a storage path of its own, and
+// that the authN scheme identifies their members by name.
+func (c *Config) validateTenants(log log.Logger) error {
+	if a := c.HTTP.Auth; len(c.Storage.Tenants) > 0 &&
+		(a == nil || (a.Webhook == nil && (a.Bearer != nil || (a.HTPasswd.Path == "" && a.LDAP == nil)))) {
+		log.Error().Msg("invalid tenant configuration, enable htpasswd, LDAP or webhook authN, without bearer authN")
+
+		return errors.ErrBadConfig
+	}
+
+	for name, tenantConfig := range c.Storage.Tenants {
+		_, isSubPath := c.Storage.SubPaths["/"+name]
+
+		if !tenantNameRegexp.MatchString(name) || isSubPath || tenantConfig.RootDirectory == "" ||
+			tenantConfig.Quota < 0 {
+			log.Error().Str("tenant", name).Str("rootDir", tenantConfig.RootDirectory).Int64("quota", tenantConfig.Quota).
+				Msg("invalid tenant configuration, set a route prefix of its own, a root directory and a quota of 0 or more")
+
+			return errors.ErrBadConfig
+		}
+	}
+
 	return nil
 }
@@ -1,33 +1,71 @@
 package api
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"path"
+	"sync/atomic"
 	"time"
 
 	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/changefeed"
 	ext "github.com/anuvu/zot/pkg/extensions"
+	"github.com/anuvu/zot/pkg/extensions/lint"
+	"github.com/anuvu/zot/pkg/extensions/pushscan"
+	"github.com/anuvu/zot/pkg/extensions/retag"
+	"github.com/anuvu/zot/pkg/extensions/sync"
 	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/netpolicy"
+	"github.com/anuvu/zot/pkg/scheduler"
 	"github.com/anuvu/zot/pkg/storage"
+	"github.com/anuvu/zot/pkg/tenant"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
 )
 
 const (
-	idleTimeout = 120 * time.Second
+	idleTimeout                   = 120 * time.Second
+	pullStatsFlushInterval        = time.Minute
+	defaultDiskSpaceCheckInterval = time.Minute
+	defaultTieringInterval        = 24 * time.Hour
+	defaultTagExpiryInterval      = 10 * time.Minute
+	changefeedPruneInterval       = time.Hour
 )
 
 type Controller struct {
-	Config          *Config
-	Router          *mux.Router
-	StoreController storage.StoreController
-	Log             log.Logger
-	Audit           *log.Logger
-	Server          *http.Server
+	Config             *Config
+	Router             *mux.Router
+	StoreController    storage.StoreController
+	Log                log.Logger
+	Audit              *log.Logger
+	Server             *http.Server
+	Scheduler          *scheduler.Scheduler
+	tagPolicy          atomic.Value // *TagPolicy, replaced through the admin resources
+	namespaces         *namespaces
+	resources          *resources
+	tenants            *tenant.Tenants
+	changefeed         *changefeed.Feed
+	presigner          *presigner
+	sessions           *sessionManager
+	netPolicy          *netpolicy.Policy
+	signaturePolicy    *SignaturePolicy
+	mediaTypePolicy    *MediaTypePolicy
+	provenancePolicy   *ProvenancePolicy
+	acmeManagers       map[*ACMEConfig]*autocert.Manager
+	certReloaders      []*certReloader
+	shutdownExtensions func()
+	replicator         *sync.Replicator
+	retagger           *retag.Retagger
+	pushScanner        *pushscan.PushScanner
+	linter             *lint.Linter
+	cveRawReport       func(ctx context.Context, repo, reference string) ([]byte, error)
+	cveUpdates         map[string]*scheduler.PeriodicTask
 }
 
 func NewController(config *Config) *Controller {
@@ -59,6 +97,436 @@ func DefaultHeaders() mux.MiddlewareFunc {
 	}
 }
 
+// RequestLoad keeps the scheduler informed of in-flight requests so that background
+// tasks can be held back under load.
+func RequestLoad(sch *scheduler.Scheduler) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sch.RequestStarted()
+			defer sch.RequestFinished()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// enablePeriodicGC schedules a GC pass over all repositories of the image store.
+func (c *Controller) enablePeriodicGC(imgStore *storage.ImageStore, gc bool, interval time.Duration) {
+	if !gc || interval == 0 {
+		return
+	}
+
+	c.Scheduler.SubmitPeriodicTask(storage.NewGCTask(imgStore), interval, scheduler.LowPriority)
+}
+
+// setIndexPolicy applies the index policy, if any, to the image store.
+func (c *Controller) setIndexPolicy(imgStore *storage.ImageStore) {
+	if policy := c.Config.Storage.IndexPolicy; policy != nil {
+		imgStore.SetIndexPolicy(policy.MaxDepth, policy.Sparse)
+	}
+}
+
+// setRepoRules applies the repository rules to the image store, migrating its blobs if the rules changed.
+func (c *Controller) setRepoRules(imgStore *storage.ImageStore) error {
+	if err := imgStore.SetRepoRules(c.Config.Storage.RepoRules); err != nil {
+		c.Log.Error().Err(err).Str("rootDir", imgStore.RootDir()).Msg("unable to apply repository rules")
+		return err
+	}
+
+	return nil
+}
+
+// enableTagExpiry periodically removes the tags of the image store which are past their TTL, unless they
+// were pulled recently, with the global settings if the ones of the storage path aren't set.
+func (c *Controller) enableTagExpiry(imgStore *storage.ImageStore, interval, retainPulledWithin time.Duration) {
+	if interval <= 0 {
+		interval = c.Config.Storage.TagExpiryInterval
+	}
+
+	if retainPulledWithin <= 0 {
+		retainPulledWithin = c.Config.Storage.RetainPulledWithin
+	}
+
+	imgStore.SetPullRetention(retainPulledWithin)
+
+	if interval <= 0 {
+		interval = defaultTagExpiryInterval
+	}
+
+	c.Scheduler.SubmitPeriodicTask(storage.NewTagExpiryTask(imgStore), interval, scheduler.LowPriority)
+}
+
+// enableTiering periodically moves the layers of the image store which weren't pulled recently to its cold tier.
+func (c *Controller) enableTiering(imgStore *storage.ImageStore, config *TieringConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	if err := imgStore.EnableTiering(config.ColdDirectory, config.ColdAfter); err != nil {
+		return err
+	}
+
+	interval := defaultTieringInterval
+	if config.Interval > 0 {
+		interval = config.Interval
+	}
+
+	c.Scheduler.SubmitPeriodicTask(storage.NewTieringTask(imgStore), interval, scheduler.LowPriority)
+
+	return nil
+}
+
+// enableDiskSpaceMonitor periodically checks the free disk space of the image store, new uploads are
+// refused below the configured threshold, and an emergency GC is run if enabled.
+func (c *Controller) enableDiskSpaceMonitor(imgStore *storage.ImageStore, config *DiskSpaceConfig) {
+	interval := defaultDiskSpaceCheckInterval
+
+	if config != nil {
+		if config.CheckInterval > 0 {
+			interval = config.CheckInterval
+		}
+
+		var onLow func()
+
+		if config.EmergencyGC {
+			onLow = func() {
+				c.Log.Warn().Str("rootDir", imgStore.RootDir()).Msg("low disk space, running emergency GC")
+
+				_ = c.Scheduler.SubmitTask(storage.NewGCTask(imgStore), scheduler.HighPriority)
+			}
+		}
+
+		imgStore.SetDiskSpaceThreshold(config.MinFreeBytes, config.MinFreePercent, onLow)
+	}
+
+	// readiness and metrics report the free space right away
+	_, _ = imgStore.CheckDiskSpace()
+
+	c.Scheduler.SubmitPeriodicTask(storage.NewDiskSpaceTask(imgStore), interval, scheduler.HighPriority)
+}
+
+// enableCVEUpdates records the CVE database update task of the image store on route, so that updates
+// can be triggered on demand, there's none if CVE scanning is disabled.
+func (c *Controller) enableCVEUpdates(route string, task *scheduler.PeriodicTask) {
+	if task == nil {
+		return
+	}
+
+	if c.cveUpdates == nil {
+		c.cveUpdates = make(map[string]*scheduler.PeriodicTask)
+	}
+
+	c.cveUpdates[route] = task
+}
+
+// isImmutableTag tells whether the tag policy forbids overwriting the tag of the repository.
+func (c *Controller) isImmutableTag(repo, tag string) bool {
+	tagPolicy := c.getTagPolicy()
+
+	return tagPolicy != nil && tagPolicy.IsImmutable(repo, tag)
+}
+
+// getTagPolicy returns the tag policy, nil if there is none.
+func (c *Controller) getTagPolicy() *TagPolicy {
+	tagPolicy, _ := c.tagPolicy.Load().(*TagPolicy)
+
+	return tagPolicy
+}
+
+// enablePullStats periodically saves the pull statistics of the image stores.
+func (c *Controller) enablePullStats() {
+	for _, imgStore := range c.imageStores() {
+		c.Scheduler.SubmitPeriodicTask(storage.NewPullStatsTask(imgStore), pullStatsFlushInterval, scheduler.LowPriority)
+	}
+}
+
+// enableBackups periodically takes incremental snapshots of the image stores, if configured with an interval.
+func (c *Controller) enableBackups() {
+	backup := c.Config.Storage.Backup
+	if backup == nil || backup.Interval <= 0 {
+		return
+	}
+
+	c.Scheduler.SubmitPeriodicTask(storage.NewBackupTask(c.StoreController.DefaultStore, backup.storeDirectory("/")),
+		backup.Interval, scheduler.LowPriority)
+
+	for route, imgStore := range c.StoreController.SubStore {
+		c.Scheduler.SubmitPeriodicTask(storage.NewBackupTask(imgStore, backup.storeDirectory(route)),
+			backup.Interval, scheduler.LowPriority)
+	}
+}
+
+// enableChangefeed records the changes of the repositories of all the image stores in the event log, if
+// configured, and periodically prunes the events past their retention.
+func (c *Controller) enableChangefeed() error {
+	if c.Config.Storage.Changefeed == nil {
+		return nil
+	}
+
+	feed, err := changefeed.NewFeed(c.Config.Storage.RootDirectory, c.Config.Storage.Changefeed.Retention, c.Log)
+	if err != nil {
+		return err
+	}
+
+	for _, imgStore := range c.imageStores() {
+		imgStore.SetChangeHook(feed.Record)
+	}
+
+	c.changefeed = feed
+
+	c.Scheduler.SubmitPeriodicTask(changefeed.NewPruneTask(feed), changefeedPruneInterval, scheduler.LowPriority)
+
+	return nil
+}
+
+// closeChangefeed closes the event log, on shutdown.
+func (c *Controller) closeChangefeed() {
+	if c.changefeed == nil {
+		return
+	}
+
+	if err := c.changefeed.Close(); err != nil {
+		c.Log.Error().Err(err).Msg("unable to close changefeed")
+	}
+}
+
+// flushPullStats saves the pull statistics of the image stores, on shutdown.
+func (c *Controller) flushPullStats() {
+	for _, imgStore := range c.imageStores() {
+		if err := imgStore.PullStats().Flush(); err != nil {
+			c.Log.Error().Err(err).Str("rootDir", imgStore.RootDir()).Msg("unable to save pull statistics")
+		}
+	}
+}
+
+// imageStores returns the default image store followed by the sub-path ones.
+func (c *Controller) imageStores() []*storage.ImageStore {
+	stores := make([]*storage.ImageStore, 0, 1+len(c.StoreController.SubStore))
+	stores = append(stores, c.StoreController.DefaultStore)
+
+	for _, imgStore := range c.StoreController.SubStore {
+		stores = append(stores, imgStore)
+	}
+
+	return stores
+}
+
+// checkDedupe returns whether the blobs of a storage path are deduped with reflinks, if its dedupe mode
+// allows them and its filesystem supports them, otherwise with hard links. Dedupe is disabled if its
+// filesystem doesn't support hard links either.
+func (c *Controller) checkDedupe(rootDir, mode string, dedupe *bool) bool {
+	if !*dedupe {
+		return false
+	}
+
+	if mode == storage.DedupeReflink || mode == storage.DedupeAuto {
+		if err := storage.ValidateReflink(rootDir); err == nil {
+			return true
+		} else if mode == storage.DedupeReflink {
+			c.Log.Warn().Err(err).Str("rootDir", rootDir).
+				Msg("storage root directory filesystem does not support reflinks, deduping with hard links")
+		}
+	}
+
+	if err := storage.ValidateHardLink(rootDir); err != nil {
+		c.Log.Warn().Str("rootDir", rootDir).
+			Msg("input storage root directory filesystem does not supports hardlinking, disabling dedupe functionality")
+
+		*dedupe = false
+	}
+
+	return false
+}
+
+// loadTenants registers the tenants with their image stores, if any are configured.
+func (c *Controller) loadTenants() error {
+	if len(c.Config.Storage.Tenants) == 0 {
+		return nil
+	}
+
+	tenants := tenant.NewTenants(c.Log)
+
+	for name, tenantConfig := range c.Config.Storage.Tenants {
+		t := tenant.Tenant{Name: name, Members: tenantConfig.Members, Quota: tenantConfig.Quota}
+
+		if err := tenants.Add(t, c.StoreController.SubStore["/"+name]); err != nil {
+			c.Log.Error().Err(err).Str("tenant", name).Msg("unable to load tenant")
+			return err
+		}
+	}
+
+	c.tenants = tenants
+
+	return nil
+}
+
+// stopExtensionsOnShutdown releases the resources of the extensions and stops their workers along with the server.
+func (c *Controller) stopExtensionsOnShutdown() {
+	if c.shutdownExtensions != nil {
+		c.Server.RegisterOnShutdown(c.shutdownExtensions)
+	}
+
+	if c.retagger != nil {
+		c.Server.RegisterOnShutdown(c.retagger.Stop)
+	}
+
+	if c.pushScanner != nil {
+		c.Server.RegisterOnShutdown(c.pushScanner.Stop)
+	}
+
+	if c.replicator != nil {
+		c.Server.RegisterOnShutdown(c.replicator.Stop)
+	}
+}
+
+// loadPolicies loads the tag immutability, media types, signature and provenance verification policies, if
+// configured, and the namespaces.
+func (c *Controller) loadPolicies() error {
+	if c.Config.Storage.TagPolicy != nil {
+		tagPolicy, err := NewTagPolicy(c.Config.Storage.TagPolicy)
+		if err != nil {
+			c.Log.Error().Err(err).Msg("unable to load tag policy")
+			return err
+		}
+
+		c.tagPolicy.Store(tagPolicy)
+	}
+
+	if c.Config.Storage.MediaTypes != nil {
+		mediaTypePolicy, err := NewMediaTypePolicy(c.Config.Storage.MediaTypes)
+		if err != nil {
+			c.Log.Error().Err(err).Msg("unable to load media types policy")
+			return err
+		}
+
+		c.mediaTypePolicy = mediaTypePolicy
+	}
+
+	if c.Config.Storage.Signatures != nil {
+		signaturePolicy, err := NewSignaturePolicy(c.Config.Storage.Signatures)
+		if err != nil {
+			c.Log.Error().Err(err).Msg("unable to load signature policy")
+			return err
+		}
+
+		c.signaturePolicy = signaturePolicy
+	}
+
+	if c.Config.Storage.Provenance != nil {
+		provenancePolicy, err := NewProvenancePolicy(c.Config.Storage.Provenance)
+		if err != nil {
+			c.Log.Error().Err(err).Msg("unable to load provenance policy")
+			return err
+		}
+
+		c.provenancePolicy = provenancePolicy
+	}
+
+	namespaces, err := loadNamespaces(path.Join(c.Config.Storage.RootDirectory, namespacesFile))
+	if err != nil {
+		c.Log.Error().Err(err).Msg("unable to load namespaces")
+		return err
+	}
+
+	c.namespaces = namespaces
+
+	if c.Config.HTTP.Auth != nil && c.Config.HTTP.Auth.Presign != nil {
+		presigner, err := newPresigner(c.Config.HTTP.Auth.Presign)
+		if err != nil {
+			c.Log.Error().Err(err).Msg("unable to generate pre-signed URLs secret")
+			return err
+		}
+
+		c.presigner = presigner
+	}
+
+	if c.Config.HTTP.Auth != nil && c.Config.HTTP.Auth.Session != nil {
+		sessions, err := newSessionManager(c.Config.HTTP.Auth.Session)
+		if err != nil {
+			c.Log.Error().Err(err).Msg("unable to generate sessions secret")
+			return err
+		}
+
+		c.sessions = sessions
+	}
+
+	if c.Config.HTTP.Network != nil {
+		netPolicy, err := netpolicy.NewPolicy(c.Config.HTTP.Network)
+		if err != nil {
+			c.Log.Error().Err(err).Msg("unable to load network policy")
+			return err
+		}
+
+		c.netPolicy = netPolicy
+	}
+
+	return nil
+}
+
+// configureTLS sets the TLS config of the server, with mutual authentication if a CA cert is given.
+func (c *Controller) configureTLS(server *http.Server, config *TLSConfig) error {
+	tlsConfig := &tls.Config{}
+
+	// TLS 1.2 or later, unless only a certificate is configured
+	if config.CACert != "" || config.Policy != nil || config.ACME != nil {
+		tlsConfig.PreferServerCipherSuites = true
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+
+	if config.CACert != "" {
+		clientAuth := tls.VerifyClientCertIfGiven
+		if (c.Config.HTTP.Auth == nil || c.Config.HTTP.Auth.HTPasswd.Path == "") && !c.Config.HTTP.AllowReadAccess {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+
+		caCert, err := ioutil.ReadFile(config.CACert)
+		if err != nil {
+			panic(err)
+		}
+
+		caCertPool := x509.NewCertPool()
+
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			panic(errors.ErrBadCACert)
+		}
+
+		tlsConfig.ClientAuth = clientAuth
+		tlsConfig.ClientCAs = caCertPool
+		tlsConfig.BuildNameToCertificate() // nolint: staticcheck
+	}
+
+	if err := ApplyTLSPolicy(tlsConfig, config.Policy); err != nil {
+		return err
+	}
+
+	http2 := true
+
+	// HTTP/2 refuses to start without one of its required cipher suites, fall back to HTTP/1.1
+	if len(tlsConfig.CipherSuites) > 0 && !hasHTTP2CipherSuite(tlsConfig.CipherSuites) {
+		c.Log.Warn().Msg("no HTTP/2 compatible cipher suite allowed by the TLS policy, disabling HTTP/2")
+
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		http2 = false
+	}
+
+	if config.ACME != nil {
+		c.configureACME(tlsConfig, config.ACME, http2)
+	} else {
+		reloader, err := newCertReloader(config.Cert, config.Key)
+		if err != nil {
+			c.Log.Error().Err(err).Str("cert", config.Cert).Msg("unable to load TLS certificate")
+			return err
+		}
+
+		tlsConfig.GetCertificate = reloader.GetCertificate
+		c.certReloaders = append(c.certReloaders, reloader)
+	}
+
+	server.TLSConfig = tlsConfig
+
+	return nil
+}
+
 func (c *Controller) Run() error {
 	// validate configuration
 	if err := c.Config.Validate(c.Log); err != nil {
@@ -66,12 +534,28 @@ func (c *Controller) Run() error {
 		return err
 	}
 
+	if err := c.loadPolicies(); err != nil {
+		return err
+	}
+
+	c.applyLowMemoryProfile()
+
+	if FIPSMode {
+		c.Log.Info().Msg("FIPS mode, only FIPS 140-2 approved TLS versions, cipher suites and curves are allowed")
+	}
+
 	// print the current configuration, but strip secrets
 	c.Log.Info().Interface("params", c.Config.Sanitize()).Msg("configuration settings")
 
+	c.Scheduler = scheduler.NewScheduler(c.Config.Scheduler, c.Log)
+
+	shutdownTracing := ext.EnableTracing(c.Config.Extensions, c.Log)
+
 	engine := mux.NewRouter()
-	engine.Use(DefaultHeaders(),
-		log.SessionLogger(c.Log),
+	engine.Use(log.RequestID(),
+		RequestLoad(c.Scheduler),
+		Tracing(),
+		log.SessionLogger(c.Log, c.Config.Log.Access),
 		handlers.RecoveryHandler(handlers.RecoveryLogger(c.Log),
 			handlers.PrintRecoveryStack(false)))
 
@@ -85,24 +569,45 @@ func (c *Controller) Run() error {
 	c.StoreController = storage.StoreController{}
 
 	if c.Config.Storage.RootDirectory != "" {
-		if c.Config.Storage.Dedupe {
-			err := storage.ValidateHardLink(c.Config.Storage.RootDirectory)
-			if err != nil {
-				c.Log.Warn().Msg("input storage root directory filesystem does not supports hardlinking," +
-					"disabling dedupe functionality")
-
-				c.Config.Storage.Dedupe = false
-			}
-		}
+		reflink := c.checkDedupe(c.Config.Storage.RootDirectory, c.Config.Storage.DedupeMode, &c.Config.Storage.Dedupe)
 
 		defaultStore := storage.NewImageStore(c.Config.Storage.RootDirectory,
 			c.Config.Storage.GC, c.Config.Storage.Dedupe, c.Log)
 
+		defaultStore.SetReflink(reflink)
+
+		if err := c.enableEncryption(defaultStore, c.Config.Storage.Encryption); err != nil {
+			return err
+		}
+
+		if err := c.enableTiering(defaultStore, c.Config.Storage.Tiering); err != nil {
+			return err
+		}
+
+		defaultStore.SetDirectIO(c.Config.Storage.DirectIO)
+
+		defaultStore.SetGCOnDelete(c.Config.Storage.GCOnDelete)
+
+		defaultStore.SetLowMemory(c.Config.LowMemory)
+
+		c.setIndexPolicy(defaultStore)
+
+		if err := c.setRepoRules(defaultStore); err != nil {
+			return err
+		}
+
 		c.StoreController.DefaultStore = defaultStore
 
+		c.enableDiskSpaceMonitor(defaultStore, c.Config.Storage.DiskSpace)
+
+		c.enablePeriodicGC(defaultStore, c.Config.Storage.GC, c.Config.Storage.GCInterval)
+
+		c.enableTagExpiry(defaultStore, c.Config.Storage.TagExpiryInterval, c.Config.Storage.RetainPulledWithin)
+
 		// Enable extensions if extension config is provided
 		if c.Config != nil && c.Config.Extensions != nil {
-			ext.EnableExtensions(c.Config.Extensions, c.Log, c.Config.Storage.RootDirectory)
+			c.enableCVEUpdates("/", ext.EnableExtensions(c.Config.Extensions, c.Log,
+				c.Config.Storage.RootDirectory, c.Scheduler))
 		}
 	} else {
 		// we can't proceed without global storage
@@ -111,82 +616,133 @@ func (c *Controller) Run() error {
 		return errors.ErrImgStoreNotFound
 	}
 
-	if c.Config.Storage.SubPaths != nil {
-		if len(c.Config.Storage.SubPaths) > 0 {
-			subPaths := c.Config.Storage.SubPaths
+	if subPaths := c.Config.subPaths(); len(subPaths) > 0 {
+		subImageStore := make(map[string]*storage.ImageStore)
+
+		// creating image store per subpaths
+		for route, storageConfig := range subPaths {
+			dedupeMode := storageConfig.DedupeMode
+			if dedupeMode == "" {
+				dedupeMode = c.Config.Storage.DedupeMode
+			}
 
-			subImageStore := make(map[string]*storage.ImageStore)
+			reflink := c.checkDedupe(storageConfig.RootDirectory, dedupeMode, &storageConfig.Dedupe)
 
-			// creating image store per subpaths
-			for route, storageConfig := range subPaths {
-				if storageConfig.Dedupe {
-					err := storage.ValidateHardLink(storageConfig.RootDirectory)
-					if err != nil {
-						c.Log.Warn().Msg("input storage root directory filesystem does not supports hardlinking, " +
-							"disabling dedupe functionality")
+			subImageStore[route] = storage.NewImageStore(storageConfig.RootDirectory,
+				storageConfig.GC, storageConfig.Dedupe, c.Log)
 
-						storageConfig.Dedupe = false
-					}
-				}
+			subImageStore[route].SetReflink(reflink)
 
-				subImageStore[route] = storage.NewImageStore(storageConfig.RootDirectory,
-					storageConfig.GC, storageConfig.Dedupe, c.Log)
+			if err := c.enableEncryption(subImageStore[route], storageConfig.Encryption); err != nil {
+				return err
+			}
 
-				// Enable extensions if extension config is provided
-				if c.Config != nil && c.Config.Extensions != nil {
-					ext.EnableExtensions(c.Config.Extensions, c.Log, storageConfig.RootDirectory)
-				}
+			if err := c.enableTiering(subImageStore[route], storageConfig.Tiering); err != nil {
+				return err
 			}
 
-			c.StoreController.SubStore = subImageStore
+			subImageStore[route].SetDirectIO(storageConfig.DirectIO)
+
+			subImageStore[route].SetGCOnDelete(storageConfig.GCOnDelete)
+
+			subImageStore[route].SetLowMemory(c.Config.LowMemory)
+
+			c.setIndexPolicy(subImageStore[route])
+
+			if err := c.setRepoRules(subImageStore[route]); err != nil {
+				return err
+			}
+
+			c.enablePeriodicGC(subImageStore[route], storageConfig.GC, storageConfig.GCInterval)
+
+			c.enableTagExpiry(subImageStore[route], storageConfig.TagExpiryInterval, storageConfig.RetainPulledWithin)
+
+			diskSpace := storageConfig.DiskSpace
+			if diskSpace == nil {
+				diskSpace = c.Config.Storage.DiskSpace
+			}
+
+			c.enableDiskSpaceMonitor(subImageStore[route], diskSpace)
+
+			// Enable extensions if extension config is provided
+			if c.Config != nil && c.Config.Extensions != nil {
+				c.enableCVEUpdates(route, ext.EnableExtensions(c.Config.Extensions, c.Log,
+					storageConfig.RootDirectory, c.Scheduler))
+			}
 		}
+
+		c.StoreController.SubStore = subImageStore
 	}
 
-	_ = NewRouteHandler(c)
+	// the tag policy is enforced by the image stores, it may be replaced at runtime
+	for _, imgStore := range c.imageStores() {
+		imgStore.SetImmutableTagCheck(c.isImmutableTag)
+	}
 
-	addr := fmt.Sprintf("%s:%s", c.Config.HTTP.Address, c.Config.HTTP.Port)
-	server := &http.Server{
-		Addr:        addr,
-		Handler:     c.Router,
-		IdleTimeout: idleTimeout,
+	if err := c.enableChangefeed(); err != nil {
+		return err
 	}
-	c.Server = server
 
-	// Create the listener
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
+	if err := c.loadTenants(); err != nil {
 		return err
 	}
 
-	if c.Config.HTTP.TLS != nil && c.Config.HTTP.TLS.Key != "" && c.Config.HTTP.TLS.Cert != "" {
-		if c.Config.HTTP.TLS.CACert != "" {
-			clientAuth := tls.VerifyClientCertIfGiven
-			if (c.Config.HTTP.Auth == nil || c.Config.HTTP.Auth.HTPasswd.Path == "") && !c.Config.HTTP.AllowReadAccess {
-				clientAuth = tls.RequireAndVerifyClientCert
-			}
+	c.enablePullStats()
 
-			caCert, err := ioutil.ReadFile(c.Config.HTTP.TLS.CACert)
-			if err != nil {
-				panic(err)
-			}
+	c.enableBackups()
 
-			caCertPool := x509.NewCertPool()
+	c.replicator = ext.EnableSync(c.Config.Extensions, c.StoreController, c.Log)
+	c.retagger = ext.EnableRetag(c.Config.Extensions, c.StoreController, c.isImmutableTag, c.Scheduler, c.Log)
+	c.pushScanner = ext.EnablePushScan(c.Config.Extensions, c.StoreController, c.Log)
+	c.linter = ext.EnableLint(c.Config.Extensions, c.Log)
+	c.cveRawReport = ext.EnableCVERawReport(c.Config.Extensions, c.StoreController, c.Log)
 
-			if !caCertPool.AppendCertsFromPEM(caCert) {
-				panic(errors.ErrBadCACert)
-			}
+	if err := c.enableResources(); err != nil {
+		return err
+	}
+
+	rh := NewRouteHandler(c)
+
+	listeners, err := c.listen()
+	if err != nil {
+		return err
+	}
+
+	var (
+		grpcServer   *grpc.Server
+		grpcListener net.Listener
+	)
 
-			server.TLSConfig = &tls.Config{
-				ClientAuth:               clientAuth,
-				ClientCAs:                caCertPool,
-				PreferServerCipherSuites: true,
-				MinVersion:               tls.VersionTLS12,
+	if c.Config.HTTP.GRPC != nil {
+		if grpcServer, grpcListener, err = c.listenGRPC(rh, *c.Config.HTTP.GRPC); err != nil {
+			for _, l := range listeners {
+				l.listener.Close()
 			}
-			server.TLSConfig.BuildNameToCertificate() // nolint: staticcheck
+
+			return err
 		}
+	}
+
+	// the first listener's server is the one shut down by callers, it stops the others
+	c.Server = listeners[0].server
+
+	// background tasks are stopped along with the server
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Server.RegisterOnShutdown(cancel)
+	c.Server.RegisterOnShutdown(shutdownTracing)
+	c.Server.RegisterOnShutdown(c.flushPullStats)
+	c.Server.RegisterOnShutdown(c.closeChangefeed)
 
-		return server.ServeTLS(l, c.Config.HTTP.TLS.Cert, c.Config.HTTP.TLS.Key)
+	c.stopExtensionsOnShutdown()
+
+	if grpcServer != nil {
+		c.serveGRPC(grpcServer, grpcListener)
 	}
 
-	return server.Serve(l)
+	go c.Scheduler.RunScheduler(ctx)
+
+	c.watchCertificates(ctx)
+	c.watchDiagnostics(ctx)
+
+	return c.serve(listeners)
 }
@@ -1,24 +1,41 @@
+//go:build extended
 // +build extended
 
 package api_test
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	goerrors "errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"os/exec"
 	"path"
 	"regexp"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -26,12 +43,32 @@ import (
 
 	"github.com/anuvu/zot/errors"
 	"github.com/anuvu/zot/pkg/api"
+	extconf "github.com/anuvu/zot/pkg/extensions"
+	"github.com/anuvu/zot/pkg/extensions/lint"
+	"github.com/anuvu/zot/pkg/extensions/sync"
+	"github.com/anuvu/zot/pkg/netpolicy"
+	"github.com/anuvu/zot/pkg/rpc"
+	"github.com/anuvu/zot/pkg/scheduler"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/anuvu/zot/pkg/tenant"
 	"github.com/chartmuseum/auth"
 	"github.com/mitchellh/mapstructure"
 	godigest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/phayes/freeport"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	vldap "github.com/nmcclain/ldap"
 	. "github.com/smartystreets/goconvey/convey"
@@ -2605,3 +2642,5462 @@ func TestHardLink(t *testing.T) {
 		So(c.Config.Storage.Dedupe, ShouldEqual, false)
 	})
 }
+
+func TestImmutableTags(t *testing.T) {
+	Convey("Immutable tags", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.Storage.TagPolicy = &api.TagPolicyConfig{
+			TagPolicyRule: api.TagPolicyRule{
+				Immutable: []string{`^v?[0-9]+\.[0-9]+\.[0-9]+$`, ".*"},
+				Exclude:   []string{"^latest$"},
+			},
+			Repositories: map[string]api.TagPolicyRule{
+				"dev/*": {},
+			},
+		}
+
+		c := api.NewController(config)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c.Config.Storage.RootDirectory = dir
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		pushManifest := func(repo, tag string, layerContent []byte) *resty.Response {
+			digest := godigest.FromBytes(layerContent)
+			resp, err := resty.R().SetQueryParam("digest", digest.String()).
+				SetHeader("Content-Type", "application/octet-stream").
+				SetBody(layerContent).Post(baseURL + "/v2/" + repo + "/blobs/uploads/")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 201)
+
+			m := ispec.Manifest{
+				Config: ispec.Descriptor{Digest: digest, Size: int64(len(layerContent))},
+				Layers: []ispec.Descriptor{
+					{MediaType: ispec.MediaTypeImageLayer, Digest: digest, Size: int64(len(layerContent))},
+				},
+			}
+			m.SchemaVersion = 2
+			content, err := json.Marshal(m)
+			So(err, ShouldBeNil)
+
+			resp, err = resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+				SetBody(content).Put(baseURL + "/v2/" + repo + "/manifests/" + tag)
+			So(err, ShouldBeNil)
+
+			return resp
+		}
+
+		// first push of an immutable tag is allowed, re-pushing the same content as well
+		So(pushManifest("prod/app", "1.0.0", []byte("layer-1")).StatusCode(), ShouldEqual, 201)
+		pushed := pushManifest("prod/app", "1.0.0", []byte("layer-1"))
+		So(pushed.StatusCode(), ShouldEqual, 201)
+
+		// moving the tag to a different manifest is rejected
+		resp := pushManifest("prod/app", "1.0.0", []byte("layer-2"))
+		So(resp.StatusCode(), ShouldEqual, 403)
+		So(string(resp.Body()), ShouldContainSubstring, "DENIED")
+		So(string(resp.Body()), ShouldContainSubstring, errors.ErrImmutableTag.Error())
+
+		// deleting the tag, or its digest, so that it's pushed again is rejected too
+		resp, err = resty.R().Delete(baseURL + "/v2/prod/app/manifests/1.0.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+		So(string(resp.Body()), ShouldContainSubstring, errors.ErrImmutableTag.Error())
+
+		resp, err = resty.R().Delete(baseURL + "/v2/prod/app/manifests/" + pushed.Header().Get(api.DistContentDigestKey))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		// excluded tags stay mutable
+		So(pushManifest("prod/app", "latest", []byte("layer-1")).StatusCode(), ShouldEqual, 201)
+		So(pushManifest("prod/app", "latest", []byte("layer-2")).StatusCode(), ShouldEqual, 201)
+
+		resp, err = resty.R().Delete(baseURL + "/v2/prod/app/manifests/latest")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+
+		// per-repository override without immutable rules
+		So(pushManifest("dev/app", "1.0.0", []byte("layer-1")).StatusCode(), ShouldEqual, 201)
+		So(pushManifest("dev/app", "1.0.0", []byte("layer-2")).StatusCode(), ShouldEqual, 201)
+	})
+
+	Convey("Invalid tag policy", t, func() {
+		config := api.NewConfig()
+		config.Storage.TagPolicy = &api.TagPolicyConfig{
+			TagPolicyRule: api.TagPolicyRule{Immutable: []string{"("}},
+		}
+
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+	})
+}
+
+func TestDigestAlgorithms(t *testing.T) {
+	Convey("Blobs and manifests are pushed and pulled by sha512 digests", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, nil)
+		defer stopServer(c)
+
+		layer := []byte("this is a sha512 layer")
+		layerDigest := godigest.SHA512.FromBytes(layer)
+
+		resp, err := resty.R().SetQueryParam("digest", layerDigest.String()).
+			SetHeader("Content-Type", "application/octet-stream").
+			SetBody(layer).Post(baseURL + "/v2/repo/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+		So(resp.Header().Get("Location"), ShouldEqual, "/v2/repo/blobs/"+layerDigest.String())
+
+		// a session finished with the digest of another algorithm
+		imageConfig := []byte(`{"architecture":"amd64","os":"linux"}`)
+		configDigest := godigest.SHA512.FromBytes(imageConfig)
+
+		resp, err = resty.R().Post(baseURL + "/v2/repo/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+		loc := resp.Header().Get("Location")
+
+		resp, err = resty.R().SetQueryParam("digest", configDigest.String()).
+			SetHeader("Content-Type", "application/octet-stream").SetBody(imageConfig).Put(baseURL + loc)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+
+		resp, err = resty.R().Head(baseURL + "/v2/repo/blobs/" + configDigest.String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get(api.DistContentDigestKey), ShouldEqual, configDigest.String())
+
+		resp, err = resty.R().Get(baseURL + "/v2/repo/blobs/" + godigest.FromBytes(imageConfig).String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = resty.R().SetQueryParam("digest", "md5:"+strings.Repeat("0", 32)).
+			SetHeader("Content-Type", "application/octet-stream").
+			SetBody(layer).Post(baseURL + "/v2/repo/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+		So(string(resp.Body()), ShouldContainSubstring, "DIGEST_INVALID")
+
+		m := ispec.Manifest{
+			Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: configDigest, Size: int64(len(imageConfig))},
+			Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: layerDigest, Size: int64(len(layer))}},
+		}
+		m.SchemaVersion = 2
+		content, err := json.Marshal(m)
+		So(err, ShouldBeNil)
+
+		manifestDigest := godigest.SHA512.FromBytes(content)
+
+		resp, err = resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+			SetBody(content).Put(baseURL + "/v2/repo/manifests/" + manifestDigest.String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+		So(resp.Header().Get(api.DistContentDigestKey), ShouldEqual, manifestDigest.String())
+
+		resp, err = resty.R().SetHeader("Accept", ispec.MediaTypeImageManifest).
+			Get(baseURL + "/v2/repo/manifests/" + manifestDigest.String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Body(), ShouldResemble, content)
+		So(resp.Header().Get(api.DistContentDigestKey), ShouldEqual, manifestDigest.String())
+
+		// manifests pushed by tag have the canonical sha256 digest
+		resp, err = resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+			SetBody(content).Put(baseURL + "/v2/repo/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+		So(resp.Header().Get(api.DistContentDigestKey), ShouldEqual, godigest.FromBytes(content).String())
+	})
+}
+
+func TestRepoRules(t *testing.T) {
+	Convey("Repositories exempt from dedupe don't share blobs", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		noDedupe := false
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Storage.RepoRules = map[string]storage.RepoRule{"secret/": {Dedupe: &noDedupe}}
+		})
+		defer stopServer(c)
+
+		content := []byte("this is a shared blob")
+		digest := pushTestBlob(baseURL, "public/a", content)
+
+		mount := func(repo string) int {
+			resp, err := resty.R().SetQueryParams(map[string]string{"mount": digest.String(), "from": "public/a"}).
+				Post(baseURL + "/v2/" + repo + "/blobs/uploads/")
+			So(err, ShouldBeNil)
+
+			return resp.StatusCode()
+		}
+
+		So(mount("public/b"), ShouldEqual, 201)
+		So(mount("secret/b"), ShouldEqual, 202)
+
+		pushTestBlob(baseURL, "secret/b", content)
+
+		public, err := os.Stat(path.Join(dir, "public/a/blobs/sha256", digest.Encoded()))
+		So(err, ShouldBeNil)
+
+		secret, err := os.Stat(path.Join(dir, "secret/b/blobs/sha256", digest.Encoded()))
+		So(err, ShouldBeNil)
+		So(os.SameFile(public, secret), ShouldBeFalse)
+	})
+}
+
+func TestIndexPolicy(t *testing.T) {
+	Convey("Pushed indexes are checked", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Storage.IndexPolicy = &api.IndexPolicyConfig{MaxDepth: 2}
+		})
+		defer stopServer(c)
+
+		content := pushTestImage(baseURL, "app", "1.0")
+		image := ispec.Descriptor{MediaType: ispec.MediaTypeImageManifest, Digest: godigest.FromBytes(content),
+			Size: int64(len(content))}
+
+		pushIndex := func(tag string, manifests ...ispec.Descriptor) (*resty.Response, ispec.Descriptor) {
+			index, err := json.Marshal(ispec.Index{Versioned: specs.Versioned{SchemaVersion: 2}, Manifests: manifests})
+			So(err, ShouldBeNil)
+
+			resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageIndex).
+				SetBody(index).Put(baseURL + "/v2/app/manifests/" + tag)
+			So(err, ShouldBeNil)
+
+			return resp, ispec.Descriptor{MediaType: ispec.MediaTypeImageIndex, Digest: godigest.FromBytes(index),
+				Size: int64(len(index))}
+		}
+
+		resp, first := pushIndex("first", image)
+		So(resp.StatusCode(), ShouldEqual, 201)
+
+		resp, second := pushIndex("second", first, image)
+		So(resp.StatusCode(), ShouldEqual, 201)
+
+		resp, _ = pushIndex("third", second)
+		So(resp.StatusCode(), ShouldEqual, 400)
+		So(string(resp.Body()), ShouldContainSubstring, "MANIFEST_INVALID")
+		So(string(resp.Body()), ShouldContainSubstring, errors.ErrIndexTooDeep.Error())
+
+		missing := ispec.Descriptor{MediaType: ispec.MediaTypeImageManifest, Digest: godigest.FromString("missing"),
+			Size: 7}
+
+		resp, _ = pushIndex("missing", image, missing)
+		So(resp.StatusCode(), ShouldEqual, 400)
+		So(string(resp.Body()), ShouldContainSubstring, "MANIFEST_BLOB_UNKNOWN")
+		So(string(resp.Body()), ShouldContainSubstring, missing.Digest.String())
+
+		foreign := image
+		foreign.URLs = []string{"https://example.com/v2/other/manifests/" + image.Digest.String()}
+
+		resp, _ = pushIndex("foreign", foreign)
+		So(resp.StatusCode(), ShouldEqual, 400)
+		So(string(resp.Body()), ShouldContainSubstring, "MANIFEST_INVALID")
+		So(string(resp.Body()), ShouldContainSubstring, errors.ErrForeignManifest.Error())
+	})
+
+	Convey("Sparse indexes may reference manifests which weren't pushed", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Storage.IndexPolicy = &api.IndexPolicyConfig{Sparse: true}
+		})
+		defer stopServer(c)
+
+		content := pushTestImage(baseURL, "app", "1.0")
+
+		index, err := json.Marshal(ispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			Manifests: []ispec.Descriptor{
+				{MediaType: ispec.MediaTypeImageManifest, Digest: godigest.FromBytes(content), Size: int64(len(content))},
+				{MediaType: ispec.MediaTypeImageManifest, Digest: godigest.FromString("arm64"), Size: 5},
+				{MediaType: ispec.MediaTypeImageIndex, Digest: godigest.FromString("index"), Size: 5},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageIndex).
+			SetBody(index).Put(baseURL + "/v2/app/manifests/latest")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+	})
+
+	Convey("Invalid index policy", t, func() {
+		config := api.NewConfig()
+		config.Storage.IndexPolicy = &api.IndexPolicyConfig{MaxDepth: -1}
+
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+	})
+}
+
+func TestSchedulerStatus(t *testing.T) {
+	Convey("Background tasks status", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.HTTP.AllowAdminAccess = true
+		config.Storage.GCInterval = 100 * time.Millisecond
+		config.Scheduler = &scheduler.Config{MaxConcurrentTasks: 3, HighLoadRequests: 10}
+
+		c := api.NewController(config)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		err = copyFiles("../../test/data", dir)
+		if err != nil {
+			panic(err)
+		}
+
+		c.Config.Storage.RootDirectory = dir
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		var status scheduler.Status
+
+		// periodic GC goes through the scheduler
+		for i := 0; i < 50; i++ {
+			resp, err := resty.R().Get(baseURL + "/v2/_zot/admin/scheduler")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+
+			err = json.Unmarshal(resp.Body(), &status)
+			So(err, ShouldBeNil)
+
+			if status.CompletedTasks > 0 {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		So(status.CompletedTasks, ShouldBeGreaterThan, 0)
+		So(status.FailedTasks, ShouldEqual, 0)
+		So(status.MaxConcurrentTasks, ShouldEqual, 3)
+		So(status.InFlightRequests, ShouldEqual, 1)
+		So(status.Paused, ShouldBeFalse)
+	})
+}
+
+func TestLowMemory(t *testing.T) {
+	Convey("Low memory profile", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		err = copyFiles("../../test/data", dir)
+		if err != nil {
+			panic(err)
+		}
+
+		// the profile lowers the GOGC of the whole process
+		defer debug.SetGCPercent(100)
+
+		search := &extconf.SearchConfig{Enable: true}
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.AllowAdminAccess = true
+			config.LowMemory = true
+			config.Extensions = &extconf.ExtensionConfig{Search: search}
+		})
+		defer stopServer(c)
+
+		// images are still served
+		resp, err := resty.R().Get(baseURL + "/v2/zot-test/manifests/0.0.1")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		// search is disabled, without changing the search config of the caller
+		resp, err = resty.R().Get(baseURL + "/query?query={ImageList(repo:\"zot-test\"){RepoName}}")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+		So(search.Enable, ShouldBeTrue)
+
+		// background tasks run one at a time
+		var status scheduler.Status
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/admin/scheduler")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		err = json.Unmarshal(resp.Body(), &status)
+		So(err, ShouldBeNil)
+		So(status.MaxConcurrentTasks, ShouldEqual, 1)
+	})
+}
+
+func TestCVERefresh(t *testing.T) {
+	Convey("CVE database updates are triggered by admins", t, func() {
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase) + "\n" +
+			getCredString("bob", "robert"))
+		defer os.Remove(htpasswdPath)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.AllowReadAccess = true
+			config.HTTP.Auth = &api.AuthConfig{
+				HTPasswd:   api.AuthHTPasswd{Path: htpasswdPath},
+				AdminUsers: []string{username},
+			}
+			config.Extensions = &extconf.ExtensionConfig{
+				Search: &extconf.SearchConfig{Enable: true, CVE: &extconf.CVEConfig{UpdateInterval: time.Hour}},
+			}
+		})
+		defer stopServer(c)
+
+		resp, err := resty.R().Post(baseURL + "/v2/_zot/ext/cve/refresh")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		resp, err = resty.R().SetBasicAuth("bob", "robert").Post(baseURL + "/v2/_zot/ext/cve/refresh")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = resty.R().SetBasicAuth(username, passphrase).Post(baseURL + "/v2/_zot/ext/cve/refresh")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+
+		var statuses map[string]scheduler.PeriodicStatus
+		err = json.Unmarshal(resp.Body(), &statuses)
+		So(err, ShouldBeNil)
+		So(statuses, ShouldContainKey, "/")
+
+		// the interval is raised to 2 hours, failed downloads are retried sooner
+		So(statuses["/"].NextRun, ShouldHappenBetween, time.Now(), time.Now().Add(2*time.Hour+12*time.Minute))
+	})
+
+	Convey("CVE database refresh without CVE scanning", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.AllowAdminAccess = true
+		})
+		defer stopServer(c)
+
+		resp, err := resty.R().Post(baseURL + "/v2/_zot/ext/cve/refresh")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+	})
+}
+
+func TestCVERawReport(t *testing.T) {
+	Convey("Raw CVE reports of images which can't be scanned", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Extensions = &extconf.ExtensionConfig{
+				Search: &extconf.SearchConfig{Enable: true, CVE: &extconf.CVEConfig{UpdateInterval: time.Hour}},
+			}
+		})
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		config, err := pushBlob(baseURL, "test", []byte("{}"))
+		So(err, ShouldBeNil)
+
+		layer, err := pushBlob(baseURL, "test", []byte("this is a layer"))
+		So(err, ShouldBeNil)
+
+		// trivy only scans compressed layers
+		manifest := ispec.Manifest{
+			Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: config, Size: 2},
+			Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: layer, Size: 15}},
+		}
+		manifest.SchemaVersion = 2
+		mb, err := json.Marshal(manifest)
+		So(err, ShouldBeNil)
+
+		resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).SetBody(mb).
+			Put(baseURL + "/v2/test/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+
+		resp, err = resty.R().SetQueryParam("reference", "1.0").Get(baseURL + "/v2/_zot/ext/cve/raw/test")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 415)
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/ext/cve/raw/test")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		resp, err = resty.R().SetQueryParam("reference", "2.0").Get(baseURL + "/v2/_zot/ext/cve/raw/test")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+		So(string(resp.Body()), ShouldContainSubstring, "MANIFEST_UNKNOWN")
+
+		resp, err = resty.R().SetQueryParam("reference", "1.0").Get(baseURL + "/v2/_zot/ext/cve/raw/unknown")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+		So(string(resp.Body()), ShouldContainSubstring, "NAME_UNKNOWN")
+
+		query := `{CVERawReportForImage(image:"test:1.0")}`
+		resp, err = resty.R().Get(baseURL + "/query?query=" + url.QueryEscape(query))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldContainSubstring, errors.ErrScanNotSupported.Error())
+
+		query = `{CVERawReportForImage(image:"test:2.0")}`
+		resp, err = resty.R().Get(baseURL + "/query?query=" + url.QueryEscape(query))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldContainSubstring, errors.ErrManifestNotFound.Error())
+	})
+
+	Convey("Raw CVE reports without CVE scanning", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, nil)
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		resp, err := resty.R().SetQueryParam("reference", "1.0").Get(baseURL + "/v2/_zot/ext/cve/raw/test")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+	})
+}
+
+func TestDebugEndpoints(t *testing.T) {
+	Convey("Debug endpoints are disabled by default", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+
+		c := api.NewController(config)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c.Config.Storage.RootDirectory = dir
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		resp, err := resty.R().Get(baseURL + "/debug/storage")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = resty.R().Get(baseURL + "/debug/pprof/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+	})
+
+	Convey("Debug endpoints are restricted to admin users", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase) + "\n" +
+			getCredString("bob", "robert"))
+		defer os.Remove(htpasswdPath)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.HTTP.Debug = true
+		config.HTTP.AllowReadAccess = true
+		config.HTTP.Auth = &api.AuthConfig{
+			HTPasswd:   api.AuthHTPasswd{Path: htpasswdPath},
+			AdminUsers: []string{username},
+		}
+
+		c := api.NewController(config)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c.Config.Storage.RootDirectory = dir
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		// anonymous read access doesn't extend to debug and admin endpoints
+		resp, err := resty.R().Get(baseURL + "/v2/_catalog")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = resty.R().Get(baseURL + "/debug/storage")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/admin/scheduler")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		// authenticated, but not an admin
+		resp, err = resty.R().SetBasicAuth("bob", "robert").Get(baseURL + "/debug/storage")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = resty.R().SetBasicAuth(username, passphrase).Get(baseURL + "/debug/storage")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var stats map[string]storage.StoreStats
+		err = json.Unmarshal(resp.Body(), &stats)
+		So(err, ShouldBeNil)
+		So(stats["/"].RootDir, ShouldEqual, dir)
+		So(stats["/"].Lock.ReadLocks, ShouldBeGreaterThan, 0)
+		So(stats["/"].Cache, ShouldNotBeNil)
+
+		resp, err = resty.R().SetBasicAuth(username, passphrase).Get(baseURL + "/debug/pprof/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = resty.R().SetBasicAuth(username, passphrase).Get(baseURL + "/debug/pprof/goroutine?debug=1")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldContainSubstring, "goroutine profile")
+
+		resp, err = resty.R().SetBasicAuth(username, passphrase).Get(baseURL + "/v2/_zot/admin/scheduler")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+	})
+	Convey("Admin endpoints are denied without authN, unless open to all", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.Debug = true
+		})
+		defer stopServer(c)
+
+		resp, err := resty.R().Get(baseURL + "/debug/storage")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/admin/scheduler")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		openDir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(openDir)
+
+		open, openURL := startController(openDir, func(config *api.Config) {
+			config.HTTP.AllowAdminAccess = true
+		})
+		defer stopServer(open)
+
+		resp, err = resty.R().Get(openURL + "/v2/_zot/admin/scheduler")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+	})
+
+	Convey("Authenticated users aren't admin users unless listed", t, func() {
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase))
+		defer os.Remove(htpasswdPath)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.Auth = &api.AuthConfig{HTPasswd: api.AuthHTPasswd{Path: htpasswdPath}}
+		})
+		defer stopServer(c)
+
+		resp, err := resty.R().SetBasicAuth(username, passphrase).Get(baseURL + "/v2/_zot/admin/scheduler")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+	})
+
+	Convey("Bearer tokens grant the admin action for admin endpoints", t, func() {
+		authTestServer := makeAuthTestServer()
+		defer authTestServer.Close()
+
+		u, err := url.Parse(authTestServer.URL)
+		So(err, ShouldBeNil)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.Auth = &api.AuthConfig{
+				Bearer: &api.BearerConfig{
+					Cert:    ServerCert,
+					Realm:   authTestServer.URL + "/auth/token",
+					Service: u.Host,
+				},
+			}
+		})
+		defer stopServer(c)
+
+		token := func(scope string) string {
+			var token accessTokenResponse
+
+			resp, err := resty.R().SetQueryParam("service", u.Host).SetQueryParam("scope", scope).
+				Get(authTestServer.URL + "/auth/token")
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(resp.Body(), &token), ShouldBeNil)
+
+			return "Bearer " + token.AccessToken
+		}
+
+		resp, err := resty.R().SetHeader("Authorization", token("repository::pull")).
+			Get(baseURL + "/v2/_zot/admin/scheduler")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = resty.R().SetHeader("Authorization", token("repository::pull,admin")).
+			Get(baseURL + "/v2/_zot/admin/scheduler")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+	})
+}
+
+func TestDiagnostics(t *testing.T) {
+	Convey("Diagnostics are dumped on demand and on SIGQUIT", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		logFile, err := ioutil.TempFile("", "zot-log")
+		So(err, ShouldBeNil)
+		defer os.Remove(logFile.Name())
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.AllowAdminAccess = true
+			config.Log.Output = logFile.Name()
+		})
+		defer stopServer(c)
+
+		resp, err := resty.R().Post(baseURL + "/v2/diag/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+
+		uuid := path.Base(resp.Header().Get("Location"))
+		So(uuid, ShouldNotBeEmpty)
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/admin/diagnostics")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var diag api.Diagnostics
+		err = json.Unmarshal(resp.Body(), &diag)
+		So(err, ShouldBeNil)
+		So(diag.Goroutines, ShouldBeGreaterThan, 0)
+		So(diag.Stacks, ShouldContainSubstring, "goroutine")
+		So(diag.Stores["/"].Stats.RootDir, ShouldEqual, dir)
+		So(diag.Stores["/"].Stats.Holders.Writer, ShouldBeEmpty)
+		So(len(diag.Stores["/"].Uploads), ShouldEqual, 1)
+		So(diag.Stores["/"].Uploads[0].Repo, ShouldEqual, "diag")
+		So(diag.Stores["/"].Uploads[0].UUID, ShouldEqual, uuid)
+
+		err = signalSelf(syscall.SIGQUIT)
+		So(err, ShouldBeNil)
+
+		dumps := 0
+
+		for i := 0; i < 50 && dumps < 2; i++ {
+			time.Sleep(100 * time.Millisecond)
+
+			buf, err := ioutil.ReadFile(logFile.Name())
+			So(err, ShouldBeNil)
+
+			dumps = strings.Count(string(buf), "diagnostics dump")
+		}
+
+		So(dumps, ShouldEqual, 2)
+
+		// the server keeps running
+		resp, err = resty.R().Get(baseURL + "/v2/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+	})
+}
+
+func TestTracing(t *testing.T) {
+	Convey("Requests and storage operations are traced", t, func() {
+		exporter := tracetest.NewInMemoryExporter()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+
+		defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+
+		c := api.NewController(config)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c.Config.Storage.RootDirectory = dir
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		exporter.Reset()
+
+		traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+		resp, err := resty.R().SetHeader("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01").
+			Get(baseURL + "/v2/zot-test/manifests/0.0.1")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		spans := exporter.GetSpans()
+		So(len(spans), ShouldEqual, 2)
+
+		// storage span ends first
+		So(spans[0].Name, ShouldEqual, "storage.GetImageManifest")
+		So(spans[0].StatusCode, ShouldEqual, codes.Error)
+		So(spans[1].Name, ShouldEqual, "GET /v2/{name:"+api.NameRegexp.String()+"}/manifests/{reference}")
+		So(spans[1].SpanKind, ShouldEqual, trace.SpanKindServer)
+		So(spans[1].SpanContext.TraceID().String(), ShouldEqual, traceID)
+		So(spans[0].Parent.SpanID(), ShouldEqual, spans[1].SpanContext.SpanID())
+		So(spans[1].Attributes, ShouldContain, attribute.Int("http.status_code", 404))
+	})
+}
+
+func TestCORS(t *testing.T) {
+	Convey("Permissive CORS headers are set by default", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+
+		c := api.NewController(config)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c.Config.Storage.RootDirectory = dir
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		resp, err := resty.R().SetHeader("Origin", "https://ui.example.com").Get(baseURL + "/v2/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+	})
+
+	Convey("Configured CORS policy is enforced", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase))
+		defer os.Remove(htpasswdPath)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.HTTP.Auth = &api.AuthConfig{
+			HTPasswd: api.AuthHTPasswd{Path: htpasswdPath},
+		}
+		config.HTTP.CORS = &api.CORSConfig{
+			AllowedOrigins:   []string{"https://ui.example.com"},
+			MaxAge:           600,
+			AllowCredentials: true,
+		}
+
+		c := api.NewController(config)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c.Config.Storage.RootDirectory = dir
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		// preflight requests are answered without credentials
+		resp, err := resty.R().SetHeader("Origin", "https://ui.example.com").
+			SetHeader("Access-Control-Request-Method", "DELETE").
+			SetHeader("Access-Control-Request-Headers", "Authorization").
+			Options(baseURL + "/v2/repo/manifests/latest")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://ui.example.com")
+		So(resp.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "DELETE")
+		So(resp.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Authorization")
+		So(resp.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
+		So(resp.Header().Get("Access-Control-Max-Age"), ShouldEqual, "600")
+
+		resp, err = resty.R().SetHeader("Origin", "https://ui.example.com").
+			SetHeader("Access-Control-Request-Method", "POST").
+			Options(baseURL + "/query")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://ui.example.com")
+
+		resp, err = resty.R().SetHeader("Origin", "https://ui.example.com").
+			SetBasicAuth(username, passphrase).Get(baseURL + "/v2/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://ui.example.com")
+		So(resp.Header().Get("Access-Control-Expose-Headers"), ShouldContainSubstring, api.DistContentDigestKey)
+
+		// other origins get no CORS headers
+		resp, err = resty.R().SetHeader("Origin", "https://evil.example.com").
+			SetBasicAuth(username, passphrase).Get(baseURL + "/v2/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+
+		resp, err = resty.R().SetHeader("Origin", "https://evil.example.com").
+			SetHeader("Access-Control-Request-Method", "DELETE").
+			Options(baseURL + "/v2/repo/manifests/latest")
+		So(err, ShouldBeNil)
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+	})
+}
+
+func TestOpenAPI(t *testing.T) {
+	Convey("OpenAPI document reflects the enabled routes", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+
+		Convey("Core routes only", func() {
+			c := api.NewController(config)
+
+			dir, err := ioutil.TempDir("", "oci-repo-test")
+			if err != nil {
+				panic(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c.Config.Storage.RootDirectory = dir
+
+			go func() {
+				// this blocks
+				if err := c.Run(); err != nil {
+					return
+				}
+			}()
+
+			// wait till ready
+			for {
+				_, err := resty.R().Get(baseURL)
+				if err == nil {
+					break
+				}
+
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			defer func() {
+				ctx := context.Background()
+				_ = c.Server.Shutdown(ctx)
+			}()
+
+			resp, err := resty.R().Get(baseURL + "/v2/_zot/ext/openapi.json")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+
+			var doc struct {
+				Swagger string                                       `json:"swagger"`
+				Host    string                                       `json:"host"`
+				Paths   map[string]map[string]map[string]interface{} `json:"paths"`
+			}
+			err = json.Unmarshal(resp.Body(), &doc)
+			So(err, ShouldBeNil)
+			So(doc.Swagger, ShouldEqual, "2.0")
+			So(doc.Host, ShouldEqual, "127.0.0.1:"+port)
+
+			So(doc.Paths, ShouldContainKey, "/v2/{name}/tags/list")
+			So(doc.Paths["/v2/{name}/tags/list"]["get"]["summary"], ShouldEqual, "List image tags")
+			So(doc.Paths, ShouldContainKey, "/v2/{name}/manifests/{reference}")
+			So(doc.Paths["/v2/{name}/manifests/{reference}"], ShouldContainKey, "put")
+			So(doc.Paths, ShouldContainKey, "/v2/_zot/admin/scheduler")
+			So(doc.Paths, ShouldContainKey, "/v2/_zot/ext/openapi.json")
+			So(doc.Paths, ShouldNotContainKey, "/debug/storage")
+			So(doc.Paths, ShouldNotContainKey, "/query")
+			So(doc.Paths, ShouldNotContainKey, "/ui/")
+		})
+
+		Convey("Extension and debug routes", func() {
+			config.HTTP.Debug = true
+			config.Extensions = &extconf.ExtensionConfig{
+				Search: &extconf.SearchConfig{Enable: true},
+				UI:     &extconf.UIConfig{Enable: true},
+			}
+
+			c := api.NewController(config)
+
+			dir, err := ioutil.TempDir("", "oci-repo-test")
+			if err != nil {
+				panic(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c.Config.Storage.RootDirectory = dir
+
+			go func() {
+				// this blocks
+				if err := c.Run(); err != nil {
+					return
+				}
+			}()
+
+			// wait till ready
+			for {
+				_, err := resty.R().Get(baseURL)
+				if err == nil {
+					break
+				}
+
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			defer func() {
+				ctx := context.Background()
+				_ = c.Server.Shutdown(ctx)
+			}()
+
+			resp, err := resty.R().Get(baseURL + "/v2/_zot/ext/openapi.json")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+
+			var doc struct {
+				Paths map[string]map[string]map[string]interface{} `json:"paths"`
+			}
+			err = json.Unmarshal(resp.Body(), &doc)
+			So(err, ShouldBeNil)
+
+			So(doc.Paths, ShouldContainKey, "/v2/{name}/tags/list")
+			So(doc.Paths, ShouldContainKey, "/debug/storage")
+			So(doc.Paths, ShouldContainKey, "/query")
+			So(doc.Paths["/query"], ShouldContainKey, "get")
+			So(doc.Paths["/query"], ShouldContainKey, "post")
+			So(doc.Paths["/query"]["post"]["summary"], ShouldEqual, "GraphQL search API")
+			So(doc.Paths, ShouldContainKey, "/ui/")
+		})
+	})
+}
+
+func TestExtensionsDiscovery(t *testing.T) {
+	Convey("Discovery of the enabled extensions", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		discover := func(baseURL string) api.ExtensionList {
+			resp, err := resty.R().Get(baseURL + "/v2/_oci/ext/discover")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+
+			var list api.ExtensionList
+			err = json.Unmarshal(resp.Body(), &list)
+			So(err, ShouldBeNil)
+
+			return list
+		}
+
+		Convey("No extension enabled", func() {
+			c, baseURL := startController(dir, nil)
+			defer stopServer(c)
+
+			list := discover(baseURL)
+			So(len(list.Extensions), ShouldEqual, 7)
+
+			for _, extension := range list.Extensions {
+				So(extension.Enabled, ShouldBeFalse)
+				So(extension.Version, ShouldNotBeEmpty)
+				So(extension.Endpoints, ShouldNotBeEmpty)
+			}
+		})
+
+		Convey("Search and metrics enabled", func() {
+			c, baseURL := startController(dir, func(config *api.Config) {
+				config.Extensions = &extconf.ExtensionConfig{
+					Search:  &extconf.SearchConfig{Enable: true},
+					Metrics: &extconf.MetricsConfig{Enable: true},
+				}
+			})
+			defer stopServer(c)
+
+			list := discover(baseURL)
+			So(list.Enabled(api.SearchExtension), ShouldBeTrue)
+			So(list.Enabled(api.MetricsExtension), ShouldBeTrue)
+			So(list.Enabled(api.CVEExtension), ShouldBeFalse)
+			So(list.Enabled(api.SyncExtension), ShouldBeFalse)
+			So(list.Enabled(api.UIExtension), ShouldBeFalse)
+			So(list.Enabled(api.LintExtension), ShouldBeFalse)
+			So(list.Enabled("_zot/unknown"), ShouldBeFalse)
+
+			for _, extension := range list.Extensions {
+				if extension.Name == api.SearchExtension {
+					So(extension.Endpoints, ShouldResemble, []string{"/query"})
+				}
+			}
+		})
+	})
+}
+
+func TestAuthWebhook(t *testing.T) {
+	Convey("Auth decisions delegated to a webhook", t, func() {
+		var calls int32
+
+		webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+
+			var req api.WebhookRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			resp := api.WebhookResponse{Reason: "unknown credentials"}
+			if req.Authorization == "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+passphrase)) {
+				// pull anything, push only to the user's own namespace
+				resp.Allowed = req.Action == "pull" || strings.HasPrefix(req.Repository, username+"/")
+				resp.Username = username
+			}
+
+			_ = json.NewEncoder(w).Encode(resp)
+		}))
+		defer webhook.Close()
+
+		baseURL, stop := startWebhookController(webhook.URL, false)
+		defer stop()
+
+		// without creds, should get access error
+		resp, err := resty.R().Get(baseURL + "/v2/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+		So(resp.Header().Get("WWW-Authenticate"), ShouldNotBeEmpty)
+
+		// with bad creds, should be denied
+		resp, err = resty.R().SetBasicAuth(username, "bad").Get(baseURL + "/v2/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = resty.R().SetBasicAuth(username, passphrase).Get(baseURL + "/v2/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		// decisions are cached
+		before := atomic.LoadInt32(&calls)
+		resp, err = resty.R().SetBasicAuth(username, passphrase).Get(baseURL + "/v2/_catalog")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(atomic.LoadInt32(&calls), ShouldEqual, before)
+
+		resp, err = resty.R().SetBasicAuth(username, passphrase).Post(baseURL + "/v2/" + username + "/repo/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+
+		resp, err = resty.R().SetBasicAuth(username, passphrase).Post(baseURL + "/v2/other/repo/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		Convey("Fail closed", func() {
+			webhook.Close()
+
+			// cached decisions are still honored
+			resp, err := resty.R().SetBasicAuth(username, passphrase).Get(baseURL + "/v2/")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+
+			resp, err = resty.R().SetBasicAuth(username, "other").Get(baseURL + "/v2/")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 503)
+		})
+
+		Convey("Fail open", func() {
+			webhook.Close()
+
+			baseURL, stop := startWebhookController(webhook.URL, true)
+			defer stop()
+
+			resp, err := resty.R().Get(baseURL + "/v2/")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+
+			// but not admin endpoints
+			resp, err = resty.R().Get(baseURL + "/v2/_zot/admin/scheduler")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 503)
+		})
+	})
+}
+
+func startWebhookController(webhookURL string, failOpen bool) (string, func()) {
+	port := getFreePort()
+	baseURL := getBaseURL(port, false)
+	config := api.NewConfig()
+	config.HTTP.Port = port
+	config.HTTP.Auth = &api.AuthConfig{
+		Webhook: &api.WebhookConfig{
+			URL:      webhookURL,
+			Timeout:  time.Second,
+			CacheTTL: time.Minute,
+			FailOpen: failOpen,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "oci-repo-test")
+	if err != nil {
+		panic(err)
+	}
+
+	config.Storage.RootDirectory = dir
+	c := api.NewController(config)
+
+	go func() {
+		// this blocks
+		if err := c.Run(); err != nil {
+			return
+		}
+	}()
+
+	// wait till ready
+	for {
+		_, err := resty.R().Get(baseURL)
+		if err == nil {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return baseURL, func() {
+		_ = c.Server.Shutdown(context.Background())
+
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSignatureVerification(t *testing.T) {
+	Convey("Notation signatures are verified on pull", t, func() {
+		rootCert, rootKey := newTestCA("trusted root")
+		leafCert, leafKey := newTestSigner(rootCert, rootKey)
+		untrustedCert, untrustedKey := newTestCA("untrusted root")
+		untrustedLeafCert, untrustedLeafKey := newTestSigner(untrustedCert, untrustedKey)
+
+		trustStore, err := ioutil.TempFile("", "truststore")
+		So(err, ShouldBeNil)
+		defer os.Remove(trustStore.Name())
+
+		err = pem.Encode(trustStore, &pem.Block{Type: "CERTIFICATE", Bytes: rootCert.Raw})
+		So(err, ShouldBeNil)
+		trustStore.Close()
+
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.Storage.Signatures = &api.SignaturePolicyConfig{
+			Mode: api.SignatureModeEnforce,
+			Repositories: map[string]api.SignatureTrustRule{
+				"prod/*": {TrustStore: []string{trustStore.Name()}},
+			},
+		}
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		config.Storage.RootDirectory = dir
+		c := api.NewController(config)
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		signed := pushTestImage(baseURL, "prod/app", "signed")
+		signatureDigest := pushTestSignature(baseURL, "prod/app", signed, leafKey, leafCert)
+
+		resp, err := resty.R().Get(baseURL + "/v2/prod/app/manifests/signed")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		// signatures themselves can be pulled
+		resp, err = resty.R().Get(baseURL + "/v2/prod/app/manifests/" + signatureDigest.String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		pushTestImage(baseURL, "prod/app", "unsigned")
+		resp, err = resty.R().Get(baseURL + "/v2/prod/app/manifests/unsigned")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		untrusted := pushTestImage(baseURL, "prod/app", "untrusted")
+		pushTestSignature(baseURL, "prod/app", untrusted, untrustedLeafKey, untrustedLeafCert)
+		resp, err = resty.R().Get(baseURL + "/v2/prod/app/manifests/untrusted")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		// repositories without a trust store are not verified
+		pushTestImage(baseURL, "dev/app", "unsigned")
+		resp, err = resty.R().Get(baseURL + "/v2/dev/app/manifests/unsigned")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+	})
+
+	Convey("Notation signature envelopes", t, func() {
+		rootCert, rootKey := newTestCA("trusted root")
+		leafCert, leafKey := newTestSigner(rootCert, rootKey)
+		roots := x509.NewCertPool()
+		roots.AddCert(rootCert)
+
+		manifest := []byte(`{"schemaVersion":2}`)
+		target := ispec.Descriptor{Digest: godigest.FromBytes(manifest), Size: int64(len(manifest))}
+		envelope := newTestEnvelope(target, leafKey, leafCert, nil)
+
+		policy, err := api.NewSignaturePolicy(&api.SignaturePolicyConfig{Mode: "bogus"})
+		So(err, ShouldNotBeNil)
+		So(policy, ShouldBeNil)
+
+		So(api.VerifyNotationSignature(envelope, target, roots, time.Now()), ShouldBeNil)
+
+		other := ispec.Descriptor{Digest: godigest.FromString("other"), Size: target.Size}
+		err = api.VerifyNotationSignature(envelope, other, roots, time.Now())
+		So(goerrors.Is(err, errors.ErrBadSignature), ShouldBeTrue)
+
+		expiry := time.Now().Add(-time.Hour)
+		expired := newTestEnvelope(target, leafKey, leafCert, &expiry)
+		err = api.VerifyNotationSignature(expired, target, roots, time.Now())
+		So(goerrors.Is(err, errors.ErrBadSignature), ShouldBeTrue)
+
+		tampered := bytes.Replace(envelope, []byte(`"signature":"`), []byte(`"signature":"AA`), 1)
+		err = api.VerifyNotationSignature(tampered, target, roots, time.Now())
+		So(goerrors.Is(err, errors.ErrBadSignature), ShouldBeTrue)
+
+		err = api.VerifyNotationSignature(envelope, target, x509.NewCertPool(), time.Now())
+		So(goerrors.Is(err, errors.ErrUntrustedSignature), ShouldBeTrue)
+	})
+}
+
+func TestLint(t *testing.T) {
+	Convey("Pushed images are linted", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		lintConfig := &lint.Config{
+			Enable:               true,
+			Repositories:         []string{"prod/*"},
+			MandatoryAnnotations: []string{ispec.AnnotationSource},
+			NonRootUser:          true,
+		}
+
+		startLintController := func() (*api.Controller, string) {
+			return startController(dir, func(config *api.Config) {
+				config.Extensions = &extconf.ExtensionConfig{Search: &extconf.SearchConfig{Enable: true},
+					Lint: lintConfig}
+			})
+		}
+
+		pushImage := func(baseURL, repo, tag string, annotations map[string]string, user string) *resty.Response {
+			layer := []byte("layer of " + repo + ":" + tag)
+			layerDigest := pushTestBlob(baseURL, repo, layer)
+			config, _ := json.Marshal(ispec.Image{Config: ispec.ImageConfig{User: user}})
+			configDigest := pushTestBlob(baseURL, repo, config)
+
+			m := ispec.Manifest{
+				Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: configDigest,
+					Size: int64(len(config))},
+				Layers: []ispec.Descriptor{
+					{MediaType: ispec.MediaTypeImageLayer, Digest: layerDigest, Size: int64(len(layer))},
+				},
+				Annotations: annotations,
+			}
+			m.SchemaVersion = 2
+			content, _ := json.Marshal(m)
+
+			resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+				SetBody(content).Put(baseURL + "/v2/" + repo + "/manifests/" + tag)
+			So(err, ShouldBeNil)
+
+			return resp
+		}
+
+		source := map[string]string{ispec.AnnotationSource: "https://github.com/anuvu/zot"}
+
+		Convey("Images breaking the rules are accepted and their violations recorded", func() {
+			c, baseURL := startLintController()
+			defer func() {
+				ctx := context.Background()
+				_ = c.Server.Shutdown(ctx)
+			}()
+
+			So(pushImage(baseURL, "prod/app", "passed", source, "1000").StatusCode(), ShouldEqual, 201)
+			So(pushImage(baseURL, "prod/app", "failed", nil, "root").StatusCode(), ShouldEqual, 201)
+			So(pushImage(baseURL, "dev/app", "unlinted", nil, "").StatusCode(), ShouldEqual, 201)
+
+			var list struct {
+				Data struct {
+					ImageList []struct {
+						Tag  string
+						Lint *struct {
+							Passed     bool
+							Violations []string
+						}
+					}
+				}
+			}
+
+			query := `{ImageList{Tag Lint{Passed Violations}}}`
+			resp, err := resty.R().Get(baseURL + "/query?query=" + url.QueryEscape(query))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+			So(json.Unmarshal(resp.Body(), &list), ShouldBeNil)
+			So(len(list.Data.ImageList), ShouldEqual, 3)
+
+			for _, image := range list.Data.ImageList {
+				switch image.Tag {
+				case "passed":
+					So(image.Lint, ShouldNotBeNil)
+					So(image.Lint.Passed, ShouldBeTrue)
+					So(image.Lint.Violations, ShouldBeEmpty)
+				case "failed":
+					So(image.Lint, ShouldNotBeNil)
+					So(image.Lint.Passed, ShouldBeFalse)
+					So(image.Lint.Violations, ShouldResemble, []string{
+						"missing annotation " + ispec.AnnotationSource, "runs as root"})
+				default:
+					So(image.Lint, ShouldBeNil)
+				}
+			}
+
+			resp, err = resty.R().Get(baseURL + "/v2/_oci/ext/discover")
+			So(err, ShouldBeNil)
+
+			var extensions api.ExtensionList
+			So(json.Unmarshal(resp.Body(), &extensions), ShouldBeNil)
+			So(extensions.Enabled(api.LintExtension), ShouldBeTrue)
+		})
+
+		Convey("Images breaking the rules are rejected when blocking", func() {
+			lintConfig.Block = true
+
+			c, baseURL := startLintController()
+			defer func() {
+				ctx := context.Background()
+				_ = c.Server.Shutdown(ctx)
+			}()
+
+			So(pushImage(baseURL, "prod/app", "passed", source, "app").StatusCode(), ShouldEqual, 201)
+
+			resp := pushImage(baseURL, "prod/app", "failed", source, "0")
+			So(resp.StatusCode(), ShouldEqual, 403)
+			So(string(resp.Body()), ShouldContainSubstring, "runs as root")
+
+			resp, err := resty.R().Get(baseURL + "/v2/prod/app/manifests/failed")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 404)
+
+			So(pushImage(baseURL, "dev/app", "unlinted", nil, "").StatusCode(), ShouldEqual, 201)
+		})
+	})
+}
+
+func TestProvenanceVerification(t *testing.T) {
+	Convey("SLSA provenance attestations are verified on pull", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+
+		keyFile, err := ioutil.TempFile("", "provenance-key")
+		So(err, ShouldBeNil)
+		defer os.Remove(keyFile.Name())
+
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		So(err, ShouldBeNil)
+		So(pem.Encode(keyFile, &pem.Block{Type: "PUBLIC KEY", Bytes: der}), ShouldBeNil)
+		keyFile.Close()
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Storage.Provenance = &api.ProvenancePolicyConfig{
+				Mode: api.ProvenanceModeEnforce,
+				Repositories: map[string]api.ProvenanceRule{
+					"prod/*": {Builders: []string{"https://ci.example.com/builder@*"}, Keys: []string{keyFile.Name()}},
+				},
+			}
+			config.Extensions = &extconf.ExtensionConfig{Search: &extconf.SearchConfig{Enable: true}}
+		})
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		attested := pushTestImage(baseURL, "prod/app", "attested")
+		statement := newTestStatement(attested, api.SLSAProvenanceV02, "https://ci.example.com/builder@v1")
+		attestation := pushTestAttestation(baseURL, "prod/app", attested, api.DSSEMediaType,
+			newTestDSSE(statement, key))
+
+		resp, err := resty.R().Get(baseURL + "/v2/prod/app/manifests/attested")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		// attestations themselves can be pulled
+		resp, err = resty.R().Get(baseURL + "/v2/prod/app/manifests/" + attestation.String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		pushTestImage(baseURL, "prod/app", "unattested")
+		resp, err = resty.R().Get(baseURL + "/v2/prod/app/manifests/unattested")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		untrusted := pushTestImage(baseURL, "prod/app", "untrusted")
+		statement = newTestStatement(untrusted, api.SLSAProvenanceV02, "https://evil.example.com/builder")
+		pushTestAttestation(baseURL, "prod/app", untrusted, api.DSSEMediaType, newTestDSSE(statement, key))
+		resp, err = resty.R().Get(baseURL + "/v2/prod/app/manifests/untrusted")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		// unsigned statements aren't trusted when keys are set
+		unsigned := pushTestImage(baseURL, "prod/app", "unsigned")
+		statement = newTestStatement(unsigned, api.SLSAProvenanceV02, "https://ci.example.com/builder@v1")
+		pushTestAttestation(baseURL, "prod/app", unsigned, api.InTotoMediaType, statement)
+		resp, err = resty.R().Get(baseURL + "/v2/prod/app/manifests/unsigned")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		// repositories without builders are not verified
+		pushTestImage(baseURL, "dev/app", "unattested")
+		resp, err = resty.R().Get(baseURL + "/v2/dev/app/manifests/unattested")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var list struct {
+			Data struct {
+				ImageList []struct {
+					Tag        string
+					Provenance *struct {
+						Valid         bool
+						Attestation   string
+						PredicateType string
+						Builder       string
+						Reason        string
+					}
+				}
+			}
+		}
+
+		query := `{ImageList(repo:"prod/app"){Tag Provenance{Valid Attestation PredicateType Builder Reason}}}`
+		resp, err = resty.R().Get(baseURL + "/query?query=" + url.QueryEscape(query))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &list), ShouldBeNil)
+		So(len(list.Data.ImageList), ShouldEqual, 4)
+
+		for _, image := range list.Data.ImageList {
+			So(image.Provenance, ShouldNotBeNil)
+
+			switch image.Tag {
+			case "attested":
+				So(image.Provenance.Valid, ShouldBeTrue)
+				So(image.Provenance.Attestation, ShouldEqual, attestation.String())
+				So(image.Provenance.PredicateType, ShouldEqual, api.SLSAProvenanceV02)
+				So(image.Provenance.Builder, ShouldEqual, "https://ci.example.com/builder@v1")
+			case "unattested":
+				So(image.Provenance.Valid, ShouldBeFalse)
+				So(image.Provenance.Reason, ShouldEqual, errors.ErrProvenanceNotFound.Error())
+			case "untrusted":
+				So(image.Provenance.Valid, ShouldBeFalse)
+				So(image.Provenance.Reason, ShouldContainSubstring, errors.ErrUntrustedBuilder.Error())
+			default:
+				So(image.Provenance.Valid, ShouldBeFalse)
+				So(image.Provenance.Reason, ShouldContainSubstring, errors.ErrBadAttestation.Error())
+			}
+		}
+	})
+
+	Convey("SLSA provenance statements", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+
+		manifest := []byte(`{"schemaVersion":2}`)
+		target := godigest.FromBytes(manifest)
+		builders := []string{"https://ci.example.com/builder"}
+		keys := []crypto.PublicKey{&key.PublicKey}
+
+		policy, err := api.NewProvenancePolicy(&api.ProvenancePolicyConfig{Mode: "bogus"})
+		So(err, ShouldNotBeNil)
+		So(policy, ShouldBeNil)
+
+		policy, err = api.NewProvenancePolicy(&api.ProvenancePolicyConfig{
+			ProvenanceRule: api.ProvenanceRule{Keys: []string{"/tmp/key.pem"}}})
+		So(err, ShouldNotBeNil)
+		So(policy, ShouldBeNil)
+
+		statement := newTestStatement(manifest, api.SLSAProvenanceV1, builders[0])
+		predicateType, builder, err := api.VerifyProvenanceAttestation(statement, api.InTotoMediaType, target,
+			builders, nil)
+		So(err, ShouldBeNil)
+		So(predicateType, ShouldEqual, api.SLSAProvenanceV1)
+		So(builder, ShouldEqual, builders[0])
+
+		_, _, err = api.VerifyProvenanceAttestation(newTestDSSE(statement, key), api.DSSEMediaType, target,
+			builders, keys)
+		So(err, ShouldBeNil)
+
+		_, _, err = api.VerifyProvenanceAttestation(newTestDSSE(statement, otherKey), api.DSSEMediaType, target,
+			builders, keys)
+		So(goerrors.Is(err, errors.ErrBadAttestation), ShouldBeTrue)
+
+		_, _, err = api.VerifyProvenanceAttestation(statement, api.InTotoMediaType, target, builders, keys)
+		So(goerrors.Is(err, errors.ErrBadAttestation), ShouldBeTrue)
+
+		_, _, err = api.VerifyProvenanceAttestation(statement, api.InTotoMediaType, godigest.FromString("other"),
+			builders, nil)
+		So(goerrors.Is(err, errors.ErrBadAttestation), ShouldBeTrue)
+
+		_, _, err = api.VerifyProvenanceAttestation(statement, api.InTotoMediaType, target,
+			[]string{"https://ci.example.com/other"}, nil)
+		So(goerrors.Is(err, errors.ErrUntrustedBuilder), ShouldBeTrue)
+
+		// builders ending with "*" match by prefix
+		_, _, err = api.VerifyProvenanceAttestation(statement, api.InTotoMediaType, target,
+			[]string{"https://ci.example.com/*"}, nil)
+		So(err, ShouldBeNil)
+
+		sbom := newTestStatement(manifest, "https://spdx.dev/Document", builders[0])
+		_, _, err = api.VerifyProvenanceAttestation(sbom, api.InTotoMediaType, target, builders, nil)
+		So(goerrors.Is(err, errors.ErrProvenanceNotFound), ShouldBeTrue)
+	})
+}
+
+// newTestStatement returns an in-toto statement of a SLSA provenance of the manifest by the builder,
+// in the format of the predicate type.
+func newTestStatement(manifest []byte, predicateType, builder string) []byte {
+	predicate := map[string]interface{}{"builder": map[string]string{"id": builder}}
+	if predicateType == api.SLSAProvenanceV1 {
+		predicate = map[string]interface{}{"runDetails": predicate}
+	}
+
+	digest := godigest.FromBytes(manifest)
+	statement, _ := json.Marshal(map[string]interface{}{
+		"_type": "https://in-toto.io/Statement/v0.1",
+		"subject": []map[string]interface{}{
+			{"name": "image", "digest": map[string]string{digest.Algorithm().String(): digest.Encoded()}},
+		},
+		"predicateType": predicateType,
+		"predicate":     predicate,
+	})
+
+	return statement
+}
+
+// newTestDSSE signs the statement with ECDSA in a DSSE envelope.
+func newTestDSSE(statement []byte, key *ecdsa.PrivateKey) []byte {
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(api.InTotoMediaType), api.InTotoMediaType, len(statement),
+		statement)
+	digest := sha256.Sum256([]byte(pae))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	envelope, _ := json.Marshal(map[string]interface{}{
+		"payloadType": api.InTotoMediaType,
+		"payload":     base64.StdEncoding.EncodeToString(statement),
+		"signatures":  []map[string]string{{"sig": base64.StdEncoding.EncodeToString(signature)}},
+	})
+
+	return envelope
+}
+
+// pushTestAttestation pushes an in-toto attestation of the manifest as a referrer.
+func pushTestAttestation(baseURL, repo string, manifest []byte, mediaType string, content []byte) godigest.Digest {
+	layerDigest := pushTestBlob(baseURL, repo, content)
+	config := []byte("{}")
+	configDigest := pushTestBlob(baseURL, repo, config)
+
+	attestation, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ispec.MediaTypeImageManifest,
+		"artifactType":  api.InTotoMediaType,
+		"config": ispec.Descriptor{
+			MediaType: "application/vnd.oci.empty.v1+json",
+			Digest:    configDigest,
+			Size:      int64(len(config)),
+		},
+		"layers": []ispec.Descriptor{{MediaType: mediaType, Digest: layerDigest, Size: int64(len(content))}},
+		"subject": ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageManifest,
+			Digest:    godigest.FromBytes(manifest),
+			Size:      int64(len(manifest)),
+		},
+	})
+	digest := godigest.FromBytes(attestation)
+
+	resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+		SetBody(attestation).Put(baseURL + "/v2/" + repo + "/manifests/" + digest.String())
+	So(err, ShouldBeNil)
+	So(resp.StatusCode(), ShouldEqual, 201)
+
+	return digest
+}
+
+func newTestCA(name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+
+	return cert, key
+}
+
+func newTestSigner(ca *x509.Certificate, caKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		panic(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+
+	return cert, key
+}
+
+// newTestEnvelope signs the target with ES256 in a notation JWS envelope.
+func newTestEnvelope(target ispec.Descriptor, key *ecdsa.PrivateKey, cert *x509.Certificate,
+	expiry *time.Time) []byte {
+	header := map[string]interface{}{
+		"alg":                          "ES256",
+		"cty":                          api.NotationPayloadContentType,
+		"crit":                         []string{"io.cncf.notary.signingScheme"},
+		"io.cncf.notary.signingScheme": "notary.x509",
+		"io.cncf.notary.signingTime":   time.Now(),
+	}
+
+	if expiry != nil {
+		header["io.cncf.notary.expiry"] = expiry
+		header["crit"] = []string{"io.cncf.notary.signingScheme", "io.cncf.notary.expiry"}
+	}
+
+	protectedJSON, _ := json.Marshal(header)
+	payloadJSON, _ := json.Marshal(map[string]interface{}{"targetArtifact": target})
+	protected := base64.RawURLEncoding.EncodeToString(protectedJSON)
+	payload := base64.RawURLEncoding.EncodeToString(payloadJSON)
+
+	digest := sha256.Sum256([]byte(protected + "." + payload))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	// r and s are left padded to the curve size
+	signature := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(signature[32-len(rb):32], rb)
+	copy(signature[64-len(sb):], sb)
+
+	envelope, _ := json.Marshal(map[string]interface{}{
+		"payload":   payload,
+		"protected": protected,
+		"header":    map[string]interface{}{"x5c": [][]byte{cert.Raw}},
+		"signature": base64.RawURLEncoding.EncodeToString(signature),
+	})
+
+	return envelope
+}
+
+func pushTestBlob(baseURL, repo string, content []byte) godigest.Digest {
+	digest := godigest.FromBytes(content)
+
+	resp, err := resty.R().Post(baseURL + "/v2/" + repo + "/blobs/uploads/")
+	So(err, ShouldBeNil)
+	So(resp.StatusCode(), ShouldEqual, 202)
+
+	resp, err = resty.R().SetQueryParam("digest", digest.String()).
+		SetHeader("Content-Type", "application/octet-stream").SetBody(content).
+		Put(baseURL + resp.Header().Get("Location"))
+	So(err, ShouldBeNil)
+	So(resp.StatusCode(), ShouldEqual, 201)
+
+	return digest
+}
+
+func pushTestImage(baseURL, repo, tag string) []byte {
+	layer := []byte("layer of " + repo + ":" + tag)
+	layerDigest := pushTestBlob(baseURL, repo, layer)
+	config, _ := json.Marshal(ispec.Image{})
+	configDigest := pushTestBlob(baseURL, repo, config)
+
+	m := ispec.Manifest{
+		Config: ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    configDigest,
+			Size:      int64(len(config)),
+		},
+		Layers: []ispec.Descriptor{
+			{
+				MediaType: ispec.MediaTypeImageLayer,
+				Digest:    layerDigest,
+				Size:      int64(len(layer)),
+			},
+		},
+	}
+	m.SchemaVersion = 2
+	content, _ := json.Marshal(m)
+
+	resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+		SetBody(content).Put(baseURL + "/v2/" + repo + "/manifests/" + tag)
+	So(err, ShouldBeNil)
+	So(resp.StatusCode(), ShouldEqual, 201)
+
+	return content
+}
+
+// pushTestSignature pushes a notation signature of the manifest as a referrer, the same way notation does.
+func pushTestSignature(baseURL, repo string, manifest []byte, key *ecdsa.PrivateKey,
+	cert *x509.Certificate) godigest.Digest {
+	target := ispec.Descriptor{
+		MediaType: ispec.MediaTypeImageManifest,
+		Digest:    godigest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+	}
+	envelope := newTestEnvelope(target, key, cert, nil)
+	envelopeDigest := pushTestBlob(baseURL, repo, envelope)
+	config := []byte("{}")
+	configDigest := pushTestBlob(baseURL, repo, config)
+
+	signature, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ispec.MediaTypeImageManifest,
+		"config": ispec.Descriptor{
+			MediaType: api.NotationSignatureArtifactType,
+			Digest:    configDigest,
+			Size:      int64(len(config)),
+		},
+		"layers": []ispec.Descriptor{
+			{
+				MediaType: api.NotationJWSMediaType,
+				Digest:    envelopeDigest,
+				Size:      int64(len(envelope)),
+			},
+		},
+		"subject": target,
+	})
+	digest := godigest.FromBytes(signature)
+
+	resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+		SetBody(signature).Put(baseURL + "/v2/" + repo + "/manifests/" + digest.String())
+	So(err, ShouldBeNil)
+	So(resp.StatusCode(), ShouldEqual, 201)
+
+	return digest
+}
+
+func TestBlobEncryption(t *testing.T) {
+	Convey("Blobs are encrypted at rest", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		subDir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(subDir)
+
+		key := bytes.Repeat([]byte{0x42}, 32)
+		keyFile := path.Join(dir, "blob.key")
+		So(ioutil.WriteFile(keyFile, []byte(fmt.Sprintf("%x\n", key)), 0600), ShouldBeNil)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.Storage.RootDirectory = dir
+		config.Storage.Encryption = &api.EncryptionConfig{KeyFile: keyFile}
+		config.Storage.SubPaths = map[string]api.StorageConfig{
+			"/a": {
+				RootDirectory: subDir,
+				Encryption: &api.EncryptionConfig{
+					// a KMS plugin prints the key on its standard output
+					KeyCommand: []string{"echo", base64.StdEncoding.EncodeToString(key)},
+				},
+			},
+		}
+
+		c := api.NewController(config)
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		for _, repo := range []string{"enc", "a/enc"} {
+			content := []byte("encrypted layer of " + repo)
+			pushTestImage(baseURL, repo, "1.0")
+			digest := pushTestBlob(baseURL, repo, content)
+
+			blobPath := c.StoreController.GetImageStore(repo).BlobPath(repo, digest)
+			onDisk, err := ioutil.ReadFile(blobPath)
+			So(err, ShouldBeNil)
+			So(bytes.Contains(onDisk, content), ShouldBeFalse)
+
+			resp, err := resty.R().Head(baseURL + "/v2/" + repo + "/blobs/" + digest.String())
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+			So(resp.Header().Get("Content-Length"), ShouldEqual, fmt.Sprint(len(content)))
+
+			resp, err = resty.R().Get(baseURL + "/v2/" + repo + "/blobs/" + digest.String())
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+			So(resp.Body(), ShouldResemble, content)
+		}
+	})
+
+	Convey("Invalid encryption configuration", t, func() {
+		config := api.NewConfig()
+		config.Storage.Encryption = &api.EncryptionConfig{}
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+
+		config.Storage.Encryption = &api.EncryptionConfig{KeyFile: "key", KeyCommand: []string{"kms"}}
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		config.Storage.RootDirectory = dir
+		config.Storage.Encryption = &api.EncryptionConfig{KeyCommand: []string{"echo", "too short"}}
+		So(api.NewController(config).Run(), ShouldEqual, errors.ErrBadEncryptionKey)
+	})
+}
+
+func TestTLSPolicy(t *testing.T) {
+	Convey("TLS versions and cipher suites are restricted", t, func() {
+		caCert, err := ioutil.ReadFile(CACert)
+		So(err, ShouldBeNil)
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.HTTP.TLS = &api.TLSConfig{
+			Cert: ServerCert,
+			Key:  ServerKey,
+			Policy: &api.TLSPolicyConfig{
+				MinVersion:   "1.2",
+				MaxVersion:   "1.2",
+				CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+			},
+		}
+
+		c := api.NewController(config)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c.Config.Storage.RootDirectory = dir
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		dial := func(tlsConfig *tls.Config) (*tls.ConnectionState, error) {
+			tlsConfig.RootCAs = caCertPool
+
+			conn, err := tls.Dial("tcp", "127.0.0.1:"+port, tlsConfig)
+			if err != nil {
+				return nil, err
+			}
+			defer conn.Close()
+
+			state := conn.ConnectionState()
+
+			return &state, nil
+		}
+
+		state, err := dial(&tls.Config{MinVersion: tls.VersionTLS12})
+		So(err, ShouldBeNil)
+		So(state.Version, ShouldEqual, tls.VersionTLS12)
+		So(state.CipherSuite, ShouldEqual, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384)
+
+		_, err = dial(&tls.Config{MinVersion: tls.VersionTLS13})
+		So(err, ShouldNotBeNil)
+
+		_, err = dial(&tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Invalid TLS policy", t, func() {
+		config := api.NewConfig()
+
+		for _, policy := range []api.TLSPolicyConfig{
+			{MinVersion: "1.4"},
+			{MinVersion: "1.3", MaxVersion: "1.2"},
+			{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			{CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+		} {
+			policy := policy
+			config.HTTP.TLS = &api.TLSConfig{Policy: &policy}
+			So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+		}
+
+		So(api.ApplyTLSPolicy(&tls.Config{}, &api.TLSPolicyConfig{MinVersion: "TLS1.3"}), ShouldBeNil)
+	})
+}
+
+func TestMultipleListeners(t *testing.T) {
+	Convey("Serve on several addresses and a unix socket", t, func() {
+		caCert, err := ioutil.ReadFile(CACert)
+		So(err, ShouldBeNil)
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		port := getFreePort()
+		tlsPort := getFreePort()
+		socket := path.Join(dir, "zot.sock")
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.Storage.RootDirectory = path.Join(dir, "storage")
+		config.HTTP.Listeners = []api.ListenerConfig{
+			{Address: "127.0.0.1", Port: tlsPort, TLS: &api.TLSConfig{Cert: ServerCert, Key: ServerKey}},
+			{Socket: socket},
+		}
+
+		// dual-stack, if the host has IPv6
+		ipv6Port := getFreePort()
+		if l, err := net.Listen("tcp", "[::1]:0"); err == nil {
+			l.Close()
+
+			config.HTTP.Listeners = append(config.HTTP.Listeners, api.ListenerConfig{Address: "::1", Port: ipv6Port})
+		}
+
+		c := api.NewController(config)
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(getBaseURL(port, false))
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		tlsClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: caCertPool}}}
+		socketClient := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}}
+
+		get := func(client *http.Client, url string) (int, error) {
+			resp, err := client.Get(url + "/v2/")
+			if err != nil {
+				return 0, err
+			}
+			defer resp.Body.Close()
+
+			return resp.StatusCode, nil
+		}
+
+		status, err := get(http.DefaultClient, getBaseURL(port, false))
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, 200)
+
+		status, err = get(tlsClient, getBaseURL(tlsPort, true))
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, 200)
+
+		status, err = get(socketClient, "http://zot")
+		So(err, ShouldBeNil)
+		So(status, ShouldEqual, 200)
+
+		info, err := os.Stat(socket)
+		So(err, ShouldBeNil)
+		So(info.Mode().Perm(), ShouldEqual, 0600)
+
+		if len(config.HTTP.Listeners) > 2 {
+			status, err = get(http.DefaultClient, "http://[::1]:"+ipv6Port)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, 200)
+		}
+
+		// shutting down the server stops all the listeners
+		So(c.Server.Shutdown(context.Background()), ShouldBeNil)
+
+		for i := 0; i < 50; i++ {
+			if _, err = get(tlsClient, getBaseURL(tlsPort, true)); err != nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+		So(err, ShouldNotBeNil)
+
+		_, err = get(socketClient, "http://zot")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Invalid listeners", t, func() {
+		config := api.NewConfig()
+
+		config.HTTP.Listeners = []api.ListenerConfig{{Address: "::", Port: "8443", Socket: "/tmp/zot.sock"}}
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+
+		config.HTTP.Listeners = []api.ListenerConfig{{Address: "::"}}
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+
+		// only the additional listeners are used without a port
+		config.HTTP.Port = ""
+		config.HTTP.Listeners = []api.ListenerConfig{{Socket: "/tmp/zot.sock"}}
+		So(config.Validate(api.NewController(config).Log), ShouldBeNil)
+		So(config.HTTP.GetListeners(), ShouldResemble, config.HTTP.Listeners)
+	})
+}
+
+func TestSocketActivation(t *testing.T) {
+	// the activated zot runs in a child process, which gets the socket as its first inherited file
+	if dir := os.Getenv("ZOT_TEST_SOCKET_ACTIVATION"); dir != "" {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		os.Setenv("LISTEN_FDS", "1")
+
+		config := api.NewConfig()
+		config.HTTP.SocketActivation = true
+		config.Storage.RootDirectory = dir
+
+		_ = api.NewController(config).Run()
+
+		return
+	}
+
+	Convey("Serve sockets passed by systemd", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+
+		socket, err := l.(*net.TCPListener).File()
+		So(err, ShouldBeNil)
+		l.Close()
+
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSocketActivation$") // nolint: gosec
+		cmd.Env = append(os.Environ(), "ZOT_TEST_SOCKET_ACTIVATION="+dir)
+		cmd.ExtraFiles = []*os.File{socket}
+		So(cmd.Start(), ShouldBeNil)
+		socket.Close()
+
+		defer func() {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		}()
+
+		var resp *resty.Response
+
+		for i := 0; i < 100; i++ {
+			resp, err = resty.R().Get("http://" + l.Addr().String() + "/v2/")
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+	})
+
+	Convey("Fail without sockets passed by systemd", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		config := api.NewConfig()
+		config.HTTP.SocketActivation = true
+		config.Storage.RootDirectory = dir
+
+		So(config.Validate(api.NewController(config).Log), ShouldBeNil)
+		So(api.NewController(config).Run(), ShouldEqual, errors.ErrNoActivatedSockets)
+	})
+}
+
+func TestACME(t *testing.T) {
+	Convey("Serve certificates obtained via ACME", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// seed the certificate cache under the storage root, as if the certificate was already obtained
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "zot.example.com"},
+			DNSNames:     []string{"zot.example.com"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		So(err, ShouldBeNil)
+
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		So(err, ShouldBeNil)
+
+		cached := append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+		So(os.MkdirAll(path.Join(dir, ".acme"), 0700), ShouldBeNil)
+		So(ioutil.WriteFile(path.Join(dir, ".acme", "zot.example.com"), cached, 0600), ShouldBeNil)
+
+		port := getFreePort()
+		challengePort := getFreePort()
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.Storage.RootDirectory = dir
+		config.HTTP.TLS = &api.TLSConfig{
+			ACME: &api.ACMEConfig{
+				Domains:              []string{"zot.example.com"},
+				DirectoryURL:         "https://127.0.0.1:1/directory",
+				HTTPChallengeAddress: "127.0.0.1:" + challengePort,
+				AcceptTOS:            true,
+			},
+		}
+
+		c := api.NewController(config)
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// HTTP-01 challenges are answered, other requests are redirected to HTTPS
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+
+		// wait till ready
+		for {
+			resp, err := client.Get(getBaseURL(challengePort, false))
+			if err == nil {
+				resp.Body.Close()
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		roots := x509.NewCertPool()
+		roots.AddCert(template)
+		roots.AppendCertsFromPEM(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+		conn, err := tls.Dial("tcp", "127.0.0.1:"+port, &tls.Config{
+			ServerName: "zot.example.com",
+			RootCAs:    roots,
+			NextProtos: []string{"h2", "http/1.1"},
+		})
+		So(err, ShouldBeNil)
+		So(conn.ConnectionState().PeerCertificates[0].Raw, ShouldResemble, der)
+		So(conn.ConnectionState().NegotiatedProtocol, ShouldEqual, "h2")
+		conn.Close()
+
+		// names outside of the configured domains get no certificate
+		_, err = tls.Dial("tcp", "127.0.0.1:"+port, &tls.Config{ServerName: "other.example.com", RootCAs: roots})
+		So(err, ShouldNotBeNil)
+
+		get := func(path string) (int, string) {
+			req, err := http.NewRequest(http.MethodGet, getBaseURL(challengePort, false)+path, nil)
+			So(err, ShouldBeNil)
+			req.Host = "zot.example.com"
+
+			resp, err := client.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+
+			return resp.StatusCode, resp.Header.Get("Location")
+		}
+
+		status, _ := get("/.well-known/acme-challenge/unknown")
+		So(status, ShouldEqual, 404)
+
+		status, location := get("/v2/")
+		So(status, ShouldEqual, 302)
+		So(location, ShouldEqual, "https://zot.example.com/v2/")
+	})
+
+	Convey("Invalid ACME configuration", t, func() {
+		config := api.NewConfig()
+
+		config.HTTP.TLS = &api.TLSConfig{ACME: &api.ACMEConfig{Domains: []string{"zot.example.com"}}}
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+
+		config.HTTP.TLS = &api.TLSConfig{ACME: &api.ACMEConfig{AcceptTOS: true}}
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+
+		config.HTTP.TLS = &api.TLSConfig{
+			Cert: ServerCert,
+			Key:  ServerKey,
+			ACME: &api.ACMEConfig{Domains: []string{"zot.example.com"}, AcceptTOS: true},
+		}
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+	})
+}
+
+// writeTestServerCert writes a self-signed certificate for 127.0.0.1 and its key, it returns the certificate.
+func writeTestServerCert(certFile, keyFile string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	So(err, ShouldBeNil)
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	So(err, ShouldBeNil)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+
+	So(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), ShouldBeNil)
+	So(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600),
+		ShouldBeNil)
+
+	cert, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+
+	return cert
+}
+
+// signalSelf sends a signal to the test process, as an operator would to zot.
+func signalSelf(sig os.Signal) error {
+	proc, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		return err
+	}
+
+	return proc.Signal(sig)
+}
+
+func TestCertReload(t *testing.T) {
+	Convey("Rotate the server certificate without a restart", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		certFile := path.Join(dir, "server.cert")
+		keyFile := path.Join(dir, "server.key")
+		first := writeTestServerCert(certFile, keyFile)
+
+		port := getFreePort()
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.HTTP.TLS = &api.TLSConfig{Cert: certFile, Key: keyFile}
+		config.Storage.RootDirectory = path.Join(dir, "storage")
+
+		c := api.NewController(config)
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(getBaseURL(port, false))
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		// the certificate of a new connection
+		peerCert := func() *x509.Certificate {
+			conn, err := tls.Dial("tcp", "127.0.0.1:"+port, &tls.Config{InsecureSkipVerify: true}) // nolint: gosec
+			So(err, ShouldBeNil)
+			defer conn.Close()
+
+			return conn.ConnectionState().PeerCertificates[0]
+		}
+
+		waitForCert := func(cert *x509.Certificate, timeout time.Duration) bool {
+			for start := time.Now(); time.Since(start) < timeout; time.Sleep(100 * time.Millisecond) {
+				if peerCert().Equal(cert) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		So(peerCert().Equal(first), ShouldBeTrue)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(first)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+		resp, err := client.Get(getBaseURL(port, true) + "/v2/")
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+
+		Convey("On SIGHUP", func() {
+			second := writeTestServerCert(certFile, keyFile)
+			So(signalSelf(syscall.SIGHUP), ShouldBeNil)
+			So(waitForCert(second, 2*time.Second), ShouldBeTrue)
+
+			// established connections are kept
+			resp, err := client.Get(getBaseURL(port, true) + "/v2/")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, 200)
+			So(resp.TLS.PeerCertificates[0].Equal(first), ShouldBeTrue)
+		})
+
+		Convey("When the files change", func() {
+			// a key not matching the certificate, e.g. in the middle of a rotation, is ignored
+			second := writeTestServerCert(certFile, path.Join(dir, "other.key"))
+			So(waitForCert(second, 6*time.Second), ShouldBeFalse)
+			So(peerCert().Equal(first), ShouldBeTrue)
+
+			second = writeTestServerCert(certFile, keyFile)
+			So(waitForCert(second, 10*time.Second), ShouldBeTrue)
+		})
+	})
+}
+
+func TestDeprecation(t *testing.T) {
+	Convey("Admin users deprecate repositories and tags", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase))
+		defer os.Remove(htpasswdPath)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.HTTP.AllowReadAccess = true
+		config.HTTP.Auth = &api.AuthConfig{
+			HTPasswd:   api.AuthHTPasswd{Path: htpasswdPath},
+			AdminUsers: []string{username},
+		}
+		config.Storage.RootDirectory = dir
+
+		c := api.NewController(config)
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		admin := func() *resty.Request {
+			return resty.R().SetBasicAuth(username, passphrase)
+		}
+
+		imgStore := c.StoreController.DefaultStore
+		So(imgStore.InitRepo("old"), ShouldBeNil)
+
+		for _, tag := range []string{"1.0", "2.0"} {
+			layer := []byte("layer of old:" + tag)
+			layerDigest := godigest.FromBytes(layer)
+			_, _, err = imgStore.FullBlobUpload("old", bytes.NewReader(layer), layerDigest.String())
+			So(err, ShouldBeNil)
+
+			config, err := json.Marshal(ispec.Image{})
+			So(err, ShouldBeNil)
+			configDigest := godigest.FromBytes(config)
+			_, _, err = imgStore.FullBlobUpload("old", bytes.NewReader(config), configDigest.String())
+			So(err, ShouldBeNil)
+
+			m := ispec.Manifest{
+				Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: configDigest,
+					Size: int64(len(config))},
+				Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: layerDigest,
+					Size: int64(len(layer))}},
+			}
+			m.SchemaVersion = 2
+			content, err := json.Marshal(m)
+			So(err, ShouldBeNil)
+
+			_, err = imgStore.PutImageManifest("old", tag, ispec.MediaTypeImageManifest, content)
+			So(err, ShouldBeNil)
+		}
+
+		deprecationsURL := baseURL + "/v2/_zot/admin/deprecations/old"
+
+		resp, err := resty.R().Get(baseURL + "/v2/old/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get(api.WarningHeader), ShouldBeEmpty)
+
+		// only admin users deprecate images
+		resp, err = resty.R().SetBody(`{"message":"unmaintained"}`).Put(deprecationsURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		resp, err = admin().SetBody(`{"message":"unmaintained","replacement":"new"}`).Put(deprecationsURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = admin().SetBody(`{"message":"vulnerable"}`).Put(deprecationsURL + "?tag=1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = resty.R().Get(baseURL + "/v2/old/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get(api.WarningHeader), ShouldEqual, `299 - "deprecated: vulnerable"`)
+
+		resp, err = resty.R().Head(baseURL + "/v2/old/manifests/2.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get(api.WarningHeader), ShouldEqual, `299 - "deprecated: unmaintained, use new instead"`)
+
+		resp, err = admin().Delete(deprecationsURL + "?tag=1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = admin().Delete(deprecationsURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = resty.R().Get(baseURL + "/v2/old/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get(api.WarningHeader), ShouldBeEmpty)
+
+		// a deprecation needs a message
+		resp, err = admin().SetBody(`{"replacement":"new"}`).Put(deprecationsURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		resp, err = admin().SetBody(`{"message":"unmaintained"}`).Put(deprecationsURL + "?tag=3.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = admin().SetBody(`{"message":"unmaintained"}`).Put(baseURL + "/v2/_zot/admin/deprecations/unknown")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+	})
+}
+
+func TestPatchAnnotations(t *testing.T) {
+	Convey("Annotations of tagged manifests are patched", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Storage.TagPolicy = &api.TagPolicyConfig{
+				TagPolicyRule: api.TagPolicyRule{Immutable: []string{"^stable$"}},
+			}
+		})
+		defer stopServer(c)
+
+		manifest := pushTestImage(baseURL, "app", "1.0")
+		pushTestImage(baseURL, "app", "stable")
+		digest := godigest.FromBytes(manifest)
+		annotationsURL := baseURL + "/v2/_zot/ext/annotations/app"
+
+		resp, err := resty.R().SetQueryParam("tag", "1.0").SetHeader("If-Match", `"`+digest.String()+`"`).
+			SetBody(`{"com.example.ticket":"OPS-42"}`).Patch(annotationsURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		patchedDigest := resp.Header().Get(api.DistContentDigestKey)
+		So(patchedDigest, ShouldNotEqual, digest.String())
+
+		resp, err = resty.R().Get(baseURL + "/v2/app/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get(api.DistContentDigestKey), ShouldEqual, patchedDigest)
+
+		var m ispec.Manifest
+		So(json.Unmarshal(resp.Body(), &m), ShouldBeNil)
+		So(m.Annotations, ShouldResemble, map[string]string{"com.example.ticket": "OPS-42"})
+
+		// the tag moved since
+		resp, err = resty.R().SetQueryParam("tag", "1.0").SetHeader("If-Match", digest.String()).
+			SetBody(`{"com.example.ticket":null}`).Patch(annotationsURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 412)
+
+		resp, err = resty.R().SetQueryParam("tag", "stable").
+			SetBody(`{"com.example.ticket":"OPS-42"}`).Patch(annotationsURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = resty.R().SetQueryParam("tag", digest.String()).
+			SetBody(`{"com.example.ticket":"OPS-42"}`).Patch(annotationsURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		resp, err = resty.R().SetQueryParam("tag", "1.0").SetBody(`["OPS-42"]`).Patch(annotationsURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		resp, err = resty.R().SetQueryParam("tag", "2.0").
+			SetBody(`{"com.example.ticket":"OPS-42"}`).Patch(annotationsURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = resty.R().SetQueryParam("tag", "1.0").
+			SetBody(`{"com.example.ticket":"OPS-42"}`).Patch(baseURL + "/v2/_zot/ext/annotations/unknown")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+	})
+}
+
+func TestTagExpiry(t *testing.T) {
+	Convey("Expired tags are removed", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Storage.TagExpiryInterval = 200 * time.Millisecond
+			config.Storage.TagPolicy = &api.TagPolicyConfig{
+				TagPolicyRule: api.TagPolicyRule{Immutable: []string{"^stable$"}},
+			}
+		})
+		defer stopServer(c)
+
+		pushTestImage(baseURL, "app", "pr-1")
+		pushTestImage(baseURL, "app", "1.0")
+		pushTestImage(baseURL, "app", "stable")
+		ttlURL := baseURL + "/v2/_zot/ext/ttl/app"
+
+		resp, err := resty.R().SetQueryParam("tag", "1.0").SetBody(`{"ttl":"72h"}`).Put(ttlURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var expiry api.TagExpiry
+		So(json.Unmarshal(resp.Body(), &expiry), ShouldBeNil)
+		So(expiry.Tag, ShouldEqual, "1.0")
+		So(expiry.Expires, ShouldHappenWithin, time.Minute, time.Now().Add(72*time.Hour))
+
+		resp, err = resty.R().Get(ttlURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var expiries map[string]time.Time
+		So(json.Unmarshal(resp.Body(), &expiries), ShouldBeNil)
+		So(expiries, ShouldResemble, map[string]time.Time{"1.0": expiry.Expires})
+
+		resp, err = resty.R().SetQueryParam("tag", "1.0").Delete(ttlURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = resty.R().SetQueryParam("tag", "pr-1").SetBody(`{"ttl":"1s"}`).Put(ttlURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		for i := 0; i < 50; i++ {
+			resp, err = resty.R().Get(baseURL + "/v2/app/manifests/pr-1")
+			So(err, ShouldBeNil)
+
+			if resp.StatusCode() == 404 {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = resty.R().Get(baseURL + "/v2/app/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = resty.R().SetQueryParam("tag", "1.0").SetBody(`{"ttl":"-1h"}`).Put(ttlURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		resp, err = resty.R().SetBody(`{"ttl":"1h"}`).Put(ttlURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		resp, err = resty.R().SetQueryParam("tag", "pr-1").SetBody(`{"ttl":"1h"}`).Put(ttlURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/ext/ttl/unknown")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = resty.R().SetQueryParam("tag", "stable").SetBody(`{"ttl":"1h"}`).Put(ttlURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+	})
+}
+
+func TestNamespaces(t *testing.T) {
+	Convey("Only the members of a namespace push to its repositories", t, func() {
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase) + "\n" +
+			getCredString("alice", "alice") + "\n" + getCredString("bob", "bob"))
+		defer os.Remove(htpasswdPath)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.Auth = &api.AuthConfig{
+				HTPasswd:   api.AuthHTPasswd{Path: htpasswdPath},
+				AdminUsers: []string{username},
+			}
+		})
+		defer stopServer(c)
+
+		as := func(user string) *resty.Request {
+			return resty.R().SetBasicAuth(user, user)
+		}
+
+		push := func(user, repo, tag string, layer []byte) int {
+			config, err := json.Marshal(ispec.Image{})
+			So(err, ShouldBeNil)
+
+			for _, blob := range [][]byte{layer, config} {
+				resp, err := as(user).SetHeader("Content-Type", "application/octet-stream").
+					SetQueryParam("digest", godigest.FromBytes(blob).String()).SetBody(blob).
+					Post(baseURL + "/v2/" + repo + "/blobs/uploads/")
+				So(err, ShouldBeNil)
+
+				if resp.StatusCode() != 201 {
+					return resp.StatusCode()
+				}
+			}
+
+			m := ispec.Manifest{
+				Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: godigest.FromBytes(config),
+					Size: int64(len(config))},
+				Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: godigest.FromBytes(layer),
+					Size: int64(len(layer))}},
+			}
+			m.SchemaVersion = 2
+			content, err := json.Marshal(m)
+			So(err, ShouldBeNil)
+
+			resp, err := as(user).SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+				SetBody(content).Put(baseURL + "/v2/" + repo + "/manifests/" + tag)
+			So(err, ShouldBeNil)
+
+			return resp.StatusCode()
+		}
+
+		namespacesURL := baseURL + "/v2/_zot/admin/namespaces"
+
+		// only admin users manage namespaces
+		resp, err := as("alice").SetBody(`{"members":["alice"]}`).Put(namespacesURL + "/team-a")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = as(username).SetBody(`{"members":["alice"],"repoQuota":4096,"tagTTL":"1h"}`).
+			Put(namespacesURL + "/team-a")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = as(username).SetBody(`{"tagTTL":"soon"}`).Put(namespacesURL + "/team-b")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		resp, err = as(username).Get(namespacesURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var namespaces []api.Namespace
+		So(json.Unmarshal(resp.Body(), &namespaces), ShouldBeNil)
+		So(namespaces, ShouldResemble, []api.Namespace{
+			{Name: "team-a", Members: []string{"alice"}, RepoQuota: 4096, TagTTL: "1h"},
+		})
+
+		So(push("alice", "team-a/app", "1.0", []byte("layer of team-a/app")), ShouldEqual, 201)
+		So(push("bob", "team-a/app", "2.0", []byte("layer of team-a/app:2.0")), ShouldEqual, 403)
+		So(push(username, "team-a/app", "3.0", []byte("layer of team-a/app:3.0")), ShouldEqual, 201)
+		So(push("bob", "team-b/app", "1.0", []byte("layer of team-b/app")), ShouldEqual, 201)
+
+		// anyone pulls
+		resp, err = as("bob").Get(baseURL + "/v2/team-a/app/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = as("bob").Delete(baseURL + "/v2/team-a/app/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		// the tags of the namespace expire
+		resp, err = as("alice").Get(baseURL + "/v2/_zot/ext/ttl/team-a/app")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var expiries map[string]time.Time
+		So(json.Unmarshal(resp.Body(), &expiries), ShouldBeNil)
+		So(expiries, ShouldHaveLength, 2)
+		So(expiries["1.0"], ShouldHappenWithin, time.Minute, time.Now().Add(time.Hour))
+
+		// the repository quota
+		So(push("alice", "team-a/app", "big", bytes.Repeat([]byte("a"), 4096)), ShouldEqual, 403)
+
+		resp, err = as(username).Delete(namespacesURL + "/team-a")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = as(username).Delete(namespacesURL + "/team-a")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		So(push("bob", "team-a/app", "2.0", []byte("layer of team-a/app:2.0")), ShouldEqual, 201)
+	})
+}
+
+func TestAdminResources(t *testing.T) {
+	Convey("Admin resources are updated with optimistic concurrency", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.AllowAdminAccess = true
+			config.Storage.RetainPulledWithin = time.Hour
+		})
+		defer stopServer(c)
+
+		resourcesURL := baseURL + "/v2/_zot/admin/resources"
+
+		get := func(location string) api.Resource {
+			resp, err := resty.R().Get(resourcesURL + location)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+
+			var resource api.Resource
+			So(json.Unmarshal(resp.Body(), &resource), ShouldBeNil)
+
+			return resource
+		}
+
+		put := func(location, body string) (int, api.Resource) {
+			resp, err := resty.R().SetBody(body).Put(resourcesURL + location)
+			So(err, ShouldBeNil)
+
+			var resource api.Resource
+			if resp.StatusCode() == 200 {
+				So(json.Unmarshal(resp.Body(), &resource), ShouldBeNil)
+			}
+
+			return resp.StatusCode(), resource
+		}
+
+		// repos are created
+		status, created := put("/repos/team-a", `{"spec":{"members":["alice"]}}`)
+		So(status, ShouldEqual, 200)
+		So(created.Kind, ShouldEqual, api.ResourceRepos)
+		So(created.ResourceVersion, ShouldNotBeEmpty)
+		So(string(created.Spec), ShouldEqual, `{"members":["alice"]}`)
+
+		status, updated := put("/repos/team-a",
+			`{"resourceVersion":"`+created.ResourceVersion+`","spec":{"members":["alice","bob"]}}`)
+		So(status, ShouldEqual, 200)
+		So(updated.ResourceVersion, ShouldNotEqual, created.ResourceVersion)
+
+		// the resource was changed since the version was read
+		status, _ = put("/repos/team-a",
+			`{"resourceVersion":"`+created.ResourceVersion+`","spec":{"members":["carol"]}}`)
+		So(status, ShouldEqual, 409)
+		So(get("/repos/team-a"), ShouldResemble, updated)
+
+		status, _ = put("/repos/team-a", `{"spec":{"tagTTL":"soon"}}`)
+		So(status, ShouldEqual, 400)
+
+		// the repos are namespaces
+		resp, err := resty.R().Get(baseURL + "/v2/_zot/admin/namespaces")
+		So(err, ShouldBeNil)
+		So(string(resp.Body()), ShouldContainSubstring, `"members":["alice","bob"]`)
+
+		// the other kinds come from the configuration
+		So(string(get("/retentionrules/_default").Spec), ShouldEqual, `{"retainPulledWithin":"1h0m0s"}`)
+		So(string(get("/policies/tags").Spec), ShouldEqual, `{}`)
+
+		status, _ = put("/retentionrules/_default", `{"spec":{"retainPulledWithin":"720h"}}`)
+		So(status, ShouldEqual, 200)
+
+		status, _ = put("/policies/tags", `{"spec":{"immutable":["^v"],"repositories":{"dev/*":{}}}}`)
+		So(status, ShouldEqual, 200)
+
+		status, _ = put("/policies/signatures", `{"spec":{}}`)
+		So(status, ShouldEqual, 404)
+
+		status, _ = put("/syncrules/mirror.example.com", `{"spec":{"content":[]}}`)
+		So(status, ShouldEqual, 404)
+
+		resp, err = resty.R().Get(resourcesURL + "/widgets")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = resty.R().Get(resourcesURL + "/retentionrules")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var list []api.Resource
+		So(json.Unmarshal(resp.Body(), &list), ShouldBeNil)
+		So(list, ShouldHaveLength, 1)
+
+		// the updates are applied again on restart, the repos are saved as namespaces
+		buf, err := ioutil.ReadFile(path.Join(dir, "resources.json"))
+		So(err, ShouldBeNil)
+
+		var saved map[string]map[string]json.RawMessage
+		So(json.Unmarshal(buf, &saved), ShouldBeNil)
+		var tagPolicy api.TagPolicySpec
+		So(json.Unmarshal(saved[api.ResourcePolicies]["tags"], &tagPolicy), ShouldBeNil)
+		So(tagPolicy.Immutable, ShouldResemble, []string{"^v"})
+		So(tagPolicy.Repositories, ShouldContainKey, "dev/*")
+
+		var retention api.RetentionRuleSpec
+		So(json.Unmarshal(saved[api.ResourceRetentionRules]["_default"], &retention), ShouldBeNil)
+		So(retention.RetainPulledWithin, ShouldEqual, "720h0m0s")
+		So(saved, ShouldNotContainKey, api.ResourceRepos)
+
+	})
+}
+
+func TestTenants(t *testing.T) {
+	Convey("Only the members of a tenant access its repositories, kept in its own image store", t, func() {
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase) + "\n" +
+			getCredString("alice", "alice") + "\n" + getCredString("bob", "bob"))
+		defer os.Remove(htpasswdPath)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		tenantDir, err := ioutil.TempDir("", "oci-tenant-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tenantDir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.Auth = &api.AuthConfig{
+				HTPasswd:   api.AuthHTPasswd{Path: htpasswdPath},
+				AdminUsers: []string{username},
+			}
+			config.Storage.Tenants = map[string]api.TenantConfig{
+				"acme": {StorageConfig: api.StorageConfig{RootDirectory: tenantDir}, Members: []string{"alice"}},
+			}
+			config.Extensions = &extconf.ExtensionConfig{Search: &extconf.SearchConfig{Enable: true}}
+		})
+		defer stopServer(c)
+
+		as := func(user string) *resty.Request {
+			return resty.R().SetBasicAuth(user, user)
+		}
+
+		push := func(user, repo, tag string, layer []byte) (int, string) {
+			config, err := json.Marshal(ispec.Image{})
+			So(err, ShouldBeNil)
+
+			for _, blob := range [][]byte{layer, config} {
+				resp, err := as(user).SetHeader("Content-Type", "application/octet-stream").
+					SetQueryParam("digest", godigest.FromBytes(blob).String()).SetBody(blob).
+					Post(baseURL + "/v2/" + repo + "/blobs/uploads/")
+				So(err, ShouldBeNil)
+
+				if resp.StatusCode() != 201 {
+					return resp.StatusCode(), resp.String()
+				}
+			}
+
+			m := ispec.Manifest{
+				Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: godigest.FromBytes(config),
+					Size: int64(len(config))},
+				Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: godigest.FromBytes(layer),
+					Size: int64(len(layer))}},
+			}
+			m.SchemaVersion = 2
+			content, err := json.Marshal(m)
+			So(err, ShouldBeNil)
+
+			resp, err := as(user).SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+				SetBody(content).Put(baseURL + "/v2/" + repo + "/manifests/" + tag)
+			So(err, ShouldBeNil)
+
+			return resp.StatusCode(), resp.String()
+		}
+
+		status, _ := push("alice", "acme/app", "1.0", []byte("layer of acme/app"))
+		So(status, ShouldEqual, 201)
+		_, err = os.Stat(path.Join(tenantDir, "acme", "app", "index.json"))
+		So(err, ShouldBeNil)
+
+		status, _ = push("bob", "acme/app", "2.0", []byte("layer of acme/app:2.0"))
+		So(status, ShouldEqual, 403)
+
+		status, _ = push("bob", "app", "1.0", []byte("layer of app"))
+		So(status, ShouldEqual, 201)
+
+		// the repositories of the tenant are hidden from the others
+		resp, err := as("bob").Get(baseURL + "/v2/acme/app/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = as("bob").Get(baseURL + "/v2/_catalog")
+		So(err, ShouldBeNil)
+
+		var repos api.RepositoryList
+		So(json.Unmarshal(resp.Body(), &repos), ShouldBeNil)
+		So(repos.Repositories, ShouldResemble, []string{"app"})
+
+		resp, err = as("alice").Get(baseURL + "/v2/_catalog")
+		So(err, ShouldBeNil)
+		So(json.Unmarshal(resp.Body(), &repos), ShouldBeNil)
+		So(repos.Repositories, ShouldResemble, []string{"acme/app", "app"})
+
+		// and from their searches
+		imageList := func(user, query string) []string {
+			resp, err := as(user).Get(baseURL + "/query?query=" + url.QueryEscape(query))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+
+			var result struct {
+				Data struct {
+					ImageList []struct {
+						RepoName string
+					}
+				}
+			}
+			So(json.Unmarshal(resp.Body(), &result), ShouldBeNil)
+
+			names := []string{}
+			for _, image := range result.Data.ImageList {
+				names = append(names, image.RepoName)
+			}
+
+			return names
+		}
+
+		So(imageList("bob", "{ImageList{RepoName}}"), ShouldResemble, []string{"app"})
+		So(imageList("bob", `{ImageList(repo:"acme/app"){RepoName}}`), ShouldBeEmpty)
+		So(imageList("alice", "{ImageList{RepoName}}"), ShouldResemble, []string{"acme/app", "app"})
+
+		resp, err = as(username).Get(baseURL + "/v2/acme/app/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		// admin users manage the tenants
+		tenantsURL := baseURL + "/v2/_zot/admin/tenants"
+
+		resp, err = as("alice").SetBody(`{"members":["alice","bob"]}`).Put(tenantsURL + "/acme")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = as(username).SetBody(`{"members":["alice","bob"],"quota":4096}`).Put(tenantsURL + "/acme")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		_, err = os.Stat(path.Join(tenantDir, tenant.File))
+		So(err, ShouldBeNil)
+
+		// the cached search results of the new members are invalidated
+		So(imageList("bob", "{ImageList{RepoName}}"), ShouldResemble, []string{"acme/app", "app"})
+
+		resp, err = as(username).SetBody(`{"quota":-1}`).Put(tenantsURL + "/acme")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		resp, err = as(username).SetBody(`{"members":["bob"]}`).Put(tenantsURL + "/other")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = as(username).Get(tenantsURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var tenants []tenant.Status
+		So(json.Unmarshal(resp.Body(), &tenants), ShouldBeNil)
+		So(tenants, ShouldHaveLength, 1)
+		So(tenants[0].Tenant, ShouldResemble, tenant.Tenant{Name: "acme", Members: []string{"alice", "bob"}, Quota: 4096})
+		So(tenants[0].Usage.Repositories, ShouldEqual, 1)
+		So(tenants[0].Usage.Bytes, ShouldBeGreaterThan, 0)
+
+		status, _ = push("bob", "acme/app", "2.0", []byte("layer of acme/app:2.0"))
+		So(status, ShouldEqual, 201)
+
+		// the quota of the tenant spans its repositories
+		status, body := push("bob", "acme/other", "big", bytes.Repeat([]byte("a"), 4096))
+		So(status, ShouldEqual, 403)
+		So(body, ShouldContainSubstring, errors.ErrTenantQuotaExceeded.Error())
+	})
+
+	Convey("Tenant members are identified by the auth webhook", t, func() {
+		webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req api.WebhookRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			resp := api.WebhookResponse{Allowed: true}
+
+			for _, user := range []string{"alice", "bob"} {
+				if req.Authorization == "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+user)) {
+					resp.Username = user
+				}
+			}
+
+			_ = json.NewEncoder(w).Encode(resp)
+		}))
+		defer webhook.Close()
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		tenantDir, err := ioutil.TempDir("", "oci-tenant-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tenantDir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.Auth = &api.AuthConfig{Webhook: &api.WebhookConfig{URL: webhook.URL, Timeout: time.Second}}
+			config.Storage.Tenants = map[string]api.TenantConfig{
+				"acme": {StorageConfig: api.StorageConfig{RootDirectory: tenantDir}, Members: []string{"alice"}},
+			}
+		})
+		defer stopServer(c)
+
+		resp, err := resty.R().SetBasicAuth("alice", "alice").Get(baseURL + "/v2/acme/app/tags/list")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		for _, user := range []string{"bob", "anonymous"} {
+			resp, err = resty.R().SetBasicAuth(user, user).Get(baseURL + "/v2/acme/app/tags/list")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 403)
+		}
+	})
+
+	Convey("Invalid tenants", t, func() {
+		config := api.NewConfig()
+		config.Storage.RootDirectory = "/tmp/zot"
+		config.Storage.SubPaths = map[string]api.StorageConfig{"/a": {RootDirectory: "/tmp/zot-a"}}
+		config.HTTP.Auth = &api.AuthConfig{HTPasswd: api.AuthHTPasswd{Path: "/tmp/htpasswd"}}
+
+		for _, tenants := range []map[string]api.TenantConfig{
+			{"a": {StorageConfig: api.StorageConfig{RootDirectory: "/tmp/zot-b"}}},
+			{"a/b": {StorageConfig: api.StorageConfig{RootDirectory: "/tmp/zot-b"}}},
+			{"b": {}},
+			{"b": {StorageConfig: api.StorageConfig{RootDirectory: "/tmp/zot-b"}, Quota: -1}},
+		} {
+			config.Storage.Tenants = tenants
+			So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+		}
+
+		config.Storage.Tenants = map[string]api.TenantConfig{
+			"b": {StorageConfig: api.StorageConfig{RootDirectory: "/tmp/zot-b"}},
+		}
+		So(config.Validate(api.NewController(config).Log), ShouldBeNil)
+
+		// members are identified by name
+		for _, auth := range []*api.AuthConfig{
+			nil,
+			{},
+			{HTPasswd: api.AuthHTPasswd{Path: "/tmp/htpasswd"}, Bearer: &api.BearerConfig{Realm: "realm"}},
+		} {
+			config.HTTP.Auth = auth
+			So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+		}
+
+		config.HTTP.Auth = &api.AuthConfig{Webhook: &api.WebhookConfig{URL: "http://127.0.0.1:9999/authz"}}
+		So(config.Validate(api.NewController(config).Log), ShouldBeNil)
+	})
+}
+
+func TestPresignedURLs(t *testing.T) {
+	Convey("Pre-signed URLs pull and push a digest without credentials", t, func() {
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase))
+		defer os.Remove(htpasswdPath)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.Auth = &api.AuthConfig{
+				HTPasswd: api.AuthHTPasswd{Path: htpasswdPath},
+				Presign:  &api.PresignConfig{Secret: "secret", MaxTTL: time.Hour},
+			}
+		})
+		defer stopServer(c)
+
+		presign := func(body string) (int, api.PresignResponse) {
+			var presigned api.PresignResponse
+
+			resp, err := resty.R().SetBasicAuth(username, passphrase).SetBody(body).
+				SetResult(&presigned).Post(baseURL + "/v2/_zot/ext/presign/app")
+			So(err, ShouldBeNil)
+
+			return resp.StatusCode(), presigned
+		}
+
+		blob := []byte("delegated blob")
+		digest := godigest.FromBytes(blob)
+
+		// minting needs credentials
+		resp, err := resty.R().SetBody(`{"digest":"` + digest.String() + `","action":"push"}`).
+			Post(baseURL + "/v2/_zot/ext/presign/app")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		status, _ := presign(`{"digest":"sha256:bad","action":"push"}`)
+		So(status, ShouldEqual, 400)
+
+		status, _ = presign(`{"digest":"` + digest.String() + `","action":"delete"}`)
+		So(status, ShouldEqual, 400)
+
+		status, _ = presign(`{"digest":"` + digest.String() + `","action":"push","ttl":"24h"}`)
+		So(status, ShouldEqual, 400)
+
+		status, push := presign(`{"digest":"` + digest.String() + `","action":"push","ttl":"5m"}`)
+		So(status, ShouldEqual, 200)
+		So(push.URL, ShouldStartWith, "/v2/app/blobs/uploads/?")
+		So(push.Expires, ShouldHappenAfter, time.Now())
+
+		// the signature only covers its digest
+		resp, err = resty.R().SetHeader("Content-Type", "application/octet-stream").
+			SetBody([]byte("another blob")).Post(baseURL + strings.Replace(push.URL, digest.Encoded(),
+			godigest.FromBytes([]byte("another blob")).Encoded(), 1))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = resty.R().SetHeader("Content-Type", "application/octet-stream").
+			SetBody(blob).Post(baseURL + strings.Replace(push.URL, "/app/", "/other/", 1))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = resty.R().SetHeader("Content-Type", "application/octet-stream").
+			SetBody(blob).Post(baseURL + push.URL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+
+		// push URLs don't pull
+		resp, err = resty.R().Get(baseURL + strings.Replace(push.URL, "uploads/", digest.String(), 1))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		status, pull := presign(`{"digest":"` + digest.String() + `","action":"pull"}`)
+		So(status, ShouldEqual, 200)
+
+		resp, err = resty.R().Get(baseURL + pull.URL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Body(), ShouldResemble, blob)
+
+		resp, err = resty.R().Get(baseURL + strings.Replace(pull.URL, "zot-expires=", "zot-expires=1", 1))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = resty.R().Delete(baseURL + pull.URL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		// requests without a signature still need credentials
+		resp, err = resty.R().Get(baseURL + "/v2/app/blobs/" + digest.String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	Convey("Browser clients authenticate with session cookies", t, func() {
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase))
+		defer os.Remove(htpasswdPath)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		trustTestCA()
+		defer func() { resty.SetTLSClientConfig(nil) }()
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.TLS = &api.TLSConfig{Cert: ServerCert, Key: ServerKey}
+			config.HTTP.Auth = &api.AuthConfig{
+				HTPasswd: api.AuthHTPasswd{Path: htpasswdPath},
+				Session:  &api.SessionConfig{Secret: "secret", TTL: time.Hour},
+			}
+		})
+		defer stopServer(c)
+
+		baseURL = getBaseURL(c.Config.HTTP.Port, true)
+
+		resp, err := resty.R().Post(baseURL + "/v2" + api.SessionRoute)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		resp, err = resty.R().SetBasicAuth(username, "wrong").Post(baseURL + "/v2" + api.SessionRoute)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		var session api.SessionResponse
+
+		resp, err = resty.R().SetBasicAuth(username, passphrase).SetResult(&session).
+			Post(baseURL + "/v2" + api.SessionRoute)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(session.Username, ShouldEqual, username)
+		So(session.CSRFToken, ShouldNotBeEmpty)
+		So(session.Expires, ShouldHappenAfter, time.Now().Add(59*time.Minute))
+
+		cookies := map[string]*http.Cookie{}
+		for _, cookie := range resp.Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+
+		sessionCookie := cookies[api.SessionCookie]
+		So(sessionCookie, ShouldNotBeNil)
+		So(sessionCookie.Secure, ShouldBeTrue)
+		So(sessionCookie.HttpOnly, ShouldBeTrue)
+		So(sessionCookie.SameSite, ShouldEqual, http.SameSiteStrictMode)
+		So(cookies[api.CSRFCookie], ShouldNotBeNil)
+		So(cookies[api.CSRFCookie].Value, ShouldEqual, session.CSRFToken)
+		So(cookies[api.CSRFCookie].HttpOnly, ShouldBeFalse)
+
+		cookie := api.SessionCookie + "=" + sessionCookie.Value
+
+		resp, err = resty.R().SetHeader("Cookie", cookie).Get(baseURL + "/v2/_catalog")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		// tampered and foreign cookies are ignored
+		resp, err = resty.R().SetHeader("Cookie", api.SessionCookie+"=e30."+sessionCookie.Value).
+			Get(baseURL + "/v2/_catalog")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		// the requests other than GET and HEAD need the CSRF token
+		resp, err = resty.R().SetHeader("Cookie", cookie).Post(baseURL + "/v2/app/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		resp, err = resty.R().SetHeader("Cookie", cookie).SetHeader(api.CSRFHeader, session.CSRFToken).
+			Post(baseURL + "/v2/app/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+
+		// sessions don't create sessions, only credentials do
+		resp, err = resty.R().SetHeader("Cookie", cookie).SetHeader(api.CSRFHeader, session.CSRFToken).
+			Post(baseURL + "/v2" + api.SessionRoute)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+		So(resp.Cookies(), ShouldBeEmpty)
+
+		resp, err = resty.R().SetHeader("Cookie", cookie).SetHeader(api.CSRFHeader, "wrong").
+			Delete(baseURL + "/v2" + api.SessionRoute)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		resp, err = resty.R().SetHeader("Cookie", cookie).SetHeader(api.CSRFHeader, session.CSRFToken).
+			Delete(baseURL + "/v2" + api.SessionRoute)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 204)
+
+		for _, cookie := range resp.Cookies() {
+			So(cookie.Value, ShouldBeEmpty)
+			So(cookie.MaxAge, ShouldBeLessThan, 0)
+		}
+	})
+
+	Convey("Users log in to read-only registries", t, func() {
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase))
+		defer os.Remove(htpasswdPath)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		trustTestCA()
+		defer func() { resty.SetTLSClientConfig(nil) }()
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.TLS = &api.TLSConfig{Cert: ServerCert, Key: ServerKey}
+			config.HTTP.Auth = &api.AuthConfig{
+				HTPasswd: api.AuthHTPasswd{Path: htpasswdPath},
+				Session:  &api.SessionConfig{},
+			}
+			config.HTTP.ReadOnly = true
+		})
+		defer stopServer(c)
+
+		baseURL = getBaseURL(c.Config.HTTP.Port, true)
+
+		var session api.SessionResponse
+
+		resp, err := resty.R().SetBasicAuth(username, passphrase).SetResult(&session).
+			Post(baseURL + "/v2" + api.SessionRoute)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(session.Expires, ShouldHappenAfter, time.Now().Add(11*time.Hour))
+
+		resp, err = resty.R().SetBasicAuth(username, passphrase).Post(baseURL + "/v2/app/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 405)
+	})
+
+	Convey("Sessions need password authN and TLS", t, func() {
+		config := api.NewConfig()
+		config.HTTP.TLS = &api.TLSConfig{Cert: ServerCert, Key: ServerKey}
+		config.HTTP.Auth = &api.AuthConfig{Session: &api.SessionConfig{}}
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+
+		config.HTTP.Auth.HTPasswd.Path = "htpasswd"
+		So(config.Validate(api.NewController(config).Log), ShouldBeNil)
+
+		config.HTTP.Listeners = []api.ListenerConfig{{Address: "127.0.0.1", Port: "8081"}}
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+
+		config.HTTP.Listeners = nil
+		config.HTTP.TLS = nil
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+	})
+}
+
+func TestNetworkPolicy(t *testing.T) {
+	Convey("Clients only push from the allowed networks", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.Network = &netpolicy.Config{
+				Rules: []netpolicy.RuleConfig{
+					{Operations: []string{"push", "delete"}, Allow: []string{"10.0.0.0/8"}},
+					{Deny: []string{"192.168.0.0/16"}},
+				},
+				TrustedProxies: []string{"127.0.0.1"},
+			}
+			config.Extensions = &extconf.ExtensionConfig{Metrics: &extconf.MetricsConfig{Enable: true}}
+		})
+		defer stopServer(c)
+
+		blob := []byte("blob pushed from the build farm")
+
+		upload := func(forwardedFor string) int {
+			resp, err := resty.R().SetHeader("X-Forwarded-For", forwardedFor).
+				SetHeader("Content-Type", "application/octet-stream").
+				SetQueryParam("digest", godigest.FromBytes(blob).String()).SetBody(blob).
+				Post(baseURL + "/v2/app/blobs/uploads/")
+			So(err, ShouldBeNil)
+
+			return resp.StatusCode()
+		}
+
+		So(upload(""), ShouldEqual, 403)
+		So(upload("10.1.2.3, 172.16.0.1"), ShouldEqual, 403)
+		So(upload("172.16.0.1, 10.1.2.3"), ShouldEqual, 201)
+
+		resp, err := resty.R().Get(baseURL + "/v2/app/blobs/" + godigest.FromBytes(blob).String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = resty.R().SetHeader("X-Forwarded-For", "192.168.1.1").
+			Get(baseURL + "/v2/app/blobs/" + godigest.FromBytes(blob).String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = resty.R().Delete(baseURL + "/v2/app/blobs/" + godigest.FromBytes(blob).String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 403)
+
+		resp, err = resty.R().Get(baseURL + "/metrics")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldContainSubstring, `zot_network_rejected_requests_total{operation="push"} 2`)
+		So(string(resp.Body()), ShouldContainSubstring, `zot_network_rejected_requests_total{operation="pull"} 1`)
+		So(string(resp.Body()), ShouldContainSubstring, `zot_network_rejected_requests_total{operation="delete"} 1`)
+	})
+}
+
+func TestDedupeReport(t *testing.T) {
+	Convey("Dedupe report and rededupe", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.HTTP.AllowAdminAccess = true
+		config.Extensions = &extconf.ExtensionConfig{Search: &extconf.SearchConfig{Enable: true}}
+
+		c := api.NewController(config)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// copied repositories don't share their blobs
+		for _, repo := range []string{"a", "b"} {
+			if err := copyFiles("../../test/data/zot-test", path.Join(dir, repo)); err != nil {
+				panic(err)
+			}
+		}
+
+		c.Config.Storage.RootDirectory = dir
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		var reports map[string]storage.DedupeReport
+
+		resp, err := resty.R().Get(baseURL + "/v2/_zot/admin/dedupe")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &reports), ShouldBeNil)
+
+		report := reports["/"]
+		So(report.Dedupe, ShouldBeTrue)
+		So(report.BlobFiles, ShouldEqual, 2*report.Blobs)
+		So(report.PhysicalBytes, ShouldEqual, report.LogicalBytes)
+		So(report.ReclaimableBytes, ShouldEqual, report.LogicalBytes/2)
+		So(len(report.TopDuplicates), ShouldBeGreaterThan, 0)
+		So(report.TopDuplicates[0].Repos, ShouldResemble, []string{"a", "b"})
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/admin/dedupe?top=1")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &reports), ShouldBeNil)
+		So(len(reports["/"].TopDuplicates), ShouldEqual, 1)
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/admin/dedupe?top=many")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		var results map[string]storage.RededupeResult
+
+		resp, err = resty.R().Post(baseURL + "/v2/_zot/admin/dedupe")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &results), ShouldBeNil)
+		So(results["/"].Linked, ShouldEqual, report.Blobs)
+		So(results["/"].ReclaimedBytes, ShouldEqual, report.ReclaimableBytes)
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/admin/dedupe")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &reports), ShouldBeNil)
+		So(reports["/"].PhysicalBytes, ShouldEqual, report.LogicalBytes/2)
+		So(reports["/"].ReclaimableBytes, ShouldEqual, 0)
+
+		var layers struct {
+			Data struct {
+				LayerListBySharing []struct {
+					Digest     string
+					ImageCount int
+					Repos      []string
+					Copies     int
+					Size       int64
+					SavedBytes int64
+				}
+			}
+		}
+
+		query := `{LayerListBySharing(limit:1){Digest ImageCount Repos Copies Size SavedBytes}}`
+		resp, err = resty.R().Get(baseURL + "/query?query=" + url.QueryEscape(query))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &layers), ShouldBeNil)
+		So(len(layers.Data.LayerListBySharing), ShouldEqual, 1)
+
+		layer := layers.Data.LayerListBySharing[0]
+		So(layer.ImageCount, ShouldEqual, 2)
+		So(layer.Repos, ShouldResemble, []string{"a", "b"})
+		So(layer.Copies, ShouldEqual, 1)
+		So(layer.SavedBytes, ShouldEqual, layer.Size)
+
+		// the images are still served
+		resp, err = resty.R().Get(baseURL + "/v2/b/manifests/0.0.1")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+	})
+}
+
+func TestCVEReport(t *testing.T) {
+	Convey("CVE report of the whole registry", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		for _, repo := range []string{"team/a", "team/b", "other", "unscanned"} {
+			if err := copyFiles("../../test/data/zot-test", path.Join(dir, repo)); err != nil {
+				panic(err)
+			}
+		}
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Extensions = &extconf.ExtensionConfig{Search: &extconf.SearchConfig{Enable: true}}
+		})
+		defer stopServer(c)
+
+		resp, err := resty.R().Head(baseURL + "/v2/other/manifests/0.0.1")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		digest := godigest.Digest(resp.Header().Get(api.DistContentDigestKey))
+
+		for repo, severities := range map[string]map[string]string{
+			"team/a": {"CVE-1": "CRITICAL", "CVE-2": "LOW"},
+			"team/b": {"CVE-2": "LOW"},
+			"other":  {"CVE-3": "HIGH"},
+		} {
+			summary := &storage.ScanSummary{Scanned: time.Now(), CVESeverities: severities}
+			for id := range severities {
+				summary.CVEs = append(summary.CVEs, id)
+			}
+
+			So(c.StoreController.DefaultStore.SetScanSummary(repo, digest, summary), ShouldBeNil)
+		}
+
+		type cveSummary struct {
+			Count       int
+			MaxSeverity string
+			Critical    int
+			High        int
+			Low         int
+		}
+
+		var report struct {
+			Data struct {
+				CVEReport struct {
+					RepoCount        int
+					ScannedRepoCount int
+					CVESummary       cveSummary
+					WorstRepos       []struct {
+						RepoName   string
+						CVESummary cveSummary
+					}
+				}
+			}
+		}
+
+		query := `{CVEReport{RepoCount ScannedRepoCount CVESummary{Count MaxSeverity Critical High Low}` +
+			` WorstRepos{RepoName CVESummary{Count MaxSeverity Critical High Low}}}}`
+		resp, err = resty.R().Get(baseURL + "/query?query=" + url.QueryEscape(query))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &report), ShouldBeNil)
+
+		// a CVE found in several repositories counts once
+		result := report.Data.CVEReport
+		So(result.RepoCount, ShouldEqual, 4)
+		So(result.ScannedRepoCount, ShouldEqual, 3)
+		So(result.CVESummary, ShouldResemble, cveSummary{Count: 3, MaxSeverity: "CRITICAL", Critical: 1, High: 1, Low: 1})
+		So(len(result.WorstRepos), ShouldEqual, 3)
+		So(result.WorstRepos[0].RepoName, ShouldEqual, "team/a")
+		So(result.WorstRepos[0].CVESummary, ShouldResemble,
+			cveSummary{Count: 2, MaxSeverity: "CRITICAL", Critical: 1, Low: 1})
+		So(result.WorstRepos[1].RepoName, ShouldEqual, "other")
+		So(result.WorstRepos[2].RepoName, ShouldEqual, "team/b")
+
+		query = `{CVEReport(prefix:"team/", limit:1){RepoCount ScannedRepoCount CVESummary{Count MaxSeverity}` +
+			` WorstRepos{RepoName}}}`
+		resp, err = resty.R().Get(baseURL + "/query?query=" + url.QueryEscape(query))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &report), ShouldBeNil)
+
+		result = report.Data.CVEReport
+		So(result.RepoCount, ShouldEqual, 2)
+		So(result.ScannedRepoCount, ShouldEqual, 2)
+		So(result.CVESummary.Count, ShouldEqual, 2)
+		So(len(result.WorstRepos), ShouldEqual, 1)
+		So(result.WorstRepos[0].RepoName, ShouldEqual, "team/a")
+	})
+}
+
+func TestGCPlan(t *testing.T) {
+	Convey("GC plan", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		if err := copyFiles("../../test/data/zot-test", path.Join(dir, "a")); err != nil {
+			panic(err)
+		}
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.AllowAdminAccess = true
+		})
+		defer stopServer(c)
+
+		var plans map[string]storage.GCPlan
+
+		// the tag doesn't expire and every blob is referenced
+		resp, err := resty.R().Get(baseURL + "/v2/_zot/admin/gc/plan")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &plans), ShouldBeNil)
+		So(plans["/"].GC, ShouldBeTrue)
+		So(plans["/"].Tags, ShouldBeEmpty)
+		So(plans["/"].Manifests, ShouldBeEmpty)
+		So(plans["/"].Blobs, ShouldBeEmpty)
+		So(plans["/"].ReclaimedBytes, ShouldEqual, 0)
+	})
+}
+
+func TestDiskSpace(t *testing.T) {
+	Convey("Uploads are refused while low on disk space", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		htpasswdPath := makeHtpasswdFile()
+		defer os.Remove(htpasswdPath)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.HTTP.Auth = &api.AuthConfig{HTPasswd: api.AuthHTPasswd{Path: htpasswdPath}}
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		subDir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(subDir)
+
+		// no filesystem has that much free space
+		config.Storage.RootDirectory = dir
+		config.Storage.DiskSpace = &api.DiskSpaceConfig{MinFreeBytes: 1 << 62, EmergencyGC: true}
+		config.Storage.SubPaths = map[string]api.StorageConfig{
+			"/a": {RootDirectory: subDir, DiskSpace: &api.DiskSpaceConfig{MinFreeBytes: 1}},
+		}
+
+		c := api.NewController(config)
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		// the readiness probe doesn't need credentials
+		resp, err := resty.R().Get(baseURL + api.ReadinessRoute)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 503)
+
+		var readiness api.Readiness
+		So(json.Unmarshal(resp.Body(), &readiness), ShouldBeNil)
+		So(readiness, ShouldResemble, api.Readiness{Ready: false, LowDiskSpace: []string{"/"}})
+
+		resp, err = resty.R().Get(baseURL + "/v2/_catalog")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		resp, err = resty.R().SetBasicAuth("test", "test").Post(baseURL + "/v2/repo/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusInsufficientStorage)
+		So(string(resp.Body()), ShouldContainSubstring, "insufficient storage")
+
+		content := []byte("this is a blob")
+		resp, err = resty.R().SetBasicAuth("test", "test").
+			SetHeader("Content-Type", "application/octet-stream").
+			SetQueryParam("digest", godigest.FromBytes(content).String()).
+			SetBody(content).Post(baseURL + "/v2/repo/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusInsufficientStorage)
+
+		// other storage paths have their own threshold
+		resp, err = resty.R().SetBasicAuth("test", "test").Post(baseURL + "/v2/a/repo/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+
+		So(c.StoreController.DefaultStore.DiskSpace().Low, ShouldBeTrue)
+		So(c.StoreController.SubStore["/a"].DiskSpace().Low, ShouldBeFalse)
+		So(c.StoreController.SubStore["/a"].DiskSpace().FreeBytes, ShouldBeGreaterThan, 0)
+	})
+
+	Convey("Ready with enough disk space", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.Storage.DiskSpace = &api.DiskSpaceConfig{MinFreePercent: 100}
+
+		c := api.NewController(config)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c.Config.Storage.RootDirectory = dir
+
+		// thresholds are percentages of the filesystem size
+		So(c.Run(), ShouldEqual, errors.ErrBadConfig)
+
+		config.Storage.DiskSpace = nil
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		resp, err := resty.R().Get(baseURL + api.ReadinessRoute)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldEqual, `{"ready":true}`)
+	})
+}
+
+func TestTiering(t *testing.T) {
+	Convey("Idle layers are moved to the cold tier and recalled on pull", t, func() {
+		port := getFreePort()
+		baseURL := getBaseURL(port, false)
+
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		coldDir, err := ioutil.TempDir("", "oci-cold-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(coldDir)
+
+		if err := copyFiles("../../test/data/zot-test", path.Join(dir, "a")); err != nil {
+			panic(err)
+		}
+
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.HTTP.AllowAdminAccess = true
+		config.Storage.RootDirectory = dir
+		config.Storage.Tiering = &api.TieringConfig{ColdDirectory: dir, ColdAfter: time.Nanosecond}
+
+		c := api.NewController(config)
+
+		// the cold tier can't be the storage itself
+		So(c.Run(), ShouldEqual, errors.ErrBadConfig)
+
+		config.Storage.Tiering.ColdDirectory = coldDir
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		var results map[string]storage.TieringResult
+
+		resp, err := resty.R().Post(baseURL + "/v2/_zot/admin/tiering")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &results), ShouldBeNil)
+		// the periodic pass may have moved it already
+		So(results, ShouldContainKey, "/")
+
+		var reports map[string]storage.TieringReport
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/admin/tiering")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &reports), ShouldBeNil)
+		So(reports["/"].ColdDir, ShouldEqual, coldDir)
+		So(reports["/"].ColdBlobs, ShouldEqual, 1)
+
+		resp, err = resty.R().Get(baseURL + "/v2/a/manifests/0.0.1")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var manifest ispec.Manifest
+		So(json.Unmarshal(resp.Body(), &manifest), ShouldBeNil)
+
+		layer := manifest.Layers[0]
+
+		resp, err = resty.R().Get(baseURL + "/v2/a/blobs/" + layer.Digest.String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(godigest.FromBytes(resp.Body()), ShouldEqual, layer.Digest)
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/admin/tiering")
+		So(err, ShouldBeNil)
+		So(json.Unmarshal(resp.Body(), &reports), ShouldBeNil)
+		So(reports["/"].ColdBlobs, ShouldEqual, 0)
+	})
+}
+
+// trustTestCA makes resty trust the CA of the test server certificate.
+func trustTestCA() {
+	caCert, err := ioutil.ReadFile(CACert)
+	if err != nil {
+		panic(err)
+	}
+
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM(caCert)
+
+	resty.SetTLSClientConfig(&tls.Config{RootCAs: caCertPool})
+}
+
+// startController runs a controller with the storage in dir, and returns it along with its base URL.
+func startController(dir string, configure func(*api.Config)) (*api.Controller, string) {
+	port := getFreePort()
+	baseURL := getBaseURL(port, false)
+
+	config := api.NewConfig()
+	config.HTTP.Port = port
+	config.Storage.RootDirectory = dir
+
+	if configure != nil {
+		configure(config)
+	}
+
+	c := api.NewController(config)
+
+	go func() {
+		// this blocks
+		if err := c.Run(); err != nil {
+			return
+		}
+	}()
+
+	// wait till ready
+	for {
+		_, err := resty.R().Get(baseURL)
+		if err == nil {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return c, baseURL
+}
+
+// pushBlob uploads a blob in a single request.
+func pushBlob(baseURL, repo string, blob []byte) (godigest.Digest, error) {
+	digest := godigest.FromBytes(blob)
+
+	resp, err := resty.R().SetHeader("Content-Type", "application/octet-stream").
+		SetQueryParam("digest", digest.String()).SetBody(blob).Post(baseURL + "/v2/" + repo + "/blobs/uploads/")
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode() != http.StatusCreated {
+		return "", fmt.Errorf("%w: %s", errors.ErrRequestFailed, resp.Status())
+	}
+
+	return digest, nil
+}
+
+func TestDirectIO(t *testing.T) {
+	Convey("Blobs are served with O_DIRECT reads", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Storage.DirectIO = true
+		})
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		blob := make([]byte, 5<<20+17)
+		_, err = rand.Read(blob)
+		So(err, ShouldBeNil)
+
+		digest, err := pushBlob(baseURL, "test", blob)
+		So(err, ShouldBeNil)
+
+		resp, err := http.Get(baseURL + "/v2/test/blobs/" + digest.String())
+		So(err, ShouldBeNil)
+		defer resp.Body.Close()
+
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.ContentLength, ShouldEqual, len(blob))
+
+		content, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(godigest.FromBytes(content), ShouldEqual, digest)
+	})
+}
+
+func BenchmarkGetBlob(b *testing.B) {
+	dir, err := ioutil.TempDir("", "oci-repo-test")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	c, baseURL := startController(dir, func(config *api.Config) {
+		config.Log.Level = "error"
+	})
+
+	defer func() {
+		ctx := context.Background()
+		_ = c.Server.Shutdown(ctx)
+	}()
+
+	blob := make([]byte, 64<<20)
+	if _, err := rand.Read(blob); err != nil {
+		b.Fatal(err)
+	}
+
+	digest, err := pushBlob(baseURL, "test", blob)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, direct := range []bool{false, true} {
+		c.StoreController.DefaultStore.SetDirectIO(direct)
+
+		b.Run(fmt.Sprintf("directIO=%t", direct), func(b *testing.B) {
+			b.SetBytes(int64(len(blob)))
+
+			for i := 0; i < b.N; i++ {
+				resp, err := http.Get(baseURL + "/v2/test/blobs/" + digest.String())
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				_, err = io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestImageList(t *testing.T) {
+	Convey("Images of all stores are listed from the catalog", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		subDir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(subDir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Storage.SubPaths = map[string]api.StorageConfig{"/a": {RootDirectory: subDir}}
+			config.Extensions = &extconf.ExtensionConfig{Search: &extconf.SearchConfig{Enable: true}}
+		})
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		pushImage := func(repo, tag string) {
+			config, err := pushBlob(baseURL, repo, []byte("{}"))
+			So(err, ShouldBeNil)
+
+			layer, err := pushBlob(baseURL, repo, []byte("this is a layer"))
+			So(err, ShouldBeNil)
+
+			manifest := ispec.Manifest{
+				Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: config, Size: 2},
+				Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: layer, Size: 15}},
+			}
+			manifest.SchemaVersion = 2
+			mb, err := json.Marshal(manifest)
+			So(err, ShouldBeNil)
+
+			resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).SetBody(mb).
+				Put(baseURL + "/v2/" + repo + "/manifests/" + tag)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 201)
+		}
+
+		type imageList struct {
+			Data struct {
+				ImageList []struct {
+					RepoName string
+					Tag      string
+					Size     int
+				}
+			}
+		}
+
+		listImages := func(query string) imageList {
+			resp, err := resty.R().Get(baseURL + "/query?query=" + url.QueryEscape(query))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+
+			var list imageList
+			So(json.Unmarshal(resp.Body(), &list), ShouldBeNil)
+
+			return list
+		}
+
+		pushImage("test", "1.0")
+		pushImage("a/test", "1.0")
+
+		list := listImages("{ImageList{RepoName Tag Size}}")
+		So(len(list.Data.ImageList), ShouldEqual, 2)
+		So(list.Data.ImageList[0].RepoName, ShouldEqual, "a/test")
+		So(list.Data.ImageList[1].RepoName, ShouldEqual, "test")
+		So(list.Data.ImageList[1].Tag, ShouldEqual, "1.0")
+		So(list.Data.ImageList[1].Size, ShouldBeGreaterThan, 17)
+
+		// images pushed after the catalog was loaded are listed too
+		pushImage("a/test", "2.0")
+
+		list = listImages(`{ImageList(repo:"a/test"){RepoName Tag}}`)
+		So(len(list.Data.ImageList), ShouldEqual, 2)
+		So(list.Data.ImageList[1].Tag, ShouldEqual, "2.0")
+
+		resp, err := resty.R().Delete(baseURL + "/v2/test/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+
+		list = listImages(`{ImageList(repo:"test"){RepoName Tag}}`)
+		So(list.Data.ImageList, ShouldBeEmpty)
+
+		Convey("Images built by stacker have their build info and are found by git version", func() {
+			config, err := pushBlob(baseURL, "test", []byte("{}"))
+			So(err, ShouldBeNil)
+
+			manifest := ispec.Manifest{
+				Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: config, Size: 2},
+				Annotations: map[string]string{
+					storage.StackerYamlAnnotation:       "build:\n  from:\n    type: docker",
+					storage.StackerGitVersionAnnotation: "v1.0-2-g0123456",
+				},
+			}
+			manifest.SchemaVersion = 2
+			mb, err := json.Marshal(manifest)
+			So(err, ShouldBeNil)
+
+			resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).SetBody(mb).
+				Put(baseURL + "/v2/test/manifests/2.0")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 201)
+
+			var builds struct {
+				Data struct {
+					ImageList []struct {
+						Tag       string
+						BuildInfo *struct {
+							StackerYaml string
+							GitVersion  string
+						}
+					}
+					ImageListForGitVersion []struct {
+						Name string
+						Tags []string
+					}
+				}
+			}
+
+			query := `{ImageList{Tag BuildInfo{StackerYaml GitVersion}} ` +
+				`ImageListForGitVersion(version:"v1.0-2-g0123456"){Name Tags}}`
+			resp, err = resty.R().Get(baseURL + "/query?query=" + url.QueryEscape(query))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+			So(json.Unmarshal(resp.Body(), &builds), ShouldBeNil)
+
+			So(len(builds.Data.ImageList), ShouldEqual, 3)
+			So(builds.Data.ImageList[0].BuildInfo, ShouldBeNil)
+			So(builds.Data.ImageList[2].Tag, ShouldEqual, "2.0")
+			So(builds.Data.ImageList[2].BuildInfo, ShouldNotBeNil)
+			So(builds.Data.ImageList[2].BuildInfo.GitVersion, ShouldEqual, "v1.0-2-g0123456")
+			So(builds.Data.ImageList[2].BuildInfo.StackerYaml, ShouldEqual, "build:\n  from:\n    type: docker")
+
+			So(len(builds.Data.ImageListForGitVersion), ShouldEqual, 1)
+			So(builds.Data.ImageListForGitVersion[0].Name, ShouldEqual, "test")
+			So(builds.Data.ImageListForGitVersion[0].Tags, ShouldResemble, []string{"2.0"})
+		})
+
+		Convey("The newest tag in a version range is found", func() {
+			pushImage("a/test", "1.2.0")
+			pushImage("a/test", "1.10.0")
+			pushImage("a/test", "3.0.0-rc.1")
+			pushImage("a/test", "latest")
+
+			latestTag := func(constraint string) (*string, []interface{}) {
+				var latest struct {
+					Data struct {
+						ImageLatestTag *struct {
+							RepoName string
+							Tag      string
+						}
+					}
+					Errors []interface{}
+				}
+
+				query := `{ImageLatestTag(repo:"a/test"` + constraint + `){RepoName Tag}}`
+				resp, err := resty.R().Get(baseURL + "/query?query=" + url.QueryEscape(query))
+				So(err, ShouldBeNil)
+				So(resp.StatusCode(), ShouldEqual, 200)
+				So(json.Unmarshal(resp.Body(), &latest), ShouldBeNil)
+
+				if latest.Data.ImageLatestTag == nil {
+					return nil, latest.Errors
+				}
+
+				So(latest.Data.ImageLatestTag.RepoName, ShouldEqual, "a/test")
+
+				return &latest.Data.ImageLatestTag.Tag, latest.Errors
+			}
+
+			tag, errs := latestTag("")
+			So(errs, ShouldBeEmpty)
+			So(*tag, ShouldEqual, "2.0")
+
+			tag, errs = latestTag(`, constraint:"^1.2"`)
+			So(errs, ShouldBeEmpty)
+			So(*tag, ShouldEqual, "1.10.0")
+
+			tag, errs = latestTag(`, constraint:">=3.0.0-rc.0"`)
+			So(errs, ShouldBeEmpty)
+			So(*tag, ShouldEqual, "3.0.0-rc.1")
+
+			tag, errs = latestTag(`, constraint:"^3"`)
+			So(errs, ShouldBeEmpty)
+			So(tag, ShouldBeNil)
+
+			_, errs = latestTag(`, constraint:"latest"`)
+			So(errs, ShouldNotBeEmpty)
+		})
+	})
+}
+
+func TestShortDigest(t *testing.T) {
+	Convey("Manifests are pulled by short digest", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, nil)
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		config, err := pushBlob(baseURL, "test", []byte("{}"))
+		So(err, ShouldBeNil)
+
+		manifest := ispec.Manifest{
+			Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: config, Size: 2},
+			Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: config, Size: 2}},
+		}
+		manifest.SchemaVersion = 2
+		mb, err := json.Marshal(manifest)
+		So(err, ShouldBeNil)
+
+		digest := godigest.FromBytes(mb)
+
+		resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).SetBody(mb).
+			Put(baseURL + "/v2/test/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+
+		for _, reference := range []string{digest.Encoded()[:7], digest.String()[:16]} {
+			resp, err = resty.R().Get(baseURL + "/v2/test/manifests/" + reference)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+			So(resp.Body(), ShouldResemble, mb)
+			So(resp.Header().Get(api.DistContentDigestKey), ShouldEqual, digest.String())
+
+			resp, err = resty.R().Head(baseURL + "/v2/test/manifests/" + reference)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+			So(resp.Header().Get(api.DistContentDigestKey), ShouldEqual, digest.String())
+		}
+
+		// too short to be a digest
+		resp, err = resty.R().Get(baseURL + "/v2/test/manifests/" + digest.Encoded()[:6])
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		// tags take precedence over short digests
+		other, err := json.Marshal(ispec.Manifest{Versioned: manifest.Versioned, Config: manifest.Config})
+		So(err, ShouldBeNil)
+
+		resp, err = resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).SetBody(other).
+			Put(baseURL + "/v2/test/manifests/" + digest.Encoded()[:8])
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+
+		resp, err = resty.R().Get(baseURL + "/v2/test/manifests/" + digest.Encoded()[:8])
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get(api.DistContentDigestKey), ShouldEqual, godigest.FromBytes(other).String())
+
+		// short digests only resolve manifests
+		resp, err = resty.R().Get(baseURL + "/v2/test/manifests/" + config.Encoded()[:12])
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+	})
+}
+
+func TestGRPC(t *testing.T) {
+	Convey("Search and admin services are served over gRPC", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase) + "\n" +
+			getCredString("reader", "reader") + "\n")
+		defer os.Remove(htpasswdPath)
+
+		grpcPort := getFreePort()
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.Auth = &api.AuthConfig{
+				HTPasswd:   api.AuthHTPasswd{Path: htpasswdPath},
+				AdminUsers: []string{username},
+			}
+			config.HTTP.GRPC = &api.ListenerConfig{Address: "127.0.0.1", Port: grpcPort}
+			config.Extensions = &extconf.ExtensionConfig{Search: &extconf.SearchConfig{Enable: true}}
+		})
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		resty.SetBasicAuth(username, passphrase)
+		defer resty.SetBasicAuth("", "")
+
+		config, err := pushBlob(baseURL, "test", []byte("{}"))
+		So(err, ShouldBeNil)
+
+		manifest := ispec.Manifest{
+			Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: config, Size: 2},
+			Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: config, Size: 2}},
+		}
+		manifest.SchemaVersion = 2
+		mb, err := json.Marshal(manifest)
+		So(err, ShouldBeNil)
+
+		resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).SetBody(mb).
+			Put(baseURL + "/v2/test/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+
+		conn, err := grpc.Dial("127.0.0.1:"+grpcPort, grpc.WithInsecure())
+		So(err, ShouldBeNil)
+		defer conn.Close()
+
+		withCreds := func(user, password string) context.Context {
+			creds := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+			return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Basic "+creds)
+		}
+
+		search := rpc.NewSearchClient(conn)
+		admin := rpc.NewAdminClient(conn)
+
+		_, err = search.ImageList(context.Background(), &rpc.ImageListRequest{})
+		So(status.Code(err), ShouldEqual, grpcCodes.Unauthenticated)
+
+		images, err := search.ImageList(withCreds("reader", "reader"), &rpc.ImageListRequest{Repo: "test"})
+		So(err, ShouldBeNil)
+		So(len(images.Images), ShouldEqual, 1)
+		So(images.Images[0].Tag, ShouldEqual, "1.0")
+		So(images.Images[0].Digest, ShouldEqual, godigest.FromBytes(mb).String())
+
+		digests, err := search.ImageListForDigest(withCreds("reader", "reader"),
+			&rpc.ImageListForDigestRequest{Id: config.Encoded()[:8]})
+		So(err, ShouldBeNil)
+		So(len(digests.Images), ShouldEqual, 1)
+		So(digests.Images[0].Tags, ShouldResemble, []string{"1.0"})
+
+		// admin methods are restricted to admin users
+		_, err = admin.GetSchedulerStatus(withCreds("reader", "reader"), &rpc.GetSchedulerStatusRequest{})
+		So(status.Code(err), ShouldEqual, grpcCodes.PermissionDenied)
+
+		schedulerStatus, err := admin.GetSchedulerStatus(withCreds(username, passphrase),
+			&rpc.GetSchedulerStatusRequest{})
+		So(err, ShouldBeNil)
+		So(schedulerStatus.MaxConcurrentTasks, ShouldBeGreaterThan, 0)
+
+		_, err = admin.SetDeprecation(withCreds(username, passphrase),
+			&rpc.SetDeprecationRequest{Repo: "test", Tag: "1.0", Message: "end of life", Replacement: "test:2.0"})
+		So(err, ShouldBeNil)
+
+		resp, err = resty.R().Get(baseURL + "/v2/test/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.Header().Get(api.WarningHeader), ShouldContainSubstring, "end of life")
+
+		_, err = admin.SetDeprecation(withCreds(username, passphrase),
+			&rpc.SetDeprecationRequest{Repo: "unknown", Message: "end of life"})
+		So(status.Code(err), ShouldEqual, grpcCodes.NotFound)
+
+		_, err = admin.DeleteDeprecation(withCreds(username, passphrase),
+			&rpc.DeleteDeprecationRequest{Repo: "test", Tag: "1.0"})
+		So(err, ShouldBeNil)
+
+		syncStatus, err := admin.GetSyncStatus(withCreds(username, passphrase), &rpc.GetSyncStatusRequest{})
+		So(err, ShouldBeNil)
+		So(syncStatus.Registries, ShouldBeEmpty)
+
+		reports, err := admin.GetDedupeReport(withCreds(username, passphrase), &rpc.GetDedupeReportRequest{})
+		So(err, ShouldBeNil)
+		So(reports.Stores["/"].RootDir, ShouldEqual, dir)
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	Convey("The effective config is resolved without serving", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		config := api.NewConfig()
+		config.HTTP.Port = getFreePort()
+		config.Storage.RootDirectory = dir
+		config.Storage.Tiering = &api.TieringConfig{ColdDirectory: path.Join(dir, "cold"), ColdAfter: time.Hour}
+		config.Storage.DiskSpace = &api.DiskSpaceConfig{MinFreePercent: 10}
+		config.Storage.SubPaths = map[string]api.StorageConfig{"/a": {RootDirectory: path.Join(dir, "a")}}
+		config.HTTP.Auth = &api.AuthConfig{
+			LDAP:    &api.LDAPConfig{UserAttribute: "uid", BindPassword: "secret"},
+			Webhook: &api.WebhookConfig{URL: "http://127.0.0.1:9999/authz"},
+		}
+		config.Extensions = &extconf.ExtensionConfig{
+			Search:  &extconf.SearchConfig{Enable: true, CVE: &extconf.CVEConfig{UpdateInterval: time.Hour}},
+			Tracing: &extconf.TracingConfig{Enable: true},
+			Sync: &sync.Config{Enable: true, Registries: []sync.RegistryConfig{
+				{URL: "https://mirror.example.com", Password: "secret"},
+			}},
+		}
+
+		c := api.NewController(config)
+
+		effective, err := c.DryRun()
+		So(err, ShouldBeNil)
+		So(effective.HTTP.Auth.LDAP.BindPassword, ShouldEqual, "******")
+		So(effective.HTTP.Auth.Webhook.Timeout, ShouldEqual, 5*time.Second)
+		So(effective.Storage.Tiering.Interval, ShouldEqual, 24*time.Hour)
+		So(effective.Storage.DiskSpace.CheckInterval, ShouldEqual, time.Minute)
+		So(effective.Storage.SubPaths["/a"].DiskSpace.MinFreePercent, ShouldEqual, 10)
+		So(effective.Scheduler.MaxConcurrentTasks, ShouldEqual, 2)
+		So(effective.Extensions.Search.CVE.UpdateInterval, ShouldEqual, 2*time.Hour)
+		So(effective.Extensions.Tracing.ServiceName, ShouldEqual, "zot")
+		So(effective.Extensions.Sync.Enable, ShouldBeTrue)
+		So(effective.Extensions.Sync.Registries[0].Password, ShouldEqual, "******")
+		So(effective.Extensions.Sync.Registries[0].MaxRetries, ShouldEqual, 3)
+		So(*effective.Extensions.Sync.Registries[0].TLSVerify, ShouldBeTrue)
+
+		// the config of the controller is left untouched
+		So(config.HTTP.Auth.LDAP.BindPassword, ShouldEqual, "secret")
+		So(config.HTTP.Auth.Webhook.Timeout, ShouldEqual, 0)
+		So(config.Scheduler, ShouldBeNil)
+
+		// the storage isn't initialized
+		_, err = os.Stat(path.Join(dir, "a"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		Convey("Extensions which can't be set up are disabled", func() {
+			config.Extensions.Sync.Registries[0].CVEPolicy = &sync.CVEPolicyConfig{Severity: "HIGH"}
+			config.Extensions.Search.CVE = nil
+
+			effective, err := c.DryRun()
+			So(err, ShouldBeNil)
+			So(effective.Extensions.Sync.Enable, ShouldBeFalse)
+			So(config.Extensions.Sync.Enable, ShouldBeTrue)
+		})
+
+		Convey("The dedupe mode is resolved to the links the filesystem supports", func() {
+			config.Storage.Dedupe = true
+			config.Storage.DedupeMode = storage.DedupeAuto
+
+			effective, err := c.DryRun()
+			So(err, ShouldBeNil)
+			So(effective.Storage.Dedupe, ShouldBeTrue)
+
+			if storage.ValidateReflink(dir) == nil {
+				So(effective.Storage.DedupeMode, ShouldEqual, storage.DedupeReflink)
+			} else {
+				So(effective.Storage.DedupeMode, ShouldEqual, storage.DedupeHardLink)
+			}
+
+			config.Storage.DedupeMode = "symlink"
+			So(config.Validate(c.Log), ShouldEqual, errors.ErrBadConfig)
+		})
+
+		Convey("Invalid files are reported", func() {
+			config.HTTP.TLS = &api.TLSConfig{Cert: ServerCert, Key: path.Join(dir, "missing.key")}
+
+			_, err := c.DryRun()
+			So(err, ShouldNotBeNil)
+
+			config.HTTP.TLS = nil
+			config.Storage.Encryption = &api.EncryptionConfig{KeyFile: path.Join(dir, "missing.key")}
+
+			_, err = c.DryRun()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestBackupAdmin(t *testing.T) {
+	Convey("Snapshots of the image stores are taken through the admin API", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		subDir, err := ioutil.TempDir("", "oci-sub-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(subDir)
+
+		backupDir, err := ioutil.TempDir("", "oci-backup-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(backupDir)
+
+		So(copyFiles("../../test/data/zot-test", path.Join(dir, "a")), ShouldBeNil)
+		So(copyFiles("../../test/data/zot-test", path.Join(subDir, "sub/b")), ShouldBeNil)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.AllowAdminAccess = true
+			config.Storage.SubPaths = map[string]api.StorageConfig{"/sub": {RootDirectory: subDir}}
+			config.Storage.Backup = &api.BackupConfig{Directory: backupDir}
+		})
+		defer stopServer(c)
+
+		var reports map[string]storage.BackupReport
+
+		resp, err := resty.R().Post(baseURL + "/v2/_zot/admin/backup")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &reports), ShouldBeNil)
+		So(reports["/"].BackupDir, ShouldEqual, path.Join(backupDir, "_default"))
+		So(reports["/"].Repos, ShouldEqual, 1)
+		So(reports["/"].CopiedBlobs, ShouldBeGreaterThan, 0)
+		So(reports["/sub"].BackupDir, ShouldEqual, path.Join(backupDir, "sub"))
+		So(reports["/sub"].Repos, ShouldEqual, 1)
+
+		resp, err = resty.R().Post(baseURL + "/v2/_zot/admin/backup")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &reports), ShouldBeNil)
+		So(reports["/"].CopiedBlobs, ShouldEqual, 0)
+		So(reports["/"].SkippedBlobs, ShouldEqual, reports["/"].Blobs)
+
+		resp, err = resty.R().Post(baseURL + "/v2/_zot/admin/backup?full=true")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &reports), ShouldBeNil)
+		So(reports["/"].CopiedBlobs, ShouldEqual, reports["/"].Blobs)
+
+		resp, err = resty.R().Post(baseURL + "/v2/_zot/admin/backup?full=maybe")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		var statuses map[string]api.BackupStatus
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/admin/backup")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &statuses), ShouldBeNil)
+		So(len(statuses["/"].Snapshots), ShouldEqual, 3)
+		So(len(statuses["/sub"].Snapshots), ShouldEqual, 3)
+
+		restoreDir, err := ioutil.TempDir("", "oci-restore-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(restoreDir)
+
+		restored, err := storage.Restore(path.Join(backupDir, "_default"), "", restoreDir, true, c.Log)
+		So(err, ShouldBeNil)
+		So(restored.Snapshot, ShouldEqual, statuses["/"].Snapshots[2])
+		So(restored.Repos, ShouldEqual, 1)
+	})
+
+	Convey("The backup routes are only served if backups are configured", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, nil)
+		defer stopServer(c)
+
+		resp, err := resty.R().Post(baseURL + "/v2/_zot/admin/backup")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldNotEqual, 200)
+	})
+}
+
+func TestChangefeed(t *testing.T) {
+	Convey("The changes of the repositories are served from a cursor", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		subDir, err := ioutil.TempDir("", "oci-sub-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(subDir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Storage.SubPaths = map[string]api.StorageConfig{"/sub": {RootDirectory: subDir}}
+			config.Storage.Changefeed = &api.ChangefeedConfig{}
+		})
+		defer stopServer(c)
+
+		pushTestImage(baseURL, "a", "1.0")
+		pushTestImage(baseURL, "sub/b", "1.0")
+
+		resp, err := resty.R().Delete(baseURL + "/v2/a/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+
+		var page api.ChangefeedPage
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/ext/changefeed?n=2")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Link"), ShouldEqual, "/v2/_zot/ext/changefeed?cursor=2&n=2; rel=\"next\"")
+		So(json.Unmarshal(resp.Body(), &page), ShouldBeNil)
+		So(page.Cursor, ShouldEqual, 2)
+		So(len(page.Events), ShouldEqual, 2)
+		So(page.Events[0].Kind, ShouldEqual, storage.ChangePush)
+		So(page.Events[0].Repo, ShouldEqual, "a")
+		So(page.Events[1].Repo, ShouldEqual, "sub/b")
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/ext/changefeed?cursor=2&n=2")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Link"), ShouldBeEmpty)
+		So(json.Unmarshal(resp.Body(), &page), ShouldBeNil)
+		So(page.Cursor, ShouldEqual, 3)
+		So(len(page.Events), ShouldEqual, 1)
+		So(page.Events[0].Kind, ShouldEqual, storage.ChangeDelete)
+		So(page.Events[0].Tag, ShouldEqual, "1.0")
+
+		for _, query := range []string{"cursor=-1", "n=0", "n=5000", "n=many"} {
+			resp, err = resty.R().Get(baseURL + "/v2/_zot/ext/changefeed?" + query)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 400)
+		}
+
+		resp, err = resty.R().Get(baseURL + "/v2/_zot/ext/changefeed?cursor=42")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 410)
+	})
+}
+
+func TestMediaTypes(t *testing.T) {
+	Convey("Repositories under a prefix only accept the allowed media types", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Storage.MediaTypes = map[string]api.MediaTypeRule{
+				"prod/":       {Configs: []string{ispec.MediaTypeImageConfig}},
+				"charts":      {Configs: []string{"application/vnd.cncf.helm.*"}},
+				"charts/oci/": {Configs: []string{}},
+			}
+		})
+		defer stopServer(c)
+
+		pushTestImage(baseURL, "prod/app", "1.0")
+		pushTestImage(baseURL, "charts/oci/app", "1.0")
+		pushTestImage(baseURL, "dev/app", "1.0")
+		// a prefix matches whole path components
+		pushTestImage(baseURL, "chartsandmore", "1.0")
+
+		chart := []byte("{}")
+		chartDigest := pushTestBlob(baseURL, "charts/nginx", chart)
+		layer := []byte("chart")
+		layerDigest := pushTestBlob(baseURL, "charts/nginx", layer)
+
+		m := ispec.Manifest{
+			Config: ispec.Descriptor{
+				MediaType: "application/vnd.cncf.helm.config.v1+json",
+				Digest:    chartDigest,
+				Size:      int64(len(chart)),
+			},
+			Layers: []ispec.Descriptor{
+				{
+					MediaType: "application/vnd.cncf.helm.chart.content.v1.tar+gzip",
+					Digest:    layerDigest,
+					Size:      int64(len(layer)),
+				},
+			},
+		}
+		m.SchemaVersion = 2
+		content, err := json.Marshal(m)
+		So(err, ShouldBeNil)
+
+		resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+			SetBody(content).Put(baseURL + "/v2/charts/nginx/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+
+		// charts aren't images
+		resp, err = resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+			SetBody(content).Put(baseURL + "/v2/prod/app/manifests/chart")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 415)
+		So(string(resp.Body()), ShouldContainSubstring, "UNSUPPORTED")
+		So(string(resp.Body()), ShouldContainSubstring, "application/vnd.cncf.helm.config.v1+json")
+		So(string(resp.Body()), ShouldContainSubstring, ispec.MediaTypeImageConfig)
+
+		resp, err = resty.R().Get(baseURL + "/v2/prod/app/manifests/chart")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		// images aren't charts
+		resp, err = resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+			SetBody(pushTestImage(baseURL, "dev/other", "1.0")).Put(baseURL + "/v2/charts/nginx/manifests/image")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 415)
+	})
+
+	Convey("Invalid media type patterns are rejected", t, func() {
+		config := api.NewConfig()
+		config.Storage.MediaTypes = map[string]api.MediaTypeRule{"prod": {Manifests: []string{"[oci"}}}
+		So(config.Validate(api.NewController(config).Log), ShouldEqual, errors.ErrBadConfig)
+	})
+}
+
+func TestDockerManifests(t *testing.T) {
+	Convey("Docker manifests are pushed and negotiated on pull", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, nil)
+		defer stopServer(c)
+
+		config := []byte(`{"architecture":"amd64","os":"linux"}`)
+		configDigest := pushTestBlob(baseURL, "docker", config)
+		layer := []byte("docker layer")
+		layerDigest := pushTestBlob(baseURL, "docker", layer)
+
+		content, err := json.Marshal(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     storage.DockerManifestMediaType,
+			"config": ispec.Descriptor{MediaType: storage.DockerConfigMediaType, Digest: configDigest,
+				Size: int64(len(config))},
+			"layers": []ispec.Descriptor{
+				{MediaType: storage.DockerLayerMediaType, Digest: layerDigest, Size: int64(len(layer))},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		resp, err := resty.R().SetHeader("Content-Type", storage.DockerManifestMediaType).
+			SetBody(content).Put(baseURL + "/v2/docker/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+		digest := resp.Header().Get("Docker-Content-Digest")
+		So(digest, ShouldEqual, godigest.FromBytes(content).String())
+
+		resp, err = resty.R().SetHeader("Accept", storage.DockerManifestMediaType+", "+ispec.MediaTypeImageManifest).
+			Get(baseURL + "/v2/docker/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Content-Type"), ShouldEqual, storage.DockerManifestMediaType)
+		So(resp.Body(), ShouldResemble, content)
+
+		// clients which only accept OCI manifests get a converted one
+		resp, err = resty.R().SetHeader("Accept", ispec.MediaTypeImageManifest).
+			Get(baseURL + "/v2/docker/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Content-Type"), ShouldEqual, ispec.MediaTypeImageManifest)
+		So(resp.Header().Get("Docker-Content-Digest"), ShouldNotEqual, digest)
+
+		var converted ispec.Manifest
+		So(json.Unmarshal(resp.Body(), &converted), ShouldBeNil)
+		So(converted.Config.MediaType, ShouldEqual, ispec.MediaTypeImageConfig)
+		So(converted.Layers[0].MediaType, ShouldEqual, ispec.MediaTypeImageLayerGzip)
+
+		list, err := json.Marshal(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     storage.DockerManifestListMediaType,
+			"manifests": []ispec.Descriptor{
+				{MediaType: storage.DockerManifestMediaType, Digest: godigest.Digest(digest), Size: int64(len(content)),
+					Platform: &ispec.Platform{OS: "linux", Architecture: "amd64"}},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		resp, err = resty.R().SetHeader("Content-Type", storage.DockerManifestListMediaType).
+			SetBody(list).Put(baseURL + "/v2/docker/manifests/latest")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+
+		resp, err = resty.R().Get(baseURL + "/v2/docker/manifests/latest")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Content-Type"), ShouldEqual, storage.DockerManifestListMediaType)
+		So(resp.Body(), ShouldResemble, list)
+	})
+}
+
+func TestManifestConversion(t *testing.T) {
+	Convey("Manifests are converted to the format the client accepts", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, nil)
+		defer stopServer(c)
+
+		content := pushTestImage(baseURL, "app", "1.0")
+		digest := godigest.FromBytes(content)
+
+		index, err := json.Marshal(ispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			Manifests: []ispec.Descriptor{
+				{MediaType: ispec.MediaTypeImageManifest, Digest: digest, Size: int64(len(content))},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageIndex).
+			SetBody(index).Put(baseURL + "/v2/app/manifests/latest")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 201)
+
+		// Docker clients which don't accept OCI manifests get Docker ones
+		dockerAccept := storage.DockerManifestMediaType + ", " + storage.DockerManifestListMediaType
+
+		resp, err = resty.R().SetHeader("Accept", dockerAccept).Head(baseURL + "/v2/app/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Content-Type"), ShouldEqual, storage.DockerManifestMediaType)
+		So(resp.Header().Get(api.OriginalContentDigestKey), ShouldEqual, digest.String())
+		convertedDigest := resp.Header().Get(api.DistContentDigestKey)
+		So(convertedDigest, ShouldNotEqual, digest.String())
+
+		resp, err = resty.R().SetHeader("Accept", dockerAccept).Get(baseURL + "/v2/app/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get(api.DistContentDigestKey), ShouldEqual, convertedDigest)
+		So(godigest.FromBytes(resp.Body()).String(), ShouldEqual, convertedDigest)
+
+		var manifest ispec.Manifest
+		So(json.Unmarshal(resp.Body(), &manifest), ShouldBeNil)
+		So(manifest.Config.MediaType, ShouldEqual, storage.DockerConfigMediaType)
+
+		// the converted manifest is pulled by its digest
+		resp, err = resty.R().SetHeader("Accept", dockerAccept).Get(baseURL + "/v2/app/manifests/" + convertedDigest)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Content-Type"), ShouldEqual, storage.DockerManifestMediaType)
+		So(godigest.FromBytes(resp.Body()).String(), ShouldEqual, convertedDigest)
+
+		resp, err = resty.R().SetHeader("Accept", dockerAccept).Get(baseURL + "/v2/app/manifests/latest")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Content-Type"), ShouldEqual, storage.DockerManifestListMediaType)
+		So(resp.Header().Get(api.OriginalContentDigestKey), ShouldEqual, godigest.FromBytes(index).String())
+
+		var list ispec.Index
+		So(json.Unmarshal(resp.Body(), &list), ShouldBeNil)
+		So(list.Manifests[0].Digest.String(), ShouldEqual, convertedDigest)
+
+		// the stored manifest is served to the clients which accept it
+		resp, err = resty.R().Get(baseURL + "/v2/app/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.Body(), ShouldResemble, content)
+		So(resp.Header().Get(api.OriginalContentDigestKey), ShouldBeEmpty)
+
+		resp, err = resty.R().Delete(baseURL + "/v2/app/manifests/" + digest.String())
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+
+		resp, err = resty.R().Get(baseURL + "/v2/app/manifests/" + convertedDigest)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+	})
+}
+
+func TestSearchLimits(t *testing.T) {
+	Convey("Search queries are authenticated, and limited in complexity and rate", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		htpasswdPath := makeHtpasswdFileFromString(getCredString(username, passphrase))
+		defer os.Remove(htpasswdPath)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.HTTP.Auth = &api.AuthConfig{HTPasswd: api.AuthHTPasswd{Path: htpasswdPath}}
+			config.HTTP.AllowReadAccess = true
+			config.HTTP.ReadOnly = true
+			config.Extensions = &extconf.ExtensionConfig{Search: &extconf.SearchConfig{
+				Enable: true,
+				Query:  &extconf.QueryConfig{MaxComplexity: 50, RateLimit: 0.01, RateBurst: 3},
+			}}
+		})
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		// other tests set credentials on the default client
+		client := resty.New()
+		query := `{ImageListByPopularity(limit:1){Name}}`
+
+		// queries are reads, whichever their method
+		resp, err := client.R().SetHeader("Content-Type", "application/json").
+			SetBody(map[string]string{"query": query}).Post(baseURL + "/query")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = client.R().SetBasicAuth(username, "wrong").Get(baseURL + "/query?query=" + url.QueryEscape(query))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		// 1 + 10 * (Name + Count + Tags{Name Count}) exceeds 50
+		complex := `{ImageListByPopularity{Name Count Tags{Name Count}}}`
+		resp, err = client.R().Get(baseURL + "/query?query=" + url.QueryEscape(complex))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldContainSubstring, "COMPLEXITY_LIMIT_EXCEEDED")
+
+		limited := `{ImageListByPopularity(limit:2){Name Count Tags{Name Count}}}`
+		resp, err = client.R().Get(baseURL + "/query?query=" + url.QueryEscape(limited))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldNotContainSubstring, "COMPLEXITY_LIMIT_EXCEEDED")
+
+		resp, err = client.R().Get(baseURL + "/query?query=" + url.QueryEscape(query))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 429)
+		So(resp.Header().Get("Retry-After"), ShouldNotBeEmpty)
+
+		// users are limited on their own
+		resp, err = client.R().SetBasicAuth(username, passphrase).Get(baseURL + "/query?query=" + url.QueryEscape(query))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		// 1 + 10 * (RepoCount + ScannedRepoCount + CVESummary{Count MaxSeverity}) exceeds 50
+		report := `{CVEReport{RepoCount ScannedRepoCount CVESummary{Count MaxSeverity}}}`
+		resp, err = client.R().SetBasicAuth(username, passphrase).Get(baseURL + "/query?query=" + url.QueryEscape(report))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldContainSubstring, "COMPLEXITY_LIMIT_EXCEEDED")
+
+		// 2 * (1 + 10 * (Digest + PreviousDigest + Time)) exceeds 50
+		history := `{a:TagHistory(repo:"app",tag:"1.0"){Digest PreviousDigest Time} ` +
+			`b:TagHistory(repo:"app",tag:"2.0"){Digest PreviousDigest Time}}`
+		resp, err = client.R().SetBasicAuth(username, passphrase).Get(baseURL + "/query?query=" + url.QueryEscape(history))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldContainSubstring, "COMPLEXITY_LIMIT_EXCEEDED")
+	})
+}
+
+func TestSearchCache(t *testing.T) {
+	Convey("Search results have an ETag and are cached until the repositories change", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		c, baseURL := startController(dir, func(config *api.Config) {
+			config.Extensions = &extconf.ExtensionConfig{Search: &extconf.SearchConfig{Enable: true}}
+		})
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		pushTestImage(baseURL, "app", "1.0")
+
+		queryURL := baseURL + "/query?query=" + url.QueryEscape(`{ImageList(repo:"app"){Tag}}`)
+
+		resp, err := resty.R().Get(queryURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldContainSubstring, `"1.0"`)
+
+		etag := resp.Header().Get("ETag")
+		So(etag, ShouldNotBeEmpty)
+
+		resp, err = resty.R().SetHeader("If-None-Match", etag).Get(queryURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 304)
+		So(resp.Body(), ShouldBeEmpty)
+
+		// pushes invalidate the cached results
+		pushTestImage(baseURL, "app", "2.0")
+
+		resp, err = resty.R().SetHeader("If-None-Match", etag).Get(queryURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldContainSubstring, `"2.0"`)
+		So(resp.Header().Get("ETag"), ShouldNotEqual, etag)
+
+		// as do deletes
+		resp, err = resty.R().Delete(baseURL + "/v2/app/manifests/2.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+
+		resp, err = resty.R().Get(queryURL)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldNotContainSubstring, `"2.0"`)
+		So(resp.Header().Get("ETag"), ShouldEqual, etag)
+	})
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffers readers are copied into responses with.
+const copyBufferSize = 1 << 20
+
+// nolint: gochecknoglobals
+var copyBuffers = sync.Pool{New: func() interface{} {
+	buf := make([]byte, copyBufferSize)
+	return &buf
+}}
+
+// writerOnly hides the io.ReaderFrom of the response.
+type writerOnly struct {
+	io.Writer
+}
+
+// copyToResponse copies the reader into the response. Files are handed to the response as they are,
+// so that they're sent with sendfile on plain TCP connections, other readers are copied with large
+// buffers instead of the small ones of the response.
+func copyToResponse(w io.Writer, reader io.Reader) error {
+	if _, ok := reader.(*os.File); ok {
+		_, err := io.Copy(w, reader)
+		return err
+	}
+
+	buf, _ := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	_, err := io.CopyBuffer(writerOnly{w}, reader, *buf)
+
+	return err
+}
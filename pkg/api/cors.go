@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+)
+
+// nolint: gochecknoglobals
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "Content-Range", "Range",
+		log.RequestIDHeader, CSRFHeader}
+	defaultCORSExposedHeaders = []string{DistContentDigestKey, BlobUploadUUID, "Location", "Link",
+		"Range", log.RequestIDHeader}
+)
+
+// CORS returns a middleware enforcing the given CORS policy. It has to wrap the router
+// itself, preflight requests don't match any route and must not require auth.
+func CORS(config *CORSConfig) func(http.Handler) http.Handler {
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+
+	headers := config.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+
+	exposed := config.ExposedHeaders
+	if len(exposed) == 0 {
+		exposed = defaultCORSExposedHeaders
+	}
+
+	opts := []handlers.CORSOption{
+		handlers.AllowedOrigins(config.AllowedOrigins),
+		handlers.AllowedMethods(methods),
+		handlers.AllowedHeaders(headers),
+		handlers.ExposedHeaders(exposed),
+	}
+
+	if config.MaxAge > 0 {
+		opts = append(opts, handlers.MaxAge(config.MaxAge))
+	}
+
+	if config.AllowCredentials {
+		opts = append(opts, handlers.AllowCredentials())
+	}
+
+	return handlers.CORS(opts...)
+}
+
+// withCORS applies the configured CORS policy, falling back to permissive default headers.
+func (c *Controller) withCORS(engine *mux.Router) http.Handler {
+	if c.Config.HTTP.CORS == nil {
+		engine.Use(DefaultHeaders())
+
+		return engine
+	}
+
+	return CORS(c.Config.HTTP.CORS)(engine)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// WarningHeader carries the deprecation of pulled images, e.g. `299 - "deprecated: ..."`.
+	WarningHeader = "Warning"
+
+	// miscellaneous persistent warning, see RFC 7234.
+	warnCodeMisc = "299"
+)
+
+// DeprecationWarning returns the text of the warning sent with a deprecated image.
+func DeprecationWarning(deprecation *storage.Deprecation) string {
+	text := "deprecated: " + deprecation.Message
+
+	if deprecation.Replacement != "" {
+		text += ", use " + deprecation.Replacement + " instead"
+	}
+
+	return text
+}
+
+// setDeprecationWarning adds a warning header to the response if the image is deprecated.
+func (rh *RouteHandler) setDeprecationWarning(w http.ResponseWriter, r *http.Request, is *storage.ImageStore,
+	name, reference string) {
+	deprecation, err := is.GetDeprecation(name, reference)
+	if err != nil {
+		rh.logger(r).Error().Err(err).Str("repo", name).Msg("unable to read deprecation")
+		return
+	}
+
+	if deprecation != nil {
+		w.Header().Set(WarningHeader, warnCodeMisc+" - "+strconv.Quote(DeprecationWarning(deprecation)))
+	}
+}
+
+// UpdateDeprecation godoc
+// @Summary Deprecate a repository or a tag
+// @Description Deprecate a whole repository, or only one of its tags, pulls get a warning header
+// @Accept  json
+// @Produce json
+// @Param   name     path    string     true        "repository name"
+// @Param   tag      query   string     false       "tag, the whole repository if not given"
+// @Param   deprecation body storage.Deprecation true "deprecation message and optional replacement"
+// @Success 200 {string} string "ok"
+// @Failure 400 {string} string "bad request"
+// @Failure 404 {string} string "not found"
+// @Failure 500 {string} string "internal server error"
+// @Router /v2/_zot/admin/deprecations/{name} [put].
+func (rh *RouteHandler) UpdateDeprecation(w http.ResponseWriter, r *http.Request) {
+	var deprecation storage.Deprecation
+
+	if err := json.NewDecoder(r.Body).Decode(&deprecation); err != nil || deprecation.Message == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	rh.setDeprecation(w, r, &deprecation)
+}
+
+// DeleteDeprecation godoc
+// @Summary Remove the deprecation of a repository or a tag
+// @Description Remove the deprecation of a whole repository, or of one of its tags
+// @Accept  json
+// @Produce json
+// @Param   name     path    string     true        "repository name"
+// @Param   tag      query   string     false       "tag, the whole repository if not given"
+// @Success 200 {string} string "ok"
+// @Failure 404 {string} string "not found"
+// @Failure 500 {string} string "internal server error"
+// @Router /v2/_zot/admin/deprecations/{name} [delete].
+func (rh *RouteHandler) DeleteDeprecation(w http.ResponseWriter, r *http.Request) {
+	rh.setDeprecation(w, r, nil)
+}
+
+func (rh *RouteHandler) setDeprecation(w http.ResponseWriter, r *http.Request, deprecation *storage.Deprecation) {
+	name := mux.Vars(r)["name"]
+	tag := r.URL.Query().Get("tag")
+
+	err := rh.getImageStore(r, name).SetDeprecation(name, tag, deprecation)
+
+	switch {
+	case err == nil:
+		rh.logger(r).Info().Str("repo", name).Str("tag", tag).Bool("deprecated", deprecation != nil).
+			Msg("updated deprecation")
+		w.WriteHeader(http.StatusOK)
+	case errors.Is(err, errors.ErrRepoNotFound), errors.Is(err, errors.ErrRepoBadVersion):
+		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
+	case errors.Is(err, errors.ErrManifestNotFound):
+		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(MANIFEST_UNKNOWN, map[string]string{"reference": tag})))
+	default:
+		rh.logger(r).Error().Err(err).Msg("unexpected error")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/anuvu/zot/pkg/extensions/sync"
+	"github.com/anuvu/zot/pkg/scheduler"
+	"github.com/anuvu/zot/pkg/storage"
+)
+
+// maxStacksSize bounds the goroutine stacks of a diagnostics dump.
+const maxStacksSize = 4 << 20
+
+// StoreDiagnostics is the state of an image store, its lock holders and the uploads in progress.
+type StoreDiagnostics struct {
+	Stats   storage.StoreStats      `json:"stats"`
+	Uploads []storage.UploadSession `json:"uploads"`
+	Error   string                  `json:"error,omitempty"`
+}
+
+// Diagnostics is a dump of the server state, to debug hangs.
+type Diagnostics struct {
+	Time       time.Time                           `json:"time"`
+	Goroutines int                                 `json:"goroutines"`
+	Stacks     string                              `json:"stacks"`
+	Stores     map[string]StoreDiagnostics         `json:"stores"`
+	Scheduler  scheduler.Status                    `json:"scheduler"`
+	CVEUpdates map[string]scheduler.PeriodicStatus `json:"cveUpdates,omitempty"`
+	Sync       []sync.Status                       `json:"sync,omitempty"`
+}
+
+// diagnostics takes a dump of the server state, none of the locks it reports about are taken.
+func (c *Controller) diagnostics() Diagnostics {
+	stacks := make([]byte, maxStacksSize)
+	stacks = stacks[:runtime.Stack(stacks, true)]
+
+	diag := Diagnostics{
+		Time:       time.Now(),
+		Goroutines: runtime.NumGoroutine(),
+		Stacks:     string(stacks),
+		Stores:     make(map[string]StoreDiagnostics),
+	}
+
+	stores := make(map[string]*storage.ImageStore)
+
+	if c.StoreController.DefaultStore != nil {
+		stores["/"] = c.StoreController.DefaultStore
+	}
+
+	for route, imgStore := range c.StoreController.SubStore {
+		stores[route] = imgStore
+	}
+
+	for route, imgStore := range stores {
+		store := StoreDiagnostics{Stats: imgStore.Stats()}
+
+		uploads, err := imgStore.UploadSessions()
+		if err != nil {
+			store.Error = err.Error()
+		}
+
+		store.Uploads = uploads
+		diag.Stores[route] = store
+	}
+
+	if c.Scheduler != nil {
+		diag.Scheduler = c.Scheduler.Status()
+	}
+
+	if len(c.cveUpdates) != 0 {
+		diag.CVEUpdates = make(map[string]scheduler.PeriodicStatus)
+
+		for route, task := range c.cveUpdates {
+			diag.CVEUpdates[route] = task.Status()
+		}
+	}
+
+	if c.replicator != nil {
+		diag.Sync = c.replicator.Status()
+	}
+
+	return diag
+}
+
+// logDiagnostics takes a dump of the server state and logs it.
+func (c *Controller) logDiagnostics() Diagnostics {
+	diag := c.diagnostics()
+
+	c.Log.Info().Int("goroutines", diag.Goroutines).Interface("stores", diag.Stores).
+		Interface("scheduler", diag.Scheduler).Interface("cveUpdates", diag.CVEUpdates).
+		Interface("sync", diag.Sync).Str("stacks", diag.Stacks).Msg("diagnostics dump")
+
+	return diag
+}
+
+// watchDiagnostics logs a dump of the server state on SIGQUIT, instead of exiting with the goroutine stacks.
+func (c *Controller) watchDiagnostics(ctx context.Context) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGQUIT)
+
+	go func() {
+		defer signal.Stop(quit)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-quit:
+				c.logDiagnostics()
+			}
+		}
+	}()
+}
+
+// GetDiagnostics godoc
+// @Summary Dump the server state
+// @Description Log and get the goroutine stacks, the lock holders, the uploads in progress, the state of the background tasks and the cache statistics, as on SIGQUIT
+// @Accept  json
+// @Produce json
+// @Success 200 {object} 	api.Diagnostics
+// @Router /v2/_zot/admin/diagnostics [get].
+func (rh *RouteHandler) GetDiagnostics(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, rh.c.logDiagnostics())
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ExtensionsDiscoverRoute lists the extensions of the registry, as the OCI distribution spec extensions describe.
+const ExtensionsDiscoverRoute = "/_oci/ext/discover"
+
+// names of the zot extensions listed by the discovery route.
+const (
+	SearchExtension    = "_zot/search"
+	CVEExtension       = "_zot/cve"
+	MetricsExtension   = "_zot/metrics"
+	SyncExtension      = "_zot/sync"
+	UIExtension        = "_zot/ui"
+	UserPrefsExtension = "_zot/userprefs"
+	LintExtension      = "_zot/lint"
+)
+
+// Extension is an extension of the registry, with the version of its API, the URL documenting it and
+// the endpoints it serves. Enabled tells whether it is enabled on this server, so that clients check
+// for it rather than guessing from the errors of its endpoints.
+type Extension struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Description string   `json:"description"`
+	URL         string   `json:"url"`
+	Endpoints   []string `json:"endpoints"`
+	Enabled     bool     `json:"enabled"`
+}
+
+// ExtensionList is the response of the discovery route.
+type ExtensionList struct {
+	Extensions []Extension `json:"extensions"`
+}
+
+// Enabled tells whether the extension of this name is listed and enabled.
+func (l ExtensionList) Enabled(name string) bool {
+	for _, extension := range l.Extensions {
+		if extension.Name == name {
+			return extension.Enabled
+		}
+	}
+
+	return false
+}
+
+const extensionsDocURL = "https://github.com/anuvu/zot/blob/main/README.md"
+
+// knownExtensions are the extensions zot may serve, their version changes along with their API.
+// nolint: gochecknoglobals
+var knownExtensions = []Extension{
+	{
+		Name:        SearchExtension,
+		Version:     "v1",
+		Description: "GraphQL search of the images, their tags and their vulnerabilities",
+		Endpoints:   []string{SearchRoute},
+	},
+	{
+		Name:        CVEExtension,
+		Version:     "v1",
+		Description: "Vulnerability scanning of the images, queried through the search extension",
+		Endpoints: []string{SearchRoute, RoutePrefix + CVERefreshRoute,
+			RoutePrefix + CVERawReportRoutePrefix + "/{name}"},
+	},
+	{
+		Name:        MetricsExtension,
+		Version:     "v1",
+		Description: "Prometheus metrics of the registry",
+		Endpoints:   []string{"/metrics"},
+	},
+	{
+		Name:        SyncExtension,
+		Version:     "v1",
+		Description: "Replication of the pushed images to downstream registries",
+		Endpoints:   []string{RoutePrefix + AdminRoutePrefix + "/sync"},
+	},
+	{
+		Name:        UIExtension,
+		Version:     "v1",
+		Description: "Web UI browsing the repositories",
+		Endpoints:   []string{"/ui/"},
+	},
+	{
+		Name:        UserPrefsExtension,
+		Version:     "v1",
+		Description: "Starred and bookmarked repositories of the users",
+		Endpoints:   []string{RoutePrefix + ExtRoutePrefix + "/userprefs"},
+	},
+	{
+		Name:        LintExtension,
+		Version:     "v1",
+		Description: "Lint of the pushed images, their results queried through the search extension",
+		Endpoints:   []string{SearchRoute},
+	},
+}
+
+// ListExtensions godoc
+// @Summary List the extensions
+// @Description List the extensions of the registry, with their versions and endpoints, and whether they're enabled
+// @Router 	/v2/_oci/ext/discover [get]
+// @Accept  json
+// @Produce json
+// @Success 200 {object} 	api.ExtensionList.
+func (rh *RouteHandler) ListExtensions(w http.ResponseWriter, r *http.Request) {
+	list := ExtensionList{Extensions: make([]Extension, 0, len(knownExtensions))}
+
+	for _, extension := range knownExtensions {
+		extension.URL = extensionsDocURL
+		extension.Endpoints = append([]string{}, extension.Endpoints...)
+		extension.Enabled = rh.extensionEnabled(extension)
+
+		list.Extensions = append(list.Extensions, extension)
+	}
+
+	WriteJSON(w, http.StatusOK, list)
+}
+
+// extensionEnabled tells whether an extension is enabled, i.e. whether its routes were set up, which
+// reflects the build of the binary and the defaults applied to the config as well as the config itself.
+func (rh *RouteHandler) extensionEnabled(extension Extension) bool {
+	switch extension.Name {
+	case SyncExtension:
+		// the sync status route is always there, it reports that sync isn't enabled
+		return rh.c.replicator != nil
+	case LintExtension:
+		// pushed images are linted even if their results can't be queried
+		return rh.c.linter != nil
+	case CVEExtension:
+		// so is the CVE refresh route, scans need a CVE database updated by the search extension
+		extensions := rh.c.Config.Extensions
+		if extensions == nil || extensions.Search == nil || extensions.Search.CVE == nil {
+			return false
+		}
+
+		return rh.routeRegistered(SearchRoute)
+	}
+
+	for _, endpoint := range extension.Endpoints {
+		if !rh.routeRegistered(endpoint) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// routeRegistered tells whether a route serves path, with or without a trailing slash.
+func (rh *RouteHandler) routeRegistered(path string) bool {
+	found := false
+
+	_ = rh.c.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+
+		if strings.TrimSuffix(tmpl, "/") == strings.TrimSuffix(path, "/") {
+			found = true
+
+			return mux.SkipRouter
+		}
+
+		return nil
+	})
+
+	return found
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/anuvu/zot/errors"
+	ext "github.com/anuvu/zot/pkg/extensions"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/getlantern/deepcopy"
+)
+
+// DryRun validates the config and loads the files it refers to, e.g. certificates, policies and
+// encryption keys, without serving anything. It returns the effective config: defaults applied,
+// features which this binary or the storage can't provide disabled, and secrets redacted.
+func (c *Controller) DryRun() (*Config, error) {
+	if err := c.Config.Validate(c.Log); err != nil {
+		c.Log.Error().Err(err).Msg("configuration validation failed")
+		return nil, err
+	}
+
+	if err := c.loadPolicies(); err != nil {
+		return nil, err
+	}
+
+	if c.Config.Storage.RootDirectory == "" {
+		c.Log.Error().Err(errors.ErrImgStoreNotFound).Msg("controller: no storage config provided")
+		return nil, errors.ErrImgStoreNotFound
+	}
+
+	config := &Config{}
+	if err := deepcopy.Copy(config, c.Config); err != nil {
+		return nil, err
+	}
+
+	if err := c.resolveHTTP(&config.HTTP); err != nil {
+		return nil, err
+	}
+
+	dedupeMode := config.Storage.DedupeMode
+
+	global := StorageConfig{
+		RootDirectory: config.Storage.RootDirectory,
+		Dedupe:        config.Storage.Dedupe,
+		DedupeMode:    dedupeMode,
+		Encryption:    config.Storage.Encryption,
+		DiskSpace:     config.Storage.DiskSpace,
+		Tiering:       config.Storage.Tiering,
+	}
+
+	if err := c.resolveStorage(&global); err != nil {
+		return nil, err
+	}
+
+	config.Storage.Dedupe = global.Dedupe
+	config.Storage.DedupeMode = global.DedupeMode
+	config.Storage.DiskSpace = global.DiskSpace
+	config.Storage.Tiering = global.Tiering
+
+	for route := range config.Storage.SubPaths {
+		storageConfig := config.Storage.SubPaths[route]
+
+		if storageConfig.DiskSpace == nil && config.Storage.DiskSpace != nil {
+			diskSpace := *config.Storage.DiskSpace
+			storageConfig.DiskSpace = &diskSpace
+		}
+
+		if storageConfig.DedupeMode == "" {
+			storageConfig.DedupeMode = dedupeMode
+		}
+
+		if err := c.resolveStorage(&storageConfig); err != nil {
+			return nil, err
+		}
+
+		config.Storage.SubPaths[route] = storageConfig
+	}
+
+	for name := range config.Storage.Tenants {
+		tenantConfig := config.Storage.Tenants[name]
+
+		if tenantConfig.DiskSpace == nil && config.Storage.DiskSpace != nil {
+			diskSpace := *config.Storage.DiskSpace
+			tenantConfig.DiskSpace = &diskSpace
+		}
+
+		if tenantConfig.DedupeMode == "" {
+			tenantConfig.DedupeMode = dedupeMode
+		}
+
+		if err := c.resolveStorage(&tenantConfig.StorageConfig); err != nil {
+			return nil, err
+		}
+
+		config.Storage.Tenants[name] = tenantConfig
+	}
+
+	config.Scheduler = config.Scheduler.WithDefaults()
+	config.Extensions = ext.EffectiveConfig(config.Extensions, c.Log)
+
+	return config.Sanitize(), nil
+}
+
+// resolveHTTP loads the certificates of the listeners and applies the defaults of the HTTP config.
+func (c *Controller) resolveHTTP(config *HTTPConfig) error {
+	tlsConfigs := []*TLSConfig{config.TLS}
+
+	for i := range config.Listeners {
+		tlsConfigs = append(tlsConfigs, config.Listeners[i].TLS)
+	}
+
+	if config.GRPC != nil {
+		tlsConfigs = append(tlsConfigs, config.GRPC.TLS)
+	}
+
+	for _, tlsConfig := range tlsConfigs {
+		if tlsConfig == nil {
+			continue
+		}
+
+		if err := checkTLS(tlsConfig); err != nil {
+			c.Log.Error().Err(err).Str("cert", tlsConfig.Cert).Msg("invalid TLS config")
+			return err
+		}
+
+		if tlsConfig.ACME != nil && tlsConfig.ACME.CacheDir == "" {
+			tlsConfig.ACME.CacheDir = path.Join(c.Config.Storage.RootDirectory, acmeCacheDir)
+		}
+	}
+
+	if config.Auth != nil && config.Auth.Webhook != nil && config.Auth.Webhook.Timeout == 0 {
+		config.Auth.Webhook.Timeout = defaultWebhookTimeout
+	}
+
+	return nil
+}
+
+// checkTLS loads the certificates of a TLS config, as done when the server starts.
+func checkTLS(config *TLSConfig) error {
+	if config.CACert != "" {
+		caCert, err := ioutil.ReadFile(config.CACert)
+		if err != nil {
+			return err
+		}
+
+		if !x509.NewCertPool().AppendCertsFromPEM(caCert) {
+			return errors.ErrBadCACert
+		}
+	}
+
+	if err := ApplyTLSPolicy(&tls.Config{}, config.Policy); err != nil {
+		return err
+	}
+
+	if config.ACME != nil || !config.enabled() {
+		return nil
+	}
+
+	_, err := newCertReloader(config.Cert, config.Key)
+
+	return err
+}
+
+// resolveStorage loads the encryption key of a storage path and applies the defaults of its config,
+// its dedupe mode is resolved to the links its filesystem supports, dedupe is disabled if it supports none.
+func (c *Controller) resolveStorage(config *StorageConfig) error {
+	// the root directory is only created when the server starts
+	if _, err := os.Stat(config.RootDirectory); err == nil && config.Dedupe {
+		if c.checkDedupe(config.RootDirectory, config.DedupeMode, &config.Dedupe) {
+			config.DedupeMode = storage.DedupeReflink
+		} else {
+			config.DedupeMode = storage.DedupeHardLink
+		}
+	}
+
+	if config.Encryption != nil {
+		if _, err := loadEncryptionKey(config.Encryption); err != nil {
+			c.Log.Error().Err(err).Str("rootDir", config.RootDirectory).Msg("unable to load blob encryption key")
+			return err
+		}
+	}
+
+	if config.Tiering != nil && config.Tiering.Interval <= 0 {
+		config.Tiering.Interval = defaultTieringInterval
+	}
+
+	if config.DiskSpace != nil && config.DiskSpace.CheckInterval <= 0 {
+		config.DiskSpace.CheckInterval = defaultDiskSpaceCheckInterval
+	}
+
+	return nil
+}
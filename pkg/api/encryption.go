@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os/exec"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/storage"
+)
+
+const (
+	encryptionKeySize     = 32
+	encryptionKeyCmdLimit = 30 * time.Second
+)
+
+// loadEncryptionKey reads the blob encryption key from a file, or from the output of a KMS plugin command.
+// Keys are 32 bytes, either raw, hex or base64 encoded.
+func loadEncryptionKey(config *EncryptionConfig) ([]byte, error) {
+	var (
+		buf []byte
+		err error
+	)
+
+	switch {
+	case config.KeyFile != "":
+		buf, err = ioutil.ReadFile(config.KeyFile)
+	case len(config.KeyCommand) > 0:
+		ctx, cancel := context.WithTimeout(context.Background(), encryptionKeyCmdLimit)
+		defer cancel()
+
+		buf, err = exec.CommandContext(ctx, config.KeyCommand[0], config.KeyCommand[1:]...).Output() // nolint: gosec
+	default:
+		return nil, errors.ErrBadEncryptionKey
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeEncryptionKey(buf)
+}
+
+func decodeEncryptionKey(buf []byte) ([]byte, error) {
+	if len(buf) == encryptionKeySize {
+		return buf, nil
+	}
+
+	text := string(bytes.TrimSpace(buf))
+
+	if key, err := hex.DecodeString(text); err == nil && len(key) == encryptionKeySize {
+		return key, nil
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == encryptionKeySize {
+		return key, nil
+	}
+
+	return nil, errors.ErrBadEncryptionKey
+}
+
+// enableEncryption enables encryption at rest of the blobs of the image store, if configured.
+func (c *Controller) enableEncryption(imgStore *storage.ImageStore, config *EncryptionConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	key, err := loadEncryptionKey(config)
+	if err != nil {
+		c.Log.Error().Err(err).Str("rootDir", imgStore.RootDir()).Msg("unable to load blob encryption key")
+		return err
+	}
+
+	if err := imgStore.SetEncryptionKey(key); err != nil {
+		c.Log.Error().Err(err).Str("rootDir", imgStore.RootDir()).Msg("invalid blob encryption key")
+		return err
+	}
+
+	if c.Config.Extensions != nil && c.Config.Extensions.Search != nil && c.Config.Extensions.Search.CVE != nil {
+		c.Log.Warn().Str("rootDir", imgStore.RootDir()).
+			Msg("CVE scanning reads image layers from disk and doesn't support encrypted blobs")
+	}
+
+	return nil
+}
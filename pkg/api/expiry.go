@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/gorilla/mux"
+)
+
+// TagTTL is how long a tag lives, e.g. "72h", before it's removed.
+type TagTTL struct {
+	TTL string `json:"ttl"`
+}
+
+// TagExpiry is when a tag is removed.
+type TagExpiry struct {
+	Tag     string    `json:"tag"`
+	Expires time.Time `json:"expires"`
+}
+
+// GetTagExpiries godoc
+// @Summary List the expiring tags of a repository
+// @Description List when the tags of a repository which have a TTL are removed
+// @Accept  json
+// @Produce json
+// @Param   name     path    string     true        "repository name"
+// @Success 200 {object} map[string]time.Time
+// @Failure 404 {string} string "not found"
+// @Failure 500 {string} string "internal server error"
+// @Router /v2/_zot/ext/ttl/{name} [get].
+func (rh *RouteHandler) GetTagExpiries(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	expiries, err := rh.getImageStore(r, name).GetTagExpiries(name)
+
+	switch {
+	case err == nil:
+		WriteJSON(w, http.StatusOK, expiries)
+	case errors.Is(err, errors.ErrRepoNotFound), errors.Is(err, errors.ErrRepoBadVersion):
+		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
+	default:
+		rh.logger(r).Error().Err(err).Msg("unexpected error")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// UpdateTagTTL godoc
+// @Summary Make a tag expire
+// @Description Remove a tag once its TTL from now elapsed, its manifest and blobs are left to GC
+// @Accept  json
+// @Produce json
+// @Param   name     path    string     true        "repository name"
+// @Param   tag      query   string     true        "tag"
+// @Param   ttl      body    api.TagTTL true        "time to live of the tag, e.g. 72h"
+// @Success 200 {object} api.TagExpiry
+// @Failure 400 {string} string "bad request"
+// @Failure 403 {string} string "forbidden"
+// @Failure 404 {string} string "not found"
+// @Failure 500 {string} string "internal server error"
+// @Router /v2/_zot/ext/ttl/{name} [put].
+func (rh *RouteHandler) UpdateTagTTL(w http.ResponseWriter, r *http.Request) {
+	var body TagTTL
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ttl, err := time.ParseDuration(body.TTL)
+	if err != nil || ttl <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	rh.setTagTTL(w, r, ttl)
+}
+
+// DeleteTagTTL godoc
+// @Summary Make a tag not expire anymore
+// @Description Clear the TTL of a tag
+// @Accept  json
+// @Produce json
+// @Param   name     path    string     true        "repository name"
+// @Param   tag      query   string     true        "tag"
+// @Success 200 {object} api.TagExpiry
+// @Failure 400 {string} string "bad request"
+// @Failure 404 {string} string "not found"
+// @Failure 500 {string} string "internal server error"
+// @Router /v2/_zot/ext/ttl/{name} [delete].
+func (rh *RouteHandler) DeleteTagTTL(w http.ResponseWriter, r *http.Request) {
+	rh.setTagTTL(w, r, 0)
+}
+
+func (rh *RouteHandler) setTagTTL(w http.ResponseWriter, r *http.Request, ttl time.Duration) {
+	name := mux.Vars(r)["name"]
+	tag := r.URL.Query().Get("tag")
+
+	if tag == "" {
+		WriteJSON(w, http.StatusBadRequest, NewErrorList(NewError(TAG_INVALID, map[string]string{"tag": tag})))
+		return
+	}
+
+	expires, err := rh.getImageStore(r, name).SetTagExpiry(name, tag, ttl)
+
+	switch {
+	case err == nil:
+		rh.logger(r).Info().Str("repo", name).Str("tag", tag).Time("expires", expires).Msg("updated tag expiry")
+		WriteJSON(w, http.StatusOK, TagExpiry{Tag: tag, Expires: expires})
+	case errors.Is(err, errors.ErrRepoNotFound), errors.Is(err, errors.ErrRepoBadVersion):
+		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
+	case errors.Is(err, errors.ErrManifestNotFound):
+		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(MANIFEST_UNKNOWN, map[string]string{"reference": tag})))
+	case errors.Is(err, errors.ErrImmutableTag):
+		rh.logger(r).Warn().Str("repo", name).Str("tag", tag).Msg("rejecting TTL of an immutable tag")
+		WriteJSON(w, http.StatusForbidden,
+			NewErrorList(NewError(DENIED, map[string]string{"reference": tag, "reason": err.Error()})))
+	default:
+		rh.logger(r).Error().Err(err).Msg("unexpected error")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
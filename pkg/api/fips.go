@@ -0,0 +1,13 @@
+// +build fips
+
+package api
+
+import (
+	// restricts crypto/tls to FIPS 140-2 approved versions, cipher suites and curves,
+	// only available with a boringcrypto go toolchain
+	_ "crypto/tls/fipsonly"
+)
+
+func init() {
+	FIPSMode = true
+}
@@ -0,0 +1,377 @@
+package api
+
+import (
+	"context"
+	goerrors "errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/anuvu/zot/errors"
+	ext "github.com/anuvu/zot/pkg/extensions"
+	"github.com/anuvu/zot/pkg/rpc"
+	"github.com/anuvu/zot/pkg/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcAdminPrefix is the prefix of the full method names of the admin gRPC service.
+const grpcAdminPrefix = "/zot.v1.Admin/"
+
+// grpcWriteMethods are the gRPC methods authorized as the REST requests modifying the registry.
+// nolint: gochecknoglobals
+var grpcWriteMethods = map[string]bool{
+	grpcAdminPrefix + "ApproveSyncedImage": true,
+	grpcAdminPrefix + "SetDeprecation":     true,
+	grpcAdminPrefix + "DeleteDeprecation":  true,
+	grpcAdminPrefix + "Rededupe":           true,
+	grpcAdminPrefix + "TierBlobs":          true,
+}
+
+// listenGRPC creates the server of the gRPC services and its socket.
+func (c *Controller) listenGRPC(rh *RouteHandler, config ListenerConfig) (*grpc.Server, net.Listener, error) {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(c.grpcAuthInterceptor())}
+
+	if config.TLS.enabled() {
+		// the TLS settings, and the certificate reloading, are the ones of the HTTP listeners
+		server := &http.Server{}
+		if err := c.configureTLS(server, config.TLS); err != nil {
+			return nil, nil, err
+		}
+
+		opts = append(opts, grpc.Creds(credentials.NewTLS(server.TLSConfig)))
+	}
+
+	var (
+		l   net.Listener
+		err error
+	)
+
+	if config.Socket != "" {
+		l, err = listenUnix(config.Socket)
+	} else {
+		l, err = net.Listen("tcp", net.JoinHostPort(config.Address, config.Port))
+	}
+
+	if err != nil {
+		c.Log.Error().Err(err).Str("address", config.Address).Str("port", config.Port).
+			Str("socket", config.Socket).Msg("unable to listen for gRPC")
+
+		return nil, nil, err
+	}
+
+	server := grpc.NewServer(opts...)
+
+	rpc.RegisterAdminServer(server, &grpcAdminServer{rh: rh})
+	ext.SetupGRPC(c.Config.Extensions, server, c.StoreController, c.replicator, c.Log)
+
+	return server, l, nil
+}
+
+// serveGRPC accepts gRPC connections until the HTTP server is shut down.
+func (c *Controller) serveGRPC(server *grpc.Server, l net.Listener) {
+	c.Server.RegisterOnShutdown(server.GracefulStop)
+
+	go func() {
+		c.Log.Info().Str("address", l.Addr().String()).Msg("listening for gRPC")
+
+		if err := server.Serve(l); err != nil {
+			c.Log.Error().Err(err).Msg("gRPC server failed")
+		}
+	}()
+}
+
+// grpcAuthInterceptor authenticates gRPC calls with the network and authN middlewares of the REST API, as requests
+// to the matching REST endpoints, the "authorization" metadata being their Authorization header. Search calls only
+// return the repositories of the tenants the user is a member of.
+func (c *Controller) grpcAuthInterceptor() grpc.UnaryServerInterceptor {
+	authenticate := AuthHandler(c)
+	checkNetwork := NetworkHandler(c)
+	filterTenants := TenantHandler(c)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		method, path := http.MethodGet, RoutePrefix+ExtRoutePrefix+info.FullMethod
+
+		if strings.HasPrefix(info.FullMethod, grpcAdminPrefix) {
+			path = RoutePrefix + AdminRoutePrefix + info.FullMethod
+		}
+
+		if grpcWriteMethods[info.FullMethod] {
+			method = http.MethodPost
+		}
+
+		r, err := http.NewRequestWithContext(ctx, method, path, nil)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, value := range md.Get("authorization") {
+				r.Header.Add("Authorization", value)
+			}
+		}
+
+		if p, ok := peer.FromContext(ctx); ok {
+			r.RemoteAddr = p.Addr.String()
+
+			if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				r.TLS = &tlsInfo.State
+			}
+		}
+
+		var authenticated context.Context
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authenticated = r.Context()
+		})
+
+		w := &grpcAuthResponse{header: make(http.Header)}
+
+		if strings.HasPrefix(info.FullMethod, grpcAdminPrefix) {
+			checkNetwork(authenticate(AdminHandler(c, next))).ServeHTTP(w, r)
+		} else {
+			checkNetwork(authenticate(filterTenants(next))).ServeHTTP(w, r)
+		}
+
+		if authenticated == nil {
+			return nil, w.err()
+		}
+
+		return handler(authenticated, req)
+	}
+}
+
+// grpcAuthResponse records the response of the authN middleware to a refused gRPC call.
+type grpcAuthResponse struct {
+	header http.Header
+	status int
+}
+
+func (w *grpcAuthResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcAuthResponse) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return len(b), nil
+}
+
+func (w *grpcAuthResponse) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *grpcAuthResponse) err() error {
+	switch w.status {
+	case http.StatusUnauthorized:
+		return status.Error(codes.Unauthenticated, http.StatusText(w.status))
+	case http.StatusForbidden, http.StatusMethodNotAllowed:
+		return status.Error(codes.PermissionDenied, http.StatusText(w.status))
+	default:
+		return status.Error(codes.Unavailable, http.StatusText(w.status))
+	}
+}
+
+// grpcAdminServer serves the admin gRPC service, with the logic of the admin REST endpoints.
+type grpcAdminServer struct {
+	rpc.UnimplementedAdminServer
+	rh *RouteHandler
+}
+
+func (s *grpcAdminServer) GetSchedulerStatus(ctx context.Context,
+	req *rpc.GetSchedulerStatusRequest) (*rpc.GetSchedulerStatusResponse, error) {
+	st := s.rh.c.Scheduler.Status()
+
+	resp := &rpc.GetSchedulerStatusResponse{MaxConcurrentTasks: int32(st.MaxConcurrentTasks),
+		RunningTasks: st.RunningTasks, QueuedTasks: make(map[string]int32, len(st.QueuedTasks)),
+		CompletedTasks: st.CompletedTasks, FailedTasks: st.FailedTasks, InFlightRequests: st.InFlightRequests,
+		Paused: st.Paused}
+
+	for priority, count := range st.QueuedTasks {
+		resp.QueuedTasks[priority] = int32(count)
+	}
+
+	return resp, nil
+}
+
+func (s *grpcAdminServer) GetSyncStatus(ctx context.Context,
+	req *rpc.GetSyncStatusRequest) (*rpc.GetSyncStatusResponse, error) {
+	resp := &rpc.GetSyncStatusResponse{}
+
+	if s.rh.c.replicator == nil {
+		return resp, nil
+	}
+
+	for _, st := range s.rh.c.replicator.Status() {
+		registry := &rpc.SyncStatus{Registry: st.Registry, ImagesSynced: st.ImagesSynced,
+			BytesTransferred: st.BytesTransferred, Queued: int32(st.Queued), FailureCount: st.FailureCount}
+
+		if st.LastSync != nil {
+			registry.LastSync = timestamppb.New(*st.LastSync)
+		}
+
+		for _, failure := range st.Failures {
+			registry.Failures = append(registry.Failures, &rpc.SyncFailure{Repo: failure.Repo,
+				Reference: failure.Reference, Time: timestamppb.New(failure.Time), Reason: failure.Reason,
+				Conflict: failure.Conflict})
+		}
+
+		for _, image := range st.Quarantined {
+			registry.Quarantined = append(registry.Quarantined, &rpc.SyncQuarantinedImage{Repo: image.Repo,
+				Reference: image.Reference, Time: timestamppb.New(image.Time), Severity: image.Severity})
+		}
+
+		resp.Registries = append(resp.Registries, registry)
+	}
+
+	return resp, nil
+}
+
+func (s *grpcAdminServer) ApproveSyncedImage(ctx context.Context,
+	req *rpc.ApproveSyncedImageRequest) (*rpc.ApproveSyncedImageResponse, error) {
+	if req.Repo == "" || req.Reference == "" {
+		return nil, status.Error(codes.InvalidArgument, "repo and reference are required")
+	}
+
+	if s.rh.c.replicator == nil || s.rh.c.replicator.Approve(req.Repo, req.Reference) != nil {
+		return nil, status.Error(codes.NotFound, errors.ErrSyncNotQuarantined.Error())
+	}
+
+	return &rpc.ApproveSyncedImageResponse{}, nil
+}
+
+func (s *grpcAdminServer) SetDeprecation(ctx context.Context,
+	req *rpc.SetDeprecationRequest) (*rpc.SetDeprecationResponse, error) {
+	if req.Message == "" {
+		return nil, status.Error(codes.InvalidArgument, "message is required")
+	}
+
+	deprecation := &storage.Deprecation{Message: req.Message, Replacement: req.Replacement}
+
+	if err := s.setDeprecation(req.Repo, req.Tag, deprecation); err != nil {
+		return nil, err
+	}
+
+	return &rpc.SetDeprecationResponse{}, nil
+}
+
+func (s *grpcAdminServer) DeleteDeprecation(ctx context.Context,
+	req *rpc.DeleteDeprecationRequest) (*rpc.DeleteDeprecationResponse, error) {
+	if err := s.setDeprecation(req.Repo, req.Tag, nil); err != nil {
+		return nil, err
+	}
+
+	return &rpc.DeleteDeprecationResponse{}, nil
+}
+
+func (s *grpcAdminServer) setDeprecation(repo, tag string, deprecation *storage.Deprecation) error {
+	if repo == "" {
+		return status.Error(codes.InvalidArgument, "repo is required")
+	}
+
+	err := s.rh.c.StoreController.GetImageStore(repo).SetDeprecation(repo, tag, deprecation)
+
+	switch {
+	case err == nil:
+		s.rh.c.Log.Info().Str("repo", repo).Str("tag", tag).Bool("deprecated", deprecation != nil).
+			Msg("updated deprecation")
+
+		return nil
+	case goerrors.Is(err, errors.ErrRepoNotFound), goerrors.Is(err, errors.ErrRepoBadVersion),
+		goerrors.Is(err, errors.ErrManifestNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		s.rh.c.Log.Error().Err(err).Msg("unexpected error")
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func (s *grpcAdminServer) GetDedupeReport(ctx context.Context,
+	req *rpc.GetDedupeReportRequest) (*rpc.GetDedupeReportResponse, error) {
+	top := storage.DefaultDedupeReportTop
+
+	if req.Top > 0 {
+		top = int(req.Top)
+	}
+
+	reports, err := s.rh.dedupeReports(&s.rh.c.Log, top)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &rpc.GetDedupeReportResponse{Stores: make(map[string]*rpc.DedupeReport, len(reports))}
+
+	for route, report := range reports {
+		r := &rpc.DedupeReport{RootDir: report.RootDir, Dedupe: report.Dedupe, Blobs: int32(report.Blobs),
+			BlobFiles: int32(report.BlobFiles), LogicalBytes: report.LogicalBytes,
+			PhysicalBytes: report.PhysicalBytes, ReclaimableBytes: report.ReclaimableBytes}
+
+		for _, blob := range report.TopDuplicates {
+			r.TopDuplicates = append(r.TopDuplicates, &rpc.DuplicateBlob{Digest: blob.Digest.String(),
+				Size: blob.Size, Repos: blob.Repos, Copies: int32(blob.Copies), SavedBytes: blob.SavedBytes})
+		}
+
+		resp.Stores[route] = r
+	}
+
+	return resp, nil
+}
+
+func (s *grpcAdminServer) Rededupe(ctx context.Context, req *rpc.RededupeRequest) (*rpc.RededupeResponse, error) {
+	results, err := s.rh.rededupe(&s.rh.c.Log)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &rpc.RededupeResponse{Stores: make(map[string]*rpc.RededupeResult, len(results))}
+
+	for route, result := range results {
+		resp.Stores[route] = &rpc.RededupeResult{RootDir: result.RootDir, Linked: int32(result.Linked),
+			ReclaimedBytes: result.ReclaimedBytes}
+	}
+
+	return resp, nil
+}
+
+func (s *grpcAdminServer) GetTieringReport(ctx context.Context,
+	req *rpc.GetTieringReportRequest) (*rpc.GetTieringReportResponse, error) {
+	reports, err := s.rh.tieringReports(&s.rh.c.Log)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &rpc.GetTieringReportResponse{Stores: make(map[string]*rpc.TieringReport, len(reports))}
+
+	for route, report := range reports {
+		resp.Stores[route] = &rpc.TieringReport{RootDir: report.RootDir, ColdDir: report.ColdDir,
+			ColdAfter: durationpb.New(report.ColdAfter), ColdBlobs: int32(report.ColdBlobs),
+			ColdBytes: report.ColdBytes}
+	}
+
+	return resp, nil
+}
+
+func (s *grpcAdminServer) TierBlobs(ctx context.Context, req *rpc.TierBlobsRequest) (*rpc.TierBlobsResponse, error) {
+	results, err := s.rh.tierBlobs(&s.rh.c.Log)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &rpc.TierBlobsResponse{Stores: make(map[string]*rpc.TieringResult, len(results))}
+
+	for route, result := range results {
+		resp.Stores[route] = &rpc.TieringResult{RootDir: result.RootDir, Moved: int32(result.Moved),
+			MovedBytes: result.MovedBytes}
+	}
+
+	return resp, nil
+}
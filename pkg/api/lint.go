@@ -0,0 +1,81 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// lintPushedManifest checks a pushed manifest against the lint rules of its repository, before it's stored.
+// Images breaking them are rejected if lint is blocking, the response is written and false is returned then.
+// The violations are returned so that they're recorded once the manifest is stored, nil if it isn't linted.
+func (rh *RouteHandler) lintPushedManifest(w http.ResponseWriter, r *http.Request, is *storage.ImageStore,
+	name, reference string, body []byte) ([]string, bool) {
+	if rh.c.linter == nil || !rh.c.linter.Matches(name) {
+		return nil, true
+	}
+
+	readConfig := func(digest godigest.Digest, mediaType string) ([]byte, error) {
+		reader, _, err := is.GetBlob(name, digest.String(), mediaType)
+		if err != nil {
+			return nil, err
+		}
+
+		if closer, ok := reader.(io.Closer); ok {
+			defer closer.Close()
+		}
+
+		return ioutil.ReadAll(reader)
+	}
+
+	violations, err := rh.c.linter.Lint(body, readConfig)
+	if err != nil {
+		// the manifest is rejected when it's stored, if it's invalid or its config is missing
+		rh.logger(r).Warn().Err(err).Str("repository", name).Str("reference", reference).
+			Msg("unable to lint pushed manifest")
+
+		return nil, true
+	}
+
+	if len(violations) == 0 {
+		return violations, true
+	}
+
+	reason := strings.Join(violations, ", ")
+
+	if !rh.c.linter.Blocking() {
+		rh.logger(r).Warn().Str("repository", name).Str("reference", reference).Str("violations", reason).
+			Msg("pushed image breaks the lint rules, accepting it")
+
+		return violations, true
+	}
+
+	rh.logger(r).Warn().Str("repository", name).Str("reference", reference).Str("violations", reason).
+		Msg("rejecting image breaking the lint rules")
+	WriteJSON(w, http.StatusForbidden,
+		NewErrorList(NewError(DENIED, map[string]string{"reference": reference,
+			"reason": errors.ErrLintViolation.Error() + ": " + reason})))
+
+	return nil, false
+}
+
+// recordLint records the result of the lint of a stored manifest.
+func (rh *RouteHandler) recordLint(r *http.Request, is *storage.ImageStore, name string, digest godigest.Digest,
+	violations []string) {
+	if violations == nil {
+		return
+	}
+
+	summary := &storage.LintSummary{Checked: time.Now(), Passed: len(violations) == 0, Violations: violations}
+
+	if err := is.SetLintSummary(name, digest, summary); err != nil {
+		rh.logger(r).Error().Err(err).Str("repository", name).Str("digest", digest.String()).
+			Msg("unable to record lint result")
+	}
+}
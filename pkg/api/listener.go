@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	goerrors "errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anuvu/zot/errors"
+)
+
+const (
+	socketMode = 0600
+	// first file descriptor passed by systemd socket activation, after stdin, stdout and stderr
+	listenFdsStart = 3
+)
+
+// listener is a server accepting connections on one of the configured addresses.
+type listener struct {
+	config   ListenerConfig
+	server   *http.Server
+	listener net.Listener
+}
+
+// listen creates the servers of all the configured listeners and their sockets.
+func (c *Controller) listen() ([]listener, error) {
+	listeners := make([]listener, 0, len(c.Config.HTTP.Listeners)+1)
+
+	closeAll := func() {
+		for _, l := range listeners {
+			l.listener.Close()
+		}
+	}
+
+	if c.Config.HTTP.SocketActivation {
+		activated, err := systemdListeners()
+		if err != nil {
+			c.Log.Error().Err(err).Msg("unable to use systemd socket activation")
+			return nil, err
+		}
+
+		for _, l := range activated {
+			config := ListenerConfig{TLS: c.Config.HTTP.TLS}
+
+			server, err := c.newServer(config)
+			if err != nil {
+				l.Close()
+				closeAll()
+
+				return nil, err
+			}
+
+			server.Addr = l.Addr().String()
+
+			listeners = append(listeners, listener{config: config, server: server, listener: l})
+		}
+	}
+
+	for _, config := range c.Config.HTTP.GetListeners() {
+		l, err := c.newListener(config)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	challenges, err := c.listenACMEChallenges()
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	return append(listeners, challenges...), nil
+}
+
+// systemdListeners returns the sockets passed by systemd socket activation, see sd_listen_fds(3).
+func systemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.ErrNoActivatedSockets
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil, errors.ErrNoActivatedSockets
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// not inherited by child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, nfds)
+
+	for i := 0; i < nfds; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFdsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+
+		// the listener uses a duplicate of the file descriptor
+		l, err := net.FileListener(f)
+		f.Close()
+
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+
+			return nil, err
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+func (c *Controller) newServer(config ListenerConfig) (*http.Server, error) {
+	server := &http.Server{
+		Handler:     c.withCORS(c.Router),
+		IdleTimeout: idleTimeout,
+	}
+
+	if config.TLS.enabled() {
+		if err := c.configureTLS(server, config.TLS); err != nil {
+			return nil, err
+		}
+	}
+
+	return server, nil
+}
+
+func (c *Controller) newListener(config ListenerConfig) (listener, error) {
+	server, err := c.newServer(config)
+	if err != nil {
+		return listener{}, err
+	}
+
+	var l net.Listener
+
+	if config.Socket != "" {
+		server.Addr = config.Socket
+		l, err = listenUnix(config.Socket)
+	} else {
+		server.Addr = net.JoinHostPort(config.Address, config.Port)
+		l, err = net.Listen("tcp", server.Addr)
+	}
+
+	if err != nil {
+		c.Log.Error().Err(err).Str("address", server.Addr).Msg("unable to listen")
+		return listener{}, err
+	}
+
+	return listener{config: config, server: server, listener: l}, nil
+}
+
+// listenUnix listens on a unix socket only accessible to the user running zot, replacing a stale one.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, socketMode); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// serve accepts connections on all the listeners, until the first server is shut down or one of them fails.
+func (c *Controller) serve(listeners []listener) error {
+	errs := make(chan error, len(listeners))
+
+	for _, l := range listeners[1:] {
+		server := l.server
+
+		c.Server.RegisterOnShutdown(func() {
+			_ = server.Shutdown(context.Background())
+		})
+	}
+
+	for _, l := range listeners {
+		go func(l listener) {
+			c.Log.Info().Str("address", l.server.Addr).Msg("listening")
+
+			if l.config.TLS.enabled() {
+				// certificates are served by the TLS config
+				errs <- l.server.ServeTLS(l.listener, "", "")
+				return
+			}
+
+			errs <- l.server.Serve(l.listener)
+		}(l)
+	}
+
+	err := <-errs
+	if !goerrors.Is(err, http.ErrServerClosed) {
+		c.Log.Error().Err(err).Msg("server failed, stopping all listeners")
+
+		_ = c.Server.Shutdown(context.Background())
+	}
+
+	return err
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"os"
+	"runtime/debug"
+
+	"github.com/anuvu/zot/pkg/scheduler"
+)
+
+const (
+	// lowMemoryGCPercent is the GOGC of the low memory profile, unless the GOGC environment variable is set.
+	lowMemoryGCPercent = 50
+	// lowMemoryMaxConcurrentTasks is the number of background tasks the low memory profile runs at the same
+	// time, unless the scheduler config sets it.
+	lowMemoryMaxConcurrentTasks = 1
+)
+
+// applyLowMemoryProfile trades speed and features for memory if the low memory profile is enabled: the heap
+// is collected more often, background tasks run one at a time and the search extension, with its indexing of
+// the images, query results cache and CVE database, is disabled. The configs are copied rather than changed in
+// place, those of the caller are left as they are.
+func (c *Controller) applyLowMemoryProfile() {
+	if !c.Config.LowMemory {
+		return
+	}
+
+	c.Log.Info().Msg("low memory profile enabled")
+
+	if os.Getenv("GOGC") == "" {
+		debug.SetGCPercent(lowMemoryGCPercent)
+	}
+
+	schedulerConfig := scheduler.Config{}
+	if c.Config.Scheduler != nil {
+		schedulerConfig = *c.Config.Scheduler
+	}
+
+	if schedulerConfig.MaxConcurrentTasks <= 0 {
+		schedulerConfig.MaxConcurrentTasks = lowMemoryMaxConcurrentTasks
+	}
+
+	c.Config.Scheduler = &schedulerConfig
+
+	if c.Config.Extensions != nil && c.Config.Extensions.Search != nil && c.Config.Extensions.Search.Enable {
+		c.Log.Warn().Msg("search isn't available with the low memory profile, disabling it")
+
+		extensions := *c.Config.Extensions
+		search := *extensions.Search
+		search.Enable = false
+		extensions.Search = &search
+		c.Config.Extensions = &extensions
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/storage"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// MediaTypePolicy decides which manifest and config media types the repositories under each prefix accept.
+type MediaTypePolicy struct {
+	prefixes []string
+	rules    map[string]MediaTypeRule
+}
+
+// NewMediaTypePolicy checks the media type patterns of each repository prefix.
+func NewMediaTypePolicy(config map[string]MediaTypeRule) (*MediaTypePolicy, error) {
+	mp := &MediaTypePolicy{rules: make(map[string]MediaTypeRule)}
+
+	for prefix, rule := range config {
+		for _, pattern := range append(append([]string{}, rule.Manifests...), rule.Configs...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, err
+			}
+		}
+
+		prefix = strings.Trim(prefix, "/")
+		mp.rules[prefix] = rule
+		mp.prefixes = append(mp.prefixes, prefix)
+	}
+
+	// the most specific (longest) prefix wins when several match a repository
+	sort.Slice(mp.prefixes, func(i, j int) bool {
+		return len(mp.prefixes[i]) > len(mp.prefixes[j])
+	})
+
+	return mp, nil
+}
+
+// rule returns the rule of the longest prefix the repository is under.
+func (mp *MediaTypePolicy) rule(repo string) (string, MediaTypeRule, bool) {
+	for _, prefix := range mp.prefixes {
+		if prefix == "" || repo == prefix || strings.HasPrefix(repo, prefix+"/") {
+			return prefix, mp.rules[prefix], true
+		}
+	}
+
+	return "", MediaTypeRule{}, false
+}
+
+func matchMediaType(patterns []string, mediaType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check returns ErrMediaTypeNotAllowed, with the media type and the allowed ones, if the repository doesn't
+// accept the manifest or its config.
+func (mp *MediaTypePolicy) Check(repo, mediaType string, body []byte) error {
+	prefix, rule, ok := mp.rule(repo)
+	if !ok {
+		return nil
+	}
+
+	if !matchMediaType(rule.Manifests, mediaType) {
+		return fmt.Errorf("%w: manifest %s under %q, allowed: %s", errors.ErrMediaTypeNotAllowed, mediaType,
+			prefix+"/", strings.Join(rule.Manifests, ", "))
+	}
+
+	// the manifests of an index are checked when they are pushed
+	if storage.IsIndexMediaType(mediaType) {
+		return nil
+	}
+
+	var manifest ispec.Manifest
+
+	// invalid manifests are refused by the image store
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil
+	}
+
+	if !matchMediaType(rule.Configs, manifest.Config.MediaType) {
+		return fmt.Errorf("%w: config %s under %q, allowed: %s", errors.ErrMediaTypeNotAllowed,
+			manifest.Config.MediaType, prefix+"/", strings.Join(rule.Configs, ", "))
+	}
+
+	return nil
+}
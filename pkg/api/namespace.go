@@ -0,0 +1,430 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/gorilla/mux"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// namespacesFile keeps the namespaces in the root directory of the default image store.
+const namespacesFile = "namespaces.json"
+
+// Namespace owns the repositories under its name, e.g. "team-a/app" for "team-a". Only its members and
+// the admin users push to them, and they get its defaults: the size quota of each repository, in bytes,
+// and the TTL of the tags pushed without one, e.g. "720h".
+type Namespace struct {
+	Name      string   `json:"name"`
+	Members   []string `json:"members"`
+	RepoQuota int64    `json:"repoQuota,omitempty"`
+	TagTTL    string   `json:"tagTTL,omitempty"`
+}
+
+func (ns Namespace) validate() error {
+	if ns.RepoQuota < 0 {
+		return errors.ErrBadConfig
+	}
+
+	if ns.TagTTL != "" {
+		if ttl, err := time.ParseDuration(ns.TagTTL); err != nil || ttl <= 0 {
+			return errors.ErrBadConfig
+		}
+	}
+
+	return nil
+}
+
+func (ns Namespace) isMember(username string) bool {
+	for _, member := range ns.Members {
+		if member == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+// namespaces are saved to a JSON file on each change.
+type namespaces struct {
+	file string
+	lock sync.RWMutex
+	list map[string]Namespace
+}
+
+func loadNamespaces(file string) (*namespaces, error) {
+	ns := &namespaces{file: file, list: make(map[string]Namespace)}
+
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ns, nil
+		}
+
+		return nil, err
+	}
+
+	list := []Namespace{}
+	if err := json.Unmarshal(buf, &list); err != nil {
+		return nil, err
+	}
+
+	for _, namespace := range list {
+		ns.list[namespace.Name] = namespace
+	}
+
+	return ns, nil
+}
+
+// get returns the namespace of a repository, the innermost one if namespaces are nested.
+func (ns *namespaces) get(repo string) (Namespace, bool) {
+	ns.lock.RLock()
+	defer ns.lock.RUnlock()
+
+	for name := repo; ; name = path.Dir(name) {
+		if name != repo {
+			if namespace, ok := ns.list[name]; ok {
+				return namespace, true
+			}
+		}
+
+		if !strings.Contains(name, "/") {
+			return Namespace{}, false
+		}
+	}
+}
+
+// lookup returns the namespace of the name.
+func (ns *namespaces) lookup(name string) (Namespace, bool) {
+	ns.lock.RLock()
+	defer ns.lock.RUnlock()
+
+	namespace, ok := ns.list[name]
+
+	return namespace, ok
+}
+
+func (ns *namespaces) all() []Namespace {
+	ns.lock.RLock()
+	defer ns.lock.RUnlock()
+
+	return ns.sorted()
+}
+
+// sorted returns the namespaces by name, the caller must hold the lock.
+func (ns *namespaces) sorted() []Namespace {
+	list := make([]Namespace, 0, len(ns.list))
+	for _, namespace := range ns.list {
+		list = append(list, namespace)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// put creates or updates a namespace.
+func (ns *namespaces) put(namespace Namespace) error {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	previous, found := ns.list[namespace.Name]
+	ns.list[namespace.Name] = namespace
+
+	if err := ns.save(); err != nil {
+		// keep the namespaces as they are on disk
+		if found {
+			ns.list[namespace.Name] = previous
+		} else {
+			delete(ns.list, namespace.Name)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// delete deletes a namespace and returns it.
+func (ns *namespaces) delete(name string) (Namespace, error) {
+	ns.lock.Lock()
+	defer ns.lock.Unlock()
+
+	namespace, found := ns.list[name]
+	if !found {
+		return namespace, errors.ErrNamespaceNotFound
+	}
+
+	delete(ns.list, name)
+
+	if err := ns.save(); err != nil {
+		ns.list[name] = namespace
+
+		return namespace, err
+	}
+
+	return namespace, nil
+}
+
+func (ns *namespaces) save() error {
+	buf, err := json.MarshalIndent(ns.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(ns.file), 0755); err != nil {
+		return err
+	}
+
+	tmp := ns.file + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, ns.file)
+}
+
+// isNamespaceAllowed tells whether the user of a request may change the repositories of a namespace, the
+// members and the admin users may, anyone if authN isn't enabled.
+func (c *Controller) isNamespaceAllowed(r *http.Request, namespace Namespace) bool {
+	return c.isMemberOrAdmin(r, namespace.isMember)
+}
+
+// isMemberOrAdmin tells whether the user of a request is a member or an admin user, granted admin access by the
+// auth webhook or its bearer token otherwise, anyone is if authN isn't enabled.
+func (c *Controller) isMemberOrAdmin(r *http.Request, isMember func(username string) bool) bool {
+	if !isAuthnEnabled(c) || isAdminGranted(r) {
+		return true
+	}
+
+	username := getUsername(r)
+	if username == "" {
+		return false
+	}
+
+	if isMember(username) {
+		return true
+	}
+
+	for _, admin := range c.Config.HTTP.Auth.AdminUsers {
+		if admin == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NamespaceHandler lets only the members of a namespace push to and delete from its repositories.
+func NamespaceHandler(c *Controller) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name, ok := mux.Vars(r)["name"]
+			if !ok || r.Method == http.MethodGet || r.Method == http.MethodHead || isAdminRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			namespace, ok := c.namespaces.get(name)
+			if ok && !c.isNamespaceAllowed(r, namespace) {
+				c.Log.Warn().Str("username", getUsername(r)).Str("repository", name).Str("namespace", namespace.Name).
+					Msg("namespace access denied")
+				WriteJSON(w, http.StatusForbidden, NewErrorList(NewError(DENIED, map[string]string{"name": name})))
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkQuota returns ErrQuotaExceeded if pushing the manifest would make the repository bigger than the
+// quota of its namespace, ErrTenantQuotaExceeded if it would make its tenant bigger than the quota of the
+// tenant.
+func (rh *RouteHandler) checkQuota(is *storage.ImageStore, name, reference string, body []byte) error {
+	namespace, inNamespace := rh.c.namespaces.get(name)
+	tenant, inTenant := rh.c.tenants.Get(name)
+
+	if (!inNamespace || namespace.RepoQuota == 0) && (!inTenant || tenant.Quota == 0) {
+		return nil
+	}
+
+	size, growth := repoGrowth(is, name, reference, body)
+	if growth == 0 {
+		return nil
+	}
+
+	if inNamespace && namespace.RepoQuota != 0 && size+growth > namespace.RepoQuota {
+		return errors.ErrQuotaExceeded
+	}
+
+	if inTenant && rh.c.tenants.ExceedsQuota(tenant.Name, growth) {
+		return errors.ErrTenantQuotaExceeded
+	}
+
+	return nil
+}
+
+// repoGrowth returns the size of the repository and how much pushing the manifest would grow it, blobs
+// already in the repository not being counted.
+func repoGrowth(is *storage.ImageStore, name, reference string, body []byte) (int64, int64) {
+	var manifest ispec.Manifest
+
+	// invalid manifests are refused by the image store
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return 0, 0
+	}
+
+	size := int64(0)
+	known := make(map[godigest.Digest]bool)
+
+	// the repository doesn't exist yet otherwise
+	if rm, err := is.GetRepoMeta(name); err == nil {
+		size = rm.Size()
+
+		for _, m := range rm.Manifests {
+			known[m.Digest] = true
+
+			for _, layer := range m.Layers {
+				known[layer.Digest] = true
+			}
+		}
+	}
+
+	if known[storage.ManifestDigest(reference, body)] {
+		return size, 0
+	}
+
+	growth := int64(len(body)) + manifest.Config.Size
+
+	for _, layer := range manifest.Layers {
+		if !known[layer.Digest] {
+			growth += layer.Size
+			known[layer.Digest] = true
+		}
+	}
+
+	return size, growth
+}
+
+// setDefaultTagTTL makes a pushed tag expire after the TTL of its namespace, unless it already expires.
+func (rh *RouteHandler) setDefaultTagTTL(r *http.Request, is *storage.ImageStore, name, tag string) {
+	namespace, ok := rh.c.namespaces.get(name)
+	if !ok || namespace.TagTTL == "" {
+		return
+	}
+
+	if _, err := godigest.Parse(tag); err == nil {
+		return
+	}
+
+	expiries, err := is.GetTagExpiries(name)
+	if err != nil {
+		rh.logger(r).Error().Err(err).Str("repo", name).Msg("unable to read tag expiries")
+		return
+	}
+
+	if _, ok := expiries[tag]; ok {
+		return
+	}
+
+	ttl, _ := time.ParseDuration(namespace.TagTTL)
+
+	if _, err := is.SetTagExpiry(name, tag, ttl); err != nil {
+		rh.logger(r).Error().Err(err).Str("repo", name).Str("tag", tag).Msg("unable to set tag expiry")
+	}
+}
+
+// ListNamespaces godoc
+// @Summary List namespaces
+// @Description List the namespaces, their members and the defaults of their repositories
+// @Accept  json
+// @Produce json
+// @Success 200 {array} api.Namespace
+// @Router /v2/_zot/admin/namespaces [get].
+func (rh *RouteHandler) ListNamespaces(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, rh.c.namespaces.all())
+}
+
+// UpdateNamespace godoc
+// @Summary Create or update a namespace
+// @Description Create or update a namespace, only its members push to the repositories under it
+// @Accept  json
+// @Produce json
+// @Param   name       path    string        true    "namespace name"
+// @Param   namespace  body    api.Namespace true    "members and defaults of the repositories"
+// @Success 200 {object} api.Namespace
+// @Failure 400 {string} string "bad request"
+// @Failure 500 {string} string "internal server error"
+// @Router /v2/_zot/admin/namespaces/{name} [put].
+func (rh *RouteHandler) UpdateNamespace(w http.ResponseWriter, r *http.Request) {
+	var namespace Namespace
+
+	if err := json.NewDecoder(r.Body).Decode(&namespace); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	namespace.Name = mux.Vars(r)["name"]
+
+	if namespace.Members == nil {
+		namespace.Members = []string{}
+	}
+
+	if err := namespace.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := rh.c.namespaces.put(namespace); err != nil {
+		rh.logger(r).Error().Err(err).Msg("unable to save namespaces")
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	rh.logger(r).Info().Str("namespace", namespace.Name).Strs("members", namespace.Members).Msg("updated namespace")
+
+	WriteJSON(w, http.StatusOK, namespace)
+}
+
+// DeleteNamespace godoc
+// @Summary Delete a namespace
+// @Description Delete a namespace, its repositories are kept and anyone can push to them again
+// @Accept  json
+// @Produce json
+// @Param   name       path    string        true    "namespace name"
+// @Success 200 {object} api.Namespace
+// @Failure 404 {string} string "not found"
+// @Failure 500 {string} string "internal server error"
+// @Router /v2/_zot/admin/namespaces/{name} [delete].
+func (rh *RouteHandler) DeleteNamespace(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	namespace, err := rh.c.namespaces.delete(name)
+
+	switch {
+	case err == nil:
+		rh.logger(r).Info().Str("namespace", name).Msg("deleted namespace")
+		WriteJSON(w, http.StatusOK, namespace)
+	case errors.Is(err, errors.ErrNamespaceNotFound):
+		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
+	default:
+		rh.logger(r).Error().Err(err).Msg("unable to save namespaces")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
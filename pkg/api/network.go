@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// NetworkHandler refuses the requests of clients outside of the networks allowed for their operation,
+// before they're authenticated.
+func NetworkHandler(c *Controller) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// probes come from the node
+			if c.netPolicy == nil || (r.URL.Path == ReadinessRoute && r.Method == http.MethodGet) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			action := requestAction(r)
+			ip := c.netPolicy.ClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For"))
+
+			if !c.netPolicy.Allowed(action, ip) {
+				c.Log.Warn().Str("clientIP", ip.String()).Str("action", action).Str("path", r.URL.Path).
+					Msg("network access denied")
+				WriteJSON(w, http.StatusForbidden, NewErrorList(NewError(DENIED)))
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/swaggo/swag"
+)
+
+// ExtRoutePrefix is the prefix of zot-specific, non dist-spec, API routes.
+const ExtRoutePrefix = "/_zot/ext"
+
+// nolint: gochecknoglobals
+var (
+	pathParamRegexp = regexp.MustCompile(`{([^}]+)}`)
+
+	// summaries of routes which are not annotated for swag, keyed by path template
+	extRouteSummaries = map[string]string{
+		RoutePrefix + ExtRoutePrefix + "/openapi.json":          "OpenAPI document of the enabled API routes",
+		RoutePrefix + ExtensionsDiscoverRoute:                   "Extensions, their versions and whether they're enabled",
+		RoutePrefix + AdminRoutePrefix + "/scheduler":           "Background task scheduler status",
+		RoutePrefix + AdminRoutePrefix + "/diagnostics":         "Dump of the server state, as logged on SIGQUIT",
+		RoutePrefix + AdminRoutePrefix + "/deprecations/{name}": "Deprecate a repository or a tag",
+		RoutePrefix + AdminRoutePrefix + "/namespaces":          "Namespaces, their members and repository defaults",
+		RoutePrefix + AdminRoutePrefix + "/namespaces/{name}":   "Create, update or delete a namespace",
+		RoutePrefix + AdminRoutePrefix + "/tenants":             "Tenants, their members, quotas and storage usage",
+		RoutePrefix + AdminRoutePrefix + "/tenants/{name}":      "Update the members and the quota of a tenant",
+		RoutePrefix + AdminRoutePrefix + "/backup":              "Snapshots of the image stores, POST to take one",
+		RoutePrefix + ResourcesRoute + "/{kind}":                "Declarative admin resources of a kind",
+		RoutePrefix + ResourcesRoute + "/{kind}/{name}":         "Get or update an admin resource at its version",
+		RoutePrefix + ChangefeedRoute:                           "Pushes, deletes and tag moves following a cursor",
+		RoutePrefix + AdminRoutePrefix + "/sync":                "Replication status of downstream registries",
+		RoutePrefix + AdminRoutePrefix + "/sync/approve/{name}": "Approve an image quarantined by sync",
+		RoutePrefix + AdminRoutePrefix + "/dedupe":              "Dedupe report, POST to hard link duplicate blobs",
+		RoutePrefix + AdminRoutePrefix + "/tiering":             "Cold tier report, POST to move idle layers to it",
+		RoutePrefix + AdminRoutePrefix + "/gc/plan":             "Tags, manifests and blobs retention and GC would remove",
+		RoutePrefix + CVERefreshRoute:                           "Update the CVE database right away",
+		RoutePrefix + CVERawReportRoutePrefix + "/{name}":       "Scan an image for the JSON report of trivy",
+		RoutePrefix + AnnotationsRoutePrefix + "/{name}":        "Patch the annotations of a tagged manifest",
+		RoutePrefix + ExtRoutePrefix + "/ttl/{name}":            "Expiring tags of a repository, PUT to set a TTL",
+		RoutePrefix + PresignRoutePrefix + "/{name}":            "Mint a short-lived URL to pull or push a digest",
+		RoutePrefix + SessionRoute:                              "Log in for a session cookie, DELETE to log out",
+		DebugRoutePrefix + "/storage":                           "Image store lock and cache statistics",
+		DebugRoutePrefix + "/pprof/":                            "Go runtime profiles",
+		RoutePrefix + ExtRoutePrefix + "/userprefs":             "Star or bookmark a repository",
+		ReadinessRoute: "Readiness probe, not ready while low on disk space",
+		SearchRoute:    "GraphQL search API",
+		"/metrics":     "Prometheus metrics",
+		"/ui/":         "Web UI",
+		"/swagger/v2/": "Swagger UI",
+	}
+)
+
+// GetOpenAPI godoc
+// @Summary Get the OpenAPI document
+// @Description Get the OpenAPI document of the core and currently enabled extension routes
+// @Router 	/v2/_zot/ext/openapi.json [get]
+// @Accept  json
+// @Produce json
+// @Success 200 {string} string	"ok"
+// @Failure 500 {string} string "internal server error".
+func (rh *RouteHandler) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	doc, err := rh.buildOpenAPI(r)
+	if err != nil {
+		rh.logger(r).Error().Err(err).Msg("unable to build OpenAPI document")
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, doc)
+}
+
+// buildOpenAPI completes the swag generated document with the routes registered at runtime,
+// so that it reflects which extensions are enabled.
+func (rh *RouteHandler) buildOpenAPI(r *http.Request) (map[string]interface{}, error) {
+	base, err := swag.ReadDoc()
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(base), &doc); err != nil {
+		return nil, err
+	}
+
+	doc["host"] = r.Host
+	doc["basePath"] = "/"
+
+	if r.TLS != nil {
+		doc["schemes"] = []string{"https"}
+	} else {
+		doc["schemes"] = []string{"http"}
+	}
+
+	documented, _ := doc["paths"].(map[string]interface{})
+	paths := map[string]interface{}{}
+
+	err = rh.c.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			// not a handler, e.g. a subrouter or a redirect
+			return nil
+		}
+
+		path := stripPathRegexps(tmpl)
+
+		ops, _ := paths[path].(map[string]interface{})
+		if ops == nil {
+			ops = map[string]interface{}{}
+			paths[path] = ops
+		}
+
+		docOps, _ := documented[path].(map[string]interface{})
+
+		for _, method := range methods {
+			method = strings.ToLower(method)
+
+			if op, ok := docOps[method]; ok {
+				ops[method] = op
+			} else {
+				ops[method] = undocumentedOperation(path)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	doc["paths"] = paths
+
+	return doc, nil
+}
+
+// stripPathRegexps turns a mux path template into an OpenAPI path, e.g. "/v2/{name:[a-z]+}/" into "/v2/{name}/".
+func stripPathRegexps(tmpl string) string {
+	var sb strings.Builder
+
+	depth := 0
+	skip := false
+
+	for _, c := range tmpl {
+		switch {
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+
+			if depth == 0 {
+				skip = false
+			}
+		case c == ':' && depth == 1:
+			skip = true
+		}
+
+		if !skip {
+			sb.WriteRune(c)
+		}
+	}
+
+	return sb.String()
+}
+
+func undocumentedOperation(path string) map[string]interface{} {
+	summary, ok := extRouteSummaries[path]
+	if !ok {
+		summary = path
+	}
+
+	params := []map[string]interface{}{}
+
+	matches := pathParamRegexp.FindAllStringSubmatch(path, -1)
+	for _, match := range matches {
+		params = append(params, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"type":     "string",
+		})
+	}
+
+	return map[string]interface{}{
+		"summary":    summary,
+		"tags":       []string{"extensions"},
+		"parameters": params,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{"description": "ok"},
+		},
+	}
+}
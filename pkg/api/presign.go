@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/gorilla/mux"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// PresignRoutePrefix is where the pre-signed URLs of a repository are minted.
+const PresignRoutePrefix = ExtRoutePrefix + "/presign"
+
+// Actions allowed by pre-signed URLs.
+const (
+	// PresignPull allows to pull the blob or the manifest of the digest.
+	PresignPull = "pull"
+	// PresignPush allows a monolithic upload of the blob, or to push the manifest, of the digest.
+	PresignPush = "push"
+)
+
+// Query parameters of pre-signed URLs.
+const (
+	presignActionParam    = "zot-action"
+	presignUserParam      = "zot-user"
+	presignExpiresParam   = "zot-expires"
+	presignSignatureParam = "zot-signature"
+)
+
+const (
+	defaultPresignTTL    = 15 * time.Minute
+	defaultPresignMaxTTL = time.Hour
+	presignSecretSize    = 32
+)
+
+type contextKey int
+
+const (
+	presignedContextKey contextKey = iota
+	// adminGrantedContextKey marks the requests granted admin access by the auth webhook or a bearer token
+	adminGrantedContextKey
+)
+
+// PresignRequest asks for a URL allowing an action on a digest of a repository, for a TTL, e.g. "15m".
+type PresignRequest struct {
+	Digest string `json:"digest"`
+	Action string `json:"action"`
+	TTL    string `json:"ttl,omitempty"`
+}
+
+// PresignResponse is a pre-signed URL, relative to the registry, and when it expires. The query of pull
+// URLs also works on the manifest path of the digest, and the query of push URLs on its manifest PUT.
+type PresignResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// presigner signs and verifies the scope of pre-signed URLs with HMAC-SHA256.
+type presigner struct {
+	secret []byte
+	maxTTL time.Duration
+}
+
+func newPresigner(config *PresignConfig) (*presigner, error) {
+	p := &presigner{secret: []byte(config.Secret), maxTTL: config.MaxTTL}
+
+	if p.maxTTL == 0 {
+		p.maxTTL = defaultPresignMaxTTL
+	}
+
+	// URLs are valid until a restart, and only on this instance
+	if len(p.secret) == 0 {
+		p.secret = make([]byte, presignSecretSize)
+
+		if _, err := rand.Read(p.secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (p *presigner) sign(action, repo string, digest godigest.Digest, username string, expires int64) string {
+	mac := hmac.New(sha256.New, p.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%d", action, repo, digest, username, expires)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// presign returns the URL of a blob, or of its monolithic upload, with the signature of its scope as query.
+func (p *presigner) presign(action, repo string, digest godigest.Digest, username string,
+	expires time.Time) string {
+	query := url.Values{}
+	query.Set(presignActionParam, action)
+	query.Set(presignExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(presignSignatureParam, p.sign(action, repo, digest, username, expires.Unix()))
+
+	if username != "" {
+		query.Set(presignUserParam, username)
+	}
+
+	if action == PresignPush {
+		query.Set("digest", digest.String())
+
+		return fmt.Sprintf("%s/%s/blobs/uploads/?%s", RoutePrefix, repo, query.Encode())
+	}
+
+	return fmt.Sprintf("%s/%s/blobs/%s?%s", RoutePrefix, repo, digest, query.Encode())
+}
+
+// verify checks the signature of a request, and that it stays in the signed scope.
+func (p *presigner) verify(r *http.Request, now time.Time) bool {
+	query := r.URL.Query()
+	vars := mux.Vars(r)
+
+	expires, err := strconv.ParseInt(query.Get(presignExpiresParam), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return false
+	}
+
+	action := query.Get(presignActionParam)
+
+	var digest string
+
+	switch {
+	case action == PresignPull && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		digest = vars["digest"]
+		if digest == "" {
+			digest = vars["reference"]
+		}
+	case action == PresignPush && r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/blobs/uploads/"):
+		digest = query.Get("digest")
+	case action == PresignPush && r.Method == http.MethodPut && vars["reference"] != "":
+		digest = vars["reference"]
+	default:
+		return false
+	}
+
+	if _, err := godigest.Parse(digest); err != nil || vars["name"] == "" {
+		return false
+	}
+
+	signature, err := hex.DecodeString(query.Get(presignSignatureParam))
+	if err != nil {
+		return false
+	}
+
+	expected, _ := hex.DecodeString(p.sign(action, vars["name"], godigest.Digest(digest),
+		query.Get(presignUserParam), expires))
+
+	return hmac.Equal(signature, expected)
+}
+
+func isPresigned(r *http.Request) bool {
+	presigned, _ := r.Context().Value(presignedContextKey).(bool)
+
+	return presigned
+}
+
+// PresignHandler accepts the requests of valid pre-signed URLs in place of credentials, on behalf of the
+// user who minted them.
+func PresignHandler(c *Controller) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.presigner == nil || r.URL.Query().Get(presignSignatureParam) == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !c.presigner.verify(r, time.Now()) {
+				c.Log.Warn().Str("path", r.URL.Path).Str("method", r.Method).Msg("invalid pre-signed URL")
+				WriteJSON(w, http.StatusForbidden, NewErrorList(NewError(DENIED)))
+
+				return
+			}
+
+			r = withUsername(r, r.URL.Query().Get(presignUserParam))
+			r = r.WithContext(context.WithValue(r.Context(), presignedContextKey, true))
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CreatePresignedURL godoc
+// @Summary Mint a pre-signed URL
+// @Description Mint a short-lived URL to pull or push a digest of a repository without credentials,
+// @Description only users allowed to push to the repository mint them
+// @Accept  json
+// @Produce json
+// @Param   name     path    string              true        "repository name"
+// @Param   request  body    api.PresignRequest  true        "digest, action and TTL of the URL"
+// @Success 200 {object} api.PresignResponse
+// @Failure 400 {string} string "bad request"
+// @Failure 405 {string} string "method not allowed"
+// @Router /v2/_zot/ext/presign/{name} [post].
+func (rh *RouteHandler) CreatePresignedURL(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var request PresignRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	digest, err := godigest.Parse(request.Digest)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest,
+			NewErrorList(NewError(DIGEST_INVALID, map[string]string{"digest": request.Digest})))
+
+		return
+	}
+
+	if request.Action != PresignPull && request.Action != PresignPush {
+		WriteJSON(w, http.StatusBadRequest, NewErrorList(NewError(UNSUPPORTED, map[string]string{
+			"action": request.Action, "reason": errors.ErrInvalidArgs.Error()})))
+
+		return
+	}
+
+	if request.Action == PresignPush && rh.c.Config.HTTP.ReadOnly {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ttl := defaultPresignTTL
+
+	if request.TTL != "" {
+		if ttl, err = time.ParseDuration(request.TTL); err != nil || ttl <= 0 || ttl > rh.c.presigner.maxTTL {
+			WriteJSON(w, http.StatusBadRequest, NewErrorList(NewError(UNSUPPORTED, map[string]string{
+				"ttl": request.TTL, "maxTTL": rh.c.presigner.maxTTL.String()})))
+
+			return
+		}
+	}
+
+	username := getUsername(r)
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+
+	rh.logger(r).Info().Str("repo", name).Str("digest", digest.String()).Str("action", request.Action).
+		Time("expires", expires).Msg("minted pre-signed URL")
+
+	WriteJSON(w, http.StatusOK, PresignResponse{
+		URL:     rh.c.presigner.presign(request.Action, name, digest, username, expires),
+		Expires: expires,
+	})
+}
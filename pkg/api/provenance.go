@@ -0,0 +1,486 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/repomatch"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// InTotoMediaType is the media type of in-toto statements, and the artifact type of their referrers.
+	InTotoMediaType = "application/vnd.in-toto+json"
+	// DSSEMediaType is the media type of DSSE envelopes holding signed in-toto statements.
+	DSSEMediaType = "application/vnd.dsse.envelope.v1+json"
+
+	SLSAProvenanceV01 = "https://slsa.dev/provenance/v0.1"
+	SLSAProvenanceV02 = "https://slsa.dev/provenance/v0.2"
+	SLSAProvenanceV1  = "https://slsa.dev/provenance/v1"
+
+	ProvenanceModeWarn    = SignatureModeWarn
+	ProvenanceModeEnforce = SignatureModeEnforce
+
+	inTotoStatementV01 = "https://in-toto.io/Statement/v0.1"
+	inTotoStatementV1  = "https://in-toto.io/Statement/v1"
+)
+
+// ProvenancePolicy decides which builders are trusted to build the images of a repository.
+type ProvenancePolicy struct {
+	enforce  bool
+	global   *provenanceRule
+	patterns []string
+	repos    map[string]*provenanceRule
+}
+
+type provenanceRule struct {
+	builders []string
+	keys     []crypto.PublicKey
+}
+
+// NewProvenancePolicy loads the public keys of the provenance policy configuration.
+func NewProvenancePolicy(config *ProvenancePolicyConfig) (*ProvenancePolicy, error) {
+	pp := &ProvenancePolicy{repos: make(map[string]*provenanceRule)}
+
+	switch strings.ToLower(config.Mode) {
+	case "", ProvenanceModeWarn:
+	case ProvenanceModeEnforce:
+		pp.enforce = true
+	default:
+		return nil, fmt.Errorf("%w: unknown provenance verification mode %q", errors.ErrBadConfig, config.Mode)
+	}
+
+	global, err := newProvenanceRule(config.ProvenanceRule)
+	if err != nil {
+		return nil, err
+	}
+
+	pp.global = global
+
+	for pattern, rule := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+
+		repoRule, err := newProvenanceRule(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		pp.repos[pattern] = repoRule
+		pp.patterns = append(pp.patterns, pattern)
+	}
+
+	return pp, nil
+}
+
+// newProvenanceRule loads the PEM encoded public keys of a rule, it returns nil if no builder is trusted.
+func newProvenanceRule(rule ProvenanceRule) (*provenanceRule, error) {
+	if len(rule.Builders) == 0 {
+		if len(rule.Keys) != 0 {
+			return nil, fmt.Errorf("%w: provenance keys without builders", errors.ErrBadConfig)
+		}
+
+		return nil, nil
+	}
+
+	pr := &provenanceRule{builders: rule.Builders}
+
+	for _, file := range rule.Keys {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		block, _ := pem.Decode(buf)
+		if block == nil {
+			return nil, fmt.Errorf("%w: no PEM public key in %s", errors.ErrBadConfig, file)
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", errors.ErrBadConfig, file, err)
+		}
+
+		pr.keys = append(pr.keys, key)
+	}
+
+	return pr, nil
+}
+
+// Enforce returns true if pulls of unverified images are denied, otherwise they are only logged.
+func (pp *ProvenancePolicy) Enforce() bool {
+	return pp.enforce
+}
+
+// rule returns the builders trusted to build the images of the repository,
+// nil if their provenance isn't verified for this repository.
+func (pp *ProvenancePolicy) rule(repo string) *provenanceRule {
+	// the most specific (longest) pattern wins when several match a repository
+	if pattern, ok := repomatch.Longest(pp.patterns, repo); ok {
+		return pp.repos[pattern]
+	}
+
+	return pp.global
+}
+
+// isTrustedBuilder returns whether the builder is one of the trusted ones.
+func isTrustedBuilder(builders []string, builder string) bool {
+	for _, trusted := range builders {
+		if builder == trusted ||
+			(strings.HasSuffix(trusted, "*") && strings.HasPrefix(builder, strings.TrimSuffix(trusted, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// attestationManifest holds the fields of a manifest which tell whether it's an in-toto attestation.
+type attestationManifest struct {
+	ispec.Manifest
+	Subject      *ispec.Descriptor `json:"subject,omitempty"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+}
+
+// isAttestation returns whether the manifest attests another one with in-toto statements.
+func (m attestationManifest) isAttestation() bool {
+	if m.Subject == nil {
+		return false
+	}
+
+	if m.ArtifactType == InTotoMediaType || m.Config.MediaType == InTotoMediaType {
+		return true
+	}
+
+	for _, layer := range m.Layers {
+		if layer.MediaType == InTotoMediaType || layer.MediaType == DSSEMediaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAttestation returns whether the manifest is an in-toto attestation referring to another manifest,
+// which isn't subject to signature, provenance or vulnerability checks.
+func isAttestation(manifest []byte) bool {
+	_, ok := attestedSubject(manifest)
+
+	return ok
+}
+
+// attestedSubject returns the digest of the manifest an in-toto attestation refers to.
+func attestedSubject(manifest []byte) (godigest.Digest, bool) {
+	var m attestationManifest
+	if err := json.Unmarshal(manifest, &m); err != nil || !m.isAttestation() {
+		return "", false
+	}
+
+	return m.Subject.Digest, true
+}
+
+// VerifyImage checks that the manifest, of the given digest, has a SLSA provenance attestation from a trusted
+// builder among its referrers. It returns nil if the provenance of the images of the repository isn't verified, or if the manifest
+// refers to another one, e.g. an attestation or a signature, otherwise the result of the verification.
+func (pp *ProvenancePolicy) VerifyImage(is *storage.ImageStore, repo string, digest godigest.Digest,
+	manifest []byte) (*storage.ProvenanceSummary, error) {
+	rule := pp.rule(repo)
+	if rule == nil {
+		return nil, nil
+	}
+
+	var m attestationManifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return nil, err
+	}
+
+	if m.Subject != nil {
+		return nil, nil
+	}
+
+	target := digest
+	summary := &storage.ProvenanceSummary{Checked: time.Now()}
+
+	referrers, err := is.GetReferrers(repo, target, "")
+	if err != nil {
+		return nil, err
+	}
+
+	verr := errors.ErrProvenanceNotFound
+
+	for _, desc := range referrers {
+		predicateType, builder, err := verifyAttestationReferrer(is, repo, desc, target, rule)
+		if err != nil {
+			// other referrers, e.g. signatures, don't hide why attestations aren't valid
+			if !errors.Is(err, errors.ErrProvenanceNotFound) {
+				verr = err
+			}
+
+			continue
+		}
+
+		summary.Valid = true
+		summary.Attestation = desc.Digest
+		summary.PredicateType = predicateType
+		summary.Builder = builder
+
+		return summary, nil
+	}
+
+	summary.Reason = verr.Error()
+
+	return summary, verr
+}
+
+// verifyAttestationReferrer checks the in-toto statements of a referrer, until one is a valid provenance.
+func verifyAttestationReferrer(is *storage.ImageStore, repo string, desc ispec.Descriptor, target godigest.Digest,
+	rule *provenanceRule) (string, string, error) {
+	buf, _, _, err := is.GetImageManifest(repo, desc.Digest.String())
+	if err != nil {
+		return "", "", err
+	}
+
+	var m attestationManifest
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return "", "", err
+	}
+
+	verr := errors.ErrProvenanceNotFound
+
+	if !m.isAttestation() {
+		return "", "", verr
+	}
+
+	for _, layer := range m.Layers {
+		if layer.MediaType != InTotoMediaType && layer.MediaType != DSSEMediaType {
+			continue
+		}
+
+		content, err := getAttestationBlob(is, repo, layer)
+		if err != nil {
+			verr = err
+			continue
+		}
+
+		predicateType, builder, err := VerifyProvenanceAttestation(content, layer.MediaType, target,
+			rule.builders, rule.keys)
+		if err != nil {
+			verr = err
+			continue
+		}
+
+		return predicateType, builder, nil
+	}
+
+	return "", "", verr
+}
+
+func getAttestationBlob(is *storage.ImageStore, repo string, desc ispec.Descriptor) ([]byte, error) {
+	reader, _, err := is.GetBlob(repo, desc.Digest.String(), desc.MediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	return ioutil.ReadAll(reader)
+}
+
+// dsseEnvelope is a DSSE envelope, its payload is signed along with its type.
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+type inTotoStatement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// slsaProvenance holds the builder of the SLSA provenance predicates, v0.x and v1.
+type slsaProvenance struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+	} `json:"runDetails"`
+}
+
+// VerifyProvenanceAttestation checks that the in-toto statement, or the DSSE envelope holding it, is a SLSA
+// provenance of the target manifest by one of the trusted builders, signed by one of the keys if any is given.
+// It returns the predicate type and the builder of the provenance.
+func VerifyProvenanceAttestation(content []byte, mediaType string, target godigest.Digest, builders []string,
+	keys []crypto.PublicKey) (string, string, error) {
+	statement := content
+
+	switch mediaType {
+	case DSSEMediaType:
+		payload, err := verifyDSSE(content, keys)
+		if err != nil {
+			return "", "", err
+		}
+
+		statement = payload
+	case InTotoMediaType:
+		if len(keys) != 0 {
+			return "", "", fmt.Errorf("%w: statement isn't signed", errors.ErrBadAttestation)
+		}
+	default:
+		return "", "", fmt.Errorf("%w: unsupported media type %q", errors.ErrBadAttestation, mediaType)
+	}
+
+	var st inTotoStatement
+	if err := json.Unmarshal(statement, &st); err != nil {
+		return "", "", fmt.Errorf("%w: %v", errors.ErrBadAttestation, err)
+	}
+
+	if st.Type != inTotoStatementV01 && st.Type != inTotoStatementV1 {
+		return "", "", fmt.Errorf("%w: unsupported statement type %q", errors.ErrBadAttestation, st.Type)
+	}
+
+	if st.PredicateType != SLSAProvenanceV01 && st.PredicateType != SLSAProvenanceV02 &&
+		st.PredicateType != SLSAProvenanceV1 {
+		return "", "", fmt.Errorf("%w: %q isn't a SLSA provenance", errors.ErrProvenanceNotFound, st.PredicateType)
+	}
+
+	attested := false
+
+	for _, subject := range st.Subject {
+		if subject.Digest[target.Algorithm().String()] == target.Encoded() {
+			attested = true
+			break
+		}
+	}
+
+	if !attested {
+		return "", "", fmt.Errorf("%w: %s isn't a subject of the statement", errors.ErrBadAttestation, target)
+	}
+
+	var provenance slsaProvenance
+	if err := json.Unmarshal(st.Predicate, &provenance); err != nil {
+		return "", "", fmt.Errorf("%w: %v", errors.ErrBadAttestation, err)
+	}
+
+	builder := provenance.Builder.ID
+	if st.PredicateType == SLSAProvenanceV1 {
+		builder = provenance.RunDetails.Builder.ID
+	}
+
+	if builder == "" || !isTrustedBuilder(builders, builder) {
+		return "", "", fmt.Errorf("%w: %q", errors.ErrUntrustedBuilder, builder)
+	}
+
+	return st.PredicateType, builder, nil
+}
+
+// verifyDSSE checks that a DSSE envelope holding an in-toto statement is signed by one of the keys,
+// if any is given, and returns its payload.
+func verifyDSSE(content []byte, keys []crypto.PublicKey) ([]byte, error) {
+	var env dsseEnvelope
+	if err := json.Unmarshal(content, &env); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrBadAttestation, err)
+	}
+
+	if env.PayloadType != InTotoMediaType {
+		return nil, fmt.Errorf("%w: unsupported payload type %q", errors.ErrBadAttestation, env.PayloadType)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrBadAttestation, err)
+	}
+
+	if len(keys) == 0 {
+		return payload, nil
+	}
+
+	// the pre-authentication encoding of the payload and its type is signed
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(env.PayloadType), env.PayloadType, len(payload), payload)
+
+	for _, signature := range env.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			continue
+		}
+
+		for _, key := range keys {
+			if verifySignature(key, []byte(pae), sig) {
+				return payload, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: not signed by a trusted key", errors.ErrBadAttestation)
+}
+
+// verifySignature checks a signature made with an ECDSA (ASN.1 encoded), RSA (PKCS #1 v1.5 or PSS)
+// or Ed25519 key.
+func verifySignature(key crypto.PublicKey, message, sig []byte) bool {
+	switch pub := key.(type) {
+	case *ecdsa.PublicKey:
+		hash := crypto.SHA256
+
+		switch pub.Curve {
+		case elliptic.P384():
+			hash = crypto.SHA384
+		case elliptic.P521():
+			hash = crypto.SHA512
+		}
+
+		hasher := hash.New()
+		_, _ = hasher.Write(message)
+
+		var rs struct {
+			R, S *big.Int
+		}
+
+		if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) != 0 {
+			return false
+		}
+
+		return ecdsa.Verify(pub, hasher.Sum(nil), rs.R, rs.S)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil ||
+			rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, nil) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, message, sig)
+	}
+
+	return false
+}
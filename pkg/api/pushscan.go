@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/extensions/pushscan"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// pushRollback returns how to restore a tag as it was before a push, if the push is scanned before being
+// accepted, nil otherwise. The tag is deleted if it didn't exist.
+func (rh *RouteHandler) pushRollback(is *storage.ImageStore, name, reference string) func() error {
+	if rh.c.pushScanner == nil || !rh.c.pushScanner.Blocking() || !rh.c.pushScanner.Matches(name) {
+		return nil
+	}
+
+	if _, err := godigest.Parse(reference); err == nil {
+		return nil
+	}
+
+	previous, _, mediaType, err := is.GetImageManifest(name, reference)
+	if err != nil {
+		return func() error {
+			// the tag was first pushed by this request, even if it's immutable
+			return is.WithoutTagPolicy().DeleteImageManifest(name, reference)
+		}
+	}
+
+	return func() error {
+		_, err := is.PutImageManifest(name, reference, mediaType, previous)
+
+		return err
+	}
+}
+
+// scanBypassReason returns why the pusher of a manifest bypasses the scan of its image, empty if it doesn't.
+func scanBypassReason(body []byte) string {
+	var manifest ispec.Manifest
+
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return ""
+	}
+
+	return manifest.Annotations[pushscan.BypassAnnotation]
+}
+
+// scanPushedImage scans a pushed image if scan on push is enabled for its repository. A blocking scan
+// rolls back the push of vulnerable images, unless an admin user bypasses it, and writes the response,
+// false is returned then. Other images are queued to be scanned.
+func (rh *RouteHandler) scanPushedImage(w http.ResponseWriter, r *http.Request, name, reference string,
+	body []byte, rollback func() error) bool {
+	if rh.c.pushScanner == nil || !rh.c.pushScanner.Matches(name) {
+		return true
+	}
+
+	if _, err := godigest.Parse(reference); err == nil || isAttestation(body) {
+		return true
+	}
+
+	if rollback == nil {
+		rh.c.pushScanner.Notify(name, reference)
+		return true
+	}
+
+	if reason := scanBypassReason(body); reason != "" && rh.c.isAdminUser(r) {
+		rh.logger(r).Warn().Str("repository", name).Str("tag", reference).Str("reason", reason).
+			Msg("scan on push bypassed")
+		rh.c.pushScanner.Notify(name, reference)
+
+		return true
+	}
+
+	severity, err := rh.c.pushScanner.Check(r.Context(), name, reference)
+	if err == nil && severity == "" {
+		return true
+	}
+
+	if err != nil && rh.c.pushScanner.FailOpen() {
+		rh.logger(r).Error().Err(err).Str("repository", name).Str("tag", reference).
+			Msg("unable to scan pushed image, accepting it")
+
+		return true
+	}
+
+	if rollbackErr := rollback(); rollbackErr != nil {
+		rh.logger(r).Error().Err(rollbackErr).Str("repository", name).Str("tag", reference).
+			Msg("unable to roll back rejected push")
+	}
+
+	if err != nil {
+		rh.logger(r).Error().Err(err).Str("repository", name).Str("tag", reference).
+			Msg("unable to scan pushed image, rejecting it")
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return false
+	}
+
+	rh.logger(r).Warn().Str("repository", name).Str("tag", reference).Str("severity", severity).
+		Msg("rejecting image with vulnerabilities at or above the severity threshold")
+	WriteJSON(w, http.StatusForbidden,
+		NewErrorList(NewError(DENIED, map[string]string{"reference": reference, "severity": severity,
+			"reason": errors.ErrVulnerableImage.Error()})))
+
+	return false
+}
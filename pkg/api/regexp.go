@@ -26,6 +26,12 @@ var (
 	NameRegexp = expression(
 		nameComponentRegexp,
 		optional(repeated(literal(`/`), nameComponentRegexp)))
+
+	// tenantNameRegexp matches the route prefixes of the tenants, a single name component.
+	tenantNameRegexp = match(`^` + nameComponentRegexp.String() + `$`)
+
+	// repoNameRegexp matches whole repository names, e.g. the names of the repos admin resources.
+	repoNameRegexp = match(`^` + NameRegexp.String() + `$`)
 )
 
 // match compiles the string to a regular expression.
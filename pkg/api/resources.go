@@ -0,0 +1,550 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	zotsync "github.com/anuvu/zot/pkg/extensions/sync"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/gorilla/mux"
+)
+
+// ResourcesRoute serves the declarative admin resources, e.g. /v2/_zot/admin/resources/policies/tags.
+const ResourcesRoute = AdminRoutePrefix + "/resources"
+
+// resourcesFile keeps the resources updated through the admin API, in the root directory of the default
+// image store, they're applied over the configuration on startup.
+const resourcesFile = "resources.json"
+
+// Kinds of the admin resources.
+const (
+	// ResourceRepos are the namespaces of the repositories under their name
+	ResourceRepos = "repos"
+	// ResourcePolicies are the policies of the pushed images, "tags" being the tag immutability policy
+	ResourcePolicies = "policies"
+	// ResourceSyncRules are the content rules of the downstream registries, named after their host
+	ResourceSyncRules = "syncrules"
+	// ResourceRetentionRules are the retention of the expired tags of the storage paths, named after their
+	// route, "_default" for the default one
+	ResourceRetentionRules = "retentionrules"
+
+	tagPolicyResource    = "tags"
+	defaultStoreResource = "_default"
+)
+
+// Resource is an admin resource, reconciled by an operator or a GitOps controller with GET and PUT. Its
+// ResourceVersion changes whenever its spec does, a PUT with the version of a previous GET fails with
+// 409 Conflict if the resource was changed meanwhile, a PUT without version overwrites it.
+type Resource struct {
+	Kind            string          `json:"kind"`
+	Name            string          `json:"name"`
+	ResourceVersion string          `json:"resourceVersion,omitempty"`
+	Spec            json.RawMessage `json:"spec"`
+}
+
+// RepoSpec is the spec of a repos resource, see Namespace.
+type RepoSpec struct {
+	Members   []string `json:"members"`
+	RepoQuota int64    `json:"repoQuota,omitempty"`
+	TagTTL    string   `json:"tagTTL,omitempty"`
+}
+
+// TagRuleSpec is a rule of the tag policy, see TagPolicyRule.
+type TagRuleSpec struct {
+	Immutable []string `json:"immutable,omitempty"`
+	Exclude   []string `json:"exclude,omitempty"`
+}
+
+// TagPolicySpec is the spec of the "tags" policies resource, see TagPolicyConfig.
+type TagPolicySpec struct {
+	TagRuleSpec
+	Repositories map[string]TagRuleSpec `json:"repositories,omitempty"`
+}
+
+// SyncContentSpec selects repositories replicated to a downstream registry, see sync.ContentConfig.
+type SyncContentSpec struct {
+	Prefix      string `json:"prefix,omitempty"`
+	Destination string `json:"destination,omitempty"`
+}
+
+// SyncRuleSpec is the spec of a syncrules resource, all the repositories are replicated without content.
+type SyncRuleSpec struct {
+	Content []SyncContentSpec `json:"content"`
+}
+
+// RetentionRuleSpec is the spec of a retentionrules resource, the expired tags pulled within
+// RetainPulledWithin, e.g. "720h", are kept, none if empty.
+type RetentionRuleSpec struct {
+	RetainPulledWithin string `json:"retainPulledWithin,omitempty"`
+}
+
+// resources serializes the updates of the resources, and saves the specs of those the configuration
+// defines, repos being saved as namespaces.
+type resources struct {
+	file  string
+	lock  sync.Mutex
+	specs map[string]map[string]json.RawMessage // by kind and name
+}
+
+func loadResources(file string) (*resources, error) {
+	res := &resources{file: file, specs: make(map[string]map[string]json.RawMessage)}
+
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(buf, &res.specs); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (res *resources) save() error {
+	buf, err := json.MarshalIndent(res.specs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := res.file + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, res.file)
+}
+
+// resourceVersion returns the version of a spec, the hash of its JSON encoding.
+func resourceVersion(spec interface{}) (string, json.RawMessage, error) {
+	buf, err := json.Marshal(spec)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sum := sha256.Sum256(buf)
+
+	return hex.EncodeToString(sum[:8]), buf, nil
+}
+
+// enableResources applies the resources saved by a previous run over the configuration, those which no
+// longer exist, e.g. a downstream registry removed from the configuration, are skipped.
+func (c *Controller) enableResources() error {
+	res, err := loadResources(path.Join(c.Config.Storage.RootDirectory, resourcesFile))
+	if err != nil {
+		c.Log.Error().Err(err).Msg("unable to load admin resources")
+		return err
+	}
+
+	c.resources = res
+
+	for kind, specs := range res.specs {
+		for name, spec := range specs {
+			if err := c.applyResource(kind, name, spec); err != nil {
+				c.Log.Warn().Err(err).Str("kind", kind).Str("name", name).Msg("skipping saved admin resource")
+			}
+		}
+	}
+
+	return nil
+}
+
+// getResource returns the resource, ErrResourceNotFound if it doesn't exist.
+func (c *Controller) getResource(kind, name string) (Resource, error) {
+	var spec interface{}
+
+	switch kind {
+	case ResourceRepos:
+		namespace, ok := c.namespaces.lookup(name)
+		if !ok {
+			return Resource{}, errors.ErrResourceNotFound
+		}
+
+		spec = RepoSpec{Members: namespace.Members, RepoQuota: namespace.RepoQuota, TagTTL: namespace.TagTTL}
+	case ResourcePolicies:
+		if name != tagPolicyResource {
+			return Resource{}, errors.ErrResourceNotFound
+		}
+
+		spec = newTagPolicySpec(c.getTagPolicy())
+	case ResourceSyncRules:
+		if c.replicator == nil {
+			return Resource{}, errors.ErrResourceNotFound
+		}
+
+		content, ok := c.replicator.Content()[name]
+		if !ok {
+			return Resource{}, errors.ErrResourceNotFound
+		}
+
+		syncSpec := SyncRuleSpec{Content: []SyncContentSpec{}}
+		for _, rule := range content {
+			syncSpec.Content = append(syncSpec.Content, SyncContentSpec{Prefix: rule.Prefix,
+				Destination: rule.Destination})
+		}
+
+		spec = syncSpec
+	case ResourceRetentionRules:
+		imgStore := c.storeByResourceName(name)
+		if imgStore == nil {
+			return Resource{}, errors.ErrResourceNotFound
+		}
+
+		retentionSpec := RetentionRuleSpec{}
+		if window := imgStore.PullRetention(); window > 0 {
+			retentionSpec.RetainPulledWithin = window.String()
+		}
+
+		spec = retentionSpec
+	default:
+		return Resource{}, errors.ErrResourceKindUnknown
+	}
+
+	version, buf, err := resourceVersion(spec)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	return Resource{Kind: kind, Name: name, ResourceVersion: version, Spec: buf}, nil
+}
+
+// listResourceNames returns the names of the resources of a kind.
+func (c *Controller) listResourceNames(kind string) ([]string, error) {
+	names := []string{}
+
+	switch kind {
+	case ResourceRepos:
+		for _, namespace := range c.namespaces.all() {
+			names = append(names, namespace.Name)
+		}
+	case ResourcePolicies:
+		names = append(names, tagPolicyResource)
+	case ResourceSyncRules:
+		if c.replicator != nil {
+			for host := range c.replicator.Content() {
+				names = append(names, host)
+			}
+		}
+	case ResourceRetentionRules:
+		names = append(names, defaultStoreResource)
+
+		for route := range c.StoreController.SubStore {
+			names = append(names, strings.Trim(route, "/"))
+		}
+	default:
+		return nil, errors.ErrResourceKindUnknown
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// putResource updates the resource if version is empty or its current version, and returns it updated.
+// Repos are created if they don't exist, the resources of the other kinds come from the configuration.
+func (c *Controller) putResource(kind, name, version string, spec json.RawMessage) (Resource, error) {
+	c.resources.lock.Lock()
+	defer c.resources.lock.Unlock()
+
+	current, err := c.getResource(kind, name)
+
+	switch {
+	case errors.Is(err, errors.ErrResourceNotFound) && kind == ResourceRepos:
+	case err != nil:
+		return Resource{}, err
+	}
+
+	if version != "" && version != current.ResourceVersion {
+		return Resource{}, errors.ErrResourceConflict
+	}
+
+	if err := c.applyResource(kind, name, spec); err != nil {
+		return Resource{}, err
+	}
+
+	updated, err := c.getResource(kind, name)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	if kind == ResourceRepos {
+		return updated, nil
+	}
+
+	if c.resources.specs[kind] == nil {
+		c.resources.specs[kind] = make(map[string]json.RawMessage)
+	}
+
+	c.resources.specs[kind][name] = updated.Spec
+
+	return updated, c.resources.save()
+}
+
+// applyResource validates the spec of the resource and applies it, ErrBadConfig if it's invalid.
+func (c *Controller) applyResource(kind, name string, spec json.RawMessage) error {
+	switch kind {
+	case ResourceRepos:
+		var repoSpec RepoSpec
+		if !repoNameRegexp.MatchString(name) {
+			return errors.ErrBadConfig
+		}
+
+		if err := json.Unmarshal(spec, &repoSpec); err != nil {
+			return errors.ErrBadConfig
+		}
+
+		namespace := Namespace{Name: name, Members: repoSpec.Members, RepoQuota: repoSpec.RepoQuota,
+			TagTTL: repoSpec.TagTTL}
+		if namespace.Members == nil {
+			namespace.Members = []string{}
+		}
+
+		if err := namespace.validate(); err != nil {
+			return err
+		}
+
+		return c.namespaces.put(namespace)
+	case ResourcePolicies:
+		var tagSpec TagPolicySpec
+		if name != tagPolicyResource {
+			return errors.ErrResourceNotFound
+		}
+
+		if err := json.Unmarshal(spec, &tagSpec); err != nil {
+			return errors.ErrBadConfig
+		}
+
+		tagPolicy, err := NewTagPolicy(tagSpec.config())
+		if err != nil {
+			return errors.ErrBadConfig
+		}
+
+		c.tagPolicy.Store(tagPolicy)
+		c.Log.Info().Interface("policy", tagSpec).Msg("updated tag policy")
+
+		return nil
+	case ResourceSyncRules:
+		var syncSpec SyncRuleSpec
+		if c.replicator == nil {
+			return errors.ErrResourceNotFound
+		}
+
+		if err := json.Unmarshal(spec, &syncSpec); err != nil {
+			return errors.ErrBadConfig
+		}
+
+		content := make([]zotsync.ContentConfig, 0, len(syncSpec.Content))
+		for _, rule := range syncSpec.Content {
+			content = append(content, zotsync.ContentConfig{Prefix: rule.Prefix, Destination: rule.Destination})
+		}
+
+		if err := c.replicator.SetContent(name, content); err != nil {
+			return errors.ErrResourceNotFound
+		}
+
+		return nil
+	case ResourceRetentionRules:
+		return c.applyRetentionRule(name, spec)
+	default:
+		return errors.ErrResourceKindUnknown
+	}
+}
+
+func (c *Controller) applyRetentionRule(name string, spec json.RawMessage) error {
+	imgStore := c.storeByResourceName(name)
+	if imgStore == nil {
+		return errors.ErrResourceNotFound
+	}
+
+	var retentionSpec RetentionRuleSpec
+	if err := json.Unmarshal(spec, &retentionSpec); err != nil {
+		return errors.ErrBadConfig
+	}
+
+	window := time.Duration(0)
+
+	if retentionSpec.RetainPulledWithin != "" {
+		var err error
+
+		if window, err = time.ParseDuration(retentionSpec.RetainPulledWithin); err != nil || window < 0 {
+			return errors.ErrBadConfig
+		}
+	}
+
+	imgStore.SetPullRetention(window)
+	c.Log.Info().Str("storage", name).Str("retainPulledWithin", window.String()).Msg("updated retention rule")
+
+	return nil
+}
+
+// storeByResourceName returns the image store of a retention rule, nil if there is none.
+func (c *Controller) storeByResourceName(name string) *storage.ImageStore {
+	if name == defaultStoreResource {
+		return c.StoreController.DefaultStore
+	}
+
+	return c.StoreController.SubStore["/"+name]
+}
+
+func newTagPolicySpec(tagPolicy *TagPolicy) TagPolicySpec {
+	spec := TagPolicySpec{}
+	if tagPolicy == nil {
+		return spec
+	}
+
+	spec.TagRuleSpec = TagRuleSpec(tagPolicy.config.TagPolicyRule)
+
+	if len(tagPolicy.config.Repositories) > 0 {
+		spec.Repositories = make(map[string]TagRuleSpec, len(tagPolicy.config.Repositories))
+
+		for pattern, rule := range tagPolicy.config.Repositories {
+			spec.Repositories[pattern] = TagRuleSpec(rule)
+		}
+	}
+
+	return spec
+}
+
+func (spec TagPolicySpec) config() *TagPolicyConfig {
+	config := &TagPolicyConfig{TagPolicyRule: TagPolicyRule(spec.TagRuleSpec),
+		Repositories: make(map[string]TagPolicyRule, len(spec.Repositories))}
+
+	for pattern, rule := range spec.Repositories {
+		config.Repositories[pattern] = TagPolicyRule(rule)
+	}
+
+	return config
+}
+
+// writeResourceError writes the response of a failed request on a resource.
+func (rh *RouteHandler) writeResourceError(w http.ResponseWriter, r *http.Request, kind, name string, err error) {
+	switch {
+	case errors.Is(err, errors.ErrResourceKindUnknown):
+		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"kind": kind})))
+	case errors.Is(err, errors.ErrResourceNotFound):
+		WriteJSON(w, http.StatusNotFound,
+			NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"kind": kind, "name": name})))
+	case errors.Is(err, errors.ErrResourceConflict):
+		WriteJSON(w, http.StatusConflict,
+			NewErrorList(NewError(DENIED, map[string]string{"kind": kind, "name": name, "reason": err.Error()})))
+	case errors.Is(err, errors.ErrBadConfig):
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		rh.logger(r).Error().Err(err).Str("kind", kind).Str("name", name).Msg("unable to update resource")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// ListResources godoc
+// @Summary List admin resources
+// @Description List the resources of a kind: repos, policies, syncrules or retentionrules
+// @Accept  json
+// @Produce json
+// @Param   kind       path    string        true    "resource kind"
+// @Success 200 {array} api.Resource
+// @Failure 404 {string} string "not found"
+// @Router /v2/_zot/admin/resources/{kind} [get].
+func (rh *RouteHandler) ListResources(w http.ResponseWriter, r *http.Request) {
+	kind := mux.Vars(r)["kind"]
+
+	names, err := rh.c.listResourceNames(kind)
+	if err != nil {
+		rh.writeResourceError(w, r, kind, "", err)
+		return
+	}
+
+	list := make([]Resource, 0, len(names))
+
+	for _, name := range names {
+		resource, err := rh.c.getResource(kind, name)
+		if errors.Is(err, errors.ErrResourceNotFound) {
+			// deleted meanwhile
+			continue
+		}
+
+		if err != nil {
+			rh.writeResourceError(w, r, kind, name, err)
+			return
+		}
+
+		list = append(list, resource)
+	}
+
+	WriteJSON(w, http.StatusOK, list)
+}
+
+// GetResource godoc
+// @Summary Get an admin resource
+// @Description Get the spec and the version of a resource
+// @Accept  json
+// @Produce json
+// @Param   kind       path    string        true    "resource kind"
+// @Param   name       path    string        true    "resource name"
+// @Success 200 {object} api.Resource
+// @Failure 404 {string} string "not found"
+// @Router /v2/_zot/admin/resources/{kind}/{name} [get].
+func (rh *RouteHandler) GetResource(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	resource, err := rh.c.getResource(vars["kind"], vars["name"])
+	if err != nil {
+		rh.writeResourceError(w, r, vars["kind"], vars["name"], err)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, resource)
+}
+
+// UpdateResource godoc
+// @Summary Update an admin resource
+// @Description Replace the spec of a resource, only if it's still at the given resource version if any.
+// @Description Repos are created if they don't exist.
+// @Accept  json
+// @Produce json
+// @Param   kind       path    string        true    "resource kind"
+// @Param   name       path    string        true    "resource name"
+// @Param   resource   body    api.Resource  true    "spec and optional resource version"
+// @Success 200 {object} api.Resource
+// @Failure 400 {string} string "bad request"
+// @Failure 404 {string} string "not found"
+// @Failure 409 {string} string "conflict"
+// @Failure 500 {string} string "internal server error"
+// @Router /v2/_zot/admin/resources/{kind}/{name} [put].
+func (rh *RouteHandler) UpdateResource(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	kind, name := vars["kind"], vars["name"]
+
+	var resource Resource
+
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil || len(resource.Spec) == 0 ||
+		(resource.Kind != "" && resource.Kind != kind) || (resource.Name != "" && resource.Name != name) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	updated, err := rh.c.putResource(kind, name, resource.ResourceVersion, resource.Spec)
+	if err != nil {
+		rh.writeResourceError(w, r, kind, name, err)
+		return
+	}
+
+	rh.logger(r).Info().Str("kind", kind).Str("name", name).Str("resourceVersion", updated.ResourceVersion).
+		Msg("updated resource")
+
+	WriteJSON(w, http.StatusOK, updated)
+}
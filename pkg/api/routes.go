@@ -16,29 +16,43 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/pprof"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/anuvu/zot/docs" // as required by swaggo
 	"github.com/anuvu/zot/errors"
 	ext "github.com/anuvu/zot/pkg/extensions"
+	"github.com/anuvu/zot/pkg/extensions/sync"
 	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/scheduler"
 	"github.com/anuvu/zot/pkg/storage"
 	"github.com/gorilla/mux"
 	jsoniter "github.com/json-iterator/go"
+	godigest "github.com/opencontainers/go-digest"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
 const (
 	RoutePrefix          = "/v2"
+	AdminRoutePrefix     = "/_zot/admin"
+	DebugRoutePrefix     = "/debug"
+	CVERefreshRoute      = ExtRoutePrefix + "/cve/refresh"
+	ReadinessRoute       = "/readyz"
+	SearchRoute          = "/query"
 	DistAPIVersion       = "Docker-Distribution-API-Version"
 	DistContentDigestKey = "Docker-Content-Digest"
 	BlobUploadUUID       = "Blob-Upload-UUID"
 	DefaultMediaType     = "application/json"
 	BinaryMediaType      = "application/octet-stream"
+	// OriginalContentDigestKey is the digest of the stored manifest a served manifest was converted from.
+	OriginalContentDigestKey = "Zot-Original-Content-Digest"
+	// CVERawReportRoutePrefix serves the reports of the scans of images in the JSON format of trivy.
+	CVERawReportRoutePrefix = ExtRoutePrefix + "/cve/raw"
 )
 
 type RouteHandler struct {
@@ -53,7 +67,11 @@ func NewRouteHandler(c *Controller) *RouteHandler {
 }
 
 func (rh *RouteHandler) SetupRoutes() {
+	rh.c.Router.Use(NetworkHandler(rh.c))
+	rh.c.Router.Use(PresignHandler(rh.c))
 	rh.c.Router.Use(AuthHandler(rh.c))
+	rh.c.Router.Use(NamespaceHandler(rh.c))
+	rh.c.Router.Use(TenantHandler(rh.c))
 	g := rh.c.Router.PathPrefix(RoutePrefix).Subrouter()
 	{
 		g.HandleFunc(fmt.Sprintf("/{name:%s}/tags/list", NameRegexp.String()),
@@ -86,12 +104,111 @@ func (rh *RouteHandler) SetupRoutes() {
 			rh.ListRepositories).Methods("GET")
 		g.HandleFunc("/",
 			rh.CheckVersionSupport).Methods("GET")
+		g.HandleFunc(AdminRoutePrefix+"/scheduler",
+			AdminHandler(rh.c, rh.GetSchedulerStatus)).Methods("GET")
+		g.HandleFunc(AdminRoutePrefix+"/diagnostics",
+			AdminHandler(rh.c, rh.GetDiagnostics)).Methods("GET")
+		g.HandleFunc(fmt.Sprintf(AdminRoutePrefix+"/deprecations/{name:%s}", NameRegexp.String()),
+			AdminHandler(rh.c, rh.UpdateDeprecation)).Methods("PUT")
+		g.HandleFunc(fmt.Sprintf(AdminRoutePrefix+"/deprecations/{name:%s}", NameRegexp.String()),
+			AdminHandler(rh.c, rh.DeleteDeprecation)).Methods("DELETE")
+		g.HandleFunc(AdminRoutePrefix+"/namespaces",
+			AdminHandler(rh.c, rh.ListNamespaces)).Methods("GET")
+		g.HandleFunc(fmt.Sprintf(AdminRoutePrefix+"/namespaces/{name:%s}", NameRegexp.String()),
+			AdminHandler(rh.c, rh.UpdateNamespace)).Methods("PUT")
+		g.HandleFunc(fmt.Sprintf(AdminRoutePrefix+"/namespaces/{name:%s}", NameRegexp.String()),
+			AdminHandler(rh.c, rh.DeleteNamespace)).Methods("DELETE")
+		g.HandleFunc(ResourcesRoute+"/{kind}",
+			AdminHandler(rh.c, rh.ListResources)).Methods("GET")
+		g.HandleFunc(ResourcesRoute+"/{kind}/{name:.+}",
+			AdminHandler(rh.c, rh.GetResource)).Methods("GET")
+		g.HandleFunc(ResourcesRoute+"/{kind}/{name:.+}",
+			AdminHandler(rh.c, rh.UpdateResource)).Methods("PUT")
+		g.HandleFunc(AdminRoutePrefix+"/sync",
+			AdminHandler(rh.c, rh.GetSyncStatus)).Methods("GET")
+		g.HandleFunc(fmt.Sprintf(AdminRoutePrefix+"/sync/approve/{name:%s}", NameRegexp.String()),
+			AdminHandler(rh.c, rh.ApproveSyncedImage)).Methods("POST")
+		g.HandleFunc(AdminRoutePrefix+"/dedupe",
+			AdminHandler(rh.c, rh.GetDedupeReport)).Methods("GET")
+		g.HandleFunc(AdminRoutePrefix+"/dedupe",
+			AdminHandler(rh.c, rh.Rededupe)).Methods("POST")
+		g.HandleFunc(AdminRoutePrefix+"/tiering",
+			AdminHandler(rh.c, rh.GetTieringReport)).Methods("GET")
+		g.HandleFunc(AdminRoutePrefix+"/tiering",
+			AdminHandler(rh.c, rh.TierBlobs)).Methods("POST")
+		g.HandleFunc(AdminRoutePrefix+"/gc/plan",
+			AdminHandler(rh.c, rh.GetGCPlan)).Methods("GET")
+		g.HandleFunc(ExtRoutePrefix+"/openapi.json",
+			rh.GetOpenAPI).Methods("GET")
+		g.HandleFunc(ExtensionsDiscoverRoute,
+			rh.ListExtensions).Methods("GET")
+		g.HandleFunc(CVERefreshRoute,
+			AdminHandler(rh.c, rh.RefreshCVEDB)).Methods("POST")
+		g.HandleFunc(fmt.Sprintf(AnnotationsRoutePrefix+"/{name:%s}", NameRegexp.String()),
+			rh.PatchAnnotations).Methods("PATCH")
+		g.HandleFunc(fmt.Sprintf(ExtRoutePrefix+"/ttl/{name:%s}", NameRegexp.String()),
+			rh.GetTagExpiries).Methods("GET")
+		g.HandleFunc(fmt.Sprintf(ExtRoutePrefix+"/ttl/{name:%s}", NameRegexp.String()),
+			rh.UpdateTagTTL).Methods("PUT")
+		g.HandleFunc(fmt.Sprintf(ExtRoutePrefix+"/ttl/{name:%s}", NameRegexp.String()),
+			rh.DeleteTagTTL).Methods("DELETE")
+
+		if rh.c.tenants != nil {
+			g.HandleFunc(AdminRoutePrefix+"/tenants",
+				AdminHandler(rh.c, rh.ListTenants)).Methods("GET")
+			g.HandleFunc(fmt.Sprintf(AdminRoutePrefix+"/tenants/{name:%s}", NameRegexp.String()),
+				AdminHandler(rh.c, rh.UpdateTenant)).Methods("PUT")
+		}
+
+		if rh.c.changefeed != nil {
+			g.HandleFunc(ChangefeedRoute,
+				rh.GetChangefeed).Methods("GET")
+		}
+
+		if rh.c.Config.Storage.Backup != nil {
+			g.HandleFunc(AdminRoutePrefix+"/backup",
+				AdminHandler(rh.c, rh.GetBackups)).Methods("GET")
+			g.HandleFunc(AdminRoutePrefix+"/backup",
+				AdminHandler(rh.c, rh.Backup)).Methods("POST")
+		}
+
+		if rh.c.cveRawReport != nil {
+			g.HandleFunc(fmt.Sprintf(CVERawReportRoutePrefix+"/{name:%s}", NameRegexp.String()),
+				rh.GetCVERawReport).Methods("GET")
+		}
+
+		if rh.c.presigner != nil {
+			g.HandleFunc(fmt.Sprintf(PresignRoutePrefix+"/{name:%s}", NameRegexp.String()),
+				rh.CreatePresignedURL).Methods("POST")
+		}
+
+		if rh.c.sessions != nil {
+			g.HandleFunc(SessionRoute,
+				rh.CreateSession).Methods("POST")
+			g.HandleFunc(SessionRoute,
+				rh.DeleteSession).Methods("DELETE")
+		}
 	}
+	// profiling and debug endpoints "/debug/pprof/", "/debug/storage"
+	if rh.c.Config.HTTP.Debug {
+		d := rh.c.Router.PathPrefix(DebugRoutePrefix).Subrouter()
+		{
+			d.HandleFunc("/pprof/cmdline", AdminHandler(rh.c, pprof.Cmdline)).Methods("GET")
+			d.HandleFunc("/pprof/profile", AdminHandler(rh.c, pprof.Profile)).Methods("GET")
+			d.HandleFunc("/pprof/symbol", AdminHandler(rh.c, pprof.Symbol)).Methods("GET", "POST")
+			d.HandleFunc("/pprof/trace", AdminHandler(rh.c, pprof.Trace)).Methods("GET")
+			d.PathPrefix("/pprof/").HandlerFunc(AdminHandler(rh.c, pprof.Index)).Methods("GET")
+			d.HandleFunc("/storage", AdminHandler(rh.c, rh.GetStorageStats)).Methods("GET")
+		}
+	}
+	// readiness probe, not authenticated
+	rh.c.Router.HandleFunc(ReadinessRoute, rh.GetReadiness).Methods("GET")
 	// swagger docs "/swagger/v2/index.html"
 	rh.c.Router.PathPrefix("/swagger/v2/").Methods("GET").Handler(httpSwagger.WrapHandler)
 	// Setup Extensions Routes
 	if rh.c.Config != nil && rh.c.Config.Extensions != nil {
-		ext.SetupRoutes(rh.c.Config.Extensions, rh.c.Router, rh.c.StoreController, rh.c.Log)
+		rh.c.shutdownExtensions = ext.SetupRoutes(rh.c.Config.Extensions, rh.c.Router, rh.c.StoreController,
+			rh.c.replicator, rh.c.retagger, rh.c.pushScanner, rh.c.Scheduler, rh.c.netPolicy, rh.c.tenants, rh.c.Log)
 	}
 }
 
@@ -148,7 +265,7 @@ func (rh *RouteHandler) ListTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
 
 	paginate := false
 	n := -1
@@ -186,7 +303,10 @@ func (rh *RouteHandler) ListTags(w http.ResponseWriter, r *http.Request) {
 		last = lastQuery[0]
 	}
 
+	span := startStorageSpan(r, "GetImageTags", name)
 	tags, err := is.GetImageTags(name)
+	endSpan(span, err)
+
 	if err != nil {
 		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
 		return
@@ -259,7 +379,7 @@ func (rh *RouteHandler) CheckManifest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
 
 	reference, ok := vars["reference"]
 	if !ok || reference == "" {
@@ -267,17 +387,24 @@ func (rh *RouteHandler) CheckManifest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, digest, mediaType, err := is.GetImageManifest(name, reference)
+	content, digest, mediaType, reference, err := rh.getImageManifest(r, is, name, reference)
 	if err != nil {
-		switch err {
-		case errors.ErrRepoNotFound:
+		if errors.Is(err, errors.ErrAmbiguousDigest) {
+			WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(MANIFEST_UNKNOWN,
+				map[string]string{"reference": reference, "reason": err.Error()})))
+
+			return
+		}
+
+		switch {
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"reference": reference})))
-		case errors.ErrManifestNotFound:
+		case errors.Is(err, errors.ErrManifestNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(MANIFEST_UNKNOWN, map[string]string{"reference": reference})))
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			WriteJSON(w, http.StatusInternalServerError,
 				NewErrorList(NewError(MANIFEST_INVALID, map[string]string{"reference": reference})))
 		}
@@ -285,6 +412,10 @@ func (rh *RouteHandler) CheckManifest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rh.setDeprecationWarning(w, r, is, name, reference)
+
+	_, digest, mediaType = rh.negotiateManifest(w, r, is, name, content, digest, mediaType)
+
 	w.Header().Set(DistContentDigestKey, digest)
 	w.Header().Set("Content-Length", "0")
 	w.Header().Set("Content-Type", mediaType)
@@ -317,7 +448,7 @@ func (rh *RouteHandler) GetManifest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
 
 	reference, ok := vars["reference"]
 	if !ok || reference == "" {
@@ -325,30 +456,118 @@ func (rh *RouteHandler) GetManifest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	content, digest, mediaType, err := is.GetImageManifest(name, reference)
+	content, digest, mediaType, reference, err := rh.getImageManifest(r, is, name, reference)
 	if err != nil {
-		switch err {
-		case errors.ErrRepoNotFound:
+		if errors.Is(err, errors.ErrAmbiguousDigest) {
+			WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(MANIFEST_UNKNOWN,
+				map[string]string{"reference": reference, "reason": err.Error()})))
+
+			return
+		}
+
+		switch {
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-		case errors.ErrRepoBadVersion:
+		case errors.Is(err, errors.ErrRepoBadVersion):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-		case errors.ErrManifestNotFound:
+		case errors.Is(err, errors.ErrManifestNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(MANIFEST_UNKNOWN, map[string]string{"reference": reference})))
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
 		return
 	}
 
+	if !rh.isSignatureVerified(r, is, name, reference, content) {
+		WriteJSON(w, http.StatusForbidden,
+			NewErrorList(NewError(DENIED, map[string]string{"reason": "image signature verification failed"})))
+		return
+	}
+
+	if !rh.isProvenanceVerified(r, is, name, reference, content) {
+		WriteJSON(w, http.StatusForbidden,
+			NewErrorList(NewError(DENIED, map[string]string{"reason": "image provenance verification failed"})))
+		return
+	}
+
+	is.PullStats().Record(name, reference, getUsername(r))
+	rh.setDeprecationWarning(w, r, is, name, reference)
+
+	content, digest, mediaType = rh.negotiateManifest(w, r, is, name, content, digest, mediaType)
+
 	w.Header().Set(DistContentDigestKey, digest)
 	WriteData(w, http.StatusOK, mediaType, content)
 }
 
+// negotiateManifest converts the manifest to the OCI or the Docker format for the clients which don't accept its
+// media type but accept the equivalent one of the other format, the digest of the stored manifest being kept
+// in the OriginalContentDigestKey header.
+func (rh *RouteHandler) negotiateManifest(w http.ResponseWriter, r *http.Request, is *storage.ImageStore, name string,
+	content []byte, digest, mediaType string) ([]byte, string, string) {
+	equivalent, ok := storage.EquivalentMediaType(mediaType)
+	if !ok {
+		return content, digest, mediaType
+	}
+
+	accepted := make(map[string]bool)
+
+	for _, header := range r.Header.Values("Accept") {
+		for _, value := range strings.Split(header, ",") {
+			accepted[strings.TrimSpace(strings.Split(value, ";")[0])] = true
+		}
+	}
+
+	if !accepted[equivalent] || accepted[mediaType] || accepted["*/*"] {
+		return content, digest, mediaType
+	}
+
+	converted, convertedDigest, convertedMediaType, err := is.ConvertManifest(name, content, digest, mediaType,
+		storage.ManifestFormat(equivalent))
+	if err != nil {
+		// the stored manifest is served, for the client to decide what to do with it
+		rh.logger(r).Warn().Err(err).Str("repository", name).Str("digest", digest).
+			Str("mediaType", equivalent).Msg("unable to convert manifest")
+
+		return content, digest, mediaType
+	}
+
+	w.Header().Set(OriginalContentDigestKey, digest)
+
+	return converted, convertedDigest, convertedMediaType
+}
+
+// getImageManifest reads a manifest by tag or digest, or else by short digest, in which case the
+// reference returned is the digest it resolved to.
+func (rh *RouteHandler) getImageManifest(r *http.Request, is *storage.ImageStore, name,
+	reference string) ([]byte, string, string, string, error) {
+	span := startStorageSpan(r, "GetImageManifest", name)
+	content, digest, mediaType, err := is.GetImageManifest(name, reference)
+	endSpan(span, err)
+
+	if !errors.Is(err, errors.ErrManifestNotFound) || !storage.IsDigestPrefix(reference) {
+		return content, digest, mediaType, reference, err
+	}
+
+	// converted manifests are pulled by their digest, and verified as the stored manifest
+	if converted, mediaType, original, err := is.GetConvertedManifest(name, reference); err == nil {
+		return converted, reference, mediaType, original, nil
+	}
+
+	resolved, err := is.ResolveDigest(name, reference)
+	if err != nil {
+		return nil, "", "", reference, err
+	}
+
+	content, digest, mediaType, err = is.GetImageManifest(name, resolved.String())
+
+	return content, digest, mediaType, resolved.String(), err
+}
+
 // UpdateManifest godoc
 // @Summary Update image manifest
 // @Description Update an image's manifest given a reference or a digest
@@ -359,6 +578,7 @@ func (rh *RouteHandler) GetManifest(w http.ResponseWriter, r *http.Request) {
 // @Header  201 {object} api.DistContentDigestKey
 // @Success 201 {string} string	"created"
 // @Failure 400 {string} string "bad request"
+// @Failure 403 {string} string "forbidden"
 // @Failure 404 {string} string "not found"
 // @Failure 500 {string} string "internal server error"
 // @Router /v2/{name}/manifests/{reference} [put].
@@ -371,7 +591,7 @@ func (rh *RouteHandler) UpdateManifest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
 
 	reference, ok := vars["reference"]
 	if !ok || reference == "" {
@@ -380,42 +600,98 @@ func (rh *RouteHandler) UpdateManifest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	mediaType := r.Header.Get("Content-Type")
-	if mediaType != ispec.MediaTypeImageManifest {
+	if !storage.IsManifestMediaType(mediaType) {
 		w.WriteHeader(http.StatusUnsupportedMediaType)
 		return
 	}
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		rh.c.Log.Error().Err(err).Msg("unexpected error")
+		rh.logger(r).Error().Err(err).Msg("unexpected error")
 		w.WriteHeader(http.StatusInternalServerError)
 
 		return
 	}
 
+	if rh.c.mediaTypePolicy != nil {
+		if err := rh.c.mediaTypePolicy.Check(name, mediaType, body); err != nil {
+			rh.logger(r).Warn().Err(err).Str("repository", name).Str("reference", reference).
+				Msg("rejecting manifest of a media type not allowed")
+			WriteJSON(w, http.StatusUnsupportedMediaType,
+				NewErrorList(NewError(UNSUPPORTED, map[string]string{"reference": reference, "reason": err.Error()})))
+
+			return
+		}
+	}
+
+	if err := rh.checkQuota(is, name, reference, body); err != nil {
+		rh.logger(r).Warn().Err(err).Str("repository", name).Str("reference", reference).Msg("rejecting manifest over quota")
+		WriteJSON(w, http.StatusForbidden,
+			NewErrorList(NewError(DENIED, map[string]string{"reference": reference, "reason": err.Error()})))
+
+		return
+	}
+
+	violations, ok := rh.lintPushedManifest(w, r, is, name, reference, body)
+	if !ok {
+		return
+	}
+
+	rollback := rh.pushRollback(is, name, reference)
+
+	span := startStorageSpan(r, "PutImageManifest", name)
 	digest, err := is.PutImageManifest(name, reference, mediaType, body)
+	endSpan(span, err)
+
 	if err != nil {
-		switch err {
-		case errors.ErrRepoNotFound:
+		switch {
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-		case errors.ErrManifestNotFound:
+		case errors.Is(err, errors.ErrManifestNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(MANIFEST_UNKNOWN, map[string]string{"reference": reference})))
-		case errors.ErrBadManifest:
+		case errors.Is(err, errors.ErrBadManifest):
 			WriteJSON(w, http.StatusBadRequest,
 				NewErrorList(NewError(MANIFEST_INVALID, map[string]string{"reference": reference})))
-		case errors.ErrBlobNotFound:
+		case errors.Is(err, errors.ErrBlobNotFound):
 			WriteJSON(w, http.StatusBadRequest,
 				NewErrorList(NewError(BLOB_UNKNOWN, map[string]string{"blob": digest})))
+		case errors.Is(err, errors.ErrIndexManifestNotFound):
+			WriteJSON(w, http.StatusBadRequest,
+				NewErrorList(NewError(MANIFEST_BLOB_UNKNOWN, map[string]string{"digest": digest})))
+		case errors.Is(err, errors.ErrImmutableTag):
+			rh.logger(r).Warn().Str("repository", name).Str("tag", reference).Msg("rejecting update of an immutable tag")
+			WriteJSON(w, http.StatusForbidden,
+				NewErrorList(NewError(DENIED, map[string]string{"reference": reference, "reason": err.Error()})))
+		case errors.Is(err, errors.ErrIndexTooDeep), errors.Is(err, errors.ErrForeignManifest):
+			WriteJSON(w, http.StatusBadRequest,
+				NewErrorList(NewError(MANIFEST_INVALID, map[string]string{"reference": reference, "digest": digest,
+					"reason": err.Error()})))
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
 		return
 	}
 
+	if !rh.scanPushedImage(w, r, name, reference, body, rollback) {
+		return
+	}
+
+	rh.setDefaultTagTTL(r, is, name, reference)
+	rh.verifyPushedProvenance(r, is, name, reference, body)
+	rh.recordLint(r, is, name, godigest.Digest(digest), violations)
+
+	if rh.c.replicator != nil {
+		rh.c.replicator.Notify(name, reference)
+	}
+
+	if rh.c.retagger != nil {
+		rh.c.retagger.Notify(name, reference)
+	}
+
 	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", name, digest))
 	w.Header().Set(DistContentDigestKey, digest)
 	w.WriteHeader(http.StatusCreated)
@@ -429,6 +705,7 @@ func (rh *RouteHandler) UpdateManifest(w http.ResponseWriter, r *http.Request) {
 // @Param   name     			path    string     true        "repository name"
 // @Param   reference     path    string     true        "image reference or digest"
 // @Success 200 {string} string	"ok"
+// @Failure 403 {string} string "forbidden"
 // @Router /v2/{name}/manifests/{reference} [delete].
 func (rh *RouteHandler) DeleteManifest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -439,7 +716,7 @@ func (rh *RouteHandler) DeleteManifest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
 
 	reference, ok := vars["reference"]
 	if !ok || reference == "" {
@@ -448,20 +725,28 @@ func (rh *RouteHandler) DeleteManifest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	span := startStorageSpan(r, "DeleteImageManifest", name)
 	err := is.DeleteImageManifest(name, reference)
+	endSpan(span, err)
+
 	if err != nil {
-		switch err {
-		case errors.ErrRepoNotFound:
+		switch {
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusBadRequest,
 				NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-		case errors.ErrManifestNotFound:
+		case errors.Is(err, errors.ErrManifestNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(MANIFEST_UNKNOWN, map[string]string{"reference": reference})))
-		case errors.ErrBadManifest:
+		case errors.Is(err, errors.ErrBadManifest):
 			WriteJSON(w, http.StatusBadRequest,
 				NewErrorList(NewError(UNSUPPORTED, map[string]string{"reference": reference})))
+		case errors.Is(err, errors.ErrImmutableTag):
+			rh.logger(r).Warn().Str("repository", name).Str("reference", reference).
+				Msg("rejecting delete of an immutable tag")
+			WriteJSON(w, http.StatusForbidden,
+				NewErrorList(NewError(DENIED, map[string]string{"reference": reference, "reason": err.Error()})))
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
@@ -490,7 +775,7 @@ func (rh *RouteHandler) CheckBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
 
 	digest, ok := vars["digest"]
 	if !ok || digest == "" {
@@ -498,17 +783,20 @@ func (rh *RouteHandler) CheckBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	span := startStorageSpan(r, "CheckBlob", name)
 	ok, blen, err := is.CheckBlob(name, digest)
+	endSpan(span, err)
+
 	if err != nil {
-		switch err {
-		case errors.ErrBadBlobDigest:
+		switch {
+		case errors.Is(err, errors.ErrBadBlobDigest):
 			WriteJSON(w, http.StatusBadRequest, NewErrorList(NewError(DIGEST_INVALID, map[string]string{"digest": digest})))
-		case errors.ErrRepoNotFound:
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-		case errors.ErrBlobNotFound:
+		case errors.Is(err, errors.ErrBlobNotFound):
 			WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(BLOB_UNKNOWN, map[string]string{"digest": digest})))
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
@@ -544,7 +832,7 @@ func (rh *RouteHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
 
 	digest, ok := vars["digest"]
 	if !ok || digest == "" {
@@ -554,27 +842,36 @@ func (rh *RouteHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 
 	mediaType := r.Header.Get("Accept")
 
+	span := startStorageSpan(r, "GetBlob", name)
 	br, blen, err := is.GetBlob(name, digest, mediaType)
+	endSpan(span, err)
+
 	if err != nil {
-		switch err {
-		case errors.ErrBadBlobDigest:
+		switch {
+		case errors.Is(err, errors.ErrBadBlobDigest):
 			WriteJSON(w, http.StatusBadRequest, NewErrorList(NewError(DIGEST_INVALID, map[string]string{"digest": digest})))
-		case errors.ErrRepoNotFound:
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-		case errors.ErrBlobNotFound:
+		case errors.Is(err, errors.ErrBlobNotFound):
 			WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(BLOB_UNKNOWN, map[string]string{"digest": digest})))
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
 		return
 	}
 
+	if closer, ok := br.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	defer rh.c.Scheduler.TransferStarted(false, blen)()
+
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", blen))
 	w.Header().Set(DistContentDigestKey, digest)
 	// return the blob data
-	WriteDataFromReader(w, http.StatusOK, blen, mediaType, br, rh.c.Log)
+	WriteDataFromReader(w, http.StatusOK, blen, mediaType, br, *rh.logger(r))
 }
 
 // DeleteBlob godoc
@@ -603,19 +900,22 @@ func (rh *RouteHandler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
 
+	span := startStorageSpan(r, "DeleteBlob", name)
 	err := is.DeleteBlob(name, digest)
+	endSpan(span, err)
+
 	if err != nil {
-		switch err {
-		case errors.ErrBadBlobDigest:
+		switch {
+		case errors.Is(err, errors.ErrBadBlobDigest):
 			WriteJSON(w, http.StatusBadRequest, NewErrorList(NewError(DIGEST_INVALID, map[string]string{"digest": digest})))
-		case errors.ErrRepoNotFound:
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-		case errors.ErrBlobNotFound:
+		case errors.Is(err, errors.ErrBlobNotFound):
 			WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(BLOB_UNKNOWN, map[string]string{"digest": digest})))
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
@@ -625,6 +925,16 @@ func (rh *RouteHandler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// trackUpload records the upload of the body of the request, if it has one, as in flight, the returned func
+// records its end.
+func (rh *RouteHandler) trackUpload(r *http.Request) func() {
+	if r.ContentLength == 0 {
+		return func() {}
+	}
+
+	return rh.c.Scheduler.TransferStarted(true, r.ContentLength)
+}
+
 // CreateBlobUpload godoc
 // @Summary Create image blob/layer upload
 // @Description Create a new image blob/layer upload
@@ -636,6 +946,7 @@ func (rh *RouteHandler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
 // @Header  202 {string} Range "bytes=0-0"
 // @Failure 404 {string} string "not found"
 // @Failure 500 {string} string "internal server error"
+// @Failure 507 {string} string "insufficient storage"
 // @Router /v2/{name}/blobs/uploads [post].
 func (rh *RouteHandler) CreateBlobUpload(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -646,7 +957,8 @@ func (rh *RouteHandler) CreateBlobUpload(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
+	defer rh.trackUpload(r)()
 
 	// currently zot does not support cross-repository mounting, following dist-spec and returning 202
 	if mountDigests, ok := r.URL.Query()["mount"]; ok {
@@ -664,15 +976,23 @@ func (rh *RouteHandler) CreateBlobUpload(w http.ResponseWriter, r *http.Request)
 		// zot does not support cross mounting directly and do a workaround creating using hard link.
 		// check blob looks for actual path (name+mountDigests[0]) first then look for cache and
 		// if found in cache, will do hard link and if fails we will start new upload.
+		span := startStorageSpan(r, "CheckBlob", name)
 		_, _, err := is.CheckBlob(name, mountDigests[0])
+		endSpan(span, err)
+
 		if err != nil {
+			span = startStorageSpan(r, "NewBlobUpload", name)
 			u, err := is.NewBlobUpload(name)
+			endSpan(span, err)
+
 			if err != nil {
-				switch err {
-				case errors.ErrRepoNotFound:
+				switch {
+				case errors.Is(err, errors.ErrRepoNotFound):
 					WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
+				case errors.Is(err, errors.ErrInsufficientStorage):
+					writeInsufficientStorage(w, name)
 				default:
-					rh.c.Log.Error().Err(err).Msg("unexpected error")
+					rh.logger(r).Error().Err(err).Msg("unexpected error")
 					w.WriteHeader(http.StatusInternalServerError)
 				}
 
@@ -708,36 +1028,47 @@ func (rh *RouteHandler) CreateBlobUpload(w http.ResponseWriter, r *http.Request)
 		digest := digests[0]
 
 		if contentType := r.Header.Get("Content-Type"); contentType != BinaryMediaType {
-			rh.c.Log.Warn().Str("actual", contentType).Str("expected", BinaryMediaType).Msg("invalid media type")
+			rh.logger(r).Warn().Str("actual", contentType).Str("expected", BinaryMediaType).Msg("invalid media type")
 			w.WriteHeader(http.StatusUnsupportedMediaType)
 
 			return
 		}
 
-		rh.c.Log.Info().Int64("r.ContentLength", r.ContentLength).Msg("DEBUG")
+		rh.logger(r).Info().Int64("r.ContentLength", r.ContentLength).Msg("DEBUG")
 
 		var contentLength int64
 
 		var err error
 
 		if contentLength, err = strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64); err != nil || contentLength <= 0 {
-			rh.c.Log.Warn().Str("actual", r.Header.Get("Content-Length")).Msg("invalid content length")
+			rh.logger(r).Warn().Str("actual", r.Header.Get("Content-Length")).Msg("invalid content length")
 			WriteJSON(w, http.StatusBadRequest,
 				NewErrorList(NewError(BLOB_UPLOAD_INVALID, map[string]string{"digest": digest})))
 
 			return
 		}
 
+		span := startStorageSpan(r, "FullBlobUpload", name)
 		sessionID, size, err := is.FullBlobUpload(name, r.Body, digest)
-		if err != nil {
-			rh.c.Log.Error().Err(err).Int64("actual", size).Int64("expected", contentLength).Msg("failed full upload")
+		endSpan(span, err)
+
+		switch {
+		case err == nil:
+		case errors.Is(err, errors.ErrInsufficientStorage):
+			writeInsufficientStorage(w, name)
+			return
+		case errors.Is(err, errors.ErrBadBlobDigest):
+			WriteJSON(w, http.StatusBadRequest, NewErrorList(NewError(DIGEST_INVALID, map[string]string{"digest": digest})))
+			return
+		default:
+			rh.logger(r).Error().Err(err).Int64("actual", size).Int64("expected", contentLength).Msg("failed full upload")
 			w.WriteHeader(http.StatusInternalServerError)
 
 			return
 		}
 
 		if size != contentLength {
-			rh.c.Log.Warn().Int64("actual", size).Int64("expected", contentLength).Msg("invalid content length")
+			rh.logger(r).Warn().Int64("actual", size).Int64("expected", contentLength).Msg("invalid content length")
 			w.WriteHeader(http.StatusInternalServerError)
 
 			return
@@ -750,13 +1081,18 @@ func (rh *RouteHandler) CreateBlobUpload(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	span := startStorageSpan(r, "NewBlobUpload", name)
 	u, err := is.NewBlobUpload(name)
+	endSpan(span, err)
+
 	if err != nil {
-		switch err {
-		case errors.ErrRepoNotFound:
+		switch {
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
+		case errors.Is(err, errors.ErrInsufficientStorage):
+			writeInsufficientStorage(w, name)
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
@@ -790,7 +1126,7 @@ func (rh *RouteHandler) GetBlobUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
 
 	sessionID, ok := vars["session_id"]
 	if !ok || sessionID == "" {
@@ -799,23 +1135,26 @@ func (rh *RouteHandler) GetBlobUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	span := startStorageSpan(r, "GetBlobUpload", name)
 	size, err := is.GetBlobUpload(name, sessionID)
+	endSpan(span, err)
+
 	if err != nil {
-		switch err {
-		case errors.ErrBadUploadRange:
+		switch {
+		case errors.Is(err, errors.ErrBadUploadRange):
 			WriteJSON(w, http.StatusBadRequest,
 				NewErrorList(NewError(BLOB_UPLOAD_INVALID, map[string]string{"session_id": sessionID})))
-		case errors.ErrBadBlobDigest:
+		case errors.Is(err, errors.ErrBadBlobDigest):
 			WriteJSON(w, http.StatusBadRequest,
 				NewErrorList(NewError(BLOB_UPLOAD_INVALID, map[string]string{"session_id": sessionID})))
-		case errors.ErrRepoNotFound:
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-		case errors.ErrUploadNotFound:
+		case errors.Is(err, errors.ErrUploadNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(BLOB_UPLOAD_UNKNOWN, map[string]string{"session_id": sessionID})))
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
@@ -852,7 +1191,8 @@ func (rh *RouteHandler) PatchBlobUpload(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
+	defer rh.trackUpload(r)()
 
 	sessionID, ok := vars["session_id"]
 	if !ok || sessionID == "" {
@@ -866,14 +1206,16 @@ func (rh *RouteHandler) PatchBlobUpload(w http.ResponseWriter, r *http.Request)
 
 	if r.Header.Get("Content-Length") == "" || r.Header.Get("Content-Range") == "" {
 		// streamed blob upload
+		span := startStorageSpan(r, "PutBlobChunkStreamed", name)
 		clen, err = is.PutBlobChunkStreamed(name, sessionID, r.Body)
+		endSpan(span, err)
 	} else {
 		// chunked blob upload
 
 		var contentLength int64
 
 		if contentLength, err = strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64); err != nil {
-			rh.c.Log.Warn().Str("actual", r.Header.Get("Content-Length")).Msg("invalid content length")
+			rh.logger(r).Warn().Str("actual", r.Header.Get("Content-Length")).Msg("invalid content length")
 			w.WriteHeader(http.StatusBadRequest)
 
 			return
@@ -881,7 +1223,7 @@ func (rh *RouteHandler) PatchBlobUpload(w http.ResponseWriter, r *http.Request)
 
 		contentRange := r.Header.Get("Content-Range")
 		if contentRange == "" {
-			rh.c.Log.Warn().Str("actual", r.Header.Get("Content-Range")).Msg("invalid content range")
+			rh.logger(r).Warn().Str("actual", r.Header.Get("Content-Range")).Msg("invalid content range")
 			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
 
 			return
@@ -893,22 +1235,24 @@ func (rh *RouteHandler) PatchBlobUpload(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
+		span := startStorageSpan(r, "PutBlobChunk", name)
 		clen, err = is.PutBlobChunk(name, sessionID, from, to, r.Body)
+		endSpan(span, err)
 	}
 
 	if err != nil {
-		switch err {
-		case errors.ErrBadUploadRange:
+		switch {
+		case errors.Is(err, errors.ErrBadUploadRange):
 			WriteJSON(w, http.StatusRequestedRangeNotSatisfiable,
 				NewErrorList(NewError(BLOB_UPLOAD_INVALID, map[string]string{"session_id": sessionID})))
-		case errors.ErrRepoNotFound:
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-		case errors.ErrUploadNotFound:
+		case errors.Is(err, errors.ErrUploadNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(BLOB_UPLOAD_UNKNOWN, map[string]string{"session_id": sessionID})))
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
@@ -937,7 +1281,7 @@ func (rh *RouteHandler) PatchBlobUpload(w http.ResponseWriter, r *http.Request)
 // @Failure 500 {string} string "internal server error"
 // @Router /v2/{name}/blobs/uploads/{session_id} [put].
 func (rh *RouteHandler) UpdateBlobUpload(w http.ResponseWriter, r *http.Request) {
-	rh.c.Log.Info().Interface("headers", r.Header).Msg("HEADERS")
+	rh.logger(r).Info().Interface("headers", r.Header).Msg("HEADERS")
 	vars := mux.Vars(r)
 	name, ok := vars["name"]
 
@@ -946,7 +1290,8 @@ func (rh *RouteHandler) UpdateBlobUpload(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
+	defer rh.trackUpload(r)()
 
 	sessionID, ok := vars["session_id"]
 	if !ok || sessionID == "" {
@@ -962,7 +1307,7 @@ func (rh *RouteHandler) UpdateBlobUpload(w http.ResponseWriter, r *http.Request)
 
 	digest := digests[0]
 
-	rh.c.Log.Info().Int64("r.ContentLength", r.ContentLength).Msg("DEBUG")
+	rh.logger(r).Info().Int64("r.ContentLength", r.ContentLength).Msg("DEBUG")
 
 	contentPresent := true
 
@@ -1001,20 +1346,23 @@ func (rh *RouteHandler) UpdateBlobUpload(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
+		span := startStorageSpan(r, "PutBlobChunk", name)
 		_, err = is.PutBlobChunk(name, sessionID, from, to, r.Body)
+		endSpan(span, err)
+
 		if err != nil {
-			switch err {
-			case errors.ErrBadUploadRange:
+			switch {
+			case errors.Is(err, errors.ErrBadUploadRange):
 				WriteJSON(w, http.StatusBadRequest,
 					NewErrorList(NewError(BLOB_UPLOAD_INVALID, map[string]string{"session_id": sessionID})))
-			case errors.ErrRepoNotFound:
+			case errors.Is(err, errors.ErrRepoNotFound):
 				WriteJSON(w, http.StatusNotFound,
 					NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-			case errors.ErrUploadNotFound:
+			case errors.Is(err, errors.ErrUploadNotFound):
 				WriteJSON(w, http.StatusNotFound,
 					NewErrorList(NewError(BLOB_UPLOAD_UNKNOWN, map[string]string{"session_id": sessionID})))
 			default:
-				rh.c.Log.Error().Err(err).Msg("unexpected error")
+				rh.logger(r).Error().Err(err).Msg("unexpected error")
 				w.WriteHeader(http.StatusInternalServerError)
 			}
 
@@ -1024,22 +1372,27 @@ func (rh *RouteHandler) UpdateBlobUpload(w http.ResponseWriter, r *http.Request)
 
 finish:
 	// blob chunks already transferred, just finish
-	if err := is.FinishBlobUpload(name, sessionID, r.Body, digest); err != nil {
-		switch err {
-		case errors.ErrBadBlobDigest:
+	span := startStorageSpan(r, "FinishBlobUpload", name)
+
+	err = is.FinishBlobUpload(name, sessionID, r.Body, digest)
+	endSpan(span, err)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errors.ErrBadBlobDigest):
 			WriteJSON(w, http.StatusBadRequest,
 				NewErrorList(NewError(DIGEST_INVALID, map[string]string{"digest": digest})))
-		case errors.ErrBadUploadRange:
+		case errors.Is(err, errors.ErrBadUploadRange):
 			WriteJSON(w, http.StatusBadRequest,
 				NewErrorList(NewError(BLOB_UPLOAD_INVALID, map[string]string{"session_id": sessionID})))
-		case errors.ErrRepoNotFound:
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-		case errors.ErrUploadNotFound:
+		case errors.Is(err, errors.ErrUploadNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(BLOB_UPLOAD_UNKNOWN, map[string]string{"session_id": sessionID})))
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
@@ -1072,7 +1425,7 @@ func (rh *RouteHandler) DeleteBlobUpload(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	is := rh.getImageStore(name)
+	is := rh.getImageStore(r, name)
 
 	sessionID, ok := vars["session_id"]
 	if !ok || sessionID == "" {
@@ -1080,16 +1433,20 @@ func (rh *RouteHandler) DeleteBlobUpload(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := is.DeleteBlobUpload(name, sessionID); err != nil {
-		switch err {
-		case errors.ErrRepoNotFound:
+	span := startStorageSpan(r, "DeleteBlobUpload", name)
+	err := is.DeleteBlobUpload(name, sessionID)
+	endSpan(span, err)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errors.ErrRepoNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
-		case errors.ErrUploadNotFound:
+		case errors.Is(err, errors.ErrUploadNotFound):
 			WriteJSON(w, http.StatusNotFound,
 				NewErrorList(NewError(BLOB_UPLOAD_UNKNOWN, map[string]string{"session_id": sessionID})))
 		default:
-			rh.c.Log.Error().Err(err).Msg("unexpected error")
+			rh.logger(r).Error().Err(err).Msg("unexpected error")
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
@@ -1117,7 +1474,10 @@ func (rh *RouteHandler) ListRepositories(w http.ResponseWriter, r *http.Request)
 	subStore := rh.c.StoreController.SubStore
 
 	for _, imgStore := range subStore {
+		span := startStorageSpan(r, "GetRepositories", "")
 		repos, err := imgStore.GetRepositories()
+		endSpan(span, err)
+
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -1128,7 +1488,10 @@ func (rh *RouteHandler) ListRepositories(w http.ResponseWriter, r *http.Request)
 
 	singleStore := rh.c.StoreController.DefaultStore
 	if singleStore != nil {
+		span := startStorageSpan(r, "GetRepositories", "")
 		repos, err := singleStore.GetRepositories()
+		endSpan(span, err)
+
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -1137,13 +1500,431 @@ func (rh *RouteHandler) ListRepositories(w http.ResponseWriter, r *http.Request)
 		combineRepoList = append(combineRepoList, repos...)
 	}
 
+	// the repositories of the tenants are only listed to their members
+	if rh.c.tenants != nil {
+		allowed := make([]string, 0, len(combineRepoList))
+
+		for _, repo := range combineRepoList {
+			if rh.c.isTenantAllowed(r, repo) {
+				allowed = append(allowed, repo)
+			}
+		}
+
+		combineRepoList = allowed
+	}
+
 	is := RepositoryList{Repositories: combineRepoList}
 
 	WriteJSON(w, http.StatusOK, is)
 }
 
+// GetSchedulerStatus godoc
+// @Summary Get background tasks status
+// @Description Get the state of the background tasks scheduler
+// @Accept  json
+// @Produce json
+// @Success 200 {object} 	scheduler.Status
+// @Router /v2/_zot/admin/scheduler [get].
+func (rh *RouteHandler) GetSchedulerStatus(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, rh.c.Scheduler.Status())
+}
+
+// GetSyncStatus godoc
+// @Summary Get replication status
+// @Description Get the replication status of each downstream registry of the sync extension, none if it's disabled
+// @Accept  json
+// @Produce json
+// @Success 200 {object} 	[]sync.Status
+// @Router /v2/_zot/admin/sync [get].
+func (rh *RouteHandler) GetSyncStatus(w http.ResponseWriter, r *http.Request) {
+	if rh.c.replicator == nil {
+		WriteJSON(w, http.StatusOK, []sync.Status{})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, rh.c.replicator.Status())
+}
+
+// ApproveSyncedImage godoc
+// @Summary Approve a quarantined image
+// @Description Release an image quarantined by the CVE policy of sync, it is replicated to its destination
+// @Accept  json
+// @Produce json
+// @Param   name     path    string     true        "repository name"
+// @Param   reference query  string     true        "image reference, tag or digest"
+// @Success 202 {string} string "accepted"
+// @Failure 400 {string} string "bad request"
+// @Failure 404 {string} string "not found"
+// @Router /v2/_zot/admin/sync/approve/{name} [post].
+func (rh *RouteHandler) ApproveSyncedImage(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	reference := r.URL.Query().Get("reference")
+
+	if reference == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if rh.c.replicator == nil || rh.c.replicator.Approve(name, reference) != nil {
+		WriteJSON(w, http.StatusNotFound,
+			NewErrorList(NewError(MANIFEST_UNKNOWN, map[string]string{"reference": reference})))
+
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RefreshCVEDB godoc
+// @Summary Refresh the CVE database
+// @Description Update the CVE database of each image store right away, instead of waiting for the next
+// @Description scheduled update, an update already queued or running isn't queued again
+// @Accept  json
+// @Produce json
+// @Success 202 {object} 	map[string]scheduler.PeriodicStatus
+// @Failure 404 {string} 	string 				"not found"
+// @Failure 503 {string} 	string 				"service unavailable"
+// @Router /v2/_zot/ext/cve/refresh [post].
+func (rh *RouteHandler) RefreshCVEDB(w http.ResponseWriter, r *http.Request) {
+	if len(rh.c.cveUpdates) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	statuses := make(map[string]scheduler.PeriodicStatus)
+
+	for route, task := range rh.c.cveUpdates {
+		if err := task.Trigger(); err != nil && !errors.Is(err, errors.ErrSchedulerTaskPending) {
+			rh.logger(r).Error().Err(err).Str("route", route).Msg("unable to queue CVE database update")
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		statuses[route] = task.Status()
+	}
+
+	WriteJSON(w, http.StatusAccepted, statuses)
+}
+
+// GetCVERawReport godoc
+// @Summary Get the raw CVE report of an image
+// @Description Scan an image and get the report of trivy in its own JSON format, as "trivy -f json" prints it,
+// @Description instead of the CVEs of the search API
+// @Accept  json
+// @Produce json
+// @Param   name      path    string     true        "repository name"
+// @Param   reference query   string     true        "image reference, tag or digest"
+// @Success 200 {string} string "trivy JSON report"
+// @Failure 400 {string} string "bad request"
+// @Failure 404 {string} string "not found"
+// @Failure 415 {string} string "unsupported media type"
+// @Failure 500 {string} string "internal server error"
+// @Router /v2/_zot/ext/cve/raw/{name} [get].
+func (rh *RouteHandler) GetCVERawReport(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	reference := r.URL.Query().Get("reference")
+
+	if reference == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	raw, err := rh.c.cveRawReport(r.Context(), name, reference)
+
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", DefaultMediaType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(raw)
+	case errors.Is(err, errors.ErrRepoNotFound):
+		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": name})))
+	case errors.Is(err, errors.ErrManifestNotFound):
+		WriteJSON(w, http.StatusNotFound,
+			NewErrorList(NewError(MANIFEST_UNKNOWN, map[string]string{"reference": reference})))
+	case errors.Is(err, errors.ErrScanNotSupported):
+		WriteJSON(w, http.StatusUnsupportedMediaType,
+			NewErrorList(NewError(UNSUPPORTED, map[string]string{"reference": reference})))
+	default:
+		rh.logger(r).Error().Err(err).Str("repo", name).Str("reference", reference).Msg("unable to scan image")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// Readiness tells whether the server accepts pushes, image stores low on disk space refuse new uploads.
+type Readiness struct {
+	Ready        bool     `json:"ready"`
+	LowDiskSpace []string `json:"lowDiskSpace,omitempty"`
+}
+
+// GetReadiness godoc
+// @Summary Get readiness
+// @Description Readiness probe, not ready while an image store is low on disk space
+// @Produce json
+// @Success 200 {object} 	api.Readiness
+// @Failure 503 {object} 	api.Readiness
+// @Router /readyz [get].
+func (rh *RouteHandler) GetReadiness(w http.ResponseWriter, r *http.Request) {
+	readiness := Readiness{Ready: true}
+
+	for route, imgStore := range rh.imageStores() {
+		if imgStore.DiskSpace().Low {
+			readiness.Ready = false
+			readiness.LowDiskSpace = append(readiness.LowDiskSpace, route)
+		}
+	}
+
+	if !readiness.Ready {
+		sort.Strings(readiness.LowDiskSpace)
+		WriteJSON(w, http.StatusServiceUnavailable, readiness)
+
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, readiness)
+}
+
+// GetDedupeReport godoc
+// @Summary Get dedupe report
+// @Description Get the logical and physical size of the blobs of each image store, and its most duplicated blobs
+// @Accept  json
+// @Produce json
+// @Param   top     query    int     false  "number of most duplicated blobs to list"
+// @Success 200 {object} 	map[string]storage.DedupeReport
+// @Failure 400 {string} 	string 				"bad request"
+// @Failure 500 {string} 	string 				"internal server error"
+// @Router /v2/_zot/admin/dedupe [get].
+func (rh *RouteHandler) GetDedupeReport(w http.ResponseWriter, r *http.Request) {
+	top := storage.DefaultDedupeReportTop
+
+	if value := r.URL.Query().Get("top"); value != "" {
+		var err error
+
+		if top, err = strconv.Atoi(value); err != nil || top < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	reports, err := rh.dedupeReports(rh.logger(r), top)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, reports)
+}
+
+// dedupeReports returns the dedupe report of each image store, keyed by route.
+func (rh *RouteHandler) dedupeReports(logger *log.Logger, top int) (map[string]storage.DedupeReport, error) {
+	reports := make(map[string]storage.DedupeReport)
+
+	for route, imgStore := range rh.imageStores() {
+		report, err := imgStore.DedupeReport(top)
+		if err != nil {
+			logger.Error().Err(err).Str("rootDir", imgStore.RootDir()).Msg("unable to report dedupe")
+			return nil, err
+		}
+
+		reports[route] = report
+	}
+
+	return reports, nil
+}
+
+// Rededupe godoc
+// @Summary Rededupe blobs
+// @Description Replace the copies of each blob with hard links, in the image stores with dedupe enabled
+// @Accept  json
+// @Produce json
+// @Success 200 {object} 	map[string]storage.RededupeResult
+// @Failure 500 {string} 	string 				"internal server error"
+// @Router /v2/_zot/admin/dedupe [post].
+func (rh *RouteHandler) Rededupe(w http.ResponseWriter, r *http.Request) {
+	results, err := rh.rededupe(rh.logger(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, results)
+}
+
+// rededupe rededupes the image stores with dedupe enabled, their results are keyed by route.
+func (rh *RouteHandler) rededupe(logger *log.Logger) (map[string]storage.RededupeResult, error) {
+	results := make(map[string]storage.RededupeResult)
+
+	for route, imgStore := range rh.imageStores() {
+		if !imgStore.DedupeEnabled() {
+			continue
+		}
+
+		result, err := imgStore.Rededupe()
+		if err != nil {
+			logger.Error().Err(err).Str("rootDir", imgStore.RootDir()).Msg("unable to rededupe")
+			return nil, err
+		}
+
+		results[route] = result
+	}
+
+	return results, nil
+}
+
+// GetGCPlan godoc
+// @Summary Get GC plan
+// @Description Get the expired tags, manifests and blobs tag expiry and GC would remove from each image store,
+// @Description and the space they would reclaim, without removing anything
+// @Accept  json
+// @Produce json
+// @Success 200 {object} 	map[string]storage.GCPlan
+// @Failure 500 {string} 	string 				"internal server error"
+// @Router /v2/_zot/admin/gc/plan [get].
+func (rh *RouteHandler) GetGCPlan(w http.ResponseWriter, r *http.Request) {
+	plans, err := rh.gcPlans(rh.logger(r), time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, plans)
+}
+
+// gcPlans returns the GC plan of each image store, keyed by route.
+func (rh *RouteHandler) gcPlans(logger *log.Logger, now time.Time) (map[string]storage.GCPlan, error) {
+	plans := make(map[string]storage.GCPlan)
+
+	for route, imgStore := range rh.imageStores() {
+		plan, err := imgStore.PlanGC(now)
+		if err != nil {
+			logger.Error().Err(err).Str("rootDir", imgStore.RootDir()).Msg("unable to plan GC")
+			return nil, err
+		}
+
+		plans[route] = plan
+	}
+
+	return plans, nil
+}
+
+// GetTieringReport godoc
+// @Summary Get tiering report
+// @Description Get the blobs moved to the cold tier of each image store with tiering enabled
+// @Accept  json
+// @Produce json
+// @Success 200 {object} 	map[string]storage.TieringReport
+// @Failure 500 {string} 	string 				"internal server error"
+// @Router /v2/_zot/admin/tiering [get].
+func (rh *RouteHandler) GetTieringReport(w http.ResponseWriter, r *http.Request) {
+	reports, err := rh.tieringReports(rh.logger(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, reports)
+}
+
+// tieringReports returns the tiering report of each image store with tiering enabled, keyed by route.
+func (rh *RouteHandler) tieringReports(logger *log.Logger) (map[string]storage.TieringReport, error) {
+	reports := make(map[string]storage.TieringReport)
+
+	for route, imgStore := range rh.imageStores() {
+		if !imgStore.TieringEnabled() {
+			continue
+		}
+
+		report, err := imgStore.TieringReport()
+		if err != nil {
+			logger.Error().Err(err).Str("rootDir", imgStore.RootDir()).Msg("unable to report tiering")
+			return nil, err
+		}
+
+		reports[route] = report
+	}
+
+	return reports, nil
+}
+
+// TierBlobs godoc
+// @Summary Move idle layers to the cold tier
+// @Description Move the layers which weren't pulled recently to the cold tier, in the image stores with tiering enabled
+// @Accept  json
+// @Produce json
+// @Success 200 {object} 	map[string]storage.TieringResult
+// @Failure 500 {string} 	string 				"internal server error"
+// @Router /v2/_zot/admin/tiering [post].
+func (rh *RouteHandler) TierBlobs(w http.ResponseWriter, r *http.Request) {
+	results, err := rh.tierBlobs(rh.logger(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, results)
+}
+
+// tierBlobs runs a tiering pass on the image stores with tiering enabled, their results are keyed by route.
+func (rh *RouteHandler) tierBlobs(logger *log.Logger) (map[string]storage.TieringResult, error) {
+	results := make(map[string]storage.TieringResult)
+
+	for route, imgStore := range rh.imageStores() {
+		if !imgStore.TieringEnabled() {
+			continue
+		}
+
+		result, err := imgStore.TierBlobs()
+		if err != nil {
+			logger.Error().Err(err).Str("rootDir", imgStore.RootDir()).Msg("unable to tier blobs")
+			return nil, err
+		}
+
+		results[route] = result
+	}
+
+	return results, nil
+}
+
+// imageStores returns the image stores keyed by their route, "/" being the default one.
+func (rh *RouteHandler) imageStores() map[string]*storage.ImageStore {
+	stores := make(map[string]*storage.ImageStore)
+
+	if rh.c.StoreController.DefaultStore != nil {
+		stores["/"] = rh.c.StoreController.DefaultStore
+	}
+
+	for route, imgStore := range rh.c.StoreController.SubStore {
+		stores[route] = imgStore
+	}
+
+	return stores
+}
+
+// GetStorageStats godoc
+// @Summary Get storage statistics
+// @Description Get lock contention and dedupe cache statistics of each image store
+// @Accept  json
+// @Produce json
+// @Success 200 {object} 	map[string]storage.StoreStats
+// @Router /debug/storage [get].
+func (rh *RouteHandler) GetStorageStats(w http.ResponseWriter, r *http.Request) {
+	stats := make(map[string]storage.StoreStats)
+
+	for route, imgStore := range rh.imageStores() {
+		stats[route] = imgStore.Stats()
+	}
+
+	WriteJSON(w, http.StatusOK, stats)
+}
+
 // helper routines
 
+// writeInsufficientStorage refuses a blob upload while the image store is low on disk space.
+func writeInsufficientStorage(w http.ResponseWriter, name string) {
+	WriteJSON(w, http.StatusInsufficientStorage, NewErrorList(NewError(BLOB_UPLOAD_INVALID,
+		map[string]string{"name": name, "reason": "insufficient storage"})))
+}
+
 func getContentRange(r *http.Request) (int64 /* from */, int64 /* to */, error) {
 	contentRange := r.Header.Get("Content-Range")
 	tokens := strings.Split(contentRange, "-")
@@ -1168,12 +1949,17 @@ func getContentRange(r *http.Request) (int64 /* from */, int64 /* to */, error)
 func WriteJSON(w http.ResponseWriter, status int, data interface{}) {
 	var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
-	body, err := json.Marshal(data)
-	if err != nil {
-		panic(err)
+	// encode into the buffer of a pooled stream rather than a new slice for every response
+	stream := json.BorrowStream(nil)
+	defer json.ReturnStream(stream)
+
+	stream.WriteVal(data)
+
+	if stream.Error != nil {
+		panic(stream.Error)
 	}
 
-	WriteData(w, status, DefaultMediaType, body)
+	WriteData(w, status, DefaultMediaType, stream.Buffer())
 }
 
 func WriteData(w http.ResponseWriter, status int, mediaType string, data []byte) {
@@ -1182,27 +1968,137 @@ func WriteData(w http.ResponseWriter, status int, mediaType string, data []byte)
 	_, _ = w.Write(data)
 }
 
+// WriteDataFromReader streams the reader into the response without buffering it.
 func WriteDataFromReader(w http.ResponseWriter, status int, length int64, mediaType string,
 	reader io.Reader, logger log.Logger) {
 	w.Header().Set("Content-Type", mediaType)
 	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
 	w.WriteHeader(status)
 
-	const maxSize = 10 * 1024 * 1024
+	if err := copyToResponse(w, reader); err != nil {
+		// other kinds of intermittent errors can occur, e.g, io.ErrShortWrite
+		logger.Error().Err(err).Msg("copying data into http response")
+	}
+}
+
+// isSignatureVerified returns false if the signature policy denies the pull of an unverified manifest,
+// in warn mode verification failures are only logged.
+func (rh *RouteHandler) isSignatureVerified(r *http.Request, is *storage.ImageStore, name, reference string,
+	content []byte) bool {
+	if rh.c.signaturePolicy == nil {
+		return true
+	}
+
+	span := startStorageSpan(r, "VerifyImageSignature", name)
+	err := rh.c.signaturePolicy.VerifyImage(is, name, storage.ManifestDigest(reference, content), content)
+	endSpan(span, err)
 
-	for {
-		_, err := io.CopyN(w, reader, maxSize)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			// other kinds of intermittent errors can occur, e.g, io.ErrShortWrite
-			logger.Error().Err(err).Msg("copying data into http response")
+	if err == nil {
+		return true
+	}
+
+	logger := rh.logger(r).Warn().Err(err).Str("repository", name).Str("reference", reference)
+
+	if !rh.c.signaturePolicy.Enforce() {
+		logger.Msg("image signature verification failed, allowing pull")
+		return true
+	}
+
+	logger.Msg("image signature verification failed, denying pull")
+
+	return false
+}
+
+// isProvenanceVerified returns false if the provenance policy denies the pull of a manifest without
+// a valid provenance attestation, in warn mode verification failures are only logged.
+func (rh *RouteHandler) isProvenanceVerified(r *http.Request, is *storage.ImageStore, name, reference string,
+	content []byte) bool {
+	if rh.c.provenancePolicy == nil {
+		return true
+	}
+
+	digest := storage.ManifestDigest(reference, content)
+
+	span := startStorageSpan(r, "VerifyImageProvenance", name)
+	summary, err := rh.c.provenancePolicy.VerifyImage(is, name, digest, content)
+	endSpan(span, err)
+
+	rh.recordProvenance(r, is, name, digest, summary)
+
+	if err == nil {
+		return true
+	}
+
+	logger := rh.logger(r).Warn().Err(err).Str("repository", name).Str("reference", reference)
+
+	if !rh.c.provenancePolicy.Enforce() {
+		logger.Msg("image provenance verification failed, allowing pull")
+		return true
+	}
+
+	logger.Msg("image provenance verification failed, denying pull")
+
+	return false
+}
+
+// verifyPushedProvenance records the result of the verification of the provenance of a pushed image,
+// or of the image a pushed attestation refers to, so that it's known before the image is pulled.
+func (rh *RouteHandler) verifyPushedProvenance(r *http.Request, is *storage.ImageStore, name, reference string,
+	body []byte) {
+	if rh.c.provenancePolicy == nil {
+		return
+	}
+
+	if digest, ok := attestedSubject(body); ok {
+		subject, _, _, err := is.GetImageManifest(name, digest.String())
+		if err != nil {
+			// the attested image may be pushed later
+			return
+		}
+
+		body, reference = subject, digest.String()
+	}
+
+	digest := storage.ManifestDigest(reference, body)
+	summary, _ := rh.c.provenancePolicy.VerifyImage(is, name, digest, body)
+	rh.recordProvenance(r, is, name, digest, summary)
+}
+
+// recordProvenance records the result of the verification of the provenance of an image if it changed.
+func (rh *RouteHandler) recordProvenance(r *http.Request, is *storage.ImageStore, name string,
+	digest godigest.Digest, summary *storage.ProvenanceSummary) {
+	if summary == nil {
+		return
+	}
+
+	rm, err := is.GetRepoMeta(name)
+	if err != nil {
+		return
+	}
+
+	if recorded := rm.Manifests[digest].Provenance; recorded != nil {
+		unchanged := *recorded
+		unchanged.Checked = summary.Checked
+
+		if unchanged == *summary {
 			return
 		}
 	}
+
+	if err := is.SetProvenanceSummary(name, digest, summary); err != nil {
+		rh.logger(r).Error().Err(err).Str("repository", name).Str("digest", digest.String()).
+			Msg("unable to record provenance verification")
+	}
 }
 
 // will return image storage corresponding to subpath provided in config.
-func (rh *RouteHandler) getImageStore(name string) *storage.ImageStore {
-	return rh.c.StoreController.GetImageStore(name)
+func (rh *RouteHandler) getImageStore(r *http.Request, name string) *storage.ImageStore {
+	return rh.c.StoreController.GetImageStore(name).WithLogger(*rh.logger(r))
+}
+
+// logger returns the controller logger tagged with the request ID.
+func (rh *RouteHandler) logger(r *http.Request) *log.Logger {
+	logger := rh.c.Log.ForRequest(r)
+
+	return &logger
 }
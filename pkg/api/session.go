@@ -0,0 +1,203 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionRoute logs users in with a POST authenticated by their credentials, and out with a DELETE.
+const SessionRoute = ExtRoutePrefix + "/session"
+
+// Cookies and header of sessions.
+const (
+	// SessionCookie is the signed session, only sent over TLS and not readable by scripts.
+	SessionCookie = "zot_session"
+	// CSRFCookie is the CSRF token of the session, readable by the scripts of the UI.
+	CSRFCookie = "zot_csrf"
+	// CSRFHeader must repeat the CSRF token of the session on the requests other than GET and HEAD.
+	CSRFHeader = "X-Zot-CSRF-Token"
+)
+
+const (
+	defaultSessionTTL = 12 * time.Hour
+	sessionSecretSize = 32
+	csrfTokenSize     = 32
+)
+
+// SessionResponse is the user and the CSRF token of a new session, and when it expires.
+type SessionResponse struct {
+	Username  string    `json:"username"`
+	CSRFToken string    `json:"csrfToken"`
+	Expires   time.Time `json:"expires"`
+}
+
+// session is the signed content of session cookies.
+type session struct {
+	Username  string `json:"u"`
+	Expires   int64  `json:"e"`
+	CSRFToken string `json:"c"`
+}
+
+// sessionManager issues and verifies session cookies, signed with HMAC-SHA256 so that no session is stored.
+type sessionManager struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func newSessionManager(config *SessionConfig) (*sessionManager, error) {
+	m := &sessionManager{secret: []byte(config.Secret), ttl: config.TTL}
+
+	if m.ttl == 0 {
+		m.ttl = defaultSessionTTL
+	}
+
+	// sessions are valid until a restart, and only on this instance
+	if len(m.secret) == 0 {
+		m.secret = make([]byte, sessionSecretSize)
+
+		if _, err := rand.Read(m.secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *sessionManager) sign(payload string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(payload))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// issue returns a new session of a user and its cookie value.
+func (m *sessionManager) issue(username string, now time.Time) (session, string, error) {
+	token := make([]byte, csrfTokenSize)
+
+	if _, err := rand.Read(token); err != nil {
+		return session{}, "", err
+	}
+
+	s := session{Username: username, Expires: now.Add(m.ttl).Unix(), CSRFToken: hex.EncodeToString(token)}
+
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return session{}, "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(buf)
+
+	return s, payload + "." + m.sign(payload), nil
+}
+
+// verify returns the session of a cookie value if it's signed and not expired.
+func (m *sessionManager) verify(value string, now time.Time) (session, bool) {
+	var s session
+
+	parts := strings.SplitN(value, ".", 2)
+	// nolint:gomnd
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(m.sign(parts[0]))) {
+		return s, false
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(buf, &s) != nil {
+		return s, false
+	}
+
+	return s, s.Username != "" && now.Unix() <= s.Expires
+}
+
+// authenticate returns the user of the session cookie of a request, false if it has none or an invalid one.
+// CSRF tokens are checked on the requests other than GET and HEAD, search queries included.
+func (m *sessionManager) authenticate(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return "", false
+	}
+
+	s, ok := m.verify(cookie.Value, time.Now())
+	if !ok {
+		return "", false
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead &&
+		!hmac.Equal([]byte(r.Header.Get(CSRFHeader)), []byte(s.CSRFToken)) {
+		return "", false
+	}
+
+	return s.Username, true
+}
+
+func isSessionRequest(r *http.Request) bool {
+	return r.URL.Path == RoutePrefix+SessionRoute
+}
+
+// isLoginRequest tells whether a request creates a session, which only credentials may do so that sessions
+// can't be renewed past their TTL, e.g. by users removed meanwhile.
+func isLoginRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && isSessionRequest(r)
+}
+
+// setSessionCookies sets the cookies of a session, or expires them if value is empty.
+func setSessionCookies(w http.ResponseWriter, value, csrfToken string, expires time.Time) {
+	maxAge := int(time.Until(expires).Seconds())
+	if value == "" {
+		maxAge = -1
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: SessionCookie, Value: value, Path: "/", Expires: expires, MaxAge: maxAge,
+		Secure: true, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	http.SetCookie(w, &http.Cookie{Name: CSRFCookie, Value: csrfToken, Path: "/", Expires: expires, MaxAge: maxAge,
+		Secure: true, SameSite: http.SameSiteStrictMode})
+}
+
+// CreateSession godoc
+// @Summary Log in
+// @Description Issue a session cookie to the user authenticated by the HTTP Basic credentials of the request,
+// @Description the CSRF token of the session must be sent in the X-Zot-CSRF-Token header of the requests
+// @Description other than GET and HEAD authenticated by the cookie
+// @Produce json
+// @Success 200 {object} api.SessionResponse
+// @Failure 401 {string} string "unauthorized"
+// @Router /v2/_zot/ext/session [post].
+func (rh *RouteHandler) CreateSession(w http.ResponseWriter, r *http.Request) {
+	username := getUsername(r)
+	if _, _, ok := r.BasicAuth(); !ok || username == "" {
+		WriteJSON(w, http.StatusUnauthorized, NewErrorList(NewError(UNAUTHORIZED)))
+		return
+	}
+
+	s, value, err := rh.c.sessions.issue(username, time.Now())
+	if err != nil {
+		rh.logger(r).Error().Err(err).Msg("unable to issue session")
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	expires := time.Unix(s.Expires, 0)
+
+	setSessionCookies(w, value, s.CSRFToken, expires)
+
+	rh.logger(r).Info().Time("expires", expires).Msg("issued session")
+
+	WriteJSON(w, http.StatusOK, SessionResponse{Username: username, CSRFToken: s.CSRFToken, Expires: expires})
+}
+
+// DeleteSession godoc
+// @Summary Log out
+// @Description Expire the session cookies of the browser
+// @Success 204 {string} string "no content"
+// @Router /v2/_zot/ext/session [delete].
+func (rh *RouteHandler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	setSessionCookies(w, "", "", time.Unix(0, 0))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,378 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/repomatch"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// NotationSignatureArtifactType identifies notation signatures stored as referrers of an image manifest.
+	NotationSignatureArtifactType = storage.NotationSignatureArtifactType
+	// NotationJWSMediaType is the media type of the JWS envelope holding a notation signature.
+	NotationJWSMediaType = "application/jose+json"
+	// NotationPayloadContentType is the content type of the signed payload.
+	NotationPayloadContentType = "application/vnd.cncf.notary.payload.v1+json"
+
+	SignatureModeWarn    = "warn"
+	SignatureModeEnforce = "enforce"
+
+	notationSigningScheme = "io.cncf.notary.signingScheme"
+	notationSigningTime   = "io.cncf.notary.signingTime"
+	notationExpiry        = "io.cncf.notary.expiry"
+	notationX509Scheme    = "notary.x509"
+)
+
+// SignaturePolicy decides which certificates are trusted to sign the images of a repository.
+type SignaturePolicy struct {
+	enforce  bool
+	global   *x509.CertPool
+	patterns []string
+	repos    map[string]*x509.CertPool
+}
+
+// NewSignaturePolicy loads the trust stores of the signature policy configuration.
+func NewSignaturePolicy(config *SignaturePolicyConfig) (*SignaturePolicy, error) {
+	sp := &SignaturePolicy{repos: make(map[string]*x509.CertPool)}
+
+	switch strings.ToLower(config.Mode) {
+	case "", SignatureModeWarn:
+	case SignatureModeEnforce:
+		sp.enforce = true
+	default:
+		return nil, fmt.Errorf("%w: unknown signature verification mode %q", errors.ErrBadConfig, config.Mode)
+	}
+
+	global, err := newTrustStore(config.TrustStore)
+	if err != nil {
+		return nil, err
+	}
+
+	sp.global = global
+
+	for pattern, rule := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+
+		pool, err := newTrustStore(rule.TrustStore)
+		if err != nil {
+			return nil, err
+		}
+
+		sp.repos[pattern] = pool
+		sp.patterns = append(sp.patterns, pattern)
+	}
+
+	return sp, nil
+}
+
+// newTrustStore loads PEM encoded certificates, it returns nil if no file is given.
+func newTrustStore(files []string) (*x509.CertPool, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+
+	for _, file := range files {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s", errors.ErrBadCACert, file)
+		}
+	}
+
+	return pool, nil
+}
+
+// Enforce returns true if pulls of unverified images are denied, otherwise they are only logged.
+func (sp *SignaturePolicy) Enforce() bool {
+	return sp.enforce
+}
+
+// TrustStore returns the certificates trusted to sign the images of the repository,
+// nil if signatures aren't verified for this repository.
+func (sp *SignaturePolicy) TrustStore(repo string) *x509.CertPool {
+	// the most specific (longest) pattern wins when several match a repository
+	if pattern, ok := repomatch.Longest(sp.patterns, repo); ok {
+		return sp.repos[pattern]
+	}
+
+	return sp.global
+}
+
+// VerifyImage checks that the manifest, of the given digest, has at least one notation signature from a
+// trusted signer.
+func (sp *SignaturePolicy) VerifyImage(is *storage.ImageStore, repo string, digest godigest.Digest,
+	manifest []byte) error {
+	roots := sp.TrustStore(repo)
+	if roots == nil {
+		return nil
+	}
+
+	var m ispec.Manifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return err
+	}
+
+	// signatures themselves are not signed, nor are attestations, which are signed in their envelope
+	if m.Config.MediaType == NotationSignatureArtifactType || isAttestation(manifest) {
+		return nil
+	}
+
+	target := ispec.Descriptor{Digest: digest, Size: int64(len(manifest))}
+
+	signatures, err := is.GetReferrers(repo, target.Digest, NotationSignatureArtifactType)
+	if err != nil {
+		return err
+	}
+
+	verr := errors.ErrSignatureNotFound
+
+	for _, desc := range signatures {
+		envelope, err := getSignatureEnvelope(is, repo, desc)
+		if err != nil {
+			verr = err
+			continue
+		}
+
+		if err := VerifyNotationSignature(envelope, target, roots, time.Now()); err != nil {
+			verr = err
+			continue
+		}
+
+		return nil
+	}
+
+	return verr
+}
+
+// getSignatureEnvelope returns the JWS envelope of a signature manifest.
+func getSignatureEnvelope(is *storage.ImageStore, repo string, desc ispec.Descriptor) ([]byte, error) {
+	buf, _, _, err := is.GetImageManifest(repo, desc.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var m ispec.Manifest
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return nil, err
+	}
+
+	for _, layer := range m.Layers {
+		if layer.MediaType != NotationJWSMediaType {
+			continue
+		}
+
+		reader, _, err := is.GetBlob(repo, layer.Digest.String(), layer.MediaType)
+		if err != nil {
+			return nil, err
+		}
+
+		if closer, ok := reader.(io.Closer); ok {
+			defer closer.Close()
+		}
+
+		return ioutil.ReadAll(reader)
+	}
+
+	return nil, fmt.Errorf("%w: no JWS envelope in signature %s", errors.ErrBadSignature, desc.Digest)
+}
+
+// jwsEnvelope is a JWS JSON serialization of a notation signature.
+type jwsEnvelope struct {
+	Payload   string `json:"payload"`
+	Protected string `json:"protected"`
+	Header    struct {
+		CertChain [][]byte `json:"x5c"`
+	} `json:"header"`
+	Signature string `json:"signature"`
+}
+
+type jwsProtectedHeader struct {
+	Algorithm     string     `json:"alg"`
+	ContentType   string     `json:"cty"`
+	Critical      []string   `json:"crit"`
+	SigningScheme string     `json:"io.cncf.notary.signingScheme"`
+	Expiry        *time.Time `json:"io.cncf.notary.expiry"`
+}
+
+type notationPayload struct {
+	TargetArtifact ispec.Descriptor `json:"targetArtifact"`
+}
+
+// VerifyNotationSignature checks that the JWS envelope signs the target manifest, with a certificate
+// chaining up to one of the trusted roots.
+func VerifyNotationSignature(envelope []byte, target ispec.Descriptor, roots *x509.CertPool, now time.Time) error {
+	var env jwsEnvelope
+	if err := json.Unmarshal(envelope, &env); err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrBadSignature, err)
+	}
+
+	protected, err := base64.RawURLEncoding.DecodeString(env.Protected)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrBadSignature, err)
+	}
+
+	var header jwsProtectedHeader
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrBadSignature, err)
+	}
+
+	if err := checkProtectedHeader(header, now); err != nil {
+		return err
+	}
+
+	if len(env.Header.CertChain) == 0 {
+		return fmt.Errorf("%w: missing certificate chain", errors.ErrBadSignature)
+	}
+
+	certs := make([]*x509.Certificate, len(env.Header.CertChain))
+
+	for i, der := range env.Header.CertChain {
+		if certs[i], err = x509.ParseCertificate(der); err != nil {
+			return fmt.Errorf("%w: %v", errors.ErrBadSignature, err)
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		CurrentTime:   now,
+	}); err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrUntrustedSignature, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(env.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrBadSignature, err)
+	}
+
+	if err := verifyJWS(header.Algorithm, certs[0].PublicKey, env.Protected+"."+env.Payload, signature); err != nil {
+		return err
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrBadSignature, err)
+	}
+
+	var payload notationPayload
+	if err := json.Unmarshal(buf, &payload); err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrBadSignature, err)
+	}
+
+	if payload.TargetArtifact.Digest != target.Digest || payload.TargetArtifact.Size != target.Size {
+		return fmt.Errorf("%w: signed artifact %s doesn't match %s", errors.ErrBadSignature,
+			payload.TargetArtifact.Digest, target.Digest)
+	}
+
+	return nil
+}
+
+func checkProtectedHeader(header jwsProtectedHeader, now time.Time) error {
+	if header.ContentType != NotationPayloadContentType {
+		return fmt.Errorf("%w: unsupported payload content type %q", errors.ErrBadSignature, header.ContentType)
+	}
+
+	if header.SigningScheme != "" && header.SigningScheme != notationX509Scheme {
+		return fmt.Errorf("%w: unsupported signing scheme %q", errors.ErrBadSignature, header.SigningScheme)
+	}
+
+	// critical headers we don't understand can't be ignored
+	for _, crit := range header.Critical {
+		switch crit {
+		case notationSigningScheme, notationSigningTime, notationExpiry:
+		default:
+			return fmt.Errorf("%w: unsupported critical header %q", errors.ErrBadSignature, crit)
+		}
+	}
+
+	if header.Expiry != nil && now.After(*header.Expiry) {
+		return fmt.Errorf("%w: signature expired on %s", errors.ErrBadSignature, header.Expiry)
+	}
+
+	return nil
+}
+
+// verifyJWS checks a JWS signature made with one of the algorithms allowed by notation.
+func verifyJWS(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	var hash crypto.Hash
+
+	switch alg {
+	case "PS256", "ES256":
+		hash = crypto.SHA256
+	case "PS384", "ES384":
+		hash = crypto.SHA384
+	case "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", errors.ErrBadSignature, alg)
+	}
+
+	hasher := hash.New()
+	_, _ = hasher.Write([]byte(signingInput))
+	digest := hasher.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "PS") {
+			break
+		}
+
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+		if err := rsa.VerifyPSS(pub, hash, digest, signature, opts); err != nil {
+			return fmt.Errorf("%w: %v", errors.ErrBadSignature, err)
+		}
+
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			break
+		}
+
+		// JWS encodes ECDSA signatures as the fixed size concatenation of r and s
+		size := (pub.Curve.Params().BitSize + 7) / 8 // nolint: gomnd
+		if len(signature) != 2*size {
+			return fmt.Errorf("%w: invalid ECDSA signature length", errors.ErrBadSignature)
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("%w: ECDSA verification failed", errors.ErrBadSignature)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: algorithm %q doesn't match the signing key", errors.ErrBadSignature, alg)
+}
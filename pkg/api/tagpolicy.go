@@ -0,0 +1,97 @@
+package api
+
+import (
+	"path"
+	"regexp"
+
+	"github.com/anuvu/zot/pkg/repomatch"
+)
+
+type tagRule struct {
+	immutable []*regexp.Regexp
+	exclude   []*regexp.Regexp
+}
+
+func newTagRule(rule TagPolicyRule) (*tagRule, error) {
+	r := &tagRule{}
+
+	for _, expr := range rule.Immutable {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		r.immutable = append(r.immutable, re)
+	}
+
+	for _, expr := range rule.Exclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		r.exclude = append(r.exclude, re)
+	}
+
+	return r, nil
+}
+
+func (r *tagRule) isImmutable(tag string) bool {
+	for _, re := range r.exclude {
+		if re.MatchString(tag) {
+			return false
+		}
+	}
+
+	for _, re := range r.immutable {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TagPolicy decides whether a tag can be overwritten once it has been pushed.
+type TagPolicy struct {
+	config   *TagPolicyConfig
+	global   *tagRule
+	patterns []string
+	repos    map[string]*tagRule
+}
+
+// NewTagPolicy compiles the tag policy configuration.
+func NewTagPolicy(config *TagPolicyConfig) (*TagPolicy, error) {
+	global, err := newTagRule(config.TagPolicyRule)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := &TagPolicy{config: config, global: global, repos: make(map[string]*tagRule)}
+
+	for pattern, rule := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+
+		r, err := newTagRule(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		tp.repos[pattern] = r
+		tp.patterns = append(tp.patterns, pattern)
+	}
+
+	return tp, nil
+}
+
+// IsImmutable returns true if the given tag of the repository can't be overwritten.
+func (tp *TagPolicy) IsImmutable(repo string, tag string) bool {
+	// the most specific (longest) pattern wins when several match a repository
+	if pattern, ok := repomatch.Longest(tp.patterns, repo); ok {
+		return tp.repos[pattern].isImmutable(tag)
+	}
+
+	return tp.global.isImmutable(tag)
+}
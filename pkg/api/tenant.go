@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/tenant"
+	"github.com/gorilla/mux"
+)
+
+// isTenantAllowed tells whether the user of a request may access a repository, only the members of its
+// tenant and the admin users may, anyone if it belongs to no tenant.
+func (c *Controller) isTenantAllowed(r *http.Request, repo string) bool {
+	t, ok := c.tenants.Get(repo)
+
+	return !ok || c.isMemberOrAdmin(r, t.IsMember)
+}
+
+// TenantHandler lets only the members of a tenant access its repositories, pre-signed requests having been
+// minted by one of them. The handlers listing repositories, e.g. search, filter them with the access filter
+// it adds to the context of the requests.
+func TenantHandler(c *Controller) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authenticated := r
+			r = r.WithContext(tenant.WithAccessFilter(r.Context(), func(repo string) bool {
+				return c.isTenantAllowed(authenticated, repo)
+			}))
+
+			name, ok := mux.Vars(r)["name"]
+			if !ok || isAdminRequest(r) || isPresigned(r) || c.isTenantAllowed(r, name) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			c.Log.Warn().Str("username", getUsername(r)).Str("repository", name).Msg("tenant access denied")
+			WriteJSON(w, http.StatusForbidden, NewErrorList(NewError(DENIED, map[string]string{"name": name})))
+		})
+	}
+}
+
+// ListTenants godoc
+// @Summary List tenants
+// @Description List the tenants, their members, quotas and storage usage
+// @Accept  json
+// @Produce json
+// @Success 200 {array} tenant.Status
+// @Router /v2/_zot/admin/tenants [get].
+func (rh *RouteHandler) ListTenants(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, rh.c.tenants.All())
+}
+
+// UpdateTenant godoc
+// @Summary Update a tenant
+// @Description Update the members and the storage quota of a configured tenant
+// @Accept  json
+// @Produce json
+// @Param   name       path    string        true    "tenant name"
+// @Param   tenant     body    tenant.Tenant true    "members and quota in bytes"
+// @Success 200 {object} tenant.Tenant
+// @Failure 400 {string} string "bad request"
+// @Failure 404 {string} string "not found"
+// @Failure 500 {string} string "internal server error"
+// @Router /v2/_zot/admin/tenants/{name} [put].
+func (rh *RouteHandler) UpdateTenant(w http.ResponseWriter, r *http.Request) {
+	var t tenant.Tenant
+
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	t.Name = mux.Vars(r)["name"]
+
+	if t.Members == nil {
+		t.Members = []string{}
+	}
+
+	err := rh.c.tenants.Update(t)
+
+	switch {
+	case err == nil:
+		rh.logger(r).Info().Str("tenant", t.Name).Strs("members", t.Members).Int64("quota", t.Quota).
+			Msg("updated tenant")
+		WriteJSON(w, http.StatusOK, t)
+	case errors.Is(err, errors.ErrBadConfig):
+		w.WriteHeader(http.StatusBadRequest)
+	case errors.Is(err, errors.ErrTenantNotFound):
+		WriteJSON(w, http.StatusNotFound, NewErrorList(NewError(NAME_UNKNOWN, map[string]string{"name": t.Name})))
+	default:
+		rh.logger(r).Error().Err(err).Msg("unable to save tenant")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
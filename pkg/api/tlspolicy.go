@@ -0,0 +1,112 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/anuvu/zot/errors"
+)
+
+// TLSPolicyConfig restricts the TLS versions and cipher suites negotiated by the server or the CLI client.
+// Versions are given as "1.0" to "1.3", cipher suites by their IANA name, e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. TLS 1.3 cipher suites aren't configurable.
+type TLSPolicyConfig struct {
+	MinVersion   string
+	MaxVersion   string
+	CipherSuites []string
+}
+
+// nolint: gochecknoglobals
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToUpper(version), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown TLS version %q", errors.ErrBadConfig, version)
+	}
+
+	return v, nil
+}
+
+// parseCipherSuite returns the ID of a secure cipher suite usable with TLS 1.2 or older.
+func parseCipherSuite(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name != name {
+			continue
+		}
+
+		for _, version := range suite.SupportedVersions {
+			if version < tls.VersionTLS13 {
+				return suite.ID, nil
+			}
+		}
+
+		return 0, fmt.Errorf("%w: TLS 1.3 cipher suite %q isn't configurable", errors.ErrBadConfig, name)
+	}
+
+	return 0, fmt.Errorf("%w: unknown or insecure cipher suite %q", errors.ErrBadConfig, name)
+}
+
+// hasHTTP2CipherSuite returns true if one of the cipher suites required by HTTP/2 is allowed.
+func hasHTTP2CipherSuite(suites []uint16) bool {
+	for _, suite := range suites {
+		if suite == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || suite == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ApplyTLSPolicy restricts the TLS config to the versions and cipher suites of the policy.
+func ApplyTLSPolicy(config *tls.Config, policy *TLSPolicyConfig) error {
+	if policy == nil {
+		return nil
+	}
+
+	if policy.MinVersion != "" {
+		v, err := parseTLSVersion(policy.MinVersion)
+		if err != nil {
+			return err
+		}
+
+		config.MinVersion = v
+	}
+
+	if policy.MaxVersion != "" {
+		v, err := parseTLSVersion(policy.MaxVersion)
+		if err != nil {
+			return err
+		}
+
+		config.MaxVersion = v
+	}
+
+	if config.MaxVersion != 0 && config.MinVersion > config.MaxVersion {
+		return fmt.Errorf("%w: TLS min version %q is above max version %q", errors.ErrBadConfig,
+			policy.MinVersion, policy.MaxVersion)
+	}
+
+	if len(policy.CipherSuites) > 0 {
+		suites := make([]uint16, 0, len(policy.CipherSuites))
+
+		for _, name := range policy.CipherSuites {
+			id, err := parseCipherSuite(name)
+			if err != nil {
+				return err
+			}
+
+			suites = append(suites, id)
+		}
+
+		config.CipherSuites = suites
+	}
+
+	return nil
+}
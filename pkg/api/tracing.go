@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/anuvu/zot/pkg/api"
+)
+
+type tracingStatusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *tracingStatusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// ReadFrom lets blobs be sent with sendfile through the wrapped response.
+func (w *tracingStatusWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// Tracing starts a span for every API request, continuing the caller's trace if
+// W3C trace context headers are present. Spans are only exported if tracing is
+// enabled in the extensions config.
+func Tracing() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+			// name spans after the route template to keep their cardinality low
+			name := r.URL.Path
+			if route := mux.CurrentRoute(r); route != nil {
+				if tmpl, err := route.GetPathTemplate(); err == nil {
+					name = tmpl
+				}
+			}
+
+			ctx, span := otel.Tracer(tracerName).Start(ctx, fmt.Sprintf("%s %s", r.Method, name),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.method", r.Method),
+					attribute.String("http.target", r.URL.Path),
+					attribute.String("http.user_agent", r.UserAgent()),
+					attribute.String("http.request_id", log.GetRequestID(r.Context())),
+				))
+			defer span.End()
+
+			sw := &tracingStatusWriter{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			span.SetAttributes(attribute.Int("http.status_code", sw.status))
+
+			if sw.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(sw.status))
+			}
+		})
+	}
+}
+
+// startStorageSpan starts a child span of the request span for a storage operation.
+func startStorageSpan(r *http.Request, operation string, repo string) trace.Span {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "storage."+operation,
+		trace.WithAttributes(attribute.String("repository", repo)))
+
+	return span
+}
+
+// endSpan records the outcome of an operation and ends its span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
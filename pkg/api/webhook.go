@@ -0,0 +1,235 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultWebhookTimeout  = 5 * time.Second
+	maxWebhookCacheEntries = 10000
+	maxWebhookResponseSize = 1 << 16
+)
+
+// WebhookRequest describes the request being authorized, it is posted as JSON to the webhook.
+type WebhookRequest struct {
+	Method        string `json:"method"`
+	Path          string `json:"path"`
+	Repository    string `json:"repository,omitempty"`
+	Action        string `json:"action"`
+	Authorization string `json:"authorization,omitempty"`
+	RemoteAddr    string `json:"remoteAddr"`
+}
+
+// WebhookResponse is the decision of the webhook, Username identifies the user of allowed requests.
+type WebhookResponse struct {
+	Allowed  bool   `json:"allowed"`
+	Username string `json:"username,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type webhookDecision struct {
+	WebhookResponse
+	expires time.Time
+}
+
+// webhookAuthorizer delegates authN/authZ decisions to an external HTTP endpoint,
+// decisions are cached for CacheTTL, keyed on the credentials and the requested action.
+type webhookAuthorizer struct {
+	config *WebhookConfig
+	client *http.Client
+	lock   sync.Mutex
+	cache  map[string]webhookDecision
+}
+
+func newWebhookAuthorizer(config *WebhookConfig) *webhookAuthorizer {
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	return &webhookAuthorizer{
+		config: config,
+		client: &http.Client{Timeout: timeout},
+		cache:  make(map[string]webhookDecision),
+	}
+}
+
+// requestAction maps a request to the action checked by the webhook and the network policy.
+func requestAction(r *http.Request) string {
+	switch {
+	case isAdminRequest(r):
+		return "admin"
+	case isReadRequest(r):
+		return "pull"
+	case r.Method == http.MethodDelete:
+		return "delete"
+	default:
+		return "push"
+	}
+}
+
+func (wa *webhookAuthorizer) cacheKey(req *WebhookRequest) string {
+	sum := sha256.Sum256([]byte(req.Authorization + "\x00" + req.Action + "\x00" + req.Repository))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (wa *webhookAuthorizer) cached(key string) (WebhookResponse, bool) {
+	wa.lock.Lock()
+	defer wa.lock.Unlock()
+
+	decision, ok := wa.cache[key]
+	if !ok || time.Now().After(decision.expires) {
+		return WebhookResponse{}, false
+	}
+
+	return decision.WebhookResponse, true
+}
+
+func (wa *webhookAuthorizer) store(key string, resp WebhookResponse) {
+	if wa.config.CacheTTL <= 0 {
+		return
+	}
+
+	wa.lock.Lock()
+	defer wa.lock.Unlock()
+
+	now := time.Now()
+
+	if len(wa.cache) >= maxWebhookCacheEntries {
+		for k, decision := range wa.cache {
+			if now.After(decision.expires) {
+				delete(wa.cache, k)
+			}
+		}
+
+		// still full, start over rather than growing without bounds
+		if len(wa.cache) >= maxWebhookCacheEntries {
+			wa.cache = make(map[string]webhookDecision)
+		}
+	}
+
+	wa.cache[key] = webhookDecision{WebhookResponse: resp, expires: now.Add(wa.config.CacheTTL)}
+}
+
+// authorize returns the decision of the webhook for the request, from the cache if possible.
+func (wa *webhookAuthorizer) authorize(r *http.Request) (WebhookResponse, error) {
+	req := &WebhookRequest{
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		Repository:    mux.Vars(r)["name"],
+		Action:        requestAction(r),
+		Authorization: r.Header.Get("Authorization"),
+		RemoteAddr:    r.RemoteAddr,
+	}
+
+	key := wa.cacheKey(req)
+	if resp, ok := wa.cached(key); ok {
+		return resp, nil
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+
+	hreq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, wa.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+
+	hreq.Header.Set("Content-Type", "application/json")
+
+	hresp, err := wa.client.Do(hreq)
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+	defer hresp.Body.Close()
+
+	if hresp.StatusCode != http.StatusOK {
+		return WebhookResponse{}, fmt.Errorf("%w: status %d", errors.ErrWebhookFailed, hresp.StatusCode)
+	}
+
+	var resp WebhookResponse
+
+	if err := json.NewDecoder(io.LimitReader(hresp.Body, maxWebhookResponseSize)).Decode(&resp); err != nil {
+		return WebhookResponse{}, fmt.Errorf("%w: %v", errors.ErrWebhookFailed, err)
+	}
+
+	wa.store(key, resp)
+
+	return resp, nil
+}
+
+func webhookAuthHandler(c *Controller) mux.MiddlewareFunc {
+	authorizer := newWebhookAuthorizer(c.Config.HTTP.Auth.Webhook)
+
+	realm := c.Config.HTTP.Realm
+	if realm == "" {
+		realm = "Authorization Required"
+	}
+
+	realm = "Basic realm=" + strconv.Quote(realm)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isReadRequest(r) && c.Config.HTTP.ReadOnly {
+				// Reject modification requests in read-only mode
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			resp, err := authorizer.authorize(r)
+			if err != nil {
+				// admin endpoints are never opened up by a webhook outage
+				if !c.Config.HTTP.Auth.Webhook.FailOpen || isAdminRequest(r) {
+					c.Log.Error().Err(err).Str("path", r.URL.Path).Msg("auth webhook failed, denying request")
+					WriteJSON(w, http.StatusServiceUnavailable, NewErrorList(NewError(DENIED)))
+
+					return
+				}
+
+				c.Log.Warn().Err(err).Str("path", r.URL.Path).Msg("auth webhook failed, allowing request")
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			if !resp.Allowed {
+				c.Log.Info().Str("path", r.URL.Path).Str("reason", resp.Reason).Msg("auth webhook denied request")
+
+				if r.Header.Get("Authorization") == "" {
+					authFail(w, realm, c.Config.HTTP.Auth.FailDelay)
+					return
+				}
+
+				WriteJSON(w, http.StatusForbidden, NewErrorList(NewError(DENIED)))
+
+				return
+			}
+
+			if resp.Username != "" {
+				r = withUsername(r, resp.Username)
+			}
+
+			// the webhook allowed the admin action
+			if isAdminRequest(r) {
+				r = withAdminGranted(r)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
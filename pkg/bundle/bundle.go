@@ -0,0 +1,318 @@
+// Package bundle packs images in a single signed tarball, which can be carried across an air gap
+// and applied to another zot instance.
+//
+// A bundle is a tar archive holding, in this order, the index of its images and blobs, the signature
+// of the index, and the blobs, manifests included. Blobs are stored once even if several images
+// reference them, and they are checked against their digest when read, so signing the index is
+// enough to sign the whole bundle.
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+const (
+	// Version is the version of the bundle format.
+	Version = 1
+
+	indexFile     = "index.json"
+	signatureFile = "signature.json"
+	blobsDir      = "blobs"
+	fileMode      = 0644
+)
+
+// Image is an image of a bundle, Blobs holds the digests of its config and layers.
+type Image struct {
+	Repo      string            `json:"repo"`
+	Tag       string            `json:"tag"`
+	Digest    godigest.Digest   `json:"digest"`
+	MediaType string            `json:"mediaType"`
+	Blobs     []godigest.Digest `json:"blobs"`
+}
+
+// Blob is a blob of a bundle, either a manifest, a config or a layer.
+type Blob struct {
+	Digest godigest.Digest `json:"digest"`
+	Size   int64           `json:"size"`
+}
+
+// Index lists the images and blobs of a bundle, blobs are in the order they are stored in.
+type Index struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Images  []Image   `json:"images"`
+	Blobs   []Blob    `json:"blobs"`
+}
+
+// AddImage adds an image and the blobs it references, blobs already in the bundle aren't added again.
+func (index *Index) AddImage(image Image, blobs ...Blob) {
+	index.Images = append(index.Images, image)
+
+	for _, blob := range blobs {
+		if !index.hasBlob(blob.Digest) {
+			index.Blobs = append(index.Blobs, blob)
+		}
+	}
+}
+
+func (index *Index) hasBlob(digest godigest.Digest) bool {
+	for _, blob := range index.Blobs {
+		if blob.Digest == digest {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validate checks that the blobs referenced by the images are in the bundle.
+func (index *Index) validate() error {
+	if index.Version != Version {
+		return fmt.Errorf("%w: unsupported version %d", errors.ErrBadBundle, index.Version)
+	}
+
+	for _, blob := range index.Blobs {
+		if err := blob.Digest.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", errors.ErrBadBundle, err)
+		}
+	}
+
+	for _, image := range index.Images {
+		for _, digest := range append([]godigest.Digest{image.Digest}, image.Blobs...) {
+			if !index.hasBlob(digest) {
+				return fmt.Errorf("%w: blob %s of %s:%s is missing", errors.ErrBadBundle, digest, image.Repo,
+					image.Tag)
+			}
+		}
+	}
+
+	return nil
+}
+
+func blobPath(digest godigest.Digest) string {
+	return path.Join(blobsDir, digest.Algorithm().String(), digest.Encoded())
+}
+
+// Create writes a bundle of the images of the index, signed by the signer. The blobs are read with
+// fetch, in the order of the index, and checked against their digest.
+func Create(w io.Writer, index *Index, signer *Signer, fetch func(Blob) (io.ReadCloser, error)) error {
+	index.Version = Version
+	if index.Created.IsZero() {
+		index.Created = time.Now().UTC()
+	}
+
+	if err := index.validate(); err != nil {
+		return err
+	}
+
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	sig, err := signer.sign(indexJSON)
+	if err != nil {
+		return err
+	}
+
+	sigJSON, err := json.Marshal(sig)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+
+	if err := writeFile(tw, indexFile, indexJSON); err != nil {
+		return err
+	}
+
+	if err := writeFile(tw, signatureFile, sigJSON); err != nil {
+		return err
+	}
+
+	for _, blob := range index.Blobs {
+		if err := writeBlob(tw, blob, fetch); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func writeFile(tw *tar.Writer, name string, content []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: fileMode, Size: int64(len(content)),
+		Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(content)
+
+	return err
+}
+
+func writeBlob(tw *tar.Writer, blob Blob, fetch func(Blob) (io.ReadCloser, error)) error {
+	reader, err := fetch(blob)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := tw.WriteHeader(&tar.Header{Name: blobPath(blob.Digest), Mode: fileMode, Size: blob.Size,
+		Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+
+	verifier := newVerifyingReader(io.LimitReader(reader, blob.Size), blob)
+
+	if _, err := io.Copy(tw, verifier); err != nil {
+		return err
+	}
+
+	return verifier.verify()
+}
+
+// Reader reads a bundle whose signature was verified, Next returns its blobs one after the other.
+type Reader struct {
+	Index Index
+	// Signer is the certificate which signed the bundle
+	Signer *x509.Certificate
+
+	tr      *tar.Reader
+	next    int
+	current *verifyingReader
+}
+
+// NewReader reads the index of the bundle, and checks that it is signed by a certificate chaining up
+// to one of the trusted roots.
+func NewReader(r io.Reader, roots *x509.CertPool) (*Reader, error) {
+	tr := tar.NewReader(r)
+
+	indexJSON, err := readFile(tr, indexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sigJSON, err := readFile(tr, signatureFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig signature
+	if err := json.Unmarshal(sigJSON, &sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrBadSignature, err)
+	}
+
+	signer, err := sig.verify(indexJSON, roots, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	br := &Reader{tr: tr, Signer: signer}
+
+	if err := json.Unmarshal(indexJSON, &br.Index); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrBadBundle, err)
+	}
+
+	if err := br.Index.validate(); err != nil {
+		return nil, err
+	}
+
+	return br, nil
+}
+
+func readFile(tr *tar.Reader, name string) ([]byte, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrBadBundle, err)
+	}
+
+	if header.Name != name {
+		return nil, fmt.Errorf("%w: expected %s, found %s", errors.ErrBadBundle, name, header.Name)
+	}
+
+	return ioutil.ReadAll(tr)
+}
+
+// Next returns the next blob of the bundle, in the order of the index, and io.EOF after the last one.
+// Reading the blob to its end fails if it doesn't match its digest, and so does the following call to
+// Next if the blob wasn't read to its end.
+func (br *Reader) Next() (Blob, io.Reader, error) {
+	if br.current != nil {
+		if _, err := io.Copy(ioutil.Discard, br.current); err != nil {
+			return Blob{}, nil, err
+		}
+
+		br.current = nil
+	}
+
+	if br.next == len(br.Index.Blobs) {
+		return Blob{}, nil, io.EOF
+	}
+
+	blob := br.Index.Blobs[br.next]
+
+	header, err := br.tr.Next()
+	if err == io.EOF {
+		return Blob{}, nil, fmt.Errorf("%w: blob %s is missing", errors.ErrBadBundle, blob.Digest)
+	}
+
+	if err != nil {
+		return Blob{}, nil, fmt.Errorf("%w: %v", errors.ErrBadBundle, err)
+	}
+
+	if header.Name != blobPath(blob.Digest) || header.Size != blob.Size {
+		return Blob{}, nil, fmt.Errorf("%w: expected blob %s, found %s", errors.ErrBadBundle, blob.Digest,
+			header.Name)
+	}
+
+	br.next++
+	br.current = newVerifyingReader(br.tr, blob)
+
+	return blob, br.current, nil
+}
+
+// verifyingReader fails at the end of the blob if its content doesn't match its digest.
+type verifyingReader struct {
+	reader io.Reader
+	blob   Blob
+	hash   hash.Hash
+	size   int64
+}
+
+func newVerifyingReader(reader io.Reader, blob Blob) *verifyingReader {
+	return &verifyingReader{reader: reader, blob: blob, hash: blob.Digest.Algorithm().Hash()}
+}
+
+func (vr *verifyingReader) Read(p []byte) (int, error) {
+	n, err := vr.reader.Read(p)
+	_, _ = vr.hash.Write(p[:n])
+	vr.size += int64(n)
+
+	if err == io.EOF {
+		if verr := vr.verify(); verr != nil {
+			return n, verr
+		}
+	}
+
+	return n, err
+}
+
+func (vr *verifyingReader) verify() error {
+	if vr.size != vr.blob.Size ||
+		godigest.NewDigestFromBytes(vr.blob.Digest.Algorithm(), vr.hash.Sum(nil)) != vr.blob.Digest {
+		return fmt.Errorf("%w: %s", errors.ErrBadBlobDigest, vr.blob.Digest)
+	}
+
+	return nil
+}
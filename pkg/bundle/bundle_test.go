@@ -0,0 +1,248 @@
+package bundle_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	goerrors "errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/bundle"
+	godigest "github.com/opencontainers/go-digest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writePEM writes the PEM encoded DER bytes to a file of the directory, and returns its path.
+func writePEM(dir, name, blockType string, der []byte) string {
+	file := path.Join(dir, name)
+	So(ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600), ShouldBeNil)
+
+	return file
+}
+
+// newCA returns a self-signed CA certificate, with its key and the file it is written to.
+func newCA(dir, name string) (*x509.Certificate, crypto.Signer, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	So(err, ShouldBeNil)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	So(err, ShouldBeNil)
+
+	cert, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+
+	return cert, key, writePEM(dir, name+".crt", "CERTIFICATE", der)
+}
+
+// newSigningCert writes a code signing key and its certificate issued by the CA, and returns their paths.
+func newSigningCert(dir string, ca *x509.Certificate, caKey crypto.Signer, key crypto.Signer) (string, string) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "release team"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	So(err, ShouldBeNil)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	So(err, ShouldBeNil)
+
+	return writePEM(dir, "signer.key", "PRIVATE KEY", keyDER), writePEM(dir, "signer.crt", "CERTIFICATE", der)
+}
+
+func newBlob(content string) (bundle.Blob, []byte) {
+	return bundle.Blob{Digest: godigest.FromString(content), Size: int64(len(content))}, []byte(content)
+}
+
+func TestBundle(t *testing.T) {
+	Convey("Create and read bundles", t, func() {
+		dir, err := ioutil.TempDir("", "bundle_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ca, caKey, caFile := newCA(dir, "ca")
+
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+
+		keyFile, certFile := newSigningCert(dir, ca, caKey, rsaKey)
+
+		signer, err := bundle.NewSigner(keyFile, certFile)
+		So(err, ShouldBeNil)
+
+		roots, err := bundle.NewTrustStore(caFile)
+		So(err, ShouldBeNil)
+
+		contents := make(map[godigest.Digest][]byte)
+		blob := func(content string) bundle.Blob {
+			b, buf := newBlob(content)
+			contents[b.Digest] = buf
+
+			return b
+		}
+
+		manifest1, manifest2 := blob("manifest of app:1.0"), blob("manifest of app:2.0")
+		config, layer1, layer2 := blob("config"), blob("base layer"), blob("app layer")
+
+		index := &bundle.Index{}
+		index.AddImage(bundle.Image{Repo: "app", Tag: "1.0", Digest: manifest1.Digest,
+			Blobs: []godigest.Digest{config.Digest, layer1.Digest}}, manifest1, config, layer1)
+		index.AddImage(bundle.Image{Repo: "app", Tag: "2.0", Digest: manifest2.Digest,
+			Blobs: []godigest.Digest{config.Digest, layer1.Digest, layer2.Digest}}, manifest2, config, layer1, layer2)
+
+		// blobs shared by the images are stored once
+		So(len(index.Blobs), ShouldEqual, 5)
+
+		fetch := func(b bundle.Blob) (io.ReadCloser, error) {
+			content, ok := contents[b.Digest]
+			if !ok {
+				return nil, errors.ErrBlobNotFound
+			}
+
+			return ioutil.NopCloser(bytes.NewReader(content)), nil
+		}
+
+		var buf bytes.Buffer
+		So(bundle.Create(&buf, index, signer, fetch), ShouldBeNil)
+
+		Convey("Read the blobs in order", func() {
+			reader, err := bundle.NewReader(bytes.NewReader(buf.Bytes()), roots)
+			So(err, ShouldBeNil)
+			So(reader.Signer.Subject.CommonName, ShouldEqual, "release team")
+			So(reader.Index.Version, ShouldEqual, bundle.Version)
+			So(reader.Index.Images, ShouldResemble, index.Images)
+
+			for _, expected := range index.Blobs {
+				b, content, err := reader.Next()
+				So(err, ShouldBeNil)
+				So(b, ShouldResemble, expected)
+
+				// blobs which aren't read are skipped
+				if b.Digest == layer1.Digest {
+					continue
+				}
+
+				data, err := ioutil.ReadAll(content)
+				So(err, ShouldBeNil)
+				So(data, ShouldResemble, contents[b.Digest])
+			}
+
+			_, _, err = reader.Next()
+			So(err, ShouldEqual, io.EOF)
+		})
+
+		Convey("Tampered blobs fail their digest", func() {
+			tampered := bytes.Replace(buf.Bytes(), []byte("app layer"), []byte("bad layer"), 1)
+
+			reader, err := bundle.NewReader(bytes.NewReader(tampered), roots)
+			So(err, ShouldBeNil)
+
+			for {
+				_, content, err := reader.Next()
+				if err != nil {
+					So(goerrors.Is(err, errors.ErrBadBlobDigest), ShouldBeTrue)
+					break
+				}
+
+				if _, err := ioutil.ReadAll(content); err != nil {
+					So(goerrors.Is(err, errors.ErrBadBlobDigest), ShouldBeTrue)
+					break
+				}
+			}
+		})
+
+		Convey("Tampered indexes fail the signature", func() {
+			tampered := bytes.Replace(buf.Bytes(), []byte(`"tag":"2.0"`), []byte(`"tag":"6.6"`), 1)
+			So(tampered, ShouldNotResemble, buf.Bytes())
+
+			_, err := bundle.NewReader(bytes.NewReader(tampered), roots)
+			So(goerrors.Is(err, errors.ErrBadSignature), ShouldBeTrue)
+		})
+
+		Convey("Bundles signed by untrusted certificates are rejected", func() {
+			_, _, otherCAFile := newCA(dir, "other")
+
+			otherRoots, err := bundle.NewTrustStore(otherCAFile)
+			So(err, ShouldBeNil)
+
+			_, err = bundle.NewReader(bytes.NewReader(buf.Bytes()), otherRoots)
+			So(goerrors.Is(err, errors.ErrUntrustedSignature), ShouldBeTrue)
+		})
+
+		Convey("Truncated bundles are incomplete", func() {
+			reader, err := bundle.NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), roots)
+			So(err, ShouldBeNil)
+
+			for err == nil {
+				var content io.Reader
+				if _, content, err = reader.Next(); err == nil {
+					_, err = ioutil.ReadAll(content)
+				}
+			}
+
+			So(err, ShouldNotEqual, io.EOF)
+		})
+
+		Convey("Blobs must match the index", func() {
+			err := bundle.Create(ioutil.Discard, index, signer, func(b bundle.Blob) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader([]byte("something else"))), nil
+			})
+			So(goerrors.Is(err, errors.ErrBadBlobDigest), ShouldBeTrue)
+
+			// the manifest of the image isn't in the bundle
+			err = bundle.Create(ioutil.Discard, &bundle.Index{Images: []bundle.Image{{Repo: "app", Tag: "1.0",
+				Digest: manifest1.Digest}}}, signer, fetch)
+			So(goerrors.Is(err, errors.ErrBadBundle), ShouldBeTrue)
+		})
+
+		Convey("ECDSA keys", func() {
+			ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			So(err, ShouldBeNil)
+
+			ecDir := path.Join(dir, "ecdsa")
+			So(os.Mkdir(ecDir, 0700), ShouldBeNil)
+
+			ecKeyFile, ecCertFile := newSigningCert(ecDir, ca, caKey, ecKey)
+
+			ecSigner, err := bundle.NewSigner(ecKeyFile, ecCertFile)
+			So(err, ShouldBeNil)
+
+			var ecBuf bytes.Buffer
+			So(bundle.Create(&ecBuf, index, ecSigner, fetch), ShouldBeNil)
+
+			_, err = bundle.NewReader(bytes.NewReader(ecBuf.Bytes()), roots)
+			So(err, ShouldBeNil)
+
+			// the certificate must certify the key
+			_, err = bundle.NewSigner(keyFile, ecCertFile)
+			So(goerrors.Is(err, errors.ErrBadSigningKey), ShouldBeTrue)
+		})
+	})
+}
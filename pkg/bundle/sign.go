@@ -0,0 +1,239 @@
+package bundle
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+)
+
+const (
+	algorithmPS256 = "PS256"
+	algorithmES256 = "ES256"
+)
+
+// signature signs the index of a bundle, like JWS signatures the ECDSA ones are the fixed size
+// concatenation of r and s.
+type signature struct {
+	Algorithm string `json:"alg"`
+	Signature []byte `json:"signature"`
+	// CertChain holds the DER encoded signing certificate, followed by its intermediates
+	CertChain [][]byte `json:"x5c"`
+}
+
+// Signer signs bundles with a private key, and attaches its certificate chain to the signatures.
+type Signer struct {
+	key       crypto.Signer
+	certChain [][]byte
+}
+
+// NewSigner loads a PEM encoded RSA or ECDSA private key, and the PEM encoded certificate chain
+// of its public key, signing certificate first.
+func NewSigner(keyFile, certFile string) (*Signer, error) {
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := &Signer{key: key}
+
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			signer.certChain = append(signer.certChain, block.Bytes)
+		}
+	}
+
+	if len(signer.certChain) == 0 {
+		return nil, fmt.Errorf("%w: no certificate in %s", errors.ErrBadSigningKey, certFile)
+	}
+
+	leaf, err := x509.ParseCertificate(signer.certChain[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if !publicKeyEqual(leaf.PublicKey, key.Public()) {
+		return nil, fmt.Errorf("%w: %s doesn't certify the key", errors.ErrBadSigningKey, certFile)
+	}
+
+	return signer, nil
+}
+
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM encoded key", errors.ErrBadSigningKey)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrBadSigningKey, err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: only RSA and ECDSA keys are supported", errors.ErrBadSigningKey)
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	switch a := a.(type) {
+	case *rsa.PublicKey:
+		b, ok := b.(*rsa.PublicKey)
+		return ok && a.N.Cmp(b.N) == 0 && a.E == b.E
+	case *ecdsa.PublicKey:
+		b, ok := b.(*ecdsa.PublicKey)
+		return ok && a.X.Cmp(b.X) == 0 && a.Y.Cmp(b.Y) == 0
+	}
+
+	return false
+}
+
+func (signer *Signer) sign(content []byte) (*signature, error) {
+	digest := sha256.Sum256(content)
+	sig := &signature{CertChain: signer.certChain}
+
+	switch key := signer.key.(type) {
+	case *rsa.PrivateKey:
+		buf, err := rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest[:],
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+		if err != nil {
+			return nil, err
+		}
+
+		sig.Algorithm, sig.Signature = algorithmPS256, buf
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+
+		size := (key.Curve.Params().BitSize + 7) / 8 // nolint: gomnd
+		buf := make([]byte, 2*size)                  // nolint: gomnd
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(buf[size-len(rBytes):size], rBytes)
+		copy(buf[2*size-len(sBytes):], sBytes)
+
+		sig.Algorithm, sig.Signature = algorithmES256, buf
+	}
+
+	return sig, nil
+}
+
+// verify checks that the signature signs the content, with a certificate chaining up to one of the
+// trusted roots, and returns the signing certificate.
+func (sig *signature) verify(content []byte, roots *x509.CertPool, now time.Time) (*x509.Certificate, error) {
+	if len(sig.CertChain) == 0 {
+		return nil, fmt.Errorf("%w: missing certificate chain", errors.ErrBadSignature)
+	}
+
+	certs := make([]*x509.Certificate, len(sig.CertChain))
+
+	for i, der := range sig.CertChain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrBadSignature, err)
+		}
+
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		CurrentTime:   now,
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrUntrustedSignature, err)
+	}
+
+	digest := sha256.Sum256(content)
+
+	switch pub := certs[0].PublicKey.(type) {
+	case *rsa.PublicKey:
+		if sig.Algorithm != algorithmPS256 {
+			break
+		}
+
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		if err := rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig.Signature, opts); err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrBadSignature, err)
+		}
+
+		return certs[0], nil
+	case *ecdsa.PublicKey:
+		if sig.Algorithm != algorithmES256 {
+			break
+		}
+
+		size := (pub.Curve.Params().BitSize + 7) / 8 // nolint: gomnd
+		if len(sig.Signature) != 2*size {
+			return nil, fmt.Errorf("%w: invalid ECDSA signature length", errors.ErrBadSignature)
+		}
+
+		r := new(big.Int).SetBytes(sig.Signature[:size])
+		s := new(big.Int).SetBytes(sig.Signature[size:])
+
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return nil, fmt.Errorf("%w: ECDSA verification failed", errors.ErrBadSignature)
+		}
+
+		return certs[0], nil
+	}
+
+	return nil, fmt.Errorf("%w: algorithm %q doesn't match the signing key", errors.ErrBadSignature, sig.Algorithm)
+}
+
+// NewTrustStore loads the PEM encoded root certificates trusted to sign bundles.
+func NewTrustStore(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("%w: %s", errors.ErrBadCACert, file)
+		}
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,218 @@
+// Package changefeed keeps an append-only log of the changes of the repositories of a registry: pushes,
+// deletes and tag moves. Each event gets a cursor, increasing with time, from which consumers such as
+// external indexers read the following events to catch up after a downtime, instead of walking the catalog.
+package changefeed
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"path"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/scheduler"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// File is the database of the events, under the root directory of the default image store.
+	File = "changefeed.db"
+	// DefaultRetention is how long events are kept if not configured.
+	DefaultRetention = 7 * 24 * time.Hour
+
+	eventsBucket = "events"
+	cursorSize   = 8
+)
+
+// Event is a change of a repository, see the kinds of storage.Change.
+type Event struct {
+	Cursor   uint64          `json:"cursor"`
+	Time     time.Time       `json:"time"`
+	Kind     string          `json:"kind"`
+	Repo     string          `json:"repo"`
+	Tag      string          `json:"tag,omitempty"`
+	Digest   godigest.Digest `json:"digest"`
+	Previous godigest.Digest `json:"previous,omitempty"`
+}
+
+// Feed is the event log, kept in a bolt database keyed by cursor.
+type Feed struct {
+	db        *bbolt.DB
+	retention time.Duration
+	log       log.Logger
+}
+
+// NewFeed opens the event log under a root directory, events older than retention are pruned, after the
+// default retention if 0.
+func NewFeed(rootDir string, retention time.Duration, log log.Logger) (*Feed, error) {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	dbPath := path.Join(rootDir, File)
+
+	db, err := bbolt.Open(dbPath, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Error().Err(err).Str("dbPath", dbPath).Msg("unable to open changefeed db")
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(eventsBucket))
+		return err
+	}); err != nil {
+		log.Error().Err(err).Str("dbPath", dbPath).Msg("unable to create a root bucket")
+		db.Close()
+
+		return nil, err
+	}
+
+	return &Feed{db: db, retention: retention, log: log}, nil
+}
+
+// Close closes the database.
+func (f *Feed) Close() error {
+	return f.db.Close()
+}
+
+// Record appends a change of an image store, it's the change hook of the image stores.
+func (f *Feed) Record(change storage.Change) {
+	if _, err := f.Append(change, time.Now()); err != nil {
+		f.log.Error().Err(err).Str("repo", change.Repo).Str("kind", change.Kind).Msg("unable to record change")
+	}
+}
+
+// Append appends a change which happened at some time, and returns the cursor of its event.
+func (f *Feed) Append(change storage.Change, at time.Time) (uint64, error) {
+	var cursor uint64
+
+	err := f.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(eventsBucket))
+
+		var err error
+
+		if cursor, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+
+		buf, err := json.Marshal(Event{Cursor: cursor, Time: at.UTC(), Kind: change.Kind, Repo: change.Repo,
+			Tag: change.Tag, Digest: change.Digest, Previous: change.Previous})
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(cursorKey(cursor), buf)
+	})
+
+	return cursor, err
+}
+
+// Events returns up to limit events following a cursor, 0 for the oldest event kept, those of the
+// repositories which aren't allowed being skipped. It returns the cursor to read the next events from,
+// and whether there are more. ErrChangefeedCursorExpired is returned if events following the cursor were
+// pruned, or if it's unknown, consumers have to walk the catalog again then.
+func (f *Feed) Events(cursor uint64, limit int, allowed func(repo string) bool) ([]Event, uint64, bool, error) {
+	events := []Event{}
+	next := cursor
+	more := false
+
+	err := f.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(eventsBucket))
+
+		if cursor > bucket.Sequence() {
+			return errors.ErrChangefeedCursorExpired
+		}
+
+		c := bucket.Cursor()
+
+		k, v := c.Seek(cursorKey(cursor + 1))
+
+		// the following event must still be there, unless it wasn't appended yet
+		if cursor > 0 && cursor < bucket.Sequence() && (k == nil || binary.BigEndian.Uint64(k) != cursor+1) {
+			return errors.ErrChangefeedCursorExpired
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			if len(events) == limit {
+				more = true
+				break
+			}
+
+			var event Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+
+			next = event.Cursor
+
+			if allowed == nil || allowed(event.Repo) {
+				events = append(events, event)
+			}
+		}
+
+		return nil
+	})
+
+	return events, next, more, err
+}
+
+// Prune removes the events older than the retention, and returns how many were removed.
+func (f *Feed) Prune(now time.Time) (int, error) {
+	before := now.Add(-f.retention)
+	removed := 0
+
+	err := f.db.Update(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(eventsBucket)).Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			var event Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+
+			// events are appended in time order
+			if !event.Time.Before(before) {
+				break
+			}
+
+			if err := c.Delete(); err != nil {
+				return err
+			}
+
+			removed++
+		}
+
+		return nil
+	})
+
+	if removed > 0 {
+		f.log.Info().Int("events", removed).Msg("pruned changefeed events")
+	}
+
+	return removed, err
+}
+
+func cursorKey(cursor uint64) []byte {
+	key := make([]byte, cursorSize)
+	binary.BigEndian.PutUint64(key, cursor)
+
+	return key
+}
+
+type pruneTask struct {
+	feed *Feed
+}
+
+// NewPruneTask returns a scheduler task which removes the events past their retention.
+func NewPruneTask(feed *Feed) scheduler.Task {
+	return &pruneTask{feed: feed}
+}
+
+func (t *pruneTask) DoWork() error {
+	_, err := t.feed.Prune(time.Now())
+
+	return err
+}
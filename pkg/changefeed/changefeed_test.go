@@ -0,0 +1,133 @@
+package changefeed_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/changefeed"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func putManifest(imgStore *storage.ImageStore, repo, reference, content string) godigest.Digest {
+	manifest := ispec.Manifest{
+		Config: ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    godigest.FromString(content),
+			Size:      int64(len(content)),
+		},
+	}
+	manifest.SchemaVersion = 2
+
+	body, err := json.Marshal(manifest)
+	So(err, ShouldBeNil)
+
+	digest, err := imgStore.PutImageManifest(repo, reference, ispec.MediaTypeImageManifest, body)
+	So(err, ShouldBeNil)
+
+	return godigest.Digest(digest)
+}
+
+func TestChangefeed(t *testing.T) {
+	Convey("The changes of an image store are read in order from a cursor", t, func() {
+		dir, err := ioutil.TempDir("", "oci-changefeed-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		logger := log.NewLogger("debug", "")
+
+		feed, err := changefeed.NewFeed(dir, time.Hour, logger)
+		So(err, ShouldBeNil)
+		defer feed.Close()
+
+		imgStore := storage.NewImageStore(dir, false, false, logger)
+		imgStore.SetChangeHook(feed.Record)
+
+		first := putManifest(imgStore, "a", "1.0", "first")
+		second := putManifest(imgStore, "a", "1.0", "second")
+		putManifest(imgStore, "a", "1.0", "second")
+		third := putManifest(imgStore, "b", "latest", "third")
+		So(imgStore.DeleteImageManifest("a", "1.0"), ShouldBeNil)
+
+		events, next, more, err := feed.Events(0, 10, nil)
+		So(err, ShouldBeNil)
+		So(more, ShouldBeFalse)
+		So(next, ShouldEqual, 4)
+		So(len(events), ShouldEqual, 4)
+
+		So(events[0].Cursor, ShouldEqual, 1)
+		So(events[0].Kind, ShouldEqual, storage.ChangePush)
+		So(events[0].Repo, ShouldEqual, "a")
+		So(events[0].Tag, ShouldEqual, "1.0")
+		So(events[0].Digest, ShouldEqual, first)
+
+		So(events[1].Kind, ShouldEqual, storage.ChangeTagMove)
+		So(events[1].Digest, ShouldEqual, second)
+		So(events[1].Previous, ShouldEqual, first)
+
+		So(events[2].Repo, ShouldEqual, "b")
+		So(events[2].Digest, ShouldEqual, third)
+
+		So(events[3].Kind, ShouldEqual, storage.ChangeDelete)
+		So(events[3].Tag, ShouldEqual, "1.0")
+		So(events[3].Digest, ShouldEqual, second)
+
+		Convey("Pages follow each other", func() {
+			events, next, more, err := feed.Events(0, 2, nil)
+			So(err, ShouldBeNil)
+			So(more, ShouldBeTrue)
+			So(next, ShouldEqual, 2)
+			So(len(events), ShouldEqual, 2)
+
+			events, next, more, err = feed.Events(next, 2, nil)
+			So(err, ShouldBeNil)
+			So(more, ShouldBeFalse)
+			So(next, ShouldEqual, 4)
+			So(events[0].Cursor, ShouldEqual, 3)
+
+			events, next, _, err = feed.Events(next, 2, nil)
+			So(err, ShouldBeNil)
+			So(events, ShouldBeEmpty)
+			So(next, ShouldEqual, 4)
+		})
+
+		Convey("Events of repositories which aren't allowed are skipped", func() {
+			events, next, _, err := feed.Events(0, 10, func(repo string) bool { return repo == "b" })
+			So(err, ShouldBeNil)
+			So(next, ShouldEqual, 4)
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Repo, ShouldEqual, "b")
+		})
+
+		Convey("Cursors of pruned or unknown events expire", func() {
+			removed, err := feed.Prune(time.Now().Add(2 * time.Hour))
+			So(err, ShouldBeNil)
+			So(removed, ShouldEqual, 4)
+
+			_, err = feed.Append(storage.Change{Kind: storage.ChangePush, Repo: "c", Digest: first}, time.Now())
+			So(err, ShouldBeNil)
+
+			_, _, _, err = feed.Events(2, 10, nil)
+			So(err, ShouldEqual, errors.ErrChangefeedCursorExpired)
+
+			_, _, _, err = feed.Events(42, 10, nil)
+			So(err, ShouldEqual, errors.ErrChangefeedCursorExpired)
+
+			events, next, _, err := feed.Events(4, 10, nil)
+			So(err, ShouldBeNil)
+			So(next, ShouldEqual, 5)
+			So(len(events), ShouldEqual, 1)
+
+			events, _, _, err = feed.Events(0, 10, nil)
+			So(err, ShouldBeNil)
+			So(len(events), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,95 @@
+// +build extended
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+const backupEndpoint = "/v2/_zot/admin/backup"
+
+func NewBackupCommand() *cobra.Command {
+	var servURL, user string
+
+	full := false
+
+	backupCmd := &cobra.Command{
+		Use:   "backup [config-name]",
+		Short: "Take snapshots of the image stores of a zot server",
+		Long: `Take a consistent snapshot of each image store of a zot server into the backup directory of the
+server config, pushes wait until it's done. Snapshots are incremental, only the blobs which weren't backed up yet
+are copied, unless --full is set. Restore them with "zot restore" while zot is stopped. Admin credentials
+are needed if the server has authentication enabled.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverURL, verifyTLS, err := serverFromCommand(cmd, args)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			if serverURL == "" {
+				return zotErrors.ErrNoURLProvided
+			}
+
+			cmd.SilenceUsage = true
+
+			endPoint, err := combineServerAndEndpointURL(serverURL, backupEndpoint)
+			if err != nil {
+				return err
+			}
+
+			req, err := http.NewRequest(http.MethodPost, endPoint+"?full="+strconv.FormatBool(full), nil)
+			if err != nil {
+				return err
+			}
+
+			username, password := getUsernameAndPassword(user)
+			req.SetBasicAuth(username, password)
+
+			reports := map[string]storage.BackupReport{}
+
+			if _, err := doHTTPRequest(req, verifyTLS, &reports); err != nil {
+				return err
+			}
+
+			printBackupReports(cmd.OutOrStdout(), reports)
+
+			return nil
+		},
+	}
+
+	backupCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	backupCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	backupCmd.Flags().BoolVar(&full, "full", false, "Copy all the blobs, not only those which weren't backed up yet")
+
+	backupCmd.ValidArgsFunction = completeConfigNames
+
+	return backupCmd
+}
+
+func printBackupReports(writer io.Writer, reports map[string]storage.BackupReport) {
+	routes := make([]string, 0, len(reports))
+	for route := range reports {
+		routes = append(routes, route)
+	}
+
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		report := reports[route]
+
+		fmt.Fprintf(writer, "%s: snapshot %s of %d repositories in %s, copied %d blobs (%s), skipped %d\n",
+			route, report.Snapshot, report.Repos, report.BackupDir, report.CopiedBlobs,
+			humanize.Bytes(uint64(report.CopiedBytes)), report.SkippedBlobs)
+	}
+}
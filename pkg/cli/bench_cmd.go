@@ -0,0 +1,477 @@
+// +build extended
+
+package cli
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/dustin/go-humanize"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// benchOptions describe the synthetic workload of zot bench.
+type benchOptions struct {
+	namespace   string
+	repos       int
+	images      int // per repository
+	layers      int // per image
+	layerSize   int64
+	pulls       int // per image
+	concurrency int
+}
+
+// benchImage is an image pushed by the benchmark, identified by its repository and tag.
+type benchImage struct {
+	repo string
+	tag  string
+}
+
+// benchLatency are latency percentiles of the operations of a phase.
+type benchLatency struct {
+	Min time.Duration `json:"min"`
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// benchPhase is the outcome of pushing or pulling all the images, an operation being a whole image.
+type benchPhase struct {
+	Operations     int           `json:"operations"`
+	Errors         int           `json:"errors"`
+	FirstError     string        `json:"firstError,omitempty"`
+	Bytes          int64         `json:"bytes"`
+	Duration       time.Duration `json:"duration"`
+	OpsPerSecond   float64       `json:"opsPerSecond"`
+	BytesPerSecond float64       `json:"bytesPerSecond"`
+	Latency        benchLatency  `json:"latency"`
+}
+
+type benchReport struct {
+	Repositories int        `json:"repositories"`
+	Images       int        `json:"images"`
+	Concurrency  int        `json:"concurrency"`
+	Push         benchPhase `json:"push"`
+	Pull         benchPhase `json:"pull"`
+}
+
+func NewBenchCommand() *cobra.Command {
+	var servURL, user, namespace, outputFormat, layerSize string
+
+	options := benchOptions{}
+
+	benchCmd := &cobra.Command{
+		Use:   "bench [config-name]",
+		Short: "Load test a registry with synthetic pushes and pulls",
+		Long: `Push images made of random layers to repositories of a registry, zot or any other, with concurrent
+workers, then pull each of them, and report the latency percentiles and the throughput of both phases.
+Images are pushed under the namespace, which defaults to zot-bench/<random>, and are left there.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverURL, verifyTLS, err := serverFromCommand(cmd, args)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			if serverURL == "" {
+				return zotErrors.ErrNoURLProvided
+			}
+
+			size, err := humanize.ParseBytes(layerSize)
+			if err != nil || size == 0 {
+				return fmt.Errorf("%w: invalid layer size %q", zotErrors.ErrInvalidArgs, layerSize)
+			}
+
+			if options.repos < 1 || options.images < 1 || options.layers < 1 || options.concurrency < 1 ||
+				options.pulls < 0 {
+				return fmt.Errorf("%w: counts must be positive", zotErrors.ErrInvalidArgs)
+			}
+
+			switch strings.ToLower(outputFormat) {
+			case "", defaultOutoutFormat, jsonOutputFormat, ymlOutputFormat, yamlOutputFormat:
+			default:
+				return ErrInvalidOutputFormat
+			}
+
+			cmd.SilenceUsage = true
+
+			username, password := getUsernameAndPassword(user)
+
+			client, err := newRegistryClient(serverURL, username, password, verifyTLS)
+			if err != nil {
+				return err
+			}
+
+			options.namespace = namespace
+			if options.namespace == "" {
+				options.namespace = "zot-bench/" + strconv.FormatInt(time.Now().UnixNano(), 36)
+			}
+
+			options.layerSize = int64(size)
+
+			report := runBench(client, options)
+
+			if err := printBenchReport(cmd.OutOrStdout(), report, outputFormat); err != nil {
+				return err
+			}
+
+			if failed := report.Push.Errors + report.Pull.Errors; failed != 0 {
+				return fmt.Errorf("%w: %d of %d operations failed", zotErrors.ErrBenchmarkFailed, failed,
+					report.Push.Operations+report.Pull.Operations)
+			}
+
+			return nil
+		},
+	}
+
+	benchCmd.Flags().StringVar(&servURL, "url", "", "Specify registry URL if config-name is not mentioned")
+	benchCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of the registry in "username:password" format`)
+	benchCmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace the images are pushed to")
+	benchCmd.Flags().IntVar(&options.repos, "repos", 1, "Number of repositories")
+	benchCmd.Flags().IntVar(&options.images, "images", 10, "Number of images pushed to each repository")
+	benchCmd.Flags().IntVar(&options.layers, "layers", 1, "Number of layers of each image")
+	benchCmd.Flags().StringVar(&layerSize, "layer-size", "1MB", "Size of each layer, e.g. 512KB or 10MB")
+	benchCmd.Flags().IntVar(&options.pulls, "pulls", 1, "Number of times each image is pulled")
+	benchCmd.Flags().IntVarP(&options.concurrency, "concurrency", "c", 4, "Number of concurrent workers")
+	benchCmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Specify output format [text/json/yaml]")
+
+	benchCmd.ValidArgsFunction = completeConfigNames
+
+	return benchCmd
+}
+
+// runBench pushes all the images, then pulls the ones which were pushed.
+func runBench(client *registryClient, options benchOptions) benchReport {
+	images := make([]benchImage, 0, options.repos*options.images)
+
+	for r := 0; r < options.repos; r++ {
+		for i := 0; i < options.images; i++ {
+			images = append(images, benchImage{
+				repo: fmt.Sprintf("%s/repo-%d", options.namespace, r),
+				tag:  strconv.Itoa(i),
+			})
+		}
+	}
+
+	push, pushed := runBenchPhase(images, options.concurrency, func(image benchImage) (int64, error) {
+		return pushBenchImage(client, image, options)
+	})
+
+	pulls := make([]benchImage, 0, len(pushed)*options.pulls)
+	for i := 0; i < options.pulls; i++ {
+		pulls = append(pulls, pushed...)
+	}
+
+	pull, _ := runBenchPhase(pulls, options.concurrency, func(image benchImage) (int64, error) {
+		return pullBenchImage(client, image)
+	})
+
+	return benchReport{
+		Repositories: options.repos,
+		Images:       len(images),
+		Concurrency:  options.concurrency,
+		Push:         push,
+		Pull:         pull,
+	}
+}
+
+// runBenchPhase runs an operation on each image with concurrent workers, and returns its statistics
+// with the images it succeeded for.
+func runBenchPhase(images []benchImage, concurrency int,
+	operation func(benchImage) (int64, error)) (benchPhase, []benchImage) {
+	var (
+		lock      sync.Mutex
+		wg        sync.WaitGroup
+		latencies []time.Duration
+		succeeded []benchImage
+	)
+
+	phase := benchPhase{Operations: len(images)}
+	jobs := make(chan benchImage)
+	start := time.Now()
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for image := range jobs {
+				opStart := time.Now()
+				size, err := operation(image)
+				latency := time.Since(opStart)
+
+				lock.Lock()
+
+				if err != nil {
+					phase.Errors++
+
+					if phase.FirstError == "" {
+						phase.FirstError = fmt.Sprintf("%s:%s: %s", image.repo, image.tag, err)
+					}
+				} else {
+					phase.Bytes += size
+					latencies = append(latencies, latency)
+					succeeded = append(succeeded, image)
+				}
+
+				lock.Unlock()
+			}
+		}()
+	}
+
+	for _, image := range images {
+		jobs <- image
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	phase.Duration = time.Since(start)
+
+	if seconds := phase.Duration.Seconds(); seconds > 0 {
+		phase.OpsPerSecond = float64(len(latencies)) / seconds
+		phase.BytesPerSecond = float64(phase.Bytes) / seconds
+	}
+
+	phase.Latency = latencyPercentiles(latencies)
+
+	return phase, succeeded
+}
+
+// latencyPercentiles returns the nearest-rank percentiles of the latencies.
+func latencyPercentiles(latencies []time.Duration) benchLatency {
+	if len(latencies) == 0 {
+		return benchLatency{}
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	percentile := func(p float64) time.Duration {
+		rank := int(math.Ceil(p / 100 * float64(len(latencies))))
+
+		if rank < 1 {
+			rank = 1
+		}
+
+		return latencies[rank-1]
+	}
+
+	return benchLatency{
+		Min: latencies[0],
+		P50: percentile(50), // nolint: gomnd
+		P90: percentile(90), // nolint: gomnd
+		P99: percentile(99), // nolint: gomnd
+		Max: latencies[len(latencies)-1],
+	}
+}
+
+// pushBenchImage pushes an image made of random layers, so that the registry can't dedupe them,
+// and returns the size of its blobs.
+func pushBenchImage(client *registryClient, image benchImage, options benchOptions) (int64, error) {
+	manifest := ispec.Manifest{Layers: make([]ispec.Descriptor, 0, options.layers)}
+	manifest.SchemaVersion = 2
+
+	diffIDs := make([]godigest.Digest, 0, options.layers)
+	size := int64(0)
+
+	for i := 0; i < options.layers; i++ {
+		layer := make([]byte, options.layerSize)
+		if _, err := rand.Read(layer); err != nil {
+			return 0, err
+		}
+
+		digest := godigest.FromBytes(layer)
+
+		if err := client.pushBlob(image.repo, digest, bytes.NewReader(layer), options.layerSize); err != nil {
+			return 0, err
+		}
+
+		manifest.Layers = append(manifest.Layers, ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageLayer,
+			Digest:    digest,
+			Size:      options.layerSize,
+		})
+
+		diffIDs = append(diffIDs, digest)
+		size += options.layerSize
+	}
+
+	created := time.Now()
+
+	config, err := json.Marshal(ispec.Image{
+		Created:      &created,
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS:       ispec.RootFS{Type: "layers", DiffIDs: diffIDs},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	configDigest := godigest.FromBytes(config)
+
+	if err := client.pushBlob(image.repo, configDigest, bytes.NewReader(config), int64(len(config))); err != nil {
+		return 0, err
+	}
+
+	manifest.Config = ispec.Descriptor{
+		MediaType: ispec.MediaTypeImageConfig,
+		Digest:    configDigest,
+		Size:      int64(len(config)),
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := client.pushManifest(image.repo, image.tag, ispec.MediaTypeImageManifest, content); err != nil {
+		return 0, err
+	}
+
+	return size + int64(len(config)) + int64(len(content)), nil
+}
+
+// pullBenchImage pulls the manifest and the blobs of an image, and returns the size of what was read.
+func pullBenchImage(client *registryClient, image benchImage) (int64, error) {
+	content, _, _, err := client.getManifest(image.repo, image.tag)
+	if err != nil {
+		return 0, err
+	}
+
+	var manifest ispec.Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return 0, err
+	}
+
+	size := int64(len(content))
+
+	for _, desc := range append([]ispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		blob, err := client.getBlob(image.repo, desc.Digest)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := io.Copy(ioutil.Discard, blob)
+		blob.Close()
+
+		if err != nil {
+			return 0, err
+		}
+
+		size += n
+	}
+
+	return size, nil
+}
+
+func printBenchReport(writer io.Writer, report benchReport, outputFormat string) error {
+	switch strings.ToLower(outputFormat) {
+	case jsonOutputFormat:
+		body, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(writer, string(body))
+
+		return nil
+	case ymlOutputFormat, yamlOutputFormat:
+		body, err := yaml.Marshal(report)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(writer, string(body))
+
+		return nil
+	}
+
+	fmt.Fprintf(writer, "%d images in %d repositories, %d workers\n\n", report.Images, report.Repositories,
+		report.Concurrency)
+
+	layout := newBenchTableLayout()
+	layout.printHeader(writer)
+
+	table := layout.newTable(writer)
+
+	for _, phase := range []struct {
+		name  string
+		stats benchPhase
+	}{{"push", report.Push}, {"pull", report.Pull}} {
+		table.Append(layout.row(map[string]string{
+			columnPhase:      phase.name,
+			columnOps:        strconv.Itoa(phase.stats.Operations),
+			columnErrors:     strconv.Itoa(phase.stats.Errors),
+			columnDuration:   phase.stats.Duration.Round(time.Millisecond).String(),
+			columnOpsPerSec:  strconv.FormatFloat(phase.stats.OpsPerSecond, 'f', 1, 64),
+			columnThroughput: formatBytes(int64(phase.stats.BytesPerSecond)) + "/s",
+			columnP50:        formatLatency(phase.stats.Latency.P50),
+			columnP90:        formatLatency(phase.stats.Latency.P90),
+			columnP99:        formatLatency(phase.stats.Latency.P99),
+			columnMax:        formatLatency(phase.stats.Latency.Max),
+		}))
+	}
+
+	table.Render()
+
+	for _, phase := range []benchPhase{report.Push, report.Pull} {
+		if phase.FirstError != "" {
+			fmt.Fprintf(writer, "\nfirst error: %s\n", phase.FirstError)
+		}
+	}
+
+	return nil
+}
+
+func formatLatency(latency time.Duration) string {
+	return latency.Round(time.Millisecond / 10).String() // nolint: gomnd
+}
+
+// newBenchTableLayout returns the layout of the table of the push and pull phases.
+func newBenchTableLayout() *tableLayout {
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnPhase, header: "PHASE", minWidth: countWidth},
+			{name: columnOps, header: "IMAGES", minWidth: countWidth},
+			{name: columnErrors, header: "ERRORS", minWidth: countWidth},
+			{name: columnDuration, header: "DURATION", minWidth: countWidth},
+			{name: columnOpsPerSec, header: "IMAGES/S", minWidth: countWidth},
+			{name: columnThroughput, header: "THROUGHPUT", minWidth: sizeWidth},
+			{name: columnP50, header: "P50", minWidth: countWidth},
+			{name: columnP90, header: "P90", minWidth: countWidth},
+			{name: columnP99, header: "P99", minWidth: countWidth},
+			{name: columnMax, header: "MAX", minWidth: countWidth},
+		},
+	}
+}
+
+const (
+	columnPhase      = "phase"
+	columnOps        = "operations"
+	columnErrors     = "errors"
+	columnDuration   = "duration"
+	columnOpsPerSec  = "opspersec"
+	columnThroughput = "throughput"
+	columnP50        = "p50"
+	columnP90        = "p90"
+	columnP99        = "p99"
+	columnMax        = "max"
+)
@@ -0,0 +1,123 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBenchCmd(t *testing.T) {
+	Convey("Test bench against zot", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		url, c := startTestServer(dir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		cmd := NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"bench", "--url", url, "--repos", "2", "--images", "3", "--layers", "2",
+			"--layer-size", "10KB", "--pulls", "2", "-c", "3", "--namespace", "bench"})
+		So(cmd.Execute(), ShouldBeNil)
+
+		lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+		So(lines[0], ShouldEqual, "6 images in 2 repositories, 3 workers")
+		So(strings.Fields(lines[2])[:3], ShouldResemble, []string{"PHASE", "IMAGES", "ERRORS"})
+		So(strings.Fields(lines[3])[:3], ShouldResemble, []string{"push", "6", "0"})
+		So(strings.Fields(lines[4])[:3], ShouldResemble, []string{"pull", "12", "0"})
+
+		repos, err := ioutil.ReadDir(dir + "/bench")
+		So(err, ShouldBeNil)
+		So(len(repos), ShouldEqual, 2)
+
+		cmd = NewRootCmd()
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"bench", "--url", url, "--images", "2", "--layer-size", "1KB", "-o", "json"})
+		So(cmd.Execute(), ShouldBeNil)
+
+		var report benchReport
+		So(json.Unmarshal(buff.Bytes(), &report), ShouldBeNil)
+		So(report.Push.Operations, ShouldEqual, 2)
+		So(report.Pull.Operations, ShouldEqual, 2)
+		// 1KB layer, config and manifest
+		So(report.Pull.Bytes, ShouldBeGreaterThan, 2*1000)
+		So(report.Pull.Latency.Max, ShouldBeGreaterThanOrEqualTo, report.Pull.Latency.P50)
+
+		for _, args := range [][]string{
+			{"bench"},
+			{"bench", "--url", url, "--layer-size", "big"},
+			{"bench", "--url", url, "-c", "0"},
+			{"bench", "--url", url, "-o", "random"},
+		} {
+			cmd = NewRootCmd()
+			cmd.SetOut(ioutil.Discard)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs(args)
+			So(cmd.Execute(), ShouldNotBeNil)
+		}
+	})
+
+	Convey("Test bench reports failed operations", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		cmd := NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"bench", "--url", server.URL, "--images", "2", "--layer-size", "1KB"})
+
+		err := cmd.Execute()
+		So(errors.Is(err, zotErrors.ErrBenchmarkFailed), ShouldBeTrue)
+		So(buff.String(), ShouldContainSubstring, "first error: ")
+
+		lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+		So(strings.Fields(lines[3])[:3], ShouldResemble, []string{"push", "2", "2"})
+		So(strings.Fields(lines[4])[:3], ShouldResemble, []string{"pull", "0", "0"})
+	})
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	Convey("Test nearest-rank percentiles", t, func() {
+		So(latencyPercentiles(nil), ShouldResemble, benchLatency{})
+
+		latencies := []time.Duration{}
+		for i := 100; i >= 1; i-- {
+			latencies = append(latencies, time.Duration(i)*time.Millisecond)
+		}
+
+		So(latencyPercentiles(latencies), ShouldResemble, benchLatency{
+			Min: time.Millisecond,
+			P50: 50 * time.Millisecond,
+			P90: 90 * time.Millisecond,
+			P99: 99 * time.Millisecond,
+			Max: 100 * time.Millisecond,
+		})
+
+		So(latencyPercentiles([]time.Duration{time.Second}).P99, ShouldEqual, time.Second)
+	})
+}
@@ -0,0 +1,254 @@
+// +build extended
+
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/spf13/cobra"
+)
+
+func NewBrowseCommand() *cobra.Command {
+	var servURL, user string
+
+	var browseCmd = &cobra.Command{
+		Use:   "browse [config-name]",
+		Short: "Interactively explore repositories, tags and CVEs",
+		Long: `Start an interactive session on a zot server to explore repositories, tags and CVEs.
+Type 'help' in the session for the list of commands.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverURL, verifyTLS, err := serverFromCommand(cmd, args)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			if serverURL == "" {
+				return zotErrors.ErrNoURLProvided
+			}
+
+			if !isURL(serverURL) {
+				cmd.SilenceUsage = true
+				return zotErrors.ErrInvalidURL
+			}
+
+			username, password := getUsernameAndPassword(user)
+
+			session := &browseSession{
+				servURL:   serverURL,
+				username:  username,
+				password:  password,
+				verifyTLS: verifyTLS,
+				out:       cmd.OutOrStdout(),
+				cveTable:  newCVETableLayout(defaultOutoutFormat, terminalWidth(cmd.OutOrStdout())),
+			}
+
+			cmd.SilenceUsage = true
+
+			return session.run(cmd.InOrStdin())
+		},
+	}
+
+	browseCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	browseCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+
+	browseCmd.ValidArgsFunction = completeConfigNames
+	browseCmd.SetUsageTemplate(browseCmd.UsageTemplate() + usageFooter)
+
+	return browseCmd
+}
+
+type browseSession struct {
+	servURL   string
+	username  string
+	password  string
+	repo      string
+	out       io.Writer
+	cveTable  *tableLayout
+	verifyTLS bool
+}
+
+func (s *browseSession) run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+
+	for {
+		fmt.Fprintf(s.out, "zot:/%s> ", s.repo)
+
+		if !scanner.Scan() {
+			fmt.Fprintln(s.out)
+
+			return scanner.Err()
+		}
+
+		err := s.exec(strings.Fields(scanner.Text()))
+		if errors.Is(err, errQuit) {
+			return nil
+		}
+
+		if err != nil {
+			fmt.Fprintf(s.out, "error: %v\n", err)
+		}
+	}
+}
+
+func (s *browseSession) exec(fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	cmd, args := fields[0], fields[1:]
+
+	switch cmd {
+	case "help", "?":
+		fmt.Fprint(s.out, browseHelp)
+	case "exit", "quit":
+		return errQuit
+	case "ls":
+		return s.list(args)
+	case "cd":
+		return s.changeRepo(args)
+	case "cve", "cves":
+		return s.listCVEs(args)
+	default:
+		fmt.Fprintf(s.out, "unknown command %q, type 'help' for the list of commands\n", cmd)
+	}
+
+	return nil
+}
+
+func (s *browseSession) list(args []string) error {
+	if len(args) > 1 {
+		return zotErrors.ErrInvalidArgs
+	}
+
+	prefix := ""
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+
+	var names []string
+
+	var err error
+
+	if s.repo == "" {
+		names, err = listRepos(s.servURL, s.username, s.password, s.verifyTLS)
+	} else {
+		names, err = listTags(s.servURL, s.username, s.password, s.repo, s.verifyTLS)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range filterByPrefix(names, prefix) {
+		fmt.Fprintln(s.out, name)
+	}
+
+	return nil
+}
+
+func (s *browseSession) changeRepo(args []string) error {
+	if len(args) != 1 {
+		return zotErrors.ErrInvalidArgs
+	}
+
+	switch args[0] {
+	case "/", "..":
+		s.repo = ""
+
+		return nil
+	}
+
+	// names are relative to the current repository unless they start with a slash
+	repo := args[0]
+	if !strings.HasPrefix(repo, "/") {
+		repo = path.Join(s.repo, repo)
+	}
+
+	repo = strings.Trim(path.Clean(repo), "/")
+
+	repos, err := listRepos(s.servURL, s.username, s.password, s.verifyTLS)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range repos {
+		if r == repo {
+			s.repo = repo
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", zotErrors.ErrRepoNotFound, repo)
+}
+
+func (s *browseSession) listCVEs(args []string) error {
+	if len(args) != 1 {
+		return zotErrors.ErrInvalidArgs
+	}
+
+	image := args[0]
+	if !strings.Contains(image, ":") && s.repo != "" {
+		image = s.repo + ":" + image
+	}
+
+	if !validateImageNameTag(image) {
+		return errInvalidImageNameAndTag
+	}
+
+	endPoint, err := combineServerAndEndpointURL(s.servURL, "/query")
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`{ CVEListForImage (image:"%s")`+
+		` { Tag CVEList { Id Title Severity Description `+
+		`PackageList {Name InstalledVersion FixedVersion}} } }`, image)
+	result := &cveResult{}
+
+	if err := makeGraphQLRequest(endPoint, query, s.username, s.password, s.verifyTLS, result); err != nil {
+		return err
+	}
+
+	if len(result.Errors) > 0 {
+		return errors.New(result.Errors[0].Message) //nolint: goerr113
+	}
+
+	if len(result.Data.CVEListForImage.CVEList) == 0 {
+		fmt.Fprintln(s.out, "no known CVEs")
+
+		return nil
+	}
+
+	result.Data.CVEListForImage.CVEList = groupCVEsBySeverity(result.Data.CVEListForImage.CVEList)
+	result.table = s.cveTable
+
+	str, err := result.stringPlainText()
+	if err != nil {
+		return err
+	}
+
+	s.cveTable.printHeader(s.out)
+	fmt.Fprint(s.out, str)
+
+	return nil
+}
+
+// browseHelp is printed by the 'help' command of a browse session.
+const browseHelp = `Commands:
+  ls [prefix]          list repositories, or tags once a repository is selected
+  cd <repo>|..         select a repository, '..' goes back to the repository list
+  cve <[repo:]tag>     list CVEs of an image
+  help                 show this help
+  exit                 end the session
+`
+
+var errQuit = errors.New("cli: quit browse session")
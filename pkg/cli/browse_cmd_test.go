@@ -0,0 +1,127 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/pkg/api"
+	"github.com/anuvu/zot/pkg/extensions"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/resty.v1"
+)
+
+func TestBrowseAndDynamicCompletion(t *testing.T) {
+	Convey("Test from real server", t, func() {
+		port := getFreePort()
+		url := getBaseURL(port)
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.Extensions = &extensions.ExtensionConfig{
+			Search: &extensions.SearchConfig{Enable: true},
+		}
+		c := api.NewController(config)
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c.Config.Storage.RootDirectory = dir
+		go func(controller *api.Controller) {
+			// this blocks
+			if err := controller.Run(); err != nil {
+				return
+			}
+		}(c)
+		// wait till ready
+		for {
+			_, err := resty.R().Get(url)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		uploadManifest(url)
+
+		configPath := makeConfigFile(fmt.Sprintf(`{"configs":[{"_name":"browsetest","url":"%s","showspinner":false},`+
+			`{"_name":"other","url":"%s","showspinner":false}]}`, url, url))
+		defer os.Remove(configPath)
+
+		Convey("Test config names completion", func() {
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetArgs([]string{"__complete", "images", "b"})
+			err := cmd.Execute()
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldStartWith, "browsetest\n")
+			So(buff.String(), ShouldNotContainSubstring, "other")
+		})
+
+		Convey("Test repo names completion", func() {
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetArgs([]string{"__complete", "images", "browsetest", "--name", "re"})
+			err := cmd.Execute()
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldStartWith, "repo7\n")
+		})
+
+		Convey("Test image names completion", func() {
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetArgs([]string{"__complete", "cve", "--url", url, "--image", "repo7:test:2"})
+			err := cmd.Execute()
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldStartWith, "repo7:test:2.0\n")
+			So(buff.String(), ShouldNotContainSubstring, "test:1.0")
+		})
+
+		Convey("Test browse session", func() {
+			cmd := NewBrowseCommand()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetIn(strings.NewReader("help\nls\ncd nope\ncd repo7\nls test:2\ncve\nbogus\ncd ..\nexit\nls\n"))
+			cmd.SetArgs([]string{"browsetest"})
+			err := cmd.Execute()
+			So(err, ShouldBeNil)
+
+			actual := buff.String()
+			So(actual, ShouldContainSubstring, "Commands:")
+			So(actual, ShouldContainSubstring, "zot:/> repo7\n")
+			So(actual, ShouldContainSubstring, "error: repository: not found: nope")
+			So(actual, ShouldContainSubstring, "zot:/repo7> test:2.0\n")
+			So(actual, ShouldContainSubstring, "error: cli: Invalid Arguments")
+			So(actual, ShouldContainSubstring, `unknown command "bogus"`)
+			So(actual, ShouldNotContainSubstring, "test:1.0")
+			// the session ends with exit, the remaining input is not consumed
+			So(actual, ShouldEndWith, "zot:/> ")
+		})
+
+		Convey("Test browse without server", func() {
+			cmd := NewBrowseCommand()
+			cmd.SetOut(ioutil.Discard)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs([]string{})
+			err := cmd.Execute()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
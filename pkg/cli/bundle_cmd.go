@@ -0,0 +1,339 @@
+// +build extended
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/bundle"
+	"github.com/dustin/go-humanize"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+)
+
+func NewBundleCommand() *cobra.Command {
+	bundleCmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Carry images across an air gap in signed bundles",
+		Long: `Pack images of a zot server in a single signed tarball, blobs shared by several images are stored
+once, and apply it to another zot server after checking its signature and the digest of each blob`,
+	}
+
+	bundleCmd.AddCommand(newBundleCreateCommand())
+	bundleCmd.AddCommand(newBundleApplyCommand())
+
+	return bundleCmd
+}
+
+func newBundleCreateCommand() *cobra.Command {
+	var servURL, user, output, keyFile, certFile string
+
+	var repos []string
+
+	createCmd := &cobra.Command{
+		Use:   "create [config-name]",
+		Short: "Create a signed bundle of images",
+		Long: `Create a signed bundle of the images of the given repositories, all the tags of a repository
+are bundled unless a tag or a digest is given, e.g. --repo app --repo db:5.7 --repo web@3f2c5e1, digests
+can be shortened as long as they're unique in the repository`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := registryClientFromCommand(cmd, args, user)
+			if err != nil {
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			signer, err := bundle.NewSigner(keyFile, certFile)
+			if err != nil {
+				return err
+			}
+
+			index, manifests, blobRepos, err := indexImages(client, repos)
+			if err != nil {
+				return err
+			}
+
+			file, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+
+			err = bundle.Create(file, index, signer, func(blob bundle.Blob) (io.ReadCloser, error) {
+				if manifest, ok := manifests[blob.Digest]; ok {
+					return ioutil.NopCloser(bytes.NewReader(manifest)), nil
+				}
+
+				return client.getBlob(blobRepos[blob.Digest], blob.Digest)
+			})
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+
+			if err != nil {
+				_ = os.Remove(output)
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "bundled %d images, %d blobs (%s), to %s\n", len(index.Images),
+				len(index.Blobs), humanize.Bytes(bundleSize(index)), output)
+
+			return nil
+		},
+	}
+
+	createCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	createCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	createCmd.Flags().StringSliceVar(&repos, "repo", nil,
+		`Repository to bundle, optionally with a tag or a digest, "repo[:tag|@digest]"`)
+	createCmd.Flags().StringVarP(&output, "output", "o", "", "Bundle file to create")
+	createCmd.Flags().StringVar(&keyFile, "key", "", "PEM encoded private key signing the bundle")
+	createCmd.Flags().StringVar(&certFile, "cert", "", "PEM encoded certificate chain of the signing key")
+
+	_ = createCmd.MarkFlagRequired("repo")
+	_ = createCmd.MarkFlagRequired("output")
+	_ = createCmd.MarkFlagRequired("key")
+	_ = createCmd.MarkFlagRequired("cert")
+
+	createCmd.ValidArgsFunction = completeConfigNames
+
+	return createCmd
+}
+
+func newBundleApplyCommand() *cobra.Command {
+	var servURL, user, input string
+
+	var trustStore []string
+
+	applyCmd := &cobra.Command{
+		Use:   "apply [config-name]",
+		Short: "Push the images of a signed bundle",
+		Long: `Push the images of a bundle to a zot server, once its signature is verified with the trusted
+certificates, blobs which don't match their digest fail the whole bundle`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := registryClientFromCommand(cmd, args, user)
+			if err != nil {
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			roots, err := bundle.NewTrustStore(trustStore...)
+			if err != nil {
+				return err
+			}
+
+			file, err := os.Open(input)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			reader, err := bundle.NewReader(file, roots)
+			if err != nil {
+				return err
+			}
+
+			if err := applyBundle(client, reader); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "applied %d images signed by %s\n", len(reader.Index.Images),
+				reader.Signer.Subject.CommonName)
+
+			return nil
+		},
+	}
+
+	applyCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	applyCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	applyCmd.Flags().StringVarP(&input, "file", "f", "", "Bundle file to apply")
+	applyCmd.Flags().StringSliceVar(&trustStore, "trust-store", nil,
+		"PEM encoded root certificates trusted to sign bundles")
+
+	_ = applyCmd.MarkFlagRequired("file")
+	_ = applyCmd.MarkFlagRequired("trust-store")
+
+	applyCmd.ValidArgsFunction = completeConfigNames
+
+	return applyCmd
+}
+
+func registryClientFromCommand(cmd *cobra.Command, args []string, user string) (*registryClient, error) {
+	serverURL, verifyTLS, err := serverFromCommand(cmd, args)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return nil, err
+	}
+
+	if serverURL == "" {
+		return nil, zotErrors.ErrNoURLProvided
+	}
+
+	username, password := getUsernameAndPassword(user)
+
+	return newRegistryClient(serverURL, username, password, verifyTLS)
+}
+
+// indexImages lists the images of the repositories, and returns their manifests and a repository
+// each blob can be read from.
+func indexImages(client *registryClient, repos []string) (*bundle.Index, map[godigest.Digest][]byte,
+	map[godigest.Digest]string, error) {
+	index := &bundle.Index{}
+	manifests := make(map[godigest.Digest][]byte)
+	blobRepos := make(map[godigest.Digest]string)
+
+	for _, spec := range repos {
+		repo, tag, byDigest := spec, "", false
+		if i := strings.IndexAny(spec, ":@"); i >= 0 {
+			repo, tag, byDigest = spec[:i], spec[i+1:], spec[i] == '@'
+		}
+
+		tags := []string{tag}
+
+		if tag == "" {
+			var err error
+
+			if tags, err = client.getTags(repo); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+
+		for _, tag := range tags {
+			content, digest, mediaType, err := client.getManifest(repo, tag)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			var manifest ispec.Manifest
+			if err := json.Unmarshal(content, &manifest); err != nil {
+				return nil, nil, nil, err
+			}
+
+			// the server resolves short digests, the image is applied by its full digest
+			if byDigest {
+				tag = digest.String()
+			}
+
+			image := bundle.Image{Repo: repo, Tag: tag, Digest: digest, MediaType: mediaType}
+			blobs := []bundle.Blob{{Digest: digest, Size: int64(len(content))}}
+
+			for _, desc := range append([]ispec.Descriptor{manifest.Config}, manifest.Layers...) {
+				image.Blobs = append(image.Blobs, desc.Digest)
+				blobs = append(blobs, bundle.Blob{Digest: desc.Digest, Size: desc.Size})
+				blobRepos[desc.Digest] = repo
+			}
+
+			manifests[digest] = content
+
+			index.AddImage(image, blobs...)
+		}
+	}
+
+	return index, manifests, blobRepos, nil
+}
+
+// applyBundle pushes the blobs of the bundle to the repositories of the images referencing them,
+// then the manifests. Each blob is staged in a temporary file, so that it is verified before being
+// pushed, even if several repositories need it.
+func applyBundle(client *registryClient, reader *bundle.Reader) error {
+	manifests := make(map[godigest.Digest][]byte)
+	blobRepos := make(map[godigest.Digest][]string)
+
+	for _, image := range reader.Index.Images {
+		manifests[image.Digest] = nil
+
+		for _, digest := range image.Blobs {
+			if !containsString(blobRepos[digest], image.Repo) {
+				blobRepos[digest] = append(blobRepos[digest], image.Repo)
+			}
+		}
+	}
+
+	for {
+		blob, content, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, ok := manifests[blob.Digest]; ok {
+			if manifests[blob.Digest], err = ioutil.ReadAll(content); err != nil {
+				return err
+			}
+		}
+
+		if repos := blobRepos[blob.Digest]; len(repos) > 0 {
+			if err := pushBundleBlob(client, blob, content, repos); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, image := range reader.Index.Images {
+		if err := client.pushManifest(image.Repo, image.Tag, image.MediaType, manifests[image.Digest]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func pushBundleBlob(client *registryClient, blob bundle.Blob, content io.Reader, repos []string) error {
+	staged, err := ioutil.TempFile("", "zot-bundle-")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	if _, err := io.Copy(staged, content); err != nil {
+		return err
+	}
+
+	for _, repo := range repos {
+		if _, err := staged.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		if err := client.pushBlob(repo, blob.Digest, staged, blob.Size); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func bundleSize(index *bundle.Index) uint64 {
+	var size uint64
+
+	for _, blob := range index.Blobs {
+		size += uint64(blob.Size)
+	}
+
+	return size
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
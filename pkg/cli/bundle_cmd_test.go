@@ -0,0 +1,225 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/pkg/api"
+	godigest "github.com/opencontainers/go-digest"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/resty.v1"
+)
+
+// startTestServer runs a zot server storing images in dir, and returns its URL and controller. configure, if
+// not nil, changes its config before it starts.
+func startTestServer(dir string, configure func(*api.Config)) (string, *api.Controller) {
+	port := getFreePort()
+	url := getBaseURL(port)
+	config := api.NewConfig()
+	config.HTTP.Port = port
+	config.Storage.RootDirectory = dir
+	config.HTTP.AllowAdminAccess = true
+
+	if configure != nil {
+		configure(config)
+	}
+
+	c := api.NewController(config)
+
+	go func(controller *api.Controller) {
+		// this blocks
+		if err := controller.Run(); err != nil {
+			return
+		}
+	}(c)
+	// wait till ready
+	for {
+		_, err := resty.R().Get(url)
+		if err == nil {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return url, c
+}
+
+// writeSigningCerts writes a CA certificate, and a code signing key and certificate it issued, and
+// returns their paths.
+func writeSigningCerts(dir, name string) (string, string, string) {
+	writePEM := func(file, blockType string, der []byte) string {
+		file = path.Join(dir, file)
+		So(ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600), ShouldBeNil)
+
+		return file
+	}
+
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	So(err, ShouldBeNil)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name + " CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	So(err, ShouldBeNil)
+
+	ca, err := x509.ParseCertificate(caDER)
+	So(err, ShouldBeNil)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	So(err, ShouldBeNil)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	So(err, ShouldBeNil)
+
+	return writePEM(name+"-ca.crt", "CERTIFICATE", caDER),
+		writePEM(name+".key", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+		writePEM(name+".crt", "CERTIFICATE", der)
+}
+
+func TestBundleCmd(t *testing.T) {
+	Convey("Test bundles from real servers", t, func() {
+		srcDir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(srcDir)
+
+		dstDir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dstDir)
+
+		certDir, err := ioutil.TempDir("", "bundle-certs")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(certDir)
+
+		srcURL, src := startTestServer(srcDir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(src)
+
+		dstURL, dst := startTestServer(dstDir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(dst)
+
+		uploadManifest(srcURL)
+
+		caFile, keyFile, certFile := writeSigningCerts(certDir, "release team")
+		bundleFile := path.Join(certDir, "repo7.tar")
+
+		cmd := NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"bundle", "create", "--url", srcURL, "--repo", "repo7", "-o", bundleFile,
+			"--key", keyFile, "--cert", certFile})
+		So(cmd.Execute(), ShouldBeNil)
+		// both tags are the same manifest, whose config and layer are the same blob
+		So(buff.String(), ShouldStartWith, "bundled 2 images, 2 blobs (")
+
+		Convey("Apply the bundle", func() {
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs([]string{"bundle", "apply", "--url", dstURL, "-f", bundleFile, "--trust-store", caFile})
+			So(cmd.Execute(), ShouldBeNil)
+			So(buff.String(), ShouldEqual, "applied 2 images signed by release team\n")
+
+			for _, tag := range []string{"test:1.0", "test:2.0"} {
+				srcResp, err := resty.R().Get(srcURL + "/v2/repo7/manifests/" + tag)
+				So(err, ShouldBeNil)
+
+				dstResp, err := resty.R().Get(dstURL + "/v2/repo7/manifests/" + tag)
+				So(err, ShouldBeNil)
+				So(dstResp.StatusCode(), ShouldEqual, 200)
+				So(dstResp.Header().Get("Docker-Content-Digest"), ShouldEqual,
+					srcResp.Header().Get("Docker-Content-Digest"))
+			}
+		})
+
+		Convey("Bundles signed by untrusted certificates aren't applied", func() {
+			otherCAFile, _, _ := writeSigningCerts(certDir, "someone else")
+
+			cmd := NewRootCmd()
+			cmd.SetOut(ioutil.Discard)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs([]string{"bundle", "apply", "--url", dstURL, "-f", bundleFile, "--trust-store", otherCAFile})
+			So(cmd.Execute(), ShouldNotBeNil)
+
+			resp, err := resty.R().Get(dstURL + "/v2/repo7/tags/list")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 404)
+		})
+
+		Convey("Images are bundled by short digest", func() {
+			resp, err := resty.R().Get(srcURL + "/v2/repo7/manifests/test:1.0")
+			So(err, ShouldBeNil)
+			digest := godigest.Digest(resp.Header().Get("Docker-Content-Digest"))
+
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs([]string{"bundle", "create", "--url", srcURL, "--repo", "repo7@" + digest.Encoded()[:12],
+				"-o", bundleFile, "--key", keyFile, "--cert", certFile})
+			So(cmd.Execute(), ShouldBeNil)
+			So(buff.String(), ShouldStartWith, "bundled 1 images, 2 blobs (")
+
+			cmd = NewRootCmd()
+			cmd.SetOut(ioutil.Discard)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs([]string{"bundle", "apply", "--url", dstURL, "-f", bundleFile, "--trust-store", caFile})
+			So(cmd.Execute(), ShouldBeNil)
+
+			resp, err = resty.R().Get(dstURL + "/v2/repo7/manifests/" + digest.String())
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+		})
+
+		Convey("Missing flags", func() {
+			cmd := NewRootCmd()
+			cmd.SetOut(ioutil.Discard)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs([]string{"bundle", "create", "--url", srcURL, "-o", bundleFile})
+			So(cmd.Execute(), ShouldNotBeNil)
+
+			cmd = NewRootCmd()
+			cmd.SetOut(ioutil.Discard)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs([]string{"bundle", "apply", "-f", bundleFile, "--trust-store", caFile})
+			So(cmd.Execute(), ShouldNotBeNil)
+		})
+	})
+}
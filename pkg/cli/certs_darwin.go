@@ -0,0 +1,21 @@
+// +build extended
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// certsDirs returns the directories holding the certificates of each host, in lookup order, those of
+// podman and then Docker Desktop.
+func certsDirs() []string {
+	dirs := []string{}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".config", "containers", "certs.d"),
+			filepath.Join(home, ".docker", "certs.d"))
+	}
+
+	return append(dirs, "/etc/containers/certs.d")
+}
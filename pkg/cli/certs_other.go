@@ -0,0 +1,20 @@
+// +build extended
+// +build !darwin,!windows
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// certsDirs returns the directories holding the certificates of each host, in lookup order.
+func certsDirs() []string {
+	dirs := []string{}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".config", "containers", "certs.d"))
+	}
+
+	return append(dirs, "/etc/containers/certs.d")
+}
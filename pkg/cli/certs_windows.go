@@ -0,0 +1,24 @@
+// +build extended
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// certsDirs returns the directories holding the certificates of each host, in lookup order, under
+// %AppData% and then %ProgramData%.
+func certsDirs() []string {
+	dirs := []string{}
+
+	if config, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(config, "containers", "certs.d"))
+	}
+
+	if programData := os.Getenv("ProgramData"); programData != "" {
+		dirs = append(dirs, filepath.Join(programData, "containers", "certs.d"))
+	}
+
+	return dirs
+}
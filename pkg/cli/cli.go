@@ -2,10 +2,52 @@
 
 package cli
 
-import "github.com/spf13/cobra"
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+)
 
 func enableCli(rootCmd *cobra.Command) {
 	rootCmd.AddCommand(NewConfigCommand())
-	rootCmd.AddCommand(NewImageCommand(NewSearchService()))
-	rootCmd.AddCommand(NewCveCommand(NewSearchService()))
+
+	for _, cmd := range []*cobra.Command{
+		NewImageCommand(NewSearchService()),
+		NewCveCommand(NewSearchService()),
+		NewBrowseCommand(),
+		NewSyncCommand(),
+		NewBundleCommand(),
+		NewDedupeCommand(),
+		NewBackupCommand(),
+		NewComplianceCommand(),
+		NewBenchCommand(),
+		NewNamespaceCommand(),
+		NewImportCommand(),
+		NewTagsCommand(),
+		NewExportCommand(),
+	} {
+		cmd.PersistentFlags().Bool(insecureHTTPFlag, false,
+			"Fall back to plain HTTP if the server URL has no scheme and the server doesn't speak HTTPS")
+		rootCmd.AddCommand(cmd)
+	}
+
+	// "zot gc plan" reviews what garbage-collect, and tag expiry, would remove from a running server
+	for _, cmd := range rootCmd.Commands() {
+		if cmd.Name() == "garbage-collect" {
+			cmd.AddCommand(newGCPlanCommand())
+		}
+	}
+}
+
+// isCommandUsageError tells whether err is one of the input errors of the search commands.
+func isCommandUsageError(err error) bool {
+	for _, usageErr := range []error{
+		ErrCannotSearch, ErrInvalidOutputFormat, errInvalidImageNameAndTag, errInvalidImageName, errInvalidAnnotation,
+	} {
+		if errors.Is(err, usageErr) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -9,55 +9,282 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/api"
+	godigest "github.com/opencontainers/go-digest"
 )
 
-var httpClientsMap = make(map[string]*http.Client) //nolint: gochecknoglobals
-var httpClientLock sync.Mutex                      //nolint: gochecknoglobals
+var httpClients = newHTTPClientPool() //nolint: gochecknoglobals
 
 const (
 	httpTimeout        = 5 * time.Minute
-	certsPath          = "/etc/containers/certs.d"
-	homeCertsDir       = ".config/containers/certs.d"
+	maxIdleConns       = 100
+	maxRetries         = 3
+	retryBackoff       = 500 * time.Millisecond
+	maxRetryBackoff    = 10 * time.Second
+	maxRetryAfter      = time.Minute
 	clientCertFilename = "client.cert"
 	clientKeyFilename  = "client.key"
 	caCertFilename     = "ca.crt"
+
+	directProxy = "direct"
+
+	contentDigestHeader = "Docker-Content-Digest"
+	warningHeader       = "Warning"
 )
 
-func createHTTPClient(verifyTLS bool, host string) *http.Client {
+// httpClientOptions tune the connections to a zot server, see the max-idle-conns, request-timeout,
+// max-retries, tls-* and proxy config variables.
+type httpClientOptions struct {
+	maxIdleConns    int
+	requestTimeout  time.Duration
+	maxRetries      int
+	retryBackoff    time.Duration
+	tlsMinVersion   string
+	tlsMaxVersion   string
+	tlsCipherSuites string
+	proxy           string
+}
+
+// tlsPolicy returns the TLS versions and cipher suites allowed by the options.
+func (o httpClientOptions) tlsPolicy() *api.TLSPolicyConfig {
+	policy := &api.TLSPolicyConfig{MinVersion: o.tlsMinVersion, MaxVersion: o.tlsMaxVersion}
+
+	for _, suite := range strings.Split(o.tlsCipherSuites, ",") {
+		if suite = strings.TrimSpace(suite); suite != "" {
+			policy.CipherSuites = append(policy.CipherSuites, suite)
+		}
+	}
+
+	return policy
+}
+
+func defaultHTTPClientOptions() httpClientOptions {
+	return httpClientOptions{
+		maxIdleConns:   maxIdleConns,
+		requestTimeout: httpTimeout,
+		maxRetries:     maxRetries,
+		retryBackoff:   retryBackoff,
+	}
+}
+
+type httpClientKey struct {
+	host      string
+	verifyTLS bool
+}
+
+// httpClientPool keeps a client per server, so that concurrent requests reuse the same connections.
+type httpClientPool struct {
+	lock    sync.Mutex
+	clients map[httpClientKey]*http.Client
+	options httpClientOptions
+}
+
+func newHTTPClientPool() *httpClientPool {
+	return &httpClientPool{
+		clients: make(map[httpClientKey]*http.Client),
+		options: defaultHTTPClientOptions(),
+	}
+}
+
+// configure sets the options of the clients, clients created with different options are dropped.
+func (p *httpClientPool) configure(options httpClientOptions) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.options == options {
+		return
+	}
+
+	for key, client := range p.clients {
+		client.CloseIdleConnections()
+		delete(p.clients, key)
+	}
+
+	p.options = options
+}
+
+// setRequestTimeout overrides the request timeout of the configured options, e.g. with a --timeout flag.
+func (p *httpClientPool) setRequestTimeout(timeout time.Duration) {
+	p.lock.Lock()
+	options := p.options
+	p.lock.Unlock()
+
+	options.requestTimeout = timeout
+	p.configure(options)
+}
+
+func (p *httpClientPool) get(host string, verifyTLS bool) (*http.Client, httpClientOptions) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := httpClientKey{host: host, verifyTLS: verifyTLS}
+
+	client, ok := p.clients[key]
+	if !ok {
+		client = createHTTPClient(verifyTLS, host, p.options)
+		p.clients[key] = client
+	}
+
+	return client, p.options
+}
+
+func createHTTPClient(verifyTLS bool, host string, options httpClientOptions) *http.Client {
+	// the default transport honors the proxy environment variables and negotiates HTTP/2
 	var tr = http.DefaultTransport.(*http.Transport).Clone()
+
+	tr.ForceAttemptHTTP2 = true
+	tr.MaxIdleConns = options.maxIdleConns
+	tr.MaxIdleConnsPerHost = options.maxIdleConns
+
 	if !verifyTLS {
 		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint: gosec
+	} else {
+		// Add a copy of the system cert pool
+		caCertPool, _ := x509.SystemCertPool()
 
-		return &http.Client{
-			Timeout:   httpTimeout,
-			Transport: tr,
+		tr.TLSClientConfig = loadPerHostCerts(caCertPool, host)
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{RootCAs: caCertPool}
 		}
 	}
 
-	// Add a copy of the system cert pool
-	caCertPool, _ := x509.SystemCertPool()
+	// the policy is checked when the options are read
+	_ = api.ApplyTLSPolicy(tr.TLSClientConfig, options.tlsPolicy())
 
-	tlsConfig := loadPerHostCerts(caCertPool, host)
-	if tlsConfig == nil {
-		tlsConfig = &tls.Config{RootCAs: caCertPool}
+	// the proxy is checked when the options are read, the default transport honors the environment otherwise
+	if options.proxy != "" {
+		tr.Proxy, _ = proxyFunc(options.proxy)
 	}
 
-	tr = &http.Transport{TLSClientConfig: tlsConfig}
+	return &http.Client{Transport: tr}
+}
+
+// configureHTTPClients applies the connection options of the [config-name] argument, if any.
+func configureHTTPClients(configPath string, args []string) error {
+	options := defaultHTTPClientOptions()
+
+	if len(args) > 0 {
+		var err error
 
-	return &http.Client{
-		Timeout:   httpTimeout,
-		Transport: tr,
+		options, err = getHTTPClientOptions(configPath, args[0])
+		if err != nil {
+			return err
+		}
 	}
+
+	httpClients.configure(options)
+
+	return nil
+}
+
+// getHTTPClientOptions reads the connection options of a CLI config, unset options keep their defaults.
+func getHTTPClientOptions(configPath, configName string) (httpClientOptions, error) {
+	var err error
+
+	options := defaultHTTPClientOptions()
+
+	options.maxIdleConns, err = parseIntConfig(configPath, configName, maxIdleConnsConfig, options.maxIdleConns)
+	if err != nil {
+		return options, err
+	}
+
+	options.maxRetries, err = parseIntConfig(configPath, configName, maxRetriesConfig, options.maxRetries)
+	if err != nil {
+		return options, err
+	}
+
+	value, err := getConfigValue(configPath, configName, requestTimeoutConfig)
+	if err != nil {
+		return options, err
+	}
+
+	if value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return options, fmt.Errorf("%w: %s %q", zotErrors.ErrInvalidConfigValue, requestTimeoutConfig, value)
+		}
+
+		options.requestTimeout = timeout
+	}
+
+	if options.tlsMinVersion, err = getConfigValue(configPath, configName, tlsMinVersionConfig); err != nil {
+		return options, err
+	}
+
+	if options.tlsMaxVersion, err = getConfigValue(configPath, configName, tlsMaxVersionConfig); err != nil {
+		return options, err
+	}
+
+	if options.tlsCipherSuites, err = getConfigValue(configPath, configName, tlsCipherSuitesConfig); err != nil {
+		return options, err
+	}
+
+	if err := api.ApplyTLSPolicy(&tls.Config{}, options.tlsPolicy()); err != nil {
+		return options, fmt.Errorf("%w: %v", zotErrors.ErrInvalidConfigValue, err)
+	}
+
+	if options.proxy, err = getConfigValue(configPath, configName, proxyConfig); err != nil {
+		return options, err
+	}
+
+	if _, err := proxyFunc(options.proxy); err != nil {
+		return options, err
+	}
+
+	return options, nil
+}
+
+// proxyFunc returns the proxy of the requests to a server: the proxy environment variables if none is
+// configured, no proxy at all if it's "direct", or else the configured one.
+func proxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case directProxy:
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Host == "" ||
+		(proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5") {
+		return nil, fmt.Errorf("%w: %s %q", zotErrors.ErrInvalidConfigValue, proxyConfig, proxy)
+	}
+
+	return http.ProxyURL(proxyURL), nil
+}
+
+// parseIntConfig returns the non-negative value of a config variable, or defaultValue if it's not set.
+func parseIntConfig(configPath, configName, configParam string, defaultValue int) (int, error) {
+	value, err := getConfigValue(configPath, configName, configParam)
+	if err != nil {
+		return defaultValue, err
+	}
+
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	val, err := strconv.Atoi(value)
+	if err != nil || val < 0 {
+		return defaultValue, fmt.Errorf("%w: %s %q", zotErrors.ErrInvalidConfigValue, configParam, value)
+	}
+
+	return val, nil
 }
 
 func makeGETRequest(url, username, password string, verifyTLS bool, resultsPtr interface{}) (http.Header, error) {
@@ -89,72 +316,215 @@ func makeGraphQLRequest(url, query, username,
 
 	_, err = doHTTPRequest(req, verifyTLS, resultsPtr)
 	if err != nil {
+		var status *zotErrors.Error
+		if errors.As(err, &status) && status.Status == http.StatusNotFound {
+			if extErr := checkExtensionEnabled(url, api.SearchExtension, username, password, verifyTLS); extErr != nil {
+				return extErr
+			}
+		}
+
 		return err
 	}
 
 	return nil
 }
 
+// checkExtensionEnabled returns ErrExtensionNotEnabled if the server of endpoint lists the extension as
+// disabled, nil if it is enabled or the server doesn't tell, e.g. because it predates the discovery route.
+func checkExtensionEnabled(endpoint, name, username, password string, verifyTLS bool) error {
+	list, ok := discoverExtensions(endpoint, username, password, verifyTLS)
+	if ok && !list.Enabled(name) {
+		return fmt.Errorf("%w: %s", zotErrors.ErrExtensionNotEnabled, name)
+	}
+
+	return nil
+}
+
+// discoverExtensions returns the extensions listed by the server of endpoint, false if it doesn't list them.
+func discoverExtensions(endpoint, username, password string, verifyTLS bool) (api.ExtensionList, bool) {
+	var list api.ExtensionList
+
+	discoverURL, err := combineServerAndEndpointURL(endpoint, api.RoutePrefix+api.ExtensionsDiscoverRoute)
+	if err != nil {
+		return list, false
+	}
+
+	if _, err := makeGETRequest(discoverURL, username, password, verifyTLS, &list); err != nil {
+		return list, false
+	}
+
+	return list, true
+}
+
+// doHTTPRequest sends a request and decodes the JSON response,
+// transient failures are retried with an exponential backoff.
 func doHTTPRequest(req *http.Request, verifyTLS bool, resultsPtr interface{}) (http.Header, error) {
-	var httpClient *http.Client
+	httpClient, options := httpClients.get(req.Host, verifyTLS)
 
-	host := req.Host
+	for attempt := 0; ; attempt++ {
+		header, err := doHTTPRequestOnce(httpClient, req, options.requestTimeout, resultsPtr)
 
-	httpClientLock.Lock()
+		var transient *transientError
+		if !errors.As(err, &transient) {
+			return header, err
+		}
 
-	if httpClientsMap[host] == nil {
-		httpClient = createHTTPClient(verifyTLS, host)
+		if attempt >= options.maxRetries {
+			return nil, transient.err
+		}
 
-		httpClientsMap[host] = httpClient
-	} else {
-		httpClient = httpClientsMap[host]
+		delay := transient.retryAfter
+		if delay == 0 {
+			delay = backoff(options.retryBackoff, attempt)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		// the body was consumed by the previous attempt
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
 	}
+}
 
-	httpClientLock.Unlock()
+func doHTTPRequestOnce(httpClient *http.Client, req *http.Request, timeout time.Duration,
+	resultsPtr interface{}) (http.Header, error) {
+	// the timeout covers reading the response body as well
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
 
-	resp, err := httpClient.Do(req)
+	resp, err := httpClient.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, err
+		// the caller gave up, there's no point in retrying
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+
+		return nil, &transientError{err: err}
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusUnauthorized {
-			return nil, zotErrors.ErrUnauthorizedAccess
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		err := zotErrors.FromResponse(resp.StatusCode, bodyBytes)
+
+		if isTransientStatus(resp.StatusCode) {
+			return nil, &transientError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
 		}
 
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil, errors.New(string(bodyBytes)) //nolint: goerr113
+	if err := verifyContentDigest(resp.Header.Get(contentDigestHeader), body); err != nil {
+		return nil, err
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(resultsPtr); err != nil {
+	if err := json.Unmarshal(body, resultsPtr); err != nil {
 		return nil, err
 	}
 
 	return resp.Header, nil
 }
 
-func loadPerHostCerts(caCertPool *x509.CertPool, host string) *tls.Config {
-	// Check if the /home/user/.config/containers/certs.d/$IP:$PORT dir exists
-	home := os.Getenv("HOME")
-	clientCertsDir := filepath.Join(home, homeCertsDir, host)
+// verifyContentDigest checks that the body matches the digest announced by the server, if any,
+// so that content altered on its way isn't trusted.
+func verifyContentDigest(header string, body []byte) error {
+	if header == "" {
+		return nil
+	}
 
-	if dirExists(clientCertsDir) {
-		tlsConfig, err := getTLSConfig(clientCertsDir, caCertPool)
+	expected, err := godigest.Parse(header)
+	if err != nil {
+		return fmt.Errorf("%w: invalid digest %q", zotErrors.ErrDigestMismatch, header)
+	}
 
-		if err == nil {
-			return tlsConfig
-		}
+	if actual := expected.Algorithm().FromBytes(body); actual != expected {
+		return fmt.Errorf("%w: expected %s, got %s", zotErrors.ErrDigestMismatch, expected, actual)
 	}
 
-	// Check if the /etc/containers/certs.d/$IP:$PORT dir exists
-	clientCertsDir = filepath.Join(certsPath, host)
-	if dirExists(clientCertsDir) {
-		tlsConfig, err := getTLSConfig(clientCertsDir, caCertPool)
+	return nil
+}
 
-		if err == nil {
+// transientError is a failure which may go away if the request is sent again.
+type transientError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+func isTransientStatus(status int) bool {
+	return status == http.StatusTooManyRequests ||
+		(status >= http.StatusInternalServerError && status != http.StatusNotImplemented)
+}
+
+// parseRetryAfter returns the delay requested by a Retry-After header, either in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	var delay time.Duration
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	}
+
+	if delay < 0 {
+		return 0
+	}
+
+	if delay > maxRetryAfter {
+		return maxRetryAfter
+	}
+
+	return delay
+}
+
+// backoff doubles the delay on every attempt, the random jitter keeps concurrent requests from retrying at once.
+func backoff(base time.Duration, attempt int) time.Duration {
+	delay := base
+
+	for i := 0; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+func loadPerHostCerts(caCertPool *x509.CertPool, host string) *tls.Config {
+	// e.g. $HOME/.config/containers/certs.d/$IP:$PORT, then /etc/containers/certs.d/$IP:$PORT on Linux
+	for _, certsDir := range certsDirs() {
+		clientCertsDir := filepath.Join(certsDir, host)
+		if !dirExists(clientCertsDir) {
+			continue
+		}
+
+		if tlsConfig, err := getTLSConfig(clientCertsDir, caCertPool); err == nil {
 			return tlsConfig
 		}
 	}
@@ -187,7 +557,7 @@ func getTLSConfig(certsPath string, caCertPool *x509.CertPool) (*tls.Config, err
 
 func dirExists(d string) bool {
 	fi, err := os.Stat(d)
-	if err != nil && os.IsNotExist(err) {
+	if err != nil {
 		return false
 	}
 
@@ -203,6 +573,85 @@ func isURL(str string) bool {
 	return err == nil && u.Scheme != "" && u.Host != ""
 } // from https://stackoverflow.com/a/55551215
 
+// isServerAddress tells whether str is a server URL without scheme, a host with an optional port and path.
+func isServerAddress(str string) bool {
+	u, err := url.Parse("https://" + str)
+	if err != nil || u.Hostname() == "" || strings.Contains(str, "://") {
+		return false
+	}
+
+	for _, label := range strings.Split(u.Hostname(), ".") {
+		if label == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resolveServerURL returns the URL of a server with its scheme. A URL without scheme is tried over HTTPS, and
+// only falls back to plain HTTP if insecure is set, a man in the middle could strip TLS otherwise. Talking to
+// a remote server over plain HTTP is warned about on w, credentials and content are sent in clear text.
+func resolveServerURL(serverURL string, insecure, verifyTLS bool, w io.Writer) (string, error) {
+	if !strings.Contains(serverURL, "://") {
+		if !isServerAddress(serverURL) {
+			return "", zotErrors.ErrInvalidURL
+		}
+
+		secureURL := "https://" + serverURL
+
+		u, _ := url.Parse(secureURL)
+		client, _ := httpClients.get(u.Host, verifyTLS)
+
+		resp, err := client.Get(strings.TrimSuffix(secureURL, "/") + "/v2/")
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		if err == nil {
+			return secureURL, nil
+		}
+
+		// the scheme of unreachable servers can't be detected, the command reports the URL as is
+		if !isSchemeMismatch(err) {
+			return serverURL, nil
+		}
+
+		if !insecure {
+			return "", fmt.Errorf("%w: %s", zotErrors.ErrInsecureHTTP, serverURL)
+		}
+
+		serverURL = "http://" + serverURL
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", zotErrors.ErrInvalidURL
+	}
+
+	if u.Scheme == "http" && !isLoopback(u.Hostname()) {
+		fmt.Fprintf(w, "WARNING: %s is reached over plain HTTP, credentials and images are sent unencrypted "+
+			"and can be read or tampered with by anyone on the network\n", u.Host)
+	}
+
+	return serverURL, nil
+}
+
+// isSchemeMismatch tells whether an HTTPS request failed because the server answered in plain HTTP.
+func isSchemeMismatch(err error) bool {
+	return strings.Contains(err.Error(), "server gave HTTP response to HTTPS client")
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
 type requestsPool struct {
 	jobs      chan *manifestJob
 	done      chan struct{}
@@ -263,9 +712,11 @@ func (p *requestsPool) doJob(job *manifestJob) {
 			return
 		}
 		p.outputCh <- stringResult{"", err}
+
+		return
 	}
 
-	digest := header.Get("docker-content-digest")
+	digest := header.Get(contentDigestHeader)
 	digest = strings.TrimPrefix(digest, "sha256:")
 
 	configDigest := job.manifestResp.Config.Digest
@@ -287,8 +738,42 @@ func (p *requestsPool) doJob(job *manifestJob) {
 		)
 	}
 
+	// the search extension isn't enabled, the images of the catalog are filtered as it would have
+	if job.config.match != nil && !job.config.match(job.manifestResp, header.Get(contentDigestHeader)) {
+		return
+	}
+
+	var lastUpdated *time.Time
+
+	// the creation time is stored in the image config, only fetch it if it's displayed
+	if job.config.imageTable.has(columnUpdated) {
+		lastUpdated = getImageCreated(job)
+	}
+
+	var build *buildInfo
+
+	if job.config.provenance {
+		build = newBuildInfo(job.manifestResp)
+	}
+
+	var summary *cveSummary
+
+	if job.config.cveSummaries != nil {
+		summary, err = job.config.cveSummaries.get(job.config, job.username, job.password, job.imageName, job.tagName)
+		if err != nil {
+			if isContextDone(p.context) {
+				return
+			}
+			p.outputCh <- stringResult{"", err}
+
+			return
+		}
+	}
+
 	image := &imageStruct{}
 	image.verbose = *job.config.verbose
+	image.tmpl = job.config.template
+	image.table = job.config.imageTable
 	image.Name = job.imageName
 	image.Tags = []tags{
 		{
@@ -297,6 +782,10 @@ func (p *requestsPool) doJob(job *manifestJob) {
 			Size:         size,
 			ConfigDigest: configDigest,
 			Layers:       layers,
+			Warning:      parseWarning(header.Get(warningHeader)),
+			CVESummary:   summary,
+			BuildInfo:    build,
+			lastUpdated:  lastUpdated,
 		},
 	}
 
@@ -317,6 +806,42 @@ func (p *requestsPool) doJob(job *manifestJob) {
 	p.outputCh <- stringResult{str, nil}
 }
 
+// parseWarning returns the text of a warning header, e.g. `299 - "deprecated: ..."`.
+func parseWarning(header string) string {
+	fields := strings.SplitN(header, " ", 3)
+	if len(fields) != 3 { //nolint:gomnd
+		return header
+	}
+
+	text, err := strconv.Unquote(fields[2])
+	if err != nil {
+		return fields[2]
+	}
+
+	return text
+}
+
+// getImageCreated returns the creation time from the image config, or nil if it's not available.
+func getImageCreated(job *manifestJob) *time.Time {
+	configURL, err := combineServerAndEndpointURL(*job.config.servURL,
+		fmt.Sprintf("/v2/%s/blobs/%s", job.imageName, job.manifestResp.Config.Digest))
+	if err != nil {
+		return nil
+	}
+
+	var imageConfig struct {
+		Created *time.Time `json:"created"`
+	}
+
+	// a missing creation time only leaves the column empty, it doesn't fail the whole listing
+	if _, err := makeGETRequest(configURL, job.username, job.password, *job.config.verifyTLS,
+		&imageConfig); err != nil {
+		return nil
+	}
+
+	return imageConfig.Created
+}
+
 func (p *requestsPool) submitJob(job *manifestJob) {
 	p.jobs <- job
 }
@@ -7,19 +7,26 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 
 	"gopkg.in/resty.v1"
 
 	"testing"
 	"time"
 
+	zotErrors "github.com/anuvu/zot/errors"
 	"github.com/anuvu/zot/pkg/api"
+	godigest "github.com/opencontainers/go-digest"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -127,11 +134,12 @@ func TestTLSWithAuth(t *testing.T) {
 			imageCmd := NewImageCommand(new(searchService))
 			imageBuff := bytes.NewBufferString("")
 			imageCmd.SetOut(imageBuff)
-			imageCmd.SetErr(ioutil.Discard)
+			imageCmd.SetErr(imageBuff)
 			imageCmd.SetArgs(args)
 			err := imageCmd.Execute()
 			So(err, ShouldNotBeNil)
-			So(imageBuff.String(), ShouldContainSubstring, "invalid URL format")
+			// the scheme is detected, the server is reached over HTTPS but has no search extension
+			So(imageBuff.String(), ShouldContainSubstring, "404 page not found")
 
 			args = []string{"imagetest"}
 			configPath = makeConfigFile(
@@ -141,11 +149,12 @@ func TestTLSWithAuth(t *testing.T) {
 			imageCmd = NewImageCommand(new(searchService))
 			imageBuff = bytes.NewBufferString("")
 			imageCmd.SetOut(imageBuff)
-			imageCmd.SetErr(ioutil.Discard)
+			imageCmd.SetErr(imageBuff)
 			imageCmd.SetArgs(args)
 			err = imageCmd.Execute()
 			So(err, ShouldNotBeNil)
 			So(imageBuff.String(), ShouldContainSubstring, "check credentials")
+			So(ExitCode(err), ShouldEqual, ExitAuthFailure)
 
 			user := fmt.Sprintf("%s:%s", username, passphrase)
 			args = []string{"imagetest", "-u", user}
@@ -156,7 +165,7 @@ func TestTLSWithAuth(t *testing.T) {
 			imageCmd = NewImageCommand(new(searchService))
 			imageBuff = bytes.NewBufferString("")
 			imageCmd.SetOut(imageBuff)
-			imageCmd.SetErr(ioutil.Discard)
+			imageCmd.SetErr(imageBuff)
 			imageCmd.SetArgs(args)
 			err = imageCmd.Execute()
 			So(err, ShouldBeNil)
@@ -226,7 +235,7 @@ func TestTLSWithoutAuth(t *testing.T) {
 			imageCmd := NewImageCommand(new(searchService))
 			imageBuff := bytes.NewBufferString("")
 			imageCmd.SetOut(imageBuff)
-			imageCmd.SetErr(ioutil.Discard)
+			imageCmd.SetErr(imageBuff)
 			imageCmd.SetArgs(args)
 			err := imageCmd.Execute()
 			So(err, ShouldBeNil)
@@ -287,7 +296,7 @@ func TestTLSWithoutAuth(t *testing.T) {
 			imageCmd := NewImageCommand(new(searchService))
 			imageBuff := bytes.NewBufferString("")
 			imageCmd.SetOut(imageBuff)
-			imageCmd.SetErr(ioutil.Discard)
+			imageCmd.SetErr(imageBuff)
 			imageCmd.SetArgs(args)
 			err := imageCmd.Execute()
 			So(err, ShouldBeNil)
@@ -350,15 +359,388 @@ func TestTLSBadCerts(t *testing.T) {
 			imageCmd := NewImageCommand(new(searchService))
 			imageBuff := bytes.NewBufferString("")
 			imageCmd.SetOut(imageBuff)
-			imageCmd.SetErr(ioutil.Discard)
+			imageCmd.SetErr(imageBuff)
 			imageCmd.SetArgs(args)
 			err := imageCmd.Execute()
 			So(err, ShouldNotBeNil)
 			So(imageBuff.String(), ShouldContainSubstring, "certificate signed by unknown authority")
+			So(ExitCode(err), ShouldEqual, ExitTLSError)
 		})
 	})
 }
 
+func TestHTTPClientPool(t *testing.T) {
+	defer httpClients.configure(defaultHTTPClientOptions())
+
+	Convey("Test clients are shared per server and TLS verification", t, func() {
+		pool := newHTTPClientPool()
+
+		client, options := pool.get("127.0.0.1:8080", false)
+		So(options, ShouldResemble, defaultHTTPClientOptions())
+
+		same, _ := pool.get("127.0.0.1:8080", false)
+		So(same, ShouldEqual, client)
+
+		verified, _ := pool.get("127.0.0.1:8080", true)
+		So(verified, ShouldNotEqual, client)
+
+		other, _ := pool.get("127.0.0.1:8081", false)
+		So(other, ShouldNotEqual, client)
+
+		for _, c := range []*http.Client{client, verified} {
+			tr, ok := c.Transport.(*http.Transport)
+			So(ok, ShouldBeTrue)
+			So(tr.Proxy, ShouldNotBeNil)
+			So(tr.ForceAttemptHTTP2, ShouldBeTrue)
+			So(tr.MaxIdleConnsPerHost, ShouldEqual, maxIdleConns)
+			So(tr.TLSClientConfig, ShouldNotBeNil)
+		}
+
+		// the same options keep the clients
+		pool.configure(defaultHTTPClientOptions())
+		same, _ = pool.get("127.0.0.1:8080", false)
+		So(same, ShouldEqual, client)
+
+		pool.configure(httpClientOptions{maxIdleConns: 5, requestTimeout: time.Second})
+		renewed, options := pool.get("127.0.0.1:8080", false)
+		So(renewed, ShouldNotEqual, client)
+		So(options.requestTimeout, ShouldEqual, time.Second)
+		So(renewed.Transport.(*http.Transport).MaxIdleConnsPerHost, ShouldEqual, 5)
+	})
+
+	Convey("Test per request timeout", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				time.Sleep(time.Second)
+			}
+
+			fmt.Fprint(w, `{"repositories":["repo"]}`)
+		}))
+		defer server.Close()
+
+		httpClients.configure(httpClientOptions{maxIdleConns: 1, requestTimeout: 200 * time.Millisecond, maxRetries: 0})
+
+		var catalog catalogResponse
+
+		_, err := makeGETRequest(server.URL+"/slow", "", "", false, &catalog)
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+
+		_, err = makeGETRequest(server.URL+"/fast", "", "", false, &catalog)
+		So(err, ShouldBeNil)
+		So(catalog.Repositories, ShouldResemble, []string{"repo"})
+	})
+
+	Convey("Test retries", t, func() {
+		var attempts int32
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempt := atomic.AddInt32(&attempts, 1)
+
+			switch r.URL.Path {
+			case "/busy":
+				if attempt == 1 {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusTooManyRequests)
+
+					return
+				}
+
+				if attempt == 2 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+
+					return
+				}
+			case "/down":
+				w.WriteHeader(http.StatusBadGateway)
+				fmt.Fprint(w, "bad gateway")
+
+				return
+			case "/missing":
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "not found")
+
+				return
+			case "/query":
+				body, _ := ioutil.ReadAll(r.Body)
+				if attempt == 1 || string(body) != "query" {
+					w.WriteHeader(http.StatusInternalServerError)
+
+					return
+				}
+			}
+
+			fmt.Fprint(w, `{"repositories":["repo"]}`)
+		}))
+		defer server.Close()
+
+		httpClients.configure(httpClientOptions{maxIdleConns: 1, requestTimeout: time.Second, maxRetries: 2,
+			retryBackoff: time.Millisecond})
+
+		var catalog catalogResponse
+
+		_, err := makeGETRequest(server.URL+"/busy", "", "", false, &catalog)
+		So(err, ShouldBeNil)
+		So(catalog.Repositories, ShouldResemble, []string{"repo"})
+		So(atomic.LoadInt32(&attempts), ShouldEqual, 3)
+
+		atomic.StoreInt32(&attempts, 0)
+		_, err = makeGETRequest(server.URL+"/down", "", "", false, &catalog)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "bad gateway")
+		So(atomic.LoadInt32(&attempts), ShouldEqual, 3)
+
+		atomic.StoreInt32(&attempts, 0)
+		_, err = makeGETRequest(server.URL+"/missing", "", "", false, &catalog)
+		So(err, ShouldNotBeNil)
+		So(atomic.LoadInt32(&attempts), ShouldEqual, 1)
+
+		// the body is sent again
+		atomic.StoreInt32(&attempts, 0)
+		err = makeGraphQLRequest(server.URL+"/query", "query", "", "", false, &catalog)
+		So(err, ShouldBeNil)
+		So(atomic.LoadInt32(&attempts), ShouldEqual, 2)
+
+		// network errors are retried too
+		server.Close()
+		_, err = makeGETRequest(server.URL+"/busy", "", "", false, &catalog)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test error responses", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/manifest":
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`)
+			case "/login":
+				w.WriteHeader(http.StatusUnauthorized)
+			default:
+				w.WriteHeader(http.StatusTeapot)
+				fmt.Fprint(w, `{"errors":[{"code":"TOO_HOT","message":"the tea is too hot"}]}`)
+			}
+		}))
+		defer server.Close()
+
+		httpClients.configure(httpClientOptions{maxIdleConns: 1, requestTimeout: time.Second})
+
+		var catalog catalogResponse
+
+		_, err := makeGETRequest(server.URL+"/manifest", "", "", false, &catalog)
+		So(errors.Is(err, zotErrors.ErrManifestNotFound), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "manifest unknown: manifest unknown")
+		So(ExitCode(err), ShouldEqual, ExitNotFound)
+
+		var zerr *zotErrors.Error
+
+		So(errors.As(err, &zerr), ShouldBeTrue)
+		So(zerr.Status, ShouldEqual, http.StatusNotFound)
+		So(zerr.Code, ShouldEqual, "MANIFEST_UNKNOWN")
+
+		_, err = makeGETRequest(server.URL+"/login", "", "", false, &catalog)
+		So(errors.Is(err, zotErrors.ErrUnauthorizedAccess), ShouldBeTrue)
+		So(ExitCode(err), ShouldEqual, ExitAuthFailure)
+
+		_, err = makeGETRequest(server.URL+"/tea", "", "", false, &catalog)
+		So(errors.Is(err, zotErrors.ErrRequestFailed), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "too hot: the tea is too hot")
+		So(ExitCode(err), ShouldEqual, ExitFailure)
+	})
+
+	Convey("Test backoff", t, func() {
+		So(parseRetryAfter(""), ShouldEqual, 0)
+		So(parseRetryAfter("2"), ShouldEqual, 2*time.Second)
+		So(parseRetryAfter("3600"), ShouldEqual, maxRetryAfter)
+		So(parseRetryAfter("soon"), ShouldEqual, 0)
+		So(parseRetryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)), ShouldEqual, 0)
+		So(parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)), ShouldEqual, maxRetryAfter)
+
+		for attempt := 0; attempt < 10; attempt++ {
+			So(backoff(time.Second, attempt), ShouldBeLessThanOrEqualTo, maxRetryBackoff)
+			So(backoff(time.Second, attempt), ShouldBeLessThanOrEqualTo, time.Second<<attempt)
+		}
+	})
+
+	Convey("Test options from config", t, func() {
+		configPath := makeConfigFile(`{"configs":[{"_name":"pooltest","url":"https://test-url.com",` +
+			`"max-idle-conns":"10","request-timeout":"30s","max-retries":"0"},` +
+			`{"_name":"defaults","url":"https://test-url.com"},` +
+			`{"_name":"badtimeout","url":"https://test-url.com","request-timeout":"soon"},` +
+			`{"_name":"badconns","url":"https://test-url.com","max-idle-conns":"-1"},` +
+			`{"_name":"tlspolicy","url":"https://test-url.com","tls-min-version":"1.2","tls-max-version":"1.2",` +
+			`"tls-cipher-suites":"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},` +
+			`{"_name":"badtlsversion","url":"https://test-url.com","tls-min-version":"1.4"},` +
+			`{"_name":"badtlssuite","url":"https://test-url.com","tls-cipher-suites":"TLS_RSA_WITH_RC4_128_SHA"}]}`)
+		defer os.Remove(configPath)
+
+		options, err := getHTTPClientOptions(configPath, "pooltest")
+		So(err, ShouldBeNil)
+		So(options, ShouldResemble, httpClientOptions{maxIdleConns: 10, requestTimeout: 30 * time.Second,
+			maxRetries: 0, retryBackoff: retryBackoff})
+
+		options, err = getHTTPClientOptions(configPath, "defaults")
+		So(err, ShouldBeNil)
+		So(options, ShouldResemble, defaultHTTPClientOptions())
+
+		// numbers which parse as booleans are stored as they are
+		cmd := NewConfigCommand()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"defaults", "max-retries", "1"})
+		So(cmd.Execute(), ShouldBeNil)
+
+		options, err = getHTTPClientOptions(configPath, "defaults")
+		So(err, ShouldBeNil)
+		So(options.maxRetries, ShouldEqual, 1)
+
+		options, err = getHTTPClientOptions(configPath, "tlspolicy")
+		So(err, ShouldBeNil)
+
+		for _, verifyTLS := range []bool{true, false} {
+			tlsConfig := createHTTPClient(verifyTLS, "test-url.com", options).Transport.(*http.Transport).TLSClientConfig
+			So(tlsConfig.MinVersion, ShouldEqual, tls.VersionTLS12)
+			So(tlsConfig.MaxVersion, ShouldEqual, tls.VersionTLS12)
+			So(tlsConfig.CipherSuites, ShouldResemble, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256})
+		}
+
+		for _, name := range []string{"badtimeout", "badconns", "badtlsversion", "badtlssuite"} {
+			_, err = getHTTPClientOptions(configPath, name)
+			So(err, ShouldNotBeNil)
+		}
+
+		cmd = NewImageCommand(new(mockService))
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(buff)
+		cmd.SetArgs([]string{"badtimeout"})
+		So(cmd.Execute(), ShouldNotBeNil)
+		So(buff.String(), ShouldContainSubstring, `invalid config value: request-timeout "soon"`)
+	})
+
+	Convey("Test per server proxy", t, func() {
+		var proxied int32
+
+		// the proxy answers in place of the server, which doesn't exist
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Host == "registry.invalid" {
+				atomic.AddInt32(&proxied, 1)
+			}
+
+			fmt.Fprint(w, `{"repositories":["repo"]}`)
+		}))
+		defer proxy.Close()
+
+		configPath := makeConfigFile(`{"configs":[{"_name":"proxied","url":"http://registry.invalid",` +
+			`"proxy":"` + proxy.URL + `"},` +
+			`{"_name":"direct","url":"http://registry.invalid","proxy":"direct"},` +
+			`{"_name":"badproxy","url":"http://registry.invalid","proxy":"proxy:3128"}]}`)
+		defer os.Remove(configPath)
+
+		So(configureHTTPClients(configPath, []string{"proxied"}), ShouldBeNil)
+
+		var catalog catalogResponse
+
+		_, err := makeGETRequest("http://registry.invalid/v2/_catalog", "", "", false, &catalog)
+		So(err, ShouldBeNil)
+		So(catalog.Repositories, ShouldResemble, []string{"repo"})
+		So(atomic.LoadInt32(&proxied), ShouldEqual, 1)
+
+		options, err := getHTTPClientOptions(configPath, "direct")
+		So(err, ShouldBeNil)
+		So(createHTTPClient(false, "registry.invalid", options).Transport.(*http.Transport).Proxy, ShouldBeNil)
+
+		_, err = getHTTPClientOptions(configPath, "badproxy")
+		So(errors.Is(err, zotErrors.ErrInvalidConfigValue), ShouldBeTrue)
+	})
+}
+
+func TestContentDigest(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"config":{"digest":"sha256:1234"},"layers":[]}`)
+	tampered := []byte(`{"schemaVersion":2,"config":{"digest":"sha256:5678"},"layers":[]}`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/_catalog":
+			fmt.Fprint(w, `{"repositories":["repo"]}`)
+		case "/v2/repo/tags/list":
+			fmt.Fprint(w, `{"name":"repo","tags":["good","tampered","invalid","none"]}`)
+		case "/v2/repo/manifests/good":
+			w.Header().Set("Docker-Content-Digest", godigest.FromBytes(manifest).String())
+			_, _ = w.Write(manifest)
+		case "/v2/repo/manifests/tampered":
+			w.Header().Set("Docker-Content-Digest", godigest.FromBytes(manifest).String())
+			_, _ = w.Write(tampered)
+		case "/v2/repo/manifests/invalid":
+			w.Header().Set("Docker-Content-Digest", "sha256:invalid")
+			_, _ = w.Write(manifest)
+		case "/v2/repo/manifests/none":
+			_, _ = w.Write(manifest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	Convey("Test responses are verified against their digest", t, func() {
+		var resp manifestResponse
+
+		header, err := makeGETRequest(server.URL+"/v2/repo/manifests/good", "", "", false, &resp)
+		So(err, ShouldBeNil)
+		So(header.Get("Docker-Content-Digest"), ShouldEqual, godigest.FromBytes(manifest).String())
+		So(resp.Config.Digest, ShouldEqual, "sha256:1234")
+
+		_, err = makeGETRequest(server.URL+"/v2/repo/manifests/tampered", "", "", false, &resp)
+		So(errors.Is(err, zotErrors.ErrDigestMismatch), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, godigest.FromBytes(tampered).String())
+
+		_, err = makeGETRequest(server.URL+"/v2/repo/manifests/invalid", "", "", false, &resp)
+		So(errors.Is(err, zotErrors.ErrDigestMismatch), ShouldBeTrue)
+
+		// nothing to verify against
+		_, err = makeGETRequest(server.URL+"/v2/repo/manifests/none", "", "", false, &resp)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Test image listing fails on a tampered manifest", t, func() {
+		cmd := NewImageCommand(new(searchService))
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(buff)
+		cmd.SetArgs([]string{"--url", server.URL})
+		err := cmd.Execute()
+		So(errors.Is(err, zotErrors.ErrDigestMismatch), ShouldBeTrue)
+	})
+}
+
+func TestExtensionNotEnabled(t *testing.T) {
+	Convey("Test GraphQL requests tell when search isn't enabled", t, func() {
+		discovered := int32(0)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == api.RoutePrefix+api.ExtensionsDiscoverRoute && atomic.AddInt32(&discovered, 1) == 1 {
+				fmt.Fprintf(w, `{"extensions":[{"name":%q,"enabled":false}]}`, api.SearchExtension)
+
+				return
+			}
+
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		var result tagHistoryResult
+
+		err := makeGraphQLRequest(server.URL+"/query", "{ImageList(repo:\"\"){RepoName}}", "", "", false, &result)
+		So(errors.Is(err, zotErrors.ErrExtensionNotEnabled), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, api.SearchExtension)
+
+		// servers without the discovery route keep reporting the missing route
+		err = makeGraphQLRequest(server.URL+"/query", "{ImageList(repo:\"\"){RepoName}}", "", "", false, &result)
+		So(err, ShouldNotBeNil)
+		So(errors.Is(err, zotErrors.ErrExtensionNotEnabled), ShouldBeFalse)
+		So(atomic.LoadInt32(&discovered), ShouldEqual, 2)
+	})
+}
+
 func copyFiles(sourceDir string, destDir string) error {
 	sourceMeta, err := os.Stat(sourceDir)
 	if err != nil {
@@ -403,3 +785,109 @@ func copyFiles(sourceDir string, destDir string) error {
 
 	return nil
 }
+
+func TestCVESummaries(t *testing.T) {
+	Convey("Test CVE summaries are fetched once per repository", t, func() {
+		var queries int32
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&queries, 1)
+			fmt.Fprint(w, `{"data":{"ImageList":[{"Tag":"scanned","CVESummary":{"Count":3,"MaxSeverity":"HIGH",`+
+				`"High":1,"Low":2}},{"Tag":"unscanned","CVESummary":null}]}}`)
+		}))
+		defer server.Close()
+
+		url := server.URL
+		verifyTLS := false
+		config := searchConfig{servURL: &url, verifyTLS: &verifyTLS}
+		summaries := newCVESummaries()
+
+		summary, err := summaries.get(config, "", "", "repo", "scanned")
+		So(err, ShouldBeNil)
+		So(summary, ShouldResemble, &cveSummary{Count: 3, MaxSeverity: "HIGH", High: 1, Low: 2})
+		So(summary.compact(), ShouldEqual, "0/1/0/2")
+
+		summary, err = summaries.get(config, "", "", "repo", "unscanned")
+		So(err, ShouldBeNil)
+		So(summary, ShouldBeNil)
+		So(summary.compact(), ShouldEqual, "-")
+		So(atomic.LoadInt32(&queries), ShouldEqual, 1)
+	})
+}
+
+func TestInsecureHTTP(t *testing.T) {
+	Convey("Test the scheme detection of server URLs", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		url, c := startTestServer(dir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		host := strings.TrimPrefix(url, "http://")
+
+		Convey("Plain HTTP is refused without opt-in", func() {
+			configPath := makeConfigFile("")
+			defer os.Remove(configPath)
+
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs([]string{"images", "--url", host})
+			err := cmd.Execute()
+			So(errors.Is(err, zotErrors.ErrInsecureHTTP), ShouldBeTrue)
+		})
+
+		Convey("Plain HTTP is allowed by --insecure-http", func() {
+			configPath := makeConfigFile("")
+			defer os.Remove(configPath)
+
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs([]string{"images", "--url", host, "--insecure-http"})
+			So(cmd.Execute(), ShouldBeNil)
+		})
+
+		Convey("Plain HTTP is allowed by the insecure config variable", func() {
+			configPath := makeConfigFile("")
+			defer os.Remove(configPath)
+
+			cmd := NewConfigCommand()
+			cmd.SetOut(ioutil.Discard)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs([]string{"add", "lab", host, "--insecure"})
+			So(cmd.Execute(), ShouldBeNil)
+
+			insecure, err := getConfigValue(configPath, "lab", insecureConfig)
+			So(err, ShouldBeNil)
+			So(insecure, ShouldEqual, "true")
+
+			cmd = NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs([]string{"images", "lab"})
+			So(cmd.Execute(), ShouldBeNil)
+		})
+
+		Convey("Remote servers reached over plain HTTP are warned about", func() {
+			buff := bytes.NewBufferString("")
+			servURL, err := resolveServerURL("http://zot.example.com", false, true, buff)
+			So(err, ShouldBeNil)
+			So(servURL, ShouldEqual, "http://zot.example.com")
+			So(buff.String(), ShouldContainSubstring, "WARNING: zot.example.com is reached over plain HTTP")
+
+			buff.Reset()
+			servURL, err = resolveServerURL(url, false, true, buff)
+			So(err, ShouldBeNil)
+			So(servURL, ShouldEqual, url)
+			So(buff.String(), ShouldBeEmpty)
+		})
+	})
+}
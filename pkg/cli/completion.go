@@ -0,0 +1,35 @@
+package cli
+
+import (
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/spf13/cobra"
+)
+
+func NewCompletionCommand() *cobra.Command {
+	var completionCmd = &cobra.Command{
+		Use:   "completion <bash|zsh|fish>",
+		Short: "Generate shell completion scripts",
+		Long: `Generate shell completion scripts for zot, including completion of configured
+zot server names and of repository names queried from the server.`,
+		Example: `  source <(zot completion bash)
+  zot completion zsh > "${fpath[1]}/_zot"
+  zot completion fish > ~/.config/fish/completions/zot.fish`,
+		Args:                  cobra.ExactValidArgs(1),
+		ValidArgs:             []string{"bash", "zsh", "fish"},
+		DisableFlagsInUseLine: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch args[0] {
+			case "bash":
+				return cmd.Root().GenBashCompletion(cmd.OutOrStdout())
+			case "zsh":
+				return cmd.Root().GenZshCompletion(cmd.OutOrStdout())
+			case "fish":
+				return cmd.Root().GenFishCompletion(cmd.OutOrStdout(), true)
+			}
+
+			return zotErrors.ErrInvalidArgs
+		},
+	}
+
+	return completionCmd
+}
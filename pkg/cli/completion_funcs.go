@@ -0,0 +1,202 @@
+// +build extended
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// serverFromCommand resolves the server to talk to the same way the search commands do:
+// --url takes precedence over the [config-name] argument.
+func serverFromCommand(cmd *cobra.Command, args []string) (servURL string, verifyTLS bool, err error) {
+	if flag := cmd.Flags().Lookup("url"); flag != nil {
+		servURL = flag.Value.String()
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false, err
+	}
+
+	configPath := path.Join(home + "/.zot")
+
+	if err := configureHTTPClients(configPath, args); err != nil {
+		return "", false, err
+	}
+
+	if len(args) == 0 {
+		servURL, err = resolveCommandURL(cmd, configPath, args, servURL, false)
+
+		return servURL, false, err
+	}
+
+	if servURL == "" {
+		servURL, err = getConfigValue(configPath, args[0], "url")
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	verifyTLS, err = parseBooleanConfig(configPath, args[0], verifyTLSConfig)
+	if err != nil {
+		return "", false, err
+	}
+
+	servURL, err = resolveCommandURL(cmd, configPath, args, servURL, verifyTLS)
+
+	return servURL, verifyTLS, err
+}
+
+// resolveCommandURL adds its scheme to the server URL of a command, plain HTTP being allowed by the
+// --insecure-http flag or the insecure variable of the config.
+func resolveCommandURL(cmd *cobra.Command, configPath string, args []string, servURL string,
+	verifyTLS bool) (string, error) {
+	if servURL == "" {
+		return "", nil
+	}
+
+	var insecure bool
+
+	if flag := cmd.Flag(insecureHTTPFlag); flag != nil {
+		insecure, _ = strconv.ParseBool(flag.Value.String())
+	}
+
+	if !insecure && len(args) > 0 {
+		value, err := getConfigValue(configPath, args[0], insecureConfig)
+		if err != nil {
+			return "", err
+		}
+
+		if value != "" {
+			if insecure, err = strconv.ParseBool(value); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return resolveServerURL(servURL, insecure, verifyTLS, cmd.ErrOrStderr())
+}
+
+func credentialsFromCommand(cmd *cobra.Command) (string, string) {
+	if flag := cmd.Flags().Lookup("user"); flag != nil {
+		return getUsernameAndPassword(flag.Value.String())
+	}
+
+	return "", ""
+}
+
+func filterByPrefix(candidates []string, prefix string) []string {
+	res := []string{}
+
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			res = append(res, candidate)
+		}
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// completeConfigNames completes the [config-name] argument with the configured zot servers.
+func completeConfigNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	names, err := getConfigNameList(path.Join(home + "/.zot"))
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	return filterByPrefix(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+func listRepos(servURL, username, password string, verifyTLS bool) ([]string, error) {
+	catalogEndPoint, err := combineServerAndEndpointURL(servURL, "/v2/_catalog")
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &catalogResponse{}
+
+	if _, err := makeGETRequest(catalogEndPoint, username, password, verifyTLS, catalog); err != nil {
+		return nil, err
+	}
+
+	return catalog.Repositories, nil
+}
+
+func listTags(servURL, username, password, repo string, verifyTLS bool) ([]string, error) {
+	tagListEndpoint, err := combineServerAndEndpointURL(servURL, fmt.Sprintf("/v2/%s/tags/list", repo))
+	if err != nil {
+		return nil, err
+	}
+
+	tagsList := &tagListResp{}
+
+	if _, err := makeGETRequest(tagListEndpoint, username, password, verifyTLS, tagsList); err != nil {
+		return nil, err
+	}
+
+	return tagsList.Tags, nil
+}
+
+// completeRepoNames completes repository names by querying the catalog of the selected server.
+func completeRepoNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	servURL, verifyTLS, err := serverFromCommand(cmd, args)
+	if err != nil || servURL == "" {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	username, password := credentialsFromCommand(cmd)
+
+	repos, err := listRepos(servURL, username, password, verifyTLS)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	return filterByPrefix(repos, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeImageNames completes IMAGENAME[:TAG], tags are only queried once the repository is known.
+func completeImageNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	repo := strings.SplitN(toComplete, ":", 2)[0]
+	if repo == toComplete {
+		repos, directive := completeRepoNames(cmd, args, toComplete)
+
+		return repos, directive | cobra.ShellCompDirectiveNoSpace
+	}
+
+	servURL, verifyTLS, err := serverFromCommand(cmd, args)
+	if err != nil || servURL == "" {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	username, password := credentialsFromCommand(cmd)
+
+	tags, err := listTags(servURL, username, password, repo, verifyTLS)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	images := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		images = append(images, repo+":"+tag)
+	}
+
+	return filterByPrefix(images, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
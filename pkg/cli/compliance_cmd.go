@@ -0,0 +1,179 @@
+// +build extended
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/compliance/suite"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+func NewComplianceCommand() *cobra.Command {
+	complianceCmd := &cobra.Command{
+		Use:   "compliance",
+		Short: "Check a registry conforms to the distribution spec",
+		Long:  `Check a registry, zot or any other, conforms to the OCI distribution spec`,
+	}
+
+	complianceCmd.AddCommand(newComplianceRunCommand())
+
+	return complianceCmd
+}
+
+func newComplianceRunCommand() *cobra.Command {
+	var servURL, user, namespace, outputFormat string
+
+	runCmd := &cobra.Command{
+		Use:   "run [config-name]",
+		Short: "Run the conformance checks against a registry",
+		Long: `Push, pull, list and delete images in a repository of a registry and check its responses, including
+the errors, follow the OCI distribution spec. The checks only touch repositories under the namespace, which
+defaults to zot-compliance/<random>, and the credentials must allow pushing and deleting images there.
+Optional features the registry doesn't support, e.g. cross repository mounts, are reported as skipped.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverURL, verifyTLS, err := serverFromCommand(cmd, args)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			if serverURL == "" {
+				return zotErrors.ErrNoURLProvided
+			}
+
+			if !isURL(serverURL) {
+				return zotErrors.ErrInvalidURL
+			}
+
+			switch strings.ToLower(outputFormat) {
+			case "", defaultOutoutFormat, jsonOutputFormat, ymlOutputFormat, yamlOutputFormat:
+			default:
+				return ErrInvalidOutputFormat
+			}
+
+			cmd.SilenceUsage = true
+
+			baseURL, err := url.Parse(serverURL)
+			if err != nil {
+				return zotErrors.ErrInvalidURL
+			}
+
+			client, _ := httpClients.get(baseURL.Host, verifyTLS)
+			username, password := getUsernameAndPassword(user)
+
+			config := suite.Config{
+				URL:       serverURL,
+				Username:  username,
+				Password:  password,
+				Namespace: namespace,
+				Client:    client,
+			}
+
+			var report func(suite.Result)
+
+			// the text output is printed as the checks run, as they take a while on remote registries
+			if outputFormat == "" || strings.ToLower(outputFormat) == defaultOutoutFormat {
+				report = func(result suite.Result) {
+					printComplianceResult(cmd.OutOrStdout(), result)
+				}
+			}
+
+			results := suite.Run(config, report)
+
+			if report == nil {
+				if err := printComplianceResults(cmd.OutOrStdout(), results, outputFormat); err != nil {
+					return err
+				}
+			}
+
+			return complianceSummary(cmd.OutOrStdout(), results, report != nil)
+		},
+	}
+
+	runCmd.Flags().StringVar(&servURL, "url", "", "Specify registry URL if config-name is not mentioned")
+	runCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of the registry in "username:password" format`)
+	runCmd.Flags().StringVar(&namespace, "namespace", "", "Repository namespace the checks push images to")
+	runCmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Specify output format [text/json/yaml]")
+
+	runCmd.ValidArgsFunction = completeConfigNames
+
+	return runCmd
+}
+
+func printComplianceResult(writer io.Writer, result suite.Result) {
+	status := "PASS"
+
+	switch {
+	case result.Skipped != "":
+		status = "SKIP"
+	case !result.Passed:
+		status = "FAIL"
+	}
+
+	fmt.Fprintf(writer, "%-4s  %-10s  %-30s  %s\n", status, result.Category, result.Name,
+		result.Duration.Round(time.Millisecond))
+
+	switch {
+	case result.Skipped != "":
+		fmt.Fprintf(writer, "      %s\n", result.Skipped)
+	case !result.Passed:
+		fmt.Fprintf(writer, "      %s\n", result.Error)
+	}
+}
+
+func printComplianceResults(writer io.Writer, results []suite.Result, outputFormat string) error {
+	var (
+		body []byte
+		err  error
+	)
+
+	if strings.ToLower(outputFormat) == jsonOutputFormat {
+		body, err = json.MarshalIndent(results, "", "  ")
+		body = append(body, '\n')
+	} else {
+		body, err = yaml.Marshal(results)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(writer, string(body))
+
+	return nil
+}
+
+// complianceSummary prints the counts of the results if asked to, and fails if any check failed.
+func complianceSummary(writer io.Writer, results []suite.Result, print bool) error {
+	passed, skipped := 0, 0
+
+	for _, result := range results {
+		switch {
+		case result.Skipped != "":
+			skipped++
+		case result.Passed:
+			passed++
+		}
+	}
+
+	failed := len(results) - passed - skipped
+
+	if print {
+		fmt.Fprintf(writer, "\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%w: %d of %d checks failed", zotErrors.ErrComplianceCheckFailed, failed, len(results))
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestComplianceCmd(t *testing.T) {
+	Convey("Test compliance run against zot", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		url, c := startTestServer(dir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		cmd := NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"compliance", "run", "--url", url})
+		So(cmd.Execute(), ShouldBeNil)
+
+		lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+		So(strings.Fields(lines[0])[:2], ShouldResemble, []string{"PASS", "push"})
+		So(lines[len(lines)-1], ShouldContainSubstring, " passed, 0 failed, ")
+
+		cmd = NewRootCmd()
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"compliance", "run", "--url", url, "--namespace", "compliance", "-o", "json"})
+		So(cmd.Execute(), ShouldBeNil)
+		So(buff.String(), ShouldContainSubstring, `"name": "base API"`)
+
+		cmd = NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"compliance", "run"})
+		So(cmd.Execute(), ShouldEqual, zotErrors.ErrNoURLProvided)
+
+		cmd = NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"compliance", "run", "--url", url, "-o", "random"})
+		So(cmd.Execute(), ShouldEqual, ErrInvalidOutputFormat)
+	})
+
+	Convey("Test compliance run against a broken registry", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		cmd := NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"compliance", "run", "--url", server.URL})
+
+		err := cmd.Execute()
+		So(errors.Is(err, zotErrors.ErrComplianceCheckFailed), ShouldBeTrue)
+		So(buff.String(), ShouldContainSubstring, "FAIL  push        base API")
+		So(buff.String(), ShouldContainSubstring, "returned 500, expected 200")
+	})
+}
@@ -91,13 +91,17 @@ func NewConfigCommand() *cobra.Command {
 
 	configCmd.Flags().BoolVarP(&isListing, "list", "l", false, "List configurations")
 	configCmd.Flags().BoolVar(&isReset, "reset", false, "Reset a variable value")
+	configCmd.ValidArgsFunction = completeConfigNames
 	configCmd.SetUsageTemplate(configCmd.UsageTemplate() + supportedOptions)
 	configCmd.AddCommand(NewConfigAddCommand())
+	configCmd.AddCommand(NewConfigInitCommand())
 
 	return configCmd
 }
 
 func NewConfigAddCommand() *cobra.Command {
+	var insecure bool
+
 	var configAddCmd = &cobra.Command{
 		Use:   "add <config-name> <url>",
 		Short: "Add configuration for a zot URL",
@@ -111,7 +115,7 @@ func NewConfigAddCommand() *cobra.Command {
 
 			configPath := path.Join(home + "/.zot")
 			// zot config add <config-name> <url>
-			err = addConfig(configPath, args[0], args[1])
+			err = addConfig(configPath, args[0], args[1], insecure)
 			if err != nil {
 				return err
 			}
@@ -120,6 +124,9 @@ func NewConfigAddCommand() *cobra.Command {
 		},
 	}
 
+	configAddCmd.Flags().BoolVar(&insecure, "insecure", false,
+		"Allow plain HTTP if the url has no scheme and the server doesn't speak HTTPS")
+
 	return configAddCmd
 }
 
@@ -194,13 +201,36 @@ func getConfigNames(configPath string) (string, error) {
 	return builder.String(), nil
 }
 
-func addConfig(configPath, configName, url string) error {
+func getConfigNameList(configPath string) ([]string, error) {
+	configs, err := getConfigMapFromFile(configPath)
+	if err != nil {
+		if errors.Is(err, ErrEmptyJSON) {
+			return []string{}, nil
+		}
+
+		return nil, err
+	}
+
+	names := make([]string, 0, len(configs))
+
+	for _, val := range configs {
+		configMap := val.(map[string]interface{})
+		if name, ok := configMap[nameKey].(string); ok {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+func addConfig(configPath, configName, url string, insecure bool) error {
 	configs, err := getConfigMapFromFile(configPath)
 	if err != nil && !errors.Is(err, ErrEmptyJSON) {
 		return err
 	}
 
-	if !isURL(url) {
+	// the scheme is detected when the config is used
+	if !isURL(url) && !isServerAddress(url) {
 		return zotErrors.ErrInvalidURL
 	}
 
@@ -211,6 +241,11 @@ func addConfig(configPath, configName, url string) error {
 	configMap := make(map[string]interface{})
 	configMap["url"] = url
 	configMap[nameKey] = configName
+
+	if insecure {
+		configMap[insecureConfig] = true
+	}
+
 	addDefaultConfigs(configMap)
 	configs = append(configs, configMap)
 
@@ -313,8 +348,9 @@ func setConfigValue(configPath, configName, key, value string) error {
 
 		name := configMap[nameKey]
 		if name == configName {
+			// only literal booleans are stored as such, numbers like 0 and 1 are kept for integer variables
 			boolVal, err := strconv.ParseBool(value)
-			if err == nil {
+			if err == nil && (strings.EqualFold(value, "true") || strings.EqualFold(value, "false")) {
 				configMap[key] = boolVal
 			} else {
 				configMap[key] = value
@@ -378,6 +414,7 @@ func configNameExists(configs []interface{}, configName string) bool {
 
 const (
 	examples = `  zot config add main https://zot-foo.com:8080
+  zot config add lab zot-lab:8080 --insecure
   zot config main url
   zot config main --list
   zot config --list`
@@ -386,17 +423,36 @@ const (
 Useful variables:
   url		zot server URL
   showspinner	show spinner while loading data [true/false]
-  verify-tls	verify TLS Certificate verification of the server [default: true]`
+  verify-tls	verify TLS Certificate verification of the server [default: true]
+  insecure	fall back to plain HTTP if the url has no scheme and the server doesn't speak HTTPS [default: false]
+  max-idle-conns	maximum number of idle connections kept open to the server [default: 100]
+  request-timeout	timeout of a single request to the server, e.g. 30s [default: 5m]
+  max-retries	number of times a request failing with a network, 429 or 5xx error is retried [default: 3]
+  tls-min-version	minimum TLS version, from 1.0 to 1.3
+  tls-max-version	maximum TLS version, from 1.0 to 1.3
+  tls-cipher-suites	comma separated list of allowed TLS 1.2 cipher suites, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+  proxy		proxy URL overriding HTTP(S)_PROXY and NO_PROXY for the server, or "direct" to bypass them`
 
 	nameKey = "_name"
 
+	insecureHTTPFlag = "insecure-http"
+
 	noArgs    = 0
 	oneArg    = 1
 	twoArgs   = 2
 	threeArgs = 3
 
-	showspinnerConfig = "showspinner"
-	verifyTLSConfig   = "verify-tls"
+	showspinnerConfig    = "showspinner"
+	verifyTLSConfig      = "verify-tls"
+	insecureConfig       = "insecure"
+	maxIdleConnsConfig   = "max-idle-conns"
+	requestTimeoutConfig = "request-timeout"
+	maxRetriesConfig     = "max-retries"
+
+	tlsMinVersionConfig   = "tls-min-version"
+	tlsMaxVersionConfig   = "tls-max-version"
+	tlsCipherSuitesConfig = "tls-cipher-suites"
+	proxyConfig           = "proxy"
 )
 
 var (
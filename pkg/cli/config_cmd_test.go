@@ -10,8 +10,11 @@ import (
 	"testing"
 
 	zotErrors "github.com/anuvu/zot/errors"
-
+	"github.com/anuvu/zot/pkg/api"
+	zlog "github.com/anuvu/zot/pkg/log"
+	"github.com/mitchellh/mapstructure"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
 )
 
 func TestConfigCmdBasics(t *testing.T) {
@@ -234,7 +237,7 @@ func TestConfigCmdMain(t *testing.T) {
 			cmd := NewConfigCommand()
 			buff := bytes.NewBufferString("")
 			cmd.SetOut(buff)
-			cmd.SetErr(ioutil.Discard)
+			cmd.SetErr(buff)
 			cmd.SetArgs(args)
 			err := cmd.Execute()
 			So(err, ShouldNotBeNil)
@@ -294,7 +297,7 @@ func TestConfigCmdMain(t *testing.T) {
 		cmd := NewConfigCommand()
 		buff := bytes.NewBufferString("")
 		cmd.SetOut(buff)
-		cmd.SetErr(ioutil.Discard)
+		cmd.SetErr(buff)
 		cmd.SetArgs(args)
 		err := cmd.Execute()
 		So(err, ShouldNotBeNil)
@@ -308,10 +311,74 @@ func TestConfigCmdMain(t *testing.T) {
 		cmd := NewConfigCommand()
 		buff := bytes.NewBufferString("")
 		cmd.SetOut(buff)
-		cmd.SetErr(ioutil.Discard)
+		cmd.SetErr(buff)
 		cmd.SetArgs(args)
 		err := cmd.Execute()
 		So(err, ShouldNotBeNil)
 		So(buff.String(), ShouldContainSubstring, "cli config name already added")
 	})
 }
+
+func TestConfigInit(t *testing.T) {
+	Convey("Test generated server configs", t, func() {
+		for _, args := range [][]string{
+			{"init"},
+			{"init", "--with-auth", "htpasswd", "--with-tls"},
+			{"init", "--with-auth", "ldap", "--extended"},
+			{"init", "--with-auth", "bearer", "--with-sync"},
+			{"init", "--with-auth", "webhook", "--extended", "--with-sync", "--port", "5000"},
+		} {
+			cmd := NewConfigCommand()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs(args)
+			err := cmd.Execute()
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldContainSubstring, "# zot configuration")
+
+			// the config is loaded as zot serve does
+			v := viper.New()
+			v.SetConfigType("yaml")
+			So(v.ReadConfig(buff), ShouldBeNil)
+
+			config := api.NewConfig()
+			md := &mapstructure.Metadata{}
+			So(v.Unmarshal(&config, metadataConfig(md)), ShouldBeNil)
+			So(md.Unused, ShouldBeEmpty)
+			So(config.Validate(zlog.NewLogger("debug", "")), ShouldBeNil)
+			So(config.Storage.RootDirectory, ShouldEqual, "/var/lib/zot")
+		}
+	})
+
+	Convey("Test selected features", t, func() {
+		args := []string{"init", "--extended", "--with-sync", "--with-auth", "ldap", "--with-tls", "--root-dir", "/tmp/zot"}
+		cmd := NewConfigCommand()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		So(err, ShouldBeNil)
+		So(buff.String(), ShouldContainSubstring, "rootDirectory: /tmp/zot")
+		So(buff.String(), ShouldContainSubstring, "tls:")
+		So(buff.String(), ShouldContainSubstring, "ldap:")
+		So(buff.String(), ShouldContainSubstring, "search:")
+		So(buff.String(), ShouldContainSubstring, "sync:")
+		So(buff.String(), ShouldContainSubstring, "cvePolicy:")
+		So(buff.String(), ShouldNotContainSubstring, "htpasswd:")
+	})
+
+	Convey("Test unknown auth", t, func() {
+		args := []string{"init", "--with-auth", "kerberos"}
+		cmd := NewConfigCommand()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(buff)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		So(err, ShouldNotBeNil)
+		So(ExitCode(err), ShouldEqual, ExitInvalidFlags)
+		So(buff.String(), ShouldContainSubstring, "unknown auth")
+	})
+}
@@ -0,0 +1,182 @@
+// +build extended
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"text/template"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	dspec "github.com/opencontainers/distribution-spec"
+	"github.com/spf13/cobra"
+)
+
+// serverConfigOptions are the features of a generated server config.
+type serverConfigOptions struct {
+	Version  string
+	RootDir  string
+	Address  string
+	Port     string
+	Auth     string
+	TLS      bool
+	Extended bool
+	Sync     bool
+}
+
+func NewConfigInitCommand() *cobra.Command {
+	options := serverConfigOptions{Version: dspec.Version}
+
+	var configInitCmd = &cobra.Command{
+		Use:   "init",
+		Short: "Generate a zot server configuration",
+		Long: `Generate a commented starter configuration of a zot server for the selected features, in YAML,
+which zot serve reads from files with a .yaml extension`,
+		Example: `  zot config init > /etc/zot/config.yaml
+  zot config init --extended --with-sync --with-auth ldap --with-tls > /etc/zot/config.yaml`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return writeServerConfig(cmd.OutOrStdout(), options)
+		},
+	}
+
+	configInitCmd.Flags().StringVar(&options.RootDir, "root-dir", "/var/lib/zot", "Storage root directory")
+	configInitCmd.Flags().StringVar(&options.Address, "address", "127.0.0.1", "Address to listen on")
+	configInitCmd.Flags().StringVar(&options.Port, "port", "8080", "Port to listen on")
+	configInitCmd.Flags().StringVar(&options.Auth, "with-auth", "", "Authenticate users [htpasswd/ldap/bearer/webhook]")
+	configInitCmd.Flags().BoolVar(&options.TLS, "with-tls", false, "Serve over TLS")
+	configInitCmd.Flags().BoolVar(&options.Extended, "extended", false,
+		"Enable the extensions of the full zot binary: search, CVE scanning, UI and metrics")
+	configInitCmd.Flags().BoolVar(&options.Sync, "with-sync", false, "Replicate pushed images to a downstream registry")
+
+	return configInitCmd
+}
+
+func writeServerConfig(w io.Writer, options serverConfigOptions) error {
+	switch options.Auth {
+	case "", "htpasswd", "ldap", "bearer", "webhook":
+	default:
+		return fmt.Errorf("%w: unknown auth %q, expected htpasswd, ldap, bearer or webhook",
+			zotErrors.ErrInvalidArgs, options.Auth)
+	}
+
+	tmpl, err := template.New("config").Parse(serverConfigTemplate)
+	if err != nil {
+		return err
+	}
+
+	return tmpl.Execute(w, options)
+}
+
+const serverConfigTemplate = `# zot configuration, check it with "zot serve --dry-run <file>"
+version: {{.Version}}
+storage:
+  # images are stored as OCI image layouts, one per repository
+  rootDirectory: {{.RootDir}}
+  # blobs shared by several images are stored once, with hard links
+  dedupe: true
+  # blobs no longer referenced by any image are deleted
+  gc: true
+  # all repositories are also garbage collected periodically if set
+  # gcInterval: 24h
+http:
+  address: {{.Address}}
+  port: {{.Port}}
+  realm: zot
+{{- if .TLS}}
+  tls:
+    cert: /etc/zot/server.cert
+    key: /etc/zot/server.key
+    # clients presenting a certificate signed by this CA are authenticated, mutual TLS
+    # cacert: /etc/zot/ca.crt
+{{- end}}
+{{- if .Auth}}
+  auth:
+{{- if eq .Auth "htpasswd"}}
+    # bcrypt hashed passwords, e.g. created with "htpasswd -bBc /etc/zot/htpasswd <user> <password>"
+    htpasswd:
+      path: /etc/zot/htpasswd
+{{- else if eq .Auth "ldap"}}
+    ldap:
+      address: ldap.example.org
+      port: 389
+      startTLS: true
+      baseDN: ou=Users,dc=example,dc=org
+      userAttribute: uid
+      bindDN: cn=ldap-searcher,ou=Users,dc=example,dc=org
+      bindPassword: ldap-searcher-password
+      subtreeSearch: true
+      # cacert: /etc/zot/ldap-ca.crt
+{{- else if eq .Auth "bearer"}}
+    # tokens issued by an external authorization server, verified with its certificate
+    bearer:
+      realm: https://auth.example.org/auth/token
+      service: zot
+      cert: /etc/zot/auth.crt
+{{- else if eq .Auth "webhook"}}
+    # every request is authorized by an external endpoint
+    webhook:
+      url: https://authz.example.org/zot
+      timeout: 5s
+      # decisions are cached for this long, they're not cached if not set
+      # cacheTTL: 1m
+{{- end}}
+    # seconds to wait before answering a failed authentication
+    failDelay: 5
+    # users allowed on the admin endpoints, along with those the webhook or bearer tokens allow
+    # adminUsers:
+    #   - admin
+  # anonymous users may pull images
+  # allowReadAccess: true
+{{- end}}
+log:
+  # one of panic, fatal, error, warn, info, debug and trace
+  level: info
+  # logs are written to stdout if not set
+  # output: /var/log/zot/zot.log
+  # audit: /var/log/zot/zot-audit.log
+{{- if or .Extended .Sync}}
+extensions:
+{{- if .Extended}}
+  # GraphQL search of images, under /query
+  search:
+    enable: true
+    # images are scanned for vulnerabilities, the CVE database is updated every updateInterval, 2h or more
+    cve:
+      updateInterval: 24h
+      # when a newer image fixes CVEs of a floating tag without adding any, the alias is moved to it,
+      # it's only recommended in the logs without alias
+      # retag:
+      #   - tag: stable
+      #     alias: stable-patched
+  # web UI under /ui
+  ui:
+    enable: true
+  # Prometheus metrics under /metrics
+  metrics:
+    enable: true
+{{- end}}
+{{- if .Sync}}
+  # images pushed to zot are replicated to downstream registries
+  sync:
+    enable: true
+    registries:
+      - url: https://registry.example.org
+        # username: zot
+        # password: replication
+        # holds ca.crt, and client.cert and client.key for mutual TLS
+        # certDir: /etc/containers/certs.d/registry.example.org
+        # all repositories are replicated under the same name if not set
+        # content:
+        #   - prefix: prod
+        #     destination: mirror
+        maxRetries: 3
+        retryDelay: 30s
+{{- if .Extended}}
+        # images with vulnerabilities of this severity or above are quarantined until an admin approves them
+        # cvePolicy:
+        #   severity: HIGH
+{{- end}}
+{{- end}}
+{{- end}}
+`
@@ -3,27 +3,42 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	zotErrors "github.com/anuvu/zot/errors"
+	cveinfo "github.com/anuvu/zot/pkg/extensions/search/cve"
 
 	"github.com/briandowns/spinner"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
 )
 
 func NewCveCommand(searchService SearchService) *cobra.Command {
 	searchCveParams := make(map[string]*string)
 
-	var servURL, user, outputFormat string
+	var servURL, user, outputFormat, format, severityThreshold string
 
-	var isSpinner, verifyTLS, fixedFlag, verbose bool
+	var verifyTLS, fixedFlag, verbose, raw bool
+
+	var timeout time.Duration
+
+	// scans of big images take a while, progress is shown unless the config turns it off
+	isSpinner := true
 
 	var cveCmd = &cobra.Command{
 		Use:   "cve [config-name]",
 		Short: "Lookup CVEs in images hosted on zot",
 		Long:  `List CVEs (Common Vulnerabilities and Exposures) of images hosted on a zot instance`,
+		// the config name isn't a subcommand
+		Args: cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			home, err := os.UserHomeDir()
 			if err != nil {
@@ -61,6 +76,57 @@ func NewCveCommand(searchService SearchService) *cobra.Command {
 				}
 			}
 
+			if err := configureHTTPClients(configPath, args); err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			servURL, err = resolveCommandURL(cmd, configPath, args, servURL, verifyTLS)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			if timeout < 0 {
+				return fmt.Errorf("%w: --timeout %s", zotErrors.ErrInvalidFlagsCombination, timeout)
+			}
+
+			if timeout > 0 {
+				httpClients.setRequestTimeout(timeout)
+			}
+
+			severityThreshold = strings.ToUpper(severityThreshold)
+			if severityThreshold != "" {
+				if cveinfo.SeverityRank(severityThreshold) < 0 {
+					return fmt.Errorf("%w: %q", zotErrors.ErrInvalidSeverity, severityThreshold)
+				}
+
+				// the threshold applies to the CVEs of an image only
+				if *searchCveParams["imageName"] == "" || fixedFlag {
+					return zotErrors.ErrInvalidFlagsCombination
+				}
+			}
+
+			// the report of the scanner of an image is printed as is
+			if raw && (*searchCveParams["imageName"] == "" || *searchCveParams["cveID"] != "" || fixedFlag ||
+				severityThreshold != "" || outputFormat != "" || format != "") {
+				return zotErrors.ErrInvalidFlagsCombination
+			}
+
+			tmpl, err := parseFormatTemplate(format, outputFormat)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			termWidth := terminalWidth(cmd.OutOrStdout())
+
+			imageTable, err := newImageTableLayout(nil, outputFormat, false, termWidth)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
 			spin := spinner.New(spinner.CharSets[39], spinnerDuration, spinner.WithWriter(cmd.ErrOrStderr()))
 			spin.Prefix = fmt.Sprintf("Fetching from %s.. ", servURL)
 
@@ -72,11 +138,23 @@ func NewCveCommand(searchService SearchService) *cobra.Command {
 				servURL:       &servURL,
 				user:          &user,
 				outputFormat:  &outputFormat,
+				template:      tmpl,
+				imageTable:    imageTable,
+				cveTable:      newCVETableLayout(outputFormat, termWidth),
 				fixedFlag:     &fixedFlag,
 				verifyTLS:     &verifyTLS,
 				verbose:       &verbose,
 				resultWriter:  cmd.OutOrStdout(),
-				spinner:       spinnerState{spin, isSpinner},
+				spinner:       spinnerState{spin, showProgress(cmd.ErrOrStderr(), isSpinner, outputFormat, tmpl)},
+				timeout:       timeout,
+
+				severityThreshold: severityThreshold,
+			}
+
+			if raw {
+				cmd.SilenceUsage = true
+
+				return printRawCVEReport(searchConfig)
 			}
 
 			err = searchCve(searchConfig)
@@ -95,10 +173,25 @@ func NewCveCommand(searchService SearchService) *cobra.Command {
 		servURL:         &servURL,
 		user:            &user,
 		outputFormat:    &outputFormat,
+		format:          &format,
 		fixedFlag:       &fixedFlag,
 	}
 
 	setupCveFlags(cveCmd, vars)
+	cveCmd.Flags().StringVar(&severityThreshold, "fail-on-severity", "", "Exit with code "+
+		strconv.Itoa(ExitCVEThreshold)+" if the image has CVEs of this severity or higher"+
+		" [unknown/low/medium/high/critical]")
+	cveCmd.Flags().BoolVar(&raw, "raw", false, "Print the report of the scanner of an image in its own JSON"+
+		" format, as trivy -f json does, instead of the CVEs")
+	cveCmd.Flags().DurationVar(&timeout, "timeout", 0, "Give up waiting for the results after this long, e.g. 30m,"+
+		" the request-timeout of the config by default")
+
+	cveCmd.ValidArgsFunction = completeConfigNames
+	_ = cveCmd.RegisterFlagCompletionFunc("image", completeImageNames)
+
+	cveCmd.SetUsageTemplate(cveCmd.UsageTemplate() + exitCodesUsage)
+
+	cveCmd.AddCommand(newCveReportCommand())
 
 	return cveCmd
 }
@@ -110,8 +203,11 @@ func setupCveFlags(cveCmd *cobra.Command, variables cveFlagVariables) {
 	cveCmd.Flags().StringVar(variables.servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
 	cveCmd.Flags().StringVarP(variables.user, "user", "u", "", `User Credentials of `+
 		`zot server in USERNAME:PASSWORD format`)
-	cveCmd.Flags().StringVarP(variables.outputFormat, "output", "o", "", "Specify output format [text/json/yaml]."+
+	cveCmd.Flags().StringVarP(variables.outputFormat, "output", "o", "", "Specify output format [text/wide/json/yaml]."+
 		" JSON and YAML format return all info for CVEs")
+	cveCmd.Flags().StringVar(variables.format, "format", "", "Format each result using a Go template, e.g. "+
+		`'{{.ID}} {{.Severity}}'. CVE fields: Tag, ID, Severity, NormalizedSeverity, Title, Description, CVSS, PackageList;`+
+		` image fields: Name, Tag, Digest, ConfigDigest, Size, Layers`)
 
 	cveCmd.Flags().BoolVar(variables.fixedFlag, "fixed", false, "List tags which have fixed a CVE")
 }
@@ -121,10 +217,16 @@ type cveFlagVariables struct {
 	servURL         *string
 	user            *string
 	outputFormat    *string
+	format          *string
 	fixedFlag       *bool
 }
 
 func searchCve(searchConfig searchConfig) error {
+	username, password := getUsernameAndPassword(*searchConfig.user)
+	if err := checkCVEEnabled(searchConfig, username, password); err != nil {
+		return err
+	}
+
 	for _, searcher := range getCveSearchers() {
 		found, err := searcher.search(searchConfig)
 		if found {
@@ -138,3 +240,243 @@ func searchCve(searchConfig searchConfig) error {
 
 	return zotErrors.ErrInvalidFlagsCombination
 }
+
+type cveRawReportResult struct {
+	Errors []errorGraphQL `json:"errors"`
+	Data   struct {
+		CVERawReportForImage *string `json:"CVERawReportForImage"`
+	} `json:"data"`
+}
+
+// printRawCVEReport scans an image and prints the report of the scanner in its own JSON format.
+func printRawCVEReport(config searchConfig) error {
+	username, password := getUsernameAndPassword(*config.user)
+	if err := checkCVEEnabled(config, username, password); err != nil {
+		return err
+	}
+
+	image := *config.params["imageName"]
+	if !validateImageNameTag(image) {
+		return errInvalidImageNameAndTag
+	}
+
+	endPoint, err := combineServerAndEndpointURL(*config.servURL, "/query")
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`{CVERawReportForImage(image: %s)}`, graphQLString(image))
+	result := &cveRawReportResult{}
+
+	if err := makeGraphQLRequest(endPoint, query, username, password, *config.verifyTLS, result); err != nil {
+		return err
+	}
+
+	if result.Errors != nil {
+		return newGraphQLError(result.Errors)
+	}
+
+	if result.Data.CVERawReportForImage == nil {
+		return fmt.Errorf("%w: %s", zotErrors.ErrManifestNotFound, image)
+	}
+
+	fmt.Fprintln(config.resultWriter, *result.Data.CVERawReportForImage)
+
+	return nil
+}
+
+// showProgress tells whether to show a spinner while waiting for the results, only on terminals and
+// for the text output, other formats being meant for scripts.
+func showProgress(writer io.Writer, enabled bool, outputFormat string, tmpl *template.Template) bool {
+	return enabled && tmpl == nil && isTableFormat(outputFormat) && terminalWidth(writer) > 0
+}
+
+func newCveReportCommand() *cobra.Command {
+	var servURL, user, outputFormat, prefix string
+
+	var limit int
+
+	reportCmd := &cobra.Command{
+		Use:   "report [config-name]",
+		Short: "Count the CVEs of all images hosted on zot by severity",
+		Long: `Count the distinct CVEs found in the images of all repositories of a zot server, or of the
+repositories under a prefix, by severity, and list the repositories with the most severe CVEs.
+The counts come from the last scans of the images, images which were never scanned are left out.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverURL, verifyTLS, err := serverFromCommand(cmd, args)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			if serverURL == "" {
+				return zotErrors.ErrNoURLProvided
+			}
+
+			endPoint, err := combineServerAndEndpointURL(serverURL, "/query")
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			username, password := getUsernameAndPassword(user)
+			query := fmt.Sprintf(`{CVEReport(prefix: %q, limit: %d) {RepoCount ScannedRepoCount `+
+				`CVESummary {Count MaxSeverity Critical High Medium Low Unknown} `+
+				`WorstRepos {RepoName CVESummary {Count MaxSeverity Critical High Medium Low Unknown}}}}`, prefix, limit)
+			result := &cveReportResult{}
+
+			if err := makeGraphQLRequest(endPoint, query, username, password, verifyTLS, result); err != nil {
+				return err
+			}
+
+			if result.Errors != nil {
+				return newGraphQLError(result.Errors)
+			}
+
+			return printCVEReport(cmd.OutOrStdout(), result.Data.CVEReport, outputFormat)
+		},
+	}
+
+	reportCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	reportCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	reportCmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Specify output format [text/json/yaml]")
+	reportCmd.Flags().StringVar(&prefix, "prefix", "", "Only count the repositories under this prefix, e.g. team")
+	reportCmd.Flags().IntVar(&limit, "limit", defaultCVEReportTop, "Number of repositories with the most severe "+
+		"CVEs listed, -1 to list all")
+
+	reportCmd.ValidArgsFunction = completeConfigNames
+
+	return reportCmd
+}
+
+// defaultCVEReportTop is the number of repositories with the most severe CVEs listed by default.
+const defaultCVEReportTop = 10
+
+type repoCVESummary struct {
+	RepoName   string      `json:"RepoName"`
+	CVESummary *cveSummary `json:"CVESummary"`
+}
+
+type cveReport struct {
+	RepoCount        int              `json:"RepoCount"`
+	ScannedRepoCount int              `json:"ScannedRepoCount"`
+	CVESummary       *cveSummary      `json:"CVESummary"`
+	WorstRepos       []repoCVESummary `json:"WorstRepos"`
+}
+
+type cveReportResult struct {
+	Errors []errorGraphQL `json:"errors"`
+	Data   struct {
+		CVEReport cveReport `json:"CVEReport"`
+	} `json:"data"`
+}
+
+func printCVEReport(writer io.Writer, report cveReport, outputFormat string) error {
+	switch strings.ToLower(outputFormat) {
+	case "", defaultOutoutFormat:
+	case jsonOutputFormat:
+		body, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(writer, string(body))
+
+		return nil
+	case ymlOutputFormat, yamlOutputFormat:
+		body, err := yaml.Marshal(report)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(writer, string(body))
+
+		return nil
+	default:
+		return ErrInvalidOutputFormat
+	}
+
+	totals := newCVEReportTableLayout(columnRepos, "REPOSITORIES")
+	totals.printHeader(writer)
+
+	values := cveReportRow(report.CVESummary)
+	values[columnRepos] = strconv.Itoa(report.RepoCount)
+	values[columnScanned] = strconv.Itoa(report.ScannedRepoCount)
+
+	table := totals.newTable(writer)
+	table.Append(totals.row(values))
+	table.Render()
+
+	if len(report.WorstRepos) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(writer)
+
+	repos := newCVEReportTableLayout(columnRepo, "REPOSITORY")
+	repos.printHeader(writer)
+
+	table = repos.newTable(writer)
+
+	for _, repo := range report.WorstRepos {
+		values := cveReportRow(repo.CVESummary)
+		values[columnRepo] = repo.RepoName
+
+		table.Append(repos.row(values))
+	}
+
+	table.Render()
+
+	return nil
+}
+
+// cveReportRow returns the CVE counts of a row of the CVE report.
+func cveReportRow(summary *cveSummary) map[string]string {
+	if summary == nil {
+		summary = &cveSummary{}
+	}
+
+	return map[string]string{
+		columnCount:    strconv.Itoa(summary.Count),
+		columnCritical: strconv.Itoa(summary.Critical),
+		columnHigh:     strconv.Itoa(summary.High),
+		columnMedium:   strconv.Itoa(summary.Medium),
+		columnLow:      strconv.Itoa(summary.Low),
+		columnUnknown:  strconv.Itoa(summary.Unknown),
+	}
+}
+
+// newCVEReportTableLayout returns the layout of the tables of the CVE report, the first column being either
+// the count of repositories or the name of a repository.
+func newCVEReportTableLayout(first, header string) *tableLayout {
+	columns := []tableColumn{{name: first, header: header, minWidth: imageNameWidth}}
+
+	if first == columnRepos {
+		columns = append(columns, tableColumn{name: columnScanned, header: "SCANNED", minWidth: countWidth})
+	}
+
+	return &tableLayout{
+		columns: append(columns,
+			tableColumn{name: columnCount, header: "CVES", minWidth: countWidth},
+			tableColumn{name: columnCritical, header: "CRITICAL", minWidth: countWidth},
+			tableColumn{name: columnHigh, header: "HIGH", minWidth: countWidth},
+			tableColumn{name: columnMedium, header: "MEDIUM", minWidth: countWidth},
+			tableColumn{name: columnLow, header: "LOW", minWidth: countWidth},
+			tableColumn{name: columnUnknown, header: "UNKNOWN", minWidth: countWidth},
+		),
+	}
+}
+
+const (
+	columnRepo     = "repo"
+	columnScanned  = "scanned"
+	columnCount    = "count"
+	columnCritical = "critical"
+	columnHigh     = "high"
+	columnMedium   = "medium"
+	columnLow      = "low"
+	columnUnknown  = "unknown"
+)
@@ -5,18 +5,24 @@ package cli //nolint:testpackage
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	zotErrors "github.com/anuvu/zot/errors"
 	"github.com/anuvu/zot/pkg/api"
 	ext "github.com/anuvu/zot/pkg/extensions"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
 	"gopkg.in/resty.v1"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -182,6 +188,127 @@ func TestSearchCVECmd(t *testing.T) {
 		})
 	})
 
+	Convey("Test CVE severity grouping", t, func() {
+		cveList := []cve{
+			{ID: "low", Severity: "LOW"},
+			{ID: "unknown", Severity: "UNKNOWN"},
+			{ID: "scored", Severity: "UNKNOWN", NormalizedSeverity: "CRITICAL"},
+			{ID: "high", Severity: "HIGH"},
+			{ID: "other", Severity: "NEGLIGIBLE"},
+			{ID: "critical", Severity: "CRITICAL"},
+			{ID: "medium", Severity: "MEDIUM"},
+		}
+
+		ids := []string{}
+		for _, c := range groupCVEsBySeverity(cveList) {
+			ids = append(ids, c.ID)
+		}
+
+		So(ids, ShouldResemble, []string{"scored", "critical", "high", "medium", "low", "unknown", "other"})
+
+		// unknown severities count as rated by their CVSS scores
+		err := checkSeverityThreshold([]cve{{ID: "scored", Severity: "UNKNOWN", NormalizedSeverity: "HIGH"}}, "HIGH")
+		So(err, ShouldNotBeNil)
+		So(ExitCode(err), ShouldEqual, ExitCVEThreshold)
+	})
+
+	Convey("Test CVEs split by target", t, func() {
+		result := cveResult{}
+		result.Data.CVEListForImage.CVEList = []cve{
+			{ID: "os", Severity: "HIGH", PackageList: []packageList{{Name: "musl", Target: "alpine"}}},
+			{ID: "both", Severity: "LOW", PackageList: []packageList{
+				{Name: "openssl", Target: "alpine"}, {Name: "libssl", Target: "alpine"}, {Name: "pyopenssl", Target: "app"}}},
+			{ID: "app", Severity: "MEDIUM", PackageList: []packageList{{Name: "requests", Target: "app"}}},
+		}
+
+		targets, cvesByTarget := splitCVEsByTarget(result.Data.CVEListForImage.CVEList)
+		So(targets, ShouldResemble, []string{"alpine", "app"})
+		So(len(cvesByTarget["alpine"]), ShouldEqual, 2)
+		So(cvesByTarget["alpine"][1].ID, ShouldEqual, "both")
+		So(len(cvesByTarget["app"]), ShouldEqual, 2)
+
+		strs, err := result.targetStrings("text")
+		So(err, ShouldBeNil)
+		So(len(strs), ShouldEqual, 2)
+
+		space := regexp.MustCompile(`\s+`)
+		So(strings.TrimSpace(space.ReplaceAllString(strs[0], " ")), ShouldEqual, "Target: alpine os HIGH both LOW")
+		So(strings.TrimSpace(space.ReplaceAllString(strs[1], " ")), ShouldEqual, "Target: app both LOW app MEDIUM")
+
+		strs, err = result.targetStrings("json")
+		So(err, ShouldBeNil)
+		So(len(strs), ShouldEqual, 1)
+		So(strs[0], ShouldContainSubstring, `"Target": "app"`)
+	})
+
+	Convey("Test CVE progress and timeout", t, func() {
+		defer httpClients.configure(defaultHTTPClientOptions())
+
+		So(showProgress(ioutil.Discard, true, "", nil), ShouldBeFalse)
+
+		args := []string{"cvetest", "--image", "dummyImageName:tag", "--url", "someURL", "--timeout", "100ms"}
+		configPath := makeConfigFile(`{"configs":[{"_name":"cvetest","showspinner":false}]}`)
+		defer os.Remove(configPath)
+		cveCmd := NewCveCommand(new(slowCVEService))
+		cveCmd.SetOut(ioutil.Discard)
+		cveCmd.SetErr(ioutil.Discard)
+		cveCmd.SetArgs(args)
+		So(cveCmd.Execute(), ShouldEqual, zotErrors.ErrCLITimeout)
+
+		args = []string{"cvetest", "--image", "dummyImageName:tag", "--url", "someURL", "--timeout", "-1s"}
+		cveCmd = NewCveCommand(new(mockService))
+		cveCmd.SetOut(ioutil.Discard)
+		cveCmd.SetErr(ioutil.Discard)
+		cveCmd.SetArgs(args)
+		So(cveCmd.Execute(), ShouldNotBeNil)
+	})
+
+	Convey("Test CVE severity threshold", t, func() {
+		args := []string{"cvetest", "--image", "dummyImageName:tag", "--url", "someURL", "--fail-on-severity", "medium"}
+		configPath := makeConfigFile(`{"configs":[{"_name":"cvetest","showspinner":false}]}`)
+		defer os.Remove(configPath)
+		cveCmd := NewCveCommand(new(mockService))
+		buff := bytes.NewBufferString("")
+		cveCmd.SetOut(buff)
+		cveCmd.SetErr(ioutil.Discard)
+		cveCmd.SetArgs(args)
+		err := cveCmd.Execute()
+		space := regexp.MustCompile(`\s+`)
+		str := space.ReplaceAllString(buff.String(), " ")
+		So(strings.TrimSpace(str), ShouldEqual, "ID SEVERITY TITLE dummyCVEID HIGH Title of that CVE")
+		So(err, ShouldNotBeNil)
+		So(ExitCode(err), ShouldEqual, ExitCVEThreshold)
+
+		Convey("not exceeded", func() {
+			args := []string{"cvetest", "--image", "dummyImageName:tag", "--url", "someURL", "--fail-on-severity", "CRITICAL"}
+			cveCmd := NewCveCommand(new(mockService))
+			cveCmd.SetOut(ioutil.Discard)
+			cveCmd.SetErr(ioutil.Discard)
+			cveCmd.SetArgs(args)
+			So(cveCmd.Execute(), ShouldBeNil)
+		})
+
+		Convey("invalid", func() {
+			args := []string{"cvetest", "--image", "dummyImageName:tag", "--url", "someURL", "--fail-on-severity", "bad"}
+			cveCmd := NewCveCommand(new(mockService))
+			cveCmd.SetOut(ioutil.Discard)
+			cveCmd.SetErr(ioutil.Discard)
+			cveCmd.SetArgs(args)
+			err := cveCmd.Execute()
+			So(err, ShouldNotBeNil)
+			So(ExitCode(err), ShouldEqual, ExitInvalidFlags)
+
+			args = []string{"cvetest", "--cve-id", "aCVEID", "--url", "someURL", "--fail-on-severity", "high"}
+			cveCmd = NewCveCommand(new(mockService))
+			cveCmd.SetOut(ioutil.Discard)
+			cveCmd.SetErr(ioutil.Discard)
+			cveCmd.SetArgs(args)
+			err = cveCmd.Execute()
+			So(err, ShouldEqual, zotErrors.ErrInvalidFlagsCombination)
+			So(ExitCode(err), ShouldEqual, ExitInvalidFlags)
+		})
+	})
+
 	Convey("Test CVE by image name", t, func() {
 		args := []string{"cvetest", "--image", "dummyImageName:tag", "--url", "someURL"}
 		configPath := makeConfigFile(`{"configs":[{"_name":"cvetest","showspinner":false}]}`)
@@ -240,7 +367,7 @@ func TestSearchCVECmd(t *testing.T) {
 			cveCmd := NewCveCommand(new(mockService))
 			buff := bytes.NewBufferString("")
 			cveCmd.SetOut(buff)
-			cveCmd.SetErr(ioutil.Discard)
+			cveCmd.SetErr(buff)
 			cveCmd.SetArgs(args)
 			err := cveCmd.Execute()
 			space := regexp.MustCompile(`\s+`)
@@ -283,6 +410,19 @@ func TestSearchCVECmd(t *testing.T) {
 	})
 }
 
+// slowCVEService never returns the CVEs of an image.
+type slowCVEService struct {
+	mockService
+}
+
+func (service slowCVEService) getCveByImage(ctx context.Context, config searchConfig, username, password,
+	imageName string, c chan stringResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(c)
+
+	<-ctx.Done()
+}
+
 func TestServerCVEResponse(t *testing.T) {
 	port := getFreePort()
 	url := getBaseURL(port)
@@ -480,3 +620,128 @@ func TestServerCVEResponse(t *testing.T) {
 		})
 	})
 }
+
+func TestCVEReportCmd(t *testing.T) {
+	Convey("Test CVE report of the whole registry", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		for _, repo := range []string{"team/app", "other"} {
+			if err := copyFiles("../../test/data/zot-test", path.Join(dir, repo)); err != nil {
+				panic(err)
+			}
+		}
+
+		url, c := startTestServer(dir, func(config *api.Config) {
+			config.Extensions = &ext.ExtensionConfig{Search: &ext.SearchConfig{Enable: true}}
+		})
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		resp, err := resty.R().Head(url + "/v2/team/app/manifests/0.0.1")
+		So(err, ShouldBeNil)
+
+		summary := &storage.ScanSummary{Scanned: time.Now(), CVEs: []string{"CVE-1", "CVE-2"},
+			CVESeverities: map[string]string{"CVE-1": "CRITICAL", "CVE-2": "MEDIUM"}}
+		So(c.StoreController.DefaultStore.SetScanSummary("team/app",
+			godigest.Digest(resp.Header().Get(api.DistContentDigestKey)), summary), ShouldBeNil)
+
+		cmd := NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"cve", "report", "--url", url})
+		So(cmd.Execute(), ShouldBeNil)
+
+		space := regexp.MustCompile(`\s+`)
+		So(strings.TrimSpace(space.ReplaceAllString(buff.String(), " ")), ShouldEqual,
+			"REPOSITORIES SCANNED CVES CRITICAL HIGH MEDIUM LOW UNKNOWN 2 1 2 1 0 1 0 0 "+
+				"REPOSITORY CVES CRITICAL HIGH MEDIUM LOW UNKNOWN team/app 2 1 0 1 0 0")
+
+		cmd = NewRootCmd()
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"cve", "report", "--url", url, "--prefix", "other", "-o", "json"})
+		So(cmd.Execute(), ShouldBeNil)
+
+		var report cveReport
+
+		So(json.Unmarshal(buff.Bytes(), &report), ShouldBeNil)
+		So(report.RepoCount, ShouldEqual, 1)
+		So(report.ScannedRepoCount, ShouldEqual, 0)
+		So(report.CVESummary, ShouldBeNil)
+		So(report.WorstRepos, ShouldBeEmpty)
+
+		cmd = NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"cve", "report", "--url", url, "-o", "wide"})
+		So(cmd.Execute(), ShouldEqual, ErrInvalidOutputFormat)
+	})
+}
+
+func TestCVERawReportCmd(t *testing.T) {
+	Convey("Test raw CVE report of an image", t, func() {
+		raw := `[{"Target":"app (alpine 3.12.0)","Type":"alpine","Vulnerabilities":[{"VulnerabilityID":"CVE-1"}]}]`
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/query" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			query := r.URL.Query().Get("query")
+			if query == "" {
+				body, _ := ioutil.ReadAll(r.Body)
+				query = string(body)
+			}
+
+			var result cveRawReportResult
+
+			if strings.Contains(query, "app:1.0") {
+				result.Data.CVERawReportForImage = &raw
+			} else {
+				result.Errors = []errorGraphQL{{Message: "manifest: not found"}}
+			}
+
+			body, _ := json.Marshal(result)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(body)
+		}))
+		defer server.Close()
+
+		cve := func(args ...string) (string, error) {
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs(append([]string{"cve", "--url", server.URL, "--raw"}, args...))
+			err := cmd.Execute()
+
+			return buff.String(), err
+		}
+
+		out, err := cve("-I", "app:1.0")
+		So(err, ShouldBeNil)
+		So(strings.TrimSpace(out), ShouldEqual, raw)
+
+		_, err = cve("-I", "app:2.0")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "manifest: not found")
+
+		_, err = cve("-I", "app")
+		So(err, ShouldEqual, errInvalidImageNameAndTag)
+
+		for _, args := range [][]string{{}, {"-i", "CVE-1"}, {"-I", "app:1.0", "-o", "json"},
+			{"-I", "app:1.0", "--fail-on-severity", "high"}, {"-I", "app", "-i", "CVE-1", "--fixed"}} {
+			_, err = cve(args...)
+			So(err, ShouldEqual, zotErrors.ErrInvalidFlagsCombination)
+		}
+	})
+}
@@ -0,0 +1,289 @@
+// +build extended
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+const dedupeEndpoint = "/v2/_zot/admin/dedupe"
+
+func NewDedupeCommand() *cobra.Command {
+	dedupeCmd := &cobra.Command{
+		Use:   "dedupe",
+		Short: "Inspect and restore the dedupe of blobs",
+		Long:  `Inspect how much disk space the dedupe of blobs saves on a zot server, and hard link duplicate blobs`,
+	}
+
+	dedupeCmd.AddCommand(newDedupeReportCommand())
+	dedupeCmd.AddCommand(newRededupeCommand())
+
+	return dedupeCmd
+}
+
+func newDedupeReportCommand() *cobra.Command {
+	var servURL, user, outputFormat string
+
+	var top int
+
+	reportCmd := &cobra.Command{
+		Use:   "report [config-name]",
+		Short: "Show how much disk space dedupe saves",
+		Long: `Show, for each image store of a zot server, the size of the blobs of all the repositories, the disk
+space they actually take, the space a rededupe would reclaim, and the blobs found in the most repositories.
+Admin credentials are needed if the server has authentication enabled.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			endPoint, username, password, verifyTLS, err := dedupeEndpointFromCommand(cmd, args, user)
+			if err != nil {
+				return err
+			}
+
+			endPoint += "?top=" + strconv.Itoa(top)
+			reports := map[string]storage.DedupeReport{}
+
+			if _, err := makeGETRequest(endPoint, username, password, verifyTLS, &reports); err != nil {
+				return err
+			}
+
+			return printDedupeReports(cmd.OutOrStdout(), reports, outputFormat)
+		},
+	}
+
+	reportCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	reportCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	reportCmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Specify output format [text/json/yaml]")
+	reportCmd.Flags().IntVar(&top, "top", storage.DefaultDedupeReportTop, "Number of most duplicated blobs to list")
+
+	reportCmd.ValidArgsFunction = completeConfigNames
+
+	return reportCmd
+}
+
+func newRededupeCommand() *cobra.Command {
+	var servURL, user string
+
+	rededupeCmd := &cobra.Command{
+		Use:   "rededupe [config-name]",
+		Short: "Hard link duplicate blobs",
+		Long: `Replace the copies of each blob with hard links of a single file, in the image stores of a zot server
+which have dedupe enabled. Copies are made by pushes while dedupe was disabled, or by copying the storage
+to another filesystem. Admin credentials are needed if the server has authentication enabled.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			endPoint, username, password, verifyTLS, err := dedupeEndpointFromCommand(cmd, args, user)
+			if err != nil {
+				return err
+			}
+
+			req, err := http.NewRequest(http.MethodPost, endPoint, nil)
+			if err != nil {
+				return err
+			}
+
+			req.SetBasicAuth(username, password)
+
+			results := map[string]storage.RededupeResult{}
+
+			if _, err := doHTTPRequest(req, verifyTLS, &results); err != nil {
+				return err
+			}
+
+			printRededupeResults(cmd.OutOrStdout(), results)
+
+			return nil
+		},
+	}
+
+	rededupeCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	rededupeCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+
+	rededupeCmd.ValidArgsFunction = completeConfigNames
+
+	return rededupeCmd
+}
+
+func dedupeEndpointFromCommand(cmd *cobra.Command, args []string, user string) (string, string, string, bool, error) {
+	serverURL, verifyTLS, err := serverFromCommand(cmd, args)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return "", "", "", false, err
+	}
+
+	if serverURL == "" {
+		return "", "", "", false, zotErrors.ErrNoURLProvided
+	}
+
+	endPoint, err := combineServerAndEndpointURL(serverURL, dedupeEndpoint)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return "", "", "", false, err
+	}
+
+	cmd.SilenceUsage = true
+
+	username, password := getUsernameAndPassword(user)
+
+	return endPoint, username, password, verifyTLS, nil
+}
+
+func printDedupeReports(writer io.Writer, reports map[string]storage.DedupeReport, outputFormat string) error {
+	switch strings.ToLower(outputFormat) {
+	case "", defaultOutoutFormat:
+	case jsonOutputFormat:
+		body, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(writer, string(body))
+
+		return nil
+	case ymlOutputFormat, yamlOutputFormat:
+		body, err := yaml.Marshal(reports)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(writer, string(body))
+
+		return nil
+	default:
+		return ErrInvalidOutputFormat
+	}
+
+	routes := make([]string, 0, len(reports))
+	for route := range reports {
+		routes = append(routes, route)
+	}
+
+	// the default image store "/" comes first
+	sort.Strings(routes)
+
+	stores := newDedupeTableLayout()
+	stores.printHeader(writer)
+
+	table := stores.newTable(writer)
+
+	for _, route := range routes {
+		report := reports[route]
+
+		table.Append(stores.row(map[string]string{
+			columnStore:       route,
+			columnDedupe:      strconv.FormatBool(report.Dedupe),
+			columnBlobs:       strconv.Itoa(report.Blobs),
+			columnFiles:       strconv.Itoa(report.BlobFiles),
+			columnLogical:     formatBytes(report.LogicalBytes),
+			columnPhysical:    formatBytes(report.PhysicalBytes),
+			columnReclaimable: formatBytes(report.ReclaimableBytes),
+		}))
+	}
+
+	table.Render()
+
+	duplicates := newDuplicateBlobTableLayout()
+
+	for _, route := range routes {
+		report := reports[route]
+		if len(report.TopDuplicates) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(writer, "\nMost duplicated blobs of %s:\n", route)
+		duplicates.printHeader(writer)
+
+		table := duplicates.newTable(writer)
+
+		for _, blob := range report.TopDuplicates {
+			table.Append(duplicates.row(map[string]string{
+				columnDigest: blob.Digest.Encoded(),
+				columnSize:   formatBytes(blob.Size),
+				columnRepos:  strconv.Itoa(len(blob.Repos)),
+				columnCopies: strconv.Itoa(blob.Copies),
+				columnSaved:  formatBytes(blob.SavedBytes),
+			}))
+		}
+
+		table.Render()
+	}
+
+	return nil
+}
+
+func printRededupeResults(writer io.Writer, results map[string]storage.RededupeResult) {
+	if len(results) == 0 {
+		fmt.Fprintln(writer, "dedupe is not enabled, no blob was linked")
+		return
+	}
+
+	routes := make([]string, 0, len(results))
+	for route := range results {
+		routes = append(routes, route)
+	}
+
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		result := results[route]
+
+		fmt.Fprintf(writer, "%s: linked %d blobs, reclaimed %s\n", route, result.Linked,
+			humanize.Bytes(uint64(result.ReclaimedBytes)))
+	}
+}
+
+func formatBytes(size int64) string {
+	return strings.ReplaceAll(humanize.Bytes(uint64(size)), " ", "")
+}
+
+// newDedupeTableLayout returns the layout of the table of image stores, values aren't truncated.
+func newDedupeTableLayout() *tableLayout {
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnStore, header: "IMAGE STORE", minWidth: imageNameWidth},
+			{name: columnDedupe, header: "DEDUPE", minWidth: countWidth},
+			{name: columnBlobs, header: "BLOBS", minWidth: countWidth},
+			{name: columnFiles, header: "FILES", minWidth: countWidth},
+			{name: columnLogical, header: "LOGICAL", minWidth: sizeWidth},
+			{name: columnPhysical, header: "PHYSICAL", minWidth: sizeWidth},
+			{name: columnReclaimable, header: "RECLAIMABLE", minWidth: sizeWidth},
+		},
+	}
+}
+
+// newDuplicateBlobTableLayout returns the layout of the table of the most duplicated blobs of an image store.
+func newDuplicateBlobTableLayout() *tableLayout {
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnDigest, header: "DIGEST", width: digestWidth, minWidth: digestWidth},
+			{name: columnSize, header: "SIZE", minWidth: sizeWidth},
+			{name: columnRepos, header: "REPOS", minWidth: countWidth},
+			{name: columnCopies, header: "COPIES", minWidth: countWidth},
+			{name: columnSaved, header: "SAVED", minWidth: sizeWidth},
+		},
+	}
+}
+
+const (
+	columnStore       = "store"
+	columnDedupe      = "dedupe"
+	columnBlobs       = "blobs"
+	columnFiles       = "files"
+	columnLogical     = "logical"
+	columnPhysical    = "physical"
+	columnReclaimable = "reclaimable"
+	columnRepos       = "repos"
+	columnCopies      = "copies"
+	columnSaved       = "saved"
+)
@@ -0,0 +1,100 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/anuvu/zot/pkg/api"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDedupeCmd(t *testing.T) {
+	Convey("Test dedupe from real server", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		// copied repositories don't share their blobs
+		for _, repo := range []string{"a", "b"} {
+			So(copyFiles("../../test/data/zot-cve-test", path.Join(dir, repo)), ShouldBeNil)
+		}
+
+		url, c := startTestServer(dir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		cmd := NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"dedupe", "report", "--url", url, "--top", "1"})
+		So(cmd.Execute(), ShouldBeNil)
+
+		lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+		So(len(lines), ShouldEqual, 6)
+		So(strings.Fields(lines[0]), ShouldResemble, []string{"IMAGE", "STORE", "DEDUPE", "BLOBS", "FILES", "LOGICAL",
+			"PHYSICAL", "RECLAIMABLE"})
+		So(strings.Fields(lines[1])[:2], ShouldResemble, []string{"/", "true"})
+		So(lines[3], ShouldEqual, "Most duplicated blobs of /:")
+		So(strings.Fields(lines[5])[2:4], ShouldResemble, []string{"2", "2"})
+
+		cmd = NewRootCmd()
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"dedupe", "rededupe", "--url", url})
+		So(cmd.Execute(), ShouldBeNil)
+		So(buff.String(), ShouldStartWith, "/: linked ")
+
+		cmd = NewRootCmd()
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"dedupe", "report", "--url", url, "-o", "json"})
+		So(cmd.Execute(), ShouldBeNil)
+		So(buff.String(), ShouldContainSubstring, `"reclaimableBytes": 0`)
+
+		cmd = NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"dedupe", "report"})
+		So(cmd.Execute(), ShouldNotBeNil)
+
+		cmd = NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"dedupe", "report", "--url", url, "-o", "random"})
+		So(cmd.Execute(), ShouldNotBeNil)
+	})
+
+	Convey("Test rededupe output", t, func() {
+		buff := bytes.NewBufferString("")
+		printRededupeResults(buff, map[string]storage.RededupeResult{})
+		So(buff.String(), ShouldEqual, "dedupe is not enabled, no blob was linked\n")
+
+		buff = bytes.NewBufferString("")
+		printRededupeResults(buff, map[string]storage.RededupeResult{
+			"/a": {Linked: 1, ReclaimedBytes: 1000},
+			"/":  {Linked: 3, ReclaimedBytes: 2000000},
+		})
+		So(buff.String(), ShouldEqual, "/: linked 3 blobs, reclaimed 2.0 MB\n/a: linked 1 blobs, reclaimed 1.0 kB\n")
+
+		buff = bytes.NewBufferString("")
+		So(printDedupeReports(buff, map[string]storage.DedupeReport{"/": {
+			TopDuplicates: []storage.DuplicateBlob{{Digest: godigest.FromString("blob"), Size: 4,
+				Repos: []string{"a", "b"}, Copies: 1, SavedBytes: 4}},
+		}}, "yaml"), ShouldBeNil)
+		So(buff.String(), ShouldContainSubstring, "savedbytes: 4")
+	})
+}
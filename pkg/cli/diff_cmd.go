@@ -0,0 +1,431 @@
+// +build extended
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/dustin/go-humanize"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+)
+
+func newImageDiffCommand() *cobra.Command {
+	var servURL, user, outputFormat string
+
+	diffCmd := &cobra.Command{
+		Use:   "diff <image> <image> [config-name]",
+		Short: "Compare the layers and configs of two images",
+		Long: `Compare the layers of two images, by digest, and the env variables, labels, entrypoint and cmd of
+their configs, e.g. zot images diff app:1.0 app:1.1. Images are given by tag or by digest, "repo[:tag|@digest]",
+and --output json prints a JSON patch (RFC 6902) turning the first image into the second one`,
+		Args: cobra.RangeArgs(2, 3), //nolint: gomnd
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := registryClientFromCommand(cmd, args[2:], user)
+			if err != nil {
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			before, err := getDiffImage(client, args[0])
+			if err != nil {
+				return err
+			}
+
+			after, err := getDiffImage(client, args[1])
+			if err != nil {
+				return err
+			}
+
+			return printImageDiff(cmd.OutOrStdout(), args[0], args[1], before, after, outputFormat)
+		},
+	}
+
+	diffCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	diffCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	diffCmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Specify output format [text/wide/json]")
+
+	return diffCmd
+}
+
+// diffImage is the part of an image compared by image diff, which JSON patches apply to.
+type diffImage struct {
+	Layers []diffLayer `json:"layers"`
+	Config diffConfig  `json:"config"`
+}
+
+type diffLayer struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+}
+
+type diffConfig struct {
+	Env        map[string]string `json:"env"`
+	Labels     map[string]string `json:"labels"`
+	Entrypoint []string          `json:"entrypoint,omitempty"`
+	Cmd        []string          `json:"cmd,omitempty"`
+}
+
+// patchOperation is an operation of a JSON patch.
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// getDiffImage reads the manifest and the config of an image, "repo[:tag|@digest]", the tag defaulting to latest.
+func getDiffImage(client *registryClient, image string) (*diffImage, error) {
+	repo, reference := image, "latest"
+	if i := strings.IndexAny(image, ":@"); i >= 0 {
+		repo, reference = image[:i], image[i+1:]
+	}
+
+	content, _, _, err := client.getManifest(repo, reference, ispec.MediaTypeImageManifest,
+		storage.DockerManifestMediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest ispec.Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, err
+	}
+
+	blob, err := client.getBlob(repo, manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	var config ispec.Image
+	if err := json.NewDecoder(blob).Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: invalid config %s: %w", image, manifest.Config.Digest, err)
+	}
+
+	result := &diffImage{Config: diffConfig{
+		Env:        make(map[string]string),
+		Labels:     make(map[string]string),
+		Entrypoint: config.Config.Entrypoint,
+		Cmd:        config.Config.Cmd,
+	}}
+
+	for _, layer := range manifest.Layers {
+		result.Layers = append(result.Layers, diffLayer{Digest: layer.Digest.String(), MediaType: layer.MediaType,
+			Size: layer.Size})
+	}
+
+	for _, env := range config.Config.Env {
+		key, value := env, ""
+		if i := strings.IndexByte(env, '='); i >= 0 {
+			key, value = env[:i], env[i+1:]
+		}
+
+		result.Config.Env[key] = value
+	}
+
+	for key, value := range config.Config.Labels {
+		result.Config.Labels[key] = value
+	}
+
+	return result, nil
+}
+
+// jsonPointer escapes the tokens of a JSON pointer (RFC 6901) and joins them.
+func jsonPointer(tokens ...string) string {
+	escaper := strings.NewReplacer("~", "~0", "/", "~1")
+
+	for i, token := range tokens {
+		tokens[i] = escaper.Replace(token)
+	}
+
+	return "/" + strings.Join(tokens, "/")
+}
+
+// diffPatch returns the JSON patch turning the image before into the one after, layers being patched by
+// position.
+func diffPatch(before, after *diffImage) []patchOperation {
+	patch := []patchOperation{}
+
+	for i := 0; i < len(before.Layers) && i < len(after.Layers); i++ {
+		if before.Layers[i] != after.Layers[i] {
+			patch = append(patch, patchOperation{Op: "replace", Path: jsonPointer("layers", fmt.Sprint(i)),
+				Value: after.Layers[i]})
+		}
+	}
+
+	for i := len(before.Layers); i < len(after.Layers); i++ {
+		patch = append(patch, patchOperation{Op: "add", Path: jsonPointer("layers", fmt.Sprint(i)),
+			Value: after.Layers[i]})
+	}
+
+	for i := len(before.Layers) - 1; i >= len(after.Layers); i-- {
+		patch = append(patch, patchOperation{Op: "remove", Path: jsonPointer("layers", fmt.Sprint(i))})
+	}
+
+	for _, field := range []struct {
+		name          string
+		before, after map[string]string
+	}{
+		{"env", before.Config.Env, after.Config.Env},
+		{"labels", before.Config.Labels, after.Config.Labels},
+	} {
+		for _, key := range unionKeys(field.before, field.after) {
+			value, existed := field.before[key]
+			newValue, exists := field.after[key]
+
+			switch {
+			case !exists:
+				patch = append(patch, patchOperation{Op: "remove", Path: jsonPointer("config", field.name, key)})
+			case !existed:
+				patch = append(patch, patchOperation{Op: "add", Path: jsonPointer("config", field.name, key),
+					Value: newValue})
+			case value != newValue:
+				patch = append(patch, patchOperation{Op: "replace", Path: jsonPointer("config", field.name, key),
+					Value: newValue})
+			}
+		}
+	}
+
+	for _, field := range []struct {
+		name          string
+		before, after []string
+	}{
+		{"entrypoint", before.Config.Entrypoint, after.Config.Entrypoint},
+		{"cmd", before.Config.Cmd, after.Config.Cmd},
+	} {
+		switch {
+		case sameArgs(field.before, field.after):
+		case len(field.after) == 0:
+			patch = append(patch, patchOperation{Op: "remove", Path: jsonPointer("config", field.name)})
+		case len(field.before) == 0:
+			patch = append(patch, patchOperation{Op: "add", Path: jsonPointer("config", field.name),
+				Value: field.after})
+		default:
+			patch = append(patch, patchOperation{Op: "replace", Path: jsonPointer("config", field.name),
+				Value: field.after})
+		}
+	}
+
+	return patch
+}
+
+// sameArgs tells whether the entrypoints or the cmds are the same, unset ones being empty.
+func sameArgs(before, after []string) bool {
+	return (len(before) == 0 && len(after) == 0) || reflect.DeepEqual(before, after)
+}
+
+func unionKeys(maps ...map[string]string) []string {
+	seen := make(map[string]bool)
+	keys := []string{}
+
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func printImageDiff(writer io.Writer, nameBefore, nameAfter string, before, after *diffImage,
+	outputFormat string) error {
+	wide := false
+
+	switch strings.ToLower(outputFormat) {
+	case "", defaultOutoutFormat:
+	case wideOutputFormat:
+		wide = true
+	case jsonOutputFormat:
+		body, err := json.MarshalIndent(diffPatch(before, after), "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(writer, string(body))
+
+		return nil
+	default:
+		return ErrInvalidOutputFormat
+	}
+
+	shortDigest := func(digest string) string {
+		if i := strings.IndexByte(digest, ':'); i >= 0 && !wide {
+			return ellipsize(digest[i+1:], digestWidth, "")
+		}
+
+		return digest
+	}
+
+	previous := make(map[string]bool)
+	for _, layer := range before.Layers {
+		previous[layer.Digest] = true
+	}
+
+	current := make(map[string]bool)
+	for _, layer := range after.Layers {
+		current[layer.Digest] = true
+	}
+
+	layers := newLayerDiffTableLayout(wide)
+	layers.printHeader(writer)
+
+	table := layers.newTable(writer)
+
+	var unchanged, added, removed int
+
+	var addedSize, removedSize uint64
+
+	appendLayer := func(change string, layer diffLayer) {
+		table.Append(layers.row(map[string]string{
+			columnChange: change,
+			columnDigest: shortDigest(layer.Digest),
+			columnSize:   strings.ReplaceAll(humanize.Bytes(uint64(layer.Size)), " ", ""),
+		}))
+	}
+
+	for _, layer := range after.Layers {
+		if previous[layer.Digest] {
+			unchanged++
+
+			appendLayer("", layer)
+
+			continue
+		}
+
+		added++
+		addedSize += uint64(layer.Size)
+
+		appendLayer("+", layer)
+	}
+
+	for _, layer := range before.Layers {
+		if !current[layer.Digest] {
+			removed++
+			removedSize += uint64(layer.Size)
+
+			appendLayer("-", layer)
+		}
+	}
+
+	table.Render()
+
+	fmt.Fprintf(writer, "\nlayers: %d unchanged, %d added (%s), %d removed (%s)\n\n", unchanged, added,
+		humanize.Bytes(addedSize), removed, humanize.Bytes(removedSize))
+
+	printConfigDiff(writer, nameBefore, nameAfter, before, after)
+
+	return nil
+}
+
+// printConfigDiff prints a row for every env variable, label, entrypoint or cmd which differs between the images.
+func printConfigDiff(writer io.Writer, nameBefore, nameAfter string, before, after *diffImage) {
+	var rows []map[string]string
+
+	for _, field := range []struct {
+		name          string
+		before, after map[string]string
+	}{
+		{"env", before.Config.Env, after.Config.Env},
+		{"label", before.Config.Labels, after.Config.Labels},
+	} {
+		for _, key := range unionKeys(field.before, field.after) {
+			value, existed := field.before[key]
+			newValue, exists := field.after[key]
+
+			if existed == exists && value == newValue {
+				continue
+			}
+
+			rows = append(rows, map[string]string{columnField: field.name, columnKey: key,
+				columnBefore: quoteIf(existed, value), columnAfter: quoteIf(exists, newValue)})
+		}
+	}
+
+	for _, field := range []struct {
+		name          string
+		before, after []string
+	}{
+		{"entrypoint", before.Config.Entrypoint, after.Config.Entrypoint},
+		{"cmd", before.Config.Cmd, after.Config.Cmd},
+	} {
+		if sameArgs(field.before, field.after) {
+			continue
+		}
+
+		rows = append(rows, map[string]string{columnField: field.name,
+			columnBefore: quoteIf(len(field.before) > 0, strings.Join(field.before, " ")),
+			columnAfter:  quoteIf(len(field.after) > 0, strings.Join(field.after, " "))})
+	}
+
+	if len(rows) == 0 {
+		fmt.Fprintln(writer, "configs: no changes to env, labels, entrypoint and cmd")
+		return
+	}
+
+	config := newConfigDiffTableLayout(nameBefore, nameAfter)
+	config.printHeader(writer)
+
+	table := config.newTable(writer)
+
+	for _, row := range rows {
+		table.Append(config.row(row))
+	}
+
+	table.Render()
+}
+
+// quoteIf quotes the value, an absent value being shown as "-".
+func quoteIf(present bool, value string) string {
+	if !present {
+		return "-"
+	}
+
+	return fmt.Sprintf("%q", value)
+}
+
+func newLayerDiffTableLayout(wide bool) *tableLayout {
+	digest := digestWidth
+	if wide {
+		digest = fullDigestWidth
+	}
+
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnChange, header: "CHANGE", minWidth: len("CHANGE")},
+			{name: columnDigest, header: "LAYER", minWidth: digest},
+			{name: columnSize, header: "SIZE", minWidth: sizeWidth},
+		},
+	}
+}
+
+func newConfigDiffTableLayout(nameBefore, nameAfter string) *tableLayout {
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnField, header: "FIELD", minWidth: len("ENTRYPOINT")},
+			{name: columnKey, header: "KEY", minWidth: registryWidth},
+			{name: columnBefore, header: nameBefore, minWidth: imageNameWidth},
+			{name: columnAfter, header: nameAfter},
+		},
+	}
+}
+
+const (
+	columnChange = "change"
+	columnField  = "field"
+	columnKey    = "key"
+	columnBefore = "before"
+	columnAfter  = "after"
+)
@@ -0,0 +1,128 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/anuvu/zot/pkg/api"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// pushDiffImage pushes an image with the layers and the config.
+func pushDiffImage(client *registryClient, repo, tag string, config ispec.Image, layers ...string) {
+	manifest := ispec.Manifest{}
+	manifest.SchemaVersion = 2
+
+	for _, layer := range layers {
+		digest := godigest.FromString(layer)
+		So(client.pushBlob(repo, digest, bytes.NewBufferString(layer), int64(len(layer))), ShouldBeNil)
+
+		manifest.Layers = append(manifest.Layers, ispec.Descriptor{MediaType: ispec.MediaTypeImageLayer,
+			Digest: digest, Size: int64(len(layer))})
+	}
+
+	content, err := json.Marshal(config)
+	So(err, ShouldBeNil)
+
+	digest := godigest.FromBytes(content)
+	So(client.pushBlob(repo, digest, bytes.NewReader(content), int64(len(content))), ShouldBeNil)
+
+	manifest.Config = ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: digest,
+		Size: int64(len(content))}
+
+	content, err = json.Marshal(manifest)
+	So(err, ShouldBeNil)
+	So(client.pushManifest(repo, tag, ispec.MediaTypeImageManifest, content), ShouldBeNil)
+}
+
+func TestImageDiff(t *testing.T) {
+	Convey("Test comparing two images", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		url, controller := startTestServer(dir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(controller)
+
+		client, err := newRegistryClient(url, "", "", false)
+		So(err, ShouldBeNil)
+
+		before := ispec.Image{Config: ispec.ImageConfig{
+			Env:        []string{"PATH=/bin", "DEBUG=1"},
+			Labels:     map[string]string{"version": "1.0", "team/owner": "web"},
+			Entrypoint: []string{"/app"},
+		}}
+		after := ispec.Image{Config: ispec.ImageConfig{
+			Env:        []string{"PATH=/usr/bin", "PORT=8080"},
+			Labels:     map[string]string{"version": "1.1", "team/owner": "web"},
+			Entrypoint: []string{"/app"},
+			Cmd:        []string{"--verbose"},
+		}}
+
+		pushDiffImage(client, "app", "1.0", before, "base layer", "app 1.0")
+		pushDiffImage(client, "app", "1.1", after, "base layer", "app 1.1", "assets")
+
+		diff := func(args ...string) (string, error) {
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs(append([]string{"image", "diff", "--url", url}, args...))
+			err := cmd.Execute()
+
+			return buff.String(), err
+		}
+
+		output, err := diff("app:1.0", "app:1.1")
+		So(err, ShouldBeNil)
+		So(output, ShouldContainSubstring, "layers: 1 unchanged, 2 added (13 B), 1 removed (7 B)")
+		So(output, ShouldContainSubstring, "+       "+godigest.FromString("assets").Encoded()[:8])
+		So(output, ShouldContainSubstring, "-       "+godigest.FromString("app 1.0").Encoded()[:8])
+		So(output, ShouldContainSubstring, "app:1.0")
+		So(output, ShouldContainSubstring, `"/bin"`)
+		So(output, ShouldContainSubstring, `"/usr/bin"`)
+		So(output, ShouldContainSubstring, `"--verbose"`)
+		So(output, ShouldNotContainSubstring, "team/owner")
+
+		output, err = diff("app:1.0", "app:1.1", "-o", "json")
+		So(err, ShouldBeNil)
+
+		var patch []patchOperation
+		So(json.Unmarshal([]byte(output), &patch), ShouldBeNil)
+		So(patch, ShouldResemble, []patchOperation{
+			{Op: "replace", Path: "/layers/1", Value: map[string]interface{}{
+				"digest": godigest.FromString("app 1.1").String(), "mediaType": ispec.MediaTypeImageLayer,
+				"size": float64(len("app 1.1"))}},
+			{Op: "add", Path: "/layers/2", Value: map[string]interface{}{
+				"digest": godigest.FromString("assets").String(), "mediaType": ispec.MediaTypeImageLayer,
+				"size": float64(len("assets"))}},
+			{Op: "remove", Path: "/config/env/DEBUG"},
+			{Op: "replace", Path: "/config/env/PATH", Value: "/usr/bin"},
+			{Op: "add", Path: "/config/env/PORT", Value: "8080"},
+			{Op: "replace", Path: "/config/labels/version", Value: "1.1"},
+			{Op: "add", Path: "/config/cmd", Value: []interface{}{"--verbose"}},
+		})
+
+		output, err = diff("app:1.1", "app@"+godigest.FromString("unknown").String())
+		So(err, ShouldNotBeNil)
+		So(output, ShouldBeEmpty)
+
+		output, err = diff("app:1.0", "app:1.0")
+		So(err, ShouldBeNil)
+		So(output, ShouldContainSubstring, "layers: 2 unchanged, 0 added (0 B), 0 removed (0 B)")
+		So(output, ShouldContainSubstring, "configs: no changes to env, labels, entrypoint and cmd")
+
+		So(jsonPointer("config", "labels", "team/owner~1"), ShouldEqual, "/config/labels/team~1owner~01")
+	})
+}
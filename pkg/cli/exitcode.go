@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+
+	zotErrors "github.com/anuvu/zot/errors"
+)
+
+// Exit codes of the zot command, so that scripts can tell the failures apart.
+const (
+	ExitOK           = 0
+	ExitFailure      = 1 // any other failure, e.g. the server can't be reached
+	ExitInvalidFlags = 2
+	ExitAuthFailure  = 3
+	ExitTLSError     = 4
+	ExitNotFound     = 5
+	ExitServerError  = 6
+	ExitCVEThreshold = 7
+)
+
+const exitCodesUsage = `
+Exit codes:
+  0  success
+  1  any other failure, e.g. the zot server can't be reached
+  2  invalid flags or arguments
+  3  authentication failure
+  4  TLS error
+  5  repository or image not found
+  6  zot server error
+  7  CVEs at or above the --fail-on-severity threshold were found
+`
+
+// ExitCode returns the exit code of a command which failed with err.
+func ExitCode(err error) int {
+	var status *zotErrors.Error
+
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, zotErrors.ErrCVEThresholdExceeded):
+		return ExitCVEThreshold
+	case errors.Is(err, zotErrors.ErrUnauthorizedAccess):
+		return ExitAuthFailure
+	case errors.As(err, &status):
+		return statusExitCode(status.Status)
+	case isTLSError(err):
+		return ExitTLSError
+	case errors.Is(err, zotErrors.ErrRepoNotFound), errors.Is(err, zotErrors.ErrManifestNotFound):
+		return ExitNotFound
+	case isUsageError(err):
+		return ExitInvalidFlags
+	default:
+		return ExitFailure
+	}
+}
+
+func statusExitCode(status int) int {
+	switch {
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return ExitAuthFailure
+	case status == http.StatusNotFound:
+		return ExitNotFound
+	case status >= http.StatusInternalServerError:
+		return ExitServerError
+	default:
+		return ExitFailure
+	}
+}
+
+// isTLSError tells whether the connection to the server failed because of its certificate,
+// or because it doesn't speak TLS.
+func isTLSError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalidCert      x509.CertificateInvalidError
+		hostname         x509.HostnameError
+		recordHeader     tls.RecordHeaderError
+	)
+
+	return errors.Is(err, zotErrors.ErrBadCACert) || errors.As(err, &unknownAuthority) ||
+		errors.As(err, &invalidCert) || errors.As(err, &hostname) || errors.As(err, &recordHeader)
+}
+
+// isUsageError tells whether err is caused by the flags, arguments or CLI config of the command.
+func isUsageError(err error) bool {
+	for _, usageErr := range []error{
+		zotErrors.ErrInvalidArgs, zotErrors.ErrInvalidFlagsCombination, zotErrors.ErrInvalidURL,
+		zotErrors.ErrNoURLProvided, zotErrors.ErrConfigNotFound, zotErrors.ErrIllegalConfigKey,
+		zotErrors.ErrCannotResetConfigKey, zotErrors.ErrDuplicateConfigName, zotErrors.ErrInvalidFormatTemplate,
+		zotErrors.ErrUnknownColumn, zotErrors.ErrInvalidConfigValue, zotErrors.ErrInvalidSeverity,
+	} {
+		if errors.Is(err, usageErr) {
+			return true
+		}
+	}
+
+	return isCommandUsageError(err)
+}
@@ -0,0 +1,329 @@
+// +build extended
+
+package cli
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/dustin/go-humanize"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+)
+
+const (
+	dockerArchiveFormat = "docker-archive"
+	ociArchiveFormat    = "oci-archive"
+)
+
+func NewExportCommand() *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export images hosted on zot to archives",
+		Long:  `Export the images of a zot server to archives loadable without a registry`,
+	}
+
+	exportCmd.AddCommand(newExportImageCommand())
+
+	return exportCmd
+}
+
+func newExportImageCommand() *cobra.Command {
+	var servURL, user, format, output, platform string
+
+	imageCmd := &cobra.Command{
+		Use:   "image <repo:tag> [config-name]",
+		Short: "Export an image to a docker-archive or an oci-archive",
+		Long: `Export an image to a tarball, in the format of "docker save", loadable by "docker load" and
+"podman load", or as an OCI image layout, loadable by "podman load" and "skopeo copy oci-archive:".
+Its manifest, config and layers are pulled with the distribution API and checked against their digests,
+the archive isn't written if one doesn't match. Manifest lists are exported as the manifest of the
+--platform, and Docker manifests are converted to OCI manifests in oci-archives.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !validateImageNameTag(args[0]) {
+				return errInvalidImageNameAndTag
+			}
+
+			i := strings.IndexByte(args[0], ':')
+			repo, tag := strings.TrimSpace(args[0][:i]), strings.TrimSpace(args[0][i+1:])
+
+			if format != dockerArchiveFormat && format != ociArchiveFormat {
+				return fmt.Errorf("%w: unknown format %q, expected %s or %s", zotErrors.ErrInvalidArgs, format,
+					dockerArchiveFormat, ociArchiveFormat)
+			}
+
+			target, err := parsePlatform(platform)
+			if err != nil {
+				return err
+			}
+
+			client, err := registryClientFromCommand(cmd, args[1:], user)
+			if err != nil {
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			image, err := getExportedImage(client, repo, tag, target, format == ociArchiveFormat)
+			if err != nil {
+				return err
+			}
+
+			file, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+
+			archive := newImageArchive(file, client, image.repo)
+
+			if format == ociArchiveFormat {
+				err = archive.writeOCIArchive(image)
+			} else {
+				err = archive.writeDockerArchive(image)
+			}
+
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+
+			if err != nil {
+				_ = os.Remove(output)
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "exported %s:%s, %d layers (%s), to %s\n", repo, tag,
+				len(image.manifest.Layers), humanize.Bytes(uint64(image.size())), output)
+
+			return nil
+		},
+	}
+
+	imageCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	imageCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	imageCmd.Flags().StringVar(&format, "format", dockerArchiveFormat,
+		fmt.Sprintf("Format of the archive [%s/%s]", dockerArchiveFormat, ociArchiveFormat))
+	imageCmd.Flags().StringVarP(&output, "file", "f", "", "Archive file to create")
+	imageCmd.Flags().StringVar(&platform, "platform", "linux/amd64",
+		`Platform exported from manifest lists, "os/arch[/variant]"`)
+
+	_ = imageCmd.MarkFlagRequired("file")
+
+	imageCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string,
+		cobra.ShellCompDirective) {
+		if len(args) == 0 {
+			return completeImageNames(cmd, args, toComplete)
+		}
+
+		return completeConfigNames(cmd, args[1:], toComplete)
+	}
+
+	return imageCmd
+}
+
+// exportedImage is an image manifest, its content as exported and the repository and tag it's pulled from.
+type exportedImage struct {
+	repo, tag string
+	// name is the name of the image once loaded, e.g. "localhost:8080/app:1.0"
+	name     string
+	content  []byte
+	manifest ispec.Manifest
+}
+
+// size is the size of the config and the layers of the image.
+func (image exportedImage) size() int64 {
+	size := image.manifest.Config.Size
+
+	for _, layer := range image.manifest.Layers {
+		size += layer.Size
+	}
+
+	return size
+}
+
+// getExportedImage returns the manifest of the image, the manifest of the platform for manifest lists,
+// converting Docker manifests to OCI ones if asked.
+func getExportedImage(client *registryClient, repo, tag string, platform ispec.Platform,
+	convert bool) (exportedImage, error) {
+	image := exportedImage{repo: repo, tag: tag, name: fmt.Sprintf("%s/%s:%s", client.baseURL.Host, repo, tag)}
+
+	content, _, mediaType, err := client.getManifest(repo, tag, ispec.MediaTypeImageManifest, ispec.MediaTypeImageIndex,
+		storage.DockerManifestMediaType, storage.DockerManifestListMediaType)
+	if err != nil {
+		return image, err
+	}
+
+	mediaType = strings.TrimSpace(strings.Split(mediaType, ";")[0])
+
+	if mediaType == ispec.MediaTypeImageIndex || mediaType == storage.DockerManifestListMediaType {
+		if content, mediaType, err = getPlatformManifest(client, repo, content, platform); err != nil {
+			return image, err
+		}
+	}
+
+	if err := json.Unmarshal(content, &image.manifest); err != nil {
+		return image, err
+	}
+
+	if convert && mediaType == storage.DockerManifestMediaType {
+		if content, err = storage.ConvertDockerManifest(&image.manifest); err != nil {
+			return image, err
+		}
+	}
+
+	image.content = content
+
+	return image, nil
+}
+
+// imageArchive writes the files of an archive, and the blobs of a repository pulled from a zot server.
+type imageArchive struct {
+	tw     *tar.Writer
+	client *registryClient
+	repo   string
+	// written are the blobs already in the archive, e.g. layers used twice are written once
+	written map[godigest.Digest]bool
+}
+
+func newImageArchive(w io.Writer, client *registryClient, repo string) *imageArchive {
+	return &imageArchive{tw: tar.NewWriter(w), client: client, repo: repo, written: make(map[godigest.Digest]bool)}
+}
+
+// writeOCIArchive writes the image as a tarball of an OCI image layout, whose index references the
+// manifest by the tag of the image.
+func (archive *imageArchive) writeOCIArchive(image exportedImage) error {
+	layout, _ := json.Marshal(ispec.ImageLayout{Version: ispec.ImageLayoutVersion})
+	if err := archive.writeFile(ispec.ImageLayoutFile, layout); err != nil {
+		return err
+	}
+
+	for _, desc := range append([]ispec.Descriptor{image.manifest.Config}, image.manifest.Layers...) {
+		if err := archive.writeBlob(desc); err != nil {
+			return err
+		}
+	}
+
+	digest := godigest.FromBytes(image.content)
+	if err := archive.writeFile(blobArchivePath(digest), image.content); err != nil {
+		return err
+	}
+
+	index := ispec.Index{
+		Manifests: []ispec.Descriptor{{
+			MediaType:   ispec.MediaTypeImageManifest,
+			Digest:      digest,
+			Size:        int64(len(image.content)),
+			Annotations: map[string]string{ispec.AnnotationRefName: image.tag},
+		}},
+	}
+	index.SchemaVersion = 2
+
+	content, _ := json.Marshal(index)
+	if err := archive.writeFile("index.json", content); err != nil {
+		return err
+	}
+
+	return archive.tw.Close()
+}
+
+// dockerArchiveManifest is an image of the manifest.json of the archives of docker save.
+type dockerArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// writeDockerArchive writes the image as a tarball in the format of docker save, with the blobs under
+// blobs/<algorithm>/<encoded> as recent Docker versions save them.
+func (archive *imageArchive) writeDockerArchive(image exportedImage) error {
+	config := image.manifest.Config
+	if err := archive.writeBlob(config); err != nil {
+		return err
+	}
+
+	archiveManifest := dockerArchiveManifest{Config: blobArchivePath(config.Digest), RepoTags: []string{image.name},
+		Layers: []string{}}
+
+	for _, layer := range image.manifest.Layers {
+		if err := archive.writeBlob(layer); err != nil {
+			return err
+		}
+
+		archiveManifest.Layers = append(archiveManifest.Layers, blobArchivePath(layer.Digest))
+	}
+
+	content, _ := json.Marshal([]dockerArchiveManifest{archiveManifest})
+	if err := archive.writeFile("manifest.json", content); err != nil {
+		return err
+	}
+
+	return archive.tw.Close()
+}
+
+func blobArchivePath(digest godigest.Digest) string {
+	return path.Join("blobs", digest.Algorithm().String(), digest.Encoded())
+}
+
+func (archive *imageArchive) writeFile(name string, content []byte) error {
+	if err := archive.tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)),
+		ModTime: time.Unix(0, 0)}); err != nil {
+		return err
+	}
+
+	_, err := archive.tw.Write(content)
+
+	return err
+}
+
+// writeBlob copies a blob into the archive, unless it's there already. It fails if the blob doesn't match
+// its digest or its size.
+func (archive *imageArchive) writeBlob(desc ispec.Descriptor) error {
+	if archive.written[desc.Digest] {
+		return nil
+	}
+
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", zotErrors.ErrBadBlobDigest, desc.Digest)
+	}
+
+	blob, err := archive.client.getBlob(archive.repo, desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	if err := archive.tw.WriteHeader(&tar.Header{Name: blobArchivePath(desc.Digest), Mode: 0644, Size: desc.Size,
+		ModTime: time.Unix(0, 0)}); err != nil {
+		return err
+	}
+
+	verifier := desc.Digest.Verifier()
+
+	// one byte more than the size, so that longer blobs are told apart
+	n, err := io.Copy(io.MultiWriter(archive.tw, verifier), io.LimitReader(blob, desc.Size+1))
+	if err != nil && !errors.Is(err, tar.ErrWriteTooLong) {
+		return err
+	}
+
+	if n != desc.Size || err != nil {
+		return fmt.Errorf("%w: blob %s isn't %d bytes long", zotErrors.ErrDigestMismatch, desc.Digest, desc.Size)
+	}
+
+	if !verifier.Verified() {
+		return fmt.Errorf("%w: blob %s", zotErrors.ErrDigestMismatch, desc.Digest)
+	}
+
+	archive.written[desc.Digest] = true
+
+	return nil
+}
@@ -0,0 +1,187 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/api"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// readArchive returns the content of each file of a tarball.
+func readArchive(file string) map[string][]byte {
+	f, err := os.Open(file)
+	So(err, ShouldBeNil)
+	defer f.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(f)
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		So(err, ShouldBeNil)
+
+		content, err := ioutil.ReadAll(tr)
+		So(err, ShouldBeNil)
+
+		files[header.Name] = content
+	}
+
+	return files
+}
+
+func TestExportCmd(t *testing.T) {
+	Convey("Test image export from real server", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		So(copyFiles("../../test/data/zot-test", path.Join(dir, "app")), ShouldBeNil)
+
+		url, c := startTestServer(dir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		manifest, err := ioutil.ReadFile(path.Join(dir, "app", "blobs", "sha256",
+			"2338f71b5dd7aa0218cfcf3708df3e5cdc0982c201ce49f6872ff8fa9718507e"))
+		So(err, ShouldBeNil)
+
+		var m ispec.Manifest
+		So(json.Unmarshal(manifest, &m), ShouldBeNil)
+
+		archive := path.Join(dir, "app.tar")
+
+		export := func(args ...string) (string, error) {
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs(append([]string{"export", "image", "--url", url, "-f", archive}, args...))
+			err := cmd.Execute()
+
+			return buff.String(), err
+		}
+
+		Convey("docker-archive", func() {
+			out, err := export("app:0.0.1")
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, fmt.Sprintf("exported app:0.0.1, %d layers", len(m.Layers)))
+
+			files := readArchive(archive)
+
+			var manifests []dockerArchiveManifest
+			So(json.Unmarshal(files["manifest.json"], &manifests), ShouldBeNil)
+			So(len(manifests), ShouldEqual, 1)
+			So(manifests[0].RepoTags, ShouldResemble, []string{url[len("http://"):] + "/app:0.0.1"})
+			So(manifests[0].Config, ShouldEqual, "blobs/sha256/"+m.Config.Digest.Encoded())
+			So(len(manifests[0].Layers), ShouldEqual, len(m.Layers))
+
+			for _, file := range append([]string{manifests[0].Config}, manifests[0].Layers...) {
+				So(files[file], ShouldNotBeEmpty)
+				So(godigest.FromBytes(files[file]).Encoded(), ShouldEqual, path.Base(file))
+			}
+		})
+
+		Convey("oci-archive", func() {
+			_, err := export("app:0.0.1", "--format", "oci-archive")
+			So(err, ShouldBeNil)
+
+			files := readArchive(archive)
+			So(string(files[ispec.ImageLayoutFile]), ShouldContainSubstring, ispec.ImageLayoutVersion)
+
+			var index ispec.Index
+			So(json.Unmarshal(files["index.json"], &index), ShouldBeNil)
+			So(len(index.Manifests), ShouldEqual, 1)
+			So(index.Manifests[0].Digest, ShouldEqual, godigest.FromBytes(manifest))
+			So(index.Manifests[0].Annotations[ispec.AnnotationRefName], ShouldEqual, "0.0.1")
+
+			So(files["blobs/sha256/"+index.Manifests[0].Digest.Encoded()], ShouldResemble, manifest)
+
+			for _, desc := range append([]ispec.Descriptor{m.Config}, m.Layers...) {
+				So(godigest.FromBytes(files["blobs/sha256/"+desc.Digest.Encoded()]), ShouldEqual, desc.Digest)
+			}
+		})
+
+		Convey("Invalid arguments", func() {
+			_, err := export("app")
+			So(err, ShouldEqual, errInvalidImageNameAndTag)
+
+			_, err = export("app:0.0.1", "--format", "tar")
+			So(errors.Is(err, zotErrors.ErrInvalidArgs), ShouldBeTrue)
+
+			_, err = export("app:0.0.2")
+			So(err, ShouldNotBeNil)
+
+			_, err = os.Stat(archive)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+
+	Convey("Test image export of blobs not matching their digest", t, func() {
+		dir, err := ioutil.TempDir("", "export-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		config := []byte("{}")
+		layer := []byte("this is a layer")
+
+		m := ispec.Manifest{
+			Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: godigest.FromBytes(config),
+				Size: int64(len(config))},
+			Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: godigest.FromBytes(layer),
+				Size: int64(len(layer))}},
+		}
+		m.SchemaVersion = 2
+		manifest, _ := json.Marshal(m)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v2/app/manifests/1.0":
+				w.Header().Set("Content-Type", ispec.MediaTypeImageManifest)
+				_, _ = w.Write(manifest)
+			case "/v2/app/blobs/" + m.Config.Digest.String():
+				_, _ = w.Write(config)
+			case "/v2/app/blobs/" + m.Layers[0].Digest.String():
+				// tampered with, of the same size
+				_, _ = w.Write([]byte("this is a LAYER"))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		archive := path.Join(dir, "app.tar")
+
+		cmd := NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"export", "image", "app:1.0", "--url", server.URL, "-f", archive})
+		err = cmd.Execute()
+		So(errors.Is(err, zotErrors.ErrDigestMismatch), ShouldBeTrue)
+
+		_, err = os.Stat(archive)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
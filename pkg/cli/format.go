@@ -0,0 +1,92 @@
+// +build extended
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/dustin/go-humanize"
+	jsoniter "github.com/json-iterator/go"
+
+	zotErrors "github.com/anuvu/zot/errors"
+)
+
+// templateFuncs are the functions available to --format templates, in addition to the text/template builtins.
+// nolint: gochecknoglobals
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		var json = jsoniter.ConfigCompatibleWithStandardLibrary
+		body, err := json.Marshal(v)
+
+		return string(body), err
+	},
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"humanize": func(size uint64) string {
+		return strings.ReplaceAll(humanize.Bytes(size), " ", "")
+	},
+}
+
+// parseFormatTemplate returns nil if no --format template was given,
+// a template can not be combined with an --output format.
+func parseFormatTemplate(format, outputFormat string) (*template.Template, error) {
+	if format == "" {
+		return nil, nil
+	}
+
+	if outputFormat != "" {
+		return nil, zotErrors.ErrInvalidFlagsCombination
+	}
+
+	tmpl, err := template.New("format").Funcs(templateFuncs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", zotErrors.ErrInvalidFormatTemplate, err)
+	}
+
+	return tmpl, nil
+}
+
+// executeTemplate renders each row on its own line.
+func executeTemplate(tmpl *template.Template, rows []interface{}) (string, error) {
+	var builder strings.Builder
+
+	for _, row := range rows {
+		if err := tmpl.Execute(&builder, row); err != nil {
+			return "", fmt.Errorf("%w: %v", zotErrors.ErrInvalidFormatTemplate, err)
+		}
+
+		fmt.Fprintln(&builder)
+	}
+
+	return builder.String(), nil
+}
+
+// imageRow is the data passed to --format templates for every tag of an image.
+type imageRow struct {
+	Name         string
+	Tag          string
+	Digest       string
+	ConfigDigest string
+	Size         uint64
+	Layers       []layer
+	Warning      string
+	CVESummary   *cveSummary
+	// StackerYaml and GitVersion are only set with --provenance.
+	StackerYaml string
+	GitVersion  string
+}
+
+// cveRow is the data passed to --format templates for every CVE of an image.
+type cveRow struct {
+	Tag                string
+	ID                 string
+	Severity           string
+	NormalizedSeverity string
+	Title              string
+	Description        string
+	CVSS               []cvss
+	PackageList        []packageList
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/anuvu/zot/errors"
+	zlog "github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+func NewFsckCommand() *cobra.Command {
+	var rootDir, outputFormat string
+
+	fix, reflink := false, false
+
+	fsckCmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "`fsck` checks the consistency of a storage root directory",
+		Long: `Check the image store of a storage root directory, while zot is stopped, for dangling uploads, orphan
+blobs, index.json entries of missing manifests, blobs missing from manifests, stale dedupe records and
+copies of deduped blobs which aren't hard links anymore, unless --reflink is set for storages deduped
+with reflinks. --fix repairs all of them but the missing blobs. Fails if some issues remain.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch strings.ToLower(outputFormat) {
+			case "", "text", "json":
+			default:
+				return fmt.Errorf("%w: invalid output format %q", errors.ErrInvalidArgs, outputFormat)
+			}
+
+			cmd.SilenceUsage = true
+
+			// the report is printed on stdout, keep it apart from the logs
+			logger := zlog.Logger{Logger: zerolog.New(cmd.ErrOrStderr()).With().Timestamp().Logger()}
+
+			report, err := storage.Fsck(rootDir, fix, reflink, logger)
+			if err != nil {
+				return err
+			}
+
+			if err := printFsckReport(cmd.OutOrStdout(), report, outputFormat); err != nil {
+				return err
+			}
+
+			if unfixed := report.Unfixed(); unfixed != 0 {
+				return fmt.Errorf("%w: %d issues", errors.ErrStorageInconsistent, unfixed)
+			}
+
+			return nil
+		},
+	}
+
+	fsckCmd.Flags().StringVarP(&rootDir, "storage-root-dir", "r", "", "Storage root directory to check")
+	fsckCmd.Flags().BoolVar(&fix, "fix", false, "Repair the issues which can be")
+	fsckCmd.Flags().BoolVar(&reflink, "reflink", false, "The blobs are deduped with reflinks, not hard links")
+	fsckCmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Specify output format [text/json]")
+
+	_ = fsckCmd.MarkFlagRequired("storage-root-dir")
+
+	return fsckCmd
+}
+
+func printFsckReport(writer io.Writer, report storage.FsckReport, outputFormat string) error {
+	if strings.EqualFold(outputFormat, "json") {
+		body, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(writer, string(body))
+
+		return nil
+	}
+
+	fmt.Fprintf(writer, "%s: %d repositories, %d blobs\n", report.RootDir, report.Repos, report.Blobs)
+
+	for _, issue := range report.Issues {
+		status := ""
+
+		switch {
+		case issue.Fixed:
+			status = " (fixed)"
+		case issue.Error != "":
+			status = " (" + issue.Error + ")"
+		}
+
+		fmt.Fprintf(writer, "%-18s %s%s\n", issue.Kind, issue.Path, status)
+	}
+
+	kinds := make([]string, 0, len(report.Summary))
+	for kind := range report.Summary {
+		kinds = append(kinds, kind)
+	}
+
+	sort.Strings(kinds)
+
+	counts := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		counts = append(counts, fmt.Sprintf("%d %s", report.Summary[kind], kind))
+	}
+
+	if len(counts) == 0 {
+		counts = append(counts, "no issues")
+	}
+
+	fmt.Fprintf(writer, "%s, %d fixed\n", strings.Join(counts, ", "), report.Fixed)
+
+	return nil
+}
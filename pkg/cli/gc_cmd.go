@@ -0,0 +1,235 @@
+// +build extended
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+const gcPlanEndpoint = "/v2/_zot/admin/gc/plan"
+
+func newGCPlanCommand() *cobra.Command {
+	var servURL, user, outputFormat string
+
+	planCmd := &cobra.Command{
+		Use:   "plan [config-name]",
+		Short: "Show what tag expiry and GC of a running zot server would remove",
+		Long: `Show, for each image store of a zot server, the expired tags which would be removed, the manifests and
+blobs garbage collection would then remove since no tag references them anymore, and the disk space this
+would reclaim. Nothing is removed. Admin credentials are needed if the server has authentication enabled.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			endPoint, username, password, verifyTLS, err := gcEndpointFromCommand(cmd, args, user)
+			if err != nil {
+				return err
+			}
+
+			plans := map[string]storage.GCPlan{}
+
+			if _, err := makeGETRequest(endPoint, username, password, verifyTLS, &plans); err != nil {
+				return err
+			}
+
+			return printGCPlans(cmd.OutOrStdout(), plans, outputFormat)
+		},
+	}
+
+	planCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	planCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	planCmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Specify output format [text/json/yaml]")
+	planCmd.Flags().Bool(insecureHTTPFlag, false,
+		"Fall back to plain HTTP if the server URL has no scheme and the server doesn't speak HTTPS")
+
+	planCmd.ValidArgsFunction = completeConfigNames
+
+	return planCmd
+}
+
+func gcEndpointFromCommand(cmd *cobra.Command, args []string, user string) (string, string, string, bool, error) {
+	serverURL, verifyTLS, err := serverFromCommand(cmd, args)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return "", "", "", false, err
+	}
+
+	if serverURL == "" {
+		return "", "", "", false, zotErrors.ErrNoURLProvided
+	}
+
+	endPoint, err := combineServerAndEndpointURL(serverURL, gcPlanEndpoint)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return "", "", "", false, err
+	}
+
+	cmd.SilenceUsage = true
+
+	username, password := getUsernameAndPassword(user)
+
+	return endPoint, username, password, verifyTLS, nil
+}
+
+func printGCPlans(writer io.Writer, plans map[string]storage.GCPlan, outputFormat string) error {
+	switch strings.ToLower(outputFormat) {
+	case "", defaultOutoutFormat:
+	case jsonOutputFormat:
+		body, err := json.MarshalIndent(plans, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(writer, string(body))
+
+		return nil
+	case ymlOutputFormat, yamlOutputFormat:
+		body, err := yaml.Marshal(plans)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(writer, string(body))
+
+		return nil
+	default:
+		return ErrInvalidOutputFormat
+	}
+
+	routes := make([]string, 0, len(plans))
+	for route := range plans {
+		routes = append(routes, route)
+	}
+
+	// the default image store "/" comes first
+	sort.Strings(routes)
+
+	stores := newGCPlanTableLayout()
+	stores.printHeader(writer)
+
+	table := stores.newTable(writer)
+
+	for _, route := range routes {
+		plan := plans[route]
+
+		table.Append(stores.row(map[string]string{
+			columnStore:     route,
+			columnGC:        strconv.FormatBool(plan.GC),
+			columnTags:      strconv.Itoa(len(plan.Tags)),
+			columnManifests: strconv.Itoa(len(plan.Manifests)),
+			columnBlobs:     strconv.Itoa(len(plan.Blobs)),
+			columnReclaimed: formatBytes(plan.ReclaimedBytes),
+		}))
+	}
+
+	table.Render()
+
+	tags := newGCPlanTagTableLayout()
+	blobs := newGCPlanBlobTableLayout()
+
+	for _, route := range routes {
+		plan := plans[route]
+
+		if len(plan.Tags) > 0 {
+			fmt.Fprintf(writer, "\nExpired tags of %s:\n", route)
+			tags.printHeader(writer)
+
+			table := tags.newTable(writer)
+
+			for _, tag := range plan.Tags {
+				table.Append(tags.row(map[string]string{
+					columnName:    tag.Repo,
+					columnTag:     tag.Tag,
+					columnDigest:  tag.Digest.Encoded(),
+					columnExpires: humanize.Time(tag.Expires),
+				}))
+			}
+
+			table.Render()
+		}
+
+		if len(plan.Manifests) == 0 && len(plan.Blobs) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(writer, "\nUnreferenced blobs of %s:\n", route)
+		blobs.printHeader(writer)
+
+		table := blobs.newTable(writer)
+
+		for _, kind := range []struct {
+			name  string
+			blobs []storage.GCPlanBlob
+		}{{"manifest", plan.Manifests}, {"blob", plan.Blobs}} {
+			for _, blob := range kind.blobs {
+				table.Append(blobs.row(map[string]string{
+					columnKind:   kind.name,
+					columnName:   blob.Repo,
+					columnDigest: blob.Digest.Encoded(),
+					columnSize:   formatBytes(blob.Size),
+				}))
+			}
+		}
+
+		table.Render()
+	}
+
+	return nil
+}
+
+// newGCPlanTableLayout returns the layout of the table of image stores, values aren't truncated.
+func newGCPlanTableLayout() *tableLayout {
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnStore, header: "IMAGE STORE", minWidth: imageNameWidth},
+			{name: columnGC, header: "GC", minWidth: countWidth},
+			{name: columnTags, header: "TAGS", minWidth: countWidth},
+			{name: columnManifests, header: "MANIFESTS", minWidth: countWidth},
+			{name: columnBlobs, header: "BLOBS", minWidth: countWidth},
+			{name: columnReclaimed, header: "RECLAIMED", minWidth: sizeWidth},
+		},
+	}
+}
+
+// newGCPlanTagTableLayout returns the layout of the table of the expired tags of an image store.
+func newGCPlanTagTableLayout() *tableLayout {
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnName, header: "REPOSITORY", minWidth: imageNameWidth},
+			{name: columnTag, header: "TAG", minWidth: tagWidth},
+			{name: columnDigest, header: "DIGEST", width: digestWidth, minWidth: digestWidth},
+			{name: columnExpires, header: "EXPIRED", minWidth: updatedWidth},
+		},
+	}
+}
+
+// newGCPlanBlobTableLayout returns the layout of the table of the unreferenced manifests and blobs of an
+// image store.
+func newGCPlanBlobTableLayout() *tableLayout {
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnKind, header: "KIND", minWidth: countWidth},
+			{name: columnName, header: "REPOSITORY", minWidth: imageNameWidth},
+			{name: columnDigest, header: "DIGEST", width: digestWidth, minWidth: digestWidth},
+			{name: columnSize, header: "SIZE", minWidth: sizeWidth},
+		},
+	}
+}
+
+const (
+	columnGC        = "gc"
+	columnTags      = "tags"
+	columnManifests = "manifests"
+	columnReclaimed = "reclaimed"
+	columnExpires   = "expires"
+	columnKind      = "kind"
+)
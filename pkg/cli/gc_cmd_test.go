@@ -0,0 +1,124 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/pkg/api"
+	"github.com/anuvu/zot/pkg/storage"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGCCmd(t *testing.T) {
+	Convey("Test gc plan from real server", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		So(copyFiles("../../test/data/zot-cve-test", path.Join(dir, "a")), ShouldBeNil)
+
+		// the only tag of the repository expired a while ago, and its blobs are past the GC delay
+		buf, err := ioutil.ReadFile(path.Join(dir, "a", "index.json"))
+		So(err, ShouldBeNil)
+
+		var index ispec.Index
+		So(json.Unmarshal(buf, &index), ShouldBeNil)
+
+		past := time.Now().Add(-2 * time.Hour)
+		index.Manifests[0].Annotations[storage.AnnotationTagExpires] = past.UTC().Format(time.RFC3339)
+
+		buf, err = json.Marshal(index)
+		So(err, ShouldBeNil)
+		So(ioutil.WriteFile(path.Join(dir, "a", "index.json"), buf, 0600), ShouldBeNil)
+
+		err = filepath.Walk(path.Join(dir, "a", "blobs"), func(file string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			return os.Chtimes(file, past, past)
+		})
+		So(err, ShouldBeNil)
+
+		url, c := startTestServer(dir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		// tag expiry runs once the server starts, the expired tag is put back once it has been removed
+		// so that the plan has one to show
+		for {
+			current, err := ioutil.ReadFile(path.Join(dir, "a", "index.json"))
+			So(err, ShouldBeNil)
+
+			var currentIndex ispec.Index
+			So(json.Unmarshal(current, &currentIndex), ShouldBeNil)
+
+			if len(currentIndex.Manifests) == 0 {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		So(ioutil.WriteFile(path.Join(dir, "a", "index.json"), buf, 0600), ShouldBeNil)
+
+		cmd := NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"gc", "plan", "--url", url})
+		So(cmd.Execute(), ShouldBeNil)
+
+		lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+		So(len(lines), ShouldEqual, 12)
+		So(strings.Fields(lines[0]), ShouldResemble, []string{"IMAGE", "STORE", "GC", "TAGS", "MANIFESTS", "BLOBS",
+			"RECLAIMED"})
+		So(strings.Fields(lines[1])[:5], ShouldResemble, []string{"/", "true", "1", "1", "2"})
+		So(lines[3], ShouldEqual, "Expired tags of /:")
+		So(strings.Fields(lines[5])[:2], ShouldResemble, []string{"a", "0.0.1"})
+		So(lines[7], ShouldEqual, "Unreferenced blobs of /:")
+		So(strings.Fields(lines[9])[:2], ShouldResemble, []string{"manifest", "a"})
+		So(strings.Fields(lines[10])[:2], ShouldResemble, []string{"blob", "a"})
+
+		cmd = NewRootCmd()
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"gc", "plan", "--url", url, "-o", "json"})
+		So(cmd.Execute(), ShouldBeNil)
+
+		plans := map[string]storage.GCPlan{}
+		So(json.Unmarshal(buff.Bytes(), &plans), ShouldBeNil)
+		So(plans["/"].ReclaimedBytes, ShouldBeGreaterThan, 0)
+
+		// nothing was removed
+		_, err = os.Stat(path.Join(dir, "a", "blobs", plans["/"].Manifests[0].Digest.Algorithm().String(),
+			plans["/"].Manifests[0].Digest.Encoded()))
+		So(err, ShouldBeNil)
+
+		cmd = NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"gc", "plan"})
+		So(cmd.Execute(), ShouldNotBeNil)
+
+		cmd = NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"gc", "plan", "--url", url, "-o", "random"})
+		So(cmd.Execute(), ShouldNotBeNil)
+	})
+}
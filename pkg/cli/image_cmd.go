@@ -9,6 +9,7 @@ import (
 	"time"
 
 	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/extensions/search/semver"
 	"github.com/briandowns/spinner"
 	"github.com/spf13/cobra"
 )
@@ -16,14 +17,19 @@ import (
 func NewImageCommand(searchService SearchService) *cobra.Command {
 	searchImageParams := make(map[string]*string)
 
-	var servURL, user, outputFormat string
+	var servURL, user, outputFormat, format, latest string
 
-	var isSpinner, verifyTLS, verbose bool
+	var columns []string
+
+	var isSpinner, verifyTLS, verbose, withCVE, provenance bool
 
 	var imageCmd = &cobra.Command{
-		Use:   "images [config-name]",
-		Short: "List hosted images",
-		Long:  `List images hosted on zot`,
+		Use:     "images [config-name]",
+		Aliases: []string{"image"},
+		Short:   "List hosted images",
+		Long:    `List images hosted on zot`,
+		// the config name isn't a subcommand
+		Args: cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			home, err := os.UserHomeDir()
 			if err != nil {
@@ -61,6 +67,41 @@ func NewImageCommand(searchService SearchService) *cobra.Command {
 				}
 			}
 
+			if err := configureHTTPClients(configPath, args); err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			servURL, err = resolveCommandURL(cmd, configPath, args, servURL, verifyTLS)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			tmpl, err := parseFormatTemplate(format, outputFormat)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			if (withCVE || provenance) && len(columns) == 0 && isTableFormat(outputFormat) {
+				columns = defaultImageColumns(outputFormat, verbose)
+
+				if withCVE {
+					columns = append(columns, columnCVEs)
+				}
+
+				if provenance {
+					columns = append(columns, columnGitVersion)
+				}
+			}
+
+			imageTable, err := newImageTableLayout(columns, outputFormat, verbose, terminalWidth(cmd.OutOrStdout()))
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
 			spin := spinner.New(spinner.CharSets[39], spinnerDuration, spinner.WithWriter(cmd.ErrOrStderr()))
 			spin.Prefix = "Searching... "
 
@@ -70,10 +111,33 @@ func NewImageCommand(searchService SearchService) *cobra.Command {
 				servURL:       &servURL,
 				user:          &user,
 				outputFormat:  &outputFormat,
+				template:      tmpl,
+				imageTable:    imageTable,
 				verbose:       &verbose,
 				spinner:       spinnerState{spin, isSpinner},
 				verifyTLS:     &verifyTLS,
 				resultWriter:  cmd.OutOrStdout(),
+				provenance:    provenance,
+			}
+
+			if withCVE || imageTable.has(columnCVEs) {
+				searchConfig.cveSummaries = newCVESummaries()
+			}
+
+			if cmd.Flags().Changed("latest") {
+				// the newest tags are picked among the tags of the listed repositories
+				for key, value := range searchImageParams {
+					if key != "imageName" && *value != "" {
+						return zotErrors.ErrInvalidFlagsCombination
+					}
+				}
+
+				constraint, err := semver.ParseConstraint(latest)
+				if err != nil {
+					return err
+				}
+
+				searchConfig.latest = &constraint
 			}
 
 			err = searchImage(searchConfig)
@@ -87,8 +151,23 @@ func NewImageCommand(searchService SearchService) *cobra.Command {
 		},
 	}
 
-	setupImageFlags(imageCmd, searchImageParams, &servURL, &user, &outputFormat, &verbose)
-	imageCmd.SetUsageTemplate(imageCmd.UsageTemplate() + usageFooter)
+	setupImageFlags(imageCmd, searchImageParams, &servURL, &user, &outputFormat, &format, &verbose)
+	imageCmd.Flags().StringSliceVar(&columns, "columns", nil, "Comma separated list of table columns, in display order"+
+		" [name/tag/digest/config/layers/size/updated/warning/cves/gitversion]")
+	imageCmd.Flags().BoolVar(&withCVE, "with-cve", false, "Show the number of critical, high, medium and low CVEs"+
+		" found by the last scan of each image")
+	imageCmd.Flags().BoolVar(&provenance, "provenance", false, "Show the git version and the stacker.yaml"+
+		" each image was built from")
+	imageCmd.Flags().StringVar(&latest, "latest", "", "List only the newest tag of each repository which is a"+
+		` semantic version, in a range if given, e.g. --latest='^1.2'`)
+	imageCmd.Flags().Lookup("latest").NoOptDefVal = "*"
+
+	imageCmd.AddCommand(newImageDiffCommand())
+
+	imageCmd.ValidArgsFunction = completeConfigNames
+	_ = imageCmd.RegisterFlagCompletionFunc("name", completeRepoNames)
+
+	imageCmd.SetUsageTemplate(imageCmd.UsageTemplate() + usageFooter + exitCodesUsage)
 
 	return imageCmd
 }
@@ -108,18 +187,32 @@ func parseBooleanConfig(configPath, configName, configParam string) (bool, error
 }
 
 func setupImageFlags(imageCmd *cobra.Command, searchImageParams map[string]*string,
-	servURL, user, outputFormat *string, verbose *bool) {
+	servURL, user, outputFormat, format *string, verbose *bool) {
 	searchImageParams["imageName"] = imageCmd.Flags().StringP("name", "n", "", "List image details by name")
 	searchImageParams["digest"] = imageCmd.Flags().StringP("digest", "d", "",
 		"List images containing a specific manifest, config, or layer digest")
+	searchImageParams["annotation"] = imageCmd.Flags().StringP("annotation", "a", "",
+		`List images whose manifest has an annotation, in "key" or "key=value" format`)
+	searchImageParams["gitVersion"] = imageCmd.Flags().String("git-version", "",
+		"List images built by stacker from a git version")
 
 	imageCmd.Flags().StringVar(servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
 	imageCmd.Flags().StringVarP(user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
-	imageCmd.Flags().StringVarP(outputFormat, "output", "o", "", "Specify output format [text/json/yaml]")
+	imageCmd.Flags().StringVarP(outputFormat, "output", "o", "", "Specify output format [text/wide/json/ndjson/yaml]")
+	imageCmd.Flags().StringVar(format, "format", "", "Format each tag using a Go template, e.g. "+
+		`'{{.Name}}:{{.Tag}} {{.Digest}}'. Fields: Name, Tag, Digest, ConfigDigest, Size, Layers, GitVersion`+
+		` and StackerYaml with --provenance`)
 	imageCmd.Flags().BoolVar(verbose, "verbose", false, "Show verbose output")
 }
 
 func searchImage(searchConfig searchConfig) error {
+	if searchConfig.cveSummaries != nil {
+		username, password := getUsernameAndPassword(*searchConfig.user)
+		if err := checkCVEEnabled(searchConfig, username, password); err != nil {
+			return err
+		}
+	}
+
 	for _, searcher := range getImageSearchers() {
 		found, err := searcher.search(searchConfig)
 		if found {
@@ -1,3 +1,4 @@
+//go:build extended
 // +build extended
 
 package cli //nolint:testpackage
@@ -6,6 +7,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -23,6 +25,7 @@ import (
 	"github.com/anuvu/zot/pkg/api"
 	"github.com/anuvu/zot/pkg/compliance/v1_0_0"
 	"github.com/anuvu/zot/pkg/extensions"
+	"github.com/anuvu/zot/pkg/storage"
 	godigest "github.com/opencontainers/go-digest"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/phayes/freeport"
@@ -243,6 +246,23 @@ func TestOutputFormat(t *testing.T) {
 		So(err, ShouldBeNil)
 	})
 
+	Convey("Test ndjson", t, func() {
+		args := []string{"imagetest", "--name", "dummyImageName", "-o", "ndjson"}
+
+		configPath := makeConfigFile(`{"configs":[{"_name":"imagetest","url":"https://test-url.com","showspinner":false}]}`)
+		defer os.Remove(configPath)
+
+		cmd := NewImageCommand(new(mockService))
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		So(err, ShouldBeNil)
+		So(buff.String(), ShouldEqual, `{"name":"dummyImageName","tags":[{"name":"tag","size":123445,`+
+			`"digest":"DigestsAreReallyLong","configDigest":"","layerDigests":null}]}`+"\n")
+	})
+
 	Convey("Test yaml", t, func() {
 		args := []string{"imagetest", "--name", "dummyImageName", "-o", "yaml"}
 
@@ -296,6 +316,240 @@ func TestOutputFormat(t *testing.T) {
 		So(err, ShouldNotBeNil)
 		So(buff.String(), ShouldContainSubstring, "invalid output format")
 	})
+
+	Convey("Test wide", t, func() {
+		args := []string{"imagetest", "--name", "dummyImageName", "-o", "wide"}
+
+		configPath := makeConfigFile(`{"configs":[{"_name":"imagetest","url":"https://test-url.com","showspinner":false}]}`)
+		defer os.Remove(configPath)
+
+		cmd := NewImageCommand(new(mockService))
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		space := regexp.MustCompile(`\s+`)
+		str := space.ReplaceAllString(buff.String(), " ")
+		So(strings.TrimSpace(str), ShouldEqual, "IMAGE NAME TAG DIGEST CONFIG LAYERS SIZE UPDATED WARNING"+
+			" dummyImageName tag DigestsAreReallyLong 0 123kB")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Test columns", t, func() {
+		args := []string{"imagetest", "--name", "dummyImageName", "--columns", "tag,size", "--columns", "DIGEST"}
+
+		configPath := makeConfigFile(`{"configs":[{"_name":"imagetest","url":"https://test-url.com","showspinner":false}]}`)
+		defer os.Remove(configPath)
+
+		cmd := NewImageCommand(new(mockService))
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		space := regexp.MustCompile(`\s+`)
+		str := space.ReplaceAllString(buff.String(), " ")
+		So(strings.TrimSpace(str), ShouldEqual, "TAG SIZE DIGEST tag 123kB DigestsA")
+		So(err, ShouldBeNil)
+
+		Convey("Test unknown column", func() {
+			args := []string{"imagetest", "--name", "dummyImageName", "--columns", "tag,bogus"}
+			cmd := NewImageCommand(new(mockService))
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err := cmd.Execute()
+			So(err, ShouldNotBeNil)
+			So(buff.String(), ShouldContainSubstring, "unknown table column: bogus")
+		})
+
+		Convey("Test columns with json", func() {
+			args := []string{"imagetest", "--name", "dummyImageName", "--columns", "tag", "-o", "json"}
+			cmd := NewImageCommand(new(mockService))
+			cmd.SetOut(ioutil.Discard)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs(args)
+			err := cmd.Execute()
+			So(err, ShouldEqual, zotErrors.ErrInvalidFlagsCombination)
+		})
+	})
+
+	Convey("Test with CVE", t, func() {
+		args := []string{"imagetest", "--name", "dummyImageName", "--with-cve"}
+
+		configPath := makeConfigFile(`{"configs":[{"_name":"imagetest","url":"https://test-url.com","showspinner":false}]}`)
+		defer os.Remove(configPath)
+
+		cmd := NewImageCommand(new(mockService))
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		space := regexp.MustCompile(`\s+`)
+		str := space.ReplaceAllString(buff.String(), " ")
+		So(strings.TrimSpace(str), ShouldEqual, "IMAGE NAME TAG DIGEST SIZE CVES C/H/M/L"+
+			" dummyImageName tag DigestsA 123kB -")
+		So(err, ShouldBeNil)
+
+		Convey("Test compact summary", func() {
+			summary := &cveSummary{Count: 7, MaxSeverity: "CRITICAL", Critical: 1, High: 2, Medium: 3, Unknown: 1}
+			So(summary.compact(), ShouldEqual, "1/2/3/0")
+		})
+	})
+
+	Convey("Test CVE wide", t, func() {
+		args := []string{"cvetest", "-I", "dummyImageName:tag", "-o", "wide"}
+
+		configPath := makeConfigFile(`{"configs":[{"_name":"cvetest","url":"https://test-url.com","showspinner":false}]}`)
+		defer os.Remove(configPath)
+
+		cmd := NewCveCommand(new(mockService))
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		space := regexp.MustCompile(`\s+`)
+		str := space.ReplaceAllString(buff.String(), " ")
+		So(strings.TrimSpace(str), ShouldEqual, "ID SEVERITY TITLE dummyCVEID HIGH Title of that CVE")
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestTableLayout(t *testing.T) {
+	Convey("Test terminal width", t, func() {
+		widths := func(layout *tableLayout) []int {
+			widths := []int{}
+			for _, column := range layout.columns {
+				widths = append(widths, column.width)
+			}
+
+			return widths
+		}
+
+		layout, err := newImageTableLayout(nil, "", false, 0)
+		So(err, ShouldBeNil)
+		So(widths(layout), ShouldResemble, []int{imageNameWidth, tagWidth, digestWidth, sizeWidth})
+
+		// 60 columns leave 38 characters for the name and tag after the fixed columns and padding
+		layout, err = newImageTableLayout(nil, "", false, 60)
+		So(err, ShouldBeNil)
+		So(widths(layout), ShouldResemble, []int{21, 16, digestWidth, sizeWidth})
+
+		layout, err = newImageTableLayout(nil, "", false, 1000)
+		So(err, ShouldBeNil)
+		So(widths(layout), ShouldResemble, []int{2 * imageNameWidth, 2 * tagWidth, digestWidth, sizeWidth})
+
+		layout, err = newImageTableLayout(nil, "", false, 10)
+		So(err, ShouldBeNil)
+		So(widths(layout), ShouldResemble, []int{len("IMAGE NAME"), minFlexibleWidth, digestWidth, sizeWidth})
+
+		// wide output is never truncated
+		layout, err = newImageTableLayout(nil, "wide", false, 60)
+		So(err, ShouldBeNil)
+		So(widths(layout), ShouldResemble, []int{0, 0, 0, 0, 0, 0, 0, 0})
+
+		layout = newCVETableLayout("", 100)
+		So(widths(layout), ShouldResemble, []int{cveIDWidth, cveSeverityWidth, 100 - cveIDWidth - cveSeverityWidth - 4})
+	})
+
+	Convey("Test rows", t, func() {
+		layout, err := newImageTableLayout([]string{"name", "digest"}, "", false, 0)
+		So(err, ShouldBeNil)
+		So(layout.row(map[string]string{
+			columnName:   strings.Repeat("a", 40),
+			columnDigest: "0123456789",
+			columnSize:   "1MB",
+		}), ShouldResemble, []string{strings.Repeat("a", imageNameWidth-len(ellipsis)) + ellipsis, "01234567"})
+		So(layout.has(columnDigest), ShouldBeTrue)
+		So(layout.has(columnUpdated), ShouldBeFalse)
+	})
+}
+
+func TestFormatTemplate(t *testing.T) {
+	Convey("Test image template", t, func() {
+		args := []string{"imagetest", "--name", "dummyImageName",
+			"--format", "{{.Name}}:{{.Tag}} {{.Digest}} {{humanize .Size}}"}
+
+		configPath := makeConfigFile(`{"configs":[{"_name":"imagetest","url":"https://test-url.com","showspinner":false}]}`)
+		defer os.Remove(configPath)
+
+		cmd := NewImageCommand(new(mockService))
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		So(err, ShouldBeNil)
+		So(buff.String(), ShouldEqual, "dummyImageName:tag DigestsAreReallyLong 123kB\n")
+	})
+
+	Convey("Test CVE template", t, func() {
+		args := []string{"cvetest", "-I", "dummyImageName:tag", "--format", `{{.Tag}} {{.ID}} {{lower .Severity}}` +
+			` {{range .PackageList}}{{.Name}}@{{.FixedVersion}}{{end}}`}
+
+		configPath := makeConfigFile(`{"configs":[{"_name":"cvetest","url":"https://test-url.com","showspinner":false}]}`)
+		defer os.Remove(configPath)
+
+		cmd := NewCveCommand(new(mockService))
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		So(err, ShouldBeNil)
+		So(buff.String(), ShouldEqual, "dummyImageName:tag dummyCVEID high packagename@fixedver\n")
+	})
+
+	Convey("Test json function", t, func() {
+		args := []string{"imagetest", "--name", "dummyImageName", "--format", "{{json .Layers}}"}
+
+		configPath := makeConfigFile(`{"configs":[{"_name":"imagetest","url":"https://test-url.com","showspinner":false}]}`)
+		defer os.Remove(configPath)
+
+		cmd := NewImageCommand(new(mockService))
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		So(err, ShouldBeNil)
+		So(buff.String(), ShouldEqual, "null\n")
+	})
+
+	Convey("Test invalid template", t, func() {
+		configPath := makeConfigFile(`{"configs":[{"_name":"imagetest","url":"https://test-url.com","showspinner":false}]}`)
+		defer os.Remove(configPath)
+
+		for _, format := range []string{"{{.Name", "{{.Unknown}}"} {
+			args := []string{"imagetest", "--name", "dummyImageName", "--format", format}
+			cmd := NewImageCommand(new(mockService))
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err := cmd.Execute()
+			So(err, ShouldNotBeNil)
+			So(buff.String(), ShouldContainSubstring, "invalid format template")
+		}
+	})
+
+	Convey("Test template with output format", t, func() {
+		args := []string{"imagetest", "--name", "dummyImageName", "--format", "{{.Name}}", "-o", "json"}
+
+		configPath := makeConfigFile(`{"configs":[{"_name":"imagetest","url":"https://test-url.com","showspinner":false}]}`)
+		defer os.Remove(configPath)
+
+		cmd := NewImageCommand(new(mockService))
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		So(err, ShouldEqual, zotErrors.ErrInvalidFlagsCombination)
+	})
 }
 
 func TestServerResponse(t *testing.T) {
@@ -337,6 +591,79 @@ func TestServerResponse(t *testing.T) {
 
 		uploadManifest(url)
 
+		Convey("Test wide output and columns", func() {
+			created := time.Now().Add(-2 * time.Hour)
+			configDigest := uploadImageWithConfig(url, "repo8", "1.0", created, nil)
+			configPath := makeConfigFile(fmt.Sprintf(`{"configs":[{"_name":"imagetest","url":"%s","showspinner":false}]}`, url))
+			defer os.Remove(configPath)
+
+			args := []string{"imagetest", "--name", "repo8", "-o", "wide"}
+			cmd := NewImageCommand(new(searchService))
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			space := regexp.MustCompile(`\s+`)
+			str := space.ReplaceAllString(buff.String(), " ")
+			actual := strings.TrimSpace(str)
+			So(actual, ShouldStartWith, "IMAGE NAME TAG DIGEST CONFIG LAYERS SIZE UPDATED WARNING repo8 1.0 ")
+			So(actual, ShouldContainSubstring, configDigest.Hex()+" 1 ")
+			So(actual, ShouldEndWith, " 2 hours ago")
+
+			args = []string{"imagetest", "--name", "repo8", "--columns", "updated,tag"}
+			cmd = NewImageCommand(new(searchService))
+			buff = bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			str = space.ReplaceAllString(buff.String(), " ")
+			So(strings.TrimSpace(str), ShouldEqual, "UPDATED TAG 2 hours ago 1.0")
+
+			// the config of repo7 images isn't a valid image config
+			args = []string{"imagetest", "--name", "repo7", "--columns", "tag,updated,size"}
+			cmd = NewImageCommand(new(searchService))
+			buff = bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			str = space.ReplaceAllString(buff.String(), " ")
+			So(str, ShouldContainSubstring, "TAG UPDATED SIZE")
+			So(str, ShouldContainSubstring, "test:1.0 15B")
+
+			// deprecated images get a warning
+			err = c.StoreController.DefaultStore.SetDeprecation("repo8", "",
+				&storage.Deprecation{Message: "no longer maintained", Replacement: "repo9:1.0"})
+			So(err, ShouldBeNil)
+
+			args = []string{"imagetest", "--name", "repo8", "--columns", "tag,warning"}
+			cmd = NewImageCommand(new(searchService))
+			buff = bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			str = space.ReplaceAllString(buff.String(), " ")
+			So(strings.TrimSpace(str), ShouldStartWith, "TAG WARNING 1.0 deprecated: no longer")
+
+			args = []string{"imagetest", "--name", "repo8", "-o", "json"}
+			cmd = NewImageCommand(new(searchService))
+			buff = bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldContainSubstring,
+				`"warning": "deprecated: no longer maintained, use repo9:1.0 instead"`)
+		})
+
 		Convey("Test all images config url", func() {
 			args := []string{"imagetest"}
 			configPath := makeConfigFile(fmt.Sprintf(`{"configs":[{"_name":"imagetest","url":"%s","showspinner":false}]}`, url))
@@ -460,6 +787,169 @@ func TestServerResponse(t *testing.T) {
 			})
 		})
 
+		Convey("Test image by annotation", func() {
+			created := time.Now()
+			gitVersion := "ws.tycho.stacker.git_version"
+			uploadImageWithConfig(url, "repo9", "1.0", created, map[string]string{gitVersion: "v1.0"})
+			uploadImageWithConfig(url, "repo9", "2.0", created, map[string]string{gitVersion: "v2.0"})
+			configPath := makeConfigFile(fmt.Sprintf(`{"configs":[{"_name":"imagetest","url":"%s","showspinner":false}]}`, url))
+			defer os.Remove(configPath)
+
+			args := []string{"imagetest", "--annotation", gitVersion + "=v1.0", "--columns", "name,tag"}
+			cmd := NewImageCommand(new(searchService))
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			space := regexp.MustCompile(`\s+`)
+			str := space.ReplaceAllString(buff.String(), " ")
+			So(strings.TrimSpace(str), ShouldEqual, "IMAGE NAME TAG repo9 1.0")
+
+			// any value of the annotation matches when only the key is given
+			args = []string{"imagetest", "-a", gitVersion, "--columns", "name,tag"}
+			cmd = NewImageCommand(new(searchService))
+			buff = bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			str = space.ReplaceAllString(buff.String(), " ")
+			So(str, ShouldContainSubstring, "repo9 1.0")
+			So(str, ShouldContainSubstring, "repo9 2.0")
+			So(str, ShouldNotContainSubstring, "repo7")
+
+			args = []string{"imagetest", "-a", gitVersion + "=v3.0"}
+			cmd = NewImageCommand(new(searchService))
+			buff = bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			So(strings.TrimSpace(buff.String()), ShouldBeEmpty)
+
+			args = []string{"imagetest", "-a", "=v1.0"}
+			cmd = NewImageCommand(new(searchService))
+			buff = bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldEqual, errInvalidAnnotation)
+		})
+
+		Convey("Test image provenance and by git version", func() {
+			created := time.Now()
+			uploadImageWithConfig(url, "repo10", "1.0", created, map[string]string{
+				"ws.tycho.stacker.git_version":  "v1.0-3-g0123456",
+				"ws.tycho.stacker.stacker_yaml": "build:\n  from:\n    type: docker\n",
+			})
+			uploadImageWithConfig(url, "repo10", "2.0", created, nil)
+			configPath := makeConfigFile(fmt.Sprintf(`{"configs":[{"_name":"imagetest","url":"%s","showspinner":false}]}`, url))
+			defer os.Remove(configPath)
+
+			args := []string{"imagetest", "--git-version", "v1.0-3-g0123456", "--provenance"}
+			cmd := NewImageCommand(new(searchService))
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldContainSubstring, "    type: docker")
+			space := regexp.MustCompile(`\s+`)
+			str := space.ReplaceAllString(buff.String(), " ")
+			So(str, ShouldContainSubstring, "IMAGE NAME TAG DIGEST SIZE GIT VERSION")
+			So(str, ShouldContainSubstring, "v1.0-3-g0123456 build: from: type: docker")
+			So(str, ShouldNotContainSubstring, "repo10 2.0")
+
+			args = []string{"imagetest", "-n", "repo10", "--provenance", "-o", "json"}
+			cmd = NewImageCommand(new(searchService))
+			buff = bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldContainSubstring, `"gitVersion": "v1.0-3-g0123456"`)
+			So(strings.Count(buff.String(), `"buildInfo"`), ShouldEqual, 1)
+
+			args = []string{"imagetest", "-n", "repo10", "--provenance", "--format", "{{.Tag}}={{.GitVersion}}"}
+			cmd = NewImageCommand(new(searchService))
+			buff = bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldContainSubstring, "1.0=v1.0-3-g0123456")
+			So(buff.String(), ShouldContainSubstring, "2.0=\n")
+
+			// without --provenance the build info isn't shown
+			args = []string{"imagetest", "-n", "repo10", "-o", "json"}
+			cmd = NewImageCommand(new(searchService))
+			buff = bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldNotContainSubstring, "buildInfo")
+
+			args = []string{"imagetest", "--git-version", "v9.9"}
+			cmd = NewImageCommand(new(searchService))
+			buff = bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(buff)
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+			So(err, ShouldBeNil)
+			So(strings.TrimSpace(buff.String()), ShouldBeEmpty)
+		})
+
+		Convey("Test latest image", func() {
+			created := time.Now()
+			for _, tag := range []string{"1.2.0", "1.10.0", "2.0.0-rc.1", "latest"} {
+				uploadImageWithConfig(url, "repo10", tag, created, nil)
+			}
+
+			configPath := makeConfigFile(fmt.Sprintf(`{"configs":[{"_name":"imagetest","url":"%s","showspinner":false}]}`, url))
+			defer os.Remove(configPath)
+
+			images := func(args ...string) (string, error) {
+				cmd := NewImageCommand(new(searchService))
+				buff := bytes.NewBufferString("")
+				cmd.SetOut(buff)
+				cmd.SetErr(buff)
+				cmd.SetArgs(append([]string{"imagetest", "--columns", "name,tag"}, args...))
+				err := cmd.Execute()
+
+				return strings.TrimSpace(regexp.MustCompile(`\s+`).ReplaceAllString(buff.String(), " ")), err
+			}
+
+			actual, err := images("--name", "repo10", "--latest")
+			So(err, ShouldBeNil)
+			So(actual, ShouldEqual, "IMAGE NAME TAG repo10 1.10.0")
+
+			actual, err = images("-n", "repo10", "--latest=>=2.0.0-rc.0")
+			So(err, ShouldBeNil)
+			So(actual, ShouldEqual, "IMAGE NAME TAG repo10 2.0.0-rc.1")
+
+			// repositories without a tag in the range aren't listed
+			actual, err = images("--latest=~1.2")
+			So(err, ShouldBeNil)
+			So(actual, ShouldEqual, "IMAGE NAME TAG repo10 1.2.0")
+
+			_, err = images("--latest=latest")
+			So(errors.Is(err, zotErrors.ErrBadSemverConstraint), ShouldBeTrue)
+
+			_, err = images("--latest", "--digest", "a0ca253b")
+			So(err, ShouldEqual, zotErrors.ErrInvalidFlagsCombination)
+		})
+
 		Convey("Test image by name invalid name", func() {
 			args := []string{"imagetest", "--name", "repo777"}
 			configPath := makeConfigFile(fmt.Sprintf(`{"configs":[{"_name":"imagetest","url":"%s","showspinner":false}]}`, url))
@@ -477,6 +967,122 @@ func TestServerResponse(t *testing.T) {
 	})
 }
 
+func TestServerWithoutSearch(t *testing.T) {
+	Convey("Test images are listed from the catalog when search isn't enabled", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		url, c := startTestServer(dir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		uploadManifest(url)
+
+		created := time.Now()
+		uploadImageWithConfig(url, "repo9", "1.0", created, map[string]string{gitVersionAnnotation: "v1.0"})
+		uploadImageWithConfig(url, "repo9", "2.0", created, map[string]string{gitVersionAnnotation: "v2.0"})
+
+		configPath := makeConfigFile(fmt.Sprintf(`{"configs":[{"_name":"imagetest","url":"%s","showspinner":false}]}`, url))
+		defer os.Remove(configPath)
+
+		space := regexp.MustCompile(`\s+`)
+
+		args := []string{"imagetest", "-d", "sha256:a0ca253b"}
+		cmd := NewImageCommand(new(searchService))
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(buff)
+		cmd.SetArgs(args)
+		err = cmd.Execute()
+		So(err, ShouldBeNil)
+		str := space.ReplaceAllString(buff.String(), " ")
+		So(str, ShouldContainSubstring, "repo7 test:2.0 a0ca253b 15B")
+		So(str, ShouldContainSubstring, "repo7 test:1.0 a0ca253b 15B")
+		So(str, ShouldNotContainSubstring, "repo9")
+
+		args = []string{"imagetest", "-a", gitVersionAnnotation + "=v1.0", "--columns", "name,tag"}
+		cmd = NewImageCommand(new(searchService))
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(buff)
+		cmd.SetArgs(args)
+		err = cmd.Execute()
+		So(err, ShouldBeNil)
+		str = space.ReplaceAllString(buff.String(), " ")
+		So(strings.TrimSpace(str), ShouldEqual, "IMAGE NAME TAG repo9 1.0")
+
+		args = []string{"imagetest", "--git-version", "v2.0", "--columns", "name,tag"}
+		cmd = NewImageCommand(new(searchService))
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(buff)
+		cmd.SetArgs(args)
+		err = cmd.Execute()
+		So(err, ShouldBeNil)
+		str = space.ReplaceAllString(buff.String(), " ")
+		So(strings.TrimSpace(str), ShouldEqual, "IMAGE NAME TAG repo9 2.0")
+
+		// the CVE counts can't be listed at all
+		args = []string{"imagetest", "-n", "repo9", "--with-cve"}
+		cmd = NewImageCommand(new(searchService))
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err = cmd.Execute()
+		So(errors.Is(err, zotErrors.ErrExtensionNotEnabled), ShouldBeTrue)
+
+		args = []string{"imagetest", "-I", "repo9:1.0"}
+		cmd = NewCveCommand(new(searchService))
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(args)
+		err = cmd.Execute()
+		So(errors.Is(err, zotErrors.ErrExtensionNotEnabled), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, api.CVEExtension)
+	})
+}
+
+func uploadImageWithConfig(url, repo, tag string, created time.Time, annotations map[string]string) godigest.Digest {
+	layer := []byte("this is a layer of " + repo)
+	layerDigest := godigest.FromBytes(layer)
+	resp, _ := resty.R().Post(url + "/v2/" + repo + "/blobs/uploads/")
+	_, _ = resty.R().SetQueryParam("digest", layerDigest.String()).
+		SetHeader("Content-Type", "application/octet-stream").SetBody(layer).Put(v1_0_0.Location(url, resp))
+
+	config, _ := json.Marshal(ispec.Image{Created: &created})
+	configDigest := godigest.FromBytes(config)
+	resp, _ = resty.R().Post(url + "/v2/" + repo + "/blobs/uploads/")
+	_, _ = resty.R().SetQueryParam("digest", configDigest.String()).
+		SetHeader("Content-Type", "application/octet-stream").SetBody(config).Put(v1_0_0.Location(url, resp))
+
+	m := ispec.Manifest{
+		Config: ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    configDigest,
+			Size:      int64(len(config)),
+		},
+		Layers: []ispec.Descriptor{
+			{
+				MediaType: ispec.MediaTypeImageLayer,
+				Digest:    layerDigest,
+				Size:      int64(len(layer)),
+			},
+		},
+		Annotations: annotations,
+	}
+	m.SchemaVersion = 2
+	content, _ := json.Marshal(m)
+	_, _ = resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).
+		SetBody(content).Put(url + "/v2/" + repo + "/manifests/" + tag)
+
+	return configDigest
+}
+
 func uploadManifest(url string) {
 	// create a blob/layer
 	resp, _ := resty.R().Post(url + "/v2/repo7/blobs/uploads/")
@@ -537,6 +1143,8 @@ func (service mockService) getAllImages(ctx context.Context, config searchConfig
 
 	image := &imageStruct{}
 	image.Name = "randomimageName"
+	image.tmpl = config.template
+	image.table = config.imageTable
 	image.Tags = []tags{
 		{
 			Name:   "tag",
@@ -560,6 +1168,8 @@ func (service mockService) getImageByName(ctx context.Context, config searchConf
 
 	image := &imageStruct{}
 	image.Name = imageName
+	image.tmpl = config.template
+	image.table = config.imageTable
 	image.Tags = []tags{
 		{
 			Name:   "tag",
@@ -582,6 +1192,8 @@ func (service mockService) getCveByImage(ctx context.Context, config searchConfi
 	defer close(c)
 
 	cveRes := &cveResult{}
+	cveRes.tmpl = config.template
+	cveRes.table = config.cveTable
 	cveRes.Data = cveData{
 		CVEListForImage: cveListForImage{
 			Tag: imageName,
@@ -609,6 +1221,10 @@ func (service mockService) getCveByImage(ctx context.Context, config searchConfi
 		return
 	}
 	c <- stringResult{str, nil}
+
+	if err := checkSeverityThreshold(cveRes.Data.CVEListForImage.CVEList, config.severityThreshold); err != nil {
+		c <- stringResult{"", err}
+	}
 }
 
 func (service mockService) getImagesByCveID(ctx context.Context, config searchConfig, username, password, cveID string,
@@ -621,6 +1237,16 @@ func (service mockService) getImagesByDigest(ctx context.Context, config searchC
 	service.getImageByName(ctx, config, username, password, "anImage", c, wg)
 }
 
+func (service mockService) getImagesByAnnotation(ctx context.Context, config searchConfig, username,
+	password, key string, value *string, c chan stringResult, wg *sync.WaitGroup) {
+	service.getImageByName(ctx, config, username, password, "anImage", c, wg)
+}
+
+func (service mockService) getImagesByGitVersion(ctx context.Context, config searchConfig, username,
+	password, version string, c chan stringResult, wg *sync.WaitGroup) {
+	service.getImageByName(ctx, config, username, password, "anImage", c, wg)
+}
+
 func (service mockService) getImageByNameAndCVEID(ctx context.Context, config searchConfig, username,
 	password, imageName, cveID string, c chan stringResult, wg *sync.WaitGroup) {
 	service.getImageByName(ctx, config, username, password, imageName, c, wg)
@@ -631,6 +1257,10 @@ func (service mockService) getFixedTagsForCVE(ctx context.Context, config search
 	service.getImageByName(ctx, config, username, password, imageName, c, wg)
 }
 
+func (service mockService) getExtensions(config searchConfig, username, password string) (api.ExtensionList, bool) {
+	return api.ExtensionList{}, false
+}
+
 func makeConfigFile(content string) string {
 	os.Setenv("HOME", os.TempDir())
 	home, err := os.UserHomeDir()
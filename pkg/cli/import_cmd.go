@@ -0,0 +1,624 @@
+// +build extended
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+)
+
+const (
+	importSourceHarbor    = "harbor"
+	importSourceQuay      = "quay"
+	importSourceDockerHub = "dockerhub"
+
+	dockerHubAPIURL      = "https://hub.docker.com"
+	dockerHubRegistryURL = "https://registry-1.docker.io"
+	quayURL              = "https://quay.io"
+
+	importPageSize = 100
+)
+
+// importOptions describe the organization imported by zot import remote.
+type importOptions struct {
+	source    string
+	sourceURL string
+	org       string
+	user      string
+	token     string // API token of Quay
+	platform  ispec.Platform
+}
+
+// importedImage is an image of the checkpoint, Digest being its digest in the source registry and
+// Target its digest in zot, they differ when the image was converted.
+type importedImage struct {
+	Digest godigest.Digest `json:"digest"`
+	Target godigest.Digest `json:"target"`
+}
+
+// importCheckpoint records the images already imported, so that an interrupted import is resumed
+// instead of copying everything again.
+type importCheckpoint struct {
+	Source string `json:"source"`
+	Org    string `json:"org"`
+	// Images are indexed by "repo:tag"
+	Images map[string]importedImage `json:"images"`
+	// Failures are the errors of the images which couldn't be imported, indexed by "repo:tag"
+	Failures map[string]string `json:"failures,omitempty"`
+
+	path string
+}
+
+type importReport struct {
+	imported, skipped, signatures, unsignable, failed int
+}
+
+func NewImportCommand() *cobra.Command {
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import images from other registries",
+		Long:  `Import the images of other registries into a zot server`,
+	}
+
+	importCmd.AddCommand(newImportRemoteCommand())
+
+	return importCmd
+}
+
+func newImportRemoteCommand() *cobra.Command {
+	var servURL, user, sourceUser, checkpointFile, platform string
+
+	options := importOptions{}
+
+	remoteCmd := &cobra.Command{
+		Use:   "remote [config-name]",
+		Short: "Import all the images of a Harbor project, Quay organization or Docker Hub namespace",
+		Long: `Import all the repositories and tags of a Harbor project, a Quay organization or a Docker Hub
+namespace, enumerated with the API of the registry, into a zot server. Repositories keep their name,
+e.g. "myorg/app". Manifest lists are imported as the manifest of the --platform, and Docker manifests
+are converted to OCI manifests, cosign signatures are imported along the images whose digest is kept.
+The imported images are recorded in a checkpoint file, an interrupted import is resumed by running it
+again, tags whose digest changed since are imported again.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+
+			if options.platform, err = parsePlatform(platform); err != nil {
+				return err
+			}
+
+			if options.sourceURL, err = importSourceURL(options.source, options.sourceURL); err != nil {
+				return err
+			}
+
+			client, err := registryClientFromCommand(cmd, args, user)
+			if err != nil {
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			if checkpointFile == "" {
+				checkpointFile = fmt.Sprintf("zot-import-%s-%s.json", options.source,
+					strings.ReplaceAll(options.org, "/", "-"))
+			}
+
+			checkpoint, err := loadImportCheckpoint(checkpointFile, options)
+			if err != nil {
+				return err
+			}
+
+			options.user = sourceUser
+
+			report, err := importRemote(cmd.ErrOrStderr(), client, checkpoint, options)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "imported %d images and %d signatures, %d already imported\n",
+				report.imported, report.signatures, report.skipped)
+
+			if report.unsignable > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "%d signatures not imported, their image was converted\n",
+					report.unsignable)
+			}
+
+			if report.failed > 0 {
+				return fmt.Errorf("%w: %d failed, see %s", zotErrors.ErrImportIncomplete, report.failed,
+					checkpointFile)
+			}
+
+			return nil
+		},
+	}
+
+	remoteCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	remoteCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	remoteCmd.Flags().StringVar(&options.source, "source", "", "Registry to import from [harbor/quay/dockerhub]")
+	remoteCmd.Flags().StringVar(&options.sourceURL, "source-url", "",
+		"URL of the Harbor or Quay registry, defaults to quay.io for Quay")
+	remoteCmd.Flags().StringVar(&options.org, "org", "", "Harbor project, Quay organization or Docker Hub namespace")
+	remoteCmd.Flags().StringVar(&sourceUser, "source-user", "",
+		`Credentials of the registry to import from in "username:password" format`)
+	remoteCmd.Flags().StringVar(&options.token, "source-token", "",
+		"OAuth token of the Quay API, needed to list private repositories")
+	remoteCmd.Flags().StringVar(&checkpointFile, "checkpoint", "",
+		`Checkpoint file of the import, "zot-import-<source>-<org>.json" by default`)
+	remoteCmd.Flags().StringVar(&platform, "platform", "linux/amd64",
+		`Platform imported from manifest lists, "os/arch[/variant]"`)
+
+	_ = remoteCmd.MarkFlagRequired("source")
+	_ = remoteCmd.MarkFlagRequired("org")
+
+	remoteCmd.ValidArgsFunction = completeConfigNames
+
+	return remoteCmd
+}
+
+func parsePlatform(platform string) (ispec.Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return ispec.Platform{}, fmt.Errorf("%w: %q, expected os/arch[/variant]", zotErrors.ErrInvalidArgs, platform)
+	}
+
+	result := ispec.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		result.Variant = parts[2]
+	}
+
+	return result, nil
+}
+
+// importSourceURL returns the URL of the registry the images are pulled from.
+func importSourceURL(source, sourceURL string) (string, error) {
+	switch source {
+	case importSourceHarbor:
+		if sourceURL == "" {
+			return "", fmt.Errorf("%w: --source-url is required for harbor", zotErrors.ErrNoURLProvided)
+		}
+	case importSourceQuay:
+		if sourceURL == "" {
+			sourceURL = quayURL
+		}
+	case importSourceDockerHub:
+		if sourceURL == "" {
+			sourceURL = dockerHubRegistryURL
+		}
+	default:
+		return "", fmt.Errorf("%w: %q", zotErrors.ErrUnknownImportSource, source)
+	}
+
+	if !isURL(sourceURL) {
+		return "", zotErrors.ErrInvalidURL
+	}
+
+	return strings.TrimSuffix(sourceURL, "/"), nil
+}
+
+// loadImportCheckpoint reads the checkpoint file of a previous import of the organization, a new
+// checkpoint is returned if there is none.
+func loadImportCheckpoint(path string, options importOptions) (*importCheckpoint, error) {
+	checkpoint := &importCheckpoint{Source: options.source, Org: options.org, path: path,
+		Images: make(map[string]importedImage)}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, checkpoint); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+
+	if checkpoint.Source != options.source || checkpoint.Org != options.org {
+		return nil, fmt.Errorf("%w: %s imported %s %s", zotErrors.ErrCheckpointMismatch, path, checkpoint.Source,
+			checkpoint.Org)
+	}
+
+	if checkpoint.Images == nil {
+		checkpoint.Images = make(map[string]importedImage)
+	}
+
+	// failed images are retried
+	checkpoint.Failures = nil
+
+	return checkpoint, nil
+}
+
+// save writes the checkpoint to a temporary file renamed over the previous one, so that an
+// interruption never leaves a truncated checkpoint.
+func (c *importCheckpoint) save() error {
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.path)
+}
+
+func (c *importCheckpoint) fail(image string, err error) error {
+	if c.Failures == nil {
+		c.Failures = make(map[string]string)
+	}
+
+	c.Failures[image] = err.Error()
+
+	return c.save()
+}
+
+// importRemote imports the tags of all the repositories of the organization, the signatures of a
+// repository last, once the images they sign are imported. Failures of an image are reported to
+// errWriter and recorded in the checkpoint without stopping the import.
+func importRemote(errWriter io.Writer, client *registryClient, checkpoint *importCheckpoint,
+	options importOptions) (importReport, error) {
+	var report importReport
+
+	username, password := getUsernameAndPassword(options.user)
+
+	src, err := newRegistryClient(options.sourceURL, username, password, client.verifyTLS)
+	if err != nil {
+		return report, err
+	}
+
+	repos, err := listImportRepos(options, username, password, client.verifyTLS)
+	if err != nil {
+		return report, err
+	}
+
+	for _, repo := range repos {
+		tags, err := src.getTags(repo)
+		if err != nil {
+			fmt.Fprintf(errWriter, "failed to list the tags of %s: %v\n", repo, err)
+
+			report.failed++
+
+			if err := checkpoint.fail(repo, err); err != nil {
+				return report, err
+			}
+
+			continue
+		}
+
+		// signatures are tagged after the digest of the image they sign, e.g. "sha256-<hex>.sig"
+		sort.SliceStable(tags, func(i, j int) bool {
+			return !isSignatureTag(tags[i]) && isSignatureTag(tags[j])
+		})
+
+		for _, tag := range tags {
+			image := repo + ":" + tag
+
+			if isSignatureTag(tag) && !isSignable(checkpoint, repo, tag) {
+				report.unsignable++
+				continue
+			}
+
+			imported, err := importImage(src, client, repo, tag, checkpoint.Images[image], options.platform)
+			if err != nil {
+				fmt.Fprintf(errWriter, "failed to import %s: %v\n", image, err)
+
+				report.failed++
+
+				if err := checkpoint.fail(image, err); err != nil {
+					return report, err
+				}
+
+				continue
+			}
+
+			if imported == checkpoint.Images[image] {
+				report.skipped++
+				continue
+			}
+
+			if isSignatureTag(tag) {
+				report.signatures++
+			} else {
+				report.imported++
+			}
+
+			checkpoint.Images[image] = imported
+
+			if err := checkpoint.save(); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func isSignatureTag(tag string) bool {
+	return strings.HasPrefix(tag, "sha256-") && strings.HasSuffix(tag, ".sig")
+}
+
+// isSignable tells if the image signed by the signature tag was imported with its digest, the
+// signature of a converted image wouldn't verify.
+func isSignable(checkpoint *importCheckpoint, repo, tag string) bool {
+	signed := godigest.Digest("sha256:" + strings.TrimSuffix(strings.TrimPrefix(tag, "sha256-"), ".sig"))
+
+	for image, imported := range checkpoint.Images {
+		if strings.HasPrefix(image, repo+":") && imported.Digest == signed && imported.Target == signed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// importImage copies the image to zot, unless it is the previously imported one, and returns the
+// digests it was imported with.
+func importImage(src, dst *registryClient, repo, tag string, previous importedImage,
+	platform ispec.Platform) (importedImage, error) {
+	content, digest, mediaType, err := src.getManifest(repo, tag, ispec.MediaTypeImageManifest,
+		storage.DockerManifestMediaType, ispec.MediaTypeImageIndex, storage.DockerManifestListMediaType)
+	if err != nil {
+		return importedImage{}, err
+	}
+
+	if digest == previous.Digest {
+		return previous, nil
+	}
+
+	imported := importedImage{Digest: digest}
+
+	mediaType = strings.TrimSpace(strings.Split(mediaType, ";")[0])
+
+	if mediaType == ispec.MediaTypeImageIndex || mediaType == storage.DockerManifestListMediaType {
+		if content, mediaType, err = getPlatformManifest(src, repo, content, platform); err != nil {
+			return importedImage{}, err
+		}
+	}
+
+	var manifest ispec.Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return importedImage{}, err
+	}
+
+	if mediaType == storage.DockerManifestMediaType {
+		if content, err = storage.ConvertDockerManifest(&manifest); err != nil {
+			return importedImage{}, err
+		}
+	}
+
+	for _, desc := range append([]ispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		if desc.MediaType == ispec.MediaTypeImageLayerNonDistributableGzip || dst.hasBlob(repo, desc.Digest) {
+			continue
+		}
+
+		if err := copyBlob(src, dst, repo, desc); err != nil {
+			return importedImage{}, err
+		}
+	}
+
+	if err := dst.pushManifest(repo, tag, ispec.MediaTypeImageManifest, content); err != nil {
+		return importedImage{}, err
+	}
+
+	imported.Target = godigest.FromBytes(content)
+
+	return imported, nil
+}
+
+func copyBlob(src, dst *registryClient, repo string, desc ispec.Descriptor) error {
+	blob, err := src.getBlob(repo, desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	return dst.pushBlob(repo, desc.Digest, blob, desc.Size)
+}
+
+// getPlatformManifest returns the manifest of the platform listed in the manifest list, and its
+// media type.
+func getPlatformManifest(src *registryClient, repo string, content []byte, platform ispec.Platform) ([]byte,
+	string, error) {
+	var index ispec.Index
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, "", err
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.Platform == nil || desc.Platform.OS != platform.OS ||
+			desc.Platform.Architecture != platform.Architecture ||
+			(platform.Variant != "" && desc.Platform.Variant != platform.Variant) {
+			continue
+		}
+
+		content, _, mediaType, err := src.getManifest(repo, desc.Digest.String(), ispec.MediaTypeImageManifest,
+			storage.DockerManifestMediaType)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return content, strings.TrimSpace(strings.Split(mediaType, ";")[0]), nil
+	}
+
+	variant := ""
+	if platform.Variant != "" {
+		variant = "/" + platform.Variant
+	}
+
+	return nil, "", fmt.Errorf("%w: %s/%s%s", zotErrors.ErrPlatformNotFound, platform.OS, platform.Architecture,
+		variant)
+}
+
+// listImportRepos enumerates the repositories of the organization with the API of the source.
+func listImportRepos(options importOptions, username, password string, verifyTLS bool) ([]string, error) {
+	switch options.source {
+	case importSourceHarbor:
+		return listHarborRepos(options.sourceURL, options.org, username, password, verifyTLS)
+	case importSourceQuay:
+		return listQuayRepos(options.sourceURL, options.org, options.token, verifyTLS)
+	default:
+		return listDockerHubRepos(options.org, username, password, verifyTLS)
+	}
+}
+
+// listHarborRepos lists the repositories of a Harbor project, whose names include the project.
+func listHarborRepos(sourceURL, project, username, password string, verifyTLS bool) ([]string, error) {
+	var repos []string
+
+	for page := 1; ; page++ {
+		var results []struct {
+			Name string `json:"name"`
+		}
+
+		endPoint := fmt.Sprintf("%s/api/v2.0/projects/%s/repositories?page=%d&page_size=%d", sourceURL,
+			url.PathEscape(project), page, importPageSize)
+
+		if _, err := makeGETRequest(endPoint, username, password, verifyTLS, &results); err != nil {
+			return nil, err
+		}
+
+		for _, result := range results {
+			repos = append(repos, result.Name)
+		}
+
+		if len(results) < importPageSize {
+			return repos, nil
+		}
+	}
+}
+
+// listQuayRepos lists the repositories of a Quay organization, the public ones only without token.
+func listQuayRepos(sourceURL, org, token string, verifyTLS bool) ([]string, error) {
+	var repos []string
+
+	nextPage := ""
+
+	for {
+		var results struct {
+			Repositories []struct {
+				Namespace string `json:"namespace"`
+				Name      string `json:"name"`
+			} `json:"repositories"`
+			NextPage string `json:"next_page"`
+		}
+
+		query := url.Values{"namespace": []string{org}}
+		if nextPage != "" {
+			query.Set("next_page", nextPage)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, sourceURL+"/api/v1/repository?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		if _, err := doHTTPRequest(req, verifyTLS, &results); err != nil {
+			return nil, err
+		}
+
+		for _, result := range results.Repositories {
+			repos = append(repos, result.Namespace+"/"+result.Name)
+		}
+
+		if nextPage = results.NextPage; nextPage == "" {
+			return repos, nil
+		}
+	}
+}
+
+// listDockerHubRepos lists the repositories of a Docker Hub namespace, the private ones too once
+// logged in.
+func listDockerHubRepos(namespace, username, password string, verifyTLS bool) ([]string, error) {
+	token := ""
+
+	if username != "" {
+		var err error
+
+		if token, err = loginDockerHub(username, password, verifyTLS); err != nil {
+			return nil, err
+		}
+	}
+
+	var repos []string
+
+	endPoint := fmt.Sprintf("%s/v2/repositories/%s/?page_size=%s", dockerHubAPIURL, url.PathEscape(namespace),
+		strconv.Itoa(importPageSize))
+
+	for endPoint != "" {
+		var results struct {
+			Results []struct {
+				Name string `json:"name"`
+			} `json:"results"`
+			Next string `json:"next"`
+		}
+
+		req, err := http.NewRequest(http.MethodGet, endPoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		if _, err := doHTTPRequest(req, verifyTLS, &results); err != nil {
+			return nil, err
+		}
+
+		for _, result := range results.Results {
+			repos = append(repos, namespace+"/"+result.Name)
+		}
+
+		endPoint = results.Next
+	}
+
+	return repos, nil
+}
+
+func loginDockerHub(username, password string, verifyTLS bool) (string, error) {
+	credentials, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, dockerHubAPIURL+"/v2/users/login",
+		bytes.NewReader(credentials))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	var login struct {
+		Token string `json:"token"`
+	}
+
+	if _, err := doHTTPRequest(req, verifyTLS, &login); err != nil {
+		return "", err
+	}
+
+	return login.Token, nil
+}
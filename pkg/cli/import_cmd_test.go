@@ -0,0 +1,146 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/api"
+	"github.com/anuvu/zot/pkg/storage"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/resty.v1"
+)
+
+func TestImportCmd(t *testing.T) {
+	Convey("Test importing a Harbor project", t, func() {
+		srcDir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(srcDir)
+
+		dstDir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dstDir)
+
+		srcURL, src := startTestServer(srcDir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(src)
+
+		dstURL, dst := startTestServer(dstDir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(dst)
+
+		uploadManifest(srcURL)
+
+		// a Harbor API in front of the source server
+		target, err := url.Parse(srcURL)
+		So(err, ShouldBeNil)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v2.0/projects/team/repositories", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode([]map[string]string{{"name": "repo7"}})
+		})
+		mux.Handle("/v2/", httputil.NewSingleHostReverseProxy(target))
+
+		harbor := httptest.NewServer(mux)
+		defer harbor.Close()
+
+		checkpointFile := path.Join(dstDir, "checkpoint.json")
+
+		importArgs := []string{"import", "remote", "--url", dstURL, "--source", "harbor", "--source-url",
+			harbor.URL, "--org", "team", "--checkpoint", checkpointFile}
+
+		cmd := NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs(importArgs)
+		So(cmd.Execute(), ShouldBeNil)
+		So(buff.String(), ShouldEqual, "imported 2 images and 0 signatures, 0 already imported\n")
+
+		for _, tag := range []string{"test:1.0", "test:2.0"} {
+			srcResp, err := resty.R().Get(srcURL + "/v2/repo7/manifests/" + tag)
+			So(err, ShouldBeNil)
+
+			dstResp, err := resty.R().Get(dstURL + "/v2/repo7/manifests/" + tag)
+			So(err, ShouldBeNil)
+			So(dstResp.StatusCode(), ShouldEqual, 200)
+			So(dstResp.Header().Get("Docker-Content-Digest"), ShouldEqual,
+				srcResp.Header().Get("Docker-Content-Digest"))
+		}
+
+		Convey("The import is resumed from the checkpoint", func() {
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs(importArgs)
+			So(cmd.Execute(), ShouldBeNil)
+			So(buff.String(), ShouldEqual, "imported 0 images and 0 signatures, 2 already imported\n")
+		})
+
+		Convey("The checkpoint of another source is refused", func() {
+			cmd := NewRootCmd()
+			cmd.SetOut(ioutil.Discard)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs([]string{"import", "remote", "--url", dstURL, "--source", "quay", "--source-url",
+				harbor.URL, "--org", "team", "--checkpoint", checkpointFile})
+			So(errors.Is(cmd.Execute(), zotErrors.ErrCheckpointMismatch), ShouldBeTrue)
+		})
+
+		Convey("Unknown sources are refused", func() {
+			cmd := NewRootCmd()
+			cmd.SetOut(ioutil.Discard)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs([]string{"import", "remote", "--url", dstURL, "--source", "gitlab", "--org", "team"})
+			So(errors.Is(cmd.Execute(), zotErrors.ErrUnknownImportSource), ShouldBeTrue)
+		})
+	})
+}
+
+func TestConvertDockerManifest(t *testing.T) {
+	Convey("Docker media types are converted to the OCI ones", t, func() {
+		manifest := ispec.Manifest{
+			Config: ispec.Descriptor{MediaType: storage.DockerConfigMediaType},
+			Layers: []ispec.Descriptor{
+				{MediaType: storage.DockerLayerMediaType},
+				{MediaType: storage.DockerForeignLayerMediaType},
+			},
+		}
+
+		content, err := storage.ConvertDockerManifest(&manifest)
+		So(err, ShouldBeNil)
+
+		var converted ispec.Manifest
+		So(json.Unmarshal(content, &converted), ShouldBeNil)
+		So(converted.Config.MediaType, ShouldEqual, ispec.MediaTypeImageConfig)
+		So(converted.Layers[0].MediaType, ShouldEqual, ispec.MediaTypeImageLayerGzip)
+		So(converted.Layers[1].MediaType, ShouldEqual, ispec.MediaTypeImageLayerNonDistributableGzip)
+	})
+
+	Convey("Challenges and links are parsed", t, func() {
+		scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`)
+		So(scheme, ShouldEqual, "Bearer")
+		So(params, ShouldResemble, map[string]string{"realm": "https://auth.docker.io/token",
+			"service": "registry.docker.io"})
+
+		So(nextLink(`</v2/app/tags/list?n=100&last=9>; rel="next"`), ShouldEqual, "/v2/app/tags/list?n=100&last=9")
+		So(nextLink(""), ShouldEqual, "")
+	})
+}
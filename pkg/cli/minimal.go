@@ -6,3 +6,7 @@ import "github.com/spf13/cobra"
 
 func enableCli(rootCmd *cobra.Command) {
 }
+
+func isCommandUsageError(err error) bool {
+	return false
+}
@@ -0,0 +1,274 @@
+// +build extended
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/api"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+const namespacesEndpoint = "/v2/_zot/admin/namespaces"
+
+func NewNamespaceCommand() *cobra.Command {
+	namespaceCmd := &cobra.Command{
+		Use:   "namespace",
+		Short: "Manage the namespaces of repositories",
+		Long: `Manage the namespaces of a zot server: only the members of a namespace push to the repositories
+under it, e.g. team-a/app for team-a, and these repositories get its size quota and tag TTL.
+Admin credentials are needed if the server has authentication enabled.`,
+	}
+
+	namespaceCmd.AddCommand(newNamespaceListCommand())
+	namespaceCmd.AddCommand(newNamespaceSetCommand())
+	namespaceCmd.AddCommand(newNamespaceDeleteCommand())
+
+	return namespaceCmd
+}
+
+func newNamespaceListCommand() *cobra.Command {
+	var servURL, user, outputFormat string
+
+	listCmd := &cobra.Command{
+		Use:   "list [config-name]",
+		Short: "List the namespaces",
+		Long:  `List the namespaces of a zot server, their members and the defaults of their repositories`,
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			endPoint, username, password, verifyTLS, err := namespaceEndpointFromCommand(cmd, args, user, "")
+			if err != nil {
+				return err
+			}
+
+			namespaces := []api.Namespace{}
+
+			if _, err := makeGETRequest(endPoint, username, password, verifyTLS, &namespaces); err != nil {
+				return err
+			}
+
+			return printNamespaces(cmd.OutOrStdout(), namespaces, outputFormat)
+		},
+	}
+
+	listCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	listCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	listCmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Specify output format [text/json/yaml]")
+
+	listCmd.ValidArgsFunction = completeConfigNames
+
+	return listCmd
+}
+
+func newNamespaceSetCommand() *cobra.Command {
+	var servURL, user, repoQuota, tagTTL string
+
+	var members []string
+
+	setCmd := &cobra.Command{
+		Use:   "set <namespace> [config-name]",
+		Short: "Create or update a namespace",
+		Long: `Create a namespace, or replace its members and defaults, those not given are cleared.
+The size quota of each repository, e.g. 10GB, is checked when manifests are pushed, and the tags pushed
+without a TTL of their own expire after the tag TTL of the namespace, e.g. 720h.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace := api.Namespace{Name: args[0], Members: members, TagTTL: tagTTL}
+
+			if repoQuota != "" {
+				quota, err := humanize.ParseBytes(repoQuota)
+				if err != nil {
+					return fmt.Errorf("%w: invalid repository quota %q", zotErrors.ErrInvalidArgs, repoQuota)
+				}
+
+				namespace.RepoQuota = int64(quota)
+			}
+
+			endPoint, username, password, verifyTLS, err := namespaceEndpointFromCommand(cmd, args[1:], user,
+				namespace.Name)
+			if err != nil {
+				return err
+			}
+
+			body, err := json.Marshal(namespace)
+			if err != nil {
+				return err
+			}
+
+			req, err := http.NewRequest(http.MethodPut, endPoint, bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+
+			req.SetBasicAuth(username, password)
+			req.Header.Set("Content-Type", "application/json")
+
+			if _, err := doHTTPRequest(req, verifyTLS, &namespace); err != nil {
+				return err
+			}
+
+			return printNamespaces(cmd.OutOrStdout(), []api.Namespace{namespace}, "")
+		},
+	}
+
+	setCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	setCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	setCmd.Flags().StringSliceVarP(&members, "member", "m", nil, "Users allowed to push to the namespace")
+	setCmd.Flags().StringVar(&repoQuota, "repo-quota", "", "Size quota of each repository, e.g. 10GB")
+	setCmd.Flags().StringVar(&tagTTL, "tag-ttl", "", "TTL of the tags pushed without one, e.g. 720h")
+
+	return setCmd
+}
+
+func newNamespaceDeleteCommand() *cobra.Command {
+	var servURL, user string
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete <namespace> [config-name]",
+		Short: "Delete a namespace",
+		Long:  `Delete a namespace, its repositories are kept and anyone can push to them again`,
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			endPoint, username, password, verifyTLS, err := namespaceEndpointFromCommand(cmd, args[1:], user, args[0])
+			if err != nil {
+				return err
+			}
+
+			req, err := http.NewRequest(http.MethodDelete, endPoint, nil)
+			if err != nil {
+				return err
+			}
+
+			req.SetBasicAuth(username, password)
+
+			var namespace api.Namespace
+
+			if _, err := doHTTPRequest(req, verifyTLS, &namespace); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "deleted namespace %s\n", namespace.Name)
+
+			return nil
+		},
+	}
+
+	deleteCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	deleteCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+
+	return deleteCmd
+}
+
+func namespaceEndpointFromCommand(cmd *cobra.Command, args []string, user, name string) (string, string, string,
+	bool, error) {
+	serverURL, verifyTLS, err := serverFromCommand(cmd, args)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return "", "", "", false, err
+	}
+
+	if serverURL == "" {
+		return "", "", "", false, zotErrors.ErrNoURLProvided
+	}
+
+	endPoint := namespacesEndpoint
+	if name != "" {
+		endPoint += "/" + name
+	}
+
+	endPoint, err = combineServerAndEndpointURL(serverURL, endPoint)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return "", "", "", false, err
+	}
+
+	cmd.SilenceUsage = true
+
+	username, password := getUsernameAndPassword(user)
+
+	return endPoint, username, password, verifyTLS, nil
+}
+
+func printNamespaces(writer io.Writer, namespaces []api.Namespace, outputFormat string) error {
+	switch strings.ToLower(outputFormat) {
+	case "", defaultOutoutFormat:
+	case jsonOutputFormat:
+		body, err := json.MarshalIndent(namespaces, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(writer, string(body))
+
+		return nil
+	case ymlOutputFormat, yamlOutputFormat:
+		body, err := yaml.Marshal(namespaces)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(writer, string(body))
+
+		return nil
+	default:
+		return ErrInvalidOutputFormat
+	}
+
+	if len(namespaces) == 0 {
+		fmt.Fprintln(writer, "no namespaces")
+		return nil
+	}
+
+	layout := newNamespaceTableLayout()
+	layout.printHeader(writer)
+
+	table := layout.newTable(writer)
+
+	for _, namespace := range namespaces {
+		quota, tagTTL := "-", "-"
+
+		if namespace.RepoQuota > 0 {
+			quota = formatBytes(namespace.RepoQuota)
+		}
+
+		if namespace.TagTTL != "" {
+			tagTTL = namespace.TagTTL
+		}
+
+		table.Append(layout.row(map[string]string{
+			columnName:    namespace.Name,
+			columnMembers: strings.Join(namespace.Members, ","),
+			columnQuota:   quota,
+			columnTagTTL:  tagTTL,
+		}))
+	}
+
+	table.Render()
+
+	return nil
+}
+
+func newNamespaceTableLayout() *tableLayout {
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnName, header: "NAMESPACE", minWidth: registryWidth},
+			{name: columnMembers, header: "MEMBERS", minWidth: registryWidth},
+			{name: columnQuota, header: "REPO QUOTA", minWidth: sizeWidth},
+			{name: columnTagTTL, header: "TAG TTL", minWidth: countWidth},
+		},
+	}
+}
+
+const (
+	columnMembers = "members"
+	columnQuota   = "quota"
+	columnTagTTL  = "tagttl"
+)
@@ -0,0 +1,84 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/anuvu/zot/pkg/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNamespaceCmd(t *testing.T) {
+	Convey("Test namespaces of a real server", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		url, c := startTestServer(dir, nil)
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		run := func(args ...string) (string, error) {
+			cmd := NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs(args)
+			err := cmd.Execute()
+
+			return buff.String(), err
+		}
+
+		out, err := run("namespace", "list", "--url", url)
+		So(err, ShouldBeNil)
+		So(strings.TrimSpace(out), ShouldEqual, "no namespaces")
+
+		out, err = run("namespace", "set", "team-a", "--url", url, "-m", "alice", "-m", "bob",
+			"--repo-quota", "10GB", "--tag-ttl", "720h")
+		So(err, ShouldBeNil)
+		So(out, ShouldContainSubstring, "team-a")
+
+		_, err = run("namespace", "set", "team-b", "--url", url)
+		So(err, ShouldBeNil)
+
+		out, err = run("namespace", "list", "--url", url)
+		So(err, ShouldBeNil)
+
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		So(lines, ShouldHaveLength, 3)
+		So(strings.Fields(lines[0]), ShouldResemble, []string{"NAMESPACE", "MEMBERS", "REPO", "QUOTA", "TAG", "TTL"})
+		So(strings.Fields(lines[1]), ShouldResemble, []string{"team-a", "alice,bob", "10GB", "720h"})
+		So(strings.Fields(lines[2]), ShouldResemble, []string{"team-b", "-", "-"})
+
+		out, err = run("namespace", "list", "--url", url, "-o", "json")
+		So(err, ShouldBeNil)
+		So(out, ShouldContainSubstring, `"repoQuota": 10000000000`)
+
+		out, err = run("namespace", "delete", "team-b", "--url", url)
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "deleted namespace team-b\n")
+
+		_, err = run("namespace", "delete", "team-b", "--url", url)
+		So(err, ShouldNotBeNil)
+
+		_, err = run("namespace", "set", "team-c", "--url", url, "--tag-ttl", "soon")
+		So(err, ShouldNotBeNil)
+
+		_, err = run("namespace", "set", "team-c", "--url", url, "--repo-quota", "lots")
+		So(err, ShouldNotBeNil)
+
+		_, err = run("namespace", "list", "--url", url, "-o", "random")
+		So(err, ShouldNotBeNil)
+
+		_, err = run("namespace", "list")
+		So(err, ShouldNotBeNil)
+	})
+}
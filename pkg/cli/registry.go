@@ -0,0 +1,339 @@
+// +build extended
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// registryClient pulls and pushes images with the OCI distribution API of a zot server.
+type registryClient struct {
+	client    *http.Client
+	baseURL   *url.URL
+	username  string
+	password  string
+	verifyTLS bool
+	// token authenticates the requests once a registry asked for a bearer token
+	token string
+}
+
+func newRegistryClient(serverURL, username, password string, verifyTLS bool) (*registryClient, error) {
+	if !isURL(serverURL) {
+		return nil, zotErrors.ErrInvalidURL
+	}
+
+	baseURL, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, zotErrors.ErrInvalidURL
+	}
+
+	client, _ := httpClients.get(baseURL.Host, verifyTLS)
+
+	return &registryClient{client: client, baseURL: baseURL, username: username, password: password,
+		verifyTLS: verifyTLS}, nil
+}
+
+// do sends a request to the server, the caller must close the body of the response, which is only
+// returned if the server answered with the expected status code.
+func (rc *registryClient) do(method, location string, body io.Reader, size int64, header http.Header,
+	expected int) (*http.Response, error) {
+	u, err := rc.baseURL.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rc.send(method, u, body, size, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// registries authenticating with bearer tokens, e.g. Docker Hub, challenge the requests for other
+	// repositories than the one of the token, those without a body are sent again with a new token
+	if resp.StatusCode == http.StatusUnauthorized && body == nil {
+		scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+		if strings.EqualFold(scheme, "bearer") && params["realm"] != "" {
+			resp.Body.Close()
+
+			if rc.token, err = rc.getToken(params); err != nil {
+				return nil, err
+			}
+
+			if resp, err = rc.send(method, u, nil, 0, header); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if resp.StatusCode == expected {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+
+	msg, _ := ioutil.ReadAll(resp.Body)
+
+	return nil, fmt.Errorf("%s %s: %w", method, u.Path, zotErrors.FromResponse(resp.StatusCode, msg))
+}
+
+func (rc *registryClient) send(method string, u *url.URL, body io.Reader, size int64,
+	header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	switch {
+	case rc.token != "":
+		req.Header.Set("Authorization", "Bearer "+rc.token)
+	case rc.username != "":
+		req.SetBasicAuth(rc.username, rc.password)
+	}
+
+	return rc.client.Do(req)
+}
+
+// getToken gets a bearer token from the authorization server of the challenge, with the credentials
+// of the client if any.
+func (rc *registryClient) getToken(challenge map[string]string) (string, error) {
+	u, err := url.Parse(challenge["realm"])
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+
+	for _, param := range []string{"service", "scope"} {
+		if challenge[param] != "" {
+			query.Set(param, challenge[param])
+		}
+	}
+
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if rc.username != "" {
+		req.SetBasicAuth(rc.username, rc.password)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if _, err := doHTTPRequest(req, rc.verifyTLS, &token); err != nil {
+		return "", err
+	}
+
+	if token.Token == "" {
+		return token.AccessToken, nil
+	}
+
+	return token.Token, nil
+}
+
+// parseChallenge returns the scheme and the parameters of a WWW-Authenticate header,
+// e.g. `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+func parseChallenge(header string) (string, map[string]string) {
+	params := make(map[string]string)
+
+	scheme := strings.TrimSpace(header)
+	if i := strings.IndexByte(scheme, ' '); i >= 0 {
+		scheme, header = scheme[:i], scheme[i+1:]
+	} else {
+		header = ""
+	}
+
+	for header != "" {
+		header = strings.TrimLeft(header, " ,")
+
+		i := strings.IndexByte(header, '=')
+		if i < 0 {
+			break
+		}
+
+		key, value := strings.TrimSpace(header[:i]), header[i+1:]
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.IndexByte(value[1:], '"')
+			if end < 0 {
+				end = len(value) - 1
+			}
+
+			params[key], header = value[1:end+1], value[end+1:]
+			header = strings.TrimPrefix(header, `"`)
+		} else {
+			end := strings.IndexByte(value, ',')
+			if end < 0 {
+				end = len(value)
+			}
+
+			params[key], header = value[:end], value[end:]
+		}
+	}
+
+	return scheme, params
+}
+
+// getJSON decodes the JSON response of the server, and returns its header.
+func (rc *registryClient) getJSON(location string, v interface{}) (http.Header, error) {
+	resp, err := rc.do(http.MethodGet, location, nil, 0, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return resp.Header, json.NewDecoder(resp.Body).Decode(v)
+}
+
+// getTags lists the tags of the repository, following the pagination links of the registry.
+func (rc *registryClient) getTags(repo string) ([]string, error) {
+	var tags []string
+
+	for location := fmt.Sprintf("/v2/%s/tags/list", repo); location != ""; {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+
+		header, err := rc.getJSON(location, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, page.Tags...)
+		location = nextLink(header.Get("Link"))
+	}
+
+	return tags, nil
+}
+
+// nextLink returns the URL of the next page of a Link header, e.g. `</v2/app/tags/list?n=100&last=9>; rel="next"`.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 || !strings.Contains(parts[1], `rel="next"`) {
+			continue
+		}
+
+		return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+	}
+
+	return ""
+}
+
+// getManifest returns the manifest, its digest and its media type, which is one of the accepted ones,
+// an image manifest by default.
+func (rc *registryClient) getManifest(repo, reference string, accept ...string) ([]byte, godigest.Digest, string,
+	error) {
+	if len(accept) == 0 {
+		accept = []string{ispec.MediaTypeImageManifest}
+	}
+
+	resp, err := rc.do(http.MethodGet, fmt.Sprintf("/v2/%s/manifests/%s", repo, reference), nil, 0,
+		http.Header{"Accept": accept}, http.StatusOK)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	digest := godigest.FromBytes(content)
+
+	if err := verifyContentDigest(resp.Header.Get(contentDigestHeader), content); err != nil {
+		return nil, "", "", err
+	}
+
+	return content, digest, resp.Header.Get("Content-Type"), nil
+}
+
+// getBlob returns the content of the blob, the caller must close it.
+func (rc *registryClient) getBlob(repo string, digest godigest.Digest) (io.ReadCloser, error) {
+	resp, err := rc.do(http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", repo, digest), nil, 0, nil, //nolint: bodyclose
+		http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// hasBlob tells if the repository has the blob.
+func (rc *registryClient) hasBlob(repo string, digest godigest.Digest) bool {
+	resp, err := rc.do(http.MethodHead, fmt.Sprintf("/v2/%s/blobs/%s", repo, digest), nil, 0, nil, http.StatusOK)
+	if err != nil {
+		return false
+	}
+
+	resp.Body.Close()
+
+	return true
+}
+
+// pushBlob uploads the blob in a single request, unless the server already has it.
+func (rc *registryClient) pushBlob(repo string, digest godigest.Digest, body io.Reader, size int64) error {
+	if rc.hasBlob(repo, digest) {
+		return nil
+	}
+
+	resp, err := rc.do(http.MethodPost, fmt.Sprintf("/v2/%s/blobs/uploads/", repo), nil, 0, nil,
+		http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+
+	query := location.Query()
+	query.Set("digest", digest.String())
+	location.RawQuery = query.Encode()
+
+	resp, err = rc.do(http.MethodPut, location.String(), body, size,
+		http.Header{"Content-Type": []string{"application/octet-stream"}}, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (rc *registryClient) pushManifest(repo, reference, mediaType string, content []byte) error {
+	resp, err := rc.do(http.MethodPut, fmt.Sprintf("/v2/%s/manifests/%s", repo, reference),
+		bytes.NewReader(content), int64(len(content)), http.Header{"Content-Type": []string{mediaType}},
+		http.StatusCreated)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
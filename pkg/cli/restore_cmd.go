@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anuvu/zot/errors"
+	zlog "github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+func NewRestoreCommand() *cobra.Command {
+	var backupDir, rootDir, snapshot, outputFormat string
+
+	dedupe := true
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore",
+		Short: "`restore` restores a snapshot of an image store",
+		Long: `Restore a snapshot taken by "zot backup", the latest one unless --snapshot is set, from the backup
+directory of an image store into an empty storage root directory, while zot is stopped. With dedupe, the
+blobs shared by repositories are hard linked and recorded in the dedupe cache.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch strings.ToLower(outputFormat) {
+			case "", "text", "json":
+			default:
+				return fmt.Errorf("%w: invalid output format %q", errors.ErrInvalidArgs, outputFormat)
+			}
+
+			cmd.SilenceUsage = true
+
+			// the report is printed on stdout, keep it apart from the logs
+			logger := zlog.Logger{Logger: zerolog.New(cmd.ErrOrStderr()).With().Timestamp().Logger()}
+
+			report, err := storage.Restore(backupDir, snapshot, rootDir, dedupe, logger)
+			if err != nil {
+				return err
+			}
+
+			return printRestoreReport(cmd.OutOrStdout(), report, outputFormat)
+		},
+	}
+
+	restoreCmd.Flags().StringVarP(&backupDir, "backup-dir", "b", "", "Backup directory of the image store")
+	restoreCmd.Flags().StringVarP(&rootDir, "storage-root-dir", "r", "", "Empty storage root directory to restore into")
+	restoreCmd.Flags().StringVarP(&snapshot, "snapshot", "s", "", "Snapshot to restore, the latest one if not set")
+	restoreCmd.Flags().BoolVar(&dedupe, "dedupe", true, "Hard link the blobs shared by repositories")
+	restoreCmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Specify output format [text/json]")
+
+	_ = restoreCmd.MarkFlagRequired("backup-dir")
+	_ = restoreCmd.MarkFlagRequired("storage-root-dir")
+
+	return restoreCmd
+}
+
+func printRestoreReport(writer io.Writer, report storage.RestoreReport, outputFormat string) error {
+	if strings.EqualFold(outputFormat, "json") {
+		body, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(writer, string(body))
+
+		return nil
+	}
+
+	fmt.Fprintf(writer, "%s: restored snapshot %s, %d repositories, %d blobs, %d hard links\n",
+		report.RootDir, report.Snapshot, report.Repos, report.Blobs, report.LinkedBlobs)
+
+	return nil
+}
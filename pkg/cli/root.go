@@ -1,8 +1,12 @@
 package cli
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/anuvu/zot/errors"
 	"github.com/anuvu/zot/pkg/api"
+	zlog "github.com/anuvu/zot/pkg/log"
 	"github.com/anuvu/zot/pkg/storage"
 	"github.com/mitchellh/mapstructure"
 	dspec "github.com/opencontainers/distribution-spec"
@@ -21,6 +25,7 @@ func metadataConfig(md *mapstructure.Metadata) viper.DecoderConfigOption {
 
 func NewRootCmd() *cobra.Command {
 	showVersion := false
+	dryRun := false
 	config := api.NewConfig()
 
 	// "serve"
@@ -48,12 +53,35 @@ func NewRootCmd() *cobra.Command {
 				}
 			}
 			c := api.NewController(config)
+
+			if dryRun {
+				// the effective config is printed on stdout, keep it apart from the logs
+				c.Log = zlog.Logger{Logger: c.Log.Output(cmd.ErrOrStderr())}
+
+				effective, err := c.DryRun()
+				if err != nil {
+					panic(err)
+				}
+
+				buf, err := json.MarshalIndent(effective, "", "  ")
+				if err != nil {
+					panic(err)
+				}
+
+				fmt.Fprintln(cmd.OutOrStdout(), string(buf))
+
+				return
+			}
+
 			if err := c.Run(); err != nil {
 				panic(err)
 			}
 		},
 	}
 
+	serveCmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"validate the config, print the effective config, with defaults applied and secrets redacted, and exit")
+
 	// "garbage-collect"
 	gcDelUntagged := false
 	gcDryRun := false
@@ -89,7 +117,7 @@ func NewRootCmd() *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			if showVersion {
 				log.Info().Str("distribution-spec", dspec.Version).Str("commit", api.Commit).
-					Str("binary-type", api.BinaryType).Msg("version")
+					Str("binary-type", api.BinaryType).Bool("fips", api.FIPSMode).Msg("version")
 			}
 			_ = cmd.Usage()
 		},
@@ -97,10 +125,18 @@ func NewRootCmd() *cobra.Command {
 
 	rootCmd.AddCommand(serveCmd)
 	rootCmd.AddCommand(gcCmd)
+	rootCmd.AddCommand(NewFsckCommand())
+	rootCmd.AddCommand(NewRestoreCommand())
+	rootCmd.AddCommand(NewCompletionCommand())
 
 	enableCli(rootCmd)
 
 	rootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
 
+	// flag parsing errors are reported as invalid arguments, see ExitCode
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return fmt.Errorf("%w: %v", errors.ErrInvalidArgs, err)
+	})
+
 	return rootCmd
 }
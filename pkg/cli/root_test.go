@@ -1,11 +1,14 @@
 package cli_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path"
 	"testing"
 
+	"github.com/anuvu/zot/pkg/api"
 	"github.com/anuvu/zot/pkg/cli"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -65,6 +68,52 @@ func TestServe(t *testing.T) {
 	})
 }
 
+func TestServeDryRun(t *testing.T) {
+	Convey("Test serve dry run", t, func(c C) {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		tmpfile, err := ioutil.TempFile("", "zot-test*.json")
+		So(err, ShouldBeNil)
+		defer os.Remove(tmpfile.Name())
+		content := []byte(`{"storage":{"rootDirectory":"` + dir + `"},"http":{"address":"127.0.0.1","port":"8080",` +
+			`"auth":{"ldap":{"address":"ldap.example.com","userAttribute":"uid","bindPassword":"secret"}}}}`)
+		_, err = tmpfile.Write(content)
+		So(err, ShouldBeNil)
+		So(tmpfile.Close(), ShouldBeNil)
+
+		buff := bytes.NewBufferString("")
+		rootCmd := cli.NewRootCmd()
+		rootCmd.SetOut(buff)
+		rootCmd.SetErr(ioutil.Discard)
+		rootCmd.SetArgs([]string{"serve", "--dry-run", tmpfile.Name()})
+		So(rootCmd.Execute(), ShouldBeNil)
+
+		effective := api.Config{}
+		So(json.Unmarshal(buff.Bytes(), &effective), ShouldBeNil)
+		So(effective.Storage.RootDirectory, ShouldEqual, dir)
+		So(effective.HTTP.Auth.LDAP.BindPassword, ShouldEqual, "******")
+		So(effective.Scheduler.MaxConcurrentTasks, ShouldEqual, 2)
+
+		Convey("invalid config", func(c C) {
+			tmpfile, err := ioutil.TempFile("", "zot-test*.json")
+			So(err, ShouldBeNil)
+			defer os.Remove(tmpfile.Name())
+			_, err = tmpfile.Write([]byte(`{"storage":{"rootDirectory":"` + dir + `"},"http":{"port":"8080",` +
+				`"auth":{"ldap":{"address":"ldap.example.com"}}}}`))
+			So(err, ShouldBeNil)
+			So(tmpfile.Close(), ShouldBeNil)
+
+			rootCmd := cli.NewRootCmd()
+			rootCmd.SetOut(ioutil.Discard)
+			rootCmd.SetErr(ioutil.Discard)
+			rootCmd.SetArgs([]string{"serve", "--dry-run", tmpfile.Name()})
+			So(func() { _ = rootCmd.Execute() }, ShouldPanic)
+		})
+	})
+}
+
 func TestGC(t *testing.T) {
 	oldArgs := os.Args
 
@@ -76,3 +125,83 @@ func TestGC(t *testing.T) {
 		So(err, ShouldBeNil)
 	})
 }
+
+func TestFsck(t *testing.T) {
+	Convey("Test fsck", t, func(c C) {
+		dir, err := ioutil.TempDir("", "fsck-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		So(os.MkdirAll(path.Join(dir, "a", "blobs"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(path.Join(dir, "a", "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0600),
+			ShouldBeNil)
+		So(ioutil.WriteFile(path.Join(dir, "a", "index.json"), []byte(`{"schemaVersion":2,"manifests":[]}`), 0600),
+			ShouldBeNil)
+		So(os.MkdirAll(path.Join(dir, "a", ".uploads", "upload"), 0755), ShouldBeNil)
+
+		cmd := cli.NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"fsck", "-r", dir})
+		So(cmd.Execute(), ShouldNotBeNil)
+		So(buff.String(), ShouldContainSubstring, "danglingUpload     a/.uploads/upload\n")
+		So(buff.String(), ShouldEndWith, "1 danglingUpload, 0 fixed\n")
+
+		cmd = cli.NewRootCmd()
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"fsck", "-r", dir, "--fix", "-o", "json"})
+		So(cmd.Execute(), ShouldBeNil)
+
+		var report struct {
+			Fixed   int            `json:"fixed"`
+			Summary map[string]int `json:"summary"`
+		}
+		So(json.Unmarshal(buff.Bytes(), &report), ShouldBeNil)
+		So(report.Fixed, ShouldEqual, 1)
+		So(report.Summary, ShouldResemble, map[string]int{"danglingUpload": 1})
+
+		cmd = cli.NewRootCmd()
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"fsck", "-r", dir})
+		So(cmd.Execute(), ShouldBeNil)
+		So(buff.String(), ShouldEndWith, "no issues, 0 fixed\n")
+
+		cmd = cli.NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"fsck", "-r", dir, "-o", "yaml"})
+		So(cmd.Execute(), ShouldNotBeNil)
+	})
+}
+
+func TestCompletion(t *testing.T) {
+	Convey("Test completion scripts", t, func(c C) {
+		for shell, expected := range map[string]string{
+			"bash": "__start_zot",
+			"zsh":  "#compdef _zot zot",
+			"fish": "complete -c zot",
+		} {
+			cmd := cli.NewRootCmd()
+			buff := bytes.NewBufferString("")
+			cmd.SetOut(buff)
+			cmd.SetArgs([]string{"completion", shell})
+			err := cmd.Execute()
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldContainSubstring, expected)
+		}
+	})
+
+	Convey("Test unsupported shell", t, func(c C) {
+		cmd := cli.NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"completion", "tcsh"})
+		err := cmd.Execute()
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -9,9 +9,12 @@ import (
 	"io"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/api"
+	"github.com/anuvu/zot/pkg/extensions/search/semver"
 	"github.com/briandowns/spinner"
 )
 
@@ -20,6 +23,8 @@ func getImageSearchers() []searcher {
 		new(allImagesSearcher),
 		new(imageByNameSearcher),
 		new(imagesByDigestSearcher),
+		new(imagesByAnnotationSearcher),
+		new(imagesByGitVersionSearcher),
 	}
 
 	return searchers
@@ -58,11 +63,26 @@ type searchConfig struct {
 	servURL       *string
 	user          *string
 	outputFormat  *string
+	template      *template.Template
+	imageTable    *tableLayout
+	cveTable      *tableLayout
 	verifyTLS     *bool
 	fixedFlag     *bool
 	verbose       *bool
 	resultWriter  io.Writer
 	spinner       spinnerState
+	// timeout is how long to wait for all the results, 0 to only time out when no result comes for a while.
+	timeout time.Duration
+	// severityThreshold is the severity from which the CVEs of an image fail the command, if set.
+	severityThreshold string
+	// cveSummaries fetches the CVE counts of the listed images, nil if they're not displayed.
+	cveSummaries *cveSummaries
+	// provenance shows how the listed images were built by stacker.
+	provenance bool
+	// match filters the images listed from the catalog when the search extension isn't enabled, nil to keep all.
+	match func(manifest manifestResponse, manifestDigest string) bool
+	// latest lists only the newest tag of each repository in the version range, nil to list all tags.
+	latest *semver.Constraint
 }
 
 type allImagesSearcher struct{}
@@ -142,8 +162,95 @@ func (search imagesByDigestSearcher) search(config searchConfig) (bool, error) {
 
 	wg.Add(1)
 
-	go config.searchService.getImagesByDigest(ctx, config, username, password,
-		*config.params["digest"], imageErr, &wg)
+	if searchDisabled(config, username, password) {
+		config.match = matchDigest(*config.params["digest"])
+
+		go config.searchService.getAllImages(ctx, config, username, password, imageErr, &wg)
+	} else {
+		go config.searchService.getImagesByDigest(ctx, config, username, password,
+			*config.params["digest"], imageErr, &wg)
+	}
+	wg.Add(1)
+
+	var errCh chan error = make(chan error, 1)
+	go collectResults(config, &wg, imageErr, cancel, printImageTableHeader, errCh)
+
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return true, err
+	default:
+		return true, nil
+	}
+}
+
+type imagesByAnnotationSearcher struct{}
+
+func (search imagesByAnnotationSearcher) search(config searchConfig) (bool, error) {
+	if !canSearch(config.params, newSet("annotation")) {
+		return false, nil
+	}
+
+	key, value, ok := parseAnnotation(*config.params["annotation"])
+	if !ok {
+		return true, errInvalidAnnotation
+	}
+
+	username, password := getUsernameAndPassword(*config.user)
+	imageErr := make(chan stringResult)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	if searchDisabled(config, username, password) {
+		config.match = matchAnnotation(key, value)
+
+		go config.searchService.getAllImages(ctx, config, username, password, imageErr, &wg)
+	} else {
+		go config.searchService.getImagesByAnnotation(ctx, config, username, password,
+			key, value, imageErr, &wg)
+	}
+	wg.Add(1)
+
+	var errCh chan error = make(chan error, 1)
+	go collectResults(config, &wg, imageErr, cancel, printImageTableHeader, errCh)
+
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return true, err
+	default:
+		return true, nil
+	}
+}
+
+type imagesByGitVersionSearcher struct{}
+
+func (search imagesByGitVersionSearcher) search(config searchConfig) (bool, error) {
+	if !canSearch(config.params, newSet("gitVersion")) {
+		return false, nil
+	}
+
+	username, password := getUsernameAndPassword(*config.user)
+	imageErr := make(chan stringResult)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	if searchDisabled(config, username, password) {
+		config.match = matchAnnotation(gitVersionAnnotation, config.params["gitVersion"])
+
+		go config.searchService.getAllImages(ctx, config, username, password, imageErr, &wg)
+	} else {
+		go config.searchService.getImagesByGitVersion(ctx, config, username, password,
+			*config.params["gitVersion"], imageErr, &wg)
+	}
 	wg.Add(1)
 
 	var errCh chan error = make(chan error, 1)
@@ -159,6 +266,80 @@ func (search imagesByDigestSearcher) search(config searchConfig) (bool, error) {
 	}
 }
 
+// searchDisabled tells whether the server lists the search extension as disabled, the images are then listed
+// from the catalog and filtered by the CLI. Servers which don't list their extensions are queried as before.
+func searchDisabled(config searchConfig, username, password string) bool {
+	extensions, ok := config.searchService.getExtensions(config, username, password)
+
+	return ok && !extensions.Enabled(api.SearchExtension)
+}
+
+// checkCVEEnabled returns ErrExtensionNotEnabled if the server lists CVE scanning as disabled.
+func checkCVEEnabled(config searchConfig, username, password string) error {
+	extensions, ok := config.searchService.getExtensions(config, username, password)
+	if ok && !extensions.Enabled(api.CVEExtension) {
+		return fmt.Errorf("%w: %s", zotErrors.ErrExtensionNotEnabled, api.CVEExtension)
+	}
+
+	return nil
+}
+
+// matchDigest matches the images whose manifest, config or a layer digest starts with digest, with or
+// without the algorithm, as the ImageListForDigest query does.
+func matchDigest(digest string) func(manifest manifestResponse, manifestDigest string) bool {
+	hasPrefix := func(candidate string) bool {
+		return strings.HasPrefix(trimAlgorithm(candidate), trimAlgorithm(digest))
+	}
+
+	return func(manifest manifestResponse, manifestDigest string) bool {
+		if hasPrefix(manifestDigest) || hasPrefix(manifest.Config.Digest) {
+			return true
+		}
+
+		for _, layer := range manifest.Layers {
+			if hasPrefix(layer.Digest) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func trimAlgorithm(digest string) string {
+	if i := strings.IndexByte(digest, ':'); i >= 0 {
+		return digest[i+1:]
+	}
+
+	return digest
+}
+
+// matchAnnotation matches the images whose manifest has the annotation, with this value if it's not nil.
+func matchAnnotation(key string, value *string) func(manifest manifestResponse, manifestDigest string) bool {
+	return func(manifest manifestResponse, manifestDigest string) bool {
+		annotation, ok := manifest.Annotations[key]
+
+		return ok && (value == nil || annotation == *value)
+	}
+}
+
+// parseAnnotation splits an annotation filter in "key" or "key=value" format,
+// value is nil when only the key is given.
+func parseAnnotation(annotation string) (string, *string, bool) {
+	parts := strings.SplitN(annotation, "=", 2)
+
+	key := strings.TrimSpace(parts[0])
+	if key == "" {
+		return "", nil, false
+	}
+
+	if len(parts) == 1 {
+		return key, nil, true
+	}
+
+	return key, &parts[1], true
+}
+
 type cveByImageSearcher struct{}
 
 func (search cveByImageSearcher) search(config searchConfig) (bool, error) {
@@ -304,7 +485,22 @@ func collectResults(config searchConfig, wg *sync.WaitGroup, imageErr chan strin
 	defer wg.Done()
 	config.spinner.startSpinner()
 
+	// with a timeout all the results must come before it expires, otherwise each must come within waitTimeout
+	var deadline <-chan time.Time
+
+	if config.timeout > 0 {
+		timer := time.NewTimer(config.timeout)
+		defer timer.Stop()
+
+		deadline = timer.C
+	}
+
 	for {
+		expired := deadline
+		if expired == nil {
+			expired = time.After(waitTimeout)
+		}
+
 		select {
 		case result, ok := <-imageErr:
 			config.spinner.stopSpinner()
@@ -321,17 +517,17 @@ func collectResults(config searchConfig, wg *sync.WaitGroup, imageErr chan strin
 				return
 			}
 
-			if !foundResult && (*config.outputFormat == defaultOutoutFormat || *config.outputFormat == "") {
+			if !foundResult && config.template == nil && isTableFormat(*config.outputFormat) {
 				var builder strings.Builder
 
-				printHeader(&builder, *config.verbose)
+				printHeader(&builder, config)
 				fmt.Fprint(config.resultWriter, builder.String())
 			}
 
 			foundResult = true
 
 			fmt.Fprint(config.resultWriter, result.StrValue)
-		case <-time.After(waitTimeout):
+		case <-expired:
 			config.spinner.stopSpinner()
 			cancel()
 
@@ -418,46 +614,14 @@ type stringResult struct {
 	Err      error
 }
 
-type printHeader func(writer io.Writer, verbose bool)
+type printHeader func(writer io.Writer, config searchConfig)
 
-func printImageTableHeader(writer io.Writer, verbose bool) {
-	table := getImageTableWriter(writer)
-
-	table.SetColMinWidth(colImageNameIndex, imageNameWidth)
-	table.SetColMinWidth(colTagIndex, tagWidth)
-	table.SetColMinWidth(colDigestIndex, digestWidth)
-	table.SetColMinWidth(colSizeIndex, sizeWidth)
-
-	if verbose {
-		table.SetColMinWidth(colConfigIndex, configWidth)
-		table.SetColMinWidth(colLayersIndex, layersWidth)
-	}
-
-	row := make([]string, 6)
-
-	row[colImageNameIndex] = "IMAGE NAME"
-	row[colTagIndex] = "TAG"
-	row[colDigestIndex] = "DIGEST"
-	row[colSizeIndex] = "SIZE"
-
-	if verbose {
-		row[colConfigIndex] = "CONFIG"
-		row[colLayersIndex] = "LAYERS"
-	}
-
-	table.Append(row)
-	table.Render()
+func printImageTableHeader(writer io.Writer, config searchConfig) {
+	config.imageTable.printHeader(writer)
 }
 
-func printCVETableHeader(writer io.Writer, verbose bool) {
-	table := getCVETableWriter(writer)
-	row := make([]string, 3)
-	row[colCVEIDIndex] = "ID"
-	row[colCVESeverityIndex] = "SEVERITY"
-	row[colCVETitleIndex] = "TITLE"
-
-	table.Append(row)
-	table.Render()
+func printCVETableHeader(writer io.Writer, config searchConfig) {
+	config.cveTable.printHeader(writer)
 }
 
 const (
@@ -467,4 +631,5 @@ const (
 var (
 	errInvalidImageNameAndTag = errors.New("cli: Invalid input format. Expected IMAGENAME:TAG")
 	errInvalidImageName       = errors.New("cli: Invalid input format. Expected IMAGENAME without :TAG")
+	errInvalidAnnotation      = errors.New("cli: Invalid input format. Expected KEY or KEY=VALUE")
 )
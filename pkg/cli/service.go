@@ -4,12 +4,14 @@ package cli
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/dustin/go-humanize"
@@ -18,6 +20,9 @@ import (
 	"gopkg.in/yaml.v2"
 
 	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/api"
+	cveinfo "github.com/anuvu/zot/pkg/extensions/search/cve"
+	"github.com/anuvu/zot/pkg/extensions/search/semver"
 )
 
 type SearchService interface {
@@ -31,10 +36,15 @@ type SearchService interface {
 		channel chan stringResult, wg *sync.WaitGroup)
 	getImagesByDigest(ctx context.Context, config searchConfig, username, password, digest string,
 		channel chan stringResult, wg *sync.WaitGroup)
+	getImagesByAnnotation(ctx context.Context, config searchConfig, username, password, key string, value *string,
+		channel chan stringResult, wg *sync.WaitGroup)
+	getImagesByGitVersion(ctx context.Context, config searchConfig, username, password, version string,
+		channel chan stringResult, wg *sync.WaitGroup)
 	getImageByNameAndCVEID(ctx context.Context, config searchConfig, username, password, imageName, cveID string,
 		channel chan stringResult, wg *sync.WaitGroup)
 	getFixedTagsForCVE(ctx context.Context, config searchConfig, username, password, imageName, cveID string,
 		channel chan stringResult, wg *sync.WaitGroup)
+	getExtensions(config searchConfig, username, password string) (api.ExtensionList, bool)
 }
 
 type searchService struct{}
@@ -43,6 +53,12 @@ func NewSearchService() SearchService {
 	return searchService{}
 }
 
+// getExtensions returns the extensions listed by the server, false if it doesn't list them, e.g. because it
+// predates the discovery route.
+func (service searchService) getExtensions(config searchConfig, username, password string) (api.ExtensionList, bool) {
+	return discoverExtensions(*config.servURL, username, password, *config.verifyTLS)
+}
+
 func (service searchService) getImageByName(ctx context.Context, config searchConfig,
 	username, password, imageName string, c chan stringResult, wg *sync.WaitGroup) {
 	defer wg.Done()
@@ -131,7 +147,17 @@ func getImage(ctx context.Context, config searchConfig, username, password, imag
 		return
 	}
 
-	for _, tag := range tagsList.Tags {
+	tags := tagsList.Tags
+
+	if config.latest != nil {
+		tags = []string{}
+
+		if latest, ok := semver.Latest(tagsList.Tags, *config.latest); ok {
+			tags = append(tags, latest)
+		}
+	}
+
+	for _, tag := range tags {
 		wg.Add(1)
 
 		go addManifestCallToPool(ctx, config, pool, username, password, imageName, tag, c, wg)
@@ -161,16 +187,10 @@ func (service searchService) getImagesByCveID(ctx context.Context, config search
 	}
 
 	if result.Errors != nil || err != nil {
-		var errBuilder strings.Builder
-
-		for _, err := range result.Errors {
-			fmt.Fprintln(&errBuilder, err.Message)
-		}
-
 		if isContextDone(ctx) {
 			return
 		}
-		c <- stringResult{"", errors.New(errBuilder.String())} //nolint: goerr113
+		c <- stringResult{"", newGraphQLError(result.Errors)}
 
 		return
 	}
@@ -216,16 +236,64 @@ func (service searchService) getImagesByDigest(ctx context.Context, config searc
 	}
 
 	if result.Errors != nil {
-		var errBuilder strings.Builder
+		if isContextDone(ctx) {
+			return
+		}
+		c <- stringResult{"", newGraphQLError(result.Errors)}
+
+		return
+	}
+
+	var localWg sync.WaitGroup
+
+	p := newSmoothRateLimiter(ctx, &localWg, c)
+	localWg.Add(1)
+
+	go p.startRateLimiter()
+
+	for _, image := range result.Data.ImageListForDigest {
+		for _, tag := range image.Tags {
+			localWg.Add(1)
 
-		for _, err := range result.Errors {
-			fmt.Fprintln(&errBuilder, err.Message)
+			go addManifestCallToPool(ctx, config, p, username, password, image.Name, tag, c, &localWg)
 		}
+	}
+
+	localWg.Wait()
+}
 
+func (service searchService) getImagesByAnnotation(ctx context.Context, config searchConfig, username,
+	password string, key string, value *string, c chan stringResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(c)
+
+	args := "key: " + graphQLString(key)
+	if value != nil {
+		args += ", value: " + graphQLString(*value)
+	}
+
+	query := fmt.Sprintf(`{ImageListForAnnotation(%s) {`+`
+									Name Tags }
+							  }`,
+		args)
+	result := &imagesForAnnotation{}
+
+	err := service.makeGraphQLQuery(config, username, password, query, result)
+
+	if err != nil {
 		if isContextDone(ctx) {
 			return
 		}
-		c <- stringResult{"", errors.New(errBuilder.String())} //nolint: goerr113
+		c <- stringResult{"", err}
+
+		return
+	}
+
+	if result.Errors != nil {
+		if isContextDone(ctx) {
+			return
+		}
+		c <- stringResult{"", newGraphQLError(result.Errors)}
 
 		return
 	}
@@ -237,7 +305,7 @@ func (service searchService) getImagesByDigest(ctx context.Context, config searc
 
 	go p.startRateLimiter()
 
-	for _, image := range result.Data.ImageListForDigest {
+	for _, image := range result.Data.ImageListForAnnotation {
 		for _, tag := range image.Tags {
 			localWg.Add(1)
 
@@ -248,6 +316,64 @@ func (service searchService) getImagesByDigest(ctx context.Context, config searc
 	localWg.Wait()
 }
 
+func (service searchService) getImagesByGitVersion(ctx context.Context, config searchConfig, username,
+	password string, version string, c chan stringResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(c)
+
+	query := fmt.Sprintf(`{ImageListForGitVersion(version: %s) {`+`
+									Name Tags }
+							  }`,
+		graphQLString(version))
+	result := &imagesForGitVersion{}
+
+	err := service.makeGraphQLQuery(config, username, password, query, result)
+
+	if err != nil {
+		if isContextDone(ctx) {
+			return
+		}
+		c <- stringResult{"", err}
+
+		return
+	}
+
+	if result.Errors != nil {
+		if isContextDone(ctx) {
+			return
+		}
+		c <- stringResult{"", newGraphQLError(result.Errors)}
+
+		return
+	}
+
+	var localWg sync.WaitGroup
+
+	p := newSmoothRateLimiter(ctx, &localWg, c)
+	localWg.Add(1)
+
+	go p.startRateLimiter()
+
+	for _, image := range result.Data.ImageListForGitVersion {
+		for _, tag := range image.Tags {
+			localWg.Add(1)
+
+			go addManifestCallToPool(ctx, config, p, username, password, image.Name, tag, c, &localWg)
+		}
+	}
+
+	localWg.Wait()
+}
+
+// graphQLString quotes a string as a GraphQL string literal, annotation keys and values may contain any character.
+func graphQLString(value string) string {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+	quoted, _ := json.Marshal(value)
+
+	return string(quoted)
+}
+
 func (service searchService) getImageByNameAndCVEID(ctx context.Context, config searchConfig, username,
 	password, imageName, cveID string, c chan stringResult, wg *sync.WaitGroup) {
 	defer wg.Done()
@@ -271,16 +397,10 @@ func (service searchService) getImageByNameAndCVEID(ctx context.Context, config
 	}
 
 	if result.Errors != nil {
-		var errBuilder strings.Builder
-
-		for _, err := range result.Errors {
-			fmt.Fprintln(&errBuilder, err.Message)
-		}
-
 		if isContextDone(ctx) {
 			return
 		}
-		c <- stringResult{"", errors.New(errBuilder.String())} //nolint: goerr113
+		c <- stringResult{"", newGraphQLError(result.Errors)}
 
 		return
 	}
@@ -313,8 +433,9 @@ func (service searchService) getCveByImage(ctx context.Context, config searchCon
 	defer close(c)
 
 	query := fmt.Sprintf(`{ CVEListForImage (image:"%s")`+
-		` { Tag CVEList { Id Title Severity Description `+
-		`PackageList {Name InstalledVersion FixedVersion}} } }`, imageName)
+		` { Tag CVEList { Id Title Severity NormalizedSeverity Description `+
+		`CVSS {Source V2Vector V2Score V3Vector V3Score} `+
+		`PackageList {Name InstalledVersion FixedVersion Target}} } }`, imageName)
 	result := &cveResult{}
 
 	err := service.makeGraphQLQuery(config, username, password, query, result)
@@ -329,23 +450,21 @@ func (service searchService) getCveByImage(ctx context.Context, config searchCon
 	}
 
 	if result.Errors != nil {
-		var errBuilder strings.Builder
-
-		for _, err := range result.Errors {
-			fmt.Fprintln(&errBuilder, err.Message)
-		}
-
 		if isContextDone(ctx) {
 			return
 		}
-		c <- stringResult{"", errors.New(errBuilder.String())} //nolint: goerr113
+		c <- stringResult{"", newGraphQLError(result.Errors)}
 
 		return
 	}
 
+	thresholdErr := checkSeverityThreshold(result.Data.CVEListForImage.CVEList, config.severityThreshold)
+
 	result.Data.CVEListForImage.CVEList = groupCVEsBySeverity(result.Data.CVEListForImage.CVEList)
+	result.tmpl = config.template
+	result.table = config.cveTable
 
-	str, err := result.string(*config.outputFormat)
+	strs, err := result.targetStrings(*config.outputFormat)
 	if err != nil {
 		if isContextDone(ctx) {
 			return
@@ -355,31 +474,51 @@ func (service searchService) getCveByImage(ctx context.Context, config searchCon
 		return
 	}
 
-	if isContextDone(ctx) {
-		return
+	// each target is printed as soon as it's received, instead of rendering all of them at once
+	for _, str := range strs {
+		if isContextDone(ctx) {
+			return
+		}
+		c <- stringResult{str, nil}
 	}
-	c <- stringResult{str, nil}
-}
 
-func groupCVEsBySeverity(cveList []cve) []cve {
-	high := make([]cve, 0)
-	med := make([]cve, 0)
-	low := make([]cve, 0)
+	if thresholdErr != nil {
+		if isContextDone(ctx) {
+			return
+		}
+		c <- stringResult{"", thresholdErr}
+	}
+}
 
-	for _, cve := range cveList {
-		switch cve.Severity {
-		case "LOW":
-			low = append(low, cve)
+// checkSeverityThreshold fails if any of the CVEs is at least as severe as the threshold, if one is set.
+func checkSeverityThreshold(cveList []cve, threshold string) error {
+	if threshold == "" {
+		return nil
+	}
 
-		case "MEDIUM":
-			med = append(med, cve)
+	count := 0
 
-		case "HIGH":
-			high = append(high, cve)
+	for _, cve := range cveList {
+		if cveinfo.SeverityRank(cve.severity()) >= cveinfo.SeverityRank(threshold) {
+			count++
 		}
 	}
 
-	return append(append(high, med...), low...)
+	if count > 0 {
+		return fmt.Errorf("%w: %d CVEs of severity %s or higher", zotErrors.ErrCVEThresholdExceeded, count, threshold)
+	}
+
+	return nil
+}
+
+// groupCVEsBySeverity orders the CVEs from the most to the least severe, those of an unexpected
+// severity being last.
+func groupCVEsBySeverity(cveList []cve) []cve {
+	sort.SliceStable(cveList, func(i, j int) bool {
+		return cveinfo.SeverityRank(cveList[i].severity()) > cveinfo.SeverityRank(cveList[j].severity())
+	})
+
+	return cveList
 }
 
 func isContextDone(ctx context.Context) bool {
@@ -414,16 +553,10 @@ func (service searchService) getFixedTagsForCVE(ctx context.Context, config sear
 	}
 
 	if result.Errors != nil {
-		var errBuilder strings.Builder
-
-		for _, err := range result.Errors {
-			fmt.Fprintln(&errBuilder, err.Message)
-		}
-
 		if isContextDone(ctx) {
 			return
 		}
-		c <- stringResult{"", errors.New(errBuilder.String())} //nolint: goerr113
+		c <- stringResult{"", newGraphQLError(result.Errors)}
 
 		return
 	}
@@ -493,23 +626,75 @@ func addManifestCallToPool(ctx context.Context, config searchConfig, p *requests
 type cveResult struct {
 	Errors []errorGraphQL `json:"errors"`
 	Data   cveData        `json:"data"`
+	tmpl   *template.Template
+	table  *tableLayout
 }
 type errorGraphQL struct {
 	Message string   `json:"message"`
 	Path    []string `json:"path"`
 }
+
+// graphQLError joins the errors of a GraphQL response. The resolvers return the errors of the
+// storage as is, so their not found errors are matched by message.
+type graphQLError struct {
+	message string
+}
+
+func newGraphQLError(errs []errorGraphQL) error {
+	var errBuilder strings.Builder
+
+	for _, err := range errs {
+		fmt.Fprintln(&errBuilder, err.Message)
+	}
+
+	return &graphQLError{message: errBuilder.String()}
+}
+
+func (e *graphQLError) Error() string {
+	return e.message
+}
+
+func (e *graphQLError) Is(target error) bool {
+	if target != zotErrors.ErrRepoNotFound && target != zotErrors.ErrManifestNotFound { //nolint: goerr113
+		return false
+	}
+
+	return strings.Contains(e.message, target.Error())
+}
+
 type packageList struct {
 	Name             string `json:"Name"`
 	InstalledVersion string `json:"InstalledVersion"`
 	FixedVersion     string `json:"FixedVersion"`
+	Target           string `json:"Target,omitempty" yaml:"target,omitempty"`
+}
+type cvss struct {
+	Source   string  `json:"Source"`
+	V2Vector string  `json:"V2Vector"`
+	V2Score  float64 `json:"V2Score"`
+	V3Vector string  `json:"V3Vector"`
+	V3Score  float64 `json:"V3Score"`
 }
 type cve struct {
-	ID          string        `json:"Id"`
-	Severity    string        `json:"Severity"`
-	Title       string        `json:"Title"`
-	Description string        `json:"Description"`
-	PackageList []packageList `json:"PackageList"`
+	ID                 string        `json:"Id"`
+	Severity           string        `json:"Severity"`
+	NormalizedSeverity string        `json:"NormalizedSeverity,omitempty" yaml:"normalizedseverity,omitempty"`
+	Title              string        `json:"Title"`
+	Description        string        `json:"Description"`
+	CVSS               []cvss        `json:"CVSS,omitempty" yaml:"cvss,omitempty"`
+	PackageList        []packageList `json:"PackageList"`
 }
+
+// severity returns the severity of the CVE, computed from its CVSS scores by the server when the
+// scanner doesn't know it.
+func (c cve) severity() string {
+	if c.NormalizedSeverity != "" {
+		return c.NormalizedSeverity
+	}
+
+	return c.Severity
+}
+
 type cveListForImage struct {
 	Tag     string `json:"Tag"`
 	CVEList []cve  `json:"CVEList"`
@@ -519,33 +704,107 @@ type cveData struct {
 }
 
 func (cve cveResult) string(format string) (string, error) {
+	if cve.tmpl != nil {
+		return cve.stringTemplate()
+	}
+
 	switch strings.ToLower(format) {
-	case "", defaultOutoutFormat:
+	case "", defaultOutoutFormat, wideOutputFormat:
 		return cve.stringPlainText()
-	case "json":
+	case jsonOutputFormat:
 		return cve.stringJSON()
-	case "yml", "yaml":
+	case ymlOutputFormat, yamlOutputFormat:
 		return cve.stringYAML()
 	default:
 		return "", ErrInvalidOutputFormat
 	}
 }
 
+// targetStrings returns the output of the CVEs of each target trivy scanned, the OS packages or a
+// language lockfile, sorted by name. The JSON, YAML and template outputs aren't split.
+func (cve cveResult) targetStrings(format string) ([]string, error) {
+	if cve.tmpl != nil || !isTableFormat(format) {
+		str, err := cve.string(format)
+
+		return []string{str}, err
+	}
+
+	targets, cvesByTarget := splitCVEsByTarget(cve.Data.CVEListForImage.CVEList)
+	if len(targets) == 0 {
+		str, err := cve.stringPlainText()
+
+		return []string{str}, err
+	}
+
+	strs := make([]string, 0, len(targets))
+
+	for _, target := range targets {
+		targetResult := cve
+		targetResult.Data.CVEListForImage.CVEList = cvesByTarget[target]
+
+		str, err := targetResult.stringPlainText()
+		if err != nil {
+			return nil, err
+		}
+
+		// servers which don't report targets list all the CVEs under an empty one
+		if target != "" {
+			str = fmt.Sprintf("Target: %s\n%s", target, str)
+		}
+
+		strs = append(strs, str)
+	}
+
+	return strs, nil
+}
+
+// splitCVEsByTarget returns the sorted targets of the packages of the CVEs, and the CVEs of each of
+// them in their original order, a CVE found in several targets being listed with each.
+func splitCVEsByTarget(cveList []cve) ([]string, map[string][]cve) {
+	cvesByTarget := map[string][]cve{}
+
+	for _, c := range cveList {
+		seen := map[string]bool{}
+
+		for _, pkg := range c.PackageList {
+			if !seen[pkg.Target] {
+				seen[pkg.Target] = true
+
+				cvesByTarget[pkg.Target] = append(cvesByTarget[pkg.Target], c)
+			}
+		}
+
+		if len(c.PackageList) == 0 {
+			cvesByTarget[""] = append(cvesByTarget[""], c)
+		}
+	}
+
+	targets := make([]string, 0, len(cvesByTarget))
+	for target := range cvesByTarget {
+		targets = append(targets, target)
+	}
+
+	sort.Strings(targets)
+
+	return targets, cvesByTarget
+}
+
 func (cve cveResult) stringPlainText() (string, error) {
 	var builder strings.Builder
 
-	table := getCVETableWriter(&builder)
+	layout := cve.table
+	if layout == nil {
+		layout = newCVETableLayout(defaultOutoutFormat, 0)
+	}
 
-	for _, c := range cve.Data.CVEListForImage.CVEList {
-		id := ellipsize(c.ID, cveIDWidth, ellipsis)
-		title := ellipsize(c.Title, cveTitleWidth, ellipsis)
-		severity := ellipsize(c.Severity, cveSeverityWidth, ellipsis)
-		row := make([]string, 3)
-		row[colCVEIDIndex] = id
-		row[colCVESeverityIndex] = severity
-		row[colCVETitleIndex] = title
+	table := layout.newTable(&builder)
 
-		table.Append(row)
+	for _, c := range cve.Data.CVEListForImage.CVEList {
+		table.Append(layout.row(map[string]string{
+			columnCVEID:    c.ID,
+			columnSeverity: c.severity(),
+			columnTitle:    c.Title,
+		}))
 	}
 
 	table.Render()
@@ -574,6 +833,25 @@ func (cve cveResult) stringYAML() (string, error) {
 	return string(body), nil
 }
 
+func (cve cveResult) stringTemplate() (string, error) {
+	rows := make([]interface{}, 0, len(cve.Data.CVEListForImage.CVEList))
+
+	for _, c := range cve.Data.CVEListForImage.CVEList {
+		rows = append(rows, cveRow{
+			Tag:                cve.Data.CVEListForImage.Tag,
+			ID:                 c.ID,
+			Severity:           c.Severity,
+			NormalizedSeverity: c.severity(),
+			Title:              c.Title,
+			Description:        c.Description,
+			CVSS:               c.CVSS,
+			PackageList:        c.PackageList,
+		})
+	}
+
+	return executeTemplate(cve.tmpl, rows)
+}
+
 type fixedTags struct {
 	Errors []errorGraphQL `json:"errors"`
 	Data   struct {
@@ -600,6 +878,20 @@ type imagesForDigest struct {
 	} `json:"data"`
 }
 
+type imagesForAnnotation struct {
+	Errors []errorGraphQL `json:"errors"`
+	Data   struct {
+		ImageListForAnnotation []tagListResp `json:"ImageListForAnnotation"`
+	} `json:"data"`
+}
+
+type imagesForGitVersion struct {
+	Errors []errorGraphQL `json:"errors"`
+	Data   struct {
+		ImageListForGitVersion []tagListResp `json:"ImageListForGitVersion"`
+	} `json:"data"`
+}
+
 type tagListResp struct {
 	Name string   `json:"name"`
 	Tags []string `json:"tags"`
@@ -609,14 +901,126 @@ type imageStruct struct {
 	Name    string `json:"name"`
 	Tags    []tags `json:"tags"`
 	verbose bool
+	tmpl    *template.Template
+	table   *tableLayout
 }
 
 type tags struct {
-	Name         string  `json:"name"`
-	Size         uint64  `json:"size"`
-	Digest       string  `json:"digest"`
-	ConfigDigest string  `json:"configDigest"`
-	Layers       []layer `json:"layerDigests"`
+	Name         string      `json:"name"`
+	Size         uint64      `json:"size"`
+	Digest       string      `json:"digest"`
+	ConfigDigest string      `json:"configDigest"`
+	Layers       []layer     `json:"layerDigests"`
+	Warning      string      `json:"warning,omitempty" yaml:",omitempty"`
+	CVESummary   *cveSummary `json:"cveSummary,omitempty" yaml:"cvesummary,omitempty"`
+	BuildInfo    *buildInfo  `json:"buildInfo,omitempty" yaml:"buildinfo,omitempty"`
+	lastUpdated  *time.Time
+}
+
+// buildInfo tells how an image was built by stacker, from the annotations of its manifest.
+type buildInfo struct {
+	StackerYaml string `json:"stackerYaml"`
+	GitVersion  string `json:"gitVersion"`
+}
+
+// newBuildInfo returns the build info of a manifest, nil if it wasn't built by stacker.
+func newBuildInfo(manifest manifestResponse) *buildInfo {
+	stackerYaml, gitVersion := manifest.Annotations[stackerYamlAnnotation], manifest.Annotations[gitVersionAnnotation]
+	if stackerYaml == "" && gitVersion == "" {
+		return nil
+	}
+
+	return &buildInfo{
+		StackerYaml: stackerYaml,
+		GitVersion:  gitVersion,
+	}
+}
+
+// annotations of the manifests of the images built by stacker.
+const (
+	stackerYamlAnnotation = "ws.tycho.stacker.stacker_yaml"
+	gitVersionAnnotation  = "ws.tycho.stacker.git_version"
+)
+
+// gitVersion returns the git version the image was built from, "-" if it wasn't built by stacker.
+func (build *buildInfo) gitVersion() string {
+	if build == nil || build.GitVersion == "" {
+		return "-"
+	}
+
+	return build.GitVersion
+}
+
+// cveSummary counts the CVEs of an image by severity.
+type cveSummary struct {
+	Count       int    `json:"Count"`
+	MaxSeverity string `json:"MaxSeverity"`
+	Critical    int    `json:"Critical"`
+	High        int    `json:"High"`
+	Medium      int    `json:"Medium"`
+	Low         int    `json:"Low"`
+	Unknown     int    `json:"Unknown"`
+}
+
+// compact returns the counts of critical, high, medium and low CVEs, "-" if the image wasn't scanned.
+func (summary *cveSummary) compact() string {
+	if summary == nil {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d/%d/%d/%d", summary.Critical, summary.High, summary.Medium, summary.Low)
+}
+
+type imageCVESummaries struct {
+	Errors []errorGraphQL `json:"errors"`
+	Data   struct {
+		ImageList []struct {
+			Tag        string      `json:"Tag"`
+			CVESummary *cveSummary `json:"CVESummary"`
+		} `json:"ImageList"`
+	} `json:"data"`
+}
+
+// cveSummaries fetches the CVE summaries of the images of a repository with a single query, from the
+// scan results recorded by the server, and keeps them for the other tags of the repository.
+type cveSummaries struct {
+	lock  sync.Mutex
+	repos map[string]map[string]*cveSummary
+}
+
+func newCVESummaries() *cveSummaries {
+	return &cveSummaries{repos: make(map[string]map[string]*cveSummary)}
+}
+
+// get returns the CVE summary of an image, nil if it wasn't scanned.
+func (summaries *cveSummaries) get(config searchConfig, username, password, repo, tag string) (*cveSummary, error) {
+	summaries.lock.Lock()
+	defer summaries.lock.Unlock()
+
+	if tags, ok := summaries.repos[repo]; ok {
+		return tags[tag], nil
+	}
+
+	query := fmt.Sprintf(`{ImageList(repo: "%s") { Tag CVESummary { Count MaxSeverity Critical High Medium Low `+
+		`Unknown } } }`, repo)
+	result := &imageCVESummaries{}
+
+	if err := (searchService{}).makeGraphQLQuery(config, username, password, query, result); err != nil {
+		return nil, err
+	}
+
+	if result.Errors != nil {
+		return nil, newGraphQLError(result.Errors)
+	}
+
+	tags := make(map[string]*cveSummary)
+	for _, image := range result.Data.ImageList {
+		tags[image.Tag] = image.CVESummary
+	}
+
+	summaries.repos[repo] = tags
+
+	return tags[tag], nil
 }
 
 type layer struct {
@@ -625,12 +1029,18 @@ type layer struct {
 }
 
 func (img imageStruct) string(format string) (string, error) {
+	if img.tmpl != nil {
+		return img.stringTemplate()
+	}
+
 	switch strings.ToLower(format) {
-	case "", defaultOutoutFormat:
+	case "", defaultOutoutFormat, wideOutputFormat:
 		return img.stringPlainText()
-	case "json":
+	case jsonOutputFormat:
 		return img.stringJSON()
-	case "yml", "yaml":
+	case ndjsonOutputFormat:
+		return img.stringNDJSON()
+	case ymlOutputFormat, yamlOutputFormat:
 		return img.stringYAML()
 	default:
 		return "", ErrInvalidOutputFormat
@@ -640,57 +1050,60 @@ func (img imageStruct) string(format string) (string, error) {
 func (img imageStruct) stringPlainText() (string, error) {
 	var builder strings.Builder
 
-	table := getImageTableWriter(&builder)
-	table.SetColMinWidth(colImageNameIndex, imageNameWidth)
-	table.SetColMinWidth(colTagIndex, tagWidth)
-	table.SetColMinWidth(colDigestIndex, digestWidth)
-	table.SetColMinWidth(colSizeIndex, sizeWidth)
-
-	if img.verbose {
-		table.SetColMinWidth(colConfigIndex, configWidth)
-		table.SetColMinWidth(colLayersIndex, layersWidth)
+	layout := img.table
+	if layout == nil {
+		layout, _ = newImageTableLayout(nil, defaultOutoutFormat, img.verbose, 0)
 	}
 
+	table := layout.newTable(&builder)
+
 	for _, tag := range img.Tags {
-		imageName := ellipsize(img.Name, imageNameWidth, ellipsis)
-		tagName := ellipsize(tag.Name, tagWidth, ellipsis)
-		digest := ellipsize(tag.Digest, digestWidth, "")
-		size := ellipsize(strings.ReplaceAll(humanize.Bytes(tag.Size), " ", ""), sizeWidth, ellipsis)
-		config := ellipsize(tag.ConfigDigest, configWidth, "")
-		row := make([]string, 6)
-
-		row[colImageNameIndex] = imageName
-		row[colTagIndex] = tagName
-		row[colDigestIndex] = digest
-		row[colSizeIndex] = size
+		values := map[string]string{
+			columnName:       img.Name,
+			columnTag:        tag.Name,
+			columnDigest:     tag.Digest,
+			columnConfig:     tag.ConfigDigest,
+			columnLayers:     strconv.Itoa(len(tag.Layers)),
+			columnSize:       strings.ReplaceAll(humanize.Bytes(tag.Size), " ", ""),
+			columnWarning:    tag.Warning,
+			columnCVEs:       tag.CVESummary.compact(),
+			columnGitVersion: tag.BuildInfo.gitVersion(),
+		}
+
+		if tag.lastUpdated != nil {
+			values[columnUpdated] = humanize.Time(*tag.lastUpdated)
+		}
 
+		// verbose output lists the layers on the following rows
 		if img.verbose {
-			row[colConfigIndex] = config
-			row[colLayersIndex] = ""
+			values[columnLayers] = ""
 		}
 
-		table.Append(row)
+		table.Append(layout.row(values))
 
 		if img.verbose {
 			for _, entry := range tag.Layers {
-				layerSize := ellipsize(strings.ReplaceAll(humanize.Bytes(entry.Size), " ", ""), sizeWidth, ellipsis)
-				layerDigest := ellipsize(entry.Digest, digestWidth, "")
-
-				layerRow := make([]string, 6)
-				layerRow[colImageNameIndex] = ""
-				layerRow[colTagIndex] = ""
-				layerRow[colDigestIndex] = ""
-				layerRow[colSizeIndex] = layerSize
-				layerRow[colConfigIndex] = ""
-				layerRow[colLayersIndex] = layerDigest
-
-				table.Append(layerRow)
+				table.Append(layout.row(map[string]string{
+					columnSize:   strings.ReplaceAll(humanize.Bytes(entry.Size), " ", ""),
+					columnLayers: entry.Digest,
+				}))
 			}
 		}
 	}
 
 	table.Render()
 
+	// the stacker.yaml the images were built with follows their row, indented
+	for _, tag := range img.Tags {
+		if tag.BuildInfo == nil || tag.BuildInfo.StackerYaml == "" {
+			continue
+		}
+
+		for _, line := range strings.Split(strings.TrimRight(tag.BuildInfo.StackerYaml, "\n"), "\n") {
+			fmt.Fprintf(&builder, "%s%s\n", tablePadding, line)
+		}
+	}
+
 	return builder.String(), nil
 }
 
@@ -705,6 +1118,19 @@ func (img imageStruct) stringJSON() (string, error) {
 	return string(body), nil
 }
 
+// stringNDJSON returns the image as a single line of JSON, so that the images are printed as
+// newline delimited JSON as they are fetched.
+func (img imageStruct) stringNDJSON() (string, error) {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	body, err := json.Marshal(img)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(body) + "\n", nil
+}
+
 func (img imageStruct) stringYAML() (string, error) {
 	body, err := yaml.Marshal(&img)
 
@@ -715,6 +1141,32 @@ func (img imageStruct) stringYAML() (string, error) {
 	return string(body), nil
 }
 
+func (img imageStruct) stringTemplate() (string, error) {
+	rows := make([]interface{}, 0, len(img.Tags))
+
+	for _, tag := range img.Tags {
+		row := imageRow{
+			Name:         img.Name,
+			Tag:          tag.Name,
+			Digest:       tag.Digest,
+			ConfigDigest: tag.ConfigDigest,
+			Size:         tag.Size,
+			Layers:       tag.Layers,
+			Warning:      tag.Warning,
+			CVESummary:   tag.CVESummary,
+		}
+
+		if tag.BuildInfo != nil {
+			row.StackerYaml = tag.BuildInfo.StackerYaml
+			row.GitVersion = tag.BuildInfo.GitVersion
+		}
+
+		rows = append(rows, row)
+	}
+
+	return executeTemplate(img.tmpl, rows)
+}
+
 type catalogResponse struct {
 	Repositories []string `json:"repositories"`
 }
@@ -725,10 +1177,7 @@ type manifestResponse struct {
 		Digest    string `json:"digest"`
 		Size      uint64 `json:"size"`
 	} `json:"layers"`
-	Annotations struct {
-		WsTychoStackerStackerYaml string `json:"ws.tycho.stacker.stacker_yaml"`
-		WsTychoStackerGitVersion  string `json:"ws.tycho.stacker.git_version"`
-	} `json:"annotations"`
+	Annotations map[string]string `json:"annotations"`
 	Config struct {
 		Size      int    `json:"size"`
 		Digest    string `json:"digest"`
@@ -764,7 +1213,7 @@ func ellipsize(text string, max int, trailing string) string {
 	return text[:max-chopLength] + trailing
 }
 
-func getImageTableWriter(writer io.Writer) *tablewriter.Table {
+func getTableWriter(writer io.Writer) *tablewriter.Table {
 	table := tablewriter.NewWriter(writer)
 
 	table.SetAutoWrapText(false)
@@ -776,33 +1225,12 @@ func getImageTableWriter(writer io.Writer) *tablewriter.Table {
 	table.SetRowSeparator("")
 	table.SetHeaderLine(false)
 	table.SetBorder(false)
-	table.SetTablePadding("  ")
+	table.SetTablePadding(tablePadding)
 	table.SetNoWhiteSpace(true)
 
 	return table
 }
 
-func getCVETableWriter(writer io.Writer) *tablewriter.Table {
-	table := tablewriter.NewWriter(writer)
-
-	table.SetAutoWrapText(false)
-	table.SetAutoFormatHeaders(true)
-	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetCenterSeparator("")
-	table.SetColumnSeparator("")
-	table.SetRowSeparator("")
-	table.SetHeaderLine(false)
-	table.SetBorder(false)
-	table.SetTablePadding("  ")
-	table.SetNoWhiteSpace(true)
-	table.SetColMinWidth(colCVEIDIndex, cveIDWidth)
-	table.SetColMinWidth(colCVESeverityIndex, cveSeverityWidth)
-	table.SetColMinWidth(colCVETitleIndex, cveTitleWidth)
-
-	return table
-}
-
 const (
 	imageNameWidth = 32
 	tagWidth       = 24
@@ -812,20 +1240,13 @@ const (
 	layersWidth    = 8
 	ellipsis       = "..."
 
-	colImageNameIndex = 0
-	colTagIndex       = 1
-	colDigestIndex    = 2
-	colConfigIndex    = 3
-	colLayersIndex    = 4
-	colSizeIndex      = 5
-
 	cveIDWidth       = 16
 	cveSeverityWidth = 8
 	cveTitleWidth    = 48
 
-	colCVEIDIndex       = 0
-	colCVESeverityIndex = 1
-	colCVETitleIndex    = 2
-
 	defaultOutoutFormat = "text"
+	jsonOutputFormat    = "json"
+	ndjsonOutputFormat  = "ndjson"
+	ymlOutputFormat     = "yml"
+	yamlOutputFormat    = "yaml"
 )
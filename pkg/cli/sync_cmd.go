@@ -0,0 +1,240 @@
+// +build extended
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/extensions/sync"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+const syncStatusEndpoint = "/v2/_zot/admin/sync"
+
+func NewSyncCommand() *cobra.Command {
+	syncCmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Inspect the replication of images to downstream registries",
+		Long:  `Inspect the replication of the images pushed to a zot server to its downstream registries`,
+	}
+
+	syncCmd.AddCommand(newSyncStatusCommand())
+
+	return syncCmd
+}
+
+func newSyncStatusCommand() *cobra.Command {
+	var servURL, user, outputFormat string
+
+	statusCmd := &cobra.Command{
+		Use:   "status [config-name]",
+		Short: "Show the replication status of each downstream registry",
+		Long: `Show when images were last replicated to each downstream registry of a zot server, how many images
+and bytes were replicated, and the most recent failures. Conflicts are images refused by a registry,
+e.g. because the tag is immutable there, they are not retried. Images quarantined by the CVE policy
+of a registry are listed until an admin approves them.
+Admin credentials are needed if the server has authentication enabled.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverURL, verifyTLS, err := serverFromCommand(cmd, args)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			if serverURL == "" {
+				return zotErrors.ErrNoURLProvided
+			}
+
+			endPoint, err := combineServerAndEndpointURL(serverURL, syncStatusEndpoint)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			username, password := getUsernameAndPassword(user)
+			statuses := []sync.Status{}
+
+			if _, err := makeGETRequest(endPoint, username, password, verifyTLS, &statuses); err != nil {
+				return err
+			}
+
+			return printSyncStatus(cmd.OutOrStdout(), statuses, outputFormat)
+		},
+	}
+
+	statusCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	statusCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	statusCmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Specify output format [text/json/yaml]")
+
+	statusCmd.ValidArgsFunction = completeConfigNames
+
+	return statusCmd
+}
+
+func printSyncStatus(writer io.Writer, statuses []sync.Status, outputFormat string) error {
+	switch strings.ToLower(outputFormat) {
+	case "", defaultOutoutFormat:
+	case jsonOutputFormat:
+		body, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(writer, string(body))
+
+		return nil
+	case ymlOutputFormat, yamlOutputFormat:
+		body, err := yaml.Marshal(statuses)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(writer, string(body))
+
+		return nil
+	default:
+		return ErrInvalidOutputFormat
+	}
+
+	if len(statuses) == 0 {
+		fmt.Fprintln(writer, "no downstream registries, sync is not enabled")
+		return nil
+	}
+
+	registries := newSyncTableLayout()
+	registries.printHeader(writer)
+
+	table := registries.newTable(writer)
+
+	for _, status := range statuses {
+		lastSync := "never"
+		if status.LastSync != nil {
+			lastSync = humanize.Time(*status.LastSync)
+		}
+
+		table.Append(registries.row(map[string]string{
+			columnRegistry:    status.Registry,
+			columnLastSync:    lastSync,
+			columnImages:      strconv.FormatUint(status.ImagesSynced, 10),
+			columnTransferred: strings.ReplaceAll(humanize.Bytes(status.BytesTransferred), " ", ""),
+			columnQueued:      strconv.Itoa(status.Queued),
+			columnFailures:    strconv.FormatUint(status.FailureCount, 10),
+		}))
+	}
+
+	table.Render()
+
+	failures := newSyncFailureTableLayout()
+
+	for _, status := range statuses {
+		if len(status.Failures) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(writer, "\nRecent failures of %s:\n", status.Registry)
+		failures.printHeader(writer)
+
+		table := failures.newTable(writer)
+
+		for _, failure := range status.Failures {
+			reason := failure.Reason
+			if failure.Conflict {
+				reason = "conflict: " + reason
+			}
+
+			table.Append(failures.row(map[string]string{
+				columnTime:   humanize.Time(failure.Time),
+				columnImage:  failure.Repo + ":" + failure.Reference,
+				columnReason: reason,
+			}))
+		}
+
+		table.Render()
+	}
+
+	quarantined := newSyncQuarantineTableLayout()
+
+	for _, status := range statuses {
+		if len(status.Quarantined) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(writer, "\nQuarantined images of %s:\n", status.Registry)
+		quarantined.printHeader(writer)
+
+		table := quarantined.newTable(writer)
+
+		for _, image := range status.Quarantined {
+			table.Append(quarantined.row(map[string]string{
+				columnTime:     humanize.Time(image.Time),
+				columnImage:    image.Repo + ":" + image.Reference,
+				columnSeverity: image.Severity,
+			}))
+		}
+
+		table.Render()
+	}
+
+	return nil
+}
+
+// newSyncTableLayout returns the layout of the table of downstream registries, values aren't truncated.
+func newSyncTableLayout() *tableLayout {
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnRegistry, header: "REGISTRY", minWidth: registryWidth},
+			{name: columnLastSync, header: "LAST SYNC", minWidth: updatedWidth},
+			{name: columnImages, header: "IMAGES", minWidth: countWidth},
+			{name: columnTransferred, header: "TRANSFERRED", minWidth: sizeWidth},
+			{name: columnQueued, header: "QUEUED", minWidth: countWidth},
+			{name: columnFailures, header: "FAILURES", minWidth: countWidth},
+		},
+	}
+}
+
+// newSyncFailureTableLayout returns the layout of the table of replication failures of a registry.
+func newSyncFailureTableLayout() *tableLayout {
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnTime, header: "TIME", minWidth: updatedWidth},
+			{name: columnImage, header: "IMAGE", minWidth: imageNameWidth},
+			{name: columnReason, header: "REASON"},
+		},
+	}
+}
+
+// newSyncQuarantineTableLayout returns the layout of the table of quarantined images of a registry.
+func newSyncQuarantineTableLayout() *tableLayout {
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnTime, header: "TIME", minWidth: updatedWidth},
+			{name: columnImage, header: "IMAGE", minWidth: imageNameWidth},
+			{name: columnSeverity, header: "SEVERITY", minWidth: cveSeverityWidth},
+		},
+	}
+}
+
+const (
+	columnRegistry    = "registry"
+	columnLastSync    = "lastsync"
+	columnImages      = "images"
+	columnTransferred = "transferred"
+	columnQueued      = "queued"
+	columnFailures    = "failures"
+	columnTime        = "time"
+	columnImage       = "image"
+	columnReason      = "reason"
+
+	registryWidth = 32
+	countWidth    = 8
+)
@@ -0,0 +1,127 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/pkg/api"
+	"github.com/anuvu/zot/pkg/extensions/sync"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/resty.v1"
+)
+
+func TestSyncStatusCmd(t *testing.T) {
+	Convey("Test sync status from real server", t, func() {
+		port := getFreePort()
+		url := getBaseURL(port)
+		config := api.NewConfig()
+		config.HTTP.Port = port
+		config.HTTP.AllowAdminAccess = true
+		c := api.NewController(config)
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		c.Config.Storage.RootDirectory = dir
+		go func(controller *api.Controller) {
+			// this blocks
+			if err := controller.Run(); err != nil {
+				return
+			}
+		}(c)
+		// wait till ready
+		for {
+			_, err := resty.R().Get(url)
+			if err == nil {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		cmd := NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"sync", "status", "--url", url})
+		So(cmd.Execute(), ShouldBeNil)
+		So(buff.String(), ShouldEqual, "no downstream registries, sync is not enabled\n")
+
+		cmd = NewRootCmd()
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"sync", "status", "--url", url, "-o", "json"})
+		So(cmd.Execute(), ShouldBeNil)
+		So(buff.String(), ShouldEqual, "[]\n")
+
+		cmd = NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"sync", "status"})
+		So(cmd.Execute(), ShouldNotBeNil)
+	})
+
+	Convey("Test sync status output", t, func() {
+		lastSync := time.Now().Add(-time.Hour)
+		statuses := []sync.Status{
+			{
+				Registry:         "https://mirror.example.com",
+				LastSync:         &lastSync,
+				ImagesSynced:     12,
+				BytesTransferred: 3000000,
+				Queued:           1,
+				FailureCount:     2,
+				Failures: []sync.Failure{
+					{Repo: "prod/app", Reference: "1.0", Time: lastSync, Reason: "403 Forbidden", Conflict: true},
+					{Repo: "prod/app", Reference: "2.0", Time: lastSync, Reason: "502 Bad Gateway"},
+				},
+				Quarantined: []sync.QuarantinedImage{
+					{Repo: "prod/db", Reference: "5.7", Time: lastSync, Severity: "CRITICAL"},
+				},
+			},
+			{Registry: "https://backup.example.com"},
+		}
+
+		buff := bytes.NewBufferString("")
+		So(printSyncStatus(buff, statuses, ""), ShouldBeNil)
+
+		output := buff.String()
+		So(output, ShouldContainSubstring, "REGISTRY")
+		So(output, ShouldContainSubstring, "TRANSFERRED")
+		So(output, ShouldContainSubstring, "https://mirror.example.com")
+		So(output, ShouldContainSubstring, "1 hour ago")
+		So(output, ShouldContainSubstring, "3.0MB")
+		So(output, ShouldContainSubstring, "never")
+		So(output, ShouldContainSubstring, "Recent failures of https://mirror.example.com:")
+		So(output, ShouldNotContainSubstring, "Recent failures of https://backup.example.com")
+		So(output, ShouldContainSubstring, "prod/app:1.0")
+		So(output, ShouldContainSubstring, "conflict: 403 Forbidden")
+		So(output, ShouldContainSubstring, "502 Bad Gateway")
+		So(output, ShouldNotContainSubstring, "conflict: 502")
+		So(output, ShouldContainSubstring, "Quarantined images of https://mirror.example.com:")
+		So(output, ShouldNotContainSubstring, "Quarantined images of https://backup.example.com")
+		So(output, ShouldContainSubstring, "prod/db:5.7")
+		So(output, ShouldContainSubstring, "CRITICAL")
+
+		buff = bytes.NewBufferString("")
+		So(printSyncStatus(buff, statuses, "yaml"), ShouldBeNil)
+		So(buff.String(), ShouldContainSubstring, "conflict: true")
+
+		err := printSyncStatus(ioutil.Discard, statuses, "random")
+		So(errors.Is(err, ErrInvalidOutputFormat), ShouldBeTrue)
+	})
+}
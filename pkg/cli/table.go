@@ -0,0 +1,300 @@
+// +build extended
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"golang.org/x/crypto/ssh/terminal"
+
+	zotErrors "github.com/anuvu/zot/errors"
+)
+
+// tableColumn is a column of the image or CVE table.
+type tableColumn struct {
+	name     string
+	header   string
+	width    int    // longer values are truncated, 0 disables truncation
+	minWidth int    // keeps rows rendered by different tables aligned
+	trailing string // appended to truncated values
+	flexible bool   // the width follows the terminal size
+}
+
+// tableLayout holds the columns of a table, in display order.
+// It is computed once per command, so that the header and the rows of all results line up.
+type tableLayout struct {
+	columns []tableColumn
+}
+
+// newImageTableLayout selects the columns of the image table, defaults depend on the output format and verbosity.
+// termWidth is the width of the terminal the table is printed to, or 0 if it's not printed to a terminal.
+func newImageTableLayout(names []string, outputFormat string, verbose bool, termWidth int) (*tableLayout, error) {
+	if len(names) > 0 && !isTableFormat(outputFormat) {
+		return nil, zotErrors.ErrInvalidFlagsCombination
+	}
+
+	wide := strings.EqualFold(outputFormat, wideOutputFormat)
+
+	if len(names) == 0 {
+		names = defaultImageColumns(outputFormat, verbose)
+	}
+
+	layout := &tableLayout{}
+
+	for _, name := range names {
+		column, ok := imageColumns(verbose)[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", zotErrors.ErrUnknownColumn, name)
+		}
+
+		layout.columns = append(layout.columns, column)
+	}
+
+	layout.resize(wide, termWidth)
+
+	return layout, nil
+}
+
+// defaultImageColumns returns the columns of the image table when none are selected.
+func defaultImageColumns(outputFormat string, verbose bool) []string {
+	switch {
+	case strings.EqualFold(outputFormat, wideOutputFormat):
+		return []string{columnName, columnTag, columnDigest, columnConfig, columnLayers, columnSize, columnUpdated,
+			columnWarning}
+	case verbose:
+		return []string{columnName, columnTag, columnDigest, columnConfig, columnLayers, columnSize}
+	default:
+		return []string{columnName, columnTag, columnDigest, columnSize}
+	}
+}
+
+// newCVETableLayout returns the layout of the CVE table.
+func newCVETableLayout(outputFormat string, termWidth int) *tableLayout {
+	layout := &tableLayout{
+		columns: []tableColumn{
+			{name: columnCVEID, header: "ID", width: cveIDWidth, trailing: ellipsis},
+			{name: columnSeverity, header: "SEVERITY", width: cveSeverityWidth, trailing: ellipsis},
+			{name: columnTitle, header: "TITLE", width: cveTitleWidth, trailing: ellipsis, flexible: true},
+		},
+	}
+
+	for i := range layout.columns {
+		layout.columns[i].minWidth = layout.columns[i].width
+	}
+
+	layout.resize(strings.EqualFold(outputFormat, wideOutputFormat), termWidth)
+
+	return layout
+}
+
+func imageColumns(verbose bool) map[string]tableColumn {
+	columns := map[string]tableColumn{
+		columnName:       {header: "IMAGE NAME", width: imageNameWidth, trailing: ellipsis, flexible: true},
+		columnTag:        {header: "TAG", width: tagWidth, trailing: ellipsis, flexible: true},
+		columnDigest:     {header: "DIGEST", width: digestWidth},
+		columnConfig:     {header: "CONFIG", width: configWidth},
+		columnLayers:     {header: "LAYERS", width: layersWidth},
+		columnSize:       {header: "SIZE", width: sizeWidth, trailing: ellipsis},
+		columnUpdated:    {header: "UPDATED", width: updatedWidth, trailing: ellipsis},
+		columnWarning:    {header: "WARNING", width: warningWidth, trailing: ellipsis, flexible: true},
+		columnCVEs:       {header: "CVES C/H/M/L", width: cveSummaryWidth},
+		columnGitVersion: {header: "GIT VERSION", width: gitVersionWidth, trailing: ellipsis, flexible: true},
+	}
+
+	for name, column := range columns {
+		column.name = name
+		column.minWidth = column.width
+
+		columns[name] = column
+	}
+
+	// verbose output lists the layer digests in the LAYERS column instead of their count
+	if verbose {
+		column := columns[columnLayers]
+		column.minWidth = digestWidth
+
+		columns[columnLayers] = column
+	}
+
+	return columns
+}
+
+// resize disables truncation for wide output,
+// otherwise the flexible columns are resized to the terminal width.
+func (layout *tableLayout) resize(wide bool, termWidth int) {
+	if wide {
+		for i := range layout.columns {
+			column := &layout.columns[i]
+
+			switch column.name {
+			case columnDigest, columnConfig:
+				column.minWidth = fullDigestWidth
+			case columnLayers:
+				if column.minWidth == digestWidth {
+					column.minWidth = fullDigestWidth
+				}
+			}
+
+			column.width = 0
+		}
+
+		return
+	}
+
+	if termWidth > 0 {
+		layout.fit(termWidth)
+	}
+}
+
+// fit resizes the flexible columns so that rows take the whole terminal width,
+// a flexible column is kept between minFlexibleWidth and maxFlexibleGrowth times its default width.
+func (layout *tableLayout) fit(termWidth int) {
+	fixed := len(tablePadding) * (len(layout.columns) - 1)
+	flexible := 0
+
+	for _, column := range layout.columns {
+		if column.flexible {
+			flexible += column.width
+		} else {
+			fixed += column.minWidth
+		}
+	}
+
+	if flexible == 0 {
+		return
+	}
+
+	available := termWidth - fixed
+
+	for i := range layout.columns {
+		column := &layout.columns[i]
+		if !column.flexible {
+			continue
+		}
+
+		width := available * column.width / flexible
+
+		if width > maxFlexibleGrowth*column.width {
+			width = maxFlexibleGrowth * column.width
+		}
+
+		if width < minFlexibleWidth {
+			width = minFlexibleWidth
+		}
+
+		if width < len(column.header) {
+			width = len(column.header)
+		}
+
+		column.width = width
+		column.minWidth = width
+	}
+}
+
+func (layout *tableLayout) has(name string) bool {
+	if layout == nil {
+		return false
+	}
+
+	for _, column := range layout.columns {
+		if column.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (layout *tableLayout) newTable(writer io.Writer) *tablewriter.Table {
+	table := getTableWriter(writer)
+
+	for i, column := range layout.columns {
+		table.SetColMinWidth(i, column.minWidth)
+	}
+
+	return table
+}
+
+// row returns the cells of a table row, values of columns which aren't displayed are dropped.
+func (layout *tableLayout) row(values map[string]string) []string {
+	row := make([]string, len(layout.columns))
+
+	for i, column := range layout.columns {
+		if column.width == 0 {
+			row[i] = strings.TrimSpace(values[column.name])
+
+			continue
+		}
+
+		row[i] = ellipsize(values[column.name], column.width, column.trailing)
+	}
+
+	return row
+}
+
+func (layout *tableLayout) printHeader(writer io.Writer) {
+	table := layout.newTable(writer)
+	row := make([]string, len(layout.columns))
+
+	for i, column := range layout.columns {
+		row[i] = column.header
+	}
+
+	table.Append(row)
+	table.Render()
+}
+
+func isTableFormat(outputFormat string) bool {
+	switch strings.ToLower(outputFormat) {
+	case "", defaultOutoutFormat, wideOutputFormat:
+		return true
+	default:
+		return false
+	}
+}
+
+// terminalWidth returns the width of the terminal the writer prints to, or 0 if it's not a terminal.
+func terminalWidth(writer io.Writer) int {
+	file, ok := writer.(*os.File)
+	if !ok {
+		return 0
+	}
+
+	width, _, err := terminal.GetSize(int(file.Fd()))
+	if err != nil {
+		return 0
+	}
+
+	return width
+}
+
+const (
+	columnName       = "name"
+	columnTag        = "tag"
+	columnDigest     = "digest"
+	columnConfig     = "config"
+	columnLayers     = "layers"
+	columnSize       = "size"
+	columnUpdated    = "updated"
+	columnWarning    = "warning"
+	columnCVEs       = "cves"
+	columnGitVersion = "gitversion"
+
+	columnCVEID    = "id"
+	columnSeverity = "severity"
+	columnTitle    = "title"
+
+	wideOutputFormat  = "wide"
+	tablePadding      = "  "
+	updatedWidth      = 16
+	warningWidth      = 32
+	cveSummaryWidth   = 12
+	gitVersionWidth   = 24
+	fullDigestWidth   = 64
+	minFlexibleWidth  = 8
+	maxFlexibleGrowth = 2
+)
@@ -0,0 +1,194 @@
+// +build extended
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+func NewTagsCommand() *cobra.Command {
+	tagsCmd := &cobra.Command{
+		Use:   "tags",
+		Short: "Inspect the tags of images hosted on zot",
+		Long:  `Inspect the tags of the images hosted on a zot server`,
+	}
+
+	tagsCmd.AddCommand(newTagsHistoryCommand())
+
+	return tagsCmd
+}
+
+func newTagsHistoryCommand() *cobra.Command {
+	var servURL, user, outputFormat, image string
+
+	historyCmd := &cobra.Command{
+		Use:   "history [config-name]",
+		Short: "Show the manifests a tag pointed to over time",
+		Long: `Show when a tag was created, re-pointed to another manifest or deleted, the oldest change first,
+e.g. to investigate which image was pulled by a tag at the time of an incident. Changes are kept for
+deleted tags and repositories too, up to the last 100 changes of each tag.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !validateImageNameTag(image) {
+				return errInvalidImageNameAndTag
+			}
+
+			serverURL, verifyTLS, err := serverFromCommand(cmd, args)
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			if serverURL == "" {
+				return zotErrors.ErrNoURLProvided
+			}
+
+			endPoint, err := combineServerAndEndpointURL(serverURL, "/query")
+			if err != nil {
+				cmd.SilenceUsage = true
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			i := strings.IndexByte(image, ':')
+			repo, tag := strings.TrimSpace(image[:i]), strings.TrimSpace(image[i+1:])
+			username, password := getUsernameAndPassword(user)
+			query := fmt.Sprintf(`{TagHistory(repo: %q, tag: %q) {Digest PreviousDigest Time}}`, repo, tag)
+			result := &tagHistoryResult{}
+
+			if err := makeGraphQLRequest(endPoint, query, username, password, verifyTLS, result); err != nil {
+				return err
+			}
+
+			if result.Errors != nil {
+				return newGraphQLError(result.Errors)
+			}
+
+			return printTagHistory(cmd.OutOrStdout(), result.Data.TagHistory, outputFormat)
+		},
+	}
+
+	historyCmd.Flags().StringVarP(&image, "image", "I", "", "The tag to show the history of, as IMAGENAME:TAG")
+	historyCmd.Flags().StringVar(&servURL, "url", "", "Specify zot server URL if config-name is not mentioned")
+	historyCmd.Flags().StringVarP(&user, "user", "u", "", `User Credentials of zot server in "username:password" format`)
+	historyCmd.Flags().StringVarP(&outputFormat, "output", "o", "", "Specify output format [text/wide/json/yaml]")
+
+	historyCmd.ValidArgsFunction = completeConfigNames
+	_ = historyCmd.RegisterFlagCompletionFunc("image", completeImageNames)
+
+	return historyCmd
+}
+
+type tagEvent struct {
+	Digest         string    `json:"Digest,omitempty" yaml:"digest,omitempty"`
+	PreviousDigest string    `json:"PreviousDigest,omitempty" yaml:"previousdigest,omitempty"`
+	Time           time.Time `json:"Time" yaml:"time"`
+}
+
+// change describes what happened to the tag.
+func (event tagEvent) change() string {
+	switch {
+	case event.PreviousDigest == "":
+		return "created"
+	case event.Digest == "":
+		return "deleted"
+	default:
+		return "moved"
+	}
+}
+
+type tagHistoryResult struct {
+	Errors []errorGraphQL `json:"errors"`
+	Data   struct {
+		TagHistory []tagEvent `json:"TagHistory"`
+	} `json:"data"`
+}
+
+func printTagHistory(writer io.Writer, events []tagEvent, outputFormat string) error {
+	wide := false
+
+	switch strings.ToLower(outputFormat) {
+	case "", defaultOutoutFormat:
+	case wideOutputFormat:
+		wide = true
+	case jsonOutputFormat:
+		body, err := json.MarshalIndent(events, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(writer, string(body))
+
+		return nil
+	case ymlOutputFormat, yamlOutputFormat:
+		body, err := yaml.Marshal(events)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(writer, string(body))
+
+		return nil
+	default:
+		return ErrInvalidOutputFormat
+	}
+
+	if len(events) == 0 {
+		fmt.Fprintln(writer, "no recorded changes")
+		return nil
+	}
+
+	shortDigest := func(digest string) string {
+		if i := strings.IndexByte(digest, ':'); i >= 0 && !wide {
+			return ellipsize(digest[i+1:], digestWidth, "")
+		}
+
+		return digest
+	}
+
+	history := newTagHistoryTableLayout(wide)
+	history.printHeader(writer)
+
+	table := history.newTable(writer)
+
+	for _, event := range events {
+		table.Append(history.row(map[string]string{
+			columnTime:     event.Time.Local().Format(time.RFC3339),
+			columnChange:   event.change(),
+			columnDigest:   shortDigest(event.Digest),
+			columnPrevious: shortDigest(event.PreviousDigest),
+		}))
+	}
+
+	table.Render()
+
+	return nil
+}
+
+// newTagHistoryTableLayout returns the layout of the table of the changes of a tag.
+func newTagHistoryTableLayout(wide bool) *tableLayout {
+	width := digestWidth
+	if wide {
+		width = fullDigestWidth
+	}
+
+	return &tableLayout{
+		columns: []tableColumn{
+			{name: columnTime, header: "TIME", minWidth: len(time.RFC3339)},
+			{name: columnChange, header: "CHANGE", minWidth: countWidth},
+			{name: columnDigest, header: "DIGEST", minWidth: width},
+			{name: columnPrevious, header: "PREVIOUS", minWidth: width},
+		},
+	}
+}
+
+const columnPrevious = "previous"
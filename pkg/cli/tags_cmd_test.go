@@ -0,0 +1,99 @@
+// +build extended
+
+package cli //nolint:testpackage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/anuvu/zot/pkg/api"
+	ext "github.com/anuvu/zot/pkg/extensions"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTagsHistoryCmd(t *testing.T) {
+	Convey("Test tag history from real server", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		if err := copyFiles("../../test/data/zot-test", path.Join(dir, "app")); err != nil {
+			panic(err)
+		}
+
+		url, c := startTestServer(dir, func(config *api.Config) {
+			config.Extensions = &ext.ExtensionConfig{Search: &ext.SearchConfig{Enable: true}}
+		})
+		defer func(controller *api.Controller) {
+			ctx := context.Background()
+			_ = controller.Server.Shutdown(ctx)
+		}(c)
+
+		imgStore := c.StoreController.DefaultStore
+		content, digest, mediaType, err := imgStore.GetImageManifest("app", "0.0.1")
+		So(err, ShouldBeNil)
+		_, err = imgStore.PutImageManifest("app", "latest", mediaType, content)
+		So(err, ShouldBeNil)
+		So(imgStore.DeleteImageManifest("app", "latest"), ShouldBeNil)
+
+		cmd := NewRootCmd()
+		buff := bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"tags", "history", "--url", url, "-I", "app:latest"})
+		So(cmd.Execute(), ShouldBeNil)
+
+		short := strings.TrimPrefix(digest, "sha256:")[:digestWidth]
+		space := regexp.MustCompile(`\s+`)
+		lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+		So(len(lines), ShouldEqual, 3)
+		So(space.ReplaceAllString(strings.TrimSpace(lines[0]), " "), ShouldEqual, "TIME CHANGE DIGEST PREVIOUS")
+		So(space.ReplaceAllString(strings.TrimSpace(lines[1]), " "), ShouldEndWith, " created "+short)
+		So(space.ReplaceAllString(strings.TrimSpace(lines[2]), " "), ShouldEndWith, " deleted "+short)
+
+		cmd = NewRootCmd()
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"tags", "history", "--url", url, "-I", "app:latest", "-o", "json"})
+		So(cmd.Execute(), ShouldBeNil)
+
+		var events []tagEvent
+
+		So(json.Unmarshal(buff.Bytes(), &events), ShouldBeNil)
+		So(len(events), ShouldEqual, 2)
+		So(events[0].Digest, ShouldEqual, digest)
+		So(events[0].PreviousDigest, ShouldBeEmpty)
+		So(events[1].Digest, ShouldBeEmpty)
+		So(events[1].PreviousDigest, ShouldEqual, digest)
+
+		cmd = NewRootCmd()
+		buff = bytes.NewBufferString("")
+		cmd.SetOut(buff)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"tags", "history", "--url", url, "-I", "app:1.0"})
+		So(cmd.Execute(), ShouldBeNil)
+		So(buff.String(), ShouldEqual, "no recorded changes\n")
+
+		cmd = NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"tags", "history", "--url", url, "-I", "unknown:1.0"})
+		So(cmd.Execute(), ShouldNotBeNil)
+
+		cmd = NewRootCmd()
+		cmd.SetOut(ioutil.Discard)
+		cmd.SetErr(ioutil.Discard)
+		cmd.SetArgs([]string{"tags", "history", "--url", url, "-I", "app"})
+		So(cmd.Execute(), ShouldEqual, errInvalidImageNameAndTag)
+	})
+}
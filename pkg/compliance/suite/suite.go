@@ -0,0 +1,776 @@
+// Package suite checks that a running registry, zot or any other one, conforms to the OCI distribution
+// spec, without the testing package, so that users can validate their deployment with zot compliance run.
+package suite
+
+import (
+	"bytes"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Categories of the checks.
+const (
+	CategoryPush       = "push"
+	CategoryPull       = "pull"
+	CategoryPagination = "pagination"
+	CategoryDelete     = "delete"
+	CategoryErrors     = "errors"
+)
+
+const (
+	contentDigestHeader = "Docker-Content-Digest"
+	defaultNamespace    = "zot-compliance"
+	paginatedTags       = 5
+	pageSize            = 2
+)
+
+// Config is the registry to check. The checks push to repositories under Namespace, and delete the
+// images they pushed.
+type Config struct {
+	URL       string // e.g. https://registry.example.org:5000
+	Username  string // basic authentication, if set
+	Password  string
+	Namespace string       // defaults to zot-compliance/<random>
+	Client    *http.Client // defaults to http.DefaultClient
+}
+
+// Result is the outcome of a check. Checks of optional features which the registry doesn't support,
+// as allowed by the spec, are passed with the reason they were skipped.
+type Result struct {
+	Name     string        `json:"name"`
+	Category string        `json:"category"`
+	Passed   bool          `json:"passed"`
+	Skipped  string        `json:"skipped,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// skipped is returned by the checks of an optional feature which the registry doesn't support.
+type skipped string
+
+func (s skipped) Error() string {
+	return string(s)
+}
+
+type check struct {
+	name     string
+	category string
+	run      func(s *session) error
+}
+
+// session holds the images pushed by the checks, those of a category rely on the images pushed by
+// the previous ones.
+type session struct {
+	config         Config
+	baseURL        string
+	repo           string
+	mountRepo      string
+	layer          []byte
+	layerDigest    godigest.Digest
+	imageConfig    []byte
+	configDigest   godigest.Digest
+	manifest       []byte
+	manifestDigest godigest.Digest
+}
+
+// response is what the checks need of a response, its body being already read and closed.
+type response struct {
+	StatusCode    int
+	Header        http.Header
+	ContentLength int64
+	path          string
+}
+
+func checks() []check {
+	return []check{
+		{"base API", CategoryPush, checkBaseAPI},
+		{"monolithic blob upload", CategoryPush, checkMonolithicUpload},
+		{"chunked blob upload", CategoryPush, checkChunkedUpload},
+		{"blob existence", CategoryPush, checkBlobExists},
+		{"cross-repository blob mount", CategoryPush, checkBlobMount},
+		{"manifest push", CategoryPush, checkManifestPush},
+		{"manifest pull by tag", CategoryPull, checkManifestPullByTag},
+		{"manifest pull by digest", CategoryPull, checkManifestPullByDigest},
+		{"blob pull", CategoryPull, checkBlobPull},
+		{"tags list", CategoryPull, checkTagsList},
+		{"tags pagination", CategoryPagination, checkTagsPagination},
+		{"catalog pagination", CategoryPagination, checkCatalogPagination},
+		{"upload cancel", CategoryDelete, checkUploadCancel},
+		{"manifest delete", CategoryDelete, checkManifestDelete},
+		{"blob delete", CategoryDelete, checkBlobDelete},
+		{"unknown manifest", CategoryErrors, checkUnknownManifest},
+		{"unknown blob", CategoryErrors, checkUnknownBlob},
+		{"invalid digest", CategoryErrors, checkInvalidDigest},
+		{"manifest with unknown blobs", CategoryErrors, checkManifestUnknownBlob},
+		{"invalid repository name", CategoryErrors, checkInvalidName},
+	}
+}
+
+// Run runs the checks in order, each result is passed to report as soon as it's known, e.g. to print
+// the progress, and all of them are returned.
+func Run(config Config, report func(Result)) []Result {
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+
+	if config.Namespace == "" {
+		config.Namespace = defaultNamespace + "/" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	layer := []byte("layer pushed by the zot compliance checks at " + time.Now().String())
+
+	imageConfig, _ := json.Marshal(ispec.Image{})
+
+	s := &session{
+		config:       config,
+		baseURL:      strings.TrimSuffix(config.URL, "/"),
+		repo:         config.Namespace + "/repo",
+		mountRepo:    config.Namespace + "/mount",
+		layer:        layer,
+		layerDigest:  godigest.FromBytes(layer),
+		imageConfig:  imageConfig,
+		configDigest: godigest.FromBytes(imageConfig),
+	}
+
+	manifest := ispec.Manifest{
+		Config: ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    s.configDigest,
+			Size:      int64(len(s.imageConfig)),
+		},
+		Layers: []ispec.Descriptor{
+			{
+				MediaType: ispec.MediaTypeImageLayer,
+				Digest:    s.layerDigest,
+				Size:      int64(len(s.layer)),
+			},
+		},
+	}
+	manifest.SchemaVersion = 2
+
+	s.manifest, _ = json.Marshal(manifest)
+	s.manifestDigest = godigest.FromBytes(s.manifest)
+
+	results := []Result{}
+
+	for _, c := range checks() {
+		start := time.Now()
+		err := c.run(s)
+		result := Result{Name: c.name, Category: c.category, Passed: err == nil, Duration: time.Since(start)}
+
+		var skip skipped
+
+		switch {
+		case goerrors.As(err, &skip):
+			result.Passed = true
+			result.Skipped = skip.Error()
+		case err != nil:
+			result.Error = err.Error()
+		}
+
+		if report != nil {
+			report(result)
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// do sends a request to a path of the registry or to a URL it returned, e.g. in a Location header.
+func (s *session) do(method, target string, body []byte, headers map[string]string) (*response, []byte, error) {
+	if strings.HasPrefix(target, "/") {
+		target = s.baseURL + target
+	}
+
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	if s.config.Username != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+
+	resp, err := s.config.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &response{
+		StatusCode:    resp.StatusCode,
+		Header:        resp.Header,
+		ContentLength: resp.ContentLength,
+		path:          req.URL.Path,
+	}, content, nil
+}
+
+// expect sends a request and checks its response has one of the given status codes.
+func (s *session) expect(method, target string, body []byte, headers map[string]string,
+	statuses ...int) (*response, []byte, error) {
+	resp, content, err := s.do(method, target, body, headers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			return resp, content, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%w: %s %s returned %d, expected %s", errors.ErrComplianceCheckFailed, method,
+		resp.path, resp.StatusCode, joinStatuses(statuses))
+}
+
+func joinStatuses(statuses []int) string {
+	values := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		values = append(values, strconv.Itoa(status))
+	}
+
+	return strings.Join(values, " or ")
+}
+
+func mismatch(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errors.ErrComplianceCheckFailed, fmt.Sprintf(format, args...))
+}
+
+// withDigest adds the digest query parameter to an upload URL, which may already have parameters.
+func withDigest(location string, digest godigest.Digest) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("digest", digest.String())
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// startUpload starts an upload session in the repository and returns its location.
+func (s *session) startUpload(repo string) (string, error) {
+	resp, _, err := s.expect(http.MethodPost, "/v2/"+repo+"/blobs/uploads/", nil, nil, http.StatusAccepted)
+	if err != nil {
+		return "", err
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", mismatch("no Location header in the response starting an upload")
+	}
+
+	return location, nil
+}
+
+// checkErrorBody checks the body of a failed request lists errors with one of the given codes, any code if
+// none is given.
+func checkErrorBody(content []byte, codes ...string) error {
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if err := json.Unmarshal(content, &body); err != nil || len(body.Errors) == 0 {
+		return mismatch("the response doesn't list errors")
+	}
+
+	if len(codes) == 0 {
+		return nil
+	}
+
+	for _, e := range body.Errors {
+		for _, code := range codes {
+			if e.Code == code {
+				return nil
+			}
+		}
+	}
+
+	return mismatch("error code %s, expected %s", body.Errors[0].Code, strings.Join(codes, " or "))
+}
+
+func checkBaseAPI(s *session) error {
+	resp, _, err := s.do(http.MethodGet, "/v2/", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return mismatch("GET /v2/ returned 401, check the credentials")
+	default:
+		return mismatch("GET /v2/ returned %d, expected 200", resp.StatusCode)
+	}
+}
+
+func checkMonolithicUpload(s *session) error {
+	location, err := s.startUpload(s.repo)
+	if err != nil {
+		return err
+	}
+
+	target, err := withDigest(location, s.layerDigest)
+	if err != nil {
+		return err
+	}
+
+	resp, _, err := s.expect(http.MethodPut, target, s.layer,
+		map[string]string{"Content-Type": "application/octet-stream"}, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+
+	if digest := resp.Header.Get(contentDigestHeader); digest != s.layerDigest.String() {
+		return mismatch("uploaded blob digest %q, expected %s", digest, s.layerDigest)
+	}
+
+	return nil
+}
+
+func checkChunkedUpload(s *session) error {
+	location, err := s.startUpload(s.repo)
+	if err != nil {
+		return err
+	}
+
+	half := len(s.imageConfig) / 2 // nolint: gomnd
+	chunks := [][]byte{s.imageConfig[:half], s.imageConfig[half:]}
+	offset := 0
+
+	for _, chunk := range chunks {
+		resp, _, err := s.expect(http.MethodPatch, location, chunk, map[string]string{
+			"Content-Type":  "application/octet-stream",
+			"Content-Range": fmt.Sprintf("%d-%d", offset, offset+len(chunk)-1),
+		}, http.StatusAccepted)
+		if err != nil {
+			return err
+		}
+
+		offset += len(chunk)
+
+		// some registries prefix the range with its unit, as in a Content-Range header
+		if rng := strings.TrimPrefix(resp.Header.Get("Range"), "bytes="); rng != fmt.Sprintf("0-%d", offset-1) {
+			return mismatch("upload range %q after %d bytes", rng, offset)
+		}
+
+		if next := resp.Header.Get("Location"); next != "" {
+			location = next
+		}
+	}
+
+	target, err := withDigest(location, s.configDigest)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = s.expect(http.MethodPut, target, nil, nil, http.StatusCreated)
+
+	return err
+}
+
+func checkBlobExists(s *session) error {
+	resp, _, err := s.expect(http.MethodHead, "/v2/"+s.repo+"/blobs/"+s.layerDigest.String(), nil, nil,
+		http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	if resp.ContentLength != int64(len(s.layer)) {
+		return mismatch("blob Content-Length %d, expected %d", resp.ContentLength, len(s.layer))
+	}
+
+	return nil
+}
+
+func checkBlobMount(s *session) error {
+	resp, _, err := s.expect(http.MethodPost,
+		fmt.Sprintf("/v2/%s/blobs/uploads/?mount=%s&from=%s", s.mountRepo, s.layerDigest, s.repo), nil, nil,
+		http.StatusCreated, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusAccepted {
+		// an upload was started instead, as allowed by the spec
+		if location := resp.Header.Get("Location"); location != "" {
+			_, _, _ = s.do(http.MethodDelete, location, nil, nil)
+		}
+
+		return skipped("cross-repository mounts aren't supported")
+	}
+
+	_, _, err = s.expect(http.MethodHead, "/v2/"+s.mountRepo+"/blobs/"+s.layerDigest.String(), nil, nil,
+		http.StatusOK)
+
+	return err
+}
+
+func checkManifestPush(s *session) error {
+	resp, _, err := s.expect(http.MethodPut, "/v2/"+s.repo+"/manifests/v1", s.manifest,
+		map[string]string{"Content-Type": ispec.MediaTypeImageManifest}, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+
+	if digest := resp.Header.Get(contentDigestHeader); digest != s.manifestDigest.String() {
+		return mismatch("pushed manifest digest %q, expected %s", digest, s.manifestDigest)
+	}
+
+	return nil
+}
+
+func (s *session) pullManifest(reference string) error {
+	resp, content, err := s.expect(http.MethodGet, "/v2/"+s.repo+"/manifests/"+reference, nil,
+		map[string]string{"Accept": ispec.MediaTypeImageManifest}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(content, s.manifest) {
+		return mismatch("pulled manifest differs from the pushed one")
+	}
+
+	if mediaType := resp.Header.Get("Content-Type"); mediaType != ispec.MediaTypeImageManifest {
+		return mismatch("manifest Content-Type %q, expected %s", mediaType, ispec.MediaTypeImageManifest)
+	}
+
+	if digest := resp.Header.Get(contentDigestHeader); digest != "" && digest != s.manifestDigest.String() {
+		return mismatch("pulled manifest digest %q, expected %s", digest, s.manifestDigest)
+	}
+
+	return nil
+}
+
+func checkManifestPullByTag(s *session) error {
+	return s.pullManifest("v1")
+}
+
+func checkManifestPullByDigest(s *session) error {
+	if err := s.pullManifest(s.manifestDigest.String()); err != nil {
+		return err
+	}
+
+	_, _, err := s.expect(http.MethodHead, "/v2/"+s.repo+"/manifests/"+s.manifestDigest.String(), nil,
+		map[string]string{"Accept": ispec.MediaTypeImageManifest}, http.StatusOK)
+
+	return err
+}
+
+func checkBlobPull(s *session) error {
+	_, content, err := s.expect(http.MethodGet, "/v2/"+s.repo+"/blobs/"+s.layerDigest.String(), nil, nil,
+		http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(content, s.layer) {
+		return mismatch("pulled blob differs from the pushed one")
+	}
+
+	return nil
+}
+
+type tagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+func checkTagsList(s *session) error {
+	_, content, err := s.expect(http.MethodGet, "/v2/"+s.repo+"/tags/list", nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	var list tagList
+	if err := json.Unmarshal(content, &list); err != nil {
+		return mismatch("invalid tag list: %v", err)
+	}
+
+	if list.Name != s.repo || len(list.Tags) != 1 || list.Tags[0] != "v1" {
+		return mismatch("tag list %s %v, expected %s [v1]", list.Name, list.Tags, s.repo)
+	}
+
+	return nil
+}
+
+// nextPage returns the target of the Link header of a paginated response, "" on the last page.
+func nextPage(resp *response) string {
+	link := resp.Header.Get("Link")
+	if link == "" {
+		return ""
+	}
+
+	target := strings.TrimSpace(strings.Split(link, ";")[0])
+
+	return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+}
+
+func checkTagsPagination(s *session) error {
+	expected := []string{"v1"}
+
+	for i := 0; i < paginatedTags; i++ {
+		tag := fmt.Sprintf("page%d", i)
+
+		if _, _, err := s.expect(http.MethodPut, "/v2/"+s.repo+"/manifests/"+tag, s.manifest,
+			map[string]string{"Content-Type": ispec.MediaTypeImageManifest}, http.StatusCreated); err != nil {
+			return err
+		}
+
+		expected = append(expected, tag)
+	}
+
+	sort.Strings(expected)
+
+	tags := []string{}
+	target := fmt.Sprintf("/v2/%s/tags/list?n=%d", s.repo, pageSize)
+
+	for target != "" && len(tags) <= len(expected) {
+		resp, content, err := s.expect(http.MethodGet, target, nil, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+
+		var list tagList
+		if err := json.Unmarshal(content, &list); err != nil {
+			return mismatch("invalid tag list: %v", err)
+		}
+
+		if len(list.Tags) > pageSize {
+			return mismatch("%d tags returned, at most %d expected", len(list.Tags), pageSize)
+		}
+
+		tags = append(tags, list.Tags...)
+		target = nextPage(resp)
+	}
+
+	if strings.Join(tags, ",") != strings.Join(expected, ",") {
+		return mismatch("paginated tags %v, expected %v", tags, expected)
+	}
+
+	return nil
+}
+
+func checkCatalogPagination(s *session) error {
+	resp, content, err := s.expect(http.MethodGet, "/v2/_catalog?n=1", nil, nil, http.StatusOK,
+		http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+
+	// the catalog isn't part of the spec
+	if resp.StatusCode == http.StatusNotFound {
+		return skipped("there's no catalog")
+	}
+
+	var catalog struct {
+		Repositories []string `json:"repositories"`
+	}
+
+	if err := json.Unmarshal(content, &catalog); err != nil {
+		return mismatch("invalid catalog: %v", err)
+	}
+
+	switch {
+	case len(catalog.Repositories) > 1:
+		return skipped("the catalog isn't paginated")
+	case len(catalog.Repositories) == 0:
+		return mismatch("no repository in the catalog")
+	}
+
+	return nil
+}
+
+func checkUploadCancel(s *session) error {
+	location, err := s.startUpload(s.repo)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := s.expect(http.MethodDelete, location, nil, nil, http.StatusNoContent); err != nil {
+		return err
+	}
+
+	_, content, err := s.expect(http.MethodGet, location, nil, nil, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+
+	return checkErrorBody(content, "BLOB_UPLOAD_UNKNOWN")
+}
+
+func checkManifestDelete(s *session) error {
+	target := "/v2/" + s.repo + "/manifests/" + s.manifestDigest.String()
+
+	resp, _, err := s.expect(http.MethodDelete, target, nil, nil, http.StatusAccepted, http.StatusMethodNotAllowed)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		return skipped("manifest deletion is disabled")
+	}
+
+	_, _, err = s.expect(http.MethodGet, target, nil, map[string]string{"Accept": ispec.MediaTypeImageManifest},
+		http.StatusNotFound)
+
+	return err
+}
+
+func checkBlobDelete(s *session) error {
+	targets := []string{}
+
+	for _, repo := range []string{s.repo, s.mountRepo} {
+		target := "/v2/" + repo + "/blobs/" + s.layerDigest.String()
+
+		// the blob isn't in the mount repository if mounts aren't supported
+		if repo == s.mountRepo {
+			if resp, _, err := s.do(http.MethodHead, target, nil, nil); err != nil || resp.StatusCode != http.StatusOK {
+				continue
+			}
+		}
+
+		resp, _, err := s.expect(http.MethodDelete, target, nil, nil, http.StatusAccepted,
+			http.StatusMethodNotAllowed)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusMethodNotAllowed {
+			return skipped("blob deletion is disabled")
+		}
+
+		targets = append(targets, target)
+	}
+
+	// registries deduping blobs may restore a deleted blob from another repository until all are deleted
+	for _, target := range targets {
+		if _, _, err := s.expect(http.MethodHead, target, nil, nil, http.StatusNotFound); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkUnknownManifest(s *session) error {
+	_, content, err := s.expect(http.MethodGet, "/v2/"+s.repo+"/manifests/unknown-tag", nil,
+		map[string]string{"Accept": ispec.MediaTypeImageManifest}, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+
+	return checkErrorBody(content, "MANIFEST_UNKNOWN", "NAME_UNKNOWN")
+}
+
+func checkUnknownBlob(s *session) error {
+	digest := godigest.FromString("blob never pushed by the zot compliance checks")
+
+	_, content, err := s.expect(http.MethodGet, "/v2/"+s.repo+"/blobs/"+digest.String(), nil, nil,
+		http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+
+	return checkErrorBody(content, "BLOB_UNKNOWN")
+}
+
+func checkInvalidDigest(s *session) error {
+	location, err := s.startUpload(s.repo)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_, _, _ = s.do(http.MethodDelete, location, nil, nil)
+	}()
+
+	target, err := withDigest(location, godigest.FromString("other content"))
+	if err != nil {
+		return err
+	}
+
+	_, content, err := s.expect(http.MethodPut, target, s.layer,
+		map[string]string{"Content-Type": "application/octet-stream"}, http.StatusBadRequest)
+	if err != nil {
+		return err
+	}
+
+	return checkErrorBody(content, "DIGEST_INVALID")
+}
+
+func checkManifestUnknownBlob(s *session) error {
+	manifest := ispec.Manifest{
+		Config: ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    s.configDigest,
+			Size:      int64(len(s.imageConfig)),
+		},
+		Layers: []ispec.Descriptor{
+			{
+				MediaType: ispec.MediaTypeImageLayer,
+				Digest:    godigest.FromString("layer never pushed by the zot compliance checks"),
+				Size:      1,
+			},
+		},
+	}
+	manifest.SchemaVersion = 2
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	_, body, err := s.expect(http.MethodPut, "/v2/"+s.repo+"/manifests/unknown-blobs", content,
+		map[string]string{"Content-Type": ispec.MediaTypeImageManifest}, http.StatusBadRequest, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+
+	return checkErrorBody(body)
+}
+
+func checkInvalidName(s *session) error {
+	resp, content, err := s.expect(http.MethodGet, "/v2/"+s.config.Namespace+"/Invalid-Name/tags/list", nil, nil,
+		http.StatusBadRequest, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+
+	// the name may not even be routed to the repository APIs, which answer with JSON errors
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+
+	return checkErrorBody(content, "NAME_INVALID", "NAME_UNKNOWN")
+}
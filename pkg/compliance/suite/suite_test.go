@@ -0,0 +1,97 @@
+package suite_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/pkg/api"
+	"github.com/anuvu/zot/pkg/compliance/suite"
+	"github.com/phayes/freeport"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/resty.v1"
+)
+
+func startServer(dir string) (*api.Controller, string) {
+	port, err := freeport.GetFreePort()
+	So(err, ShouldBeNil)
+
+	config := api.NewConfig()
+	config.HTTP.Address = "127.0.0.1"
+	config.HTTP.Port = fmt.Sprint(port)
+	config.Storage.RootDirectory = dir
+
+	ctrl := api.NewController(config)
+
+	go func() {
+		// this blocks
+		if err := ctrl.Run(); err != nil {
+			return
+		}
+	}()
+
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+	for {
+		if _, err := resty.R().Get(baseURL); err == nil {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return ctrl, baseURL
+}
+
+func TestRun(t *testing.T) {
+	Convey("Test zot passes the checks", t, func() {
+		dir, err := ioutil.TempDir("", "compliance-suite-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ctrl, baseURL := startServer(dir)
+		defer func() {
+			_ = ctrl.Server.Shutdown(context.Background())
+		}()
+
+		reported := 0
+		results := suite.Run(suite.Config{URL: baseURL}, func(suite.Result) {
+			reported++
+		})
+
+		So(reported, ShouldEqual, len(results))
+		So(len(results), ShouldBeGreaterThan, 0)
+
+		failed := []string{}
+
+		for _, result := range results {
+			if !result.Passed {
+				failed = append(failed, result.Name+": "+result.Error)
+			}
+		}
+
+		So(failed, ShouldBeEmpty)
+	})
+
+	Convey("Test a broken registry fails the checks", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		results := suite.Run(suite.Config{URL: server.URL, Namespace: "compliance"}, nil)
+
+		So(results[0].Name, ShouldEqual, "base API")
+		So(results[0].Category, ShouldEqual, suite.CategoryPush)
+		So(results[0].Error, ShouldContainSubstring, "GET /v2/ returned 500, expected 200")
+
+		for _, result := range results {
+			So(result.Passed, ShouldBeFalse)
+		}
+	})
+}
@@ -1,17 +1,76 @@
 package extensions
 
-import "time"
+import (
+	"time"
+
+	"github.com/anuvu/zot/pkg/extensions/lint"
+	"github.com/anuvu/zot/pkg/extensions/pushscan"
+	"github.com/anuvu/zot/pkg/extensions/retag"
+	"github.com/anuvu/zot/pkg/extensions/sync"
+)
 
 type ExtensionConfig struct {
-	Search *SearchConfig
+	Search    *SearchConfig
+	Tracing   *TracingConfig
+	UI        *UIConfig
+	Metrics   *MetricsConfig
+	UserPrefs *UserPrefsConfig
+	Sync      *sync.Config
+	// checks the pushed images against rules, e.g. mandatory annotations
+	Lint *lint.Config
 }
 
 type SearchConfig struct {
 	// CVE search
-	CVE    *CVEConfig
+	CVE *CVEConfig
+	// limits of the GraphQL queries
+	Query  *QueryConfig
 	Enable bool
 }
 
+// QueryConfig limits the cost of the GraphQL queries, so that a few users can't overload the registry.
+type QueryConfig struct {
+	// queries more complex than MaxComplexity are rejected, 1000 by default. Each field counts for 1, and the
+	// fields of the top-level lists for as many times as the list limit, or ListLength if they have none,
+	// 10 by default
+	MaxComplexity int
+	ListLength    int
+	// queries per second allowed to each user, or client address when anonymous, in bursts of up to
+	// RateBurst queries, unlimited if not set
+	RateLimit float64
+	RateBurst int
+}
+
 type CVEConfig struct {
 	UpdateInterval time.Duration // should be 2 hours or more, if not specified default be kept as 24 hours
+	// Retag policies are checked after each push to their repositories and every UpdateInterval
+	Retag []retag.Policy
+	// images scanned at once, 2 by default, and how long a scan may take, 10m by default
+	MaxConcurrentScans int
+	ScanTimeout        time.Duration
+	// scans the pushed images, and rejects the vulnerable ones if blocking
+	ScanOnPush *pushscan.Config
+}
+
+// UIConfig enables the embedded web UI served under /ui.
+type UIConfig struct {
+	Enable bool
+}
+
+// MetricsConfig enables the Prometheus metrics served under /metrics.
+type MetricsConfig struct {
+	Enable bool
+}
+
+// UserPrefsConfig lets authenticated users star and bookmark repositories.
+type UserPrefsConfig struct {
+	Enable bool
+}
+
+type TracingConfig struct {
+	Endpoint    string  // OTLP gRPC collector address, e.g. "localhost:4317"
+	ServiceName string  // defaults to "zot"
+	SampleRatio float64 // fraction of new traces which are sampled, all of them if not set
+	Enable      bool
+	Insecure    bool
 }
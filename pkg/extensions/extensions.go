@@ -3,65 +3,558 @@
 package extensions
 
 import (
+	"context"
+	"math"
+	"net/http"
+	goSync "sync"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/extensions/metrics"
+	"github.com/anuvu/zot/pkg/extensions/lint"
+	"github.com/anuvu/zot/pkg/extensions/pushscan"
+	"github.com/anuvu/zot/pkg/extensions/retag"
 	"github.com/anuvu/zot/pkg/extensions/search"
+	"github.com/anuvu/zot/pkg/extensions/sync"
+	"github.com/anuvu/zot/pkg/extensions/ui"
+	"github.com/anuvu/zot/pkg/extensions/userprefs"
+	"github.com/anuvu/zot/pkg/scheduler"
 	"github.com/anuvu/zot/pkg/storage"
+	"github.com/anuvu/zot/pkg/tenant"
 	"github.com/gorilla/mux"
 
 	"time"
 
 	gqlHandler "github.com/99designs/gqlgen/graphql/handler"
+	gqlExtension "github.com/99designs/gqlgen/graphql/handler/extension"
 	cveinfo "github.com/anuvu/zot/pkg/extensions/search/cve"
 
 	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/netpolicy"
+	"github.com/anuvu/zot/pkg/rpc"
+	"github.com/aquasecurity/trivy/pkg/report"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"google.golang.org/grpc"
 )
 
-// DownloadTrivyDB ...
-func downloadTrivyDB(dbDir string, log log.Logger, updateInterval time.Duration) error {
-	for {
-		log.Info().Msg("updating the CVE database")
+const (
+	minCVEUpdateInterval      = 2 * time.Hour
+	cveUpdateJitterRatio      = 10
+	cveUpdateRetryDelay       = 5 * time.Minute
+	defaultTracingServiceName = "zot"
+	defaultTracingSampleRatio = 1
+	defaultQueryMaxComplexity = 1000
+	defaultQueryListLength    = 10
+)
 
-		err := cveinfo.UpdateCVEDb(dbDir, log)
-		if err != nil {
-			return err
+type trivyTask struct {
+	dbDir string
+	log   log.Logger
+}
+
+// DoWork updates the CVE database.
+func (t *trivyTask) DoWork() error {
+	t.log.Info().Msg("updating the CVE database")
+
+	if err := cveinfo.UpdateCVEDb(t.dbDir, t.log); err != nil {
+		t.log.Error().Err(err).Msg("error while downloading TrivyDB")
+		return err
+	}
+
+	t.log.Info().Msg("DB update completed")
+
+	return nil
+}
+
+// EnableExtensions schedules the updates of the CVE database of rootDir, it returns the update task
+// so that updates can also be triggered on demand, nil if CVE scanning is disabled.
+func EnableExtensions(extension *ExtensionConfig, log log.Logger, rootDir string,
+	sch *scheduler.Scheduler) *scheduler.PeriodicTask {
+	if extension.Search != nil && extension.Search.Enable && extension.Search.CVE != nil {
+		if extension.Search.CVE.UpdateInterval < minCVEUpdateInterval {
+			extension.Search.CVE.UpdateInterval = minCVEUpdateInterval
+
+			log.Warn().Msg("CVE update interval set to too-short interval <= 1, changing update duration to 2 hours and continuing.") // nolint: lll
 		}
 
-		log.Info().Str("DB update completed, next update scheduled after", updateInterval.String()).Msg("")
+		log.Info().Str("update interval", extension.Search.CVE.UpdateInterval.String()).Msg("scheduling CVE DB updates")
+
+		cveinfo.SetScanLimits(extension.Search.CVE.MaxConcurrentScans, extension.Search.CVE.ScanTimeout)
 
-		time.Sleep(updateInterval)
+		// updates are spread over a tenth of the interval and failed downloads retried sooner
+		return sch.SubmitPeriodicTaskWithOptions(&trivyTask{dbDir: rootDir, log: log},
+			extension.Search.CVE.UpdateInterval, scheduler.MediumPriority,
+			scheduler.PeriodicOptions{
+				Jitter:     extension.Search.CVE.UpdateInterval / cveUpdateJitterRatio,
+				RetryDelay: cveUpdateRetryDelay,
+			})
 	}
+
+	log.Info().Msg("CVE config not provided, skipping CVE update")
+
+	return nil
 }
 
-// EnableExtensions ...
-func EnableExtensions(extension *ExtensionConfig, log log.Logger, rootDir string) {
+// EffectiveConfig applies the defaults of the extensions to their config, which is modified in place,
+// and disables those which couldn't be set up as configured, logging why.
+func EffectiveConfig(extension *ExtensionConfig, log log.Logger) *ExtensionConfig {
+	if extension == nil {
+		return nil
+	}
+
+	cveScanning := extension.Search != nil && extension.Search.Enable && extension.Search.CVE != nil
+
+	if cveScanning && extension.Search.CVE.UpdateInterval < minCVEUpdateInterval {
+		extension.Search.CVE.UpdateInterval = minCVEUpdateInterval
+	}
+
+	if cveScanning && extension.Search.CVE.MaxConcurrentScans <= 0 {
+		extension.Search.CVE.MaxConcurrentScans = cveinfo.DefaultMaxConcurrentScans
+	}
+
+	if cveScanning && extension.Search.CVE.ScanTimeout <= 0 {
+		extension.Search.CVE.ScanTimeout = cveinfo.DefaultScanTimeout
+	}
+
+	if extension.Search != nil && extension.Search.Enable {
+		query := queryConfig(extension.Search)
+		extension.Search.Query = &query
+	}
+
+	if tracing := extension.Tracing; tracing != nil && tracing.Enable {
+		if tracing.ServiceName == "" {
+			tracing.ServiceName = defaultTracingServiceName
+		}
+
+		if tracing.SampleRatio == 0 {
+			tracing.SampleRatio = defaultTracingSampleRatio
+		}
+	}
+
+	if cveScanning && extension.Search.CVE.ScanOnPush != nil {
+		if err := extension.Search.CVE.ScanOnPush.Validate(); err != nil {
+			log.Error().Err(err).Str("severity", extension.Search.CVE.ScanOnPush.Severity).
+				Msg("invalid scan on push config, scan on push disabled")
+
+			extension.Search.CVE.ScanOnPush = nil
+		}
+	}
+
+	if cveScanning {
+		for _, policy := range extension.Search.CVE.Retag {
+			if err := policy.Validate(); err != nil {
+				log.Error().Err(err).Str("tag", policy.Tag).Str("alias", policy.Alias).
+					Msg("invalid retag policy, retag disabled")
+
+				extension.Search.CVE.Retag = nil
+
+				break
+			}
+		}
+	}
+
+	if extension.Sync != nil && extension.Sync.Enable {
+		for i, regConfig := range extension.Sync.Registries {
+			if err := regConfig.Validate(cveScanning); err != nil {
+				log.Error().Err(err).Str("url", regConfig.URL).Msg("invalid sync registry config, sync disabled")
+
+				extension.Sync.Enable = false
+			}
+
+			extension.Sync.Registries[i] = regConfig.WithDefaults()
+		}
+	}
+
+	return extension
+}
+
+// queryConfig returns the limits of the search queries, with their defaults.
+func queryConfig(search *SearchConfig) QueryConfig {
+	var query QueryConfig
+	if search.Query != nil {
+		query = *search.Query
+	}
+
+	if query.MaxComplexity <= 0 {
+		query.MaxComplexity = defaultQueryMaxComplexity
+	}
+
+	if query.ListLength <= 0 {
+		query.ListLength = defaultQueryListLength
+	}
+
+	if query.RateLimit > 0 && query.RateBurst <= 0 {
+		query.RateBurst = int(math.Ceil(query.RateLimit))
+	}
+
+	return query
+}
+
+// EnableTracing sets up the global OpenTelemetry tracer provider exporting spans via OTLP,
+// the returned function flushes pending spans and must be called on shutdown.
+func EnableTracing(extension *ExtensionConfig, log log.Logger) func() {
+	if extension == nil || extension.Tracing == nil || !extension.Tracing.Enable {
+		return func() {}
+	}
+
+	config := extension.Tracing
+
+	opts := []otlpgrpc.Option{otlpgrpc.WithEndpoint(config.Endpoint)}
+	if config.Insecure {
+		opts = append(opts, otlpgrpc.WithInsecure())
+	}
+
+	exporter, err := otlp.NewExporter(context.Background(), otlpgrpc.NewDriver(opts...))
+	if err != nil {
+		log.Error().Err(err).Str("endpoint", config.Endpoint).Msg("unable to create OTLP exporter, tracing disabled")
+		return func() {}
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = defaultTracingServiceName
+	}
+
+	sampleRatio := config.SampleRatio
+	if sampleRatio == 0 {
+		sampleRatio = defaultTracingSampleRatio
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{},
+		propagation.Baggage{}))
+
+	log.Info().Str("endpoint", config.Endpoint).Float64("sampleRatio", sampleRatio).Msg("tracing enabled")
+
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			log.Error().Err(err).Msg("unable to flush traces")
+		}
+	}
+}
+
+// EnableSync starts replicating the images pushed to zot to downstream registries, it returns nil
+// if sync is disabled, the replicator must be stopped on shutdown otherwise.
+func EnableSync(extension *ExtensionConfig, storeController storage.StoreController,
+	log log.Logger) *sync.Replicator {
+	if extension == nil || extension.Sync == nil || !extension.Sync.Enable {
+		return nil
+	}
+
+	var scanner sync.Scanner
+
 	if extension.Search != nil && extension.Search.Enable && extension.Search.CVE != nil {
-		defaultUpdateInterval, _ := time.ParseDuration("2h")
+		cveInfo, err := cveinfo.GetCVEInfo(storeController, log)
+		if err != nil {
+			log.Error().Err(err).Msg("unable to setup cve scanning of synced images")
+		} else {
+			scanner = newSeverityScanner(cveInfo)
+		}
+	}
+
+	replicator, err := sync.NewReplicator(extension.Sync, storeController, scanner, log)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to setup sync, pushed images won't be replicated")
+		return nil
+	}
 
-		if extension.Search.CVE.UpdateInterval < defaultUpdateInterval {
-			extension.Search.CVE.UpdateInterval = defaultUpdateInterval
+	log.Info().Int("registries", len(extension.Sync.Registries)).Msg("replicating pushed images")
 
-			log.Warn().Msg("CVE update interval set to too-short interval <= 1, changing update duration to 2 hours and continuing.") // nolint: lll
+	return replicator
+}
+
+// newImageScanner scans the images and records their scan summaries, the trivy configs are shared with
+// the search extension so scans are serialized.
+func newImageScanner(cveInfo *cveinfo.CveInfo) func(ctx context.Context, repo, reference string) (report.Results,
+	error) {
+	var lock goSync.Mutex
+
+	return func(ctx context.Context, repo, reference string) (report.Results, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		trivyConfig := cveInfo.GetTrivyConfig(repo + ":" + reference)
+
+		if ok, _ := cveInfo.IsValidImage(repo, reference); !ok {
+			cveInfo.Log.Debug().Str("image", repo+":"+reference).Msg("image media type not supported for scanning")
+			return nil, nil
+		}
+
+		results, err := cveinfo.ScanImage(ctx, trivyConfig)
+		if err != nil {
+			return nil, err
 		}
 
-		go func() {
-			err := downloadTrivyDB(rootDir, log,
-				extension.Search.CVE.UpdateInterval)
-			if err != nil {
-				log.Error().Err(err).Msg("error while downloading TrivyDB")
+		cveInfo.RecordScan(repo, reference, results)
+
+		return results, nil
+	}
+}
+
+// newSeverityScanner scans the images for the CVE policies of sync and of scan on push.
+func newSeverityScanner(cveInfo *cveinfo.CveInfo) func(ctx context.Context, repo, reference string) ([]string,
+	error) {
+	scan := newImageScanner(cveInfo)
+
+	return func(ctx context.Context, repo, reference string) ([]string, error) {
+		results, err := scan(ctx, repo, reference)
+		if err != nil {
+			return nil, err
+		}
+
+		var severities []string
+
+		for _, result := range results {
+			for _, vulnerability := range result.Vulnerabilities {
+				severities = append(severities, cveinfo.NormalizedSeverity(vulnerability))
+			}
+		}
+
+		return severities, nil
+	}
+}
+
+// newRetagScanner scans the images for the retag policies.
+func newRetagScanner(cveInfo *cveinfo.CveInfo) retag.Scanner {
+	scan := newImageScanner(cveInfo)
+
+	return func(ctx context.Context, repo, reference string) ([]string, error) {
+		results, err := scan(ctx, repo, reference)
+		if err != nil {
+			return nil, err
+		}
+
+		var ids []string
+
+		for _, result := range results {
+			for _, vulnerability := range result.Vulnerabilities {
+				ids = append(ids, vulnerability.VulnerabilityID)
+			}
+		}
+
+		return ids, nil
+	}
+}
+
+type retagTask struct {
+	retagger *retag.Retagger
+}
+
+// DoWork queues the check of the repositories of the retag policies, for the updated CVE database.
+func (t *retagTask) DoWork() error {
+	t.retagger.CheckAll()
+
+	return nil
+}
+
+// EnableRetag starts checking the retag policies of the CVE config, it returns nil if there's none,
+// the retagger must be stopped on shutdown otherwise. Existing aliases for which isImmutable is true
+// aren't moved.
+func EnableRetag(extension *ExtensionConfig, storeController storage.StoreController,
+	isImmutable func(repo, tag string) bool, sch *scheduler.Scheduler, log log.Logger) *retag.Retagger {
+	if extension == nil || extension.Search == nil || !extension.Search.Enable || extension.Search.CVE == nil ||
+		len(extension.Search.CVE.Retag) == 0 {
+		return nil
+	}
+
+	cveInfo, err := cveinfo.GetCVEInfo(storeController, log)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to setup cve scanning, retag policies disabled")
+		return nil
+	}
+
+	retagger, err := retag.NewRetagger(extension.Search.CVE.Retag, storeController, newRetagScanner(cveInfo),
+		isImmutable, log)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to setup retag policies")
+		return nil
+	}
+
+	log.Info().Int("policies", len(extension.Search.CVE.Retag)).Msg("retagging images fixing CVEs")
+
+	// the repositories are checked again for each update of the CVE database
+	sch.SubmitPeriodicTask(&retagTask{retagger: retagger}, extension.Search.CVE.UpdateInterval,
+		scheduler.LowPriority)
+
+	return retagger
+}
+
+// EnablePushScan starts scanning the pushed images as configured, it returns nil if scan on push isn't
+// configured, the push scanner must be stopped on shutdown otherwise.
+func EnablePushScan(extension *ExtensionConfig, storeController storage.StoreController,
+	log log.Logger) *pushscan.PushScanner {
+	if extension == nil || extension.Search == nil || !extension.Search.Enable || extension.Search.CVE == nil ||
+		extension.Search.CVE.ScanOnPush == nil {
+		return nil
+	}
+
+	cveInfo, err := cveinfo.GetCVEInfo(storeController, log)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to setup cve scanning, scan on push disabled")
+		return nil
+	}
+
+	pushScanner, err := pushscan.NewPushScanner(*extension.Search.CVE.ScanOnPush, newSeverityScanner(cveInfo), log)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to setup scan on push")
+		return nil
+	}
+
+	log.Info().Str("severity", extension.Search.CVE.ScanOnPush.Severity).
+		Bool("block", extension.Search.CVE.ScanOnPush.Block).Msg("scanning pushed images")
+
+	return pushScanner
+}
+
+// EnableCVERawReport returns the function scanning an image for the report of trivy in its JSON format, or nil
+// if CVE scanning isn't enabled.
+func EnableCVERawReport(extension *ExtensionConfig, storeController storage.StoreController,
+	log log.Logger) func(ctx context.Context, repo, reference string) ([]byte, error) {
+	if extension == nil || extension.Search == nil || !extension.Search.Enable || extension.Search.CVE == nil {
+		return nil
+	}
+
+	cveInfo, err := cveinfo.GetCVEInfo(storeController, log)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to setup cve scanning, raw cve reports disabled")
+		return nil
+	}
+
+	scan := newImageScanner(cveInfo)
+
+	return func(ctx context.Context, repo, reference string) ([]byte, error) {
+		if ok, err := cveInfo.IsValidImage(repo, reference); !ok {
+			if err == nil {
+				err = errors.ErrManifestNotFound
 			}
-		}()
-	} else {
-		log.Info().Msg("CVE config not provided, skipping CVE update")
+
+			return nil, err
+		}
+
+		results, err := scan(ctx, repo, reference)
+		if err != nil {
+			return nil, err
+		}
+
+		return cveinfo.RawReport(results)
 	}
 }
 
-// SetupRoutes ...
+// EnableLint returns the linter checking the pushed images, or nil if lint isn't enabled.
+func EnableLint(extension *ExtensionConfig, log log.Logger) *lint.Linter {
+	if extension == nil || extension.Lint == nil || !extension.Lint.Enable {
+		return nil
+	}
+
+	linter, err := lint.NewLinter(*extension.Lint, log)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to setup lint")
+		return nil
+	}
+
+	log.Info().Bool("block", extension.Lint.Block).Msg("linting pushed images")
+
+	return linter
+}
+
+// SetupRoutes registers the routes of the enabled extensions, the returned function releases
+// their resources and must be called on shutdown.
 func SetupRoutes(extension *ExtensionConfig, router *mux.Router, storeController storage.StoreController,
-	log log.Logger) {
+	replicator *sync.Replicator, retagger *retag.Retagger, pushScanner *pushscan.PushScanner, sch *scheduler.Scheduler,
+	netPolicy *netpolicy.Policy, tenants *tenant.Tenants, log log.Logger) func() {
 	log.Info().Msg("setting up extensions routes")
 
+	var userPrefs *userprefs.UserPrefs
+
+	if extension.UserPrefs != nil && extension.UserPrefs.Enable {
+		up, err := userprefs.NewUserPrefs(storeController.DefaultStore.RootDir(), log)
+		if err != nil {
+			log.Error().Err(err).Msg("unable to open user preferences, starring and bookmarking disabled")
+		} else {
+			userPrefs = up
+
+			router.Path(userprefs.RoutePrefix).Methods("PUT").Handler(userprefs.Handler(userPrefs, storeController))
+		}
+	}
+
 	if extension.Search != nil && extension.Search.Enable {
-		resConfig := search.GetResolverConfig(log, storeController)
-		router.PathPrefix("/query").Methods("GET", "POST").
-			Handler(gqlHandler.NewDefaultServer(search.NewExecutableSchema(resConfig)))
+		resConfig := search.GetResolverConfig(log, storeController, userPrefs, replicator)
+
+		query := queryConfig(extension.Search)
+		resConfig.Complexity = search.NewComplexityRoot(query.ListLength)
+
+		gqlServer := gqlHandler.NewDefaultServer(search.NewExecutableSchema(resConfig))
+		gqlServer.Use(search.Tracing{})
+		gqlServer.Use(gqlExtension.FixedComplexityLimit(query.MaxComplexity))
+		gqlServer.Use(search.NewResultCache(storeController, tenants))
+
+		handler := search.ETagHandler(gqlServer)
+		if query.RateLimit > 0 {
+			handler = search.RateLimitHandler(handler, query.RateLimit, query.RateBurst, netPolicy, log)
+		}
+
+		router.PathPrefix("/query").Methods("GET", "POST").Handler(handler)
+	}
+
+	if extension.Metrics != nil && extension.Metrics.Enable {
+		saturation := metrics.NewSaturationCollector(sch, func() map[string]int {
+			queues := map[string]int{}
+
+			if retagger != nil {
+				queues["retag"] = retagger.QueueLength()
+			}
+
+			if pushScanner != nil {
+				queues["pushscan"] = pushScanner.QueueLength()
+			}
+
+			return queues
+		}, func() map[string]int {
+			if replicator == nil {
+				return nil
+			}
+
+			return replicator.QueueLengths()
+		})
+
+		router.PathPrefix(metrics.RoutePrefix).Methods("GET").
+			Handler(metrics.Handler(storeController, netPolicy, tenants, saturation))
+	}
+
+	if extension.UI != nil && extension.UI.Enable {
+		router.Handle(ui.RoutePrefix, http.RedirectHandler(ui.RoutePrefix+"/", http.StatusMovedPermanently))
+		router.PathPrefix(ui.RoutePrefix+"/").Methods("GET", "HEAD").Handler(ui.Handler())
+	}
+
+	return func() {
+		if userPrefs != nil {
+			if err := userPrefs.Close(); err != nil {
+				log.Error().Err(err).Msg("unable to close user preferences")
+			}
+		}
+	}
+}
+
+// SetupGRPC registers the gRPC services of the enabled extensions, the search service shares the
+// resolvers of the GraphQL API.
+func SetupGRPC(extension *ExtensionConfig, server grpc.ServiceRegistrar, storeController storage.StoreController,
+	replicator *sync.Replicator, log log.Logger) {
+	if extension != nil && extension.Search != nil && extension.Search.Enable {
+		log.Info().Msg("setting up search gRPC service")
+
+		resConfig := search.GetResolverConfig(log, storeController, nil, replicator)
+
+		rpc.RegisterSearchServer(server, search.NewGRPCServer(resConfig))
 	}
 }
@@ -0,0 +1,131 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/repomatch"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// dockerImageConfigMediaType is the media type of the config of docker v2 schema 2 images.
+const dockerImageConfigMediaType = "application/vnd.docker.container.image.v1+json"
+
+// Config checks the images pushed to the repositories matching Repositories against rules, those breaking
+// them are reported in the logs, or rejected if Block is set.
+type Config struct {
+	Enable       bool
+	Repositories []string // path.Match patterns of repository names, all repositories if empty
+	// annotations the manifests must have, e.g. org.opencontainers.image.source
+	MandatoryAnnotations []string
+	MaxLayers            int   // layers an image may have, unlimited if 0
+	MaxLayerSize         int64 // bytes each layer may have, unlimited if 0
+	NonRootUser          bool  // the image config must set a user other than root
+	Block                bool  // reject the images breaking the rules instead of accepting them
+}
+
+// Validate checks the limits and the repository patterns of the config.
+func (c Config) Validate() error {
+	if c.MaxLayers < 0 || c.MaxLayerSize < 0 {
+		return errors.ErrBadConfig
+	}
+
+	if err := repomatch.Validate(c.Repositories); err != nil {
+		return errors.ErrBadConfig
+	}
+
+	return nil
+}
+
+// ConfigReader returns the content of the config blob of an image.
+type ConfigReader func(digest godigest.Digest, mediaType string) ([]byte, error)
+
+// Linter checks the pushed images against the rules of its config.
+type Linter struct {
+	config Config
+}
+
+// NewLinter returns a linter checking the rules of config.
+func NewLinter(config Config, log log.Logger) (*Linter, error) {
+	if err := config.Validate(); err != nil {
+		log.Error().Err(err).Msg("invalid lint config")
+		return nil, err
+	}
+
+	return &Linter{config: config}, nil
+}
+
+// Matches tells whether the images pushed to a repository are checked.
+func (l *Linter) Matches(repo string) bool {
+	return repomatch.Matches(l.config.Repositories, repo)
+}
+
+// Blocking tells whether the images breaking the rules are rejected.
+func (l *Linter) Blocking() bool {
+	return l.config.Block
+}
+
+// Lint returns the rules an image manifest breaks, none if it passes them. Other manifests, e.g. indexes,
+// signatures and other artifacts, always pass.
+func (l *Linter) Lint(body []byte, readConfig ConfigReader) ([]string, error) {
+	var manifest ispec.Manifest
+
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, errors.ErrBadManifest
+	}
+
+	if manifest.Config.MediaType != ispec.MediaTypeImageConfig && manifest.Config.MediaType != dockerImageConfigMediaType {
+		return nil, nil
+	}
+
+	violations := []string{}
+
+	for _, key := range l.config.MandatoryAnnotations {
+		if _, ok := manifest.Annotations[key]; !ok {
+			violations = append(violations, fmt.Sprintf("missing annotation %s", key))
+		}
+	}
+
+	if l.config.MaxLayers > 0 && len(manifest.Layers) > l.config.MaxLayers {
+		violations = append(violations, fmt.Sprintf("%d layers, more than %d", len(manifest.Layers), l.config.MaxLayers))
+	}
+
+	if l.config.MaxLayerSize > 0 {
+		for _, layer := range manifest.Layers {
+			if layer.Size > l.config.MaxLayerSize {
+				violations = append(violations, fmt.Sprintf("layer %s of %d bytes, more than %d",
+					layer.Digest, layer.Size, l.config.MaxLayerSize))
+			}
+		}
+	}
+
+	if l.config.NonRootUser {
+		content, err := readConfig(manifest.Config.Digest, manifest.Config.MediaType)
+		if err != nil {
+			return nil, err
+		}
+
+		var config ispec.Image
+
+		if err := json.Unmarshal(content, &config); err != nil {
+			return nil, errors.ErrBadManifest
+		}
+
+		if isRootUser(config.Config.User) {
+			violations = append(violations, "runs as root")
+		}
+	}
+
+	return violations, nil
+}
+
+// isRootUser tells whether the user of an image config, e.g. "1000:1000", is root, which it is by default.
+func isRootUser(user string) bool {
+	name := strings.SplitN(user, ":", 2)[0]
+
+	return name == "" || name == "root" || name == "0"
+}
@@ -0,0 +1,99 @@
+package lint_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/extensions/lint"
+	"github.com/anuvu/zot/pkg/log"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLint(t *testing.T) {
+	logger := log.NewLogger("debug", "")
+
+	Convey("Validate config", t, func() {
+		So(lint.Config{MaxLayers: 10}.Validate(), ShouldBeNil)
+		So(lint.Config{MaxLayerSize: -1}.Validate(), ShouldEqual, errors.ErrBadConfig)
+		So(lint.Config{Repositories: []string{"["}}.Validate(), ShouldEqual, errors.ErrBadConfig)
+
+		_, err := lint.NewLinter(lint.Config{MaxLayers: -1}, logger)
+		So(err, ShouldEqual, errors.ErrBadConfig)
+	})
+
+	Convey("Lint manifests", t, func() {
+		config := lint.Config{
+			Repositories:         []string{"prod/*"},
+			MandatoryAnnotations: []string{ispec.AnnotationSource},
+			MaxLayers:            2,
+			MaxLayerSize:         100,
+			NonRootUser:          true,
+			Block:                true,
+		}
+
+		l, err := lint.NewLinter(config, logger)
+		So(err, ShouldBeNil)
+		So(l.Blocking(), ShouldBeTrue)
+		So(l.Matches("prod/app"), ShouldBeTrue)
+		So(l.Matches("dev/app"), ShouldBeFalse)
+
+		user := "app"
+		readConfig := func(digest godigest.Digest, mediaType string) ([]byte, error) {
+			return json.Marshal(ispec.Image{Config: ispec.ImageConfig{User: user}})
+		}
+
+		layer := ispec.Descriptor{MediaType: ispec.MediaTypeImageLayerGzip, Digest: godigest.FromString("layer"), Size: 10}
+		manifest := ispec.Manifest{
+			Config:      ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: godigest.FromString("config")},
+			Layers:      []ispec.Descriptor{layer},
+			Annotations: map[string]string{ispec.AnnotationSource: "https://github.com/anuvu/zot"},
+		}
+		manifest.SchemaVersion = 2
+
+		body, _ := json.Marshal(manifest)
+		violations, err := l.Lint(body, readConfig)
+		So(err, ShouldBeNil)
+		So(violations, ShouldBeEmpty)
+
+		big := layer
+		big.Size = 1000
+		manifest.Layers = []ispec.Descriptor{layer, layer, big}
+		manifest.Annotations = nil
+		user = "0:0"
+
+		body, _ = json.Marshal(manifest)
+		violations, err = l.Lint(body, readConfig)
+		So(err, ShouldBeNil)
+		So(violations, ShouldResemble, []string{
+			"missing annotation " + ispec.AnnotationSource,
+			"3 layers, more than 2",
+			"layer " + big.Digest.String() + " of 1000 bytes, more than 100",
+			"runs as root",
+		})
+
+		// the user defaults to root
+		user = ""
+		manifest.Layers = []ispec.Descriptor{layer}
+		manifest.Annotations = map[string]string{ispec.AnnotationSource: "https://github.com/anuvu/zot"}
+
+		body, _ = json.Marshal(manifest)
+		violations, err = l.Lint(body, readConfig)
+		So(err, ShouldBeNil)
+		So(violations, ShouldResemble, []string{"runs as root"})
+
+		// artifacts aren't images
+		manifest.Config.MediaType = "application/vnd.cncf.notary.signature"
+		manifest.Annotations = nil
+
+		body, _ = json.Marshal(manifest)
+		violations, err = l.Lint(body, readConfig)
+		So(err, ShouldBeNil)
+		So(violations, ShouldBeEmpty)
+
+		_, err = l.Lint([]byte("{"), readConfig)
+		So(err, ShouldEqual, errors.ErrBadManifest)
+	})
+}
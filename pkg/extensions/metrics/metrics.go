@@ -0,0 +1,302 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/anuvu/zot/pkg/netpolicy"
+	"github.com/anuvu/zot/pkg/scheduler"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/anuvu/zot/pkg/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// RoutePrefix is the path the metrics are served under.
+	RoutePrefix = "/metrics"
+
+	namespace = "zot"
+)
+
+// pullStatsCollector exports the pull statistics of the image stores, they are read at scrape time
+// so that counts survive restarts.
+type pullStatsCollector struct {
+	storeController storage.StoreController
+	repoPulls       *prometheus.Desc
+	repoLastPull    *prometheus.Desc
+	tagPulls        *prometheus.Desc
+	userPulls       *prometheus.Desc
+}
+
+// NewPullStatsCollector returns a collector of the pull statistics of all image stores.
+func NewPullStatsCollector(storeController storage.StoreController) prometheus.Collector {
+	return &pullStatsCollector{
+		storeController: storeController,
+		repoPulls: prometheus.NewDesc(prometheus.BuildFQName(namespace, "repo", "pulls_total"),
+			"Number of image manifest downloads of a repository.", []string{"repo"}, nil),
+		repoLastPull: prometheus.NewDesc(prometheus.BuildFQName(namespace, "repo", "last_pull_timestamp_seconds"),
+			"Time of the last image manifest download of a repository.", []string{"repo"}, nil),
+		tagPulls: prometheus.NewDesc(prometheus.BuildFQName(namespace, "tag", "pulls_total"),
+			"Number of image manifest downloads of a tag, or of a digest.", []string{"repo", "tag"}, nil),
+		userPulls: prometheus.NewDesc(prometheus.BuildFQName(namespace, "user", "pulls_total"),
+			"Number of image manifest downloads of an authenticated user.", []string{"user"}, nil),
+	}
+}
+
+func (c *pullStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.repoPulls
+	ch <- c.repoLastPull
+	ch <- c.tagPulls
+	ch <- c.userPulls
+}
+
+func (c *pullStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stores := []*storage.ImageStore{c.storeController.DefaultStore}
+	for _, store := range c.storeController.SubStore {
+		stores = append(stores, store)
+	}
+
+	// users may pull from several image stores
+	users := make(map[string]uint64)
+
+	for _, store := range stores {
+		for repo, stats := range store.PullStats().Repos() {
+			ch <- prometheus.MustNewConstMetric(c.repoPulls, prometheus.CounterValue, float64(stats.Count), repo)
+			ch <- prometheus.MustNewConstMetric(c.repoLastPull, prometheus.GaugeValue,
+				float64(stats.LastPull.Unix()), repo)
+
+			for tag, tagStats := range stats.Tags {
+				ch <- prometheus.MustNewConstMetric(c.tagPulls, prometheus.CounterValue, float64(tagStats.Count), repo, tag)
+			}
+		}
+
+		for user, count := range store.PullStats().Users() {
+			users[user] += count.Count
+		}
+	}
+
+	for user, count := range users {
+		ch <- prometheus.MustNewConstMetric(c.userPulls, prometheus.CounterValue, float64(count), user)
+	}
+}
+
+// diskSpaceCollector exports the free disk space of the image stores, as of their last check.
+type diskSpaceCollector struct {
+	storeController storage.StoreController
+	totalBytes      *prometheus.Desc
+	freeBytes       *prometheus.Desc
+	minFreeBytes    *prometheus.Desc
+	lowSpace        *prometheus.Desc
+}
+
+// NewDiskSpaceCollector returns a collector of the free disk space of all image stores.
+func NewDiskSpaceCollector(storeController storage.StoreController) prometheus.Collector {
+	return &diskSpaceCollector{
+		storeController: storeController,
+		totalBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "storage", "total_bytes"),
+			"Size of the filesystem of an image store.", []string{"store"}, nil),
+		freeBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "storage", "free_bytes"),
+			"Free space of the filesystem of an image store.", []string{"store"}, nil),
+		minFreeBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "storage", "min_free_bytes"),
+			"Free space below which new uploads are refused, 0 if none.", []string{"store"}, nil),
+		lowSpace: prometheus.NewDesc(prometheus.BuildFQName(namespace, "storage", "low_space"),
+			"Whether new uploads are refused because of low free space.", []string{"store"}, nil),
+	}
+}
+
+func (c *diskSpaceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.totalBytes
+	ch <- c.freeBytes
+	ch <- c.minFreeBytes
+	ch <- c.lowSpace
+}
+
+func (c *diskSpaceCollector) Collect(ch chan<- prometheus.Metric) {
+	stores := map[string]*storage.ImageStore{"/": c.storeController.DefaultStore}
+	for route, store := range c.storeController.SubStore {
+		stores[route] = store
+	}
+
+	for route, store := range stores {
+		space := store.DiskSpace()
+
+		lowSpace := 0.0
+		if space.Low {
+			lowSpace = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.totalBytes, prometheus.GaugeValue, float64(space.TotalBytes), route)
+		ch <- prometheus.MustNewConstMetric(c.freeBytes, prometheus.GaugeValue, float64(space.FreeBytes), route)
+		ch <- prometheus.MustNewConstMetric(c.minFreeBytes, prometheus.GaugeValue, float64(space.MinFreeBytes), route)
+		ch <- prometheus.MustNewConstMetric(c.lowSpace, prometheus.GaugeValue, lowSpace, route)
+	}
+}
+
+// networkRejectionsCollector exports the requests rejected by the network policy.
+type networkRejectionsCollector struct {
+	netPolicy *netpolicy.Policy
+	rejected  *prometheus.Desc
+}
+
+// NewNetworkRejectionsCollector returns a collector of the requests rejected by a network policy.
+func NewNetworkRejectionsCollector(netPolicy *netpolicy.Policy) prometheus.Collector {
+	return &networkRejectionsCollector{
+		netPolicy: netPolicy,
+		rejected: prometheus.NewDesc(prometheus.BuildFQName(namespace, "network", "rejected_requests_total"),
+			"Number of requests rejected because of the network of their client.", []string{"operation"}, nil),
+	}
+}
+
+func (c *networkRejectionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.rejected
+}
+
+func (c *networkRejectionsCollector) Collect(ch chan<- prometheus.Metric) {
+	for operation, count := range c.netPolicy.Rejected() {
+		ch <- prometheus.MustNewConstMetric(c.rejected, prometheus.CounterValue, float64(count), operation)
+	}
+}
+
+// tenantsCollector exports the storage usage and the quota of the tenants, computed at scrape time.
+type tenantsCollector struct {
+	tenants      *tenant.Tenants
+	repositories *prometheus.Desc
+	bytes        *prometheus.Desc
+	quotaBytes   *prometheus.Desc
+}
+
+// NewTenantsCollector returns a collector of the storage usage of the tenants.
+func NewTenantsCollector(tenants *tenant.Tenants) prometheus.Collector {
+	return &tenantsCollector{
+		tenants: tenants,
+		repositories: prometheus.NewDesc(prometheus.BuildFQName(namespace, "tenant", "repositories"),
+			"Number of repositories of a tenant.", []string{"tenant"}, nil),
+		bytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "tenant", "storage_bytes"),
+			"Sum of the sizes of the repositories of a tenant.", []string{"tenant"}, nil),
+		quotaBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "tenant", "quota_bytes"),
+			"Storage quota of a tenant, 0 if none.", []string{"tenant"}, nil),
+	}
+}
+
+func (c *tenantsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.repositories
+	ch <- c.bytes
+	ch <- c.quotaBytes
+}
+
+func (c *tenantsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, status := range c.tenants.All() {
+		ch <- prometheus.MustNewConstMetric(c.repositories, prometheus.GaugeValue,
+			float64(status.Usage.Repositories), status.Name)
+		ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(status.Usage.Bytes), status.Name)
+		ch <- prometheus.MustNewConstMetric(c.quotaBytes, prometheus.GaugeValue, float64(status.Quota), status.Name)
+	}
+}
+
+// saturationCollector exports the saturation of the server, the requests and blob transfers in flight and
+// the tasks waiting for the scheduler and the background workers, read at scrape time.
+type saturationCollector struct {
+	scheduler     *scheduler.Scheduler
+	workerQueues  func() map[string]int
+	syncQueues    func() map[string]int
+	requests      *prometheus.Desc
+	uploads       *prometheus.Desc
+	downloads     *prometheus.Desc
+	bytesInFlight *prometheus.Desc
+	tasksRunning  *prometheus.Desc
+	tasksQueued   *prometheus.Desc
+	tasksPaused   *prometheus.Desc
+	workerQueue   *prometheus.Desc
+	syncQueue     *prometheus.Desc
+}
+
+// NewSaturationCollector returns a collector of the load of the scheduler, and of the queue lengths of the
+// background workers, by worker, and of the replication to each downstream registry, by host.
+func NewSaturationCollector(sch *scheduler.Scheduler, workerQueues,
+	syncQueues func() map[string]int) prometheus.Collector {
+	return &saturationCollector{
+		scheduler:    sch,
+		workerQueues: workerQueues,
+		syncQueues:   syncQueues,
+		requests: prometheus.NewDesc(prometheus.BuildFQName(namespace, "http", "requests_in_flight"),
+			"Number of API requests being served.", nil, nil),
+		uploads: prometheus.NewDesc(prometheus.BuildFQName(namespace, "blob", "uploads_in_flight"),
+			"Number of blob uploads being received.", nil, nil),
+		downloads: prometheus.NewDesc(prometheus.BuildFQName(namespace, "blob", "downloads_in_flight"),
+			"Number of blob downloads being sent.", nil, nil),
+		bytesInFlight: prometheus.NewDesc(prometheus.BuildFQName(namespace, "blob", "bytes_in_flight"),
+			"Size of the blobs being transferred, uploads of unknown size aside.", nil, nil),
+		tasksRunning: prometheus.NewDesc(prometheus.BuildFQName(namespace, "scheduler", "tasks_running"),
+			"Number of background tasks being run.", nil, nil),
+		tasksQueued: prometheus.NewDesc(prometheus.BuildFQName(namespace, "scheduler", "tasks_queued"),
+			"Number of background tasks waiting to be run.", []string{"priority"}, nil),
+		tasksPaused: prometheus.NewDesc(prometheus.BuildFQName(namespace, "scheduler", "paused"),
+			"Whether only high priority tasks are run because of the load of API requests.", nil, nil),
+		workerQueue: prometheus.NewDesc(prometheus.BuildFQName(namespace, "worker", "queue_length"),
+			"Number of jobs waiting for a background worker.", []string{"worker"}, nil),
+		syncQueue: prometheus.NewDesc(prometheus.BuildFQName(namespace, "sync", "queue_length"),
+			"Number of images waiting to be replicated to a downstream registry.", []string{"registry"}, nil),
+	}
+}
+
+func (c *saturationCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.requests
+	ch <- c.uploads
+	ch <- c.downloads
+	ch <- c.bytesInFlight
+	ch <- c.tasksRunning
+	ch <- c.tasksQueued
+	ch <- c.tasksPaused
+	ch <- c.workerQueue
+	ch <- c.syncQueue
+}
+
+func (c *saturationCollector) Collect(ch chan<- prometheus.Metric) {
+	status := c.scheduler.Status()
+
+	paused := 0.0
+	if status.Paused {
+		paused = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.requests, prometheus.GaugeValue, float64(status.InFlightRequests))
+	ch <- prometheus.MustNewConstMetric(c.uploads, prometheus.GaugeValue, float64(status.ActiveUploads))
+	ch <- prometheus.MustNewConstMetric(c.downloads, prometheus.GaugeValue, float64(status.ActiveDownloads))
+	ch <- prometheus.MustNewConstMetric(c.bytesInFlight, prometheus.GaugeValue, float64(status.BytesInFlight))
+	ch <- prometheus.MustNewConstMetric(c.tasksRunning, prometheus.GaugeValue, float64(status.RunningTasks))
+	ch <- prometheus.MustNewConstMetric(c.tasksPaused, prometheus.GaugeValue, paused)
+
+	for priority, queued := range status.QueuedTasks {
+		ch <- prometheus.MustNewConstMetric(c.tasksQueued, prometheus.GaugeValue, float64(queued), priority)
+	}
+
+	for worker, length := range c.workerQueues() {
+		ch <- prometheus.MustNewConstMetric(c.workerQueue, prometheus.GaugeValue, float64(length), worker)
+	}
+
+	for registry, length := range c.syncQueues() {
+		ch <- prometheus.MustNewConstMetric(c.syncQueue, prometheus.GaugeValue, float64(length), registry)
+	}
+}
+
+// Handler returns the handler serving the metrics of zot and of the Go runtime, of the saturation of the
+// server, and of the network policy and the tenants if any.
+func Handler(storeController storage.StoreController, netPolicy *netpolicy.Policy, tenants *tenant.Tenants,
+	saturation prometheus.Collector) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		NewPullStatsCollector(storeController),
+		NewDiskSpaceCollector(storeController),
+		saturation)
+
+	if netPolicy != nil {
+		registry.MustRegister(NewNetworkRejectionsCollector(netPolicy))
+	}
+
+	if tenants != nil {
+		registry.MustRegister(NewTenantsCollector(tenants))
+	}
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
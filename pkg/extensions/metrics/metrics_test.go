@@ -0,0 +1,278 @@
+// +build extended
+
+package metrics_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/pkg/api"
+	ext "github.com/anuvu/zot/pkg/extensions"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/phayes/freeport"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/resty.v1"
+)
+
+type popularityResponse struct {
+	Data struct {
+		Repos []struct {
+			Name  string `json:"Name"`
+			Count int    `json:"Count"`
+			Tags  []struct {
+				Name  string `json:"Name"`
+				Count int    `json:"Count"`
+			} `json:"Tags"`
+		} `json:"ImageListByPopularity"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func pushImage(imageStore *storage.ImageStore, repo, tag string) {
+	So(imageStore.InitRepo(repo), ShouldBeNil)
+
+	layer := []byte("this is a layer of " + repo + ":" + tag)
+	layerDigest := godigest.FromBytes(layer)
+	_, _, err := imageStore.FullBlobUpload(repo, bytes.NewReader(layer), layerDigest.String())
+	So(err, ShouldBeNil)
+
+	config, err := json.Marshal(ispec.Image{})
+	So(err, ShouldBeNil)
+
+	configDigest := godigest.FromBytes(config)
+	_, _, err = imageStore.FullBlobUpload(repo, bytes.NewReader(config), configDigest.String())
+	So(err, ShouldBeNil)
+
+	manifest := ispec.Manifest{
+		Config: ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    configDigest,
+			Size:      int64(len(config)),
+		},
+		Layers: []ispec.Descriptor{
+			{
+				MediaType: ispec.MediaTypeImageLayer,
+				Digest:    layerDigest,
+				Size:      int64(len(layer)),
+			},
+		},
+	}
+	manifest.SchemaVersion = 2
+
+	content, err := json.Marshal(manifest)
+	So(err, ShouldBeNil)
+
+	_, err = imageStore.PutImageManifest(repo, tag, ispec.MediaTypeImageManifest, content)
+	So(err, ShouldBeNil)
+}
+
+func TestPullStatsMetrics(t *testing.T) {
+	Convey("Pull statistics are exported as metrics and by popularity", t, func() {
+		dir, err := ioutil.TempDir("", "metrics_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imageStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+		pushImage(imageStore, "alpine", "3.13")
+		pushImage(imageStore, "alpine", "3.14")
+		pushImage(imageStore, "busybox", "latest")
+		pushImage(imageStore, "unused", "latest")
+
+		// statistics saved before a restart are kept
+		imageStore.PullStats().Record("busybox", "latest", "alice")
+		So(imageStore.PullStats().Flush(), ShouldBeNil)
+
+		port, err := freeport.GetFreePort()
+		So(err, ShouldBeNil)
+
+		baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+		config := api.NewConfig()
+		config.HTTP.Port = fmt.Sprint(port)
+		config.Storage.RootDirectory = dir
+		config.Extensions = &ext.ExtensionConfig{
+			Search:  &ext.SearchConfig{Enable: true},
+			Metrics: &ext.MetricsConfig{Enable: true},
+		}
+
+		c := api.NewController(config)
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			_ = c.Server.Shutdown(context.Background())
+		}()
+
+		for _, tag := range []string{"3.13", "3.13", "3.14"} {
+			resp, err := resty.R().Get(baseURL + "/v2/alpine/manifests/" + tag)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+		}
+
+		// HEAD requests and failed pulls are not counted
+		resp, err := resty.R().Head(baseURL + "/v2/busybox/manifests/latest")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = resty.R().Get(baseURL + "/v2/busybox/manifests/unknown")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = resty.R().Get(baseURL + "/metrics")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		metrics := string(resp.Body())
+		So(metrics, ShouldContainSubstring, `zot_repo_pulls_total{repo="alpine"} 3`)
+		So(metrics, ShouldContainSubstring, `zot_repo_pulls_total{repo="busybox"} 1`)
+		So(metrics, ShouldContainSubstring, `zot_tag_pulls_total{repo="alpine",tag="3.13"} 2`)
+		So(metrics, ShouldContainSubstring, `zot_tag_pulls_total{repo="alpine",tag="3.14"} 1`)
+		So(metrics, ShouldContainSubstring, `zot_user_pulls_total{user="alice"} 1`)
+		So(metrics, ShouldContainSubstring, `zot_repo_last_pull_timestamp_seconds{repo="alpine"}`)
+		So(metrics, ShouldNotContainSubstring, `repo="unused"`)
+		So(metrics, ShouldContainSubstring, `zot_storage_free_bytes{store="/"}`)
+		So(metrics, ShouldContainSubstring, `zot_storage_low_space{store="/"} 0`)
+
+		var popularity popularityResponse
+
+		resp, err = resty.R().Get(baseURL + "/query?query={ImageListByPopularity{Name%20Count%20Tags{Name%20Count}}}")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &popularity), ShouldBeNil)
+		So(popularity.Errors, ShouldBeEmpty)
+		So(len(popularity.Data.Repos), ShouldEqual, 3)
+		So(popularity.Data.Repos[0].Name, ShouldEqual, "alpine")
+		So(popularity.Data.Repos[0].Count, ShouldEqual, 3)
+		So(popularity.Data.Repos[0].Tags[0].Name, ShouldEqual, "3.13")
+		So(popularity.Data.Repos[0].Tags[0].Count, ShouldEqual, 2)
+		So(popularity.Data.Repos[1].Name, ShouldEqual, "busybox")
+		So(popularity.Data.Repos[2].Name, ShouldEqual, "unused")
+		So(popularity.Data.Repos[2].Count, ShouldEqual, 0)
+		So(popularity.Data.Repos[2].Tags, ShouldBeEmpty)
+
+		resp, err = resty.R().Get(baseURL + "/query?query={ImageListByPopularity(limit:1){Name}}")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &popularity), ShouldBeNil)
+		So(len(popularity.Data.Repos), ShouldEqual, 1)
+		So(popularity.Data.Repos[0].Name, ShouldEqual, "alpine")
+	})
+}
+
+func TestSaturationMetrics(t *testing.T) {
+	Convey("Requests, transfers and queued tasks in flight are exported as metrics", t, func() {
+		dir, err := ioutil.TempDir("", "metrics_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		port, err := freeport.GetFreePort()
+		So(err, ShouldBeNil)
+
+		baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+		config := api.NewConfig()
+		config.HTTP.Port = fmt.Sprint(port)
+		config.Storage.RootDirectory = dir
+		config.Extensions = &ext.ExtensionConfig{
+			Metrics: &ext.MetricsConfig{Enable: true},
+		}
+
+		c := api.NewController(config)
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			_ = c.Server.Shutdown(context.Background())
+		}()
+
+		scrape := func() string {
+			resp, err := resty.R().Get(baseURL + "/metrics")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 200)
+
+			return string(resp.Body())
+		}
+
+		resp, err := resty.R().Post(baseURL + "/v2/app/blobs/uploads/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 202)
+
+		// the blob is streamed in chunks, its size is unknown
+		reader, writer := io.Pipe()
+		done := make(chan int)
+
+		request, err := http.NewRequest(http.MethodPatch, baseURL+resp.Header().Get("Location"), reader)
+		So(err, ShouldBeNil)
+
+		go func() {
+			resp, err := http.DefaultClient.Do(request)
+			if err != nil {
+				done <- 0
+				return
+			}
+
+			resp.Body.Close()
+			done <- resp.StatusCode
+		}()
+
+		_, err = writer.Write([]byte("first chunk"))
+		So(err, ShouldBeNil)
+
+		metrics := scrape()
+		So(metrics, ShouldContainSubstring, "zot_blob_uploads_in_flight 1")
+		So(metrics, ShouldContainSubstring, "zot_blob_downloads_in_flight 0")
+		So(metrics, ShouldContainSubstring, "zot_blob_bytes_in_flight 0")
+		So(metrics, ShouldContainSubstring, "zot_http_requests_in_flight 2")
+		So(metrics, ShouldContainSubstring, `zot_scheduler_tasks_queued{priority="low"}`)
+		So(metrics, ShouldContainSubstring, "zot_scheduler_paused 0")
+
+		So(writer.Close(), ShouldBeNil)
+		So(<-done, ShouldEqual, http.StatusAccepted)
+
+		metrics = scrape()
+		So(metrics, ShouldContainSubstring, "zot_blob_uploads_in_flight 0")
+		So(metrics, ShouldContainSubstring, "zot_http_requests_in_flight 1")
+		So(metrics, ShouldNotContainSubstring, "zot_sync_queue_length")
+	})
+}
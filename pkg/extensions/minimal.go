@@ -3,24 +3,107 @@
 package extensions
 
 import (
-	"time"
+	"context"
 
+	"github.com/anuvu/zot/pkg/extensions/lint"
+	"github.com/anuvu/zot/pkg/extensions/pushscan"
+	"github.com/anuvu/zot/pkg/extensions/retag"
+	"github.com/anuvu/zot/pkg/extensions/sync"
 	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/netpolicy"
+	"github.com/anuvu/zot/pkg/scheduler"
 	"github.com/anuvu/zot/pkg/storage"
+	"github.com/anuvu/zot/pkg/tenant"
 	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
 )
 
-// DownloadTrivyDB ...
-func downloadTrivyDB(dbDir string, log log.Logger, updateInterval time.Duration) error {
+// EnableExtensions ...
+func EnableExtensions(extension *ExtensionConfig, log log.Logger, rootDir string,
+	sch *scheduler.Scheduler) *scheduler.PeriodicTask {
+	log.Warn().Msg("skipping enabling extensions because given zot binary doesn't support any extensions, please build zot full binary for this feature")
+
 	return nil
 }
 
-// EnableExtensions ...
-func EnableExtensions(extension *ExtensionConfig, log log.Logger, rootDir string) {
-	log.Warn().Msg("skipping enabling extensions because given zot binary doesn't support any extensions, please build zot full binary for this feature")
+// EffectiveConfig ...
+func EffectiveConfig(extension *ExtensionConfig, log log.Logger) *ExtensionConfig {
+	if extension != nil {
+		log.Warn().Msg("extensions are disabled because given zot binary doesn't support any extensions, please build zot full binary for this feature")
+	}
+
+	return nil
+}
+
+// EnableTracing ...
+func EnableTracing(extension *ExtensionConfig, log log.Logger) func() {
+	if extension != nil && extension.Tracing != nil && extension.Tracing.Enable {
+		log.Warn().Msg("skipping enabling tracing because given zot binary doesn't support any extensions, please build zot full binary for this feature")
+	}
+
+	return func() {}
+}
+
+// EnableSync ...
+func EnableSync(extension *ExtensionConfig, storeController storage.StoreController,
+	log log.Logger) *sync.Replicator {
+	if extension != nil && extension.Sync != nil && extension.Sync.Enable {
+		log.Warn().Msg("skipping enabling sync because given zot binary doesn't support any extensions, please build zot full binary for this feature")
+	}
+
+	return nil
+}
+
+// EnableRetag ...
+func EnableRetag(extension *ExtensionConfig, storeController storage.StoreController,
+	isImmutable func(repo, tag string) bool, sch *scheduler.Scheduler, log log.Logger) *retag.Retagger {
+	if extension != nil && extension.Search != nil && extension.Search.CVE != nil &&
+		len(extension.Search.CVE.Retag) != 0 {
+		log.Warn().Msg("skipping retag policies because given zot binary doesn't support any extensions, please build zot full binary for this feature")
+	}
+
+	return nil
+}
+
+// EnablePushScan ...
+func EnablePushScan(extension *ExtensionConfig, storeController storage.StoreController,
+	log log.Logger) *pushscan.PushScanner {
+	if extension != nil && extension.Search != nil && extension.Search.CVE != nil &&
+		extension.Search.CVE.ScanOnPush != nil {
+		log.Warn().Msg("skipping scan on push because given zot binary doesn't support any extensions, please build zot full binary for this feature")
+	}
+
+	return nil
+}
+
+// EnableCVERawReport ...
+func EnableCVERawReport(extension *ExtensionConfig, storeController storage.StoreController,
+	log log.Logger) func(ctx context.Context, repo, reference string) ([]byte, error) {
+	return nil
+}
+
+// EnableLint ...
+func EnableLint(extension *ExtensionConfig, log log.Logger) *lint.Linter {
+	if extension != nil && extension.Lint != nil && extension.Lint.Enable {
+		log.Warn().Msg("skipping lint because given zot binary doesn't support any extensions, please build zot full binary for this feature")
+	}
+
+	return nil
 }
 
 // SetupRoutes ...
-func SetupRoutes(extension *ExtensionConfig, router *mux.Router, storeController storage.StoreController, log log.Logger) {
+func SetupRoutes(extension *ExtensionConfig, router *mux.Router, storeController storage.StoreController,
+	replicator *sync.Replicator, retagger *retag.Retagger, pushScanner *pushscan.PushScanner, sch *scheduler.Scheduler,
+	netPolicy *netpolicy.Policy, tenants *tenant.Tenants, log log.Logger) func() {
 	log.Warn().Msg("skipping setting up extensions routes because given zot binary doesn't support any extensions, please build zot full binary for this feature")
+
+	return func() {}
+}
+
+// SetupGRPC ...
+func SetupGRPC(extension *ExtensionConfig, server grpc.ServiceRegistrar, storeController storage.StoreController,
+	replicator *sync.Replicator, log log.Logger) {
+	if extension != nil && extension.Search != nil && extension.Search.Enable {
+		log.Warn().Msg("skipping search gRPC service because given zot binary doesn't support any extensions, please build zot full binary for this feature")
+	}
 }
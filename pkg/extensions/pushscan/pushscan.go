@@ -0,0 +1,173 @@
+package pushscan
+
+import (
+	"context"
+	"sync"
+
+	"github.com/anuvu/zot/errors"
+	cveinfo "github.com/anuvu/zot/pkg/extensions/search/cve"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/repomatch"
+)
+
+const queueSize = 100
+
+// BypassAnnotation is the manifest annotation with which admin users push images without them being
+// blocked, e.g. for emergency fixes, its value is the reason of the bypass.
+const BypassAnnotation = "io.zot.scan.bypass" // nolint: gosec
+
+// Config scans the images pushed to the repositories matching Repositories, those with vulnerabilities
+// of Severity or above are reported in the logs, or rejected if Block is set.
+type Config struct {
+	Repositories []string // path.Match patterns of repository names, all repositories if empty
+	Severity     string   // one of UNKNOWN, LOW, MEDIUM, HIGH and CRITICAL
+	Block        bool     // scan the images before accepting their push, instead of queuing their scan
+	FailOpen     bool     // accept the pushes which couldn't be scanned while blocking
+}
+
+// Validate checks the severity and the repository patterns of the config.
+func (c Config) Validate() error {
+	if cveinfo.SeverityRank(c.Severity) < 0 {
+		return errors.ErrBadConfig
+	}
+
+	if err := repomatch.Validate(c.Repositories); err != nil {
+		return errors.ErrBadConfig
+	}
+
+	return nil
+}
+
+// Scanner returns the severity of each vulnerability of an image, none if the image can't be scanned.
+type Scanner func(ctx context.Context, repo, reference string) ([]string, error)
+
+type job struct {
+	repo      string
+	reference string
+}
+
+// PushScanner scans the pushed images, the queued scans being run one at a time by a single worker.
+type PushScanner struct {
+	config  Config
+	scanner Scanner
+	log     log.Logger
+	queue   chan job
+	lock    sync.Mutex
+	queued  map[job]bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewPushScanner starts the worker scanning the queued images, Stop must be called on shutdown.
+func NewPushScanner(config Config, scanner Scanner, log log.Logger) (*PushScanner, error) {
+	if err := config.Validate(); err != nil {
+		log.Error().Err(err).Str("severity", config.Severity).Msg("invalid scan on push config")
+		return nil, err
+	}
+
+	if scanner == nil {
+		return nil, errors.ErrPushScanNotEnabled
+	}
+
+	p := &PushScanner{
+		config:  config,
+		scanner: scanner,
+		log:     log,
+		queue:   make(chan job, queueSize),
+		queued:  make(map[job]bool),
+		done:    make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+
+	go p.run()
+
+	return p, nil
+}
+
+// Stop stops the worker, images still queued are not scanned.
+func (p *PushScanner) Stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// Matches tells whether the images pushed to a repository are scanned.
+func (p *PushScanner) Matches(repo string) bool {
+	return repomatch.Matches(p.config.Repositories, repo)
+}
+
+// Blocking tells whether images are scanned before their push is accepted.
+func (p *PushScanner) Blocking() bool {
+	return p.config.Block
+}
+
+// FailOpen tells whether the pushes of images which couldn't be scanned are accepted while blocking.
+func (p *PushScanner) FailOpen() bool {
+	return p.config.FailOpen
+}
+
+// Check scans an image, and returns the highest severity of its vulnerabilities if it's at or above the
+// threshold, empty otherwise.
+func (p *PushScanner) Check(ctx context.Context, repo, reference string) (string, error) {
+	vulnerabilities, err := p.scanner(ctx, repo, reference)
+	if err != nil {
+		return "", err
+	}
+
+	return cveinfo.HighestSeverity(vulnerabilities, p.config.Severity), nil
+}
+
+// QueueLength returns the number of images waiting to be scanned.
+func (p *PushScanner) QueueLength() int {
+	return len(p.queue)
+}
+
+// Notify queues the scan of a pushed image, each image being queued once until it's scanned.
+func (p *PushScanner) Notify(repo, reference string) {
+	j := job{repo: repo, reference: reference}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.queued[j] {
+		return
+	}
+
+	select {
+	case p.queue <- j:
+		p.queued[j] = true
+	default:
+		p.log.Warn().Str("repo", repo).Str("reference", reference).Msg("scan queue is full, image won't be scanned")
+	}
+}
+
+func (p *PushScanner) run() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case j := <-p.queue:
+			p.lock.Lock()
+			delete(p.queued, j)
+			p.lock.Unlock()
+
+			p.scan(j)
+		}
+	}
+}
+
+func (p *PushScanner) scan(j job) {
+	severity, err := p.Check(context.Background(), j.repo, j.reference)
+
+	switch {
+	case err != nil:
+		p.log.Error().Err(err).Str("repo", j.repo).Str("reference", j.reference).Msg("unable to scan pushed image")
+	case severity != "":
+		p.log.Warn().Str("repo", j.repo).Str("reference", j.reference).Str("severity", severity).
+			Msg("pushed image has vulnerabilities at or above the severity threshold")
+	default:
+		p.log.Debug().Str("repo", j.repo).Str("reference", j.reference).Msg("scanned pushed image")
+	}
+}
@@ -0,0 +1,95 @@
+package pushscan_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/extensions/pushscan"
+	"github.com/anuvu/zot/pkg/log"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPushScan(t *testing.T) {
+	logger := log.NewLogger("debug", "")
+
+	Convey("Validate config", t, func() {
+		So(pushscan.Config{Severity: "high"}.Validate(), ShouldBeNil)
+		So(pushscan.Config{Severity: "SEVERE"}.Validate(), ShouldEqual, errors.ErrBadConfig)
+		So(pushscan.Config{Severity: "HIGH", Repositories: []string{"["}}.Validate(), ShouldEqual, errors.ErrBadConfig)
+
+		_, err := pushscan.NewPushScanner(pushscan.Config{Severity: "HIGH"}, nil, logger)
+		So(err, ShouldEqual, errors.ErrPushScanNotEnabled)
+	})
+
+	Convey("Check and queue scans", t, func() {
+		scanned := make(chan string, 10)
+		vulnerabilities := map[string][]string{
+			"clean":    {},
+			"low":      {"LOW", "unknown"},
+			"critical": {"MEDIUM", "critical", "HIGH"},
+		}
+
+		scanner := func(ctx context.Context, repo, reference string) ([]string, error) {
+			scanned <- repo + ":" + reference
+
+			severities, ok := vulnerabilities[reference]
+			if !ok {
+				return nil, errors.ErrManifestNotFound
+			}
+
+			return severities, nil
+		}
+
+		config := pushscan.Config{Repositories: []string{"prod/*"}, Severity: "HIGH", Block: true}
+		p, err := pushscan.NewPushScanner(config, scanner, logger)
+		So(err, ShouldBeNil)
+
+		defer p.Stop()
+
+		So(p.Blocking(), ShouldBeTrue)
+		So(p.FailOpen(), ShouldBeFalse)
+		So(p.Matches("prod/app"), ShouldBeTrue)
+		So(p.Matches("dev/app"), ShouldBeFalse)
+
+		severity, err := p.Check(context.Background(), "prod/app", "clean")
+		So(err, ShouldBeNil)
+		So(severity, ShouldBeEmpty)
+
+		severity, err = p.Check(context.Background(), "prod/app", "low")
+		So(err, ShouldBeNil)
+		So(severity, ShouldBeEmpty)
+
+		severity, err = p.Check(context.Background(), "prod/app", "critical")
+		So(err, ShouldBeNil)
+		So(severity, ShouldEqual, "CRITICAL")
+
+		_, err = p.Check(context.Background(), "prod/app", "missing")
+		So(err, ShouldEqual, errors.ErrManifestNotFound)
+
+		for i := 0; i < 4; i++ {
+			<-scanned
+		}
+
+		p.Notify("prod/app", "critical")
+
+		select {
+		case image := <-scanned:
+			So(image, ShouldEqual, "prod/app:critical")
+		case <-time.After(5 * time.Second):
+			So("queued image was not scanned", ShouldBeEmpty)
+		}
+	})
+
+	Convey("All repositories match without patterns", t, func() {
+		p, err := pushscan.NewPushScanner(pushscan.Config{Severity: "LOW"},
+			func(ctx context.Context, repo, reference string) ([]string, error) { return nil, nil }, logger)
+		So(err, ShouldBeNil)
+
+		defer p.Stop()
+
+		So(p.Matches("any/repo"), ShouldBeTrue)
+		So(p.Blocking(), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,314 @@
+package retag
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/repomatch"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/rs/zerolog"
+)
+
+const queueSize = 100
+
+// Policy follows the Tag of the repositories matching Repositories: when a newer image of a repository
+// fixes some of the CVEs of the image tagged Tag without adding any, Alias is moved to the newest such
+// image, or it's only recommended in the logs if there's no Alias.
+type Policy struct {
+	Repositories []string // path.Match patterns of repository names, all repositories if empty
+	Tag          string   // floating tag, e.g. stable
+	Alias        string   // tag moved to the image fixing the CVEs, e.g. stable-patched
+}
+
+// Validate checks the tags and the repository patterns of the policy.
+func (p Policy) Validate() error {
+	if p.Tag == "" || p.Alias == p.Tag {
+		return errors.ErrBadConfig
+	}
+
+	if err := repomatch.Validate(p.Repositories); err != nil {
+		return errors.ErrBadConfig
+	}
+
+	return nil
+}
+
+// Scanner returns the ids of the CVEs of an image.
+type Scanner func(ctx context.Context, repo, reference string) ([]string, error)
+
+// Retagger checks the repositories of the retag policies on a single worker, so that their images
+// are scanned one at a time, each repository being queued once until it's checked.
+type Retagger struct {
+	policies        []Policy
+	storeController storage.StoreController
+	scanner         Scanner
+	isImmutable     func(repo, tag string) bool
+	log             log.Logger
+	queue           chan string
+	lock            sync.Mutex
+	queued          map[string]bool
+	done            chan struct{}
+	wg              sync.WaitGroup
+}
+
+// NewRetagger starts the worker checking the repositories, Stop must be called on shutdown. Aliases
+// for which isImmutable is true are only set if they don't exist yet.
+func NewRetagger(policies []Policy, storeController storage.StoreController, scanner Scanner,
+	isImmutable func(repo, tag string) bool, log log.Logger) (*Retagger, error) {
+	for _, policy := range policies {
+		if err := policy.Validate(); err != nil {
+			log.Error().Err(err).Str("tag", policy.Tag).Str("alias", policy.Alias).Msg("invalid retag policy")
+			return nil, err
+		}
+	}
+
+	if scanner == nil {
+		return nil, errors.ErrRetagScanNotEnabled
+	}
+
+	r := &Retagger{
+		policies:        policies,
+		storeController: storeController,
+		scanner:         scanner,
+		isImmutable:     isImmutable,
+		log:             log,
+		queue:           make(chan string, queueSize),
+		queued:          make(map[string]bool),
+		done:            make(chan struct{}),
+	}
+
+	r.wg.Add(1)
+
+	go r.run()
+
+	return r, nil
+}
+
+// Stop stops the worker, repositories still queued are not checked.
+func (r *Retagger) Stop() {
+	close(r.done)
+	r.wg.Wait()
+}
+
+// QueueLength returns the number of repositories waiting to be checked.
+func (r *Retagger) QueueLength() int {
+	return len(r.queue)
+}
+
+// Notify queues the check of a repository an image was pushed to, if a policy follows it.
+func (r *Retagger) Notify(repo, reference string) {
+	for _, policy := range r.policies {
+		// the aliases are moved by the retagger itself
+		if repomatch.Matches(policy.Repositories, repo) && reference != policy.Alias {
+			r.enqueue(repo)
+			return
+		}
+	}
+}
+
+// CheckAll queues the check of all the repositories followed by a policy, e.g. after the CVE database
+// was updated.
+func (r *Retagger) CheckAll() {
+	stores := []*storage.ImageStore{r.storeController.DefaultStore}
+	for _, imgStore := range r.storeController.SubStore {
+		stores = append(stores, imgStore)
+	}
+
+	for _, imgStore := range stores {
+		if imgStore == nil {
+			continue
+		}
+
+		repos, err := imgStore.GetRepositories()
+		if err != nil {
+			r.log.Error().Err(err).Str("rootDir", imgStore.RootDir()).Msg("unable to list repositories to retag")
+			continue
+		}
+
+		for _, repo := range repos {
+			r.Notify(repo, "")
+		}
+	}
+}
+
+func (r *Retagger) enqueue(repo string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.queued[repo] {
+		return
+	}
+
+	select {
+	case r.queue <- repo:
+		r.queued[repo] = true
+	default:
+		r.log.Warn().Str("repo", repo).Msg("retag queue is full, repository won't be checked")
+	}
+}
+
+func (r *Retagger) run() {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case repo := <-r.queue:
+			r.lock.Lock()
+			delete(r.queued, repo)
+			r.lock.Unlock()
+
+			r.check(repo)
+		}
+	}
+}
+
+func (r *Retagger) check(repo string) {
+	for _, policy := range r.policies {
+		if !repomatch.Matches(policy.Repositories, repo) {
+			continue
+		}
+
+		if err := r.apply(repo, policy); err != nil {
+			r.log.Error().Err(err).Str("repo", repo).Str("tag", policy.Tag).Str("alias", policy.Alias).
+				Msg("unable to apply retag policy")
+		}
+	}
+}
+
+// apply looks for the newest image of the repository fixing CVEs of the image tagged with the floating
+// tag of the policy, and moves the alias to it or recommends it.
+func (r *Retagger) apply(repo string, policy Policy) error {
+	imgStore := r.storeController.GetImageStore(repo)
+
+	repoMeta, err := imgStore.GetRepoMeta(repo)
+	if err != nil {
+		return err
+	}
+
+	floating, ok := repoMeta.Tags[policy.Tag]
+	if !ok {
+		return nil
+	}
+
+	floatingCVEs, err := r.scanner(context.Background(), repo, policy.Tag)
+	if err != nil || len(floatingCVEs) == 0 {
+		return err
+	}
+
+	for _, tag := range newerTags(repoMeta, floating, policy) {
+		cves, err := r.scanner(context.Background(), repo, tag)
+		if err != nil {
+			return err
+		}
+
+		fixed, ok := fixedCVEs(floatingCVEs, cves)
+		if !ok {
+			continue
+		}
+
+		logger := r.log.With().Str("repo", repo).Str("tag", policy.Tag).Str("fixedBy", tag).Strs("fixed", fixed).
+			Logger()
+
+		if policy.Alias == "" {
+			logger.Info().Msg("retag recommended, a newer image fixes CVEs of the floating tag")
+			return nil
+		}
+
+		return r.moveAlias(repo, repoMeta, repoMeta.Tags[tag], policy.Alias, logger)
+	}
+
+	return nil
+}
+
+// moveAlias tags the image with the alias, unless it's already tagged or the alias is immutable.
+func (r *Retagger) moveAlias(repo string, repoMeta storage.RepoMeta, digest godigest.Digest, alias string,
+	logger zerolog.Logger) error {
+	current, exists := repoMeta.Tags[alias]
+	if current == digest {
+		return nil
+	}
+
+	if exists && r.isImmutable != nil && r.isImmutable(repo, alias) {
+		return errors.ErrRetagImmutableAlias
+	}
+
+	imgStore := r.storeController.GetImageStore(repo)
+
+	manifest, _, mediaType, err := imgStore.GetImageManifest(repo, digest.String())
+	if err != nil {
+		return err
+	}
+
+	if _, err := imgStore.PutImageManifest(repo, alias, mediaType, manifest); err != nil {
+		return err
+	}
+
+	logger.Info().Str("alias", alias).Str("digest", digest.String()).Msg("alias moved to a newer image fixing CVEs")
+
+	return nil
+}
+
+// newerTags returns the tags of the images created after the one tagged with the floating tag,
+// the newest first, without the tags of the policy.
+func newerTags(repoMeta storage.RepoMeta, floating godigest.Digest, policy Policy) []string {
+	created := repoMeta.Manifests[floating].Created
+	tags := []string{}
+
+	for tag, digest := range repoMeta.Tags {
+		if tag == policy.Tag || tag == policy.Alias || digest == floating {
+			continue
+		}
+
+		if repoMeta.Manifests[digest].Created.After(created) {
+			tags = append(tags, tag)
+		}
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		createdI := repoMeta.Manifests[repoMeta.Tags[tags[i]]].Created
+		createdJ := repoMeta.Manifests[repoMeta.Tags[tags[j]]].Created
+
+		if !createdI.Equal(createdJ) {
+			return createdI.After(createdJ)
+		}
+
+		return tags[i] < tags[j]
+	})
+
+	return tags
+}
+
+// fixedCVEs returns the CVEs of the floating tag which the newer image doesn't have, it's a fix if
+// there's at least one and the newer image has no other CVE.
+func fixedCVEs(floating, newer []string) ([]string, bool) {
+	remaining := make(map[string]bool, len(floating))
+	for _, id := range floating {
+		remaining[id] = true
+	}
+
+	for _, id := range newer {
+		if _, ok := remaining[id]; !ok {
+			return nil, false
+		}
+
+		remaining[id] = false
+	}
+
+	fixed := []string{}
+
+	for id, notFixed := range remaining {
+		if notFixed {
+			fixed = append(fixed, id)
+		}
+	}
+
+	sort.Strings(fixed)
+
+	return fixed, len(fixed) != 0
+}
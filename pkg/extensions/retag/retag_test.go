@@ -0,0 +1,200 @@
+package retag_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/extensions/retag"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/rs/zerolog"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const repo = "app"
+
+func pushImage(imageStore *storage.ImageStore, tag string, created time.Time) godigest.Digest {
+	So(imageStore.InitRepo(repo), ShouldBeNil)
+
+	layer := []byte("this is a layer of " + repo + ":" + tag)
+	layerDigest := godigest.FromBytes(layer)
+	_, _, err := imageStore.FullBlobUpload(repo, bytes.NewReader(layer), layerDigest.String())
+	So(err, ShouldBeNil)
+
+	config, err := json.Marshal(ispec.Image{Created: &created})
+	So(err, ShouldBeNil)
+
+	configDigest := godigest.FromBytes(config)
+	_, _, err = imageStore.FullBlobUpload(repo, bytes.NewReader(config), configDigest.String())
+	So(err, ShouldBeNil)
+
+	manifest := ispec.Manifest{
+		Config: ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    configDigest,
+			Size:      int64(len(config)),
+		},
+		Layers: []ispec.Descriptor{
+			{
+				MediaType: ispec.MediaTypeImageLayer,
+				Digest:    layerDigest,
+				Size:      int64(len(layer)),
+			},
+		},
+	}
+	manifest.SchemaVersion = 2
+
+	content, err := json.Marshal(manifest)
+	So(err, ShouldBeNil)
+
+	digest, err := imageStore.PutImageManifest(repo, tag, ispec.MediaTypeImageManifest, content)
+	So(err, ShouldBeNil)
+
+	return godigest.Digest(digest)
+}
+
+// waitForTag waits for the retagger to tag the expected digest, and returns the digest of the tag.
+func waitForTag(imageStore *storage.ImageStore, tag string, expected godigest.Digest) godigest.Digest {
+	var digest godigest.Digest
+
+	for i := 0; i < 50; i++ {
+		repoMeta, err := imageStore.GetRepoMeta(repo)
+		So(err, ShouldBeNil)
+
+		if digest = repoMeta.Tags[tag]; digest == expected {
+			break
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return digest
+}
+
+func TestRetag(t *testing.T) {
+	Convey("Test retag policies", t, func() {
+		dir, err := ioutil.TempDir("", "retag-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		logger := log.Logger{Logger: zerolog.New(ioutil.Discard)}
+		imageStore := storage.NewImageStore(dir, false, false, logger)
+		storeController := storage.StoreController{DefaultStore: imageStore}
+
+		now := time.Now()
+		pushImage(imageStore, "1.0", now.Add(-2*time.Hour))
+		stable := pushImage(imageStore, "stable", now.Add(-time.Hour))
+		fix := pushImage(imageStore, "1.2", now.Add(-30*time.Minute))
+		pushImage(imageStore, "1.3", now)
+
+		// 1.3 is newer but brings a new CVE, 1.2 fixes one of the CVEs of stable
+		cves := map[string][]string{
+			"1.0":    {},
+			"stable": {"CVE-1", "CVE-2"},
+			"1.2":    {"CVE-1"},
+			"1.3":    {"CVE-1", "CVE-3"},
+		}
+		scanner := func(_ context.Context, _, reference string) ([]string, error) {
+			ids, ok := cves[reference]
+			if !ok {
+				return nil, errors.ErrManifestNotFound
+			}
+
+			return ids, nil
+		}
+
+		Convey("Test alias is moved to the newest image fixing CVEs", func() {
+			retagger, err := retag.NewRetagger([]retag.Policy{{Tag: "stable", Alias: "stable-patched"}},
+				storeController, scanner, nil, logger)
+			So(err, ShouldBeNil)
+			defer retagger.Stop()
+
+			retagger.CheckAll()
+			So(waitForTag(imageStore, "stable-patched", fix), ShouldEqual, fix)
+
+			Convey("Test a newer fix moves the alias again", func() {
+				cves["1.4"] = []string{}
+				newFix := pushImage(imageStore, "1.4", now.Add(time.Minute))
+				retagger.Notify(repo, "1.4")
+
+				So(waitForTag(imageStore, "stable-patched", newFix), ShouldEqual, newFix)
+				So(waitForTag(imageStore, "stable", stable), ShouldEqual, stable)
+			})
+		})
+
+		Convey("Test other repositories aren't retagged", func() {
+			retagger, err := retag.NewRetagger([]retag.Policy{
+				{Repositories: []string{"other/*"}, Tag: "stable", Alias: "stable-patched"},
+			}, storeController, scanner, nil, logger)
+			So(err, ShouldBeNil)
+
+			retagger.CheckAll()
+			retagger.Notify(repo, "1.2")
+			time.Sleep(200 * time.Millisecond)
+			retagger.Stop()
+
+			repoMeta, err := imageStore.GetRepoMeta(repo)
+			So(err, ShouldBeNil)
+			So(repoMeta.Tags, ShouldNotContainKey, "stable-patched")
+		})
+
+		Convey("Test images are only recommended without alias", func() {
+			retagger, err := retag.NewRetagger([]retag.Policy{{Tag: "stable"}}, storeController, scanner, nil, logger)
+			So(err, ShouldBeNil)
+
+			retagger.CheckAll()
+			time.Sleep(200 * time.Millisecond)
+			retagger.Stop()
+
+			repoMeta, err := imageStore.GetRepoMeta(repo)
+			So(err, ShouldBeNil)
+			So(len(repoMeta.Tags), ShouldEqual, 4)
+			So(repoMeta.Tags["stable"], ShouldEqual, stable)
+		})
+
+		Convey("Test immutable aliases aren't moved", func() {
+			old := pushImage(imageStore, "stable-patched", now.Add(-3*time.Hour))
+			isImmutable := func(repo, tag string) bool {
+				return tag == "stable-patched"
+			}
+
+			retagger, err := retag.NewRetagger([]retag.Policy{{Tag: "stable", Alias: "stable-patched"}},
+				storeController, scanner, isImmutable, logger)
+			So(err, ShouldBeNil)
+
+			retagger.CheckAll()
+			time.Sleep(200 * time.Millisecond)
+			retagger.Stop()
+
+			repoMeta, err := imageStore.GetRepoMeta(repo)
+			So(err, ShouldBeNil)
+			So(repoMeta.Tags["stable-patched"], ShouldEqual, old)
+		})
+	})
+
+	Convey("Test invalid retag policies", t, func() {
+		logger := log.Logger{Logger: zerolog.New(ioutil.Discard)}
+		scanner := func(context.Context, string, string) ([]string, error) {
+			return nil, nil
+		}
+
+		So(retag.Policy{Alias: "patched"}.Validate(), ShouldEqual, errors.ErrBadConfig)
+		So(retag.Policy{Tag: "stable", Alias: "stable"}.Validate(), ShouldEqual, errors.ErrBadConfig)
+		So(retag.Policy{Repositories: []string{"[app"}, Tag: "stable"}.Validate(), ShouldEqual, errors.ErrBadConfig)
+		So(retag.Policy{Repositories: []string{"app/*"}, Tag: "stable"}.Validate(), ShouldBeNil)
+
+		_, err := retag.NewRetagger([]retag.Policy{{Tag: "stable"}}, storage.StoreController{}, nil, nil, logger)
+		So(err, ShouldEqual, errors.ErrRetagScanNotEnabled)
+
+		_, err = retag.NewRetagger([]retag.Policy{{Tag: ""}}, storage.StoreController{}, scanner, nil, logger)
+		So(err, ShouldEqual, errors.ErrBadConfig)
+	})
+}
@@ -0,0 +1,79 @@
+package annotationinfo
+
+import (
+	goerrors "errors"
+	"sort"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+)
+
+// AnnotationInfo implements searching by image manifest annotations.
+type AnnotationInfo struct {
+	Log             log.Logger
+	StoreController storage.StoreController
+}
+
+// NewAnnotationInfo initializes a new AnnotationInfo object.
+func NewAnnotationInfo(storeController storage.StoreController, log log.Logger) *AnnotationInfo {
+	return &AnnotationInfo{Log: log, StoreController: storeController}
+}
+
+// GetImageTagsByAnnotation returns the tags in a repository whose manifest has the annotation key,
+// with the given value unless value is nil.
+func (annotationInfo AnnotationInfo) GetImageTagsByAnnotation(repo, key string, value *string) ([]*string, error) {
+	tags := []*string{}
+
+	repoMeta, err := annotationInfo.StoreController.GetImageStore(repo).GetRepoMeta(repo)
+	if err != nil {
+		annotationInfo.Log.Error().Err(err).Msg("unable to read image manifests")
+
+		if goerrors.Is(err, errors.ErrRepoNotFound) {
+			return nil, err
+		}
+
+		return tags, err
+	}
+
+	for tag, digest := range repoMeta.Tags {
+		tag := tag
+
+		annotation, ok := repoMeta.Manifests[digest].Annotations[key]
+		if !ok || (value != nil && annotation != *value) {
+			continue
+		}
+
+		tags = append(tags, &tag)
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		return *tags[i] < *tags[j]
+	})
+
+	return tags, nil
+}
+
+// GetImagesByGitVersion returns the tags of each repository of all image stores with an image built by stacker
+// from the given git version.
+func (annotationInfo AnnotationInfo) GetImagesByGitVersion(version string) (map[string][]string, error) {
+	stores := []*storage.ImageStore{annotationInfo.StoreController.DefaultStore}
+	for _, store := range annotationInfo.StoreController.SubStore {
+		stores = append(stores, store)
+	}
+
+	results := map[string][]string{}
+
+	for _, store := range stores {
+		images, err := store.SearchGitVersion(version)
+		if err != nil {
+			return results, err
+		}
+
+		for repo, tags := range images {
+			results[repo] = tags
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,126 @@
+package annotationinfo_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/anuvu/zot/errors"
+	annotationinfo "github.com/anuvu/zot/pkg/extensions/search/annotation"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const gitVersion = "ws.tycho.stacker.git_version"
+
+func TestImageTagsByAnnotation(t *testing.T) {
+	Convey("Test image tags by annotation", t, func() {
+		dir, err := ioutil.TempDir("", "annotation_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		log := log.NewLogger("debug", "")
+		imageStore := storage.NewImageStore(dir, false, false, log)
+		storeController := storage.StoreController{DefaultStore: imageStore}
+
+		pushImage(imageStore, "zot-test", "1.0", map[string]string{gitVersion: "v1.0"})
+		pushImage(imageStore, "zot-test", "2.0", map[string]string{gitVersion: "v2.0"})
+		pushImage(imageStore, "zot-test", "3.0", nil)
+
+		annotationInfo := annotationinfo.NewAnnotationInfo(storeController, log)
+
+		value := "v1.0"
+		tags, err := annotationInfo.GetImageTagsByAnnotation("zot-test", gitVersion, &value)
+		So(err, ShouldBeNil)
+		So(len(tags), ShouldEqual, 1)
+		So(*tags[0], ShouldEqual, "1.0")
+
+		tags, err = annotationInfo.GetImageTagsByAnnotation("zot-test", gitVersion, nil)
+		So(err, ShouldBeNil)
+		So(len(tags), ShouldEqual, 2)
+
+		value = "v3.0"
+		tags, err = annotationInfo.GetImageTagsByAnnotation("zot-test", gitVersion, &value)
+		So(err, ShouldBeNil)
+		So(tags, ShouldBeEmpty)
+
+		_, err = annotationInfo.GetImageTagsByAnnotation("zot-missing", gitVersion, nil)
+		So(err, ShouldEqual, errors.ErrRepoNotFound)
+	})
+}
+
+func TestImagesByGitVersion(t *testing.T) {
+	Convey("Test images of all stores by git version", t, func() {
+		dir, err := ioutil.TempDir("", "annotation_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		subDir, err := ioutil.TempDir("", "annotation_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(subDir)
+
+		log := log.NewLogger("debug", "")
+		imageStore := storage.NewImageStore(dir, false, false, log)
+		subStore := storage.NewImageStore(subDir, false, false, log)
+		storeController := storage.StoreController{DefaultStore: imageStore,
+			SubStore: map[string]*storage.ImageStore{"/a": subStore}}
+
+		pushImage(imageStore, "zot-test", "1.0", map[string]string{gitVersion: "v1.0"})
+		pushImage(imageStore, "zot-test", "2.0", map[string]string{gitVersion: "v2.0"})
+		pushImage(subStore, "a/zot-test", "1.0", map[string]string{gitVersion: "v1.0"})
+
+		annotationInfo := annotationinfo.NewAnnotationInfo(storeController, log)
+
+		images, err := annotationInfo.GetImagesByGitVersion("v1.0")
+		So(err, ShouldBeNil)
+		So(images, ShouldResemble, map[string][]string{"zot-test": {"1.0"}, "a/zot-test": {"1.0"}})
+
+		images, err = annotationInfo.GetImagesByGitVersion("v3.0")
+		So(err, ShouldBeNil)
+		So(images, ShouldBeEmpty)
+	})
+}
+
+func pushImage(imageStore *storage.ImageStore, repo, tag string, annotations map[string]string) {
+	So(imageStore.InitRepo(repo), ShouldBeNil)
+
+	layer := []byte("this is a layer of " + tag)
+	layerDigest := godigest.FromBytes(layer)
+	_, _, err := imageStore.FullBlobUpload(repo, bytes.NewReader(layer), layerDigest.String())
+	So(err, ShouldBeNil)
+
+	config, err := json.Marshal(ispec.Image{})
+	So(err, ShouldBeNil)
+
+	configDigest := godigest.FromBytes(config)
+	_, _, err = imageStore.FullBlobUpload(repo, bytes.NewReader(config), configDigest.String())
+	So(err, ShouldBeNil)
+
+	manifest := ispec.Manifest{
+		Config: ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    configDigest,
+			Size:      int64(len(config)),
+		},
+		Layers: []ispec.Descriptor{
+			{
+				MediaType: ispec.MediaTypeImageLayer,
+				Digest:    layerDigest,
+				Size:      int64(len(layer)),
+			},
+		},
+		Annotations: annotations,
+	}
+	manifest.SchemaVersion = 2
+
+	content, err := json.Marshal(manifest)
+	So(err, ShouldBeNil)
+
+	_, err = imageStore.PutImageManifest(repo, tag, ispec.MediaTypeImageManifest, content)
+	So(err, ShouldBeNil)
+}
@@ -0,0 +1,213 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/anuvu/zot/pkg/tenant"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// maxCachedResults bounds the query results kept in memory, the oldest ones being dropped first.
+const maxCachedResults = 1024
+
+// cacheableQueries are the queries whose results only depend on the repositories and their metadata, the
+// others also depend on pull statistics, user preferences, the CVE database or the sync status.
+// nolint: gochecknoglobals
+var cacheableQueries = map[string]bool{
+	"ImageList":              true,
+	"ImageListForDigest":     true,
+	"ImageListForAnnotation": true,
+	"ImageListForGitVersion": true,
+	"__typename":             true,
+}
+
+// ResultCache is a gqlgen extension which caches the results of the queries only depending on the repositories,
+// by user, query and variables, until the generation of an image store changes on a push or a delete, or the
+// members of a tenant change.
+type ResultCache struct {
+	storeController storage.StoreController
+	tenants         *tenant.Tenants
+	lock            sync.Mutex
+	generation      uint64
+	results         map[string]*graphql.Response
+	keys            []string
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+} = &ResultCache{}
+
+// NewResultCache returns a cache of the results of the queries on the repositories of the store controller,
+// filtered by the membership of the tenants.
+func NewResultCache(storeController storage.StoreController, tenants *tenant.Tenants) *ResultCache {
+	return &ResultCache{storeController: storeController, tenants: tenants,
+		results: make(map[string]*graphql.Response)}
+}
+
+func (*ResultCache) ExtensionName() string {
+	return "ResultCache"
+}
+
+func (*ResultCache) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (rc *ResultCache) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	oc := graphql.GetOperationContext(ctx)
+	if oc == nil || oc.Operation == nil || oc.Operation.Operation != ast.Query || !isCacheable(oc.Operation.SelectionSet) {
+		return next(ctx)
+	}
+
+	variables, err := json.Marshal(oc.Variables)
+	if err != nil {
+		return next(ctx)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{log.GetUsername(ctx), oc.RawQuery, oc.OperationName,
+		string(variables)}, "\x00")))
+	key := hex.EncodeToString(sum[:])
+
+	// the results computed while the repositories change are recorded under the generation read before
+	generation := rc.currentGeneration()
+
+	if result, ok := rc.get(key, generation); ok {
+		return result
+	}
+
+	result := next(ctx)
+	if result != nil && len(result.Errors) == 0 {
+		rc.put(key, generation, result)
+	}
+
+	return result
+}
+
+// currentGeneration sums the generations of the image stores and of the tenants, it increases whenever one
+// of them changes.
+func (rc *ResultCache) currentGeneration() uint64 {
+	generation := rc.storeController.DefaultStore.Generation() + rc.tenants.Generation()
+
+	for _, store := range rc.storeController.SubStore {
+		generation += store.Generation()
+	}
+
+	return generation
+}
+
+func (rc *ResultCache) get(key string, generation uint64) (*graphql.Response, bool) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if generation != rc.generation {
+		return nil, false
+	}
+
+	result, ok := rc.results[key]
+
+	return result, ok
+}
+
+func (rc *ResultCache) put(key string, generation uint64, result *graphql.Response) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if generation < rc.generation {
+		return
+	}
+
+	// the results of the previous generations are stale
+	if generation > rc.generation {
+		rc.generation = generation
+		rc.results = make(map[string]*graphql.Response)
+		rc.keys = nil
+	}
+
+	if _, ok := rc.results[key]; ok {
+		return
+	}
+
+	if len(rc.keys) >= maxCachedResults {
+		delete(rc.results, rc.keys[0])
+		rc.keys = rc.keys[1:]
+	}
+
+	rc.results[key] = result
+	rc.keys = append(rc.keys, key)
+}
+
+// isCacheable tells whether a query only selects cacheable queries.
+func isCacheable(selectionSet ast.SelectionSet) bool {
+	for _, selection := range selectionSet {
+		field, ok := selection.(*ast.Field)
+		if !ok || !cacheableQueries[field.Name] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// etagWriter buffers a response to compute its ETag.
+type etagWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (ew *etagWriter) WriteHeader(status int) {
+	ew.status = status
+}
+
+func (ew *etagWriter) Write(b []byte) (int, error) {
+	return ew.body.Write(b)
+}
+
+// ETagHandler sets the ETag of the successful query responses, the hash of their body, and answers 304 Not
+// Modified to the queries whose If-None-Match header has it, so that polling clients don't get the same
+// results again.
+func ETagHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &etagWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(ew, r)
+
+		if ew.status == http.StatusOK {
+			sum := sha256.Sum256(ew.body.Bytes())
+			etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", "no-cache")
+
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		w.WriteHeader(ew.status)
+		_, _ = w.Write(ew.body.Bytes())
+	})
+}
+
+// etagMatches tells whether an If-None-Match header lists the ETag, weak ETags matching too.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,10 +1,14 @@
 package cveinfo
 
 import (
+	"bytes"
+	"context"
+	goerrors "errors"
 	"fmt"
 	"path"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/anuvu/zot/errors"
 	"github.com/anuvu/zot/pkg/extensions/search/common"
@@ -14,18 +18,41 @@ import (
 	config "github.com/aquasecurity/trivy/integration/config"
 	"github.com/aquasecurity/trivy/pkg/report"
 	"github.com/google/go-containerregistry/pkg/v1/types"
+	godigest "github.com/opencontainers/go-digest"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	tracerName = "github.com/anuvu/zot/pkg/extensions/search/cve"
+)
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
 // UpdateCVEDb ...
 func UpdateCVEDb(dbDir string, log log.Logger) error {
+	_, span := otel.Tracer(tracerName).Start(context.Background(), "trivy.UpdateCVEDb")
+
 	config, err := config.NewConfig(dbDir)
 	if err != nil {
 		log.Error().Err(err).Msg("unable to get config")
+		endSpan(span, err)
+
 		return err
 	}
 
 	err = integration.RunTrivyDb(config.TrivyConfig)
+	endSpan(span, err)
+
 	if err != nil {
 		log.Error().Err(err).Msg("unable to update DB ")
 		return err
@@ -38,10 +65,6 @@ func NewTrivyConfig(dir string) (*config.Config, error) {
 	return config.NewConfig(dir)
 }
 
-func ScanImage(config *config.Config) (report.Results, error) {
-	return integration.ScanTrivyImage(config.TrivyConfig)
-}
-
 func GetCVEInfo(storeController storage.StoreController, log log.Logger) (*CveInfo, error) {
 	cveController := CveTrivyController{}
 	layoutUtils := common.NewOciLayoutUtils(storeController, log)
@@ -166,11 +189,11 @@ func (cveinfo CveInfo) IsValidImageFormat(imagePath string) (bool, error) {
 	return false, nil
 }
 
-func (cveinfo CveInfo) GetImageListForCVE(repo string, id string, imgStore *storage.ImageStore,
+func (cveinfo CveInfo) GetImageListForCVE(ctx context.Context, repo string, id string, imgStore *storage.ImageStore,
 	trivyConfig *config.Config) ([]*string, error) {
 	tags := make([]*string, 0)
 
-	tagList, err := imgStore.GetImageTags(repo)
+	repoMeta, err := imgStore.GetRepoMeta(repo)
 	if err != nil {
 		cveinfo.Log.Error().Err(err).Msg("unable to get list of image tag")
 
@@ -179,25 +202,32 @@ func (cveinfo CveInfo) GetImageListForCVE(repo string, id string, imgStore *stor
 
 	rootDir := imgStore.RootDir()
 
-	for _, tag := range tagList {
-		trivyConfig.TrivyConfig.Input = fmt.Sprintf("%s:%s", path.Join(rootDir, repo), tag)
-
-		isValidImage, _ := cveinfo.IsValidImageFormat(trivyConfig.TrivyConfig.Input)
+	for _, tag := range sortedTags(repoMeta) {
+		isValidImage, _ := cveinfo.IsValidImage(repo, tag)
 		if !isValidImage {
 			cveinfo.Log.Debug().Str("image", repo+":"+tag).Msg("image media type not supported for scanning")
 
 			continue
 		}
 
+		trivyConfig.TrivyConfig.Input = fmt.Sprintf("%s:%s", path.Join(rootDir, repo), tag)
+
 		cveinfo.Log.Info().Str("image", repo+":"+tag).Msg("scanning image")
 
-		results, err := ScanImage(trivyConfig)
+		results, err := ScanImage(ctx, trivyConfig)
 		if err != nil {
 			cveinfo.Log.Error().Err(err).Str("image", repo+":"+tag).Msg("unable to scan image")
 
+			// the request was canceled
+			if ctx.Err() != nil {
+				return tags, ctx.Err()
+			}
+
 			continue
 		}
 
+		cveinfo.RecordScan(repo, tag, results)
+
 		for _, result := range results {
 			for _, vulnerability := range result.Vulnerabilities {
 				if vulnerability.VulnerabilityID == id {
@@ -213,47 +243,129 @@ func (cveinfo CveInfo) GetImageListForCVE(repo string, id string, imgStore *stor
 	return tags, nil
 }
 
-// GetImageTagsWithTimestamp returns a list of image tags with timestamp available in the specified repository.
-func (cveinfo CveInfo) GetImageTagsWithTimestamp(repo string) ([]TagInfo, error) {
-	tagsInfo := make([]TagInfo, 0)
+// IsValidImage tells whether the image referenced by a tag or a digest, or all the tagged images of
+// the repository if reference is empty, can be scanned, judging by the media type of their layers.
+func (cveinfo CveInfo) IsValidImage(repo, reference string) (bool, error) {
+	repoMeta, err := cveinfo.StoreController.GetImageStore(repo).GetRepoMeta(repo)
+	if err != nil {
+		return false, err
+	}
+
+	digests := []godigest.Digest{}
 
-	imagePath := cveinfo.LayoutUtils.GetImageRepoPath(repo)
-	if !common.DirExists(imagePath) {
-		return nil, errors.ErrRepoNotFound
+	switch digest, ok := repoMeta.Tags[reference]; {
+	case ok:
+		digests = append(digests, digest)
+	case reference == "":
+		for _, tag := range sortedTags(repoMeta) {
+			digests = append(digests, repoMeta.Tags[tag])
+		}
+	default:
+		digests = append(digests, godigest.Digest(reference))
 	}
 
-	manifests, err := cveinfo.LayoutUtils.GetImageManifests(imagePath)
+	for _, digest := range digests {
+		for _, layer := range repoMeta.Manifests[digest].Layers {
+			switch types.MediaType(layer.MediaType) {
+			case types.OCILayer, types.DockerLayer:
+				return true, nil
+
+			default:
+				cveinfo.Log.Debug().Msg("image media type not supported for scanning")
+				return false, errors.ErrScanNotSupported
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// RecordScan saves the summary of the scan of an image referenced by a tag or a digest
+// in the metadata of its repository.
+func (cveinfo CveInfo) RecordScan(repo, reference string, results report.Results) {
+	imgStore := cveinfo.StoreController.GetImageStore(repo)
 
+	repoMeta, err := imgStore.GetRepoMeta(repo)
 	if err != nil {
-		cveinfo.Log.Error().Err(err).Msg("unable to read image manifests")
+		cveinfo.Log.Error().Err(err).Str("repo", repo).Msg("unable to record scan results")
 
-		return tagsInfo, err
+		return
 	}
 
-	for _, manifest := range manifests {
-		digest := manifest.Digest
-
-		v, ok := manifest.Annotations[ispec.AnnotationRefName]
-		if ok {
-			imageBlobManifest, err := cveinfo.LayoutUtils.GetImageBlobManifest(imagePath, digest)
+	digest, ok := repoMeta.Tags[reference]
+	if !ok {
+		digest = godigest.Digest(reference)
+	}
 
-			if err != nil {
-				cveinfo.Log.Error().Err(err).Msg("unable to read image blob manifest")
+	summary := &storage.ScanSummary{Scanned: time.Now(), CVEs: []string{}, Severities: map[string]int{},
+		CVESeverities: map[string]string{}}
 
-				return tagsInfo, err
+	for _, result := range results {
+		for _, vulnerability := range result.Vulnerabilities {
+			if _, ok := summary.CVESeverities[vulnerability.VulnerabilityID]; ok {
+				continue
 			}
 
-			imageInfo, err := cveinfo.LayoutUtils.GetImageInfo(imagePath, imageBlobManifest.Config.Digest)
-			if err != nil {
-				cveinfo.Log.Error().Err(err).Msg("unable to read image info")
+			severity := NormalizedSeverity(vulnerability)
 
-				return tagsInfo, err
-			}
+			summary.CVESeverities[vulnerability.VulnerabilityID] = severity
+			summary.CVEs = append(summary.CVEs, vulnerability.VulnerabilityID)
+			summary.Severities[severity]++
+		}
+	}
+
+	sort.Strings(summary.CVEs)
+
+	if err := imgStore.SetScanSummary(repo, digest, summary); err != nil {
+		cveinfo.Log.Error().Err(err).Str("image", repo+":"+reference).Msg("unable to record scan results")
+	}
+}
+
+// RawReport returns the results of a scan in the JSON format of trivy, as "trivy -f json" prints them, for tools
+// parsing its reports rather than the CVEs of the search API.
+func RawReport(results report.Results) ([]byte, error) {
+	// images with nothing to scan have no results, reported as an empty list rather than null
+	if results == nil {
+		results = report.Results{}
+	}
+
+	var buf bytes.Buffer
+
+	if err := (report.JsonWriter{Output: &buf}).Write(results); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func sortedTags(repoMeta storage.RepoMeta) []string {
+	tags := make([]string, 0, len(repoMeta.Tags))
+	for tag := range repoMeta.Tags {
+		tags = append(tags, tag)
+	}
 
-			timeStamp := *imageInfo.History[0].Created
+	sort.Strings(tags)
 
-			tagsInfo = append(tagsInfo, TagInfo{Name: v, Timestamp: timeStamp})
+	return tags
+}
+
+// GetImageTagsWithTimestamp returns a list of image tags with timestamp available in the specified repository.
+func (cveinfo CveInfo) GetImageTagsWithTimestamp(repo string) ([]TagInfo, error) {
+	tagsInfo := make([]TagInfo, 0)
+
+	repoMeta, err := cveinfo.StoreController.GetImageStore(repo).GetRepoMeta(repo)
+	if err != nil {
+		cveinfo.Log.Error().Err(err).Msg("unable to read image manifests")
+
+		if goerrors.Is(err, errors.ErrRepoNotFound) {
+			return nil, err
 		}
+
+		return tagsInfo, err
+	}
+
+	for _, tag := range sortedTags(repoMeta) {
+		tagsInfo = append(tagsInfo, TagInfo{Name: tag, Timestamp: repoMeta.Manifests[repoMeta.Tags[tag]].Created})
 	}
 
 	return tagsInfo, nil
@@ -2,6 +2,7 @@
 package cveinfo_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -12,12 +13,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/anuvu/zot/errors"
 	"github.com/anuvu/zot/pkg/api"
 	ext "github.com/anuvu/zot/pkg/extensions"
 	"github.com/anuvu/zot/pkg/extensions/search/common"
 	cveinfo "github.com/anuvu/zot/pkg/extensions/search/cve"
 	"github.com/anuvu/zot/pkg/log"
 	"github.com/anuvu/zot/pkg/storage"
+	dbTypes "github.com/aquasecurity/trivy-db/pkg/types"
+	"github.com/aquasecurity/trivy/pkg/report"
+	trivyTypes "github.com/aquasecurity/trivy/pkg/types"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/phayes/freeport"
 	. "github.com/smartystreets/goconvey/convey"
@@ -845,3 +850,97 @@ func TestCVEConfig(t *testing.T) {
 		}()
 	})
 }
+
+func TestScanPool(t *testing.T) {
+	Convey("Scans are bounded in number and duration", t, func() {
+		pool := cveinfo.NewScanPool(2, 200*time.Millisecond)
+
+		release := make(chan struct{})
+		running := make(chan struct{}, 3)
+		scan := func(results report.Results, err error) func() (report.Results, error) {
+			return func() (report.Results, error) {
+				running <- struct{}{}
+				<-release
+
+				return results, err
+			}
+		}
+
+		errs := make(chan error, 2)
+
+		for i := 0; i < 2; i++ {
+			go func() {
+				_, err := pool.Run(context.Background(), scan(nil, nil))
+				errs <- err
+			}()
+		}
+
+		<-running
+		<-running
+
+		// no slot is free, the request gives up
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := pool.Run(ctx, scan(nil, nil))
+		So(err, ShouldResemble, context.DeadlineExceeded)
+		So(len(running), ShouldEqual, 0)
+
+		// timed out scans keep their slot until they return
+		So(<-errs, ShouldEqual, errors.ErrCVEScanTimeout)
+		So(<-errs, ShouldEqual, errors.ErrCVEScanTimeout)
+
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err = pool.Run(ctx, scan(nil, nil))
+		So(err, ShouldResemble, context.DeadlineExceeded)
+
+		close(release)
+
+		results, err := pool.Run(context.Background(), scan(report.Results{{Target: "image"}}, nil))
+		So(err, ShouldBeNil)
+		So(results, ShouldHaveLength, 1)
+
+		_, err = pool.Run(context.Background(), scan(nil, errors.ErrBadBlob))
+		So(err, ShouldEqual, errors.ErrBadBlob)
+	})
+}
+
+func TestRawReport(t *testing.T) {
+	Convey("Scan results are reported in the JSON format of trivy", t, func() {
+		results := report.Results{{
+			Target: "image (alpine 3.12.0)",
+			Type:   "alpine",
+			Vulnerabilities: []trivyTypes.DetectedVulnerability{{
+				VulnerabilityID:  "CVE-2020-28928",
+				PkgName:          "musl",
+				InstalledVersion: "1.1.24-r8",
+				FixedVersion:     "1.1.24-r10",
+				Vulnerability:    dbTypes.Vulnerability{Title: "musl: ESC: fix", Severity: "MEDIUM"},
+			}},
+		}}
+
+		raw, err := cveinfo.RawReport(results)
+		So(err, ShouldBeNil)
+
+		var buf bytes.Buffer
+		So((report.JsonWriter{Output: &buf}).Write(results), ShouldBeNil)
+		So(string(raw), ShouldEqual, buf.String())
+
+		var parsed []map[string]interface{}
+		So(json.Unmarshal(raw, &parsed), ShouldBeNil)
+		So(parsed, ShouldHaveLength, 1)
+		So(parsed[0]["Target"], ShouldEqual, "image (alpine 3.12.0)")
+
+		vulnerabilities, ok := parsed[0]["Vulnerabilities"].([]interface{})
+		So(ok, ShouldBeTrue)
+		So(vulnerabilities, ShouldHaveLength, 1)
+		So(vulnerabilities[0].(map[string]interface{})["VulnerabilityID"], ShouldEqual, "CVE-2020-28928")
+		So(vulnerabilities[0].(map[string]interface{})["Severity"], ShouldEqual, "MEDIUM")
+
+		raw, err = cveinfo.RawReport(nil)
+		So(err, ShouldBeNil)
+		So(string(raw), ShouldEqual, "[]")
+	})
+}
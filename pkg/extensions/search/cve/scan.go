@@ -0,0 +1,115 @@
+package cveinfo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	integration "github.com/aquasecurity/trivy/integration"
+	config "github.com/aquasecurity/trivy/integration/config"
+	"github.com/aquasecurity/trivy/pkg/report"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultMaxConcurrentScans is how many images are scanned at once if not configured.
+	DefaultMaxConcurrentScans = 2
+	// DefaultScanTimeout is how long a scan may take if not configured.
+	DefaultScanTimeout = 10 * time.Minute
+)
+
+// ScanPool bounds the number of concurrent scans, trivy being CPU and memory hungry, and their duration.
+type ScanPool struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+type scanResult struct {
+	results report.Results
+	err     error
+}
+
+// the scans of the search, sync and retag extensions, of all the image stores, share the pool
+// nolint: gochecknoglobals
+var (
+	scanPoolLock   sync.RWMutex
+	sharedScanPool = NewScanPool(DefaultMaxConcurrentScans, DefaultScanTimeout)
+)
+
+// NewScanPool returns a pool running maxConcurrent scans at once, each for up to timeout, the defaults
+// are used for zero values.
+func NewScanPool(maxConcurrent int, timeout time.Duration) *ScanPool {
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultMaxConcurrentScans
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultScanTimeout
+	}
+
+	return &ScanPool{slots: make(chan struct{}, maxConcurrent), timeout: timeout}
+}
+
+// Run runs a scan once a slot of the pool is free. It gives up when the context is done or the scan
+// times out, trivy scans can't be interrupted though, and the slot is only freed once the scan returns.
+func (p *ScanPool) Run(ctx context.Context, scan func() (report.Results, error)) (report.Results, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	done := make(chan scanResult, 1)
+
+	go func() {
+		defer func() { <-p.slots }()
+
+		results, err := scan()
+		done <- scanResult{results: results, err: err}
+	}()
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-done:
+		return result.results, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, errors.ErrCVEScanTimeout
+	}
+}
+
+// SetScanLimits sets how many images are scanned at once and how long a scan may take, the defaults are
+// used for zero values. Scans in progress keep the previous limits.
+func SetScanLimits(maxConcurrent int, timeout time.Duration) {
+	pool := NewScanPool(maxConcurrent, timeout)
+
+	scanPoolLock.Lock()
+	sharedScanPool = pool
+	scanPoolLock.Unlock()
+}
+
+func getScanPool() *ScanPool {
+	scanPoolLock.RLock()
+	defer scanPoolLock.RUnlock()
+
+	return sharedScanPool
+}
+
+// ScanImage scans the image of the config in the shared pool, canceling the scan with the context.
+func ScanImage(ctx context.Context, config *config.Config) (report.Results, error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "trivy.ScanImage",
+		trace.WithAttributes(attribute.String("image", config.TrivyConfig.Input)))
+
+	results, err := getScanPool().Run(ctx, func() (report.Results, error) {
+		return integration.ScanTrivyImage(config.TrivyConfig)
+	})
+	endSpan(span, err)
+
+	return results, err
+}
@@ -0,0 +1,127 @@
+package cveinfo
+
+import (
+	"sort"
+	"strings"
+
+	trivyTypes "github.com/aquasecurity/trivy/pkg/types"
+)
+
+const (
+	severityUnknown = "UNKNOWN"
+	// nvdSource is the preferred source of CVSS scores, the others being vendors of distributions
+	nvdSource = "nvd"
+)
+
+// Severities are the trivy severities, from the least to the most severe.
+// nolint: gochecknoglobals
+var Severities = []string{severityUnknown, "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// CVSS are the CVSS vectors and scores of a vulnerability, as rated by one source.
+type CVSS struct {
+	Source   string
+	V2Vector string
+	V2Score  float64
+	V3Vector string
+	V3Score  float64
+}
+
+// GetCVSS returns the CVSS ratings of a vulnerability, NVD's first and then by source name.
+func GetCVSS(vulnerability trivyTypes.DetectedVulnerability) []CVSS {
+	cvss := make([]CVSS, 0, len(vulnerability.CVSS))
+
+	for source, rating := range vulnerability.CVSS {
+		cvss = append(cvss, CVSS{Source: source, V2Vector: rating.V2Vector, V2Score: rating.V2Score,
+			V3Vector: rating.V3Vector, V3Score: rating.V3Score})
+	}
+
+	sort.Slice(cvss, func(i, j int) bool {
+		if (cvss[i].Source == nvdSource) != (cvss[j].Source == nvdSource) {
+			return cvss[i].Source == nvdSource
+		}
+
+		return cvss[i].Source < cvss[j].Source
+	})
+
+	return cvss
+}
+
+// NormalizedSeverity returns the severity of a vulnerability as reported by the scanner, or computed
+// from its CVSS scores when it's UNKNOWN, v3 scores being preferred to v2 ones.
+func NormalizedSeverity(vulnerability trivyTypes.DetectedVulnerability) string {
+	if vulnerability.Severity != "" && vulnerability.Severity != severityUnknown {
+		return vulnerability.Severity
+	}
+
+	cvss := GetCVSS(vulnerability)
+
+	for _, rating := range cvss {
+		if rating.V3Score > 0 {
+			return cvssV3Severity(rating.V3Score)
+		}
+	}
+
+	for _, rating := range cvss {
+		if rating.V2Score > 0 {
+			return cvssV2Severity(rating.V2Score)
+		}
+	}
+
+	return severityUnknown
+}
+
+// SeverityRank orders the trivy severities from the least to the most severe, whatever their case,
+// -1 if unknown.
+func SeverityRank(severity string) int {
+	for rank, name := range Severities {
+		if strings.EqualFold(name, severity) {
+			return rank
+		}
+	}
+
+	return -1
+}
+
+// HighestSeverity returns the highest of the severities, in upper case, if it's at or above the threshold,
+// empty otherwise.
+func HighestSeverity(severities []string, threshold string) string {
+	highest := ""
+
+	for _, severity := range severities {
+		if SeverityRank(severity) > SeverityRank(highest) {
+			highest = strings.ToUpper(severity)
+		}
+	}
+
+	if highest == "" || SeverityRank(highest) < SeverityRank(threshold) {
+		return ""
+	}
+
+	return highest
+}
+
+// cvssV3Severity returns the qualitative severity rating of a CVSS v3 base score.
+func cvssV3Severity(score float64) string {
+	switch {
+	case score >= 9.0: // nolint: gomnd
+		return "CRITICAL"
+	case score >= 7.0: // nolint: gomnd
+		return "HIGH"
+	case score >= 4.0: // nolint: gomnd
+		return "MEDIUM"
+	default:
+		return "LOW"
+	}
+}
+
+// cvssV2Severity returns the NVD severity rating of a CVSS v2 base score, which has no critical one.
+func cvssV2Severity(score float64) string {
+	switch {
+	case score >= 7.0: // nolint: gomnd
+		return "HIGH"
+	case score >= 4.0: // nolint: gomnd
+		return "MEDIUM"
+	default:
+		return "LOW"
+	}
+}
@@ -0,0 +1,69 @@
+package cveinfo_test
+
+import (
+	"testing"
+
+	cveinfo "github.com/anuvu/zot/pkg/extensions/search/cve"
+	dbTypes "github.com/aquasecurity/trivy-db/pkg/types"
+	trivyTypes "github.com/aquasecurity/trivy/pkg/types"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNormalizedSeverity(t *testing.T) {
+	Convey("Severities reported by the scanner are kept", t, func() {
+		vulnerability := trivyTypes.DetectedVulnerability{Vulnerability: dbTypes.Vulnerability{Severity: "LOW",
+			CVSS: dbTypes.VendorCVSS{"nvd": {V3Score: 9.8}}}}
+
+		So(cveinfo.NormalizedSeverity(vulnerability), ShouldEqual, "LOW")
+	})
+
+	Convey("Unknown severities are computed from CVSS scores", t, func() {
+		vulnerability := trivyTypes.DetectedVulnerability{Vulnerability: dbTypes.Vulnerability{Severity: "UNKNOWN"}}
+		So(cveinfo.NormalizedSeverity(vulnerability), ShouldEqual, "UNKNOWN")
+
+		// v3 scores are preferred, NVD's first
+		vulnerability.CVSS = dbTypes.VendorCVSS{
+			"redhat": {V3Vector: "CVSS:3.0/AV:N/AC:L/PR:N/UI:N/S:U/C:L/I:N/A:N", V3Score: 5.3},
+			"nvd":    {V2Vector: "AV:N/AC:L/Au:N/C:P/I:P/A:P", V2Score: 7.5, V3Score: 9.8},
+		}
+		So(cveinfo.NormalizedSeverity(vulnerability), ShouldEqual, "CRITICAL")
+
+		cvss := cveinfo.GetCVSS(vulnerability)
+		So(len(cvss), ShouldEqual, 2)
+		So(cvss[0].Source, ShouldEqual, "nvd")
+		So(cvss[1].V3Vector, ShouldEqual, "CVSS:3.0/AV:N/AC:L/PR:N/UI:N/S:U/C:L/I:N/A:N")
+
+		vulnerability.CVSS = dbTypes.VendorCVSS{
+			"redhat": {V3Score: 5.3},
+			"nvd":    {V2Score: 7.5},
+		}
+		So(cveinfo.NormalizedSeverity(vulnerability), ShouldEqual, "MEDIUM")
+
+		// v2 scores have no critical rating
+		vulnerability.CVSS = dbTypes.VendorCVSS{"nvd": {V2Score: 10}}
+		So(cveinfo.NormalizedSeverity(vulnerability), ShouldEqual, "HIGH")
+
+		vulnerability.Severity = ""
+		vulnerability.CVSS = dbTypes.VendorCVSS{"nvd": {V3Score: 2.1}}
+		So(cveinfo.NormalizedSeverity(vulnerability), ShouldEqual, "LOW")
+	})
+}
+
+func TestSeverityRank(t *testing.T) {
+	Convey("Severities are ordered whatever their case", t, func() {
+		So(cveinfo.SeverityRank("UNKNOWN"), ShouldEqual, 0)
+		So(cveinfo.SeverityRank("high"), ShouldEqual, cveinfo.SeverityRank("HIGH"))
+		So(cveinfo.SeverityRank("Medium"), ShouldBeLessThan, cveinfo.SeverityRank("high"))
+		So(cveinfo.SeverityRank("CRITICAL"), ShouldEqual, len(cveinfo.Severities)-1)
+		So(cveinfo.SeverityRank(""), ShouldEqual, -1)
+		So(cveinfo.SeverityRank("bad"), ShouldEqual, -1)
+	})
+
+	Convey("The highest severity is only returned at or above the threshold", t, func() {
+		So(cveinfo.HighestSeverity(nil, "LOW"), ShouldBeEmpty)
+		So(cveinfo.HighestSeverity([]string{"low", "high", "medium"}, "HIGH"), ShouldEqual, "HIGH")
+		So(cveinfo.HighestSeverity([]string{"low", "high", "medium"}, "medium"), ShouldEqual, "HIGH")
+		So(cveinfo.HighestSeverity([]string{"low", "medium"}, "HIGH"), ShouldBeEmpty)
+		So(cveinfo.HighestSeverity([]string{"bad"}, "UNKNOWN"), ShouldBeEmpty)
+	})
+}
@@ -1,88 +1,75 @@
 package digestinfo
 
 import (
-	"strings"
+	goerrors "errors"
 
 	"github.com/anuvu/zot/errors"
-	"github.com/anuvu/zot/pkg/extensions/search/common"
 	"github.com/anuvu/zot/pkg/log"
 	"github.com/anuvu/zot/pkg/storage"
-	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // DigestInfo implements searching by manifes/config/layer digest.
 type DigestInfo struct {
-	Log         log.Logger
-	LayoutUtils *common.OciLayoutUtils
+	Log             log.Logger
+	StoreController storage.StoreController
 }
 
 // NewDigestInfo initializes a new DigestInfo object.
 func NewDigestInfo(storeController storage.StoreController, log log.Logger) *DigestInfo {
-	layoutUtils := common.NewOciLayoutUtils(storeController, log)
-
-	return &DigestInfo{Log: log, LayoutUtils: layoutUtils}
+	return &DigestInfo{Log: log, StoreController: storeController}
 }
 
 // FilterImagesByDigest returns a list of image tags in a repository matching a specific divest.
 func (digestinfo DigestInfo) GetImageTagsByDigest(repo string, digest string) ([]*string, error) {
 	uniqueTags := []*string{}
 
-	imagePath := digestinfo.LayoutUtils.GetImageRepoPath(repo)
-	if !common.DirExists(imagePath) {
-		return nil, errors.ErrRepoNotFound
-	}
+	imgStore := digestinfo.StoreController.GetImageStore(repo)
+
+	if _, err := imgStore.GetRepoMeta(repo); err != nil {
+		digestinfo.Log.Error().Err(err).Msg("unable to read image manifests")
 
-	manifests, err := digestinfo.LayoutUtils.GetImageManifests(imagePath)
+		if goerrors.Is(err, errors.ErrRepoNotFound) {
+			return nil, err
+		}
 
+		return uniqueTags, err
+	}
+
+	images, err := imgStore.SearchDigest(digest)
 	if err != nil {
-		digestinfo.Log.Error().Err(err).Msg("unable to read image manifests")
+		digestinfo.Log.Error().Err(err).Msg("unable to search digests")
+
 		return uniqueTags, err
 	}
 
-	for _, manifest := range manifests {
-		imageDigest := manifest.Digest
-
-		v, ok := manifest.Annotations[ispec.AnnotationRefName]
-		if ok {
-			imageBlobManifest, err := digestinfo.LayoutUtils.GetImageBlobManifest(imagePath, imageDigest)
-
-			if err != nil {
-				digestinfo.Log.Error().Err(err).Msg("unable to read image blob manifest")
-				return uniqueTags, err
-			}
-
-			tags := []*string{}
-
-			// Check the image manigest in index.json matches the search digest
-			// This is a blob with mediaType application/vnd.oci.image.manifest.v1+json
-			if strings.Contains(manifest.Digest.String(), digest) {
-				tags = append(tags, &v)
-			}
-
-			// Check the image config matches the search digest
-			// This is a blob with mediaType application/vnd.oci.image.config.v1+json
-			if strings.Contains(imageBlobManifest.Config.Digest.Algorithm+":"+imageBlobManifest.Config.Digest.Hex, digest) {
-				tags = append(tags, &v)
-			}
-
-			// Check to see if the individual layers in the oci image manifest match the digest
-			// These are blobs with mediaType application/vnd.oci.image.layer.v1.tar+gzip
-			for _, layer := range imageBlobManifest.Layers {
-				if strings.Contains(layer.Digest.Algorithm+":"+layer.Digest.Hex, digest) {
-					tags = append(tags, &v)
-				}
-			}
-
-			keys := make(map[string]bool)
-
-			for _, entry := range tags {
-				if _, value := keys[*entry]; !value {
-					uniqueTags = append(uniqueTags, entry)
-					keys[*entry] = true
-				}
-			}
-		}
+	tags := images[repo]
+	for i := range tags {
+		uniqueTags = append(uniqueTags, &tags[i])
 	}
 
 	return uniqueTags, nil
 }
+
+// GetImagesByDigest returns the tags of each repository of all image stores with an image whose manifest,
+// config or a layer has a digest starting with the given prefix, which can leave out the algorithm.
+func (digestinfo DigestInfo) GetImagesByDigest(digest string) (map[string][]string, error) {
+	stores := []*storage.ImageStore{digestinfo.StoreController.DefaultStore}
+	for _, store := range digestinfo.StoreController.SubStore {
+		stores = append(stores, store)
+	}
+
+	results := map[string][]string{}
+
+	for _, store := range stores {
+		images, err := store.SearchDigest(digest)
+		if err != nil {
+			return results, err
+		}
+
+		for repo, tags := range images {
+			results[repo] = tags
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,211 @@
+package search
+
+import (
+	"context"
+	goerrors "errors"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/rpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer answers the search queries of the gRPC API with the resolvers of the GraphQL API.
+type GRPCServer struct {
+	rpc.UnimplementedSearchServer
+	query QueryResolver
+}
+
+func NewGRPCServer(config Config) *GRPCServer {
+	return &GRPCServer{query: config.Resolvers.Query()}
+}
+
+func (s *GRPCServer) CVEListForImage(ctx context.Context,
+	req *rpc.CVEListForImageRequest) (*rpc.CVEListForImageResponse, error) {
+	result, err := s.query.CVEListForImage(ctx, req.Image)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &rpc.CVEListForImageResponse{Tag: stringValue(result.Tag)}
+
+	for _, cve := range result.CVEList {
+		c := &rpc.CVE{Id: stringValue(cve.ID), Title: stringValue(cve.Title),
+			Description: stringValue(cve.Description), Severity: stringValue(cve.Severity)}
+
+		for _, pkg := range cve.PackageList {
+			c.Packages = append(c.Packages, &rpc.PackageInfo{Name: stringValue(pkg.Name),
+				InstalledVersion: stringValue(pkg.InstalledVersion), FixedVersion: stringValue(pkg.FixedVersion)})
+		}
+
+		resp.Cves = append(resp.Cves, c)
+	}
+
+	return resp, nil
+}
+
+func (s *GRPCServer) ImageListForCVE(ctx context.Context,
+	req *rpc.ImageListForCVERequest) (*rpc.ImageListForCVEResponse, error) {
+	results, err := s.query.ImageListForCve(ctx, req.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &rpc.ImageListForCVEResponse{}
+
+	for _, result := range results {
+		resp.Images = append(resp.Images, imageTags(result.Name, result.Tags))
+	}
+
+	return resp, nil
+}
+
+func (s *GRPCServer) ImageListWithCVEFixed(ctx context.Context,
+	req *rpc.ImageListWithCVEFixedRequest) (*rpc.ImageListWithCVEFixedResponse, error) {
+	result, err := s.query.ImageListWithCVEFixed(ctx, req.Id, req.Image)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &rpc.ImageListWithCVEFixedResponse{}
+
+	for _, tag := range result.Tags {
+		resp.Tags = append(resp.Tags, &rpc.TagInfo{Name: stringValue(tag.Name), Timestamp: timestamp(tag.Timestamp)})
+	}
+
+	return resp, nil
+}
+
+func (s *GRPCServer) ImageList(ctx context.Context, req *rpc.ImageListRequest) (*rpc.ImageListResponse, error) {
+	results, err := s.query.ImageList(ctx, &req.Repo)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &rpc.ImageListResponse{}
+
+	for _, image := range results {
+		resp.Images = append(resp.Images, &rpc.ImageInfo{RepoName: stringValue(image.RepoName),
+			Tag: stringValue(image.Tag), Digest: stringValue(image.Digest),
+			ConfigDigest: stringValue(image.ConfigDigest), Size: int64(intValue(image.Size)),
+			LastUpdated: timestamp(image.LastUpdated), IsSigned: image.IsSigned != nil && *image.IsSigned,
+			LastScanned: timestamp(image.LastScanned), CveCount: int32(intValue(image.CVECount))})
+	}
+
+	return resp, nil
+}
+
+func (s *GRPCServer) ImageListForDigest(ctx context.Context,
+	req *rpc.ImageListForDigestRequest) (*rpc.ImageListForDigestResponse, error) {
+	results, err := s.query.ImageListForDigest(ctx, req.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &rpc.ImageListForDigestResponse{}
+
+	for _, result := range results {
+		resp.Images = append(resp.Images, imageTags(result.Name, result.Tags))
+	}
+
+	return resp, nil
+}
+
+func (s *GRPCServer) ImageListForAnnotation(ctx context.Context,
+	req *rpc.ImageListForAnnotationRequest) (*rpc.ImageListForAnnotationResponse, error) {
+	var value *string
+
+	if req.Value != "" {
+		value = &req.Value
+	}
+
+	results, err := s.query.ImageListForAnnotation(ctx, req.Key, value)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &rpc.ImageListForAnnotationResponse{}
+
+	for _, result := range results {
+		resp.Images = append(resp.Images, imageTags(result.Name, result.Tags))
+	}
+
+	return resp, nil
+}
+
+func (s *GRPCServer) ImageListByPopularity(ctx context.Context,
+	req *rpc.ImageListByPopularityRequest) (*rpc.ImageListByPopularityResponse, error) {
+	var limit *int
+
+	if req.Limit > 0 {
+		l := int(req.Limit)
+		limit = &l
+	}
+
+	results, err := s.query.ImageListByPopularity(ctx, limit)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &rpc.ImageListByPopularityResponse{}
+
+	for _, result := range results {
+		repo := &rpc.RepoPullStats{Name: stringValue(result.Name), Count: int64(intValue(result.Count)),
+			LastPull: timestamp(result.LastPull)}
+
+		for _, tag := range result.Tags {
+			repo.Tags = append(repo.Tags, &rpc.TagPullStats{Name: stringValue(tag.Name),
+				Count: int64(intValue(tag.Count)), LastPull: timestamp(tag.LastPull)})
+		}
+
+		resp.Repos = append(resp.Repos, repo)
+	}
+
+	return resp, nil
+}
+
+// grpcError maps the errors of the resolvers to gRPC status codes.
+func grpcError(err error) error {
+	switch {
+	case goerrors.Is(err, errors.ErrRepoNotFound), goerrors.Is(err, errors.ErrManifestNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func imageTags(name *string, tags []*string) *rpc.ImageTags {
+	result := &rpc.ImageTags{Name: stringValue(name), Tags: make([]string, 0, len(tags))}
+
+	for _, tag := range tags {
+		result.Tags = append(result.Tags, stringValue(tag))
+	}
+
+	return result
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+func intValue(i *int) int {
+	if i == nil {
+		return 0
+	}
+
+	return *i
+}
+
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+
+	return timestamppb.New(*t)
+}
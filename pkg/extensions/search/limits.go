@@ -0,0 +1,167 @@
+package search
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/netpolicy"
+)
+
+// maxRateLimiterClients is the number of clients whose rate is tracked before the idle ones are forgotten.
+const maxRateLimiterClients = 10000
+
+// NewComplexityRoot estimates the complexity of the queries returning lists as the complexity of their
+// items times the list limit, or listLength if the query has no limit.
+func NewComplexityRoot(listLength int) ComplexityRoot {
+	var complexity ComplexityRoot
+
+	list := func(childComplexity int) int {
+		return 1 + listLength*childComplexity
+	}
+
+	limited := func(childComplexity int, limit *int) int {
+		if limit != nil && *limit >= 0 {
+			return 1 + *limit*childComplexity
+		}
+
+		return list(childComplexity)
+	}
+
+	complexity.Query.ImageList = func(childComplexity int, repo *string) int {
+		return list(childComplexity)
+	}
+	complexity.Query.ImageListForCve = func(childComplexity int, id string) int {
+		return list(childComplexity)
+	}
+	complexity.Query.ImageListForDigest = func(childComplexity int, id string) int {
+		return list(childComplexity)
+	}
+	complexity.Query.ImageListForAnnotation = func(childComplexity int, key string, value *string) int {
+		return list(childComplexity)
+	}
+	complexity.Query.ImageListForGitVersion = func(childComplexity int, version string) int {
+		return list(childComplexity)
+	}
+	complexity.Query.ImageListByPopularity = limited
+	complexity.Query.LayerListBySharing = limited
+	complexity.Query.StarredRepos = list
+	complexity.Query.BookmarkedRepos = list
+	complexity.Query.SyncStatus = list
+	// the report reads the scans of all repositories, and lists the worst ones
+	complexity.Query.CVEReport = func(childComplexity int, prefix *string, limit *int) int {
+		return limited(childComplexity, limit)
+	}
+	complexity.Query.TagHistory = func(childComplexity int, repo string, tag string) int {
+		return list(childComplexity)
+	}
+
+	return complexity
+}
+
+// rateLimiter is a token bucket per client, refilled at rate tokens per second up to burst tokens.
+type rateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	clients map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), clients: make(map[string]*tokenBucket)}
+}
+
+// refill adds the tokens earned since the last query of the client.
+func (rl *rateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	bucket.tokens = math.Min(rl.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rl.rate)
+	bucket.last = now
+}
+
+// take takes a token of the client, it returns how long to wait for one if there's none left.
+func (rl *rateLimiter) take(client string, now time.Time) time.Duration {
+	rl.Lock()
+	defer rl.Unlock()
+
+	bucket, ok := rl.clients[client]
+	if !ok {
+		if len(rl.clients) >= maxRateLimiterClients {
+			rl.forgetIdle(now)
+		}
+
+		bucket = &tokenBucket{tokens: rl.burst, last: now}
+		rl.clients[client] = bucket
+	}
+
+	rl.refill(bucket, now)
+
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+	}
+
+	bucket.tokens--
+
+	return 0
+}
+
+// forgetIdle forgets the clients whose bucket is full again, they're no different from new ones.
+func (rl *rateLimiter) forgetIdle(now time.Time) {
+	for client, bucket := range rl.clients {
+		rl.refill(bucket, now)
+
+		if bucket.tokens >= rl.burst {
+			delete(rl.clients, client)
+		}
+	}
+}
+
+// RateLimitHandler limits the queries of each user, or of each client address for anonymous queries, to rate
+// per second in bursts of up to burst queries. The others are answered 429 Too Many Requests.
+func RateLimitHandler(next http.Handler, rate float64, burst int, netPolicy *netpolicy.Policy,
+	logger log.Logger) http.Handler {
+	limiter := newRateLimiter(rate, burst)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := log.GetUsername(r.Context())
+		if client == "" {
+			client = clientAddress(r, netPolicy)
+		}
+
+		if wait := limiter.take(client, time.Now()); wait > 0 {
+			logger.Warn().Str("client", client).Dur("retryAfter", wait).Msg("search query rate limit exceeded")
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"errors":[{"message":"too many queries, retry later"}]}`))
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientAddress returns the address of the client, the one forwarded by trusted proxies if any.
+func clientAddress(r *http.Request, netPolicy *netpolicy.Policy) string {
+	if netPolicy != nil {
+		if ip := netPolicy.ClientIP(r.RemoteAddr, r.Header.Get("X-Forwarded-For")); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -6,12 +6,26 @@ import (
 	"time"
 )
 
+type BuildInfo struct {
+	StackerYaml *string `json:"StackerYaml"`
+	GitVersion  *string `json:"GitVersion"`
+}
+
 type Cve struct {
-	ID          *string        `json:"Id"`
-	Title       *string        `json:"Title"`
-	Description *string        `json:"Description"`
-	Severity    *string        `json:"Severity"`
-	PackageList []*PackageInfo `json:"PackageList"`
+	ID                 *string        `json:"Id"`
+	Title              *string        `json:"Title"`
+	Description        *string        `json:"Description"`
+	Severity           *string        `json:"Severity"`
+	NormalizedSeverity *string        `json:"NormalizedSeverity"`
+	Cvss               []*Cvss        `json:"CVSS"`
+	PackageList        []*PackageInfo `json:"PackageList"`
+}
+
+type CVEReport struct {
+	RepoCount        *int              `json:"RepoCount"`
+	ScannedRepoCount *int              `json:"ScannedRepoCount"`
+	CVESummary       *CVESummary       `json:"CVESummary"`
+	WorstRepos       []*RepoCVESummary `json:"WorstRepos"`
 }
 
 type CVEResultForImage struct {
@@ -19,6 +33,60 @@ type CVEResultForImage struct {
 	CVEList []*Cve  `json:"CVEList"`
 }
 
+type CVESummary struct {
+	Count       *int    `json:"Count"`
+	MaxSeverity *string `json:"MaxSeverity"`
+	Critical    *int    `json:"Critical"`
+	High        *int    `json:"High"`
+	Medium      *int    `json:"Medium"`
+	Low         *int    `json:"Low"`
+	Unknown     *int    `json:"Unknown"`
+}
+
+type Cvss struct {
+	Source   *string  `json:"Source"`
+	V2Vector *string  `json:"V2Vector"`
+	V2Score  *float64 `json:"V2Score"`
+	V3Vector *string  `json:"V3Vector"`
+	V3Score  *float64 `json:"V3Score"`
+}
+
+type Deprecation struct {
+	Tag         *string `json:"Tag"`
+	Message     *string `json:"Message"`
+	Replacement *string `json:"Replacement"`
+}
+
+type ImageInfo struct {
+	RepoName     *string                 `json:"RepoName"`
+	Tag          *string                 `json:"Tag"`
+	Digest       *string                 `json:"Digest"`
+	ConfigDigest *string                 `json:"ConfigDigest"`
+	Size         *int                    `json:"Size"`
+	LastUpdated  *time.Time              `json:"LastUpdated"`
+	IsSigned     *bool                   `json:"IsSigned"`
+	LastScanned  *time.Time              `json:"LastScanned"`
+	CVECount     *int                    `json:"CVECount"`
+	CVESummary   *CVESummary             `json:"CVESummary"`
+	BuildInfo    *BuildInfo              `json:"BuildInfo"`
+	Provenance   *ProvenanceVerification `json:"Provenance"`
+	Lint         *LintResult             `json:"Lint"`
+}
+
+type ImageSummary struct {
+	RepoName     *string        `json:"RepoName"`
+	Tags         []*string      `json:"Tags"`
+	IsStarred    *bool          `json:"IsStarred"`
+	IsBookmarked *bool          `json:"IsBookmarked"`
+	Deprecations []*Deprecation `json:"Deprecations"`
+	CVESummary   *CVESummary    `json:"CVESummary"`
+}
+
+type ImgResultForAnnotation struct {
+	Name *string   `json:"Name"`
+	Tags []*string `json:"Tags"`
+}
+
 type ImgResultForCve struct {
 	Name *string   `json:"Name"`
 	Tags []*string `json:"Tags"`
@@ -33,13 +101,93 @@ type ImgResultForFixedCve struct {
 	Tags []*TagInfo `json:"Tags"`
 }
 
+type ImgResultForGitVersion struct {
+	Name *string   `json:"Name"`
+	Tags []*string `json:"Tags"`
+}
+
+type LintResult struct {
+	Passed     *bool      `json:"Passed"`
+	Violations []*string  `json:"Violations"`
+	Checked    *time.Time `json:"Checked"`
+}
+
 type PackageInfo struct {
 	Name             *string `json:"Name"`
 	InstalledVersion *string `json:"InstalledVersion"`
 	FixedVersion     *string `json:"FixedVersion"`
+	Target           *string `json:"Target"`
+}
+
+type ProvenanceVerification struct {
+	Valid         *bool      `json:"Valid"`
+	Attestation   *string    `json:"Attestation"`
+	PredicateType *string    `json:"PredicateType"`
+	Builder       *string    `json:"Builder"`
+	Reason        *string    `json:"Reason"`
+	Checked       *time.Time `json:"Checked"`
+}
+
+type RepoCVESummary struct {
+	RepoName   *string     `json:"RepoName"`
+	CVESummary *CVESummary `json:"CVESummary"`
+}
+
+type RepoPullStats struct {
+	Name     *string         `json:"Name"`
+	Count    *int            `json:"Count"`
+	LastPull *time.Time      `json:"LastPull"`
+	Tags     []*TagPullStats `json:"Tags"`
+}
+
+type SharedLayer struct {
+	Digest     *string   `json:"Digest"`
+	Size       *int      `json:"Size"`
+	ImageCount *int      `json:"ImageCount"`
+	Repos      []*string `json:"Repos"`
+	Copies     *int      `json:"Copies"`
+	SavedBytes *int      `json:"SavedBytes"`
+}
+
+type SyncFailure struct {
+	Repo      *string    `json:"Repo"`
+	Reference *string    `json:"Reference"`
+	Time      *time.Time `json:"Time"`
+	Reason    *string    `json:"Reason"`
+	Conflict  *bool      `json:"Conflict"`
+}
+
+type SyncQuarantinedImage struct {
+	Repo      *string    `json:"Repo"`
+	Reference *string    `json:"Reference"`
+	Time      *time.Time `json:"Time"`
+	Severity  *string    `json:"Severity"`
+}
+
+type SyncStatus struct {
+	Registry         *string                 `json:"Registry"`
+	LastSync         *time.Time              `json:"LastSync"`
+	ImagesSynced     *int                    `json:"ImagesSynced"`
+	BytesTransferred *int                    `json:"BytesTransferred"`
+	Queued           *int                    `json:"Queued"`
+	FailureCount     *int                    `json:"FailureCount"`
+	Failures         []*SyncFailure          `json:"Failures"`
+	Quarantined      []*SyncQuarantinedImage `json:"Quarantined"`
+}
+
+type TagEvent struct {
+	Digest         *string    `json:"Digest"`
+	PreviousDigest *string    `json:"PreviousDigest"`
+	Time           *time.Time `json:"Time"`
 }
 
 type TagInfo struct {
 	Name      *string    `json:"Name"`
 	Timestamp *time.Time `json:"Timestamp"`
 }
+
+type TagPullStats struct {
+	Name     *string    `json:"Name"`
+	Count    *int       `json:"Count"`
+	LastPull *time.Time `json:"LastPull"`
+}
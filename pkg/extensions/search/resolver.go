@@ -4,15 +4,27 @@ package search
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/anuvu/zot/errors"
 	"github.com/anuvu/zot/pkg/log"
 	"github.com/aquasecurity/trivy/integration/config"
+	"github.com/aquasecurity/trivy/pkg/report"
+	trivyTypes "github.com/aquasecurity/trivy/pkg/types"
+	godigest "github.com/opencontainers/go-digest"
 
+	annotationinfo "github.com/anuvu/zot/pkg/extensions/search/annotation"
+	"github.com/anuvu/zot/pkg/extensions/search/common"
 	cveinfo "github.com/anuvu/zot/pkg/extensions/search/cve"
 	digestinfo "github.com/anuvu/zot/pkg/extensions/search/digest"
+	"github.com/anuvu/zot/pkg/extensions/search/semver"
+	"github.com/anuvu/zot/pkg/extensions/sync"
+	"github.com/anuvu/zot/pkg/extensions/userprefs"
 	"github.com/anuvu/zot/pkg/storage"
+	"github.com/anuvu/zot/pkg/tenant"
 ) // THIS CODE IS A STARTING POINT ONLY. IT WILL NOT BE UPDATED WITH SCHEMA CHANGES.
 
 // Resolver ...
@@ -20,6 +32,9 @@ type Resolver struct {
 	cveInfo         *cveinfo.CveInfo
 	storeController storage.StoreController
 	digestInfo      *digestinfo.DigestInfo
+	annotationInfo  *annotationinfo.AnnotationInfo
+	userPrefs       *userprefs.UserPrefs
+	replicator      *sync.Replicator
 }
 
 // Query ...
@@ -30,42 +45,66 @@ func (r *Resolver) Query() QueryResolver {
 type queryResolver struct{ *Resolver }
 
 type cveDetail struct {
-	Title       string
-	Description string
-	Severity    string
-	PackageList []*PackageInfo
+	Title              string
+	Description        string
+	Severity           string
+	NormalizedSeverity string
+	CVSS               []*Cvss
+	PackageList        []*PackageInfo
 }
 
 // GetResolverConfig ...
-func GetResolverConfig(log log.Logger, storeController storage.StoreController) Config {
+func GetResolverConfig(log log.Logger, storeController storage.StoreController,
+	userPrefs *userprefs.UserPrefs, replicator *sync.Replicator) Config {
 	cveInfo, err := cveinfo.GetCVEInfo(storeController, log)
 	if err != nil {
 		panic(err)
 	}
 
 	digestInfo := digestinfo.NewDigestInfo(storeController, log)
-	resConfig := &Resolver{cveInfo: cveInfo, storeController: storeController, digestInfo: digestInfo}
+	annotationInfo := annotationinfo.NewAnnotationInfo(storeController, log)
+	resConfig := &Resolver{cveInfo: cveInfo, storeController: storeController, digestInfo: digestInfo,
+		annotationInfo: annotationInfo, userPrefs: userPrefs, replicator: replicator}
 
 	return Config{Resolvers: resConfig, Directives: DirectiveRoot{},
 		Complexity: ComplexityRoot{}}
 }
 
-func (r *queryResolver) CVEListForImage(ctx context.Context, image string) (*CVEResultForImage, error) {
+// scanImage scans an image, recording the scan of tagged images, ok is false if it can't be scanned.
+func (r *queryResolver) scanImage(ctx context.Context, image string) (report.Results, bool, error) {
+	repo, tag := common.GetImageDirAndTag(image)
+	if !tenant.IsAllowed(ctx, repo) {
+		return nil, false, errors.ErrRepoNotFound
+	}
+
 	trivyConfig := r.cveInfo.GetTrivyConfig(image)
 
 	r.cveInfo.Log.Info().Str("image", image).Msg("scanning image")
 
-	isValidImage, err := r.cveInfo.IsValidImageFormat(trivyConfig.TrivyConfig.Input)
+	isValidImage, err := r.cveInfo.IsValidImage(repo, tag)
 	if !isValidImage {
 		r.cveInfo.Log.Debug().Str("image", image).Msg("image media type not supported for scanning")
 
-		return &CVEResultForImage{}, err
+		return nil, false, err
 	}
 
-	cveResults, err := cveinfo.ScanImage(trivyConfig)
+	cveResults, err := cveinfo.ScanImage(ctx, trivyConfig)
 	if err != nil {
 		r.cveInfo.Log.Error().Err(err).Msg("unable to scan image repository")
 
+		return nil, false, err
+	}
+
+	if tag != "" {
+		r.cveInfo.RecordScan(repo, tag, cveResults)
+	}
+
+	return cveResults, true, nil
+}
+
+func (r *queryResolver) CVEListForImage(ctx context.Context, image string) (*CVEResultForImage, error) {
+	cveResults, ok, err := r.scanImage(ctx, image)
+	if !ok {
 		return &CVEResultForImage{}, err
 	}
 
@@ -78,6 +117,8 @@ func (r *queryResolver) CVEListForImage(ctx context.Context, image string) (*CVE
 	cveidMap := make(map[string]cveDetail)
 
 	for _, result := range cveResults {
+		target := result.Target
+
 		for _, vulnerability := range result.Vulnerabilities {
 			pkgName := vulnerability.PkgName
 
@@ -97,7 +138,8 @@ func (r *queryResolver) CVEListForImage(ctx context.Context, image string) (*CVE
 				pkgList := cveDetailStruct.PackageList
 
 				pkgList = append(pkgList,
-					&PackageInfo{Name: &pkgName, InstalledVersion: &installedVersion, FixedVersion: &fixedVersion})
+					&PackageInfo{Name: &pkgName, InstalledVersion: &installedVersion, FixedVersion: &fixedVersion,
+						Target: &target})
 
 				cveDetailStruct.PackageList = pkgList
 
@@ -106,10 +148,13 @@ func (r *queryResolver) CVEListForImage(ctx context.Context, image string) (*CVE
 				newPkgList := make([]*PackageInfo, 0)
 
 				newPkgList = append(newPkgList,
-					&PackageInfo{Name: &pkgName, InstalledVersion: &installedVersion, FixedVersion: &fixedVersion})
+					&PackageInfo{Name: &pkgName, InstalledVersion: &installedVersion, FixedVersion: &fixedVersion,
+						Target: &target})
 
 				cveidMap[vulnerability.VulnerabilityID] = cveDetail{Title: vulnerability.Title,
-					Description: vulnerability.Description, Severity: vulnerability.Severity, PackageList: newPkgList}
+					Description: vulnerability.Description, Severity: vulnerability.Severity,
+					NormalizedSeverity: cveinfo.NormalizedSeverity(vulnerability),
+					CVSS:               getCVSS(vulnerability), PackageList: newPkgList}
 			}
 		}
 	}
@@ -125,15 +170,65 @@ func (r *queryResolver) CVEListForImage(ctx context.Context, image string) (*CVE
 
 		severity := cveDetail.Severity
 
+		normalizedSeverity := cveDetail.NormalizedSeverity
+
 		pkgList := cveDetail.PackageList
 
 		cveids = append(cveids,
-			&Cve{ID: &vulID, Title: &title, Description: &desc, Severity: &severity, PackageList: pkgList})
+			&Cve{ID: &vulID, Title: &title, Description: &desc, Severity: &severity,
+				NormalizedSeverity: &normalizedSeverity, Cvss: cveDetail.CVSS, PackageList: pkgList})
 	}
 
 	return &CVEResultForImage{Tag: &copyImgTag, CVEList: cveids}, nil
 }
 
+// CVERawReportForImage returns the report of the scan of an image in the JSON format of trivy, as it prints
+// it with "-f json", instead of the CVEs of CVEListForImage.
+func (r *queryResolver) CVERawReportForImage(ctx context.Context, image string) (*string, error) {
+	cveResults, ok, err := r.scanImage(ctx, image)
+	if !ok {
+		// images which aren't there aren't reported as having nothing to scan
+		if err == nil {
+			err = errors.ErrManifestNotFound
+		}
+
+		return nil, err
+	}
+
+	raw, err := cveinfo.RawReport(cveResults)
+	if err != nil {
+		return nil, err
+	}
+
+	rawReport := string(raw)
+
+	return &rawReport, nil
+}
+
+// getCVSS returns the CVSS ratings of a vulnerability, the scores and vectors of the CVSS versions
+// a source didn't rate it with are nil.
+func getCVSS(vulnerability trivyTypes.DetectedVulnerability) []*Cvss {
+	ratings := cveinfo.GetCVSS(vulnerability)
+	cvss := make([]*Cvss, 0, len(ratings))
+
+	for i := range ratings {
+		rating := ratings[i]
+		result := &Cvss{Source: &rating.Source}
+
+		if rating.V2Vector != "" || rating.V2Score > 0 {
+			result.V2Vector, result.V2Score = &rating.V2Vector, &rating.V2Score
+		}
+
+		if rating.V3Vector != "" || rating.V3Score > 0 {
+			result.V3Vector, result.V3Score = &rating.V3Vector, &rating.V3Score
+		}
+
+		cvss = append(cvss, result)
+	}
+
+	return cvss
+}
+
 func (r *queryResolver) ImageListForCve(ctx context.Context, id string) ([]*ImgResultForCve, error) {
 	finalCveResult := []*ImgResultForCve{}
 
@@ -152,7 +247,7 @@ func (r *queryResolver) ImageListForCve(ctx context.Context, id string) ([]*ImgR
 
 	r.cveInfo.Log.Info().Msg("scanning each global repository")
 
-	cveResult, err := r.getImageListForCVE(repoList, id, defaultStore, defaultTrivyConfig)
+	cveResult, err := r.getImageListForCVE(ctx, repoList, id, defaultStore, defaultTrivyConfig)
 	if err != nil {
 		r.cveInfo.Log.Error().Err(err).Msg("error getting cve list for global repositories")
 
@@ -172,7 +267,7 @@ func (r *queryResolver) ImageListForCve(ctx context.Context, id string) ([]*ImgR
 
 		subTrivyConfig := r.cveInfo.CveTrivyController.SubCveConfig[route]
 
-		subCveResult, err := r.getImageListForCVE(subRepoList, id, store, subTrivyConfig)
+		subCveResult, err := r.getImageListForCVE(ctx, subRepoList, id, store, subTrivyConfig)
 		if err != nil {
 			r.cveInfo.Log.Error().Err(err).Msg("unable to get cve result for sub repositories")
 
@@ -185,16 +280,20 @@ func (r *queryResolver) ImageListForCve(ctx context.Context, id string) ([]*ImgR
 	return finalCveResult, nil
 }
 
-func (r *queryResolver) getImageListForCVE(repoList []string, id string, imgStore *storage.ImageStore,
-	trivyConfig *config.Config) ([]*ImgResultForCve, error) {
+func (r *queryResolver) getImageListForCVE(ctx context.Context, repoList []string, id string,
+	imgStore *storage.ImageStore, trivyConfig *config.Config) ([]*ImgResultForCve, error) {
 	cveResult := []*ImgResultForCve{}
 
 	for _, repo := range repoList {
+		if !tenant.IsAllowed(ctx, repo) {
+			continue
+		}
+
 		r.cveInfo.Log.Info().Str("repo", repo).Msg("extracting list of tags available in image repo")
 
 		name := repo
 
-		tags, err := r.cveInfo.GetImageListForCVE(repo, id, imgStore, trivyConfig)
+		tags, err := r.cveInfo.GetImageListForCVE(ctx, repo, id, imgStore, trivyConfig)
 		if err != nil {
 			r.cveInfo.Log.Error().Err(err).Msg("error getting tag")
 
@@ -212,6 +311,10 @@ func (r *queryResolver) getImageListForCVE(repoList []string, id string, imgStor
 func (r *queryResolver) ImageListWithCVEFixed(ctx context.Context, id string, image string) (*ImgResultForFixedCve, error) { // nolint: lll
 	imgResultForFixedCVE := &ImgResultForFixedCve{}
 
+	if repo, _ := common.GetImageDirAndTag(image); !tenant.IsAllowed(ctx, repo) {
+		return imgResultForFixedCVE, errors.ErrRepoNotFound
+	}
+
 	r.cveInfo.Log.Info().Str("image", image).Msg("retrieving image path")
 
 	imagePath := r.cveInfo.LayoutUtils.GetImageRepoPath(image)
@@ -236,7 +339,7 @@ func (r *queryResolver) ImageListWithCVEFixed(ctx context.Context, id string, im
 	for _, tag := range tagsInfo {
 		trivyConfig.TrivyConfig.Input = fmt.Sprintf("%s:%s", imagePath, tag.Name)
 
-		isValidImage, _ := r.cveInfo.IsValidImageFormat(trivyConfig.TrivyConfig.Input)
+		isValidImage, _ := r.cveInfo.IsValidImage(image, tag.Name)
 		if !isValidImage {
 			r.cveInfo.Log.Debug().Str("image",
 				image+":"+tag.Name).Msg("image media type not supported for scanning, adding as an infected image")
@@ -248,13 +351,20 @@ func (r *queryResolver) ImageListWithCVEFixed(ctx context.Context, id string, im
 
 		r.cveInfo.Log.Info().Str("image", image+":"+tag.Name).Msg("scanning image")
 
-		results, err := cveinfo.ScanImage(trivyConfig)
+		results, err := cveinfo.ScanImage(ctx, trivyConfig)
 		if err != nil {
 			r.cveInfo.Log.Error().Err(err).Str("image", image+":"+tag.Name).Msg("unable to scan image")
 
+			// the request was canceled
+			if ctx.Err() != nil {
+				return imgResultForFixedCVE, ctx.Err()
+			}
+
 			continue
 		}
 
+		r.cveInfo.RecordScan(image, tag.Name, results)
+
 		hasCVE = false
 
 		for _, result := range results {
@@ -294,61 +404,96 @@ func (r *queryResolver) ImageListWithCVEFixed(ctx context.Context, id string, im
 func (r *queryResolver) ImageListForDigest(ctx context.Context, id string) ([]*ImgResultForDigest, error) {
 	imgResultForDigest := []*ImgResultForDigest{}
 
-	r.digestInfo.Log.Info().Msg("extracting repositories")
-
-	defaultStore := r.storeController.DefaultStore
+	r.digestInfo.Log.Info().Str("digest", id).Msg("searching the digest index")
 
-	repoList, err := defaultStore.GetRepositories()
+	images, err := r.digestInfo.GetImagesByDigest(id)
 	if err != nil {
-		r.digestInfo.Log.Error().Err(err).Msg("unable to search repositories")
+		r.digestInfo.Log.Error().Err(err).Msg("unable to get image and tag list")
 
 		return imgResultForDigest, err
 	}
 
-	r.digestInfo.Log.Info().Msg("scanning each global repository")
+	for repo, tags := range images {
+		if !tenant.IsAllowed(ctx, repo) {
+			continue
+		}
+
+		name := repo
+		result := &ImgResultForDigest{Name: &name, Tags: make([]*string, 0, len(tags))}
+
+		for i := range tags {
+			result.Tags = append(result.Tags, &tags[i])
+		}
+
+		imgResultForDigest = append(imgResultForDigest, result)
+	}
+
+	sort.Slice(imgResultForDigest, func(i, j int) bool {
+		return *imgResultForDigest[i].Name < *imgResultForDigest[j].Name
+	})
+
+	return imgResultForDigest, nil
+}
 
-	partialImgResultForDigest, err := r.getImageListForDigest(repoList, id)
+func (r *queryResolver) ImageListForAnnotation(ctx context.Context, key string,
+	value *string) ([]*ImgResultForAnnotation, error) {
+	imgResultForAnnotation := []*ImgResultForAnnotation{}
+
+	r.annotationInfo.Log.Info().Msg("extracting repositories")
+
+	repoList, err := r.storeController.DefaultStore.GetRepositories()
 	if err != nil {
-		r.cveInfo.Log.Error().Err(err).Msg("unable to get image and tag list for global repositories")
+		r.annotationInfo.Log.Error().Err(err).Msg("unable to search repositories")
 
-		return imgResultForDigest, err
+		return imgResultForAnnotation, err
 	}
 
-	imgResultForDigest = append(imgResultForDigest, partialImgResultForDigest...)
+	partialImgResultForAnnotation, err := r.getImageListForAnnotation(ctx, repoList, key, value)
+	if err != nil {
+		r.annotationInfo.Log.Error().Err(err).Msg("unable to get image and tag list for global repositories")
 
-	subStore := r.storeController.SubStore
-	for _, store := range subStore {
+		return imgResultForAnnotation, err
+	}
+
+	imgResultForAnnotation = append(imgResultForAnnotation, partialImgResultForAnnotation...)
+
+	for _, store := range r.storeController.SubStore {
 		subRepoList, err := store.GetRepositories()
 		if err != nil {
-			r.cveInfo.Log.Error().Err(err).Msg("unable to search sub-repositories")
+			r.annotationInfo.Log.Error().Err(err).Msg("unable to search sub-repositories")
 
-			return imgResultForDigest, err
+			return imgResultForAnnotation, err
 		}
 
-		partialImgResultForDigest, err = r.getImageListForDigest(subRepoList, id)
+		partialImgResultForAnnotation, err = r.getImageListForAnnotation(ctx, subRepoList, key, value)
 		if err != nil {
-			r.cveInfo.Log.Error().Err(err).Msg("unable to get image and tag list for sub-repositories")
+			r.annotationInfo.Log.Error().Err(err).Msg("unable to get image and tag list for sub-repositories")
 
-			return imgResultForDigest, err
+			return imgResultForAnnotation, err
 		}
 
-		imgResultForDigest = append(imgResultForDigest, partialImgResultForDigest...)
+		imgResultForAnnotation = append(imgResultForAnnotation, partialImgResultForAnnotation...)
 	}
 
-	return imgResultForDigest, nil
+	return imgResultForAnnotation, nil
 }
 
-func (r *queryResolver) getImageListForDigest(repoList []string, digest string) ([]*ImgResultForDigest, error) {
-	imgResultForDigest := []*ImgResultForDigest{}
+func (r *queryResolver) getImageListForAnnotation(ctx context.Context, repoList []string, key string,
+	value *string) ([]*ImgResultForAnnotation, error) {
+	imgResultForAnnotation := []*ImgResultForAnnotation{}
 
 	var errResult error
 
 	for _, repo := range repoList {
-		r.digestInfo.Log.Info().Str("repo", repo).Msg("filtering list of tags in image repo by digest")
+		if !tenant.IsAllowed(ctx, repo) {
+			continue
+		}
 
-		tags, err := r.digestInfo.GetImageTagsByDigest(repo, digest)
+		r.annotationInfo.Log.Info().Str("repo", repo).Msg("filtering list of tags in image repo by annotation")
+
+		tags, err := r.annotationInfo.GetImageTagsByAnnotation(repo, key, value)
 		if err != nil {
-			r.digestInfo.Log.Error().Err(err).Msg("unable to get filtered list of image tags")
+			r.annotationInfo.Log.Error().Err(err).Msg("unable to get filtered list of image tags")
 			errResult = err
 
 			continue
@@ -357,11 +502,732 @@ func (r *queryResolver) getImageListForDigest(repoList []string, digest string)
 		if len(tags) != 0 {
 			name := repo
 
-			imgResultForDigest = append(imgResultForDigest, &ImgResultForDigest{Name: &name, Tags: tags})
+			imgResultForAnnotation = append(imgResultForAnnotation, &ImgResultForAnnotation{Name: &name, Tags: tags})
+		}
+	}
+
+	return imgResultForAnnotation, errResult
+}
+
+func (r *queryResolver) ImageListForGitVersion(ctx context.Context, version string) ([]*ImgResultForGitVersion, error) {
+	imgResultForGitVersion := []*ImgResultForGitVersion{}
+
+	images, err := r.annotationInfo.GetImagesByGitVersion(version)
+	if err != nil {
+		r.annotationInfo.Log.Error().Err(err).Str("gitVersion", version).Msg("unable to get image and tag list")
+
+		return imgResultForGitVersion, err
+	}
+
+	for repo, tags := range images {
+		if !tenant.IsAllowed(ctx, repo) {
+			continue
+		}
+
+		name := repo
+		result := &ImgResultForGitVersion{Name: &name, Tags: make([]*string, 0, len(tags))}
+
+		for i := range tags {
+			result.Tags = append(result.Tags, &tags[i])
+		}
+
+		imgResultForGitVersion = append(imgResultForGitVersion, result)
+	}
+
+	sort.Slice(imgResultForGitVersion, func(i, j int) bool {
+		return *imgResultForGitVersion[i].Name < *imgResultForGitVersion[j].Name
+	})
+
+	return imgResultForGitVersion, nil
+}
+
+// ImageList returns the tagged images of a repository, or of all repositories of all image stores,
+// they are served from the catalog kept by each image store instead of being read from disk.
+func (r *queryResolver) ImageList(ctx context.Context, repo *string) ([]*ImageInfo, error) {
+	results := []*ImageInfo{}
+
+	if repo != nil && *repo != "" {
+		if !tenant.IsAllowed(ctx, *repo) {
+			return results, errors.ErrRepoNotFound
+		}
+
+		images, err := r.storeController.GetImageStore(*repo).CatalogImages(*repo)
+		if err != nil {
+			r.cveInfo.Log.Error().Err(err).Str("repo", *repo).Msg("unable to list images")
+
+			return results, err
+		}
+
+		return getGraphqlCompatibleImages(images), nil
+	}
+
+	stores := []*storage.ImageStore{r.storeController.DefaultStore}
+	for _, store := range r.storeController.SubStore {
+		stores = append(stores, store)
+	}
+
+	for _, store := range stores {
+		images, err := store.CatalogImages("")
+		if err != nil {
+			r.cveInfo.Log.Error().Err(err).Msg("unable to list images")
+
+			return results, err
+		}
+
+		results = append(results, getGraphqlCompatibleImages(allowedImages(ctx, images))...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return *results[i].RepoName < *results[j].RepoName
+	})
+
+	return results, nil
+}
+
+// allowedImages returns the images of the repositories the user of a request may access.
+func allowedImages(ctx context.Context, images []storage.CatalogImage) []storage.CatalogImage {
+	allowed := make([]storage.CatalogImage, 0, len(images))
+
+	for _, image := range images {
+		if tenant.IsAllowed(ctx, image.Repo) {
+			allowed = append(allowed, image)
+		}
+	}
+
+	return allowed
+}
+
+func getGraphqlCompatibleImages(images []storage.CatalogImage) []*ImageInfo {
+	results := make([]*ImageInfo, 0, len(images))
+
+	for _, image := range images {
+		image := image
+		digest := image.Digest.String()
+		configDigest := image.ConfigDigest.String()
+		size := int(image.Size)
+
+		info := &ImageInfo{RepoName: &image.Repo, Tag: &image.Tag, Digest: &digest,
+			ConfigDigest: &configDigest, Size: &size, LastUpdated: &image.LastUpdated, IsSigned: &image.Signed}
+
+		// images which were never scanned have no scan results
+		if image.Scan != nil {
+			cveCount := len(image.Scan.CVEs)
+
+			info.LastScanned = &image.Scan.Scanned
+			info.CVECount = &cveCount
+			info.CVESummary = getGraphqlCompatibleCVESummary([]*storage.ScanSummary{image.Scan})
+		}
+
+		if image.Build != nil {
+			info.BuildInfo = &BuildInfo{StackerYaml: &image.Build.StackerYaml, GitVersion: &image.Build.GitVersion}
+		}
+
+		// images whose provenance was never verified have no verification result
+		if p := image.Provenance; p != nil {
+			attestation := p.Attestation.String()
+
+			info.Provenance = &ProvenanceVerification{Valid: &p.Valid, Attestation: &attestation,
+				PredicateType: &p.PredicateType, Builder: &p.Builder, Reason: &p.Reason, Checked: &p.Checked}
+		}
+
+		// so do images pushed before lint was enabled
+		if l := image.Lint; l != nil {
+			violations := make([]*string, 0, len(l.Violations))
+
+			for i := range l.Violations {
+				violations = append(violations, &l.Violations[i])
+			}
+
+			info.Lint = &LintResult{Passed: &l.Passed, Violations: violations, Checked: &l.Checked}
+		}
+
+		results = append(results, info)
+	}
+
+	return results
+}
+
+// getGraphqlCompatibleCVESummary counts the distinct CVEs found by the scans of one or more images by severity,
+// a CVE found by several scans counts once, with its highest severity. It's nil if none was scanned.
+func getGraphqlCompatibleCVESummary(scans []*storage.ScanSummary) *CVESummary {
+	cveSeverities := map[string]int{}
+	scanned := false
+
+	for _, scan := range scans {
+		if scan == nil {
+			continue
+		}
+
+		scanned = true
+
+		for _, id := range scan.CVEs {
+			// the severities of the CVEs found by scans recorded by older versions are unknown
+			rank := cveinfo.SeverityRank(scan.CVESeverities[id])
+			if rank < 0 {
+				rank = 0
+			}
+
+			if previous, ok := cveSeverities[id]; !ok || rank > previous {
+				cveSeverities[id] = rank
+			}
+		}
+	}
+
+	if !scanned {
+		return nil
+	}
+
+	counts := make([]int, len(cveinfo.Severities))
+
+	for _, rank := range cveSeverities {
+		counts[rank]++
+	}
+
+	count := len(cveSeverities)
+	maxSeverity := "NONE"
+
+	for rank := len(cveinfo.Severities) - 1; rank >= 0; rank-- {
+		if counts[rank] > 0 {
+			maxSeverity = cveinfo.Severities[rank]
+
+			break
+		}
+	}
+
+	return &CVESummary{Count: &count, MaxSeverity: &maxSeverity, Unknown: &counts[0], Low: &counts[1],
+		Medium: &counts[2], High: &counts[3], Critical: &counts[4]}
+}
+
+// ImageListByPopularity returns the repositories of all image stores sorted by their pull count,
+// the most pulled first, repositories which were never pulled are listed last.
+func (r *queryResolver) ImageListByPopularity(ctx context.Context, limit *int) ([]*RepoPullStats, error) {
+	stores := []*storage.ImageStore{r.storeController.DefaultStore}
+	for _, store := range r.storeController.SubStore {
+		stores = append(stores, store)
+	}
+
+	results := []*RepoPullStats{}
+
+	for _, store := range stores {
+		repoList, err := store.GetRepositories()
+		if err != nil {
+			r.cveInfo.Log.Error().Err(err).Msg("unable to search repositories")
+
+			return results, err
+		}
+
+		pullStats := store.PullStats().Repos()
+
+		for _, repo := range repoList {
+			if tenant.IsAllowed(ctx, repo) {
+				results = append(results, getGraphqlCompatiblePullStats(repo, pullStats[repo]))
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if *results[i].Count != *results[j].Count {
+			return *results[i].Count > *results[j].Count
+		}
+
+		return *results[i].Name < *results[j].Name
+	})
+
+	if limit != nil && *limit >= 0 && *limit < len(results) {
+		results = results[:*limit]
+	}
+
+	return results, nil
+}
+
+func getGraphqlCompatiblePullStats(repo string, stats storage.RepoPullStats) *RepoPullStats {
+	name := repo
+	count := int(stats.Count)
+
+	result := &RepoPullStats{Name: &name, Count: &count, Tags: make([]*TagPullStats, 0, len(stats.Tags))}
+
+	if !stats.LastPull.IsZero() {
+		lastPull := stats.LastPull
+		result.LastPull = &lastPull
+	}
+
+	for tag, tagStats := range stats.Tags {
+		tagName := tag
+		tagCount := int(tagStats.Count)
+		tagLastPull := tagStats.LastPull
+
+		result.Tags = append(result.Tags, &TagPullStats{Name: &tagName, Count: &tagCount, LastPull: &tagLastPull})
+	}
+
+	sort.Slice(result.Tags, func(i, j int) bool {
+		if *result.Tags[i].Count != *result.Tags[j].Count {
+			return *result.Tags[i].Count > *result.Tags[j].Count
+		}
+
+		return *result.Tags[i].Name < *result.Tags[j].Name
+	})
+
+	return result
+}
+
+// LayerListBySharing returns the layers referenced by the most images, and the space dedupe saves on each of
+// them, the top 10 unless a limit is given. Layers are counted in each image store, the top ones of the stores
+// being merged.
+func (r *queryResolver) LayerListBySharing(ctx context.Context, limit *int) ([]*SharedLayer, error) {
+	stores := []*storage.ImageStore{r.storeController.DefaultStore}
+	for _, store := range r.storeController.SubStore {
+		stores = append(stores, store)
+	}
+
+	top := storage.DefaultDedupeReportTop
+	if limit != nil {
+		top = *limit
+	}
+
+	merged := make(map[godigest.Digest]*storage.SharedLayer)
+
+	for _, store := range stores {
+		layers, err := store.SharedLayers(top)
+		if err != nil {
+			r.cveInfo.Log.Error().Err(err).Msg("unable to count shared layers")
+
+			return []*SharedLayer{}, err
+		}
+
+		for _, layer := range layers {
+			shared, ok := merged[layer.Digest]
+			if !ok {
+				layer := layer
+				merged[layer.Digest] = &layer
+
+				continue
+			}
+
+			shared.Images += layer.Images
+			shared.Repos = append(shared.Repos, layer.Repos...)
+			shared.Copies += layer.Copies
+			shared.SavedBytes += layer.SavedBytes
+		}
+	}
+
+	results := make([]*SharedLayer, 0, len(merged))
+
+	for _, layer := range merged {
+		// the layers only shared by repositories the user may not access aren't listed
+		repos := make([]string, 0, len(layer.Repos))
+
+		for _, repo := range layer.Repos {
+			if tenant.IsAllowed(ctx, repo) {
+				repos = append(repos, repo)
+			}
+		}
+
+		if len(repos) == 0 {
+			continue
+		}
+
+		layer.Repos = repos
+		results = append(results, getGraphqlCompatibleSharedLayer(layer))
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if *results[i].ImageCount != *results[j].ImageCount {
+			return *results[i].ImageCount > *results[j].ImageCount
+		}
+
+		if *results[i].Size != *results[j].Size {
+			return *results[i].Size > *results[j].Size
+		}
+
+		return *results[i].Digest < *results[j].Digest
+	})
+
+	if top >= 0 && top < len(results) {
+		results = results[:top]
+	}
+
+	return results, nil
+}
+
+func getGraphqlCompatibleSharedLayer(layer *storage.SharedLayer) *SharedLayer {
+	digest := layer.Digest.String()
+	size := int(layer.Size)
+	savedBytes := int(layer.SavedBytes)
+
+	result := &SharedLayer{Digest: &digest, Size: &size, ImageCount: &layer.Images, Copies: &layer.Copies,
+		SavedBytes: &savedBytes, Repos: make([]*string, 0, len(layer.Repos))}
+
+	sort.Strings(layer.Repos)
+
+	for i := range layer.Repos {
+		result.Repos = append(result.Repos, &layer.Repos[i])
+	}
+
+	return result
+}
+
+// ImageSummaryForRepo returns the tags of a repository, and whether the user starred or bookmarked it.
+func (r *queryResolver) ImageSummaryForRepo(ctx context.Context, repo string) (*ImageSummary, error) {
+	return r.getImageSummary(ctx, repo)
+}
+
+// StarredRepos returns the repositories starred by the user, anonymous users have none.
+func (r *queryResolver) StarredRepos(ctx context.Context) ([]*ImageSummary, error) {
+	if r.userPrefs == nil {
+		return []*ImageSummary{}, nil
+	}
+
+	return r.getImageSummaries(ctx, r.userPrefs.StarredRepos)
+}
+
+// BookmarkedRepos returns the repositories bookmarked by the user, anonymous users have none.
+func (r *queryResolver) BookmarkedRepos(ctx context.Context) ([]*ImageSummary, error) {
+	if r.userPrefs == nil {
+		return []*ImageSummary{}, nil
+	}
+
+	return r.getImageSummaries(ctx, r.userPrefs.BookmarkedRepos)
+}
+
+func (r *queryResolver) getImageSummaries(ctx context.Context,
+	listRepos func(user string) ([]string, error)) ([]*ImageSummary, error) {
+	summaries := []*ImageSummary{}
+
+	repos, err := listRepos(log.GetUsername(ctx))
+	if err != nil {
+		r.cveInfo.Log.Error().Err(err).Msg("unable to read user preferences")
+
+		return summaries, err
+	}
+
+	for _, repo := range repos {
+		summary, err := r.getImageSummary(ctx, repo)
+		if err != nil {
+			// repositories deleted since they were starred or bookmarked
+			if goerrors.Is(err, errors.ErrRepoNotFound) {
+				continue
+			}
+
+			return summaries, err
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
+
+func (r *queryResolver) getImageSummary(ctx context.Context, repo string) (*ImageSummary, error) {
+	if !tenant.IsAllowed(ctx, repo) {
+		return nil, errors.ErrRepoNotFound
+	}
+
+	tags, err := r.storeController.GetImageStore(repo).GetImageTags(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	deprecations, err := r.storeController.GetImageStore(repo).GetDeprecations(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := r.storeController.GetImageStore(repo).CatalogImages(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	scans := make([]*storage.ScanSummary, 0, len(images))
+	for _, image := range images {
+		scans = append(scans, image.Scan)
+	}
+
+	name := repo
+	summary := &ImageSummary{RepoName: &name, Tags: make([]*string, 0, len(tags)),
+		Deprecations: getGraphqlCompatibleDeprecations(deprecations), CVESummary: getGraphqlCompatibleCVESummary(scans)}
+
+	for i := range tags {
+		summary.Tags = append(summary.Tags, &tags[i])
+	}
+
+	if r.userPrefs == nil {
+		return summary, nil
+	}
+
+	user := log.GetUsername(ctx)
+
+	starred, err := r.userPrefs.IsStarred(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	bookmarked, err := r.userPrefs.IsBookmarked(user, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	summary.IsStarred = &starred
+	summary.IsBookmarked = &bookmarked
+
+	return summary, nil
+}
+
+// getGraphqlCompatibleDeprecations lists the deprecation of the whole repository first, with an empty tag.
+func getGraphqlCompatibleDeprecations(deprecations map[string]storage.Deprecation) []*Deprecation {
+	result := make([]*Deprecation, 0, len(deprecations))
+
+	for tag, deprecation := range deprecations {
+		tag, deprecation := tag, deprecation
+
+		result = append(result, &Deprecation{Tag: &tag, Message: &deprecation.Message,
+			Replacement: &deprecation.Replacement})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return *result[i].Tag < *result[j].Tag
+	})
+
+	return result
+}
+
+// SyncStatus lists the replication status of the downstream registries, none if sync is disabled.
+func (r *queryResolver) SyncStatus(ctx context.Context) ([]*SyncStatus, error) {
+	results := []*SyncStatus{}
+
+	if r.replicator == nil {
+		return results, nil
+	}
+
+	for _, status := range r.replicator.Status() {
+		results = append(results, getGraphqlCompatibleSyncStatus(ctx, status))
+	}
+
+	return results, nil
+}
+
+// getGraphqlCompatibleSyncStatus only lists the failures and quarantined images of the repositories
+// the user of a request may access.
+func getGraphqlCompatibleSyncStatus(ctx context.Context, status sync.Status) *SyncStatus {
+	imagesSynced := int(status.ImagesSynced)
+	bytesTransferred := int(status.BytesTransferred)
+	failureCount := int(status.FailureCount)
+
+	result := &SyncStatus{
+		Registry:         &status.Registry,
+		LastSync:         status.LastSync,
+		ImagesSynced:     &imagesSynced,
+		BytesTransferred: &bytesTransferred,
+		Queued:           &status.Queued,
+		FailureCount:     &failureCount,
+		Failures:         make([]*SyncFailure, 0, len(status.Failures)),
+		Quarantined:      make([]*SyncQuarantinedImage, 0, len(status.Quarantined)),
+	}
+
+	for _, failure := range status.Failures {
+		failure := failure
+
+		if !tenant.IsAllowed(ctx, failure.Repo) {
+			continue
+		}
+
+		result.Failures = append(result.Failures, &SyncFailure{Repo: &failure.Repo, Reference: &failure.Reference,
+			Time: &failure.Time, Reason: &failure.Reason, Conflict: &failure.Conflict})
+	}
+
+	for _, image := range status.Quarantined {
+		image := image
+
+		if !tenant.IsAllowed(ctx, image.Repo) {
+			continue
+		}
+
+		result.Quarantined = append(result.Quarantined, &SyncQuarantinedImage{Repo: &image.Repo,
+			Reference: &image.Reference, Time: &image.Time, Severity: &image.Severity})
+	}
+
+	return result
+}
+
+// defaultCVEReportTop is the number of repositories with the most severe CVEs listed by default in CVE reports.
+const defaultCVEReportTop = 10
+
+// CVEReport counts the distinct CVEs found by the last scans of the tagged images of all repositories under
+// a prefix, or of all repositories, by severity, and lists the repositories with the most severe CVEs, the
+// top 10 unless a limit is given. Images are not scanned, those which were never scanned are left out.
+func (r *queryResolver) CVEReport(ctx context.Context, prefix *string, limit *int) (*CVEReport, error) {
+	stores := []*storage.ImageStore{r.storeController.DefaultStore}
+	for _, store := range r.storeController.SubStore {
+		stores = append(stores, store)
+	}
+
+	under := ""
+	if prefix != nil {
+		under = strings.Trim(*prefix, "/")
+	}
+
+	top := defaultCVEReportTop
+	if limit != nil {
+		top = *limit
+	}
+
+	repoCount, scannedRepoCount := 0, 0
+	allScans := []*storage.ScanSummary{}
+	worst := []*RepoCVESummary{}
+
+	for _, store := range stores {
+		repoList, err := store.GetRepositories()
+		if err != nil {
+			r.cveInfo.Log.Error().Err(err).Msg("unable to search repositories")
+
+			return nil, err
+		}
+
+		images, err := store.CatalogImages("")
+		if err != nil {
+			r.cveInfo.Log.Error().Err(err).Msg("unable to list images")
+
+			return nil, err
+		}
+
+		repoScans := map[string][]*storage.ScanSummary{}
+
+		for _, image := range images {
+			if image.Scan != nil {
+				repoScans[image.Repo] = append(repoScans[image.Repo], image.Scan)
+			}
+		}
+
+		for _, repo := range repoList {
+			if under != "" && repo != under && !strings.HasPrefix(repo, under+"/") {
+				continue
+			}
+
+			if !tenant.IsAllowed(ctx, repo) {
+				continue
+			}
+
+			repoCount++
+
+			scans, ok := repoScans[repo]
+			if !ok {
+				continue
+			}
+
+			scannedRepoCount++
+			allScans = append(allScans, scans...)
+
+			if summary := getGraphqlCompatibleCVESummary(scans); *summary.Count > 0 {
+				name := repo
+				worst = append(worst, &RepoCVESummary{RepoName: &name, CVESummary: summary})
+			}
+		}
+	}
+
+	sort.SliceStable(worst, func(i, j int) bool {
+		if c := compareCVESummaries(worst[i].CVESummary, worst[j].CVESummary); c != 0 {
+			return c > 0
+		}
+
+		return *worst[i].RepoName < *worst[j].RepoName
+	})
+
+	if top >= 0 && top < len(worst) {
+		worst = worst[:top]
+	}
+
+	return &CVEReport{RepoCount: &repoCount, ScannedRepoCount: &scannedRepoCount,
+		CVESummary: getGraphqlCompatibleCVESummary(allScans), WorstRepos: worst}, nil
+}
+
+// compareCVESummaries orders CVE summaries by their count of critical CVEs, then of high ones, and so on.
+func compareCVESummaries(a, b *CVESummary) int {
+	for _, counts := range [][2]*int{{a.Critical, b.Critical}, {a.High, b.High}, {a.Medium, b.Medium},
+		{a.Low, b.Low}, {a.Unknown, b.Unknown}} {
+		if *counts[0] != *counts[1] {
+			return *counts[0] - *counts[1]
+		}
+	}
+
+	return 0
+}
+
+// TagHistory returns the changes of the manifest a tag points to, the oldest first. The digest is null once
+// the tag is deleted, and the previous digest is null when it's created.
+func (r *queryResolver) TagHistory(ctx context.Context, repo string, tag string) ([]*TagEvent, error) {
+	if !tenant.IsAllowed(ctx, repo) {
+		return []*TagEvent{}, errors.ErrRepoNotFound
+	}
+
+	events, err := r.storeController.GetImageStore(repo).TagHistory(repo, tag)
+	if err != nil {
+		r.cveInfo.Log.Error().Err(err).Str("repo", repo).Str("tag", tag).Msg("unable to read tag history")
+
+		return []*TagEvent{}, err
+	}
+
+	results := make([]*TagEvent, 0, len(events))
+
+	for _, event := range events {
+		event := event
+		result := &TagEvent{Time: &event.Time}
+
+		if event.Digest != "" {
+			digest := event.Digest.String()
+			result.Digest = &digest
+		}
+
+		if event.Previous != "" {
+			previous := event.Previous.String()
+			result.PreviousDigest = &previous
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// ImageLatestTag returns the image of the newest tag of a repository which is a semantic version in the range,
+// e.g. "^1.2", or of any version if there's no range. It's null if no tag is.
+func (r *queryResolver) ImageLatestTag(ctx context.Context, repo string, constraint *string) (*ImageInfo, error) {
+	versionRange := ""
+	if constraint != nil {
+		versionRange = *constraint
+	}
+
+	parsed, err := semver.ParseConstraint(versionRange)
+	if err != nil {
+		return nil, err
+	}
+
+	if !tenant.IsAllowed(ctx, repo) {
+		return nil, errors.ErrRepoNotFound
+	}
+
+	images, err := r.storeController.GetImageStore(repo).CatalogImages(repo)
+	if err != nil {
+		r.cveInfo.Log.Error().Err(err).Str("repo", repo).Msg("unable to list images")
+
+		return nil, err
+	}
+
+	tags := make([]string, 0, len(images))
+	for _, image := range images {
+		tags = append(tags, image.Tag)
+	}
+
+	latest, ok := semver.Latest(tags, parsed)
+	if !ok {
+		return nil, nil
+	}
+
+	for i, image := range images {
+		if image.Tag == latest {
+			return getGraphqlCompatibleImages(images[i : i+1])[0], nil
 		}
 	}
 
-	return imgResultForDigest, errResult
+	return nil, nil
 }
 
 func getGraphqlCompatibleTags(fixedTags []cveinfo.TagInfo) []*TagInfo {
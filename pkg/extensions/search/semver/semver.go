@@ -0,0 +1,381 @@
+// Package semver orders the tags which look like semantic versions, e.g. "1.2.3" or "v1.2", and picks the
+// newest of them matching a version range, e.g. "^1.2".
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/anuvu/zot/errors"
+)
+
+const (
+	operatorChars = "=!<>^~"
+	// identifierChars are the characters of prerelease identifiers
+	identifierChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-"
+)
+
+// Version is a semantic version, without build metadata which tags can't have.
+type Version struct {
+	Major, Minor, Patch uint64
+	// Prerelease are the dot separated identifiers following the patch version, e.g. "rc" and "1" for "1.2.3-rc.1"
+	Prerelease []string
+}
+
+// Parse returns the version of a tag which looks like a semantic version, e.g. "1.2.3", "v1.2" or "1.2.3-rc.1",
+// missing minor and patch versions being 0. Tags of partial versions can't be prereleases, e.g. "1.2-alpine".
+func Parse(tag string) (Version, bool) {
+	version, parts, ok := parse(tag, false)
+
+	return version, ok && parts > 0
+}
+
+// parse parses a possibly partial version, e.g. "1.2", returning how many of its major, minor and patch
+// versions are given. Those may be wildcards in constraints, e.g. "1.x" or "*".
+func parse(text string, wildcards bool) (Version, int, bool) {
+	var version Version
+
+	core := strings.TrimPrefix(text, "v")
+
+	if i := strings.IndexByte(core, '-'); i >= 0 {
+		version.Prerelease = strings.Split(core[i+1:], ".")
+		core = core[:i]
+
+		for _, identifier := range version.Prerelease {
+			if identifier == "" || strings.Trim(identifier, identifierChars) != "" {
+				return version, 0, false
+			}
+		}
+	}
+
+	fields := strings.Split(core, ".")
+	if len(fields) > 3 { // nolint: gomnd
+		return version, 0, false
+	}
+
+	numbers := []*uint64{&version.Major, &version.Minor, &version.Patch}
+	parts := 0
+
+	for i, field := range fields {
+		if wildcards && isWildcard(field) {
+			// only wildcards follow wildcards, e.g. "1.x.x" but not "1.x.3"
+			for _, next := range fields[i+1:] {
+				if !isWildcard(next) {
+					return version, 0, false
+				}
+			}
+
+			break
+		}
+
+		number, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return version, 0, false
+		}
+
+		*numbers[i] = number
+		parts++
+	}
+
+	if version.Prerelease != nil && parts < 3 {
+		return version, 0, false
+	}
+
+	return version, parts, true
+}
+
+func isWildcard(field string) bool {
+	return field == "x" || field == "X" || field == "*"
+}
+
+// Compare returns -1, 0 or 1 when a precedes, equals or follows b, prereleases preceding their version.
+func Compare(a, b Version) int {
+	for _, numbers := range [][2]uint64{{a.Major, b.Major}, {a.Minor, b.Minor}, {a.Patch, b.Patch}} {
+		if numbers[0] != numbers[1] {
+			return compareUints(numbers[0], numbers[1])
+		}
+	}
+
+	switch {
+	case len(a.Prerelease) == 0 && len(b.Prerelease) == 0:
+		return 0
+	case len(a.Prerelease) == 0:
+		return 1
+	case len(b.Prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(a.Prerelease) && i < len(b.Prerelease); i++ {
+		if c := compareIdentifiers(a.Prerelease[i], b.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+
+	return compareUints(uint64(len(a.Prerelease)), uint64(len(b.Prerelease)))
+}
+
+// compareIdentifiers compares prerelease identifiers, numerically if both are numbers, which precede the
+// other identifiers.
+func compareIdentifiers(a, b string) int {
+	numberA, errA := strconv.ParseUint(a, 10, 64)
+	numberB, errB := strconv.ParseUint(b, 10, 64)
+
+	switch {
+	case errA == nil && errB == nil:
+		return compareUints(numberA, numberB)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// bump returns the version following all the versions starting with the given parts of a version,
+// e.g. 1.3.0 for the 2 parts of 1.2.3.
+func bump(version Version, parts int) Version {
+	switch parts {
+	case 1:
+		return Version{Major: version.Major + 1}
+	case 2: // nolint: gomnd
+		return Version{Major: version.Major, Minor: version.Minor + 1}
+	default:
+		return Version{Major: version.Major, Minor: version.Minor, Patch: version.Patch + 1}
+	}
+}
+
+// comparison is a comparison of versions with a bound, e.g. ">= 1.2.0".
+type comparison struct {
+	op    string
+	bound Version
+}
+
+func (c comparison) check(version Version) bool {
+	order := Compare(version, c.bound)
+
+	switch c.op {
+	case ">=":
+		return order >= 0
+	case ">":
+		return order > 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case "!=":
+		return order != 0
+	default:
+		return order == 0
+	}
+}
+
+// Constraint is a range of versions, in the syntax of npm and of most semver libraries:
+//   - "1.2.3" or "=1.2.3" is that version, "!=1.2.3" any other
+//   - "1.2", "1.2.x" or "1.2.*" are the 1.2 patch versions, "*" or "" any version
+//   - ">1.2.3", ">=1.2.3", "<1.2.3" and "<=1.2.3" compare with a version, e.g. ">1.2" is ">=1.3.0"
+//   - "~1.2.3" are the patch versions from 1.2.3, "~1" the minor versions of 1
+//   - "^1.2.3" are the versions from 1.2.3 up to 2.0.0, "^0.2.3" up to 0.3.0 and "^0.0.3" just 0.0.3
+//
+// Comparators separated by spaces or commas must all match, e.g. ">=1.2 <1.5", and "||" separates
+// alternatives, e.g. "^1.2 || ^2". Prereleases only match comparators with a prerelease of the same
+// version, e.g. "1.2.3-rc.2" matches ">=1.2.3-rc.1" but not "^1.2".
+type Constraint struct {
+	// sets are the alternatives, a version matches a set when it satisfies all its comparisons
+	sets [][]comparison
+}
+
+// ParseConstraint parses a version range, e.g. "^1.2" or ">=1.2 <1.5".
+func ParseConstraint(constraint string) (Constraint, error) {
+	var result Constraint
+
+	for _, alternative := range strings.Split(constraint, "||") {
+		set := []comparison{}
+
+		tokens := strings.FieldsFunc(alternative, func(r rune) bool {
+			return unicode.IsSpace(r) || r == ','
+		})
+
+		for i := 0; i < len(tokens); i++ {
+			token := tokens[i]
+
+			// operators may be separated from their version, e.g. ">= 1.2"
+			if strings.Trim(token, operatorChars) == "" && i+1 < len(tokens) {
+				i++
+				token += tokens[i]
+			}
+
+			comparisons, err := parseComparator(token)
+			if err != nil {
+				return result, err
+			}
+
+			set = append(set, comparisons...)
+		}
+
+		result.sets = append(result.sets, set)
+	}
+
+	return result, nil
+}
+
+// parseComparator returns the comparisons of one comparator, e.g. ">= 1.2.0" and "< 2.0.0" for "^1.2".
+func parseComparator(comparator string) ([]comparison, error) {
+	invalid := fmt.Errorf("%w: %q", errors.ErrBadSemverConstraint, comparator)
+
+	op := comparator[:len(comparator)-len(strings.TrimLeft(comparator, operatorChars))]
+
+	version, parts, ok := parse(comparator[len(op):], true)
+	if !ok {
+		return nil, invalid
+	}
+
+	// partial versions are the range of versions starting with them, e.g. 1.2.0 up to 1.3.0 for "1.2"
+	lower := comparison{">=", version}
+	upper := comparison{"<", bump(version, parts)}
+
+	switch op {
+	case "", "=", "==":
+		switch parts {
+		case 0:
+			return nil, nil
+		case 3: // nolint: gomnd
+			return []comparison{{"=", version}}, nil
+		default:
+			return []comparison{lower, upper}, nil
+		}
+	case "!=":
+		if parts < 3 { // nolint: gomnd
+			return nil, invalid
+		}
+
+		return []comparison{{"!=", version}}, nil
+	case ">=":
+		if parts == 0 {
+			return nil, nil
+		}
+
+		return []comparison{lower}, nil
+	case ">":
+		switch parts {
+		case 0:
+			return nil, invalid
+		case 3: // nolint: gomnd
+			return []comparison{{">", version}}, nil
+		default:
+			return []comparison{{">=", upper.bound}}, nil
+		}
+	case "<":
+		if parts == 0 {
+			return nil, invalid
+		}
+
+		return []comparison{{"<", version}}, nil
+	case "<=":
+		switch parts {
+		case 0:
+			return nil, nil
+		case 3: // nolint: gomnd
+			return []comparison{{"<=", version}}, nil
+		default:
+			return []comparison{upper}, nil
+		}
+	case "~":
+		if parts == 0 {
+			return nil, nil
+		}
+
+		if parts > 2 { // nolint: gomnd
+			upper.bound = bump(version, 2) // nolint: gomnd
+		}
+
+		return []comparison{lower, upper}, nil
+	case "^":
+		if parts == 0 {
+			return nil, nil
+		}
+
+		// the versions up to the next change of the first non zero part given
+		switch {
+		case version.Major > 0 || parts == 1:
+			upper.bound = bump(version, 1)
+		case version.Minor > 0 || parts == 2: // nolint: gomnd
+			upper.bound = bump(version, 2) // nolint: gomnd
+		default:
+			upper.bound = bump(version, 3) // nolint: gomnd
+		}
+
+		return []comparison{lower, upper}, nil
+	default:
+		return nil, invalid
+	}
+}
+
+// Check returns whether a version is in the range.
+func (c Constraint) Check(version Version) bool {
+	for _, set := range c.sets {
+		if matches(set, version) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matches(set []comparison, version Version) bool {
+	for _, c := range set {
+		if !c.check(version) {
+			return false
+		}
+	}
+
+	if len(version.Prerelease) == 0 {
+		return true
+	}
+
+	for _, c := range set {
+		if len(c.bound.Prerelease) > 0 && c.bound.Major == version.Major && c.bound.Minor == version.Minor &&
+			c.bound.Patch == version.Patch {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Latest returns the newest of the tags which are versions in the range, false if there's none. Tags of the
+// same version, e.g. "1.2" and "v1.2.0", are ordered by name.
+func Latest(tags []string, constraint Constraint) (string, bool) {
+	var latest string
+
+	var latestVersion Version
+
+	found := false
+
+	for _, tag := range tags {
+		version, ok := Parse(tag)
+		if !ok || !constraint.Check(version) {
+			continue
+		}
+
+		order := Compare(version, latestVersion)
+		if !found || order > 0 || (order == 0 && tag > latest) {
+			latest, latestVersion, found = tag, version, true
+		}
+	}
+
+	return latest, found
+}
@@ -0,0 +1,133 @@
+package semver_test
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	zotErrors "github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/extensions/search/semver"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParse(t *testing.T) {
+	Convey("Tags which look like semantic versions are parsed", t, func() {
+		version, ok := semver.Parse("1.2.3")
+		So(ok, ShouldBeTrue)
+		So(version, ShouldResemble, semver.Version{Major: 1, Minor: 2, Patch: 3})
+
+		version, ok = semver.Parse("v1.2")
+		So(ok, ShouldBeTrue)
+		So(version, ShouldResemble, semver.Version{Major: 1, Minor: 2})
+
+		version, ok = semver.Parse("2.0.0-rc.1")
+		So(ok, ShouldBeTrue)
+		So(version.Prerelease, ShouldResemble, []string{"rc", "1"})
+
+		for _, tag := range []string{"latest", "", "v", "1.2.3.4", "1.x", "1.2-alpine", "1.2.3-", "1.2.3-rc..1",
+			"1.2.3-rc_1", "-1.2"} {
+			_, ok = semver.Parse(tag)
+			So(ok, ShouldBeFalse)
+		}
+	})
+
+	Convey("Versions are ordered by precedence", t, func() {
+		tags := []string{"1.10.0", "1.2.0", "1.2.0-rc.10", "1.2.0-rc.2", "1.2.0-beta", "1.2.0-rc", "0.9", "1.2.0-1"}
+
+		sort.Slice(tags, func(i, j int) bool {
+			a, _ := semver.Parse(tags[i])
+			b, _ := semver.Parse(tags[j])
+
+			return semver.Compare(a, b) < 0
+		})
+
+		So(tags, ShouldResemble, []string{"0.9", "1.2.0-1", "1.2.0-beta", "1.2.0-rc", "1.2.0-rc.2", "1.2.0-rc.10",
+			"1.2.0", "1.10.0"})
+
+		a, _ := semver.Parse("v1.2")
+		b, _ := semver.Parse("1.2.0")
+		So(semver.Compare(a, b), ShouldEqual, 0)
+	})
+}
+
+func TestConstraint(t *testing.T) {
+	Convey("Versions are checked against ranges", t, func() {
+		check := func(constraint string, tag string) bool {
+			c, err := semver.ParseConstraint(constraint)
+			So(err, ShouldBeNil)
+
+			version, ok := semver.Parse(tag)
+			So(ok, ShouldBeTrue)
+
+			return c.Check(version)
+		}
+
+		for constraint, tags := range map[string][2][]string{
+			"":                {{"0.0.1", "1.2.3", "10"}, {"1.2.3-rc.1"}},
+			"*":               {{"0.0.1", "10"}, {"1.0.0-rc.1"}},
+			"1.2.3":           {{"1.2.3", "v1.2.3"}, {"1.2.4", "1.2.3-rc.1"}},
+			"=1.2":            {{"1.2.0", "1.2.9"}, {"1.3.0", "1.1.9"}},
+			"1.x":             {{"1.0.0", "1.9.9"}, {"2.0.0", "0.9.9"}},
+			"!=1.2.3":         {{"1.2.4"}, {"1.2.3"}},
+			">1.2":            {{"1.3.0"}, {"1.2.9"}},
+			">1.2.3":          {{"1.2.4"}, {"1.2.3"}},
+			">= 1.2":          {{"1.2.0", "3"}, {"1.1.9"}},
+			"<1.2":            {{"1.1.9"}, {"1.2.0"}},
+			"<=1.2":           {{"1.2.9"}, {"1.3.0"}},
+			"~1.2.3":          {{"1.2.3", "1.2.9"}, {"1.2.2", "1.3.0"}},
+			"~1":              {{"1.0.0", "1.9.0"}, {"2.0.0"}},
+			"^1.2":            {{"1.2.0", "1.9.0"}, {"1.1.0", "2.0.0", "2.0.0-rc.1"}},
+			"^0.2.3":          {{"0.2.3", "0.2.9"}, {"0.3.0"}},
+			"^0.0.3":          {{"0.0.3"}, {"0.0.4"}},
+			"^0":              {{"0.9.9"}, {"1.0.0"}},
+			">=1.2, <1.5":     {{"1.4.9"}, {"1.5.0"}},
+			"^1.2 || ^3":      {{"1.3.0", "3.1.0"}, {"2.0.0"}},
+			">=1.2.3-rc.1":    {{"1.2.3-rc.2", "1.2.3", "1.3.0"}, {"1.2.3-beta", "1.3.0-rc.1"}},
+			"1.2.3-rc.1 || 2": {{"1.2.3-rc.1", "2.1.0"}, {"1.2.3"}},
+		} {
+			for _, tag := range tags[0] {
+				So(check(constraint, tag), ShouldBeTrue)
+			}
+
+			for _, tag := range tags[1] {
+				So(check(constraint, tag), ShouldBeFalse)
+			}
+		}
+
+		for _, constraint := range []string{"latest", ">=", "1.x.3", ">*", "<x", "!=1.2", "=>1.2", "1.2 - 1.4",
+			"1.2-rc.1"} {
+			_, err := semver.ParseConstraint(constraint)
+			So(errors.Is(err, zotErrors.ErrBadSemverConstraint), ShouldBeTrue)
+		}
+	})
+
+	Convey("The newest tag in a range is picked", t, func() {
+		tags := []string{"latest", "1.2", "1.2.10", "1.2.9", "1.3.0-rc.1", "v1.3.0", "1.3.0", "2.0.0-beta", "0.9"}
+
+		latest := func(constraint string) (string, bool) {
+			c, err := semver.ParseConstraint(constraint)
+			So(err, ShouldBeNil)
+
+			return semver.Latest(tags, c)
+		}
+
+		tag, ok := latest("")
+		So(ok, ShouldBeTrue)
+		So(tag, ShouldEqual, "v1.3.0")
+
+		tag, ok = latest("~1.2")
+		So(ok, ShouldBeTrue)
+		So(tag, ShouldEqual, "1.2.10")
+
+		tag, ok = latest("<1.3.0 || ^0.9")
+		So(ok, ShouldBeTrue)
+		So(tag, ShouldEqual, "1.2.10")
+
+		tag, ok = latest(">=2.0.0-alpha")
+		So(ok, ShouldBeTrue)
+		So(tag, ShouldEqual, "2.0.0-beta")
+
+		_, ok = latest("^3")
+		So(ok, ShouldBeFalse)
+	})
+}
@@ -0,0 +1,48 @@
+package search
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	tracerName = "github.com/anuvu/zot/pkg/extensions/search"
+)
+
+// Tracing is a gqlgen extension which starts a span for each top-level query resolver.
+type Tracing struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.FieldInterceptor
+} = Tracing{}
+
+func (Tracing) ExtensionName() string {
+	return "OpenTelemetryTracing"
+}
+
+func (Tracing) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (Tracing) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	fc := graphql.GetFieldContext(ctx)
+	// nested fields are plain struct accesses, only resolvers are worth a span
+	if fc == nil || fc.Object != "Query" {
+		return next(ctx)
+	}
+
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "graphql.Query."+fc.Field.Name)
+	defer span.End()
+
+	res, err := next(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return res, err
+}
@@ -0,0 +1,247 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+)
+
+const (
+	secretUsernameFile = "username"
+	secretPasswordFile = "password"
+
+	credentialHelperLimit = 30 * time.Second
+	credentialHelperTTL   = 5 * time.Minute
+)
+
+// CredentialsConfig reads the credentials of a registry from outside the zot config, with one of:
+// DockerConfig, the path of a docker config.json whose auths entry of the registry host is used,
+// SecretDir, a directory holding username and password files, e.g. a mounted Kubernetes secret,
+// Helper, a docker credential helper program, e.g. docker-credential-ecr-login, run with "get".
+// Files are read again when they change, and helpers run again after 5 minutes.
+type CredentialsConfig struct {
+	DockerConfig string
+	SecretDir    string
+	Helper       string
+}
+
+func (c *CredentialsConfig) validate() error {
+	var sources int
+
+	for _, source := range []string{c.DockerConfig, c.SecretDir, c.Helper} {
+		if source != "" {
+			sources++
+		}
+	}
+
+	if sources != 1 {
+		return fmt.Errorf("%w: sync credentials need one of dockerConfig, secretDir and helper", errors.ErrBadConfig)
+	}
+
+	return nil
+}
+
+// credentialProvider returns the username and password to authenticate to a registry with.
+type credentialProvider interface {
+	credentials() (string, string, error)
+}
+
+// newCredentialProvider returns the provider of the credentials of a registry, its config must be valid.
+func newCredentialProvider(config RegistryConfig, host string) credentialProvider {
+	switch {
+	case config.Credentials == nil:
+		return staticCredentials{username: config.Username, password: config.Password}
+	case config.Credentials.DockerConfig != "":
+		return &fileCredentials{
+			files: []string{config.Credentials.DockerConfig},
+			parse: func(contents [][]byte) (string, string, error) {
+				return parseDockerConfig(contents[0], host)
+			},
+		}
+	case config.Credentials.SecretDir != "":
+		return &fileCredentials{
+			files: []string{
+				filepath.Join(config.Credentials.SecretDir, secretUsernameFile),
+				filepath.Join(config.Credentials.SecretDir, secretPasswordFile),
+			},
+			parse: func(contents [][]byte) (string, string, error) {
+				// secrets are often written with a trailing newline
+				username := strings.TrimRight(string(contents[0]), "\r\n")
+				password := strings.TrimRight(string(contents[1]), "\r\n")
+
+				return username, password, nil
+			},
+		}
+	default:
+		return &helperCredentials{helper: config.Credentials.Helper, host: host}
+	}
+}
+
+// staticCredentials are the username and password of the zot config, if any.
+type staticCredentials struct {
+	username string
+	password string
+}
+
+func (sc staticCredentials) credentials() (string, string, error) {
+	return sc.username, sc.password, nil
+}
+
+// fileCredentials parses the credentials out of files, again whenever one of them changes, so that
+// credentials can be rotated without a restart.
+type fileCredentials struct {
+	files    []string
+	parse    func(contents [][]byte) (string, string, error)
+	lock     sync.Mutex
+	stamp    []os.FileInfo
+	username string
+	password string
+}
+
+func (fc *fileCredentials) credentials() (string, string, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	stamp := make([]os.FileInfo, len(fc.files))
+	changed := fc.stamp == nil
+
+	for i, file := range fc.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %v", errors.ErrSyncCredentials, err)
+		}
+
+		stamp[i] = info
+
+		if !changed && (!info.ModTime().Equal(fc.stamp[i].ModTime()) || info.Size() != fc.stamp[i].Size()) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return fc.username, fc.password, nil
+	}
+
+	contents := make([][]byte, len(fc.files))
+
+	for i, file := range fc.files {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %v", errors.ErrSyncCredentials, err)
+		}
+
+		contents[i] = buf
+	}
+
+	username, password, err := fc.parse(contents)
+	if err != nil {
+		return "", "", err
+	}
+
+	fc.username, fc.password, fc.stamp = username, password, stamp
+
+	return username, password, nil
+}
+
+// dockerConfig is the part of a docker config.json holding credentials.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+}
+
+// parseDockerConfig returns the credentials of the registry host, whose auths entry may also be a URL,
+// e.g. https://registry:5000/v1/.
+func parseDockerConfig(content []byte, host string) (string, string, error) {
+	var config dockerConfig
+
+	if err := json.Unmarshal(content, &config); err != nil {
+		return "", "", fmt.Errorf("%w: %v", errors.ErrSyncCredentials, err)
+	}
+
+	for key, auth := range config.Auths {
+		if key != host && !strings.HasPrefix(key, "https://"+host) && !strings.HasPrefix(key, "http://"+host) {
+			continue
+		}
+
+		if auth.Auth == "" {
+			return auth.Username, auth.Password, nil
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %v", errors.ErrSyncCredentials, err)
+		}
+
+		sep := bytes.IndexByte(decoded, ':')
+		if sep < 0 {
+			return "", "", fmt.Errorf("%w: invalid auth of %s", errors.ErrSyncCredentials, key)
+		}
+
+		return string(decoded[:sep]), string(decoded[sep+1:]), nil
+	}
+
+	return "", "", fmt.Errorf("%w: no auths entry for %s", errors.ErrSyncCredentials, host)
+}
+
+// helperCredentials runs a docker credential helper, whose credentials are kept for a while since helpers
+// may be slow, e.g. when they ask a cloud provider for a token.
+type helperCredentials struct {
+	helper   string
+	host     string
+	lock     sync.Mutex
+	expiry   time.Time
+	username string
+	password string
+}
+
+func (hc *helperCredentials) credentials() (string, string, error) {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	if time.Now().Before(hc.expiry) {
+		return hc.username, hc.password, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperLimit)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hc.helper, "get") // nolint: gosec
+	cmd.Stdin = strings.NewReader(hc.host)
+
+	var stderr bytes.Buffer
+
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s: %v %s", errors.ErrSyncCredentials, hc.helper, err,
+			strings.TrimSpace(stderr.String()))
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+
+	if err := json.Unmarshal(output, &creds); err != nil {
+		return "", "", fmt.Errorf("%w: %s: %v", errors.ErrSyncCredentials, hc.helper, err)
+	}
+
+	hc.username, hc.password, hc.expiry = creds.Username, creds.Secret, time.Now().Add(credentialHelperTTL)
+
+	return hc.username, hc.password, nil
+}
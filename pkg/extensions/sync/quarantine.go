@@ -0,0 +1,77 @@
+package sync
+
+import (
+	"context"
+	"path"
+
+	"github.com/anuvu/zot/errors"
+	cveinfo "github.com/anuvu/zot/pkg/extensions/search/cve"
+)
+
+const defaultQuarantine = "quarantine"
+
+func checkCVEPolicy(policy *CVEPolicyConfig, canScan bool) error {
+	if policy == nil {
+		return nil
+	}
+
+	if cveinfo.SeverityRank(policy.Severity) < 0 {
+		return errors.ErrBadConfig
+	}
+
+	if !canScan {
+		return errors.ErrSyncScanNotEnabled
+	}
+
+	return nil
+}
+
+// checkImage scans the image if the registry has a CVE policy, and returns the repository it is replicated
+// to, with the highest severity of its vulnerabilities if it must be quarantined.
+func (r *Replicator) checkImage(reg *registry, j job) (string, string, error) {
+	policy := reg.config.CVEPolicy
+	if policy == nil || j.approved {
+		return j.destRepo, "", nil
+	}
+
+	vulnerabilities, err := r.scanner(context.Background(), j.repo, j.reference)
+	if err != nil {
+		return "", "", err
+	}
+
+	highest := cveinfo.HighestSeverity(vulnerabilities, policy.Severity)
+	if highest == "" {
+		return j.destRepo, "", nil
+	}
+
+	return path.Join(policy.Quarantine, j.destRepo), highest, nil
+}
+
+// Approve releases an image from quarantine, it is replicated to its destination in the registries which
+// quarantined it, without being scanned again.
+func (r *Replicator) Approve(repo, reference string) error {
+	approved := false
+
+	for _, reg := range r.registries {
+		if !reg.isQuarantined(repo, reference) {
+			continue
+		}
+
+		destRepo, ok := reg.destination(repo)
+		if !ok {
+			continue
+		}
+
+		r.log.Info().Str("registry", reg.config.URL).Str("repo", repo).Str("reference", reference).
+			Msg("approved quarantined image")
+		r.enqueue(reg, job{repo: repo, destRepo: destRepo, reference: reference, approved: true})
+
+		approved = true
+	}
+
+	if !approved {
+		return errors.ErrSyncNotQuarantined
+	}
+
+	return nil
+}
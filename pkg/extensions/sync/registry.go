@@ -0,0 +1,439 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	httpTimeout        = 5 * time.Minute
+	caCertFilename     = "ca.crt"
+	clientCertFilename = "client.cert"
+	clientKeyFilename  = "client.key"
+	directProxy        = "direct"
+
+	// AnnotationOriginalDigest is the digest of the index an index replicated without some of its platforms
+	// was rewritten from.
+	AnnotationOriginalDigest = "io.zot.sync.original.digest"
+)
+
+// registry is a downstream registry images are pushed to, with the OCI distribution API.
+type registry struct {
+	config      RegistryConfig
+	baseURL     *url.URL
+	client      *http.Client
+	credentials credentialProvider
+	queue       chan job
+	maxRetries  int
+	retryDelay  time.Duration
+	lock        sync.Mutex
+	status      Status
+}
+
+// newRegistry sets up the replication to a registry, its config must have its defaults applied.
+func newRegistry(config RegistryConfig) (*registry, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := proxyFunc(config.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := &registry{
+		config:  config,
+		baseURL: baseURL,
+		client: &http.Client{
+			Timeout:   httpTimeout,
+			Transport: &http.Transport{Proxy: proxy, TLSClientConfig: tlsConfig},
+		},
+		credentials: newCredentialProvider(config, baseURL.Host),
+		maxRetries:  config.MaxRetries,
+		retryDelay:  config.RetryDelay,
+		queue:       make(chan job, config.QueueSize),
+	}
+
+	return reg, nil
+}
+
+// proxyFunc returns the proxy of the requests to a registry: the proxy environment variables if none is
+// configured, no proxy at all if it's "direct", or else the configured one.
+func proxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case directProxy:
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Host == "" ||
+		(proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5") {
+		return nil, fmt.Errorf("%w: invalid proxy %q", errors.ErrBadConfig, proxy)
+	}
+
+	return http.ProxyURL(proxyURL), nil
+}
+
+// newTLSConfig trusts the CA of the cert dir, and presents its client certificate if there is one.
+func newTLSConfig(config RegistryConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.TLSVerify != nil && !*config.TLSVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if config.CertDir == "" {
+		return tlsConfig, nil
+	}
+
+	caCert, err := ioutil.ReadFile(filepath.Join(config.CertDir, caCertFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+		return nil, errors.ErrBadCACert
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(config.CertDir, clientCertFilename),
+		filepath.Join(config.CertDir, clientKeyFilename))
+
+	switch {
+	case err == nil:
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	return tlsConfig, nil
+}
+
+// do sends a request to the registry, and returns the headers of the response,
+// it fails unless the registry answers with the expected status code.
+func (reg *registry) do(method, location string, body io.Reader, size int64, contentType string,
+	expected int) (http.Header, error) {
+	u, err := reg.baseURL.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	username, password, err := reg.credentials.credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := reg.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case expected:
+	case http.StatusForbidden, http.StatusConflict:
+		return nil, fmt.Errorf("%w: %s %s: %s", errors.ErrSyncConflict, method, u.Path, resp.Status)
+	default:
+		return nil, fmt.Errorf("%w: %s %s: %s", errors.ErrSyncPushFailed, method, u.Path, resp.Status)
+	}
+
+	return resp.Header, nil
+}
+
+// pushImage pushes the blobs and the manifest of the image, or the manifests of the index, then its signatures
+// and other referrers.
+func (reg *registry) pushImage(imgStore *storage.ImageStore, repo, destRepo, reference string) error {
+	content, digest, mediaType, err := imgStore.GetImageManifest(repo, reference)
+	if err != nil {
+		return err
+	}
+
+	rewritten, err := reg.pushManifest(imgStore, repo, destRepo, reference, content, mediaType)
+	if err != nil {
+		return err
+	}
+
+	// the signatures of an index left without some of its platforms don't apply to the replicated one
+	if rewritten {
+		return nil
+	}
+
+	referrers, err := imgStore.GetReferrers(repo, godigest.Digest(digest), "")
+	if err != nil {
+		return err
+	}
+
+	for _, referrer := range referrers {
+		if err := reg.pushImage(imgStore, repo, destRepo, referrer.Digest.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pushManifest pushes the blobs of a manifest, or the manifests of an index, then the manifest itself. It returns
+// true if the index was rewritten without the manifests of the platforms which aren't replicated.
+func (reg *registry) pushManifest(imgStore *storage.ImageStore, repo, destRepo, reference string, content []byte,
+	mediaType string) (bool, error) {
+	rewritten := false
+
+	if storage.IsIndexMediaType(mediaType) {
+		var err error
+
+		if content, rewritten, err = reg.pushIndexManifests(imgStore, repo, destRepo, content); err != nil {
+			return false, err
+		}
+
+		// the rewritten index has a digest of its own
+		if d, err := godigest.Parse(reference); err == nil && rewritten {
+			reference = d.Algorithm().FromBytes(content).String()
+		}
+	} else {
+		var manifest ispec.Manifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return false, err
+		}
+
+		for _, desc := range append([]ispec.Descriptor{manifest.Config}, manifest.Layers...) {
+			if err := reg.pushBlob(imgStore, repo, destRepo, desc.Digest); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if _, err := reg.do(http.MethodPut, fmt.Sprintf("/v2/%s/manifests/%s", destRepo, reference),
+		bytes.NewReader(content), int64(len(content)), mediaType, http.StatusCreated); err != nil {
+		return false, err
+	}
+
+	reg.recordBytes(int64(len(content)))
+
+	return rewritten, nil
+}
+
+// pushIndexManifests pushes the manifests of an index of the replicated platforms, and returns the index to
+// push. An index which references manifests of other platforms, or manifests left out of a sparse index, is
+// rewritten with the replicated ones only, and the digest of the original index in AnnotationOriginalDigest.
+func (reg *registry) pushIndexManifests(imgStore *storage.ImageStore, repo, destRepo string,
+	content []byte) ([]byte, bool, error) {
+	var index ispec.Index
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, false, err
+	}
+
+	selected := make([]ispec.Descriptor, 0, len(index.Manifests))
+
+	for _, desc := range index.Manifests {
+		if !platformSelected(reg.config.Platforms, desc.Platform) {
+			continue
+		}
+
+		child, _, mediaType, err := imgStore.GetImageManifest(repo, desc.Digest.String())
+		if goerrors.Is(err, errors.ErrManifestNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		if _, err := reg.pushManifest(imgStore, repo, destRepo, desc.Digest.String(), child, mediaType); err != nil {
+			return nil, false, err
+		}
+
+		selected = append(selected, desc)
+	}
+
+	if len(selected) == 0 {
+		return nil, false, errors.ErrSyncPlatformNotFound
+	}
+
+	if len(selected) == len(index.Manifests) {
+		return content, false, nil
+	}
+
+	// the other fields of the index, e.g. its media type, are kept as they are
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, false, err
+	}
+
+	annotations := map[string]string{}
+
+	for key, value := range index.Annotations {
+		annotations[key] = value
+	}
+
+	annotations[AnnotationOriginalDigest] = godigest.FromBytes(content).String()
+
+	for key, value := range map[string]interface{}{"manifests": selected, "annotations": annotations} {
+		buf, err := json.Marshal(value)
+		if err != nil {
+			return nil, false, err
+		}
+
+		fields[key] = buf
+	}
+
+	rewritten, err := json.Marshal(fields)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return rewritten, true, nil
+}
+
+// platformSelected tells whether the manifest of an index of the platform is replicated, manifests without a
+// platform, e.g. nested indexes, always are.
+func platformSelected(platforms []string, platform *ispec.Platform) bool {
+	if len(platforms) == 0 || platform == nil {
+		return true
+	}
+
+	for _, selected := range platforms {
+		parts := strings.Split(selected, "/")
+
+		if parts[0] == platform.OS && parts[1] == platform.Architecture &&
+			(len(parts) == 2 || parts[2] == platform.Variant) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// platformReplicated tells whether the image, pushed by digest, is of a replicated platform. Images of other
+// platforms are the manifests of multi-arch indexes, pushed before the index itself, which the index won't
+// reference once replicated. Tagged images, and manifests whose config has no platform, e.g. signatures,
+// always are.
+func (reg *registry) platformReplicated(imgStore *storage.ImageStore, repo, reference string) (bool, error) {
+	if len(reg.config.Platforms) == 0 {
+		return true, nil
+	}
+
+	if _, err := godigest.Parse(reference); err != nil {
+		return true, nil
+	}
+
+	content, _, mediaType, err := imgStore.GetImageManifest(repo, reference)
+	if err != nil {
+		return false, err
+	}
+
+	if storage.IsIndexMediaType(mediaType) {
+		return true, nil
+	}
+
+	var manifest ispec.Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return false, err
+	}
+
+	if manifest.Config.MediaType != ispec.MediaTypeImageConfig {
+		return true, nil
+	}
+
+	blob, _, err := imgStore.GetBlob(repo, manifest.Config.Digest.String(), "")
+	if err != nil {
+		return false, err
+	}
+
+	if closer, ok := blob.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	var config ispec.Platform
+	if err := json.NewDecoder(blob).Decode(&config); err != nil {
+		return false, err
+	}
+
+	if config.OS == "" {
+		return true, nil
+	}
+
+	return platformSelected(reg.config.Platforms, &config), nil
+}
+
+// pushBlob uploads the blob in a single request, unless the registry already has it.
+func (reg *registry) pushBlob(imgStore *storage.ImageStore, repo, destRepo string, digest godigest.Digest) error {
+	if _, err := reg.do(http.MethodHead, fmt.Sprintf("/v2/%s/blobs/%s", destRepo, digest),
+		nil, 0, "", http.StatusOK); err == nil {
+		return nil
+	}
+
+	header, err := reg.do(http.MethodPost, fmt.Sprintf("/v2/%s/blobs/uploads/", destRepo),
+		nil, 0, "", http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+
+	location, err := url.Parse(header.Get("Location"))
+	if err != nil {
+		return err
+	}
+
+	query := location.Query()
+	query.Set("digest", digest.String())
+	location.RawQuery = query.Encode()
+
+	blob, size, err := imgStore.GetBlob(repo, digest.String(), "")
+	if err != nil {
+		return err
+	}
+
+	if closer, ok := blob.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	if _, err := reg.do(http.MethodPut, location.String(), blob, size, "application/octet-stream",
+		http.StatusCreated); err != nil {
+		return err
+	}
+
+	reg.recordBytes(size)
+
+	return nil
+}
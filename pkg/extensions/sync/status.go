@@ -0,0 +1,160 @@
+package sync
+
+import (
+	"time"
+)
+
+// maxFailures is the number of recent failures kept per registry.
+const maxFailures = 100
+
+// Failure is an image which couldn't be replicated. Conflicts are pushes refused by the registry,
+// e.g. because the tag is immutable there, they are not retried.
+type Failure struct {
+	Repo      string    `json:"repo"`
+	Reference string    `json:"reference"`
+	Time      time.Time `json:"time"`
+	Reason    string    `json:"reason"`
+	Conflict  bool      `json:"conflict"`
+}
+
+// QuarantinedImage is an image replicated to the quarantine of the registry because of its
+// vulnerabilities, Severity is the highest of them.
+type QuarantinedImage struct {
+	Repo      string    `json:"repo"`
+	Reference string    `json:"reference"`
+	Time      time.Time `json:"time"`
+	Severity  string    `json:"severity"`
+}
+
+// Status is the replication status of a downstream registry, Failures holds the most recent
+// failures first, and Quarantined the images pending approval, most recent first.
+type Status struct {
+	Registry         string             `json:"registry"`
+	LastSync         *time.Time         `json:"lastSync,omitempty"`
+	ImagesSynced     uint64             `json:"imagesSynced"`
+	BytesTransferred uint64             `json:"bytesTransferred"`
+	Queued           int                `json:"queued"`
+	FailureCount     uint64             `json:"failureCount"`
+	Failures         []Failure          `json:"failures"`
+	Quarantined      []QuarantinedImage `json:"quarantined"`
+}
+
+// Status returns the replication status of the downstream registries, in configuration order.
+func (r *Replicator) Status() []Status {
+	statuses := make([]Status, 0, len(r.registries))
+
+	for _, reg := range r.registries {
+		statuses = append(statuses, reg.getStatus())
+	}
+
+	return statuses
+}
+
+// QueueLengths returns the number of images waiting to be replicated to each downstream registry, by host.
+func (r *Replicator) QueueLengths() map[string]int {
+	lengths := make(map[string]int, len(r.registries))
+
+	for _, reg := range r.registries {
+		lengths[reg.baseURL.Host] = len(reg.queue)
+	}
+
+	return lengths
+}
+
+func (reg *registry) getStatus() Status {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	status := reg.status
+	status.Registry = reg.config.URL
+	status.Queued = len(reg.queue)
+
+	if status.LastSync != nil {
+		lastSync := *status.LastSync
+		status.LastSync = &lastSync
+	}
+
+	status.Failures = make([]Failure, len(reg.status.Failures))
+	copy(status.Failures, reg.status.Failures)
+
+	status.Quarantined = make([]QuarantinedImage, len(reg.status.Quarantined))
+	copy(status.Quarantined, reg.status.Quarantined)
+
+	return status
+}
+
+// recordSync counts the image, and releases it from quarantine if it was there.
+func (reg *registry) recordSync(j job) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	now := time.Now()
+	reg.status.LastSync = &now
+	reg.status.ImagesSynced++
+	reg.status.Quarantined = removeQuarantined(reg.status.Quarantined, j.repo, j.reference)
+}
+
+func (reg *registry) recordQuarantine(j job, severity string) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	now := time.Now()
+	reg.status.LastSync = &now
+	reg.status.ImagesSynced++
+
+	image := QuarantinedImage{Repo: j.repo, Reference: j.reference, Time: now, Severity: severity}
+	reg.status.Quarantined = append([]QuarantinedImage{image},
+		removeQuarantined(reg.status.Quarantined, j.repo, j.reference)...)
+}
+
+func (reg *registry) isQuarantined(repo, reference string) bool {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	for _, image := range reg.status.Quarantined {
+		if image.Repo == repo && image.Reference == reference {
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeQuarantined(images []QuarantinedImage, repo, reference string) []QuarantinedImage {
+	kept := make([]QuarantinedImage, 0, len(images))
+
+	for _, image := range images {
+		if image.Repo != repo || image.Reference != reference {
+			kept = append(kept, image)
+		}
+	}
+
+	return kept
+}
+
+func (reg *registry) recordBytes(size int64) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	reg.status.BytesTransferred += uint64(size)
+}
+
+func (reg *registry) recordFailure(j job, err error, conflict bool) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	failure := Failure{
+		Repo:      j.repo,
+		Reference: j.reference,
+		Time:      time.Now(),
+		Reason:    err.Error(),
+		Conflict:  conflict,
+	}
+
+	reg.status.FailureCount++
+	reg.status.Failures = append([]Failure{failure}, reg.status.Failures...)
+
+	if len(reg.status.Failures) > maxFailures {
+		reg.status.Failures = reg.status.Failures[:maxFailures]
+	}
+}
@@ -0,0 +1,358 @@
+package sync
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultRetryDelay = 30 * time.Second
+	defaultQueueSize  = 1000
+)
+
+// Config replicates the images pushed to zot to downstream registries.
+type Config struct {
+	Enable     bool
+	Registries []RegistryConfig
+}
+
+// RegistryConfig is a downstream registry, the images pushed to zot are queued for replication to it.
+type RegistryConfig struct {
+	URL         string
+	Username    string
+	Password    string
+	Credentials *CredentialsConfig // reads the username and password from outside the zot config instead
+	CertDir     string             // holds ca.crt, and client.cert and client.key for mutual TLS
+	TLSVerify   *bool              // defaults to true
+	Proxy       string             // proxy URL overriding HTTP(S)_PROXY and NO_PROXY, or "direct" to bypass them
+	Content     []ContentConfig    // all repositories, under the same name, if not given
+	Platforms   []string           // os/arch[/variant] of the multi-arch images replicated, all if not given
+	MaxRetries  int                // defaults to 3
+	RetryDelay  time.Duration      // delay before the first retry, doubled after each one, defaults to 30s
+	QueueSize   int                // defaults to 1000, pushes are not replicated while the queue is full
+	CVEPolicy   *CVEPolicyConfig
+}
+
+// Validate checks the URL, the proxy, the credentials, the platforms and the CVE policy of the registry, a CVE policy
+// needs images to be scannable.
+func (c RegistryConfig) Validate(canScan bool) error {
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.ErrBadConfig
+	}
+
+	if _, err := proxyFunc(c.Proxy); err != nil {
+		return err
+	}
+
+	if c.Credentials != nil {
+		if c.Username != "" || c.Password != "" {
+			return fmt.Errorf("%w: sync credentials can't be given along with a username and password",
+				errors.ErrBadConfig)
+		}
+
+		if err := c.Credentials.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, platform := range c.Platforms {
+		parts := strings.Split(platform, "/")
+
+		//nolint:gomnd
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("%w: sync platform %q isn't os/arch[/variant]", errors.ErrBadConfig, platform)
+		}
+	}
+
+	return checkCVEPolicy(c.CVEPolicy, canScan)
+}
+
+// WithDefaults returns a copy of the registry config with the defaults of its unset fields.
+func (c RegistryConfig) WithDefaults() RegistryConfig {
+	if c.TLSVerify == nil {
+		verify := true
+		c.TLSVerify = &verify
+	}
+
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defaultMaxRetries
+	}
+
+	if c.RetryDelay == 0 {
+		c.RetryDelay = defaultRetryDelay
+	}
+
+	if c.QueueSize == 0 {
+		c.QueueSize = defaultQueueSize
+	}
+
+	if c.CVEPolicy != nil && c.CVEPolicy.Quarantine == "" {
+		policy := *c.CVEPolicy
+		policy.Quarantine = defaultQuarantine
+		c.CVEPolicy = &policy
+	}
+
+	return c
+}
+
+// ContentConfig selects the repositories under Prefix, all of them if empty, and replicates them
+// under Destination, e.g. prod/app is replicated to mirror/app with Prefix "prod" and Destination "mirror".
+type ContentConfig struct {
+	Prefix      string
+	Destination string
+}
+
+// CVEPolicyConfig scans the images before replicating them, those with vulnerabilities of Severity or above
+// are replicated under the Quarantine namespace instead, e.g. quarantine/mirror/app, until an admin approves them.
+type CVEPolicyConfig struct {
+	Severity   string // one of UNKNOWN, LOW, MEDIUM, HIGH and CRITICAL
+	Quarantine string // defaults to "quarantine"
+}
+
+// Scanner returns the severity of each vulnerability of an image, none if the image can't be scanned.
+type Scanner func(ctx context.Context, repo, reference string) ([]string, error)
+
+type job struct {
+	repo      string
+	destRepo  string
+	reference string
+	approved  bool // released from quarantine, it isn't scanned again
+}
+
+// Replicator pushes the images pushed to zot to downstream registries, each registry has its own
+// queue and worker so that an unavailable registry doesn't hold back the others, and images are
+// replicated in the order they were pushed.
+type Replicator struct {
+	storeController storage.StoreController
+	scanner         Scanner
+	registries      []*registry
+	log             log.Logger
+	done            chan struct{}
+	wg              sync.WaitGroup
+}
+
+// NewReplicator starts a worker per downstream registry, Stop must be called on shutdown. The scanner
+// is only needed by the registries with a CVE policy.
+func NewReplicator(config *Config, storeController storage.StoreController, scanner Scanner,
+	log log.Logger) (*Replicator, error) {
+	r := &Replicator{
+		storeController: storeController,
+		scanner:         scanner,
+		log:             log,
+		done:            make(chan struct{}),
+	}
+
+	for _, regConfig := range config.Registries {
+		if err := regConfig.Validate(scanner != nil); err != nil {
+			log.Error().Err(err).Str("url", regConfig.URL).Msg("invalid sync registry config")
+			return nil, err
+		}
+
+		reg, err := newRegistry(regConfig.WithDefaults())
+		if err != nil {
+			log.Error().Err(err).Str("url", regConfig.URL).Msg("unable to setup sync registry")
+			return nil, err
+		}
+
+		r.registries = append(r.registries, reg)
+	}
+
+	for _, reg := range r.registries {
+		r.wg.Add(1)
+
+		go r.run(reg)
+	}
+
+	return r, nil
+}
+
+// Stop stops the workers, images still queued are not replicated.
+func (r *Replicator) Stop() {
+	close(r.done)
+	r.wg.Wait()
+}
+
+// Notify queues the replication of an image pushed to the repository, to the registries whose
+// content rules select the repository.
+func (r *Replicator) Notify(repo, reference string) {
+	for _, reg := range r.registries {
+		destRepo, ok := reg.destination(repo)
+		if !ok {
+			continue
+		}
+
+		r.enqueue(reg, job{repo: repo, destRepo: destRepo, reference: reference})
+	}
+}
+
+func (r *Replicator) enqueue(reg *registry, j job) {
+	select {
+	case reg.queue <- j:
+	default:
+		r.log.Error().Err(errors.ErrSyncQueueFull).Str("registry", reg.config.URL).Str("repo", j.repo).
+			Str("reference", j.reference).Msg("image won't be replicated")
+		reg.recordFailure(j, errors.ErrSyncQueueFull, false)
+	}
+}
+
+func (r *Replicator) run(reg *registry) {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case j := <-reg.queue:
+			r.replicate(reg, j)
+		}
+	}
+}
+
+// replicate pushes the image to the registry, or to its quarantine if the CVE policy of the registry
+// rejects it, retrying with an exponential backoff unless the registry refuses it.
+func (r *Replicator) replicate(reg *registry, j job) {
+	logger := r.log.With().Str("registry", reg.config.URL).Str("repo", j.repo).Str("reference", j.reference).
+		Logger()
+	imgStore := r.storeController.GetImageStore(j.repo)
+	delay := reg.retryDelay
+
+	if ok, err := reg.platformReplicated(imgStore, j.repo, j.reference); err != nil || !ok {
+		if err != nil {
+			logger.Error().Err(err).Msg("unable to read the platform of the image, it won't be replicated")
+			reg.recordFailure(j, err, false)
+		}
+
+		return
+	}
+
+	destRepo, severity, err := r.checkImage(reg, j)
+	if err != nil {
+		logger.Error().Err(err).Msg("unable to scan image, it won't be replicated")
+		reg.recordFailure(j, err, false)
+
+		return
+	}
+
+	logger = logger.With().Str("destRepo", destRepo).Logger()
+
+	for attempt := 0; ; attempt++ {
+		err := reg.pushImage(imgStore, j.repo, destRepo, j.reference)
+		if err == nil && severity != "" {
+			logger.Warn().Str("severity", severity).Msg("quarantined image")
+			reg.recordQuarantine(j, severity)
+
+			return
+		}
+
+		if err == nil {
+			logger.Info().Msg("replicated image")
+			reg.recordSync(j)
+
+			return
+		}
+
+		if goerrors.Is(err, errors.ErrSyncConflict) {
+			logger.Error().Err(err).Msg("downstream registry refused the image")
+			reg.recordFailure(j, err, true)
+
+			return
+		}
+
+		if goerrors.Is(err, errors.ErrSyncPlatformNotFound) {
+			logger.Warn().Err(err).Msg("image has none of the replicated platforms")
+			reg.recordFailure(j, err, false)
+
+			return
+		}
+
+		if attempt >= reg.maxRetries {
+			logger.Error().Err(err).Int("attempts", attempt+1).Msg("giving up replicating image")
+			reg.recordFailure(j, err, false)
+
+			return
+		}
+
+		logger.Warn().Err(err).Str("retryIn", delay.String()).Msg("unable to replicate image, retrying")
+
+		select {
+		case <-r.done:
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+// Content returns the content rules of the downstream registries, by host, e.g. "mirror.example.com:5000".
+func (r *Replicator) Content() map[string][]ContentConfig {
+	content := make(map[string][]ContentConfig, len(r.registries))
+
+	for _, reg := range r.registries {
+		content[reg.baseURL.Host] = reg.getContent()
+	}
+
+	return content
+}
+
+// SetContent replaces the content rules of the downstream registry on host, the images already queued are
+// replicated with the previous ones.
+func (r *Replicator) SetContent(host string, content []ContentConfig) error {
+	for _, reg := range r.registries {
+		if reg.baseURL.Host != host {
+			continue
+		}
+
+		reg.lock.Lock()
+		reg.config.Content = append([]ContentConfig{}, content...)
+		reg.lock.Unlock()
+
+		r.log.Info().Str("registry", reg.config.URL).Interface("content", content).Msg("updated sync content rules")
+
+		return nil
+	}
+
+	return errors.ErrSyncRegistryNotFound
+}
+
+func (reg *registry) getContent() []ContentConfig {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	return append([]ContentConfig{}, reg.config.Content...)
+}
+
+// destination returns the name of the repository in the registry, false if not replicated to it.
+func (reg *registry) destination(repo string) (string, bool) {
+	contents := reg.getContent()
+	if len(contents) == 0 {
+		return repo, true
+	}
+
+	for _, content := range contents {
+		prefix := strings.Trim(content.Prefix, "/")
+
+		if prefix != "" && repo != prefix && !strings.HasPrefix(repo, prefix+"/") {
+			continue
+		}
+
+		if content.Destination == "" {
+			return repo, true
+		}
+
+		return strings.Trim(path.Join(content.Destination, strings.TrimPrefix(repo, prefix)), "/"), true
+	}
+
+	return "", false
+}
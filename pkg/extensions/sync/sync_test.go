@@ -0,0 +1,817 @@
+// +build extended
+
+package sync_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/api"
+	ext "github.com/anuvu/zot/pkg/extensions"
+	"github.com/anuvu/zot/pkg/extensions/sync"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/phayes/freeport"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/resty.v1"
+)
+
+const (
+	username = "replicator"
+	password = "replicator"
+)
+
+func startController(config *api.Config) *api.Controller {
+	c := api.NewController(config)
+
+	go func() {
+		// this blocks
+		if err := c.Run(); err != nil {
+			return
+		}
+	}()
+
+	baseURL := fmt.Sprintf("http://127.0.0.1:%s", config.HTTP.Port)
+
+	// wait till ready
+	for {
+		_, err := resty.R().Get(baseURL)
+		if err == nil {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return c
+}
+
+func getFreePort() string {
+	port, err := freeport.GetFreePort()
+	So(err, ShouldBeNil)
+
+	return fmt.Sprint(port)
+}
+
+func pushBlob(baseURL, repo string, content []byte) godigest.Digest {
+	digest := godigest.FromBytes(content)
+
+	resp, err := resty.R().Post(baseURL + "/v2/" + repo + "/blobs/uploads/")
+	So(err, ShouldBeNil)
+	So(resp.StatusCode(), ShouldEqual, 202)
+
+	resp, err = resty.R().SetQueryParam("digest", digest.String()).
+		SetHeader("Content-Type", "application/octet-stream").SetBody(content).
+		Put(baseURL + resp.Header().Get("Location"))
+	So(err, ShouldBeNil)
+	So(resp.StatusCode(), ShouldEqual, 201)
+
+	return digest
+}
+
+func pushManifest(baseURL, repo, reference string, subject *ispec.Descriptor) godigest.Digest {
+	// each push has new content, so that a tag pushed again points to another image
+	layer := []byte("layer of " + repo + ":" + reference + " pushed at " + time.Now().String())
+	config, err := json.Marshal(ispec.Image{})
+	So(err, ShouldBeNil)
+
+	manifest := struct {
+		ispec.Manifest
+		Subject *ispec.Descriptor `json:"subject,omitempty"`
+	}{
+		Manifest: ispec.Manifest{
+			Config: ispec.Descriptor{
+				MediaType: ispec.MediaTypeImageConfig,
+				Digest:    pushBlob(baseURL, repo, config),
+				Size:      int64(len(config)),
+			},
+			Layers: []ispec.Descriptor{
+				{
+					MediaType: ispec.MediaTypeImageLayer,
+					Digest:    pushBlob(baseURL, repo, layer),
+					Size:      int64(len(layer)),
+				},
+			},
+		},
+		Subject: subject,
+	}
+	manifest.SchemaVersion = 2
+
+	content, err := json.Marshal(manifest)
+	So(err, ShouldBeNil)
+
+	// referrers are pushed by digest
+	if reference == "" {
+		reference = godigest.FromBytes(content).String()
+	}
+
+	resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).SetBody(content).
+		Put(baseURL + "/v2/" + repo + "/manifests/" + reference)
+	So(err, ShouldBeNil)
+	So(resp.StatusCode(), ShouldEqual, 201)
+
+	return godigest.FromBytes(content)
+}
+
+// waitForManifest polls the registry until it has the manifest, false if it didn't get it in time.
+func waitForManifest(baseURL, repo, reference string) bool {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		resp, err := resty.R().SetBasicAuth(username, password).
+			Head(baseURL + "/v2/" + repo + "/manifests/" + reference)
+		if err == nil && resp.StatusCode() == 200 {
+			return true
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return false
+}
+
+// waitForStatus polls the replication status until the registry got the given number of images,
+// and failed the given number of times.
+func waitForStatus(baseURL string, synced, failures uint64) []sync.Status {
+	var statuses []sync.Status
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		resp, err := resty.R().Get(baseURL + "/v2/_zot/admin/sync")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &statuses), ShouldBeNil)
+		So(len(statuses), ShouldEqual, 1)
+
+		if statuses[0].ImagesSynced >= synced && statuses[0].FailureCount >= failures {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return statuses
+}
+
+func TestSync(t *testing.T) {
+	Convey("Pushed images are replicated to downstream registries", t, func() {
+		upstreamDir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(upstreamDir)
+
+		downstreamDir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(downstreamDir)
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		So(err, ShouldBeNil)
+
+		htpasswd, err := ioutil.TempFile("", "htpasswd-")
+		So(err, ShouldBeNil)
+		defer os.Remove(htpasswd.Name())
+
+		_, err = htpasswd.WriteString(username + ":" + string(hash) + "\n")
+		So(err, ShouldBeNil)
+		So(htpasswd.Close(), ShouldBeNil)
+
+		upstreamPort := getFreePort()
+		downstreamPort := getFreePort()
+		upstreamURL := "http://127.0.0.1:" + upstreamPort
+		downstreamURL := "http://127.0.0.1:" + downstreamPort
+
+		upstreamConfig := api.NewConfig()
+		upstreamConfig.HTTP.Port = upstreamPort
+		upstreamConfig.HTTP.AllowAdminAccess = true
+		upstreamConfig.Storage.RootDirectory = upstreamDir
+		upstreamConfig.Extensions = &ext.ExtensionConfig{
+			Search: &ext.SearchConfig{Enable: true},
+			Sync: &sync.Config{
+				Enable: true,
+				Registries: []sync.RegistryConfig{
+					{
+						URL:        downstreamURL,
+						Username:   username,
+						Password:   password,
+						Content:    []sync.ContentConfig{{Prefix: "prod", Destination: "mirror"}},
+						MaxRetries: 10,
+						RetryDelay: 100 * time.Millisecond,
+					},
+				},
+			},
+		}
+
+		So(upstreamConfig.Sanitize().Extensions.Sync.Registries[0].Password, ShouldEqual, "******")
+		So(upstreamConfig.Extensions.Sync.Registries[0].Password, ShouldEqual, password)
+
+		upstream := startController(upstreamConfig)
+		defer func() {
+			_ = upstream.Server.Shutdown(context.Background())
+		}()
+
+		// the downstream registry isn't up yet, replication is retried
+		imageDigest := pushManifest(upstreamURL, "prod/app", "1.0", nil)
+		pushManifest(upstreamURL, "dev/app", "1.0", nil)
+
+		time.Sleep(200 * time.Millisecond)
+
+		downstreamConfig := api.NewConfig()
+		downstreamConfig.HTTP.Port = downstreamPort
+		downstreamConfig.HTTP.Auth = &api.AuthConfig{HTPasswd: api.AuthHTPasswd{Path: htpasswd.Name()}}
+		downstreamConfig.Storage.RootDirectory = downstreamDir
+		downstreamConfig.Storage.TagPolicy = &api.TagPolicyConfig{
+			TagPolicyRule: api.TagPolicyRule{Immutable: []string{`^1\.0$`}},
+		}
+
+		downstream := startController(downstreamConfig)
+		defer func() {
+			_ = downstream.Server.Shutdown(context.Background())
+		}()
+
+		So(waitForManifest(downstreamURL, "mirror/app", "1.0"), ShouldBeTrue)
+
+		resp, err := resty.R().SetBasicAuth(username, password).Get(downstreamURL + "/v2/mirror/app/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(godigest.FromBytes(resp.Body()), ShouldEqual, imageDigest)
+
+		// signatures follow the image they sign
+		signatureDigest := pushManifest(upstreamURL, "prod/app", "",
+			&ispec.Descriptor{MediaType: ispec.MediaTypeImageManifest, Digest: imageDigest})
+		So(waitForManifest(downstreamURL, "mirror/app", signatureDigest.String()), ShouldBeTrue)
+
+		referrers, err := downstream.StoreController.DefaultStore.GetReferrers("mirror/app", imageDigest, "")
+		So(err, ShouldBeNil)
+		So(len(referrers), ShouldEqual, 1)
+		So(referrers[0].Digest, ShouldEqual, signatureDigest)
+
+		pushManifest(upstreamURL, "prod/app", "2.0", nil)
+		So(waitForManifest(downstreamURL, "mirror/app", "2.0"), ShouldBeTrue)
+
+		// repositories not selected by the content rules are not replicated
+		repos, err := downstream.StoreController.DefaultStore.GetRepositories()
+		So(err, ShouldBeNil)
+		So(repos, ShouldResemble, []string{"mirror/app"})
+
+		statuses := waitForStatus(upstreamURL, 3, 0)
+		So(statuses[0].Registry, ShouldEqual, downstreamURL)
+		So(statuses[0].ImagesSynced, ShouldEqual, 3)
+		So(statuses[0].BytesTransferred, ShouldBeGreaterThan, 0)
+		So(statuses[0].LastSync, ShouldNotBeNil)
+		So(statuses[0].FailureCount, ShouldEqual, 0)
+
+		// the downstream registry refuses to overwrite the immutable tag, it isn't retried
+		pushManifest(upstreamURL, "prod/app", "1.0", nil)
+
+		statuses = waitForStatus(upstreamURL, 3, 1)
+		So(statuses[0].ImagesSynced, ShouldEqual, 3)
+		So(statuses[0].FailureCount, ShouldEqual, 1)
+		So(len(statuses[0].Failures), ShouldEqual, 1)
+		So(statuses[0].Failures[0].Repo, ShouldEqual, "prod/app")
+		So(statuses[0].Failures[0].Reference, ShouldEqual, "1.0")
+		So(statuses[0].Failures[0].Conflict, ShouldBeTrue)
+		So(statuses[0].Failures[0].Reason, ShouldContainSubstring, "403")
+
+		resp, err = resty.R().Get(upstreamURL +
+			"/query?query={SyncStatus{Registry%20ImagesSynced%20FailureCount%20Failures{Repo%20Conflict}}}")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var result struct {
+			Data struct {
+				SyncStatus []struct {
+					Registry     string
+					ImagesSynced int
+					FailureCount int
+					Failures     []struct {
+						Repo     string
+						Conflict bool
+					}
+				}
+			}
+		}
+		So(json.Unmarshal(resp.Body(), &result), ShouldBeNil)
+		So(len(result.Data.SyncStatus), ShouldEqual, 1)
+		So(result.Data.SyncStatus[0].Registry, ShouldEqual, downstreamURL)
+		So(result.Data.SyncStatus[0].ImagesSynced, ShouldEqual, 3)
+		So(result.Data.SyncStatus[0].FailureCount, ShouldEqual, 1)
+		So(result.Data.SyncStatus[0].Failures[0].Repo, ShouldEqual, "prod/app")
+		So(result.Data.SyncStatus[0].Failures[0].Conflict, ShouldBeTrue)
+
+		// only quarantined images can be approved
+		resp, err = resty.R().Post(upstreamURL + "/v2/_zot/admin/sync/approve/prod/app?reference=2.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = resty.R().Post(upstreamURL + "/v2/_zot/admin/sync/approve/prod/app")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+	})
+
+	Convey("Images are quarantined by the CVE policy of the registry", t, func() {
+		upstreamDir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(upstreamDir)
+
+		downstreamDir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(downstreamDir)
+
+		upstreamPort := getFreePort()
+		downstreamPort := getFreePort()
+		upstreamURL := "http://127.0.0.1:" + upstreamPort
+		downstreamURL := "http://127.0.0.1:" + downstreamPort
+
+		upstreamConfig := api.NewConfig()
+		upstreamConfig.HTTP.Port = upstreamPort
+		upstreamConfig.Storage.RootDirectory = upstreamDir
+
+		upstream := startController(upstreamConfig)
+		defer func() {
+			_ = upstream.Server.Shutdown(context.Background())
+		}()
+
+		downstreamConfig := api.NewConfig()
+		downstreamConfig.HTTP.Port = downstreamPort
+		downstreamConfig.Storage.RootDirectory = downstreamDir
+
+		downstream := startController(downstreamConfig)
+		defer func() {
+			_ = downstream.Server.Shutdown(context.Background())
+		}()
+
+		scanner := func(ctx context.Context, repo, reference string) ([]string, error) {
+			switch reference {
+			case "1.0":
+				return []string{"LOW", "critical"}, nil
+			case "2.0":
+				return []string{"MEDIUM"}, nil
+			case "3.0":
+				return nil, errors.ErrScanNotSupported
+			default:
+				return nil, nil
+			}
+		}
+
+		replicator, err := sync.NewReplicator(&sync.Config{
+			Enable: true,
+			Registries: []sync.RegistryConfig{
+				{URL: downstreamURL, CVEPolicy: &sync.CVEPolicyConfig{Severity: "HIGH"}},
+			},
+		}, upstream.StoreController, scanner, log.NewLogger("debug", ""))
+		So(err, ShouldBeNil)
+		defer replicator.Stop()
+
+		for _, tag := range []string{"1.0", "2.0", "3.0"} {
+			pushManifest(upstreamURL, "app", tag, nil)
+			replicator.Notify("app", tag)
+		}
+
+		So(waitForManifest(downstreamURL, "quarantine/app", "1.0"), ShouldBeTrue)
+		So(waitForManifest(downstreamURL, "app", "2.0"), ShouldBeTrue)
+
+		var status sync.Status
+
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+			status = replicator.Status()[0]
+			if status.FailureCount > 0 {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		So(status.ImagesSynced, ShouldEqual, 2)
+		So(len(status.Quarantined), ShouldEqual, 1)
+		So(status.Quarantined[0].Repo, ShouldEqual, "app")
+		So(status.Quarantined[0].Reference, ShouldEqual, "1.0")
+		So(status.Quarantined[0].Severity, ShouldEqual, "CRITICAL")
+
+		// images which can't be scanned aren't replicated
+		So(status.FailureCount, ShouldEqual, 1)
+		So(status.Failures[0].Reference, ShouldEqual, "3.0")
+
+		resp, err := resty.R().Head(downstreamURL + "/v2/app/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		So(replicator.Approve("app", "2.0"), ShouldEqual, errors.ErrSyncNotQuarantined)
+		So(replicator.Approve("app", "1.0"), ShouldBeNil)
+		So(waitForManifest(downstreamURL, "app", "1.0"), ShouldBeTrue)
+
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+			status = replicator.Status()[0]
+			if len(status.Quarantined) == 0 {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		So(status.Quarantined, ShouldBeEmpty)
+		So(status.ImagesSynced, ShouldEqual, 3)
+	})
+
+	Convey("Images are replicated through the proxy of the registry", t, func() {
+		upstreamDir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(upstreamDir)
+
+		downstreamDir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(downstreamDir)
+
+		upstreamPort := getFreePort()
+		downstreamPort := getFreePort()
+		upstreamURL := "http://127.0.0.1:" + upstreamPort
+		downstreamURL := "http://127.0.0.1:" + downstreamPort
+
+		upstreamConfig := api.NewConfig()
+		upstreamConfig.HTTP.Port = upstreamPort
+		upstreamConfig.Storage.RootDirectory = upstreamDir
+
+		upstream := startController(upstreamConfig)
+		defer func() {
+			_ = upstream.Server.Shutdown(context.Background())
+		}()
+
+		downstreamConfig := api.NewConfig()
+		downstreamConfig.HTTP.Port = downstreamPort
+		downstreamConfig.Storage.RootDirectory = downstreamDir
+
+		downstream := startController(downstreamConfig)
+		defer func() {
+			_ = downstream.Server.Shutdown(context.Background())
+		}()
+
+		var proxied int32
+
+		// the registry is only reachable through the proxy, which forwards its requests to the downstream zot
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Host != "registry.invalid" {
+				w.WriteHeader(http.StatusBadGateway)
+
+				return
+			}
+
+			atomic.AddInt32(&proxied, 1)
+
+			r.URL.Host = "127.0.0.1:" + downstreamPort
+			r.RequestURI = ""
+
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+
+				return
+			}
+			defer resp.Body.Close()
+
+			for key, values := range resp.Header {
+				w.Header()[key] = values
+			}
+
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.Copy(w, resp.Body)
+		}))
+		defer proxy.Close()
+
+		replicator, err := sync.NewReplicator(&sync.Config{
+			Enable:     true,
+			Registries: []sync.RegistryConfig{{URL: "http://registry.invalid", Proxy: proxy.URL}},
+		}, upstream.StoreController, nil, log.NewLogger("debug", ""))
+		So(err, ShouldBeNil)
+		defer replicator.Stop()
+
+		pushManifest(upstreamURL, "app", "1.0", nil)
+		replicator.Notify("app", "1.0")
+
+		So(waitForManifest(downstreamURL, "app", "1.0"), ShouldBeTrue)
+		So(atomic.LoadInt32(&proxied), ShouldBeGreaterThan, 0)
+	})
+
+	Convey("Credentials are read from outside the zot config", t, func() {
+		upstreamDir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(upstreamDir)
+
+		downstreamDir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(downstreamDir)
+
+		credsDir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(credsDir)
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		So(err, ShouldBeNil)
+
+		htpasswd := path.Join(credsDir, "htpasswd")
+		So(ioutil.WriteFile(htpasswd, []byte(username+":"+string(hash)+"\n"), 0600), ShouldBeNil)
+
+		upstreamPort := getFreePort()
+		downstreamPort := getFreePort()
+		upstreamURL := "http://127.0.0.1:" + upstreamPort
+		downstreamURL := "http://127.0.0.1:" + downstreamPort
+
+		upstreamConfig := api.NewConfig()
+		upstreamConfig.HTTP.Port = upstreamPort
+		upstreamConfig.Storage.RootDirectory = upstreamDir
+
+		upstream := startController(upstreamConfig)
+		defer func() {
+			_ = upstream.Server.Shutdown(context.Background())
+		}()
+
+		downstreamConfig := api.NewConfig()
+		downstreamConfig.HTTP.Port = downstreamPort
+		downstreamConfig.HTTP.Auth = &api.AuthConfig{HTPasswd: api.AuthHTPasswd{Path: htpasswd}}
+		downstreamConfig.Storage.RootDirectory = downstreamDir
+
+		downstream := startController(downstreamConfig)
+		defer func() {
+			_ = downstream.Server.Shutdown(context.Background())
+		}()
+
+		// a secret whose password is rotated, a docker config.json and a credential helper
+		secretDir := path.Join(credsDir, "secret")
+		So(os.Mkdir(secretDir, 0700), ShouldBeNil)
+		So(ioutil.WriteFile(path.Join(secretDir, "username"), []byte(username+"\n"), 0600), ShouldBeNil)
+		So(ioutil.WriteFile(path.Join(secretDir, "password"), []byte("outdated\n"), 0600), ShouldBeNil)
+
+		dockerConfig := path.Join(credsDir, "config.json")
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		So(ioutil.WriteFile(dockerConfig, []byte(`{"auths":{"http://127.0.0.1:`+downstreamPort+`":{"auth":"`+auth+`"}}}`),
+			0600), ShouldBeNil)
+
+		helper := path.Join(credsDir, "docker-credential-test")
+		So(ioutil.WriteFile(helper, []byte("#!/bin/sh\nread host\n"+
+			`echo "{\"ServerURL\":\"$host\",\"Username\":\"`+username+`\",\"Secret\":\"`+password+`\"}"`+"\n"),
+			0700), ShouldBeNil) // nolint: gosec
+
+		replicator, err := sync.NewReplicator(&sync.Config{
+			Enable: true,
+			Registries: []sync.RegistryConfig{
+				{
+					URL:         downstreamURL,
+					Credentials: &sync.CredentialsConfig{SecretDir: secretDir},
+					Content:     []sync.ContentConfig{{Prefix: "secret"}},
+					MaxRetries:  1,
+					RetryDelay:  10 * time.Millisecond,
+				},
+				{
+					URL:         downstreamURL,
+					Credentials: &sync.CredentialsConfig{DockerConfig: dockerConfig},
+					Content:     []sync.ContentConfig{{Prefix: "docker"}},
+				},
+				{
+					URL:         downstreamURL,
+					Credentials: &sync.CredentialsConfig{Helper: helper},
+					Content:     []sync.ContentConfig{{Prefix: "helper"}},
+				},
+			},
+		}, upstream.StoreController, nil, log.NewLogger("debug", ""))
+		So(err, ShouldBeNil)
+		defer replicator.Stop()
+
+		for _, repo := range []string{"secret/app", "docker/app", "helper/app"} {
+			pushManifest(upstreamURL, repo, "1.0", nil)
+			replicator.Notify(repo, "1.0")
+		}
+
+		So(waitForManifest(downstreamURL, "docker/app", "1.0"), ShouldBeTrue)
+		So(waitForManifest(downstreamURL, "helper/app", "1.0"), ShouldBeTrue)
+
+		// the outdated password is refused until the secret is rotated
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+			if replicator.Status()[0].FailureCount > 0 {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		So(replicator.Status()[0].FailureCount, ShouldBeGreaterThan, 0)
+		So(ioutil.WriteFile(path.Join(secretDir, "password"), []byte(password+"\n"), 0600), ShouldBeNil)
+
+		pushManifest(upstreamURL, "secret/app", "2.0", nil)
+		replicator.Notify("secret/app", "2.0")
+		So(waitForManifest(downstreamURL, "secret/app", "2.0"), ShouldBeTrue)
+	})
+
+	Convey("Only the selected platforms of multi-arch images are replicated", t, func() {
+		upstreamDir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(upstreamDir)
+
+		downstreamDir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(downstreamDir)
+
+		downstreamConfig := api.NewConfig()
+		downstreamConfig.HTTP.Port = getFreePort()
+		downstreamConfig.Storage.RootDirectory = downstreamDir
+		downstreamURL := "http://127.0.0.1:" + downstreamConfig.HTTP.Port
+
+		downstream := startController(downstreamConfig)
+		defer func() {
+			_ = downstream.Server.Shutdown(context.Background())
+		}()
+
+		upstreamConfig := api.NewConfig()
+		upstreamConfig.HTTP.Port = getFreePort()
+		upstreamConfig.Storage.RootDirectory = upstreamDir
+		upstreamConfig.HTTP.AllowAdminAccess = true
+		upstreamConfig.Extensions = &ext.ExtensionConfig{
+			Sync: &sync.Config{
+				Enable: true,
+				Registries: []sync.RegistryConfig{
+					{URL: downstreamURL, Platforms: []string{"linux/amd64", "linux/arm/v7"}, MaxRetries: 1},
+				},
+			},
+		}
+		upstreamURL := "http://127.0.0.1:" + upstreamConfig.HTTP.Port
+
+		upstream := startController(upstreamConfig)
+		defer func() {
+			_ = upstream.Server.Shutdown(context.Background())
+		}()
+
+		pushIndex := func(tag string, platforms ...ispec.Platform) ([]byte, []godigest.Digest) {
+			index := ispec.Index{Annotations: map[string]string{"org.opencontainers.image.ref.name": tag}}
+			index.SchemaVersion = 2
+			digests := []godigest.Digest{}
+
+			// the manifests of the index are pushed first, by digest, their config has their platform
+			for i := range platforms {
+				config, err := json.Marshal(platforms[i])
+				So(err, ShouldBeNil)
+
+				manifest := ispec.Manifest{
+					Config: ispec.Descriptor{
+						MediaType: ispec.MediaTypeImageConfig,
+						Digest:    pushBlob(upstreamURL, "multi", config),
+						Size:      int64(len(config)),
+					},
+					Layers: []ispec.Descriptor{},
+				}
+				manifest.SchemaVersion = 2
+
+				content, err := json.Marshal(manifest)
+				So(err, ShouldBeNil)
+
+				digest := godigest.FromBytes(content)
+				digests = append(digests, digest)
+
+				resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageManifest).SetBody(content).
+					Put(upstreamURL + "/v2/multi/manifests/" + digest.String())
+				So(err, ShouldBeNil)
+				So(resp.StatusCode(), ShouldEqual, 201)
+
+				index.Manifests = append(index.Manifests, ispec.Descriptor{
+					MediaType: ispec.MediaTypeImageManifest,
+					Digest:    digest,
+					Size:      int64(len(content)),
+					Platform:  &platforms[i],
+				})
+			}
+
+			content, err := json.Marshal(index)
+			So(err, ShouldBeNil)
+
+			resp, err := resty.R().SetHeader("Content-Type", ispec.MediaTypeImageIndex).SetBody(content).
+				Put(upstreamURL + "/v2/multi/manifests/" + tag)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, 201)
+
+			return content, digests
+		}
+
+		original, digests := pushIndex("1.0", ispec.Platform{OS: "linux", Architecture: "amd64"},
+			ispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+			ispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+			ispec.Platform{OS: "linux", Architecture: "arm", Variant: "v6"})
+
+		So(waitForManifest(downstreamURL, "multi", "1.0"), ShouldBeTrue)
+
+		resp, err := resty.R().Get(downstreamURL + "/v2/multi/manifests/1.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		var index ispec.Index
+		So(json.Unmarshal(resp.Body(), &index), ShouldBeNil)
+		So(len(index.Manifests), ShouldEqual, 2)
+		So(index.Manifests[0].Digest, ShouldEqual, digests[0])
+		So(index.Manifests[1].Digest, ShouldEqual, digests[2])
+		So(index.Annotations[sync.AnnotationOriginalDigest], ShouldEqual, godigest.FromBytes(original).String())
+		So(index.Annotations["org.opencontainers.image.ref.name"], ShouldEqual, "1.0")
+
+		// the manifests of the other platforms aren't replicated
+		for i, digest := range digests {
+			resp, err := resty.R().Head(downstreamURL + "/v2/multi/manifests/" + digest.String())
+			So(err, ShouldBeNil)
+
+			if i%2 == 0 {
+				So(resp.StatusCode(), ShouldEqual, 200)
+			} else {
+				So(resp.StatusCode(), ShouldEqual, 404)
+			}
+		}
+
+		// an index of the selected platforms only is replicated as it is
+		original, _ = pushIndex("2.0", ispec.Platform{OS: "linux", Architecture: "amd64"})
+		So(waitForManifest(downstreamURL, "multi", "2.0"), ShouldBeTrue)
+
+		resp, err = resty.R().Get(downstreamURL + "/v2/multi/manifests/2.0")
+		So(err, ShouldBeNil)
+		So(resp.Body(), ShouldResemble, original)
+
+		// an index of none of them isn't, and isn't retried
+		pushIndex("3.0", ispec.Platform{OS: "windows", Architecture: "amd64"})
+
+		statuses := waitForStatus(upstreamURL, 2, 1)
+		So(statuses[0].FailureCount, ShouldEqual, 1)
+		So(statuses[0].Failures[0].Reason, ShouldContainSubstring, errors.ErrSyncPlatformNotFound.Error())
+		So(statuses[0].Failures[0].Conflict, ShouldBeFalse)
+
+		resp, err = resty.R().Head(downstreamURL + "/v2/multi/manifests/3.0")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+	})
+
+	Convey("Invalid sync configuration", t, func() {
+		dir, err := ioutil.TempDir("", "sync_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		_, err = sync.NewReplicator(&sync.Config{
+			Enable:     true,
+			Registries: []sync.RegistryConfig{{URL: "registry:5000"}},
+		}, storage.StoreController{}, nil, log.NewLogger("debug", ""))
+		So(err, ShouldNotBeNil)
+
+		_, err = sync.NewReplicator(&sync.Config{
+			Enable:     true,
+			Registries: []sync.RegistryConfig{{URL: "https://registry:5000", Proxy: "ftp://proxy:21"}},
+		}, storage.StoreController{}, nil, log.NewLogger("debug", ""))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "invalid proxy")
+
+		// credentials come from exactly one place
+		for _, regConfig := range []sync.RegistryConfig{
+			{URL: "https://registry:5000", Credentials: &sync.CredentialsConfig{}},
+			{URL: "https://registry:5000", Credentials: &sync.CredentialsConfig{SecretDir: dir, Helper: "helper"}},
+			{URL: "https://registry:5000", Username: username, Credentials: &sync.CredentialsConfig{SecretDir: dir}},
+		} {
+			_, err = sync.NewReplicator(&sync.Config{Enable: true, Registries: []sync.RegistryConfig{regConfig}},
+				storage.StoreController{}, nil, log.NewLogger("debug", ""))
+			So(err, ShouldNotBeNil)
+		}
+
+		// the cert dir has no CA certificate
+		_, err = sync.NewReplicator(&sync.Config{
+			Enable:     true,
+			Registries: []sync.RegistryConfig{{URL: "https://registry:5000", CertDir: dir}},
+		}, storage.StoreController{}, nil, log.NewLogger("debug", ""))
+		So(err, ShouldNotBeNil)
+
+		// platforms are os/arch[/variant]
+		for _, platform := range []string{"linux", "linux/", "linux/arm/v7/extra"} {
+			_, err = sync.NewReplicator(&sync.Config{
+				Enable:     true,
+				Registries: []sync.RegistryConfig{{URL: "https://registry:5000", Platforms: []string{platform}}},
+			}, storage.StoreController{}, nil, log.NewLogger("debug", ""))
+			So(err, ShouldNotBeNil)
+		}
+
+		// cve policies need a scanner, and a known severity
+		_, err = sync.NewReplicator(&sync.Config{
+			Enable: true,
+			Registries: []sync.RegistryConfig{
+				{URL: "https://registry:5000", CVEPolicy: &sync.CVEPolicyConfig{Severity: "HIGH"}},
+			},
+		}, storage.StoreController{}, nil, log.NewLogger("debug", ""))
+		So(err, ShouldEqual, errors.ErrSyncScanNotEnabled)
+
+		_, err = sync.NewReplicator(&sync.Config{
+			Enable: true,
+			Registries: []sync.RegistryConfig{
+				{URL: "https://registry:5000", CVEPolicy: &sync.CVEPolicyConfig{Severity: "SEVERE"}},
+			},
+		}, storage.StoreController{}, func(ctx context.Context, repo, reference string) ([]string, error) {
+			return nil, nil
+		}, log.NewLogger("debug", ""))
+		So(err, ShouldEqual, errors.ErrBadConfig)
+	})
+}
@@ -0,0 +1,289 @@
+package ui
+
+// assets are kept in source since go1.14 has no embed support, they must not contain backquotes.
+// nolint: gochecknoglobals
+var assets = map[string]string{
+	"/index.html": indexHTML,
+	"/app.js":     appJS,
+	"/style.css":  styleCSS,
+}
+
+const indexHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>zot</title>
+  <link rel="stylesheet" href="/ui/style.css">
+</head>
+<body>
+  <header>
+    <a href="#/" class="brand">zot</a>
+    <nav id="breadcrumbs"></nav>
+  </header>
+  <main>
+    <input id="filter" type="search" placeholder="Filter" autocomplete="off">
+    <div id="error" class="error" hidden></div>
+    <div id="content"></div>
+  </main>
+  <script src="/ui/app.js"></script>
+</body>
+</html>
+`
+
+const appJS = `(function () {
+  "use strict";
+
+  var content = document.getElementById("content");
+  var errorBox = document.getElementById("error");
+  var filter = document.getElementById("filter");
+  var breadcrumbs = document.getElementById("breadcrumbs");
+
+  function escapeHTML(s) {
+    return String(s === null || s === undefined ? "" : s).replace(/[&<>"']/g, function (c) {
+      return { "&": "&amp;", "<": "&lt;", ">": "&gt;", "\"": "&quot;", "'": "&#39;" }[c];
+    });
+  }
+
+  function showError(msg) {
+    errorBox.textContent = msg;
+    errorBox.hidden = !msg;
+  }
+
+  function request(url, options) {
+    options = options || {};
+    options.credentials = "same-origin";
+
+    return fetch(url, options).then(function (resp) {
+      if (!resp.ok) {
+        var err = new Error(resp.status + " " + resp.statusText);
+        err.status = resp.status;
+        throw err;
+      }
+
+      return resp.json();
+    });
+  }
+
+  function graphql(query) {
+    return request("/query", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ query: query })
+    }).then(function (body) {
+      if (body.errors && body.errors.length) {
+        throw new Error(body.errors[0].message);
+      }
+
+      return body.data;
+    });
+  }
+
+  function setBreadcrumbs(parts) {
+    var html = "";
+    var href = "#";
+
+    parts.forEach(function (part) {
+      href += "/" + encodeURIComponent(part);
+      html += " / <a href=\"" + escapeHTML(href) + "\">" + escapeHTML(part) + "</a>";
+    });
+
+    breadcrumbs.innerHTML = html;
+  }
+
+  function renderList(items, link) {
+    var needle = filter.value.toLowerCase();
+    var html = "<ul class=\"list\">";
+
+    items.filter(function (item) {
+      return item.toLowerCase().indexOf(needle) !== -1;
+    }).forEach(function (item) {
+      html += "<li><a href=\"" + escapeHTML(link(item)) + "\">" + escapeHTML(item) + "</a></li>";
+    });
+
+    if (items.length === 0) {
+      html += "<li class=\"empty\">nothing here</li>";
+    }
+
+    content.innerHTML = html + "</ul>";
+  }
+
+  function showRepos() {
+    setBreadcrumbs([]);
+
+    return request("/v2/_catalog").then(function (body) {
+      var repos = (body.repositories || []).sort();
+      var link = function (repo) { return "#/" + encodeURIComponent(repo); };
+
+      renderList(repos, link);
+      filter.oninput = function () { renderList(repos, link); };
+    });
+  }
+
+  function showTags(repo) {
+    setBreadcrumbs([repo]);
+
+    return request("/v2/" + repo + "/tags/list").then(function (body) {
+      var tags = (body.tags || []).sort();
+      var link = function (tag) { return "#/" + encodeURIComponent(repo) + "/" + encodeURIComponent(tag); };
+
+      renderList(tags, link);
+      filter.oninput = function () { renderList(tags, link); };
+    });
+  }
+
+  function showImage(repo, tag) {
+    setBreadcrumbs([repo, tag]);
+    filter.oninput = null;
+    content.innerHTML = "<p>Scanning " + escapeHTML(repo + ":" + tag) + "...</p>";
+
+    var query = "{ CVEListForImage(image: " + JSON.stringify(repo + ":" + tag) + ") " +
+      "{ Tag CVEList { Id Title Severity NormalizedSeverity PackageList { Name InstalledVersion FixedVersion } } } }";
+
+    return graphql(query).then(function (data) {
+      var cves = (data.CVEListForImage && data.CVEListForImage.CVEList) || [];
+      var needle = filter.value.toLowerCase();
+
+      if (cves.length === 0) {
+        content.innerHTML = "<p>No known vulnerabilities.</p>";
+        return;
+      }
+
+      var html = "<table><thead><tr><th>ID</th><th>Severity</th><th>Title</th><th>Packages</th></tr></thead>" +
+        "<tbody>";
+
+      cves.filter(function (cve) {
+        return (cve.Id + " " + cve.Title).toLowerCase().indexOf(needle) !== -1;
+      }).forEach(function (cve) {
+        var pkgs = (cve.PackageList || []).map(function (p) {
+          var fixed = p.FixedVersion ? " (fixed in " + p.FixedVersion + ")" : "";
+
+          return escapeHTML(p.Name + " " + p.InstalledVersion + fixed);
+        }).join("<br>");
+
+        var severity = escapeHTML(cve.NormalizedSeverity || cve.Severity);
+
+        html += "<tr><td>" + escapeHTML(cve.Id) + "</td>" +
+          "<td class=\"severity " + severity.toLowerCase() + "\">" + severity + "</td>" +
+          "<td>" + escapeHTML(cve.Title) + "</td><td>" + pkgs + "</td></tr>";
+      });
+
+      content.innerHTML = html + "</tbody></table>";
+    }).catch(function (err) {
+      if (err.status === 404) {
+        content.innerHTML = "<p>Vulnerability scanning is not enabled on this registry.</p>";
+        return;
+      }
+
+      throw err;
+    });
+  }
+
+  function route() {
+    var parts = window.location.hash.replace(/^#\/?/, "").split("/").filter(Boolean).map(decodeURIComponent);
+    var done;
+
+    showError("");
+    filter.value = "";
+
+    // repository names are URI encoded in links, so a slash always separates the repository from the tag
+    if (parts.length === 0) {
+      done = showRepos();
+    } else if (parts.length === 1) {
+      done = showTags(parts[0]);
+    } else {
+      done = showImage(parts[0], parts[1]);
+    }
+
+    done.catch(function (err) { showError(err.message); });
+  }
+
+  window.addEventListener("hashchange", route);
+  route();
+})();
+`
+
+const styleCSS = `body {
+  margin: 0;
+  font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif;
+  color: #222;
+  background: #fafafa;
+}
+
+header {
+  display: flex;
+  align-items: center;
+  padding: 0.75em 1.5em;
+  background: #2d3e50;
+  color: #fff;
+}
+
+header a {
+  color: #fff;
+  text-decoration: none;
+}
+
+.brand {
+  font-weight: bold;
+  font-size: 1.25em;
+  margin-right: 0.5em;
+}
+
+main {
+  padding: 1em 1.5em;
+}
+
+#filter {
+  width: 100%;
+  max-width: 30em;
+  padding: 0.4em;
+  margin-bottom: 1em;
+}
+
+.error {
+  padding: 0.5em;
+  margin-bottom: 1em;
+  background: #fdecea;
+  color: #a12622;
+}
+
+.list {
+  list-style: none;
+  padding: 0;
+}
+
+.list li {
+  padding: 0.4em 0;
+  border-bottom: 1px solid #eee;
+}
+
+.list a {
+  color: #1a5fb4;
+  text-decoration: none;
+}
+
+.empty {
+  color: #888;
+}
+
+table {
+  border-collapse: collapse;
+  width: 100%;
+}
+
+th, td {
+  text-align: left;
+  vertical-align: top;
+  padding: 0.4em;
+  border-bottom: 1px solid #eee;
+}
+
+.severity.critical, .severity.high {
+  color: #a12622;
+  font-weight: bold;
+}
+
+.severity.medium {
+  color: #b5651d;
+}
+`
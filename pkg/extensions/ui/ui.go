@@ -0,0 +1,44 @@
+// Package ui serves a small single-page registry browser built on top of the
+// dist-spec API and the search extension's GraphQL endpoint.
+package ui
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// RoutePrefix is where the UI is served.
+const RoutePrefix = "/ui"
+
+// nolint: gochecknoglobals
+var startTime = time.Now()
+
+// Handler serves the embedded UI assets, unknown paths fall back to the index page so
+// that client-side routes can be bookmarked.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, RoutePrefix))
+		if name == "/" {
+			name = "/index.html"
+		}
+
+		content, ok := assets[name]
+		if !ok {
+			name = "/index.html"
+			content = assets[name]
+		}
+
+		if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+			w.Header().Set("Content-Type", ctype)
+		}
+
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "DENY")
+
+		http.ServeContent(w, r, name, startTime, bytes.NewReader([]byte(content)))
+	})
+}
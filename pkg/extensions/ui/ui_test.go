@@ -0,0 +1,100 @@
+package ui_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/pkg/api"
+	ext "github.com/anuvu/zot/pkg/extensions"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/resty.v1"
+)
+
+const (
+	BaseURL = "http://127.0.0.1:8091"
+	Port    = "8091"
+)
+
+func startServer(extensions *ext.ExtensionConfig) func() {
+	dir, err := ioutil.TempDir("", "oci-repo-test")
+	if err != nil {
+		panic(err)
+	}
+
+	config := api.NewConfig()
+	config.HTTP.Port = Port
+	config.Storage.RootDirectory = dir
+	config.Extensions = extensions
+
+	c := api.NewController(config)
+
+	go func() {
+		// this blocks
+		if err := c.Run(); err != nil {
+			return
+		}
+	}()
+
+	// wait till ready
+	for {
+		_, err := resty.R().Get(BaseURL)
+		if err == nil {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return func() {
+		ctx := context.Background()
+		_ = c.Server.Shutdown(ctx)
+
+		os.RemoveAll(dir)
+	}
+}
+
+func TestUI(t *testing.T) {
+	Convey("UI is not served unless enabled", t, func() {
+		stop := startServer(&ext.ExtensionConfig{})
+		defer stop()
+
+		resp, err := resty.R().Get(BaseURL + "/ui/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+	})
+
+	Convey("UI is served when enabled", t, func() {
+		stop := startServer(&ext.ExtensionConfig{UI: &ext.UIConfig{Enable: true}})
+		defer stop()
+
+		resp, err := resty.R().Get(BaseURL + "/ui/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Content-Type"), ShouldStartWith, "text/html")
+		So(string(resp.Body()), ShouldContainSubstring, "/ui/app.js")
+
+		resp, err = resty.R().Get(BaseURL + "/ui/app.js")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Content-Type"), ShouldContainSubstring, "javascript")
+
+		resp, err = resty.R().Get(BaseURL + "/ui/style.css")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(resp.Header().Get("Content-Type"), ShouldStartWith, "text/css")
+
+		// client-side routes fall back to the index page
+		resp, err = resty.R().Get(BaseURL + "/ui/some/repo")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(string(resp.Body()), ShouldContainSubstring, "/ui/app.js")
+
+		resp, err = resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().Get(BaseURL + "/ui")
+		So(err, ShouldNotBeNil)
+		So(resp.StatusCode(), ShouldEqual, 301)
+		So(resp.Header().Get("Location"), ShouldEqual, "/ui/")
+	})
+}
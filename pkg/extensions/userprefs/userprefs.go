@@ -0,0 +1,218 @@
+package userprefs
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// RoutePrefix is where users star and bookmark repositories.
+	RoutePrefix = "/v2/_zot/ext/userprefs"
+
+	// ToggleStar and ToggleBookmark are the actions of the userprefs route.
+	ToggleStar     = "toggleStar"
+	ToggleBookmark = "toggleBookmark"
+
+	usersBucket     = "users"
+	starsBucket     = "stars"
+	bookmarksBucket = "bookmarks"
+)
+
+// UserPrefs keeps the repositories starred and bookmarked by each user in a bolt database,
+// a bucket per user holds a bucket of starred repositories and one of bookmarked repositories.
+type UserPrefs struct {
+	db  *bbolt.DB
+	log log.Logger
+}
+
+// NewUserPrefs opens the database of user preferences under the root directory.
+func NewUserPrefs(rootDir string, log log.Logger) (*UserPrefs, error) {
+	dbPath := path.Join(rootDir, "users.db")
+
+	db, err := bbolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		log.Error().Err(err).Str("dbPath", dbPath).Msg("unable to open user preferences db")
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(usersBucket))
+		return err
+	}); err != nil {
+		log.Error().Err(err).Str("dbPath", dbPath).Msg("unable to create a root bucket")
+		db.Close()
+
+		return nil, err
+	}
+
+	return &UserPrefs{db: db, log: log}, nil
+}
+
+// Close closes the database.
+func (up *UserPrefs) Close() error {
+	return up.db.Close()
+}
+
+// toggle adds the repository to the bucket of the user, or removes it if already there,
+// it returns true if the repository was added.
+func (up *UserPrefs) toggle(user, bucket, repo string) (bool, error) {
+	if user == "" {
+		return false, errors.ErrNoAuthenticatedUser
+	}
+
+	added := false
+
+	err := up.db.Update(func(tx *bbolt.Tx) error {
+		userBucket, err := tx.Bucket([]byte(usersBucket)).CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+
+		repos, err := userBucket.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		if repos.Get([]byte(repo)) != nil {
+			return repos.Delete([]byte(repo))
+		}
+
+		added = true
+
+		return repos.Put([]byte(repo), []byte{})
+	})
+
+	return added, err
+}
+
+// list returns the repositories in the bucket of the user, bolt keeps them sorted by name.
+func (up *UserPrefs) list(user, bucket string) ([]string, error) {
+	repos := []string{}
+
+	if user == "" {
+		return repos, nil
+	}
+
+	err := up.db.View(func(tx *bbolt.Tx) error {
+		userBucket := tx.Bucket([]byte(usersBucket)).Bucket([]byte(user))
+		if userBucket == nil {
+			return nil
+		}
+
+		bucket := userBucket.Bucket([]byte(bucket))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			repos = append(repos, string(k))
+			return nil
+		})
+	})
+
+	return repos, err
+}
+
+// contains returns true if the repository is in the bucket of the user.
+func (up *UserPrefs) contains(user, bucket, repo string) (bool, error) {
+	if user == "" {
+		return false, nil
+	}
+
+	found := false
+
+	err := up.db.View(func(tx *bbolt.Tx) error {
+		userBucket := tx.Bucket([]byte(usersBucket)).Bucket([]byte(user))
+		if userBucket == nil {
+			return nil
+		}
+
+		if bucket := userBucket.Bucket([]byte(bucket)); bucket != nil {
+			found = bucket.Get([]byte(repo)) != nil
+		}
+
+		return nil
+	})
+
+	return found, err
+}
+
+// ToggleStar stars the repository for the user, or unstars it, it returns true if starred.
+func (up *UserPrefs) ToggleStar(user, repo string) (bool, error) {
+	return up.toggle(user, starsBucket, repo)
+}
+
+// ToggleBookmark bookmarks the repository for the user, or removes the bookmark, it returns true if bookmarked.
+func (up *UserPrefs) ToggleBookmark(user, repo string) (bool, error) {
+	return up.toggle(user, bookmarksBucket, repo)
+}
+
+// StarredRepos returns the repositories starred by the user.
+func (up *UserPrefs) StarredRepos(user string) ([]string, error) {
+	return up.list(user, starsBucket)
+}
+
+// BookmarkedRepos returns the repositories bookmarked by the user.
+func (up *UserPrefs) BookmarkedRepos(user string) ([]string, error) {
+	return up.list(user, bookmarksBucket)
+}
+
+// IsStarred returns true if the user starred the repository.
+func (up *UserPrefs) IsStarred(user, repo string) (bool, error) {
+	return up.contains(user, starsBucket, repo)
+}
+
+// IsBookmarked returns true if the user bookmarked the repository.
+func (up *UserPrefs) IsBookmarked(user, repo string) (bool, error) {
+	return up.contains(user, bookmarksBucket, repo)
+}
+
+// Handler toggles the star or bookmark of a repository for the authenticated user,
+// e.g. PUT /v2/_zot/ext/userprefs?action=toggleStar&repo=alpine.
+func Handler(up *UserPrefs, storeController storage.StoreController) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := log.GetUsername(r.Context())
+		if user == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		repo := r.URL.Query().Get("repo")
+		if repo == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if ok, err := storeController.GetImageStore(repo).ValidateRepo(repo); !ok || err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var err error
+
+		switch r.URL.Query().Get("action") {
+		case ToggleStar:
+			_, err = up.ToggleStar(user, repo)
+		case ToggleBookmark:
+			_, err = up.ToggleBookmark(user, repo)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			logger := up.log.ForRequest(r)
+			logger.Error().Err(err).Str("repo", repo).Msg("unable to update user preferences")
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
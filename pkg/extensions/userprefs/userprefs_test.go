@@ -0,0 +1,311 @@
+// +build extended
+
+package userprefs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/api"
+	ext "github.com/anuvu/zot/pkg/extensions"
+	"github.com/anuvu/zot/pkg/extensions/userprefs"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/phayes/freeport"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/resty.v1"
+)
+
+type imageSummary struct {
+	RepoName     string   `json:"RepoName"`
+	Tags         []string `json:"Tags"`
+	IsStarred    bool     `json:"IsStarred"`
+	IsBookmarked bool     `json:"IsBookmarked"`
+	Deprecations []struct {
+		Tag         string `json:"Tag"`
+		Message     string `json:"Message"`
+		Replacement string `json:"Replacement"`
+	} `json:"Deprecations"`
+}
+
+type summariesResponse struct {
+	Data struct {
+		Starred    []imageSummary `json:"StarredRepos"`
+		Bookmarked []imageSummary `json:"BookmarkedRepos"`
+		Summary    *imageSummary  `json:"ImageSummaryForRepo"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func pushImage(imageStore *storage.ImageStore, repo, tag string) {
+	So(imageStore.InitRepo(repo), ShouldBeNil)
+
+	layer := []byte("this is a layer of " + repo + ":" + tag)
+	layerDigest := godigest.FromBytes(layer)
+	_, _, err := imageStore.FullBlobUpload(repo, bytes.NewReader(layer), layerDigest.String())
+	So(err, ShouldBeNil)
+
+	config, err := json.Marshal(ispec.Image{})
+	So(err, ShouldBeNil)
+
+	configDigest := godigest.FromBytes(config)
+	_, _, err = imageStore.FullBlobUpload(repo, bytes.NewReader(config), configDigest.String())
+	So(err, ShouldBeNil)
+
+	manifest := ispec.Manifest{
+		Config: ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    configDigest,
+			Size:      int64(len(config)),
+		},
+		Layers: []ispec.Descriptor{
+			{
+				MediaType: ispec.MediaTypeImageLayer,
+				Digest:    layerDigest,
+				Size:      int64(len(layer)),
+			},
+		},
+	}
+	manifest.SchemaVersion = 2
+
+	content, err := json.Marshal(manifest)
+	So(err, ShouldBeNil)
+
+	_, err = imageStore.PutImageManifest(repo, tag, ispec.MediaTypeImageManifest, content)
+	So(err, ShouldBeNil)
+}
+
+func TestUserPrefs(t *testing.T) {
+	Convey("Users star and bookmark repositories", t, func() {
+		dir, err := ioutil.TempDir("", "userprefs_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		up, err := userprefs.NewUserPrefs(dir, log.NewLogger("debug", ""))
+		So(err, ShouldBeNil)
+
+		repos, err := up.StarredRepos("alice")
+		So(err, ShouldBeNil)
+		So(repos, ShouldBeEmpty)
+
+		starred, err := up.ToggleStar("alice", "zot")
+		So(err, ShouldBeNil)
+		So(starred, ShouldBeTrue)
+
+		starred, err = up.ToggleStar("alice", "alpine")
+		So(err, ShouldBeNil)
+		So(starred, ShouldBeTrue)
+
+		bookmarked, err := up.ToggleBookmark("bob", "zot")
+		So(err, ShouldBeNil)
+		So(bookmarked, ShouldBeTrue)
+
+		repos, err = up.StarredRepos("alice")
+		So(err, ShouldBeNil)
+		So(repos, ShouldResemble, []string{"alpine", "zot"})
+
+		repos, err = up.BookmarkedRepos("alice")
+		So(err, ShouldBeNil)
+		So(repos, ShouldBeEmpty)
+
+		ok, err := up.IsStarred("bob", "zot")
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+
+		ok, err = up.IsBookmarked("bob", "zot")
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+
+		// toggling again removes the star
+		starred, err = up.ToggleStar("alice", "zot")
+		So(err, ShouldBeNil)
+		So(starred, ShouldBeFalse)
+
+		ok, err = up.IsStarred("alice", "zot")
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+
+		// anonymous users have no preferences
+		_, err = up.ToggleStar("", "zot")
+		So(err, ShouldEqual, errors.ErrNoAuthenticatedUser)
+
+		repos, err = up.StarredRepos("")
+		So(err, ShouldBeNil)
+		So(repos, ShouldBeEmpty)
+
+		ok, err = up.IsStarred("", "alpine")
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+
+		// preferences are kept across restarts
+		So(up.Close(), ShouldBeNil)
+
+		up, err = userprefs.NewUserPrefs(dir, log.NewLogger("debug", ""))
+		So(err, ShouldBeNil)
+		defer up.Close()
+
+		repos, err = up.StarredRepos("alice")
+		So(err, ShouldBeNil)
+		So(repos, ShouldResemble, []string{"alpine"})
+	})
+}
+
+func TestUserPrefsHTTP(t *testing.T) {
+	Convey("Users star and bookmark repositories through the API", t, func() {
+		dir, err := ioutil.TempDir("", "userprefs_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imageStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+		pushImage(imageStore, "alpine", "3.14")
+		pushImage(imageStore, "busybox", "latest")
+
+		hash, err := bcrypt.GenerateFromPassword([]byte("alice"), bcrypt.MinCost)
+		So(err, ShouldBeNil)
+
+		htpasswd, err := ioutil.TempFile("", "htpasswd-")
+		So(err, ShouldBeNil)
+		defer os.Remove(htpasswd.Name())
+
+		_, err = htpasswd.WriteString("alice:" + string(hash) + "\n")
+		So(err, ShouldBeNil)
+		So(htpasswd.Close(), ShouldBeNil)
+
+		port, err := freeport.GetFreePort()
+		So(err, ShouldBeNil)
+
+		baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+		config := api.NewConfig()
+		config.HTTP.Port = fmt.Sprint(port)
+		config.HTTP.AllowReadAccess = true
+		config.HTTP.Auth = &api.AuthConfig{HTPasswd: api.AuthHTPasswd{Path: htpasswd.Name()}}
+		config.Storage.RootDirectory = dir
+		config.Extensions = &ext.ExtensionConfig{
+			Search:    &ext.SearchConfig{Enable: true},
+			UserPrefs: &ext.UserPrefsConfig{Enable: true},
+		}
+
+		c := api.NewController(config)
+
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(baseURL)
+			if err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			_ = c.Server.Shutdown(context.Background())
+		}()
+
+		alice := func() *resty.Request {
+			return resty.R().SetBasicAuth("alice", "alice")
+		}
+
+		resp, err := alice().Put(baseURL + userprefs.RoutePrefix + "?action=toggleStar&repo=alpine")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = alice().Put(baseURL + userprefs.RoutePrefix + "?action=toggleBookmark&repo=busybox")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+
+		resp, err = alice().Put(baseURL + userprefs.RoutePrefix + "?action=toggleStar&repo=unknown")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 404)
+
+		resp, err = alice().Put(baseURL + userprefs.RoutePrefix + "?action=unknown&repo=alpine")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		resp, err = alice().Put(baseURL + userprefs.RoutePrefix + "?action=toggleStar")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 400)
+
+		resp, err = resty.R().Put(baseURL + userprefs.RoutePrefix + "?action=toggleStar&repo=alpine")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 401)
+
+		var summaries summariesResponse
+
+		query := "/query?query={StarredRepos{RepoName%20Tags%20IsStarred%20IsBookmarked}" +
+			"BookmarkedRepos{RepoName%20IsStarred%20IsBookmarked}}"
+
+		resp, err = alice().Get(baseURL + query)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &summaries), ShouldBeNil)
+		So(summaries.Errors, ShouldBeEmpty)
+		So(summaries.Data.Starred, ShouldResemble, []imageSummary{
+			{RepoName: "alpine", Tags: []string{"3.14"}, IsStarred: true},
+		})
+		So(summaries.Data.Bookmarked, ShouldResemble, []imageSummary{
+			{RepoName: "busybox", IsBookmarked: true},
+		})
+
+		resp, err = alice().Get(baseURL + "/query?query={ImageSummaryForRepo(repo:\"alpine\"){RepoName%20IsStarred}}")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &summaries), ShouldBeNil)
+		So(summaries.Data.Summary, ShouldNotBeNil)
+		So(summaries.Data.Summary.IsStarred, ShouldBeTrue)
+
+		// deprecations are listed with the summary
+		err = imageStore.SetDeprecation("alpine", "3.14", &storage.Deprecation{Message: "eol", Replacement: "alpine:3.15"})
+		So(err, ShouldBeNil)
+
+		resp, err = resty.R().Get(baseURL +
+			"/query?query={ImageSummaryForRepo(repo:\"alpine\"){Deprecations{Tag%20Message%20Replacement}}}")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &summaries), ShouldBeNil)
+		So(summaries.Errors, ShouldBeEmpty)
+		So(len(summaries.Data.Summary.Deprecations), ShouldEqual, 1)
+		So(summaries.Data.Summary.Deprecations[0].Tag, ShouldEqual, "3.14")
+		So(summaries.Data.Summary.Deprecations[0].Message, ShouldEqual, "eol")
+		So(summaries.Data.Summary.Deprecations[0].Replacement, ShouldEqual, "alpine:3.15")
+
+		// anonymous users have no preferences
+		summaries = summariesResponse{}
+
+		resp, err = resty.R().Get(baseURL + query)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &summaries), ShouldBeNil)
+		So(summaries.Errors, ShouldBeEmpty)
+		So(summaries.Data.Starred, ShouldBeEmpty)
+		So(summaries.Data.Bookmarked, ShouldBeEmpty)
+
+		// deleted repositories are not listed
+		So(os.RemoveAll(dir+"/alpine"), ShouldBeNil)
+
+		resp, err = alice().Get(baseURL + query)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, 200)
+		So(json.Unmarshal(resp.Body(), &summaries), ShouldBeNil)
+		So(summaries.Errors, ShouldBeEmpty)
+		So(summaries.Data.Starred, ShouldBeEmpty)
+	})
+}
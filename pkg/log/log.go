@@ -1,16 +1,34 @@
 package log
 
 import (
+	"context"
 	"encoding/base64"
+	"io"
+	"math/rand"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/anuvu/zot/errors"
+	guuid "github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
 )
 
+const (
+	// RequestIDHeader is used to correlate a request with the log entries produced while handling it.
+	RequestIDHeader = "X-Request-ID"
+	maxRequestIDLen = 128
+)
+
+type contextKey int
+
+const (
+	requestIDContextKey contextKey = iota
+	usernameContextKey
+)
+
 // Logger extends zerolog's Logger.
 type Logger struct {
 	zerolog.Logger
@@ -67,6 +85,72 @@ func NewAuditLogger(level string, audit string) *Logger {
 	return &Logger{Logger: auditLog.With().Timestamp().Logger()}
 }
 
+// ForRequest returns a logger which tags every entry with the request ID, if any.
+func (l Logger) ForRequest(r *http.Request) Logger {
+	requestID := GetRequestID(r.Context())
+	if requestID == "" {
+		return l
+	}
+
+	return Logger{Logger: l.With().Str("requestID", requestID).Logger()}
+}
+
+// GetRequestID returns the request ID stored in the context, empty if none.
+func GetRequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+
+	return requestID
+}
+
+// WithUsername returns a copy of the context which carries the authenticated user.
+func WithUsername(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, usernameContextKey, username)
+}
+
+// GetUsername returns the authenticated user stored in the context, empty if none.
+func GetUsername(ctx context.Context) string {
+	username, _ := ctx.Value(usernameContextKey).(string)
+
+	return username
+}
+
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLen {
+		return false
+	}
+
+	for _, c := range requestID {
+		// printable ASCII without spaces
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RequestID honors a well-formed incoming request ID or generates a new one, makes it
+// available to handlers and returns it to the client.
+func RequestID() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get(RequestIDHeader)
+			if !isValidRequestID(requestID) {
+				uuid, err := guuid.NewV4()
+				if err == nil {
+					requestID = uuid.String()
+				}
+			}
+
+			w.Header().Set(RequestIDHeader, requestID)
+
+			ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 type statusWriter struct {
 	http.ResponseWriter
 	status int
@@ -89,10 +173,126 @@ func (w *statusWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// ReadFrom lets blobs be sent with sendfile through the wrapped response.
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+
+	n, err := io.Copy(w.ResponseWriter, r)
+	w.length += int(n)
+
+	return n, err
+}
+
+// AccessConfig tunes the HTTP access log, only the request headers are logged if it isn't set.
+type AccessConfig struct {
+	RequestHeaders  bool
+	ResponseHeaders bool
+	// RedactHeaders are masked along with the Authorization, Proxy-Authorization, Cookie and Set-Cookie headers
+	RedactHeaders []string
+	// LatencyBuckets are ascending upper bounds, a request is tagged with the first one its latency is within
+	LatencyBuckets []time.Duration
+	// SampleRate is the fraction of successful requests which are logged, all of them if 0, failures always are
+	SampleRate float64
+}
+
+// Validate checks the sample rate and the latency buckets.
+func (ac *AccessConfig) Validate() error {
+	if ac.SampleRate < 0 || ac.SampleRate > 1 {
+		return errors.ErrBadConfig
+	}
+
+	for i, bucket := range ac.LatencyBuckets {
+		if bucket <= 0 || (i > 0 && bucket <= ac.LatencyBuckets[i-1]) {
+			return errors.ErrBadConfig
+		}
+	}
+
+	return nil
+}
+
+// sampled tells whether a request is logged, failed ones always are.
+func (ac *AccessConfig) sampled(statusCode int) bool {
+	if ac.SampleRate == 0 || statusCode >= http.StatusBadRequest {
+		return true
+	}
+
+	return rand.Float64() < ac.SampleRate // nolint: gosec
+}
+
+// latencyBucket returns the first bucket the latency is within, e.g. "<=100ms", or ">1s" past the last one.
+func (ac *AccessConfig) latencyBucket(latency time.Duration) string {
+	if len(ac.LatencyBuckets) == 0 {
+		return ""
+	}
+
+	for _, bucket := range ac.LatencyBuckets {
+		if latency <= bucket {
+			return "<=" + bucket.String()
+		}
+	}
+
+	return ">" + ac.LatencyBuckets[len(ac.LatencyBuckets)-1].String()
+}
+
+// redactedHeaders returns the canonical names of the headers masked in the access log.
+func (ac *AccessConfig) redactedHeaders() map[string]bool {
+	redacted := make(map[string]bool)
+
+	for _, headers := range [][]string{{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}, ac.RedactHeaders} {
+		for _, header := range headers {
+			redacted[http.CanonicalHeaderKey(header)] = true
+		}
+	}
+
+	return redacted
+}
+
+// redact returns a copy of the headers, the redacted ones masked.
+func redact(header http.Header, redacted map[string]bool) map[string][]string {
+	headers := make(map[string][]string, len(header))
+
+	for key, value := range header {
+		if redacted[http.CanonicalHeaderKey(key)] {
+			value = []string{"******"}
+		}
+
+		headers[key] = value
+	}
+
+	return headers
+}
+
+// basicAuthUsername returns the user of a request with basic authentication, empty otherwise.
+func basicAuthUsername(r *http.Request) string {
+	value := r.Header.Get("Authorization")
+
+	s := strings.SplitN(value, " ", 2)
+	if len(s) == 2 && strings.EqualFold(s[0], "basic") {
+		b, err := base64.StdEncoding.DecodeString(s[1])
+		if err == nil {
+			pair := strings.SplitN(string(b), ":", 2)
+			// nolint:gomnd
+			if len(pair) == 2 {
+				return pair[0]
+			}
+		}
+	}
+
+	return ""
+}
+
 // SessionLogger logs session details.
-func SessionLogger(log Logger) mux.MiddlewareFunc {
+func SessionLogger(log Logger, access *AccessConfig) mux.MiddlewareFunc {
 	l := log.With().Str("module", "http").Logger()
 
+	if access == nil {
+		access = &AccessConfig{RequestHeaders: true}
+	}
+
+	redacted := access.redactedHeaders()
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Start timer
@@ -105,6 +305,11 @@ func SessionLogger(log Logger) mux.MiddlewareFunc {
 			// Process request
 			next.ServeHTTP(&sw, r)
 
+			statusCode := sw.status
+			if !access.sampled(statusCode) {
+				return
+			}
+
 			// Stop timer
 			end := time.Now()
 			latency := end.Sub(start)
@@ -114,41 +319,36 @@ func SessionLogger(log Logger) mux.MiddlewareFunc {
 			}
 			clientIP := r.RemoteAddr
 			method := r.Method
-			headers := map[string][]string{}
-			username := ""
 			log := l.Info()
-			for key, value := range r.Header {
-				if key == "Authorization" { // anonymize from logs
-					s := strings.SplitN(value[0], " ", 2)
-					if len(s) == 2 && strings.EqualFold(s[0], "basic") {
-						b, err := base64.StdEncoding.DecodeString(s[1])
-						if err == nil {
-							pair := strings.SplitN(string(b), ":", 2)
-							// nolint:gomnd
-							if len(pair) == 2 {
-								username = pair[0]
-								log = log.Str("username", username)
-							}
-						}
-					}
-					value = []string{"******"}
-				}
-				headers[key] = value
+			if username := basicAuthUsername(r); username != "" {
+				log = log.Str("username", username)
 			}
-			statusCode := sw.status
 			bodySize := sw.length
 			if raw != "" {
 				path = path + "?" + raw
 			}
 
-			log.Str("clientIP", clientIP).
+			log = log.Str("requestID", GetRequestID(r.Context())).
+				Str("clientIP", clientIP).
 				Str("method", method).
 				Str("path", path).
 				Int("statusCode", statusCode).
 				Str("latency", latency.String()).
-				Int("bodySize", bodySize).
-				Interface("headers", headers).
-				Msg("HTTP API")
+				Int("bodySize", bodySize)
+
+			if bucket := access.latencyBucket(latency); bucket != "" {
+				log = log.Str("latencyBucket", bucket)
+			}
+
+			if access.RequestHeaders {
+				log = log.Interface("headers", redact(r.Header, redacted))
+			}
+
+			if access.ResponseHeaders {
+				log = log.Interface("responseHeaders", redact(w.Header(), redacted))
+			}
+
+			log.Msg("HTTP API")
 		})
 	}
 }
@@ -166,23 +366,7 @@ func SessionAuditLogger(audit *Logger) mux.MiddlewareFunc {
 
 			clientIP := r.RemoteAddr
 			method := r.Method
-			username := ""
-
-			for key, value := range r.Header {
-				if key == "Authorization" { // anonymize from logs
-					s := strings.SplitN(value[0], " ", 2)
-					if len(s) == 2 && strings.EqualFold(s[0], "basic") {
-						b, err := base64.StdEncoding.DecodeString(s[1])
-						if err == nil {
-							pair := strings.SplitN(string(b), ":", 2)
-							// nolint:gomnd
-							if len(pair) == 2 {
-								username = pair[0]
-							}
-						}
-					}
-				}
-			}
+			username := basicAuthUsername(r)
 
 			statusCode := sw.status
 			if raw != "" {
@@ -193,6 +377,7 @@ func SessionAuditLogger(audit *Logger) mux.MiddlewareFunc {
 				method == http.MethodPatch || method == http.MethodDelete) &&
 				(statusCode == http.StatusOK || statusCode == http.StatusCreated || statusCode == http.StatusAccepted) {
 				audit.Info().
+					Str("requestID", GetRequestID(r.Context())).
 					Str("clientIP", clientIP).
 					Str("subject", username).
 					Str("action", method).
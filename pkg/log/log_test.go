@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
 	"path"
@@ -17,6 +18,7 @@ import (
 	"time"
 
 	"github.com/anuvu/zot/pkg/api"
+	"github.com/anuvu/zot/pkg/log"
 	godigest "github.com/opencontainers/go-digest"
 	. "github.com/smartystreets/goconvey/convey"
 	"gopkg.in/resty.v1"
@@ -384,3 +386,196 @@ func TestAuditLogMessages(t *testing.T) {
 		})
 	})
 }
+
+func TestRequestID(t *testing.T) {
+	Convey("Make a new controller", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		config := api.NewConfig()
+
+		outputPath := dir + "/zot.log"
+		config.Log = &api.LogConfig{Level: "debug", Output: outputPath}
+
+		config.HTTP.Port = SecurePort
+
+		c := api.NewController(config)
+		c.Config.Storage.RootDirectory = dir
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(BaseURL)
+			if err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		Convey("Generate a request ID if none is provided", func() {
+			resp, err := resty.R().Get(BaseURL + "/v2/")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, http.StatusOK)
+			So(resp.Header().Get(log.RequestIDHeader), ShouldNotBeEmpty)
+
+			other, err := resty.R().Get(BaseURL + "/v2/")
+			So(err, ShouldBeNil)
+			So(other.Header().Get(log.RequestIDHeader), ShouldNotEqual, resp.Header().Get(log.RequestIDHeader))
+		})
+
+		Convey("Replace an invalid request ID", func() {
+			resp, err := resty.R().SetHeader(log.RequestIDHeader, "not a valid id").Get(BaseURL + "/v2/")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, http.StatusOK)
+			So(resp.Header().Get(log.RequestIDHeader), ShouldNotBeEmpty)
+			So(resp.Header().Get(log.RequestIDHeader), ShouldNotEqual, "not a valid id")
+		})
+
+		Convey("Honor an incoming request ID and tag log entries with it", func() {
+			requestID := "zot-test-request-id"
+			resp, err := resty.R().SetHeader(log.RequestIDHeader, requestID).
+				SetHeader("Content-Type", "application/vnd.oci.image.manifest.v1+json").
+				Put(BaseURL + "/v2/repo/manifests/latest")
+			So(err, ShouldBeNil)
+			So(resp.StatusCode(), ShouldEqual, http.StatusBadRequest)
+			So(resp.Header().Get(log.RequestIDHeader), ShouldEqual, requestID)
+
+			var storageEntry, sessionEntry bool
+
+			for i := 0; i < 50 && !(storageEntry && sessionEntry); i++ {
+				time.Sleep(100 * time.Millisecond)
+
+				content, err := ioutil.ReadFile(outputPath)
+				So(err, ShouldBeNil)
+
+				for _, line := range strings.Split(string(content), "\n") {
+					var entry map[string]interface{}
+					if json.Unmarshal([]byte(line), &entry) != nil || entry["requestID"] != requestID {
+						continue
+					}
+
+					switch entry["message"] {
+					case "invalid body length":
+						storageEntry = true
+					case "HTTP API":
+						sessionEntry = true
+					}
+				}
+			}
+
+			So(storageEntry, ShouldBeTrue)
+			So(sessionEntry, ShouldBeTrue)
+		})
+	})
+}
+
+func TestAccessLog(t *testing.T) {
+	Convey("Access log settings are validated", t, func() {
+		So((&log.AccessConfig{SampleRate: 0.5, LatencyBuckets: []time.Duration{time.Millisecond, time.Second}}).Validate(),
+			ShouldBeNil)
+		So((&log.AccessConfig{SampleRate: 2}).Validate(), ShouldNotBeNil)
+		So((&log.AccessConfig{LatencyBuckets: []time.Duration{time.Second, time.Millisecond}}).Validate(), ShouldNotBeNil)
+	})
+
+	Convey("Make a new controller with headers, latency buckets and sampling in the access log", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+
+		config := api.NewConfig()
+
+		outputPath := dir + "/zot.log"
+		config.Log = &api.LogConfig{Level: "debug", Output: outputPath, Access: &log.AccessConfig{
+			RequestHeaders:  true,
+			ResponseHeaders: true,
+			RedactHeaders:   []string{"x-secret"},
+			LatencyBuckets:  []time.Duration{time.Hour},
+			SampleRate:      math.SmallestNonzeroFloat64,
+		}}
+
+		config.HTTP.Port = SecurePort
+
+		c := api.NewController(config)
+		c.Config.Storage.RootDirectory = dir
+		go func() {
+			// this blocks
+			if err := c.Run(); err != nil {
+				return
+			}
+		}()
+
+		// wait till ready
+		for {
+			_, err := resty.R().Get(BaseURL)
+			if err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		defer func() {
+			ctx := context.Background()
+			_ = c.Server.Shutdown(ctx)
+		}()
+
+		// successful requests are sampled out
+		resp, err := resty.R().Get(BaseURL + "/v2/")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusOK)
+
+		resp, err = resty.R().SetBasicAuth(username, passphrase).SetHeader("X-Secret", "s3cr3t").
+			Get(BaseURL + "/v2/missing/manifests/latest")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode(), ShouldEqual, http.StatusNotFound)
+
+		var failed map[string]interface{}
+
+		for i := 0; i < 50 && failed == nil; i++ {
+			time.Sleep(100 * time.Millisecond)
+
+			content, err := ioutil.ReadFile(outputPath)
+			So(err, ShouldBeNil)
+
+			for _, line := range strings.Split(string(content), "\n") {
+				var entry map[string]interface{}
+				if json.Unmarshal([]byte(line), &entry) != nil || entry["message"] != "HTTP API" {
+					continue
+				}
+
+				So(entry["path"], ShouldNotEqual, "/v2/")
+
+				if entry["path"] == "/v2/missing/manifests/latest" {
+					failed = entry
+				}
+			}
+		}
+
+		So(failed, ShouldNotBeNil)
+		So(failed["username"], ShouldEqual, username)
+		So(failed["latencyBucket"], ShouldEqual, "<=1h0m0s")
+
+		headers, ok := failed["headers"].(map[string]interface{})
+		So(ok, ShouldBeTrue)
+		So(headers["Authorization"], ShouldResemble, []interface{}{"******"})
+		So(headers["X-Secret"], ShouldResemble, []interface{}{"******"})
+
+		responseHeaders, ok := failed["responseHeaders"].(map[string]interface{})
+		So(ok, ShouldBeTrue)
+		So(responseHeaders, ShouldContainKey, "Content-Type")
+	})
+}
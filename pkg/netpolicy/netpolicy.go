@@ -0,0 +1,203 @@
+// Package netpolicy restricts the operations of registry clients to their networks, e.g. pushes to the
+// subnets of a build farm, with CIDR allow and deny rules.
+package netpolicy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/anuvu/zot/errors"
+)
+
+// Operations the rules apply to.
+const (
+	Pull   = "pull"
+	Push   = "push"
+	Delete = "delete"
+	Admin  = "admin"
+)
+
+// Config lists the rules of the client networks. TrustedProxies are the networks of the reverse proxies
+// whose X-Forwarded-For header gives the address of their clients.
+type Config struct {
+	Rules          []RuleConfig
+	TrustedProxies []string
+}
+
+// RuleConfig allows and denies networks, e.g. "10.0.0.0/8" or "192.168.1.10", for some operations, all
+// of them if none is given. Denied networks win over allowed ones, and once some networks are allowed
+// the others are denied.
+type RuleConfig struct {
+	Operations []string
+	Allow      []string
+	Deny       []string
+}
+
+type rule struct {
+	operations map[string]bool
+	allow      []*net.IPNet
+	deny       []*net.IPNet
+}
+
+func (r rule) appliesTo(operation string) bool {
+	return len(r.operations) == 0 || r.operations[operation]
+}
+
+// Policy checks the addresses of the clients against the rules of their operations, and counts the
+// rejected requests of each operation.
+type Policy struct {
+	rules          []rule
+	trustedProxies []*net.IPNet
+	lock           sync.Mutex
+	rejected       map[string]uint64
+}
+
+// NewPolicy parses the networks of the rules.
+func NewPolicy(config *Config) (*Policy, error) {
+	p := &Policy{rejected: make(map[string]uint64)}
+
+	for _, rc := range config.Rules {
+		r := rule{operations: make(map[string]bool)}
+
+		for _, operation := range rc.Operations {
+			operation = strings.ToLower(operation)
+
+			switch operation {
+			case Pull, Push, Delete, Admin:
+				r.operations[operation] = true
+			default:
+				return nil, fmt.Errorf("%w: unknown operation %q", errors.ErrBadConfig, operation)
+			}
+		}
+
+		var err error
+
+		if r.allow, err = parseNetworks(rc.Allow); err != nil {
+			return nil, err
+		}
+
+		if r.deny, err = parseNetworks(rc.Deny); err != nil {
+			return nil, err
+		}
+
+		p.rules = append(p.rules, r)
+	}
+
+	proxies, err := parseNetworks(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	p.trustedProxies = proxies
+
+	return p, nil
+}
+
+// parseNetworks parses CIDR networks, or single addresses.
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(networks))
+
+	for _, network := range networks {
+		if !strings.Contains(network, "/") {
+			ip := net.ParseIP(network)
+			if ip == nil {
+				return nil, fmt.Errorf("%w: invalid network %q", errors.ErrBadConfig, network)
+			}
+
+			if ip.To4() != nil {
+				network += "/32"
+			} else {
+				network += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid network %q", errors.ErrBadConfig, network)
+		}
+
+		ipNets = append(ipNets, ipNet)
+	}
+
+	return ipNets, nil
+}
+
+func contains(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the address of the client of a connection from remoteAddr, the address of the client
+// of a trusted proxy being the last untrusted one of its X-Forwarded-For header. It's nil for unix sockets.
+func (p *Policy) ClientIP(remoteAddr, forwardedFor string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(p.trustedProxies, ip) || forwardedFor == "" {
+		return ip
+	}
+
+	hops := strings.Split(forwardedFor, ",")
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return ip
+		}
+
+		ip = hop
+
+		if !contains(p.trustedProxies, ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// Allowed tells whether a client may do an operation, counting the rejected ones. Clients without an IP
+// address, on unix sockets, are always allowed.
+func (p *Policy) Allowed(operation string, ip net.IP) bool {
+	if ip == nil {
+		return true
+	}
+
+	for _, r := range p.rules {
+		if !r.appliesTo(operation) {
+			continue
+		}
+
+		if contains(r.deny, ip) || (len(r.allow) != 0 && !contains(r.allow, ip)) {
+			p.lock.Lock()
+			p.rejected[operation]++
+			p.lock.Unlock()
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// Rejected returns the number of rejected requests of each operation.
+func (p *Policy) Rejected() map[string]uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	rejected := make(map[string]uint64, len(p.rejected))
+	for operation, count := range p.rejected {
+		rejected[operation] = count
+	}
+
+	return rejected
+}
@@ -0,0 +1,58 @@
+package netpolicy_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/anuvu/zot/pkg/netpolicy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPolicy(t *testing.T) {
+	Convey("Invalid rules are refused", t, func() {
+		_, err := netpolicy.NewPolicy(&netpolicy.Config{Rules: []netpolicy.RuleConfig{{Operations: []string{"copy"}}}})
+		So(err, ShouldNotBeNil)
+
+		_, err = netpolicy.NewPolicy(&netpolicy.Config{Rules: []netpolicy.RuleConfig{{Allow: []string{"10.0.0.0/33"}}}})
+		So(err, ShouldNotBeNil)
+
+		_, err = netpolicy.NewPolicy(&netpolicy.Config{TrustedProxies: []string{"proxy"}})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Operations are restricted to their networks", t, func() {
+		p, err := netpolicy.NewPolicy(&netpolicy.Config{
+			Rules: []netpolicy.RuleConfig{
+				{Operations: []string{"Push"}, Allow: []string{"10.0.0.0/8", "2001:db8::/32"}, Deny: []string{"10.6.6.6"}},
+				{Operations: []string{"admin"}, Allow: []string{"127.0.0.1"}},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		So(p.Allowed(netpolicy.Push, net.ParseIP("10.1.2.3")), ShouldBeTrue)
+		So(p.Allowed(netpolicy.Push, net.ParseIP("::ffff:10.1.2.3")), ShouldBeTrue)
+		So(p.Allowed(netpolicy.Push, net.ParseIP("2001:db8::1")), ShouldBeTrue)
+		So(p.Allowed(netpolicy.Push, net.ParseIP("10.6.6.6")), ShouldBeFalse)
+		So(p.Allowed(netpolicy.Push, net.ParseIP("172.16.0.1")), ShouldBeFalse)
+		So(p.Allowed(netpolicy.Pull, net.ParseIP("172.16.0.1")), ShouldBeTrue)
+		So(p.Allowed(netpolicy.Admin, net.ParseIP("10.1.2.3")), ShouldBeFalse)
+		So(p.Allowed(netpolicy.Admin, net.ParseIP("127.0.0.1")), ShouldBeTrue)
+
+		// unix sockets
+		So(p.Allowed(netpolicy.Push, nil), ShouldBeTrue)
+
+		So(p.Rejected(), ShouldResemble, map[string]uint64{netpolicy.Push: 2, netpolicy.Admin: 1})
+	})
+
+	Convey("Clients of trusted proxies are found in X-Forwarded-For", t, func() {
+		p, err := netpolicy.NewPolicy(&netpolicy.Config{TrustedProxies: []string{"10.0.0.0/24"}})
+		So(err, ShouldBeNil)
+
+		So(p.ClientIP("192.168.1.1:1234", "10.1.1.1").String(), ShouldEqual, "192.168.1.1")
+		So(p.ClientIP("10.0.0.1:1234", "").String(), ShouldEqual, "10.0.0.1")
+		So(p.ClientIP("10.0.0.1:1234", "1.2.3.4, 192.168.1.1, 10.0.0.2").String(), ShouldEqual, "192.168.1.1")
+		So(p.ClientIP("10.0.0.1:1234", "garbage").String(), ShouldEqual, "10.0.0.1")
+		So(p.ClientIP("[::1]:1234", "").String(), ShouldEqual, "::1")
+		So(p.ClientIP("@", ""), ShouldBeNil)
+	})
+}
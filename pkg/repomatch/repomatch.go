@@ -0,0 +1,51 @@
+// Package repomatch matches repository names with path.Match patterns, e.g. "prod/*".
+package repomatch
+
+import "path"
+
+// Validate returns path.ErrBadPattern if one of the patterns is malformed.
+func Validate(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Matches returns true if the repository matches one of the patterns, all repositories match if there are none.
+func Matches(patterns []string, repo string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, repo); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Longest returns the most specific of the patterns matching the repository, i.e. the longest one, the first
+// in lexical order among patterns of the same length, false if none matches.
+func Longest(patterns []string, repo string) (string, bool) {
+	var longest string
+
+	found := false
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, repo); !ok {
+			continue
+		}
+
+		if !found || len(pattern) > len(longest) || (len(pattern) == len(longest) && pattern < longest) {
+			longest = pattern
+			found = true
+		}
+	}
+
+	return longest, found
+}
@@ -0,0 +1,48 @@
+package repomatch_test
+
+import (
+	"path"
+	"testing"
+
+	"github.com/anuvu/zot/pkg/repomatch"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidate(t *testing.T) {
+	Convey("Malformed patterns are rejected", t, func() {
+		So(repomatch.Validate(nil), ShouldBeNil)
+		So(repomatch.Validate([]string{"prod/*", "dev/app"}), ShouldBeNil)
+		So(repomatch.Validate([]string{"prod/*", "["}), ShouldEqual, path.ErrBadPattern)
+	})
+}
+
+func TestMatches(t *testing.T) {
+	Convey("Repositories match any of the patterns", t, func() {
+		So(repomatch.Matches(nil, "prod/app"), ShouldBeTrue)
+		So(repomatch.Matches([]string{"dev/*", "prod/*"}, "prod/app"), ShouldBeTrue)
+		So(repomatch.Matches([]string{"dev/*"}, "prod/app"), ShouldBeFalse)
+		So(repomatch.Matches([]string{"dev/*"}, "dev/team/app"), ShouldBeFalse)
+	})
+}
+
+func TestLongest(t *testing.T) {
+	Convey("The most specific pattern wins", t, func() {
+		_, ok := repomatch.Longest(nil, "prod/app")
+		So(ok, ShouldBeFalse)
+
+		_, ok = repomatch.Longest([]string{"dev/*"}, "prod/app")
+		So(ok, ShouldBeFalse)
+
+		pattern, ok := repomatch.Longest([]string{"*/*", "prod/*", "prod/app"}, "prod/app")
+		So(ok, ShouldBeTrue)
+		So(pattern, ShouldEqual, "prod/app")
+
+		Convey("Patterns of the same length are ordered lexically", func() {
+			for _, patterns := range [][]string{{"prod/*", "*/app*"}, {"*/app*", "prod/*"}} {
+				pattern, ok := repomatch.Longest(patterns, "prod/app")
+				So(ok, ShouldBeTrue)
+				So(pattern, ShouldEqual, "*/app*")
+			}
+		})
+	})
+}
@@ -0,0 +1,5 @@
+// Package rpc holds the protobuf types and gRPC services of the search and admin gRPC API,
+// generated from zot.proto with protoc, protoc-gen-go and protoc-gen-go-grpc.
+package rpc
+
+//go:generate protoc --go_out=paths=source_relative:. --go-grpc_out=paths=source_relative:. zot.proto
@@ -0,0 +1,727 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.17.3
+// source: zot.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// SearchClient is the client API for Search service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SearchClient interface {
+	// CVEListForImage scans an image, "repo:tag", for vulnerabilities.
+	CVEListForImage(ctx context.Context, in *CVEListForImageRequest, opts ...grpc.CallOption) (*CVEListForImageResponse, error)
+	// ImageListForCVE lists the images affected by a vulnerability.
+	ImageListForCVE(ctx context.Context, in *ImageListForCVERequest, opts ...grpc.CallOption) (*ImageListForCVEResponse, error)
+	// ImageListWithCVEFixed lists the tags of a repository in which a vulnerability is fixed.
+	ImageListWithCVEFixed(ctx context.Context, in *ImageListWithCVEFixedRequest, opts ...grpc.CallOption) (*ImageListWithCVEFixedResponse, error)
+	// ImageList lists the tagged images of a repository, or of all repositories.
+	ImageList(ctx context.Context, in *ImageListRequest, opts ...grpc.CallOption) (*ImageListResponse, error)
+	// ImageListForDigest lists the images with a manifest, config or layer digest starting with the given one.
+	ImageListForDigest(ctx context.Context, in *ImageListForDigestRequest, opts ...grpc.CallOption) (*ImageListForDigestResponse, error)
+	// ImageListForAnnotation lists the images with the given annotation.
+	ImageListForAnnotation(ctx context.Context, in *ImageListForAnnotationRequest, opts ...grpc.CallOption) (*ImageListForAnnotationResponse, error)
+	// ImageListByPopularity lists the repositories by pull count, most pulled first.
+	ImageListByPopularity(ctx context.Context, in *ImageListByPopularityRequest, opts ...grpc.CallOption) (*ImageListByPopularityResponse, error)
+}
+
+type searchClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSearchClient(cc grpc.ClientConnInterface) SearchClient {
+	return &searchClient{cc}
+}
+
+func (c *searchClient) CVEListForImage(ctx context.Context, in *CVEListForImageRequest, opts ...grpc.CallOption) (*CVEListForImageResponse, error) {
+	out := new(CVEListForImageResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Search/CVEListForImage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchClient) ImageListForCVE(ctx context.Context, in *ImageListForCVERequest, opts ...grpc.CallOption) (*ImageListForCVEResponse, error) {
+	out := new(ImageListForCVEResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Search/ImageListForCVE", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchClient) ImageListWithCVEFixed(ctx context.Context, in *ImageListWithCVEFixedRequest, opts ...grpc.CallOption) (*ImageListWithCVEFixedResponse, error) {
+	out := new(ImageListWithCVEFixedResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Search/ImageListWithCVEFixed", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchClient) ImageList(ctx context.Context, in *ImageListRequest, opts ...grpc.CallOption) (*ImageListResponse, error) {
+	out := new(ImageListResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Search/ImageList", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchClient) ImageListForDigest(ctx context.Context, in *ImageListForDigestRequest, opts ...grpc.CallOption) (*ImageListForDigestResponse, error) {
+	out := new(ImageListForDigestResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Search/ImageListForDigest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchClient) ImageListForAnnotation(ctx context.Context, in *ImageListForAnnotationRequest, opts ...grpc.CallOption) (*ImageListForAnnotationResponse, error) {
+	out := new(ImageListForAnnotationResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Search/ImageListForAnnotation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchClient) ImageListByPopularity(ctx context.Context, in *ImageListByPopularityRequest, opts ...grpc.CallOption) (*ImageListByPopularityResponse, error) {
+	out := new(ImageListByPopularityResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Search/ImageListByPopularity", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SearchServer is the server API for Search service.
+// All implementations must embed UnimplementedSearchServer
+// for forward compatibility
+type SearchServer interface {
+	// CVEListForImage scans an image, "repo:tag", for vulnerabilities.
+	CVEListForImage(context.Context, *CVEListForImageRequest) (*CVEListForImageResponse, error)
+	// ImageListForCVE lists the images affected by a vulnerability.
+	ImageListForCVE(context.Context, *ImageListForCVERequest) (*ImageListForCVEResponse, error)
+	// ImageListWithCVEFixed lists the tags of a repository in which a vulnerability is fixed.
+	ImageListWithCVEFixed(context.Context, *ImageListWithCVEFixedRequest) (*ImageListWithCVEFixedResponse, error)
+	// ImageList lists the tagged images of a repository, or of all repositories.
+	ImageList(context.Context, *ImageListRequest) (*ImageListResponse, error)
+	// ImageListForDigest lists the images with a manifest, config or layer digest starting with the given one.
+	ImageListForDigest(context.Context, *ImageListForDigestRequest) (*ImageListForDigestResponse, error)
+	// ImageListForAnnotation lists the images with the given annotation.
+	ImageListForAnnotation(context.Context, *ImageListForAnnotationRequest) (*ImageListForAnnotationResponse, error)
+	// ImageListByPopularity lists the repositories by pull count, most pulled first.
+	ImageListByPopularity(context.Context, *ImageListByPopularityRequest) (*ImageListByPopularityResponse, error)
+	mustEmbedUnimplementedSearchServer()
+}
+
+// UnimplementedSearchServer must be embedded to have forward compatible implementations.
+type UnimplementedSearchServer struct {
+}
+
+func (UnimplementedSearchServer) CVEListForImage(context.Context, *CVEListForImageRequest) (*CVEListForImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CVEListForImage not implemented")
+}
+func (UnimplementedSearchServer) ImageListForCVE(context.Context, *ImageListForCVERequest) (*ImageListForCVEResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImageListForCVE not implemented")
+}
+func (UnimplementedSearchServer) ImageListWithCVEFixed(context.Context, *ImageListWithCVEFixedRequest) (*ImageListWithCVEFixedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImageListWithCVEFixed not implemented")
+}
+func (UnimplementedSearchServer) ImageList(context.Context, *ImageListRequest) (*ImageListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImageList not implemented")
+}
+func (UnimplementedSearchServer) ImageListForDigest(context.Context, *ImageListForDigestRequest) (*ImageListForDigestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImageListForDigest not implemented")
+}
+func (UnimplementedSearchServer) ImageListForAnnotation(context.Context, *ImageListForAnnotationRequest) (*ImageListForAnnotationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImageListForAnnotation not implemented")
+}
+func (UnimplementedSearchServer) ImageListByPopularity(context.Context, *ImageListByPopularityRequest) (*ImageListByPopularityResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImageListByPopularity not implemented")
+}
+func (UnimplementedSearchServer) mustEmbedUnimplementedSearchServer() {}
+
+// UnsafeSearchServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SearchServer will
+// result in compilation errors.
+type UnsafeSearchServer interface {
+	mustEmbedUnimplementedSearchServer()
+}
+
+func RegisterSearchServer(s grpc.ServiceRegistrar, srv SearchServer) {
+	s.RegisterService(&Search_ServiceDesc, srv)
+}
+
+func _Search_CVEListForImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CVEListForImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServer).CVEListForImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Search/CVEListForImage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServer).CVEListForImage(ctx, req.(*CVEListForImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Search_ImageListForCVE_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImageListForCVERequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServer).ImageListForCVE(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Search/ImageListForCVE",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServer).ImageListForCVE(ctx, req.(*ImageListForCVERequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Search_ImageListWithCVEFixed_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImageListWithCVEFixedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServer).ImageListWithCVEFixed(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Search/ImageListWithCVEFixed",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServer).ImageListWithCVEFixed(ctx, req.(*ImageListWithCVEFixedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Search_ImageList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImageListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServer).ImageList(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Search/ImageList",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServer).ImageList(ctx, req.(*ImageListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Search_ImageListForDigest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImageListForDigestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServer).ImageListForDigest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Search/ImageListForDigest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServer).ImageListForDigest(ctx, req.(*ImageListForDigestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Search_ImageListForAnnotation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImageListForAnnotationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServer).ImageListForAnnotation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Search/ImageListForAnnotation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServer).ImageListForAnnotation(ctx, req.(*ImageListForAnnotationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Search_ImageListByPopularity_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImageListByPopularityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServer).ImageListByPopularity(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Search/ImageListByPopularity",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServer).ImageListByPopularity(ctx, req.(*ImageListByPopularityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Search_ServiceDesc is the grpc.ServiceDesc for Search service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Search_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "zot.v1.Search",
+	HandlerType: (*SearchServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CVEListForImage",
+			Handler:    _Search_CVEListForImage_Handler,
+		},
+		{
+			MethodName: "ImageListForCVE",
+			Handler:    _Search_ImageListForCVE_Handler,
+		},
+		{
+			MethodName: "ImageListWithCVEFixed",
+			Handler:    _Search_ImageListWithCVEFixed_Handler,
+		},
+		{
+			MethodName: "ImageList",
+			Handler:    _Search_ImageList_Handler,
+		},
+		{
+			MethodName: "ImageListForDigest",
+			Handler:    _Search_ImageListForDigest_Handler,
+		},
+		{
+			MethodName: "ImageListForAnnotation",
+			Handler:    _Search_ImageListForAnnotation_Handler,
+		},
+		{
+			MethodName: "ImageListByPopularity",
+			Handler:    _Search_ImageListByPopularity_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "zot.proto",
+}
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminClient interface {
+	// GetSchedulerStatus returns the state of the background tasks scheduler.
+	GetSchedulerStatus(ctx context.Context, in *GetSchedulerStatusRequest, opts ...grpc.CallOption) (*GetSchedulerStatusResponse, error)
+	// GetSyncStatus returns the replication status of each downstream registry of the sync extension.
+	GetSyncStatus(ctx context.Context, in *GetSyncStatusRequest, opts ...grpc.CallOption) (*GetSyncStatusResponse, error)
+	// ApproveSyncedImage releases an image quarantined by the CVE policy of sync.
+	ApproveSyncedImage(ctx context.Context, in *ApproveSyncedImageRequest, opts ...grpc.CallOption) (*ApproveSyncedImageResponse, error)
+	// SetDeprecation deprecates a repository, or one of its tags.
+	SetDeprecation(ctx context.Context, in *SetDeprecationRequest, opts ...grpc.CallOption) (*SetDeprecationResponse, error)
+	// DeleteDeprecation removes the deprecation of a repository, or of one of its tags.
+	DeleteDeprecation(ctx context.Context, in *DeleteDeprecationRequest, opts ...grpc.CallOption) (*DeleteDeprecationResponse, error)
+	// GetDedupeReport returns the logical and physical size of the blobs of each image store.
+	GetDedupeReport(ctx context.Context, in *GetDedupeReportRequest, opts ...grpc.CallOption) (*GetDedupeReportResponse, error)
+	// Rededupe replaces the copies of each blob with hard links, in the image stores with dedupe enabled.
+	Rededupe(ctx context.Context, in *RededupeRequest, opts ...grpc.CallOption) (*RededupeResponse, error)
+	// GetTieringReport returns the blobs moved to the cold tier of each image store with tiering enabled.
+	GetTieringReport(ctx context.Context, in *GetTieringReportRequest, opts ...grpc.CallOption) (*GetTieringReportResponse, error)
+	// TierBlobs moves the layers which weren't pulled recently to the cold tier.
+	TierBlobs(ctx context.Context, in *TierBlobsRequest, opts ...grpc.CallOption) (*TierBlobsResponse, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) GetSchedulerStatus(ctx context.Context, in *GetSchedulerStatusRequest, opts ...grpc.CallOption) (*GetSchedulerStatusResponse, error) {
+	out := new(GetSchedulerStatusResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Admin/GetSchedulerStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetSyncStatus(ctx context.Context, in *GetSyncStatusRequest, opts ...grpc.CallOption) (*GetSyncStatusResponse, error) {
+	out := new(GetSyncStatusResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Admin/GetSyncStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ApproveSyncedImage(ctx context.Context, in *ApproveSyncedImageRequest, opts ...grpc.CallOption) (*ApproveSyncedImageResponse, error) {
+	out := new(ApproveSyncedImageResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Admin/ApproveSyncedImage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) SetDeprecation(ctx context.Context, in *SetDeprecationRequest, opts ...grpc.CallOption) (*SetDeprecationResponse, error) {
+	out := new(SetDeprecationResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Admin/SetDeprecation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DeleteDeprecation(ctx context.Context, in *DeleteDeprecationRequest, opts ...grpc.CallOption) (*DeleteDeprecationResponse, error) {
+	out := new(DeleteDeprecationResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Admin/DeleteDeprecation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetDedupeReport(ctx context.Context, in *GetDedupeReportRequest, opts ...grpc.CallOption) (*GetDedupeReportResponse, error) {
+	out := new(GetDedupeReportResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Admin/GetDedupeReport", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Rededupe(ctx context.Context, in *RededupeRequest, opts ...grpc.CallOption) (*RededupeResponse, error) {
+	out := new(RededupeResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Admin/Rededupe", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetTieringReport(ctx context.Context, in *GetTieringReportRequest, opts ...grpc.CallOption) (*GetTieringReportResponse, error) {
+	out := new(GetTieringReportResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Admin/GetTieringReport", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) TierBlobs(ctx context.Context, in *TierBlobsRequest, opts ...grpc.CallOption) (*TierBlobsResponse, error) {
+	out := new(TierBlobsResponse)
+	err := c.cc.Invoke(ctx, "/zot.v1.Admin/TierBlobs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility
+type AdminServer interface {
+	// GetSchedulerStatus returns the state of the background tasks scheduler.
+	GetSchedulerStatus(context.Context, *GetSchedulerStatusRequest) (*GetSchedulerStatusResponse, error)
+	// GetSyncStatus returns the replication status of each downstream registry of the sync extension.
+	GetSyncStatus(context.Context, *GetSyncStatusRequest) (*GetSyncStatusResponse, error)
+	// ApproveSyncedImage releases an image quarantined by the CVE policy of sync.
+	ApproveSyncedImage(context.Context, *ApproveSyncedImageRequest) (*ApproveSyncedImageResponse, error)
+	// SetDeprecation deprecates a repository, or one of its tags.
+	SetDeprecation(context.Context, *SetDeprecationRequest) (*SetDeprecationResponse, error)
+	// DeleteDeprecation removes the deprecation of a repository, or of one of its tags.
+	DeleteDeprecation(context.Context, *DeleteDeprecationRequest) (*DeleteDeprecationResponse, error)
+	// GetDedupeReport returns the logical and physical size of the blobs of each image store.
+	GetDedupeReport(context.Context, *GetDedupeReportRequest) (*GetDedupeReportResponse, error)
+	// Rededupe replaces the copies of each blob with hard links, in the image stores with dedupe enabled.
+	Rededupe(context.Context, *RededupeRequest) (*RededupeResponse, error)
+	// GetTieringReport returns the blobs moved to the cold tier of each image store with tiering enabled.
+	GetTieringReport(context.Context, *GetTieringReportRequest) (*GetTieringReportResponse, error)
+	// TierBlobs moves the layers which weren't pulled recently to the cold tier.
+	TierBlobs(context.Context, *TierBlobsRequest) (*TierBlobsResponse, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (UnimplementedAdminServer) GetSchedulerStatus(context.Context, *GetSchedulerStatusRequest) (*GetSchedulerStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSchedulerStatus not implemented")
+}
+func (UnimplementedAdminServer) GetSyncStatus(context.Context, *GetSyncStatusRequest) (*GetSyncStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSyncStatus not implemented")
+}
+func (UnimplementedAdminServer) ApproveSyncedImage(context.Context, *ApproveSyncedImageRequest) (*ApproveSyncedImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ApproveSyncedImage not implemented")
+}
+func (UnimplementedAdminServer) SetDeprecation(context.Context, *SetDeprecationRequest) (*SetDeprecationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetDeprecation not implemented")
+}
+func (UnimplementedAdminServer) DeleteDeprecation(context.Context, *DeleteDeprecationRequest) (*DeleteDeprecationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteDeprecation not implemented")
+}
+func (UnimplementedAdminServer) GetDedupeReport(context.Context, *GetDedupeReportRequest) (*GetDedupeReportResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDedupeReport not implemented")
+}
+func (UnimplementedAdminServer) Rededupe(context.Context, *RededupeRequest) (*RededupeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rededupe not implemented")
+}
+func (UnimplementedAdminServer) GetTieringReport(context.Context, *GetTieringReportRequest) (*GetTieringReportResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTieringReport not implemented")
+}
+func (UnimplementedAdminServer) TierBlobs(context.Context, *TierBlobsRequest) (*TierBlobsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TierBlobs not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_GetSchedulerStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSchedulerStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetSchedulerStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Admin/GetSchedulerStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetSchedulerStatus(ctx, req.(*GetSchedulerStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetSyncStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSyncStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetSyncStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Admin/GetSyncStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetSyncStatus(ctx, req.(*GetSyncStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ApproveSyncedImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApproveSyncedImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ApproveSyncedImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Admin/ApproveSyncedImage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ApproveSyncedImage(ctx, req.(*ApproveSyncedImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_SetDeprecation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetDeprecationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).SetDeprecation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Admin/SetDeprecation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).SetDeprecation(ctx, req.(*SetDeprecationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DeleteDeprecation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteDeprecationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DeleteDeprecation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Admin/DeleteDeprecation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DeleteDeprecation(ctx, req.(*DeleteDeprecationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetDedupeReport_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDedupeReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetDedupeReport(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Admin/GetDedupeReport",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetDedupeReport(ctx, req.(*GetDedupeReportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Rededupe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RededupeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Rededupe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Admin/Rededupe",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Rededupe(ctx, req.(*RededupeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetTieringReport_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTieringReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetTieringReport(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Admin/GetTieringReport",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetTieringReport(ctx, req.(*GetTieringReportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_TierBlobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TierBlobsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).TierBlobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/zot.v1.Admin/TierBlobs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).TierBlobs(ctx, req.(*TierBlobsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "zot.v1.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetSchedulerStatus",
+			Handler:    _Admin_GetSchedulerStatus_Handler,
+		},
+		{
+			MethodName: "GetSyncStatus",
+			Handler:    _Admin_GetSyncStatus_Handler,
+		},
+		{
+			MethodName: "ApproveSyncedImage",
+			Handler:    _Admin_ApproveSyncedImage_Handler,
+		},
+		{
+			MethodName: "SetDeprecation",
+			Handler:    _Admin_SetDeprecation_Handler,
+		},
+		{
+			MethodName: "DeleteDeprecation",
+			Handler:    _Admin_DeleteDeprecation_Handler,
+		},
+		{
+			MethodName: "GetDedupeReport",
+			Handler:    _Admin_GetDedupeReport_Handler,
+		},
+		{
+			MethodName: "Rededupe",
+			Handler:    _Admin_Rededupe_Handler,
+		},
+		{
+			MethodName: "GetTieringReport",
+			Handler:    _Admin_GetTieringReport_Handler,
+		},
+		{
+			MethodName: "TierBlobs",
+			Handler:    _Admin_TierBlobs_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "zot.proto",
+}
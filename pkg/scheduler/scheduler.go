@@ -0,0 +1,451 @@
+package scheduler
+
+import (
+	"context"
+	goerrors "errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/log"
+)
+
+// Task is a unit of background work (GC, CVE database updates, etc).
+type Task interface {
+	DoWork() error
+}
+
+type Priority int
+
+const (
+	LowPriority Priority = iota
+	MediumPriority
+	HighPriority
+)
+
+func (p Priority) String() string {
+	switch p {
+	case LowPriority:
+		return "low"
+	case MediumPriority:
+		return "medium"
+	case HighPriority:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	numPriorities     = 3
+	queueSize         = 1000
+	defaultMaxTasks   = 2
+	loadCheckInterval = 500 * time.Millisecond
+)
+
+// Config controls how much background work is allowed to run alongside API traffic.
+type Config struct {
+	// MaxConcurrentTasks is the number of tasks which may run at the same time, defaults to 2
+	MaxConcurrentTasks int
+	// HighLoadRequests is the number of in-flight API requests above which only
+	// high priority tasks are started, 0 disables throttling
+	HighLoadRequests int64
+}
+
+// WithDefaults returns a copy of the config, nil being the default one, with the defaults of its unset fields.
+func (c *Config) WithDefaults() *Config {
+	config := Config{}
+
+	if c != nil {
+		config = *c
+	}
+
+	if config.MaxConcurrentTasks <= 0 {
+		config.MaxConcurrentTasks = defaultMaxTasks
+	}
+
+	return &config
+}
+
+// Status is a snapshot of the scheduler state.
+type Status struct {
+	MaxConcurrentTasks int            `json:"maxConcurrentTasks"`
+	RunningTasks       int64          `json:"runningTasks"`
+	QueuedTasks        map[string]int `json:"queuedTasks"`
+	CompletedTasks     uint64         `json:"completedTasks"`
+	FailedTasks        uint64         `json:"failedTasks"`
+	InFlightRequests   int64          `json:"inFlightRequests"`
+	ActiveUploads      int64          `json:"activeUploads"`
+	ActiveDownloads    int64          `json:"activeDownloads"`
+	BytesInFlight      int64          `json:"bytesInFlight"`
+	Paused             bool           `json:"paused"`
+}
+
+// PeriodicOptions tune the schedule of a periodic task.
+type PeriodicOptions struct {
+	// Jitter is the maximum random delay added to each interval, so that the servers started together
+	// don't run the task at the same time
+	Jitter time.Duration
+	// RetryDelay is the delay before a failed occurrence is retried, doubled after each consecutive
+	// failure up to the interval, failed occurrences are only retried at the next interval if 0
+	RetryDelay time.Duration
+}
+
+// PeriodicStatus is a snapshot of the state of a periodic task.
+type PeriodicStatus struct {
+	LastRun             *time.Time `json:"lastRun,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	NextRun             time.Time  `json:"nextRun"`
+	Pending             bool       `json:"pending"`
+}
+
+// PeriodicTask is a task submitted with SubmitPeriodicTask, which can also be run on demand.
+type PeriodicTask struct {
+	task     Task
+	interval time.Duration
+	options  PeriodicOptions
+	priority Priority
+	pending  int32
+	done     chan error
+	sch      *Scheduler
+	lock     sync.Mutex
+	status   PeriodicStatus
+}
+
+// DoWork runs the wrapped task and allows the next occurrence to be queued.
+func (pt *PeriodicTask) DoWork() error {
+	defer atomic.StoreInt32(&pt.pending, 0)
+
+	err := pt.task.DoWork()
+
+	pt.lock.Lock()
+	now := time.Now()
+	pt.status.LastRun = &now
+
+	if err != nil {
+		pt.status.LastError = err.Error()
+		pt.status.ConsecutiveFailures++
+	} else {
+		pt.status.LastError = ""
+		pt.status.ConsecutiveFailures = 0
+	}
+	pt.lock.Unlock()
+
+	// the schedule only needs the latest result
+	select {
+	case pt.done <- err:
+	default:
+	}
+
+	return err
+}
+
+// Trigger queues an occurrence of the task right away, it's not queued twice if one is already
+// queued or running.
+func (pt *PeriodicTask) Trigger() error {
+	if !atomic.CompareAndSwapInt32(&pt.pending, 0, 1) {
+		return errors.ErrSchedulerTaskPending
+	}
+
+	if err := pt.sch.SubmitTask(pt, pt.priority); err != nil {
+		atomic.StoreInt32(&pt.pending, 0)
+		return err
+	}
+
+	return nil
+}
+
+// Status returns a snapshot of the state of the task.
+func (pt *PeriodicTask) Status() PeriodicStatus {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+
+	status := pt.status
+	status.Pending = atomic.LoadInt32(&pt.pending) == 1
+
+	return status
+}
+
+// nextDelay returns the delay before the next occurrence, after a successful or a failed one.
+func (pt *PeriodicTask) nextDelay(failed bool) time.Duration {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+
+	delay := pt.interval
+
+	if failed && pt.options.RetryDelay > 0 {
+		delay = pt.options.RetryDelay
+		for i := 1; i < pt.status.ConsecutiveFailures && delay < pt.interval; i++ {
+			delay *= 2
+		}
+
+		if delay > pt.interval {
+			delay = pt.interval
+		}
+	} else if pt.options.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(pt.options.Jitter)))
+	}
+
+	pt.status.NextRun = time.Now().Add(delay)
+
+	return delay
+}
+
+// Scheduler runs background tasks by priority with a bounded number of workers,
+// lower priority tasks are held back while the server is busy serving requests.
+type Scheduler struct {
+	queues           [numPriorities]chan Task
+	workers          chan struct{}
+	notify           chan struct{}
+	maxTasks         int
+	highLoadRequests int64
+	inFlight         int64
+	uploads          int64
+	downloads        int64
+	bytesInFlight    int64
+	running          int64
+	completed        uint64
+	failed           uint64
+	lock             sync.Mutex
+	ctx              context.Context
+	periodic         []*PeriodicTask
+	log              log.Logger
+}
+
+// NewScheduler returns a scheduler, tasks are only executed once RunScheduler is called.
+func NewScheduler(config *Config, logger log.Logger) *Scheduler {
+	config = config.WithDefaults()
+
+	s := &Scheduler{
+		workers:          make(chan struct{}, config.MaxConcurrentTasks),
+		notify:           make(chan struct{}, 1),
+		maxTasks:         config.MaxConcurrentTasks,
+		highLoadRequests: config.HighLoadRequests,
+		log:              log.Logger{Logger: logger.With().Str("component", "scheduler").Logger()},
+	}
+
+	for i := range s.queues {
+		s.queues[i] = make(chan Task, queueSize)
+	}
+
+	return s
+}
+
+// SubmitTask queues a task for execution.
+func (s *Scheduler) SubmitTask(task Task, priority Priority) error {
+	if priority < LowPriority || priority > HighPriority {
+		return errors.ErrSchedulerBadPriority
+	}
+
+	select {
+	case s.queues[priority] <- task:
+	default:
+		s.log.Warn().Str("priority", priority.String()).Msg("task queue is full, dropping task")
+		return errors.ErrSchedulerQueueFull
+	}
+
+	s.wakeUp()
+
+	return nil
+}
+
+// SubmitPeriodicTask queues the task right away and then every interval, an occurrence
+// is skipped if the previous one is still queued or running.
+func (s *Scheduler) SubmitPeriodicTask(task Task, interval time.Duration, priority Priority) *PeriodicTask {
+	return s.SubmitPeriodicTaskWithOptions(task, interval, priority, PeriodicOptions{})
+}
+
+// SubmitPeriodicTaskWithOptions is SubmitPeriodicTask with a jittered schedule and retries of failed occurrences.
+func (s *Scheduler) SubmitPeriodicTaskWithOptions(task Task, interval time.Duration, priority Priority,
+	options PeriodicOptions) *PeriodicTask {
+	pt := &PeriodicTask{task: task, interval: interval, options: options, priority: priority,
+		done: make(chan error, 1), sch: s}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.periodic = append(s.periodic, pt)
+
+	if s.ctx != nil {
+		go s.runPeriodic(s.ctx, pt)
+	}
+
+	return pt
+}
+
+// RunScheduler dispatches queued tasks until the context is cancelled.
+func (s *Scheduler) RunScheduler(ctx context.Context) {
+	s.lock.Lock()
+	s.ctx = ctx
+
+	for _, pt := range s.periodic {
+		go s.runPeriodic(ctx, pt)
+	}
+	s.lock.Unlock()
+
+	ticker := time.NewTicker(loadCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		// wait for a free worker
+		select {
+		case <-ctx.Done():
+			return
+		case s.workers <- struct{}{}:
+		}
+
+		task := s.nextTask()
+		if task == nil {
+			<-s.workers
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.notify:
+			case <-ticker.C:
+			}
+
+			continue
+		}
+
+		atomic.AddInt64(&s.running, 1)
+
+		go func(task Task) {
+			defer func() {
+				atomic.AddInt64(&s.running, -1)
+				<-s.workers
+				s.wakeUp()
+			}()
+
+			if err := task.DoWork(); err != nil {
+				atomic.AddUint64(&s.failed, 1)
+				s.log.Error().Err(err).Msg("task failed")
+
+				return
+			}
+
+			atomic.AddUint64(&s.completed, 1)
+		}(task)
+	}
+}
+
+// RequestStarted records an in-flight API request.
+func (s *Scheduler) RequestStarted() {
+	atomic.AddInt64(&s.inFlight, 1)
+}
+
+// RequestFinished records the completion of an API request.
+func (s *Scheduler) RequestFinished() {
+	if atomic.AddInt64(&s.inFlight, -1) <= s.highLoadRequests {
+		s.wakeUp()
+	}
+}
+
+// TransferStarted records an in-flight blob upload or download of size bytes, not counted in the bytes in
+// flight if unknown (negative), and returns the func recording its end.
+func (s *Scheduler) TransferStarted(upload bool, size int64) func() {
+	counter := &s.downloads
+	if upload {
+		counter = &s.uploads
+	}
+
+	if size < 0 {
+		size = 0
+	}
+
+	atomic.AddInt64(counter, 1)
+	atomic.AddInt64(&s.bytesInFlight, size)
+
+	return func() {
+		atomic.AddInt64(counter, -1)
+		atomic.AddInt64(&s.bytesInFlight, -size)
+	}
+}
+
+// Status returns a snapshot of the scheduler state.
+func (s *Scheduler) Status() Status {
+	status := Status{
+		MaxConcurrentTasks: s.maxTasks,
+		RunningTasks:       atomic.LoadInt64(&s.running),
+		QueuedTasks:        make(map[string]int),
+		CompletedTasks:     atomic.LoadUint64(&s.completed),
+		FailedTasks:        atomic.LoadUint64(&s.failed),
+		InFlightRequests:   atomic.LoadInt64(&s.inFlight),
+		ActiveUploads:      atomic.LoadInt64(&s.uploads),
+		ActiveDownloads:    atomic.LoadInt64(&s.downloads),
+		BytesInFlight:      atomic.LoadInt64(&s.bytesInFlight),
+		Paused:             s.isHighLoad(),
+	}
+
+	for i := range s.queues {
+		status.QueuedTasks[Priority(i).String()] = len(s.queues[i])
+	}
+
+	return status
+}
+
+func (s *Scheduler) isHighLoad() bool {
+	return s.highLoadRequests > 0 && atomic.LoadInt64(&s.inFlight) > s.highLoadRequests
+}
+
+// nextTask picks the highest priority queued task, only high priority tasks run under load.
+func (s *Scheduler) nextTask() Task {
+	lowest := LowPriority
+	if s.isHighLoad() {
+		lowest = HighPriority
+	}
+
+	for p := HighPriority; p >= lowest; p-- {
+		select {
+		case task := <-s.queues[p]:
+			return task
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (s *Scheduler) runPeriodic(ctx context.Context, pt *PeriodicTask) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if err := pt.Trigger(); goerrors.Is(err, errors.ErrSchedulerTaskPending) {
+				s.log.Debug().Msg("previous occurrence of periodic task still pending, skipping")
+			}
+
+			timer.Reset(pt.nextDelay(false))
+		case err := <-pt.done:
+			if err == nil || pt.options.RetryDelay == 0 {
+				continue
+			}
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+
+			delay := pt.nextDelay(true)
+
+			s.log.Warn().Err(err).Str("retryIn", delay.String()).Msg("periodic task failed, retrying")
+			timer.Reset(delay)
+		}
+	}
+}
+
+func (s *Scheduler) wakeUp() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,215 @@
+package scheduler_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/scheduler"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type task struct {
+	name string
+	lock *sync.Mutex
+	done *[]string
+	wait chan struct{}
+}
+
+func (t *task) DoWork() error {
+	if t.wait != nil {
+		<-t.wait
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	*t.done = append(*t.done, t.name)
+
+	return nil
+}
+
+type failingTask struct{}
+
+func (t *failingTask) DoWork() error {
+	return errors.ErrBadConfig
+}
+
+func TestScheduler(t *testing.T) {
+	logger := log.NewLogger("debug", "")
+
+	Convey("Tasks are run by priority", t, func() {
+		var lock sync.Mutex
+
+		done := []string{}
+
+		sch := scheduler.NewScheduler(&scheduler.Config{MaxConcurrentTasks: 1}, logger)
+
+		So(sch.SubmitTask(&task{name: "low", lock: &lock, done: &done}, scheduler.LowPriority), ShouldBeNil)
+		So(sch.SubmitTask(&task{name: "medium", lock: &lock, done: &done}, scheduler.MediumPriority), ShouldBeNil)
+		So(sch.SubmitTask(&task{name: "high", lock: &lock, done: &done}, scheduler.HighPriority), ShouldBeNil)
+		So(sch.SubmitTask(&failingTask{}, scheduler.LowPriority), ShouldBeNil)
+		So(sch.SubmitTask(&failingTask{}, scheduler.Priority(5)), ShouldEqual, errors.ErrSchedulerBadPriority)
+
+		status := sch.Status()
+		So(status.QueuedTasks["low"], ShouldEqual, 2)
+		So(status.QueuedTasks["medium"], ShouldEqual, 1)
+		So(status.QueuedTasks["high"], ShouldEqual, 1)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go sch.RunScheduler(ctx)
+
+		So(waitFor(func() bool { return sch.Status().CompletedTasks+sch.Status().FailedTasks == 4 }), ShouldBeTrue)
+
+		lock.Lock()
+		So(done, ShouldResemble, []string{"high", "medium", "low"})
+		lock.Unlock()
+
+		So(sch.Status().FailedTasks, ShouldEqual, 1)
+	})
+
+	Convey("Only high priority tasks run under load", t, func() {
+		var lock sync.Mutex
+
+		done := []string{}
+
+		sch := scheduler.NewScheduler(&scheduler.Config{HighLoadRequests: 1}, logger)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go sch.RunScheduler(ctx)
+
+		sch.RequestStarted()
+		sch.RequestStarted()
+		So(sch.Status().Paused, ShouldBeTrue)
+		So(sch.Status().InFlightRequests, ShouldEqual, 2)
+
+		So(sch.SubmitTask(&task{name: "low", lock: &lock, done: &done}, scheduler.LowPriority), ShouldBeNil)
+		So(sch.SubmitTask(&task{name: "high", lock: &lock, done: &done}, scheduler.HighPriority), ShouldBeNil)
+
+		So(waitFor(func() bool { return sch.Status().CompletedTasks == 1 }), ShouldBeTrue)
+		So(sch.Status().QueuedTasks["low"], ShouldEqual, 1)
+
+		sch.RequestFinished()
+		So(sch.Status().Paused, ShouldBeFalse)
+
+		So(waitFor(func() bool { return sch.Status().CompletedTasks == 2 }), ShouldBeTrue)
+
+		lock.Lock()
+		So(done, ShouldResemble, []string{"high", "low"})
+		lock.Unlock()
+	})
+
+	Convey("Blob transfers in flight are counted", t, func() {
+		sch := scheduler.NewScheduler(nil, logger)
+
+		upload := sch.TransferStarted(true, 100)
+		streamed := sch.TransferStarted(true, -1)
+		download := sch.TransferStarted(false, 20)
+
+		status := sch.Status()
+		So(status.ActiveUploads, ShouldEqual, 2)
+		So(status.ActiveDownloads, ShouldEqual, 1)
+		So(status.BytesInFlight, ShouldEqual, 120)
+
+		upload()
+		streamed()
+
+		status = sch.Status()
+		So(status.ActiveUploads, ShouldEqual, 0)
+		So(status.ActiveDownloads, ShouldEqual, 1)
+		So(status.BytesInFlight, ShouldEqual, 20)
+
+		download()
+		So(sch.Status().BytesInFlight, ShouldEqual, 0)
+	})
+
+	Convey("Periodic tasks are not queued twice", t, func() {
+		var lock sync.Mutex
+
+		done := []string{}
+		wait := make(chan struct{})
+
+		sch := scheduler.NewScheduler(nil, logger)
+		sch.SubmitPeriodicTask(&task{name: "periodic", lock: &lock, done: &done, wait: wait},
+			10*time.Millisecond, scheduler.LowPriority)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go sch.RunScheduler(ctx)
+
+		So(waitFor(func() bool { return sch.Status().RunningTasks == 1 }), ShouldBeTrue)
+		time.Sleep(50 * time.Millisecond)
+		So(sch.Status().QueuedTasks["low"], ShouldEqual, 0)
+
+		close(wait)
+
+		So(waitFor(func() bool { return sch.Status().CompletedTasks >= 2 }), ShouldBeTrue)
+	})
+
+	Convey("Failed periodic tasks are retried with backoff", t, func() {
+		sch := scheduler.NewScheduler(nil, logger)
+		pt := sch.SubmitPeriodicTaskWithOptions(&failingTask{}, time.Hour, scheduler.LowPriority,
+			scheduler.PeriodicOptions{Jitter: time.Minute, RetryDelay: 20 * time.Millisecond})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go sch.RunScheduler(ctx)
+
+		So(waitFor(func() bool { return pt.Status().ConsecutiveFailures >= 3 }), ShouldBeTrue)
+
+		status := pt.Status()
+		So(status.LastRun, ShouldNotBeNil)
+		So(status.LastError, ShouldEqual, errors.ErrBadConfig.Error())
+		So(status.NextRun, ShouldHappenBefore, time.Now().Add(time.Minute))
+	})
+
+	Convey("Periodic tasks are triggered on demand", t, func() {
+		var lock sync.Mutex
+
+		done := []string{}
+		wait := make(chan struct{})
+
+		sch := scheduler.NewScheduler(nil, logger)
+		pt := sch.SubmitPeriodicTaskWithOptions(&task{name: "periodic", lock: &lock, done: &done, wait: wait},
+			time.Hour, scheduler.LowPriority, scheduler.PeriodicOptions{Jitter: 6 * time.Minute})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go sch.RunScheduler(ctx)
+
+		So(waitFor(func() bool { return sch.Status().RunningTasks == 1 }), ShouldBeTrue)
+		So(pt.Trigger(), ShouldEqual, errors.ErrSchedulerTaskPending)
+		So(pt.Status().Pending, ShouldBeTrue)
+		So(pt.Status().NextRun, ShouldHappenBetween, time.Now().Add(59*time.Minute),
+			time.Now().Add(66*time.Minute))
+
+		close(wait)
+
+		So(waitFor(func() bool { return !pt.Status().Pending }), ShouldBeTrue)
+		So(pt.Trigger(), ShouldBeNil)
+		So(waitFor(func() bool { return sch.Status().CompletedTasks == 2 }), ShouldBeTrue)
+		So(pt.Status().ConsecutiveFailures, ShouldEqual, 0)
+	})
+}
+
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return false
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+
+	"github.com/anuvu/zot/errors"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// PatchManifestAnnotations applies a JSON merge patch to the annotations of the image manifest of a tag,
+// a nil value removing an annotation. The patched manifest gets a new digest, the tag is moved to it in
+// the same index.json update, unless expected isn't empty and the tag doesn't reference it anymore.
+// It returns the digest of the patched manifest, the previous one is left to the periodic GC.
+func (is *ImageStore) PatchManifestAnnotations(repo, tag string, expected godigest.Digest,
+	patch map[string]*string) (godigest.Digest, error) {
+	for key := range patch {
+		if key == "" {
+			return "", errors.ErrBadManifest
+		}
+	}
+
+	is.Lock()
+	defer is.Unlock()
+
+	var desc ispec.Descriptor
+
+	var digest godigest.Digest
+
+	if err := is.updateIndex(repo, func(index *ispec.Index) (bool, error) {
+		pos := -1
+
+		for i, m := range index.Manifests {
+			if m.Annotations[ispec.AnnotationRefName] == tag {
+				pos = i
+				break
+			}
+		}
+
+		if pos < 0 {
+			return false, errors.ErrManifestNotFound
+		}
+
+		desc = index.Manifests[pos]
+
+		if expected != "" && desc.Digest != expected {
+			return false, errors.ErrManifestChanged
+		}
+
+		body, err := is.patchManifestAnnotations(repo, desc, patch)
+		if err != nil {
+			return false, err
+		}
+
+		// the patched manifest keeps the digest algorithm of the one it replaces
+		digest = desc.Digest.Algorithm().FromBytes(body)
+		if digest == desc.Digest {
+			return false, nil
+		}
+
+		dir := path.Join(is.rootDir, repo, "blobs", digest.Algorithm().String())
+		_ = ensureDir(dir, is.log)
+		file := path.Join(dir, digest.Encoded())
+
+		if err := ioutil.WriteFile(file, body, 0600); err != nil {
+			is.log.Error().Err(err).Str("file", file).Msg("unable to write")
+			return false, err
+		}
+
+		index.Manifests[pos].Digest = digest
+		index.Manifests[pos].Size = int64(len(body))
+
+		return true, nil
+	}); err != nil {
+		return "", err
+	}
+
+	if digest == desc.Digest {
+		return digest, nil
+	}
+
+	is.log.Info().Str("repo", repo).Str("tag", tag).Str("old digest", desc.Digest.String()).
+		Str("new digest", digest.String()).Msg("patched manifest annotations")
+
+	is.updateRepoMeta(repo)
+
+	is.notifyChange(Change{Kind: ChangeTagMove, Repo: repo, Tag: tag, Digest: digest, Previous: desc.Digest})
+
+	return digest, nil
+}
+
+// patchManifestAnnotations returns the image manifest of desc with a JSON merge patch applied to its annotations.
+func (is *ImageStore) patchManifestAnnotations(repo string, desc ispec.Descriptor,
+	patch map[string]*string) ([]byte, error) {
+	if desc.MediaType != ispec.MediaTypeImageManifest {
+		return nil, errors.ErrBadManifest
+	}
+
+	buf, err := ioutil.ReadFile(is.BlobPath(repo, desc.Digest))
+	if err != nil {
+		is.log.Error().Err(err).Str("digest", desc.Digest.String()).Msg("unable to read manifest")
+		return nil, err
+	}
+
+	// unknown fields of the manifest are kept as they are
+	var manifest map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return nil, errors.ErrBadManifest
+	}
+
+	annotations := map[string]string{}
+
+	if raw, ok := manifest["annotations"]; ok {
+		if err := json.Unmarshal(raw, &annotations); err != nil {
+			return nil, errors.ErrBadManifest
+		}
+	}
+
+	for key, value := range patch {
+		if value == nil {
+			delete(annotations, key)
+		} else {
+			annotations[key] = *value
+		}
+	}
+
+	if len(annotations) == 0 {
+		delete(manifest, "annotations")
+	} else if manifest["annotations"], err = json.Marshal(annotations); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(manifest)
+}
@@ -0,0 +1,440 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	zlog "github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/scheduler"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// A backup directory keeps the blobs of all its snapshots once, by digest, under "blobs", and each
+// snapshot, the index.json and the blobs of every repository, under "snapshots".
+const (
+	backupBlobsDir     = "blobs"
+	backupSnapshotsDir = "snapshots"
+	snapshotIDFormat   = "20060102T150405.000Z"
+)
+
+// Snapshot is a consistent copy of the repositories of an image store at the time it was taken, its blobs
+// being kept in the backup directory.
+type Snapshot struct {
+	ID          string                  `json:"id"`
+	Created     time.Time               `json:"created"`
+	Incremental bool                    `json:"incremental"`
+	Repos       map[string]SnapshotRepo `json:"repos"`
+}
+
+// SnapshotRepo is a repository of a snapshot, its index.json and the blobs it references.
+type SnapshotRepo struct {
+	Index json.RawMessage   `json:"index"`
+	Blobs []godigest.Digest `json:"blobs"`
+}
+
+// BackupReport reports a snapshot, an incremental one only copies the blobs which weren't backed up yet.
+type BackupReport struct {
+	RootDir      string `json:"rootDir"`
+	BackupDir    string `json:"backupDir"`
+	Snapshot     string `json:"snapshot"`
+	Incremental  bool   `json:"incremental"`
+	Repos        int    `json:"repos"`
+	Blobs        int    `json:"blobs"`
+	CopiedBlobs  int    `json:"copiedBlobs"`
+	CopiedBytes  int64  `json:"copiedBytes"`
+	SkippedBlobs int    `json:"skippedBlobs"`
+}
+
+// RestoreReport reports the restore of a snapshot, the blobs shared by repositories are linked if dedupe
+// is enabled.
+type RestoreReport struct {
+	RootDir     string `json:"rootDir"`
+	BackupDir   string `json:"backupDir"`
+	Snapshot    string `json:"snapshot"`
+	Repos       int    `json:"repos"`
+	Blobs       int    `json:"blobs"`
+	LinkedBlobs int    `json:"linkedBlobs"`
+}
+
+// Backup takes a snapshot of the repositories of the image store into a backup directory. Index writes
+// are held back while it runs, so that the snapshot is consistent, pulls go on. An incremental backup
+// only copies the blobs which aren't in the backup directory already, a full one copies all of them.
+func (is *ImageStore) Backup(backupDir string, incremental bool) (BackupReport, error) {
+	is.backupLock.Lock()
+	defer is.backupLock.Unlock()
+
+	report := BackupReport{RootDir: is.rootDir, BackupDir: backupDir, Incremental: incremental}
+
+	for _, dir := range []string{backupBlobsDir, backupSnapshotsDir} {
+		if err := os.MkdirAll(path.Join(backupDir, dir), 0700); err != nil {
+			return report, err
+		}
+	}
+
+	now := time.Now().UTC()
+
+	// snapshots taken within the same millisecond get IDs of their own
+	for {
+		_, err := os.Stat(path.Join(backupDir, backupSnapshotsDir, now.Format(snapshotIDFormat)+".json"))
+		if os.IsNotExist(err) {
+			break
+		}
+
+		now = now.Add(time.Millisecond)
+	}
+
+	snapshot := Snapshot{ID: now.Format(snapshotIDFormat), Created: now, Incremental: incremental,
+		Repos: map[string]SnapshotRepo{}}
+	report.Snapshot = snapshot.ID
+
+	is.RLock()
+	defer is.RUnlock()
+
+	repos, err := is.getRepositories()
+	if err != nil {
+		return report, err
+	}
+
+	copied := make(map[godigest.Digest]bool)
+
+	for _, repo := range repos {
+		repoSnapshot, err := is.backupRepo(repo, backupDir, incremental, copied, &report)
+		if err != nil {
+			is.log.Error().Err(err).Str("repo", repo).Str("backupDir", backupDir).Msg("backup: unable to back up repo")
+			return report, err
+		}
+
+		snapshot.Repos[repo] = repoSnapshot
+	}
+
+	report.Repos = len(snapshot.Repos)
+	report.Blobs = len(copied)
+
+	// the snapshot is only written once all its blobs are, an interrupted backup leaves none
+	if err := replaceFile(path.Join(backupDir, backupSnapshotsDir, snapshot.ID+".json"), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(snapshot)
+	}); err != nil {
+		return report, err
+	}
+
+	is.log.Info().Str("rootDir", is.rootDir).Str("backupDir", backupDir).Str("snapshot", snapshot.ID).
+		Int("repos", report.Repos).Int("copiedBlobs", report.CopiedBlobs).Int("skippedBlobs", report.SkippedBlobs).
+		Msg("backup: snapshot done")
+
+	return report, nil
+}
+
+// backupRepo copies the blobs referenced by the index.json of a repository, copied has the blobs already
+// handled by this backup, and returns the repository snapshot.
+func (is *ImageStore) backupRepo(repo, backupDir string, incremental bool, copied map[godigest.Digest]bool,
+	report *BackupReport) (SnapshotRepo, error) {
+	buf, err := ioutil.ReadFile(path.Join(is.rootDir, repo, "index.json"))
+	if err != nil {
+		return SnapshotRepo{}, err
+	}
+
+	index, err := is.readIndex(repo)
+	if err != nil {
+		return SnapshotRepo{}, err
+	}
+
+	referenced := make(map[godigest.Digest]bool)
+
+	for _, desc := range index.Manifests {
+		if err := is.backupManifestBlobs(repo, desc.Digest, referenced); err != nil {
+			return SnapshotRepo{}, err
+		}
+	}
+
+	repoSnapshot := SnapshotRepo{Index: buf, Blobs: make([]godigest.Digest, 0, len(referenced))}
+
+	for digest := range referenced {
+		repoSnapshot.Blobs = append(repoSnapshot.Blobs, digest)
+
+		if copied[digest] {
+			continue
+		}
+
+		copied[digest] = true
+
+		dst := backupBlobPath(backupDir, digest)
+
+		if incremental {
+			if _, err := os.Stat(dst); err == nil {
+				report.SkippedBlobs++
+				continue
+			}
+		}
+
+		if err := os.MkdirAll(path.Dir(dst), 0700); err != nil {
+			return SnapshotRepo{}, err
+		}
+
+		// cold blobs are copied from the cold tier through their symlink
+		if err := copyFile(is.BlobPath(repo, digest), dst); err != nil {
+			return SnapshotRepo{}, err
+		}
+
+		if fi, err := os.Stat(dst); err == nil {
+			report.CopiedBytes += fi.Size()
+		}
+
+		report.CopiedBlobs++
+	}
+
+	sort.Slice(repoSnapshot.Blobs, func(i, j int) bool {
+		return repoSnapshot.Blobs[i] < repoSnapshot.Blobs[j]
+	})
+
+	return repoSnapshot, nil
+}
+
+// backupManifestBlobs adds a manifest and the blobs it references, recursively, to referenced, they
+// must all exist.
+func (is *ImageStore) backupManifestBlobs(repo string, digest godigest.Digest,
+	referenced map[godigest.Digest]bool) error {
+	if referenced[digest] {
+		return nil
+	}
+
+	buf, err := ioutil.ReadFile(is.BlobPath(repo, digest))
+	if err != nil {
+		return errors.ErrBlobNotFound
+	}
+
+	referenced[digest] = true
+
+	var manifest fsckManifest
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return errors.ErrBadManifest
+	}
+
+	blobs := make([]ispec.Descriptor, 0, len(manifest.Layers)+len(manifest.Blobs)+1)
+	blobs = append(blobs, manifest.Layers...)
+	blobs = append(blobs, manifest.Blobs...)
+
+	if manifest.Config != nil {
+		blobs = append(blobs, *manifest.Config)
+	}
+
+	for _, desc := range blobs {
+		// foreign layers are pulled from their URLs
+		if isForeignLayer(desc) {
+			continue
+		}
+
+		if _, err := os.Stat(is.BlobPath(repo, desc.Digest)); err != nil {
+			return errors.ErrBlobNotFound
+		}
+
+		referenced[desc.Digest] = true
+	}
+
+	for _, desc := range manifest.Manifests {
+		if is.isSparseChild(repo, desc.Digest) {
+			continue
+		}
+
+		if err := is.backupManifestBlobs(repo, desc.Digest, referenced); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func backupBlobPath(backupDir string, digest godigest.Digest) string {
+	return path.Join(backupDir, backupBlobsDir, digest.Algorithm().String(), digest.Encoded())
+}
+
+// Snapshots returns the IDs of the snapshots of a backup directory, from the oldest to the latest.
+func Snapshots(backupDir string) ([]string, error) {
+	files, err := ioutil.ReadDir(path.Join(backupDir, backupSnapshotsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(files))
+
+	for _, file := range files {
+		if id := strings.TrimSuffix(file.Name(), ".json"); id != file.Name() && !file.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+
+	// snapshot IDs are timestamps
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// Restore restores a snapshot of a backup directory, the latest one if snapshotID is empty, into the empty
+// root directory of an image store while zot is stopped. The copies of the blobs shared by repositories
+// are hard linked and recorded in the dedupe cache if dedupe is enabled.
+func Restore(backupDir, snapshotID, rootDir string, dedupe bool, log zlog.Logger) (RestoreReport, error) {
+	report := RestoreReport{RootDir: rootDir, BackupDir: backupDir, Snapshot: snapshotID}
+
+	snapshot, err := readSnapshot(backupDir, snapshotID)
+	if err != nil {
+		return report, err
+	}
+
+	report.Snapshot = snapshot.ID
+
+	if files, err := ioutil.ReadDir(rootDir); err == nil && len(files) > 0 {
+		return report, errors.ErrRestoreTargetNotEmpty
+	}
+
+	is := NewImageStore(rootDir, false, dedupe, log)
+	if is == nil {
+		return report, errors.ErrStorageInUse
+	}
+
+	if is.cache != nil {
+		defer is.cache.Close()
+	}
+
+	is.Lock()
+
+	restored := make(map[godigest.Digest]string)
+
+	for repo, repoSnapshot := range snapshot.Repos {
+		if err := is.restoreRepo(repo, repoSnapshot, backupDir, restored, &report); err != nil {
+			is.Unlock()
+			is.log.Error().Err(err).Str("repo", repo).Str("backupDir", backupDir).Msg("restore: unable to restore repo")
+
+			return report, err
+		}
+	}
+
+	is.Unlock()
+
+	report.Repos = len(snapshot.Repos)
+	report.Blobs = len(restored)
+
+	// record the restored blobs in the dedupe cache
+	if _, err := is.Rededupe(); err != nil {
+		return report, err
+	}
+
+	is.log.Info().Str("rootDir", rootDir).Str("backupDir", backupDir).Str("snapshot", snapshot.ID).
+		Int("repos", report.Repos).Int("blobs", report.Blobs).Msg("restore: snapshot restored")
+
+	return report, nil
+}
+
+// restoreRepo restores a repository of a snapshot, restored has the files of the blobs already restored,
+// the caller must hold the store lock.
+func (is *ImageStore) restoreRepo(repo string, repoSnapshot SnapshotRepo, backupDir string,
+	restored map[godigest.Digest]string, report *RestoreReport) error {
+	if err := is.initRepo(repo); err != nil {
+		return err
+	}
+
+	for _, digest := range repoSnapshot.Blobs {
+		if err := digest.Validate(); err != nil {
+			return errors.ErrBadBlobDigest
+		}
+
+		dst := is.BlobPath(repo, digest)
+
+		if err := os.MkdirAll(path.Dir(dst), 0700); err != nil {
+			return err
+		}
+
+		if src, ok := restored[digest]; ok && is.dedupeRepo(repo) {
+			if err := relink(src, dst); err != nil {
+				return err
+			}
+
+			report.LinkedBlobs++
+
+			continue
+		}
+
+		if err := copyFile(backupBlobPath(backupDir, digest), dst); err != nil {
+			if os.IsNotExist(err) {
+				return errors.ErrBlobNotFound
+			}
+
+			return err
+		}
+
+		if is.dedupeRepo(repo) {
+			restored[digest] = dst
+		}
+	}
+
+	if err := writeIndexFile(path.Join(is.rootDir, repo), repoSnapshot.Index); err != nil {
+		return err
+	}
+
+	is.updateRepoMeta(repo)
+
+	return nil
+}
+
+// readSnapshot reads a snapshot of a backup directory, the latest one if id is empty.
+func readSnapshot(backupDir, id string) (Snapshot, error) {
+	var snapshot Snapshot
+
+	if id == "" {
+		ids, err := Snapshots(backupDir)
+		if err != nil {
+			return snapshot, err
+		}
+
+		if len(ids) == 0 {
+			return snapshot, errors.ErrSnapshotNotFound
+		}
+
+		id = ids[len(ids)-1]
+	}
+
+	if _, err := time.Parse(snapshotIDFormat, id); err != nil {
+		return snapshot, errors.ErrSnapshotNotFound
+	}
+
+	buf, err := ioutil.ReadFile(path.Join(backupDir, backupSnapshotsDir, id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return snapshot, errors.ErrSnapshotNotFound
+		}
+
+		return snapshot, err
+	}
+
+	if err := json.Unmarshal(buf, &snapshot); err != nil {
+		return snapshot, errors.ErrBadSnapshot
+	}
+
+	return snapshot, nil
+}
+
+type backupTask struct {
+	imgStore  *ImageStore
+	backupDir string
+}
+
+// NewBackupTask returns a scheduler task which takes an incremental snapshot of an image store.
+func NewBackupTask(imgStore *ImageStore, backupDir string) scheduler.Task {
+	return &backupTask{imgStore: imgStore, backupDir: backupDir}
+}
+
+func (t *backupTask) DoWork() error {
+	_, err := t.imgStore.Backup(t.backupDir, true)
+
+	return err
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+const (
+	// directIOAlignment is the alignment of the buffers, offsets and sizes of O_DIRECT reads.
+	directIOAlignment = 4096
+	// directIOBufferSize is the size of the reads of blobs opened with O_DIRECT.
+	directIOBufferSize = 1 << 20
+)
+
+// directReader reads a blob opened with O_DIRECT, bypassing the page cache, so that pulls of
+// large layers don't evict hotter data. Reads must be aligned, so they go through its own buffer.
+type directReader struct {
+	file    *os.File
+	buf     []byte
+	pending []byte
+}
+
+func newDirectReader(file *os.File) *directReader {
+	buf := make([]byte, directIOBufferSize+directIOAlignment)
+
+	// only the address of the buffer is read
+	if offset := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1)); offset != 0 {
+		buf = buf[directIOAlignment-offset:]
+	}
+
+	return &directReader{file: file, buf: buf[:directIOBufferSize]}
+}
+
+// fill reads the next chunk of the blob, short reads only happen at the end of the file.
+func (dr *directReader) fill() error {
+	n, err := dr.file.Read(dr.buf)
+	dr.pending = dr.buf[:n]
+
+	if n > 0 && err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+func (dr *directReader) Read(p []byte) (int, error) {
+	if len(dr.pending) == 0 {
+		if err := dr.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, dr.pending)
+	dr.pending = dr.pending[n:]
+
+	return n, nil
+}
+
+// WriteTo writes the blob straight from the aligned buffer, io.Copy uses it instead of its own buffer.
+func (dr *directReader) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+
+	for {
+		if len(dr.pending) == 0 {
+			if err := dr.fill(); err == io.EOF {
+				return written, nil
+			} else if err != nil {
+				return written, err
+			}
+		}
+
+		n, err := w.Write(dr.pending)
+		written += int64(n)
+		dr.pending = dr.pending[n:]
+
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+func (dr *directReader) Close() error {
+	return dr.file.Close()
+}
+
+// SetDirectIO makes the image store serve blobs with O_DIRECT reads where the filesystem supports
+// them, except encrypted blobs.
+func (is *ImageStore) SetDirectIO(enabled bool) {
+	is.directIO = enabled
+}
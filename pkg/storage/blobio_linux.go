@@ -0,0 +1,37 @@
+// +build linux
+
+package storage
+
+import (
+	goerrors "errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// openBlobFile opens a blob to be read sequentially, with O_DIRECT if direct is set and the
+// filesystem supports it. It returns whether the file was opened with O_DIRECT.
+func openBlobFile(blobPath string, direct bool) (*os.File, bool, error) {
+	if direct {
+		file, err := os.OpenFile(blobPath, os.O_RDONLY|syscall.O_DIRECT, 0)
+		if err == nil {
+			return file, true, nil
+		}
+
+		// e.g. tmpfs doesn't support it
+		if !goerrors.Is(err, syscall.EINVAL) {
+			return nil, false, err
+		}
+	}
+
+	file, err := os.Open(blobPath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// doubles the readahead window of the file, blobs are read from start to end
+	_ = unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_SEQUENTIAL)
+
+	return file, false, nil
+}
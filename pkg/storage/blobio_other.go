@@ -0,0 +1,14 @@
+// +build !linux
+
+package storage
+
+import (
+	"os"
+)
+
+// openBlobFile opens a blob to be read sequentially, O_DIRECT is only supported on Linux.
+func openBlobFile(blobPath string, direct bool) (*os.File, bool, error) {
+	file, err := os.Open(blobPath)
+
+	return file, false, err
+}
@@ -4,6 +4,8 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/anuvu/zot/errors"
 	zlog "github.com/anuvu/zot/pkg/log"
@@ -12,12 +14,17 @@ import (
 
 const (
 	BlobsCache = "blobs"
+	// ColdBlobsCache records the repository paths of the blobs moved to the cold tier.
+	ColdBlobsCache = "coldBlobs"
+	// BlobAccessCache records when blobs were last pulled.
+	BlobAccessCache = "blobAccess"
 )
 
 type Cache struct {
 	rootDir string
 	db      *bbolt.DB
 	log     zlog.Logger
+	stats   cacheCounters
 }
 
 // Blob is a blob record.
@@ -35,10 +42,12 @@ func NewCache(rootDir string, name string, log zlog.Logger) *Cache {
 	}
 
 	if err := db.Update(func(tx *bbolt.Tx) error {
-		if _, err := tx.CreateBucketIfNotExists([]byte(BlobsCache)); err != nil {
-			// this is a serious failure
-			log.Error().Err(err).Str("dbPath", dbPath).Msg("unable to create a root bucket")
-			return err
+		for _, bucket := range []string{BlobsCache, ColdBlobsCache, BlobAccessCache} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				// this is a serious failure
+				log.Error().Err(err).Str("dbPath", dbPath).Msg("unable to create a root bucket")
+				return err
+			}
 		}
 		return nil
 	}); err != nil {
@@ -86,6 +95,8 @@ func (c *Cache) PutBlob(digest string, path string) error {
 		return err
 	}
 
+	atomic.AddUint64(&c.stats.puts, 1)
+
 	return nil
 }
 
@@ -112,12 +123,50 @@ func (c *Cache) GetBlob(digest string) (string, error) {
 
 		return errors.ErrCacheMiss
 	}); err != nil {
+		atomic.AddUint64(&c.stats.misses, 1)
 		return "", err
 	}
 
+	atomic.AddUint64(&c.stats.hits, 1)
+
 	return blobPath.String(), nil
 }
 
+// FindBlob returns the first path recorded for a blob which match accepts, e.g. one within the dedupe scope
+// of a repository.
+func (c *Cache) FindBlob(digest string, match func(blobPath string) bool) (string, error) {
+	var blobPath string
+
+	if err := c.db.View(func(tx *bbolt.Tx) error {
+		root, err := c.rootBucket(tx, BlobsCache)
+		if err != nil {
+			return err
+		}
+
+		b := root.Bucket([]byte(digest))
+		if b == nil {
+			return errors.ErrCacheMiss
+		}
+
+		cur := b.Cursor()
+		for k, _ := cur.First(); k != nil; k, _ = cur.Next() {
+			if match(string(k)) {
+				blobPath = string(k)
+				return nil
+			}
+		}
+
+		return errors.ErrCacheMiss
+	}); err != nil {
+		atomic.AddUint64(&c.stats.misses, 1)
+		return "", err
+	}
+
+	atomic.AddUint64(&c.stats.hits, 1)
+
+	return blobPath, nil
+}
+
 func (c *Cache) HasBlob(digest string, blob string) bool {
 	if err := c.db.View(func(tx *bbolt.Tx) error {
 		root := tx.Bucket([]byte(BlobsCache))
@@ -186,5 +235,209 @@ func (c *Cache) DeleteBlob(digest string, path string) error {
 		return err
 	}
 
+	atomic.AddUint64(&c.stats.deletes, 1)
+
 	return nil
 }
+
+// Blobs returns the paths, relative to rootDir, recorded for each deduped blob.
+func (c *Cache) Blobs() (map[string][]string, error) {
+	blobs := make(map[string][]string)
+
+	if err := c.db.View(func(tx *bbolt.Tx) error {
+		root, err := c.rootBucket(tx, BlobsCache)
+		if err != nil {
+			return err
+		}
+
+		return root.ForEach(func(digest, _ []byte) error {
+			b := root.Bucket(digest)
+			if b == nil {
+				return nil
+			}
+
+			return b.ForEach(func(k, _ []byte) error {
+				blobs[string(digest)] = append(blobs[string(digest)], string(k))
+				return nil
+			})
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return blobs, nil
+}
+
+// Close closes the cache db, e.g. so that offline tools can open it.
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// rootBucket returns one of the root buckets of the cache db.
+func (c *Cache) rootBucket(tx *bbolt.Tx, name string) (*bbolt.Bucket, error) {
+	root := tx.Bucket([]byte(name))
+	if root == nil {
+		// this is a serious failure
+		err := errors.ErrCacheRootBucket
+		c.log.Error().Err(err).Str("bucket", name).Msg("unable to access root bucket")
+
+		return nil, err
+	}
+
+	return root, nil
+}
+
+// PutColdBlob records a repository path of a blob moved to the cold tier.
+func (c *Cache) PutColdBlob(digest string, path string) error {
+	// use only relative (to rootDir) paths on blobs
+	relp, err := filepath.Rel(c.rootDir, path)
+	if err != nil {
+		c.log.Error().Err(err).Str("path", path).Msg("unable to get relative path")
+	}
+
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		root, err := c.rootBucket(tx, ColdBlobsCache)
+		if err != nil {
+			return err
+		}
+
+		b, err := root.CreateBucketIfNotExists([]byte(digest))
+		if err != nil {
+			c.log.Error().Err(err).Str("bucket", digest).Msg("unable to create a bucket")
+			return err
+		}
+
+		if err := b.Put([]byte(relp), nil); err != nil {
+			c.log.Error().Err(err).Str("bucket", digest).Str("value", relp).Msg("unable to put record")
+			return err
+		}
+
+		return nil
+	})
+}
+
+// GetColdBlob returns the repository paths, relative to rootDir, of a blob moved to the cold tier.
+func (c *Cache) GetColdBlob(digest string) ([]string, error) {
+	var paths []string
+
+	if err := c.db.View(func(tx *bbolt.Tx) error {
+		root, err := c.rootBucket(tx, ColdBlobsCache)
+		if err != nil {
+			return err
+		}
+
+		b := root.Bucket([]byte(digest))
+		if b == nil {
+			return errors.ErrCacheMiss
+		}
+
+		return b.ForEach(func(k, _ []byte) error {
+			paths = append(paths, string(k))
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// DeleteColdBlob removes a repository path of a blob moved to the cold tier, and the blob record
+// along with its last path.
+func (c *Cache) DeleteColdBlob(digest string, path string) error {
+	// use only relative (to rootDir) paths on blobs
+	relp, err := filepath.Rel(c.rootDir, path)
+	if err != nil {
+		c.log.Error().Err(err).Str("path", path).Msg("unable to get relative path")
+	}
+
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		root, err := c.rootBucket(tx, ColdBlobsCache)
+		if err != nil {
+			return err
+		}
+
+		b := root.Bucket([]byte(digest))
+		if b == nil {
+			return errors.ErrCacheMiss
+		}
+
+		if err := b.Delete([]byte(relp)); err != nil {
+			c.log.Error().Err(err).Str("digest", digest).Str("path", relp).Msg("unable to delete")
+			return err
+		}
+
+		if k, _ := b.Cursor().First(); k == nil {
+			if err := root.DeleteBucket([]byte(digest)); err != nil {
+				c.log.Error().Err(err).Str("digest", digest).Msg("unable to delete")
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ColdBlobs returns the digests of the blobs moved to the cold tier.
+func (c *Cache) ColdBlobs() ([]string, error) {
+	var digests []string
+
+	if err := c.db.View(func(tx *bbolt.Tx) error {
+		root, err := c.rootBucket(tx, ColdBlobsCache)
+		if err != nil {
+			return err
+		}
+
+		return root.ForEach(func(k, _ []byte) error {
+			digests = append(digests, string(k))
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return digests, nil
+}
+
+// PutBlobAccess records when a blob was last pulled.
+func (c *Cache) PutBlobAccess(digest string, accessed time.Time) error {
+	value, err := accessed.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		root, err := c.rootBucket(tx, BlobAccessCache)
+		if err != nil {
+			return err
+		}
+
+		if err := root.Put([]byte(digest), value); err != nil {
+			c.log.Error().Err(err).Str("digest", digest).Msg("unable to put record")
+			return err
+		}
+
+		return nil
+	})
+}
+
+// GetBlobAccess returns when a blob was last pulled.
+func (c *Cache) GetBlobAccess(digest string) (time.Time, error) {
+	var accessed time.Time
+
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		root, err := c.rootBucket(tx, BlobAccessCache)
+		if err != nil {
+			return err
+		}
+
+		value := root.Get([]byte(digest))
+		if value == nil {
+			return errors.ErrCacheMiss
+		}
+
+		return accessed.UnmarshalBinary(value)
+	})
+
+	return accessed, err
+}
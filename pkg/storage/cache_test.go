@@ -44,6 +44,19 @@ func TestCache(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(v, ShouldNotBeEmpty)
 
+		err = c.PutBlob("key", path.Join(dir, "other"))
+		So(err, ShouldBeNil)
+
+		v, err = c.FindBlob("key", func(blobPath string) bool { return blobPath != "other" })
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "value")
+
+		_, err = c.FindBlob("key", func(blobPath string) bool { return false })
+		So(err, ShouldEqual, errors.ErrCacheMiss)
+
+		_, err = c.FindBlob("bogusKey", func(blobPath string) bool { return true })
+		So(err, ShouldEqual, errors.ErrCacheMiss)
+
 		err = c.DeleteBlob("bogusKey", "bogusValue")
 		So(err, ShouldEqual, errors.ErrCacheMiss)
 
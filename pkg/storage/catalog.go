@@ -0,0 +1,79 @@
+package storage
+
+import (
+	goerrors "errors"
+	"sort"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// CatalogImage is a tagged image of an image store, as listed by its catalog.
+type CatalogImage struct {
+	Repo         string
+	Tag          string
+	Digest       godigest.Digest
+	ConfigDigest godigest.Digest
+	// Size is the size of the manifest, config and layers of the image.
+	Size        int64
+	LastUpdated time.Time
+	Signed      bool
+	// Scan is the result of the last CVE scan of the image, if it was scanned.
+	Scan *ScanSummary
+	// Build tells how the image was built, if it was built by stacker.
+	Build *BuildInfo
+	// Provenance is the result of the last verification of its provenance attestations, if they were verified.
+	Provenance *ProvenanceSummary
+	// Lint is the result of the lint of the image when it was pushed, if it was linted.
+	Lint *LintSummary
+}
+
+// CatalogImages returns the tagged images of a repository, or of all repositories if repo is empty,
+// sorted by repository and tag. They're read from the metadata db instead of the OCI layouts.
+func (is *ImageStore) CatalogImages(repo string) ([]CatalogImage, error) {
+	var repos []RepoMeta
+
+	if repo != "" {
+		rm, err := is.GetRepoMeta(repo)
+		if goerrors.Is(err, errors.ErrRepoNotFound) {
+			return []CatalogImage{}, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		repos = []RepoMeta{rm}
+	} else {
+		var err error
+
+		repos, err = is.GetReposMeta()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	images := []CatalogImage{}
+
+	for _, rm := range repos {
+		repoImages := make([]CatalogImage, 0, len(rm.Tags))
+
+		for tag, digest := range rm.Tags {
+			mm := rm.Manifests[digest]
+
+			repoImages = append(repoImages, CatalogImage{Repo: rm.Name, Tag: tag, Digest: digest,
+				ConfigDigest: mm.ConfigDigest, Size: mm.Size, LastUpdated: mm.Created,
+				Signed: rm.IsSigned(digest), Scan: mm.Scan, Build: mm.BuildInfo(),
+				Provenance: mm.Provenance, Lint: mm.Lint})
+		}
+
+		sort.Slice(repoImages, func(i, j int) bool {
+			return repoImages[i].Tag < repoImages[j].Tag
+		})
+
+		images = append(images, repoImages...)
+	}
+
+	return images, nil
+}
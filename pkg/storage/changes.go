@@ -0,0 +1,53 @@
+package storage
+
+import (
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// Kinds of the changes of the repositories of an image store.
+const (
+	// ChangePush is a manifest pushed by tag or digest.
+	ChangePush = "push"
+	// ChangeDelete is a manifest or a tag deleted, by a user or on expiry.
+	ChangeDelete = "delete"
+	// ChangeTagMove is a tag moved to another manifest, by a push or a patch of its annotations.
+	ChangeTagMove = "tagMove"
+)
+
+// Change is a change of the index.json of a repository. Tag is empty for the manifests pushed or
+// deleted by digest, Previous is the manifest a tag was moved from.
+type Change struct {
+	Kind     string
+	Repo     string
+	Tag      string
+	Digest   godigest.Digest
+	Previous godigest.Digest
+}
+
+// SetChangeHook sets the function called on each change of the repositories. It's called while the
+// store lock is held, so that the changes of a repository are reported in order.
+func (is *ImageStore) SetChangeHook(hook func(Change)) {
+	is.onChange = hook
+}
+
+// pushChange returns the change of a manifest pushed by reference, a tag or its digest, which moved the
+// tag from previous if set.
+func pushChange(repo, reference string, digest, previous godigest.Digest) Change {
+	change := Change{Kind: ChangePush, Repo: repo, Digest: digest, Previous: previous}
+
+	if reference != digest.String() {
+		change.Tag = reference
+	}
+
+	if previous != "" {
+		change.Kind = ChangeTagMove
+	}
+
+	return change
+}
+
+func (is *ImageStore) notifyChange(change Change) {
+	if is.onChange != nil {
+		is.onChange(change)
+	}
+}
@@ -0,0 +1,12 @@
+// +build !linux,!darwin
+
+package storage
+
+import (
+	"github.com/anuvu/zot/errors"
+)
+
+// cloneFile isn't supported, blobs are copied instead.
+func cloneFile(src, dst string) error {
+	return errors.ErrReflinkNotSupported
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/anuvu/zot/errors"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxConvertedManifests bounds the converted manifests kept in memory, the oldest ones being dropped first.
+// Low memory image stores keep lowMemoryConvertedManifests of them, enough for the clients pulling a converted
+// manifest by digest right after pulling it by tag.
+const (
+	maxConvertedManifests       = 1024
+	lowMemoryConvertedManifests = 16
+)
+
+// convertedImageManifest is an image manifest with the media type field Docker manifests must have.
+type convertedImageManifest struct {
+	specs.Versioned
+	MediaType   string             `json:"mediaType,omitempty"`
+	Config      ispec.Descriptor   `json:"config"`
+	Layers      []ispec.Descriptor `json:"layers"`
+	Annotations map[string]string  `json:"annotations,omitempty"`
+}
+
+// convertedIndex is an index with the media type field Docker manifest lists must have.
+type convertedIndex struct {
+	specs.Versioned
+	MediaType   string             `json:"mediaType,omitempty"`
+	Manifests   []ispec.Descriptor `json:"manifests"`
+	Annotations map[string]string  `json:"annotations,omitempty"`
+}
+
+// convertedManifest is a stored manifest converted to the other format.
+type convertedManifest struct {
+	repo      string
+	original  string
+	content   []byte
+	digest    string
+	mediaType string
+}
+
+// conversionCache keeps the converted manifests, by the stored manifest and format, and by their digest.
+type conversionCache struct {
+	lock     sync.Mutex
+	byKey    map[string]*convertedManifest
+	byDigest map[string]*convertedManifest
+	keys     []string
+	max      int
+}
+
+func newConversionCache(max int) *conversionCache {
+	return &conversionCache{
+		byKey:    make(map[string]*convertedManifest),
+		byDigest: make(map[string]*convertedManifest),
+		max:      max,
+	}
+}
+
+func conversionKey(repo, digest, format string) string {
+	return repo + "@" + digest + "/" + format
+}
+
+func (cc *conversionCache) get(key string) (*convertedManifest, bool) {
+	if cc == nil {
+		return nil, false
+	}
+
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	converted, ok := cc.byKey[key]
+
+	return converted, ok
+}
+
+func (cc *conversionCache) lookup(repo, digest string) (*convertedManifest, bool) {
+	if cc == nil {
+		return nil, false
+	}
+
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	converted, ok := cc.byDigest[repo+"@"+digest]
+
+	return converted, ok
+}
+
+func (cc *conversionCache) put(key string, converted *convertedManifest) {
+	if cc == nil {
+		return
+	}
+
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	if _, ok := cc.byKey[key]; ok {
+		return
+	}
+
+	if len(cc.keys) >= cc.max {
+		oldest := cc.byKey[cc.keys[0]]
+		delete(cc.byKey, cc.keys[0])
+		delete(cc.byDigest, oldest.repo+"@"+oldest.digest)
+		cc.keys = cc.keys[1:]
+	}
+
+	cc.byKey[key] = converted
+	cc.byDigest[converted.repo+"@"+converted.digest] = converted
+	cc.keys = append(cc.keys, key)
+}
+
+// ConvertManifest converts a manifest of the repository to the OCI or the Docker format, along with the
+// manifests of an index, and returns the converted manifest, its digest and its media type. Manifests already
+// in the format are returned as is, and converted ones are cached, the stored manifest being left untouched.
+func (is *ImageStore) ConvertManifest(repo string, content []byte, digest, mediaType,
+	format string) ([]byte, string, string, error) {
+	if !IsManifestMediaType(mediaType) || ManifestFormat(mediaType) == format {
+		return content, digest, mediaType, nil
+	}
+
+	key := conversionKey(repo, digest, format)
+	if converted, ok := is.conversions.get(key); ok {
+		return converted.content, converted.digest, converted.mediaType, nil
+	}
+
+	types := dockerToOCI
+	if format == DockerFormat {
+		types = ociToDocker
+	}
+
+	var (
+		converted []byte
+		err       error
+	)
+
+	if IsIndexMediaType(mediaType) {
+		converted, err = is.convertIndex(repo, content, types[mediaType], types, format)
+	} else {
+		converted, err = convertImageManifest(content, types[mediaType], types, format)
+	}
+
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	result := &convertedManifest{
+		repo:      repo,
+		original:  digest,
+		content:   converted,
+		digest:    godigest.FromBytes(converted).String(),
+		mediaType: types[mediaType],
+	}
+	is.conversions.put(key, result)
+
+	return result.content, result.digest, result.mediaType, nil
+}
+
+// convertDescriptor converts the media type of a config or a layer, those without a Docker equivalent, e.g.
+// zstd layers or artifacts, not being pullable by Docker clients.
+func convertDescriptor(desc *ispec.Descriptor, types map[string]string, format string) error {
+	if mediaType, ok := types[desc.MediaType]; ok {
+		desc.MediaType = mediaType
+		return nil
+	}
+
+	if format == DockerFormat {
+		return fmt.Errorf("%w: %s has no Docker equivalent", errors.ErrManifestNotConvertible, desc.MediaType)
+	}
+
+	return nil
+}
+
+func convertImageManifest(content []byte, mediaType string, types map[string]string,
+	format string) ([]byte, error) {
+	var manifest convertedImageManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, errors.ErrBadManifest
+	}
+
+	manifest.MediaType = mediaType
+
+	if err := convertDescriptor(&manifest.Config, types, format); err != nil {
+		return nil, err
+	}
+
+	for i := range manifest.Layers {
+		if err := convertDescriptor(&manifest.Layers[i], types, format); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(manifest)
+}
+
+// convertIndex converts the manifests of an index, which then references the converted ones.
+func (is *ImageStore) convertIndex(repo string, content []byte, mediaType string, types map[string]string,
+	format string) ([]byte, error) {
+	var index convertedIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, errors.ErrBadManifest
+	}
+
+	index.MediaType = mediaType
+
+	for i, desc := range index.Manifests {
+		if !IsManifestMediaType(desc.MediaType) {
+			if err := convertDescriptor(&index.Manifests[i], types, format); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		manifest, _, _, err := is.GetImageManifest(repo, desc.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+
+		converted, digest, mediaType, err := is.ConvertManifest(repo, manifest, desc.Digest.String(),
+			desc.MediaType, format)
+		if err != nil {
+			return nil, err
+		}
+
+		index.Manifests[i].MediaType = mediaType
+		index.Manifests[i].Digest = godigest.Digest(digest)
+		index.Manifests[i].Size = int64(len(converted))
+	}
+
+	return json.Marshal(index)
+}
+
+// GetConvertedManifest returns a converted manifest of the repository by its digest, along with its media type
+// and the digest of the stored manifest it was converted from.
+func (is *ImageStore) GetConvertedManifest(repo, digest string) ([]byte, string, string, error) {
+	converted, ok := is.conversions.lookup(repo, digest)
+	if !ok {
+		return nil, "", "", errors.ErrManifestNotFound
+	}
+
+	// the stored manifest may have been deleted since
+	if _, _, _, err := is.GetImageManifest(repo, converted.original); err != nil {
+		return nil, "", "", err
+	}
+
+	return converted.content, converted.mediaType, converted.original, nil
+}
@@ -0,0 +1,442 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// DefaultDedupeReportTop is the number of most duplicated blobs listed by default in dedupe reports.
+const DefaultDedupeReportTop = 10
+
+// DedupeReport reports how much disk space dedupe saves in an image store. LogicalBytes is the size
+// of the blob files of all the repositories, PhysicalBytes the space they take once hard links are
+// accounted for, and ReclaimableBytes the space a rededupe would free by hard linking the copies of
+// the same blob. The extents shared by reflinks aren't accounted for, reflinked blobs count as copies.
+type DedupeReport struct {
+	RootDir          string          `json:"rootDir"`
+	Dedupe           bool            `json:"dedupe"`
+	Reflink          bool            `json:"reflink,omitempty"`
+	Blobs            int             `json:"blobs"`
+	BlobFiles        int             `json:"blobFiles"`
+	LogicalBytes     int64           `json:"logicalBytes"`
+	PhysicalBytes    int64           `json:"physicalBytes"`
+	ReclaimableBytes int64           `json:"reclaimableBytes"`
+	TopDuplicates    []DuplicateBlob `json:"topDuplicates"`
+}
+
+// DuplicateBlob is a blob found in several repositories, Copies counts the files which aren't hard
+// links of each other.
+type DuplicateBlob struct {
+	Digest     godigest.Digest `json:"digest"`
+	Size       int64           `json:"size"`
+	Repos      []string        `json:"repos"`
+	Copies     int             `json:"copies"`
+	SavedBytes int64           `json:"savedBytes"`
+}
+
+// SharedLayer is a layer and the repositories of the images referencing it. Copies counts its blob files
+// which aren't hard links of each other, and SavedBytes the space dedupe saves by linking the others.
+type SharedLayer struct {
+	Digest     godigest.Digest `json:"digest"`
+	Size       int64           `json:"size"`
+	Images     int             `json:"images"`
+	Repos      []string        `json:"repos"`
+	Copies     int             `json:"copies"`
+	SavedBytes int64           `json:"savedBytes"`
+}
+
+// RededupeResult reports the duplicate blob files a rededupe replaced with hard links.
+type RededupeResult struct {
+	RootDir        string `json:"rootDir"`
+	Linked         int    `json:"linked"`
+	ReclaimedBytes int64  `json:"reclaimedBytes"`
+}
+
+// blobFile is the file of a blob in a repository.
+type blobFile struct {
+	repo string
+	path string
+	info os.FileInfo
+}
+
+// blobCopies groups the files of a blob which are hard links of each other.
+func blobCopies(files []blobFile) [][]blobFile {
+	var copies [][]blobFile
+
+	for _, file := range files {
+		found := false
+
+		for i := range copies {
+			if os.SameFile(copies[i][0].info, file.info) {
+				copies[i] = append(copies[i], file)
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			copies = append(copies, []blobFile{file})
+		}
+	}
+
+	return copies
+}
+
+// scanBlobs returns the files of each blob of the image store, the caller must hold the lock.
+func (is *ImageStore) scanBlobs() (map[godigest.Digest][]blobFile, error) {
+	repos, err := is.getRepositories()
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := make(map[godigest.Digest][]blobFile)
+
+	for _, repo := range repos {
+		blobsDir := path.Join(is.rootDir, repo, "blobs")
+
+		algorithms, err := ioutil.ReadDir(blobsDir)
+		if err != nil {
+			is.log.Error().Err(err).Str("dir", blobsDir).Msg("dedupe: unable to read directory")
+			return nil, err
+		}
+
+		for _, algorithm := range algorithms {
+			if !algorithm.IsDir() || !godigest.Algorithm(algorithm.Name()).Available() {
+				continue
+			}
+
+			files, err := ioutil.ReadDir(path.Join(blobsDir, algorithm.Name()))
+			if err != nil {
+				is.log.Error().Err(err).Str("dir", blobsDir).Msg("dedupe: unable to read directory")
+				return nil, err
+			}
+
+			for _, file := range files {
+				digest := godigest.NewDigestFromEncoded(godigest.Algorithm(algorithm.Name()), file.Name())
+				if !file.Mode().IsRegular() || digest.Validate() != nil {
+					continue
+				}
+
+				blobs[digest] = append(blobs[digest], blobFile{
+					repo: repo,
+					path: path.Join(blobsDir, algorithm.Name(), file.Name()),
+					info: file,
+				})
+			}
+		}
+	}
+
+	return blobs, nil
+}
+
+// DedupeEnabled tells whether blobs are deduped when pushed, to some repositories at least.
+func (is *ImageStore) DedupeEnabled() bool {
+	if is.cache == nil {
+		return false
+	}
+
+	if is.dedupe {
+		return true
+	}
+
+	for _, rule := range is.repoRules {
+		if rule.Dedupe != nil && *rule.Dedupe {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DedupeReport reports the disk space saved by dedupe, and the top most duplicated blobs, those
+// saving the most space once deduped.
+func (is *ImageStore) DedupeReport(top int) (DedupeReport, error) {
+	report := DedupeReport{RootDir: is.rootDir, Dedupe: is.dedupe, Reflink: is.reflink, TopDuplicates: []DuplicateBlob{}}
+
+	is.RLock()
+	defer is.RUnlock()
+
+	blobs, err := is.scanBlobs()
+	if err != nil {
+		return report, err
+	}
+
+	for digest, files := range blobs {
+		size := files[0].info.Size()
+		copies := blobCopies(files)
+
+		report.Blobs++
+		report.BlobFiles += len(files)
+		report.LogicalBytes += size * int64(len(files))
+		report.PhysicalBytes += size * int64(len(copies))
+		report.ReclaimableBytes += size * int64(len(copies)-1)
+
+		if len(files) == 1 {
+			continue
+		}
+
+		duplicate := DuplicateBlob{
+			Digest:     digest,
+			Size:       size,
+			Copies:     len(copies),
+			SavedBytes: size * int64(len(files)-1),
+		}
+
+		for _, file := range files {
+			duplicate.Repos = append(duplicate.Repos, file.repo)
+		}
+
+		sort.Strings(duplicate.Repos)
+
+		report.TopDuplicates = append(report.TopDuplicates, duplicate)
+	}
+
+	sort.Slice(report.TopDuplicates, func(i, j int) bool {
+		a, b := report.TopDuplicates[i], report.TopDuplicates[j]
+		if a.SavedBytes != b.SavedBytes {
+			return a.SavedBytes > b.SavedBytes
+		}
+
+		return a.Digest < b.Digest
+	})
+
+	if top >= 0 && len(report.TopDuplicates) > top {
+		report.TopDuplicates = report.TopDuplicates[:top]
+	}
+
+	return report, nil
+}
+
+// SharedLayers returns the layers referenced by the most images, counted from the repository metadata, and
+// the space dedupe saves on each of them. Only the top ones are returned, unless top is negative.
+func (is *ImageStore) SharedLayers(top int) ([]SharedLayer, error) {
+	reposMeta, err := is.GetReposMeta()
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make(map[godigest.Digest]*SharedLayer)
+
+	for _, rm := range reposMeta {
+		for _, mm := range rm.Manifests {
+			// the layers of referrers, e.g. signature envelopes, aren't image layers
+			if mm.Subject != "" {
+				continue
+			}
+
+			seen := make(map[godigest.Digest]bool)
+
+			for _, layer := range mm.Layers {
+				if isForeignLayer(layer) || seen[layer.Digest] {
+					continue
+				}
+
+				seen[layer.Digest] = true
+
+				shared, ok := layers[layer.Digest]
+				if !ok {
+					shared = &SharedLayer{Digest: layer.Digest, Size: layer.Size, Repos: []string{}}
+					layers[layer.Digest] = shared
+				}
+
+				shared.Images++
+
+				if n := len(shared.Repos); n == 0 || shared.Repos[n-1] != rm.Name {
+					shared.Repos = append(shared.Repos, rm.Name)
+				}
+			}
+		}
+	}
+
+	result := make([]SharedLayer, 0, len(layers))
+	for _, shared := range layers {
+		result = append(result, *shared)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Images != b.Images {
+			return a.Images > b.Images
+		}
+
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+
+		return a.Digest < b.Digest
+	})
+
+	if top >= 0 && len(result) > top {
+		result = result[:top]
+	}
+
+	is.RLock()
+	defer is.RUnlock()
+
+	for i := range result {
+		var files []blobFile
+
+		for _, repo := range result[i].Repos {
+			file := is.BlobPath(repo, result[i].Digest)
+
+			// blobs moved to the cold tier aren't deduped
+			info, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+
+			files = append(files, blobFile{repo: repo, path: file, info: info})
+		}
+
+		if len(files) == 0 {
+			continue
+		}
+
+		result[i].Copies = len(blobCopies(files))
+		result[i].SavedBytes = result[i].Size * int64(len(files)-result[i].Copies)
+	}
+
+	return result, nil
+}
+
+// Rededupe replaces the copies of each blob with hard links of a single file, and records them in the
+// dedupe cache. Copies are made when blobs are pushed while dedupe is disabled, or when the storage is
+// copied to another filesystem. It does nothing unless dedupe is enabled, nor when deduping with reflinks,
+// there's no telling copies from clones. The blobs of the repositories which aren't deduped are left alone.
+func (is *ImageStore) Rededupe() (RededupeResult, error) {
+	if !is.DedupeEnabled() {
+		return RededupeResult{RootDir: is.rootDir}, nil
+	}
+
+	is.Lock()
+	defer is.Unlock()
+
+	return is.rededupe()
+}
+
+func (is *ImageStore) rededupe() (RededupeResult, error) {
+	result := RededupeResult{RootDir: is.rootDir}
+
+	if is.cache == nil || is.reflink {
+		return result, nil
+	}
+
+	blobs, err := is.scanDedupedBlobs()
+	if err != nil {
+		return result, err
+	}
+
+	for digest, all := range blobs {
+		// blobs are only linked within their dedupe scope
+		for _, files := range is.byDedupeScope(all) {
+			if err := is.rededupeBlob(digest, files, &result); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	is.log.Info().Str("rootDir", is.rootDir).Int("linked", result.Linked).
+		Int64("reclaimedBytes", result.ReclaimedBytes).Msg("dedupe: rededupe done")
+
+	return result, nil
+}
+
+// rededupeBlob links the copies of a blob within a dedupe scope.
+func (is *ImageStore) rededupeBlob(digest godigest.Digest, files []blobFile, result *RededupeResult) error {
+	copies := blobCopies(files)
+	master := is.dedupeMaster(digest, copies)
+
+	for _, group := range copies {
+		if os.SameFile(group[0].info, master.info) {
+			continue
+		}
+
+		if group[0].info.Size() != master.info.Size() {
+			is.log.Error().Str("blob", group[0].path).Str("master", master.path).
+				Msg("dedupe: blob size doesn't match its digest, skipping it")
+
+			continue
+		}
+
+		for _, file := range group {
+			if err := relink(master.path, file.path); err != nil {
+				is.log.Error().Err(err).Str("blob", file.path).Str("link", master.path).
+					Msg("dedupe: unable to hard link")
+
+				return err
+			}
+
+			result.Linked++
+		}
+
+		result.ReclaimedBytes += master.info.Size()
+	}
+
+	for _, file := range files {
+		if !is.cache.HasBlob(digest.String(), is.relativePath(file.path)) {
+			if err := is.cache.PutBlob(digest.String(), file.path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// dedupeMaster returns the file the other copies of the blob are linked to, the one recorded in the
+// dedupe cache if any, so that the blobs deduped on push stay linked together.
+func (is *ImageStore) dedupeMaster(digest godigest.Digest, copies [][]blobFile) blobFile {
+	if record, err := is.cachedBlob(copies[0][0].repo, digest.String()); err == nil {
+		for _, group := range copies {
+			for _, file := range group {
+				if file.path == path.Join(is.rootDir, record) {
+					return file
+				}
+			}
+		}
+	}
+
+	// otherwise the group with the most links, so that fewer files are replaced
+	master := copies[0]
+
+	for _, group := range copies[1:] {
+		if len(group) > len(master) {
+			master = group
+		}
+	}
+
+	return master[0]
+}
+
+func (is *ImageStore) relativePath(file string) string {
+	rel, err := filepath.Rel(is.rootDir, file)
+	if err != nil {
+		return file
+	}
+
+	return rel
+}
+
+// relink atomically replaces dst with a hard link of src.
+func relink(src, dst string) error {
+	tmp := dst + ".rededupe"
+
+	_ = os.Remove(tmp)
+
+	if err := os.Link(src, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"github.com/anuvu/zot/errors"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Deprecations are kept as annotations of the OCI layout of the repository, on the index for
+// the whole repository, and on the descriptor of the manifest for a tag.
+const (
+	AnnotationDeprecated  = "io.zot.deprecated"
+	AnnotationReplacement = "io.zot.deprecated.replacement"
+)
+
+// Deprecation marks a repository or a tag as deprecated, Replacement optionally names the image to use instead.
+type Deprecation struct {
+	Message     string `json:"message"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+func getDeprecation(annotations map[string]string) *Deprecation {
+	message, ok := annotations[AnnotationDeprecated]
+	if !ok {
+		return nil
+	}
+
+	return &Deprecation{Message: message, Replacement: annotations[AnnotationReplacement]}
+}
+
+func setDeprecation(annotations map[string]string, deprecation *Deprecation) map[string]string {
+	delete(annotations, AnnotationDeprecated)
+	delete(annotations, AnnotationReplacement)
+
+	if deprecation == nil {
+		return annotations
+	}
+
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	annotations[AnnotationDeprecated] = deprecation.Message
+
+	if deprecation.Replacement != "" {
+		annotations[AnnotationReplacement] = deprecation.Replacement
+	}
+
+	return annotations
+}
+
+// SetDeprecation deprecates a tag of the repository, or the whole repository if the tag is empty,
+// a nil deprecation clears it.
+func (is *ImageStore) SetDeprecation(repo, tag string, deprecation *Deprecation) error {
+	is.Lock()
+	defer is.Unlock()
+
+	if err := is.updateIndex(repo, func(index *ispec.Index) (bool, error) {
+		if tag == "" {
+			index.Annotations = setDeprecation(index.Annotations, deprecation)
+
+			return true, nil
+		}
+
+		found := false
+
+		for i, desc := range index.Manifests {
+			if desc.Annotations[ispec.AnnotationRefName] == tag {
+				index.Manifests[i].Annotations = setDeprecation(desc.Annotations, deprecation)
+				found = true
+			}
+		}
+
+		if !found {
+			return false, errors.ErrManifestNotFound
+		}
+
+		return true, nil
+	}); err != nil {
+		return err
+	}
+
+	// deprecations aren't recorded in the metadata, but they're part of the search results
+	is.metaDB.touch()
+
+	return nil
+}
+
+// GetDeprecation returns the deprecation of the image referenced by a tag or a digest,
+// falling back to the deprecation of the repository, nil if it's not deprecated.
+func (is *ImageStore) GetDeprecation(repo, reference string) (*Deprecation, error) {
+	is.RLock()
+	defer is.RUnlock()
+
+	index, err := is.readIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if reference != "" {
+		for _, desc := range index.Manifests {
+			if desc.Digest.String() != reference && desc.Annotations[ispec.AnnotationRefName] != reference {
+				continue
+			}
+
+			if deprecation := getDeprecation(desc.Annotations); deprecation != nil {
+				return deprecation, nil
+			}
+		}
+	}
+
+	return getDeprecation(index.Annotations), nil
+}
+
+// GetDeprecations returns the deprecations of the repository, keyed by tag, the empty key holding
+// the deprecation of the whole repository.
+func (is *ImageStore) GetDeprecations(repo string) (map[string]Deprecation, error) {
+	is.RLock()
+	defer is.RUnlock()
+
+	index, err := is.readIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	deprecations := make(map[string]Deprecation)
+
+	if deprecation := getDeprecation(index.Annotations); deprecation != nil {
+		deprecations[""] = *deprecation
+	}
+
+	for _, desc := range index.Manifests {
+		tag, ok := desc.Annotations[ispec.AnnotationRefName]
+		if !ok {
+			continue
+		}
+
+		if deprecation := getDeprecation(desc.Annotations); deprecation != nil {
+			deprecations[tag] = *deprecation
+		}
+	}
+
+	return deprecations, nil
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/scheduler"
+)
+
+// DiskSpace is the space of the filesystem of an image store, as of its last check. FreeBytes is the
+// space available to zot, and MinFreeBytes the threshold below which new uploads are refused, 0 if none.
+type DiskSpace struct {
+	TotalBytes   uint64    `json:"totalBytes"`
+	FreeBytes    uint64    `json:"freeBytes"`
+	MinFreeBytes uint64    `json:"minFreeBytes"`
+	Low          bool      `json:"low"`
+	Checked      time.Time `json:"checked"`
+}
+
+// diskSpaceMonitor keeps track of the free space of the filesystem of an image store.
+type diskSpaceMonitor struct {
+	minFreeBytes   uint64
+	minFreePercent float64
+	// onLow is called when the free space falls below the threshold
+	onLow func()
+	lock  sync.RWMutex
+	last  DiskSpace
+}
+
+// threshold returns the larger of the absolute and relative thresholds.
+func (m *diskSpaceMonitor) threshold(total uint64) uint64 {
+	threshold := m.minFreeBytes
+
+	if relative := uint64(float64(total) * m.minFreePercent / 100); relative > threshold { // nolint: gomnd
+		threshold = relative
+	}
+
+	return threshold
+}
+
+// SetDiskSpaceThreshold refuses new uploads once the free space of the filesystem falls below
+// minFreeBytes, or minFreePercent of its size, onLow is called when it does.
+func (is *ImageStore) SetDiskSpaceThreshold(minFreeBytes uint64, minFreePercent float64, onLow func()) {
+	is.diskSpace.lock.Lock()
+	defer is.diskSpace.lock.Unlock()
+
+	is.diskSpace.minFreeBytes = minFreeBytes
+	is.diskSpace.minFreePercent = minFreePercent
+	is.diskSpace.onLow = onLow
+}
+
+// CheckDiskSpace reads the free space of the filesystem of the image store.
+func (is *ImageStore) CheckDiskSpace() (DiskSpace, error) {
+	total, free, err := diskUsage(is.rootDir)
+	if err != nil {
+		is.log.Error().Err(err).Str("rootDir", is.rootDir).Msg("unable to read free disk space")
+		return is.DiskSpace(), err
+	}
+
+	monitor := is.diskSpace
+
+	monitor.lock.Lock()
+
+	wasLow := monitor.last.Low
+	threshold := monitor.threshold(total)
+	monitor.last = DiskSpace{
+		TotalBytes:   total,
+		FreeBytes:    free,
+		MinFreeBytes: threshold,
+		Low:          free < threshold,
+		Checked:      time.Now(),
+	}
+	space, onLow := monitor.last, monitor.onLow
+
+	monitor.lock.Unlock()
+
+	if space.Low && !wasLow {
+		is.log.Warn().Str("rootDir", is.rootDir).Uint64("freeBytes", free).Uint64("minFreeBytes", threshold).
+			Msg("low disk space, refusing new uploads")
+
+		if onLow != nil {
+			onLow()
+		}
+	} else if !space.Low && wasLow {
+		is.log.Info().Str("rootDir", is.rootDir).Uint64("freeBytes", free).Msg("disk space recovered, accepting uploads")
+	}
+
+	return space, nil
+}
+
+// DiskSpace returns the free space of the filesystem of the image store as of its last check.
+func (is *ImageStore) DiskSpace() DiskSpace {
+	is.diskSpace.lock.RLock()
+	defer is.diskSpace.lock.RUnlock()
+
+	return is.diskSpace.last
+}
+
+// checkUploadSpace refuses new uploads while the image store is low on disk space.
+func (is *ImageStore) checkUploadSpace() error {
+	if is.DiskSpace().Low {
+		return errors.ErrInsufficientStorage
+	}
+
+	return nil
+}
+
+type diskSpaceTask struct {
+	imgStore *ImageStore
+}
+
+// NewDiskSpaceTask returns a scheduler task which checks the free space of an image store.
+func NewDiskSpaceTask(imgStore *ImageStore) scheduler.Task {
+	return &diskSpaceTask{imgStore: imgStore}
+}
+
+func (t *diskSpaceTask) DoWork() error {
+	_, err := t.imgStore.CheckDiskSpace()
+
+	return err
+}
@@ -0,0 +1,19 @@
+// +build !windows
+
+package storage
+
+import (
+	"syscall"
+)
+
+// diskUsage returns the size of the filesystem of a directory and the space available to zot on it.
+func diskUsage(dir string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	// nolint: unconvert // the types of the fields depend on the platform
+	return uint64(stat.Blocks) * uint64(stat.Bsize), uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// diskUsage returns the size of the volume of a directory and the space available to zot on it.
+func diskUsage(dir string) (uint64, uint64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var free, total, totalFree uint64
+
+	if err := windows.GetDiskFreeSpaceEx(path, &free, &total, &totalFree); err != nil {
+		return 0, 0, err
+	}
+
+	return total, free, nil
+}
@@ -0,0 +1,389 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	goerrors "errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/anuvu/zot/errors"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// Encrypted blobs are a header followed by the blob contents sealed with AES-GCM in segments,
+// so that large blobs can be streamed. Each segment has its own nonce made of a random per-blob
+// prefix, the segment counter and a flag set on the last segment, so that segments can't be
+// reordered and a truncated blob can't be decrypted.
+const (
+	encryptionMagic     = "ZOTENC\x00\x01"
+	encryptionKeyIDSize = 8
+	noncePrefixSize     = 7
+	segmentSize         = 64 * 1024
+	encryptionKeySize   = 32
+	encryptionHeader    = len(encryptionMagic) + encryptionKeyIDSize + noncePrefixSize
+)
+
+// blobCipher encrypts blob contents at rest.
+type blobCipher struct {
+	aead  cipher.AEAD
+	keyID []byte
+}
+
+func newBlobCipher(key []byte) (*blobCipher, error) {
+	if len(key) != encryptionKeySize {
+		return nil, errors.ErrBadEncryptionKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// the key ID tells a blob encrypted with another key apart from a corrupted one
+	sum := sha256.Sum256(key)
+
+	return &blobCipher{aead: aead, keyID: sum[:encryptionKeyIDSize]}, nil
+}
+
+func (bc *blobCipher) nonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, bc.aead.NonceSize())
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+
+	return nonce
+}
+
+// encrypt writes the sealed contents of src to dst.
+func (bc *blobCipher) encrypt(dst io.Writer, src io.Reader) error {
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+
+	header := append(append([]byte(encryptionMagic), bc.keyID...), prefix...)
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(src, segmentSize)
+	buf := make([]byte, segmentSize, segmentSize+bc.aead.Overhead())
+
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, buf[:segmentSize])
+		if err != nil && !goerrors.Is(err, io.EOF) && !goerrors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+
+		last := err != nil
+		if !last {
+			if _, err := reader.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+
+		sealed := bc.aead.Seal(buf[:0], bc.nonce(prefix, counter, last), buf[:n], nil)
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// encryptFile replaces the contents of the file with their encrypted form.
+func (bc *blobCipher) encryptFile(file string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return replaceFile(file, func(dst io.Writer) error {
+		return bc.encrypt(dst, src)
+	})
+}
+
+// decryptFile replaces the contents of an encrypted file with their plaintext.
+func (bc *blobCipher) decryptFile(file string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	reader, err := bc.decrypt(src)
+	if err != nil {
+		src.Close()
+		return err
+	}
+	defer reader.Close()
+
+	return replaceFile(file, func(dst io.Writer) error {
+		_, err := io.Copy(dst, reader)
+		return err
+	})
+}
+
+// replaceFile atomically replaces the file with the content written by fill.
+func replaceFile(file string, fill func(io.Writer) error) error {
+	tmp, err := os.OpenFile(file+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := fill(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+// decrypt returns a reader of the plaintext of an encrypted blob, closing it closes the blob.
+func (bc *blobCipher) decrypt(blob io.ReadCloser) (io.ReadCloser, error) {
+	header := make([]byte, encryptionHeader)
+	if _, err := io.ReadFull(blob, header); err != nil {
+		return nil, errors.ErrBadEncryptedBlob
+	}
+
+	if string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, errors.ErrBadEncryptedBlob
+	}
+
+	if !bytes.Equal(header[len(encryptionMagic):len(encryptionMagic)+encryptionKeyIDSize], bc.keyID) {
+		return nil, errors.ErrBadEncryptionKey
+	}
+
+	return &decryptReader{
+		cipher: bc,
+		src:    bufio.NewReaderSize(blob, segmentSize+bc.aead.Overhead()),
+		closer: blob,
+		prefix: header[len(encryptionMagic)+encryptionKeyIDSize:],
+		buf:    make([]byte, segmentSize+bc.aead.Overhead()),
+	}, nil
+}
+
+type decryptReader struct {
+	cipher  *blobCipher
+	src     *bufio.Reader
+	closer  io.Closer
+	prefix  []byte
+	buf     []byte
+	plain   []byte
+	counter uint32
+	done    bool
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plain) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+
+		if err := dr.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, dr.plain)
+	dr.plain = dr.plain[n:]
+
+	return n, nil
+}
+
+// next decrypts the next segment.
+func (dr *decryptReader) next() error {
+	n, err := io.ReadFull(dr.src, dr.buf)
+
+	switch err {
+	case nil:
+		_, perr := dr.src.Peek(1)
+		dr.done = perr == io.EOF
+	case io.ErrUnexpectedEOF:
+		dr.done = true
+	case io.EOF:
+		// the last segment is always present, even if empty
+		return errors.ErrBadEncryptedBlob
+	default:
+		return err
+	}
+
+	plain, err := dr.cipher.aead.Open(dr.buf[:0], dr.cipher.nonce(dr.prefix, dr.counter, dr.done), dr.buf[:n], nil)
+	if err != nil {
+		return errors.ErrBadEncryptedBlob
+	}
+
+	dr.counter++
+	dr.plain = plain
+
+	return nil
+}
+
+func (dr *decryptReader) Close() error {
+	return dr.closer.Close()
+}
+
+// isEncryptedBlob returns true if the blob file starts with the encryption header.
+func isEncryptedBlob(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	return hasEncryptionMagic(f)
+}
+
+// hasEncryptionMagic checks the start of the file for the encryption header, and rewinds it.
+func hasEncryptionMagic(f io.ReadSeeker) (bool, error) {
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		if goerrors.Is(err, io.EOF) || goerrors.Is(err, io.ErrUnexpectedEOF) {
+			_, err = f.Seek(0, io.SeekStart)
+
+			return false, err
+		}
+
+		return false, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	return string(magic) == encryptionMagic, nil
+}
+
+// plaintextSize returns the size of the contents of an encrypted blob, given the size of its file.
+func (bc *blobCipher) plaintextSize(size int64) int64 {
+	sealed := int64(segmentSize + bc.aead.Overhead())
+	body := size - int64(encryptionHeader)
+	segments := (body + sealed - 1) / sealed
+
+	return body - segments*int64(bc.aead.Overhead())
+}
+
+// openBlob opens a blob for reading, it returns the reader of its plaintext and its size.
+// Stores without an encryption key serve blobs as they are on disk, the reader is then the file
+// itself so that it can be sent with sendfile, unless it's read with O_DIRECT.
+func (is *ImageStore) openBlob(blobPath string) (io.ReadCloser, int64, error) {
+	// encrypted blobs are sniffed with unaligned reads
+	blob, direct, err := openBlobFile(blobPath, is.directIO && is.cipher == nil)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	info, err := blob.Stat()
+	if err != nil {
+		blob.Close()
+		return nil, -1, err
+	}
+
+	if direct {
+		return newDirectReader(blob), info.Size(), nil
+	}
+
+	if is.cipher == nil {
+		return blob, info.Size(), nil
+	}
+
+	encrypted, err := hasEncryptionMagic(blob)
+	if err != nil {
+		blob.Close()
+		return nil, -1, err
+	}
+
+	if !encrypted {
+		return blob, info.Size(), nil
+	}
+
+	reader, err := is.cipher.decrypt(blob)
+	if err != nil {
+		blob.Close()
+		return nil, -1, err
+	}
+
+	return reader, is.cipher.plaintextSize(info.Size()), nil
+}
+
+// blobSize returns the size of the contents of a blob, which differs from the size of its file if encrypted.
+func (is *ImageStore) blobSize(blobPath string, size int64) (int64, error) {
+	if is.cipher == nil {
+		return size, nil
+	}
+
+	encrypted, err := isEncryptedBlob(blobPath)
+	if err != nil || !encrypted {
+		return size, err
+	}
+
+	return is.cipher.plaintextSize(size), nil
+}
+
+// sealUpload encrypts a finished upload before it's moved to the blobs of the repository.
+func (is *ImageStore) sealUpload(repo, src string) error {
+	if !is.encryptRepo(repo) {
+		return nil
+	}
+
+	return is.cipher.encryptFile(src)
+}
+
+// unsealBlob stores the blob in plaintext, metadata blobs like image configs are read from disk by GC and search.
+func (is *ImageStore) unsealBlob(repo string, digest godigest.Digest) error {
+	if is.cipher == nil {
+		return nil
+	}
+
+	blobPath := is.BlobPath(repo, digest)
+
+	encrypted, err := isEncryptedBlob(blobPath)
+	if err != nil || !encrypted {
+		return err
+	}
+
+	if err := is.cipher.decryptFile(blobPath); err != nil {
+		return err
+	}
+
+	is.log.Debug().Str("blob", filepath.Base(blobPath)).Msg("stored metadata blob in plaintext")
+
+	return nil
+}
+
+// SetEncryptionKey enables encryption at rest of the blobs written to the image store,
+// blobs stored in plaintext before are still readable.
+func (is *ImageStore) SetEncryptionKey(key []byte) error {
+	bc, err := newBlobCipher(key)
+	if err != nil {
+		return err
+	}
+
+	is.cipher = bc
+
+	return nil
+}
@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/scheduler"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// A tag expires a TTL after it's pushed if its manifest has the TTL annotation, e.g. "72h", or once
+// given one with SetTagExpiry. Its expiry time is kept as an annotation of its descriptor in the
+// index.json of the repository, and RemoveExpiredTags removes it, the blobs being left to GC.
+const (
+	AnnotationTagTTL     = "io.zot.tag.ttl"
+	AnnotationTagExpires = "io.zot.tag.expires"
+)
+
+// tagExpiry returns when a tag pushed now with a manifest of these annotations expires, the empty
+// string if it doesn't.
+func tagExpiry(annotations map[string]string, now time.Time) (string, error) {
+	value, ok := annotations[AnnotationTagTTL]
+	if !ok {
+		return "", nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return "", errors.ErrBadManifest
+	}
+
+	return now.Add(ttl).UTC().Format(time.RFC3339), nil
+}
+
+func setTagExpiry(annotations map[string]string, expires string) map[string]string {
+	if expires == "" {
+		delete(annotations, AnnotationTagExpires)
+		return annotations
+	}
+
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	annotations[AnnotationTagExpires] = expires
+
+	return annotations
+}
+
+// withTagExpiry records when the tag of a descriptor expires, if its manifest has a TTL.
+func withTagExpiry(desc ispec.Descriptor, expires string) ispec.Descriptor {
+	if _, ok := desc.Annotations[ispec.AnnotationRefName]; ok && expires != "" {
+		desc.Annotations[AnnotationTagExpires] = expires
+	}
+
+	return desc
+}
+
+// getTagExpiry returns when the tag of a descriptor expires, false if it doesn't.
+func getTagExpiry(desc ispec.Descriptor) (time.Time, bool) {
+	if _, ok := desc.Annotations[ispec.AnnotationRefName]; !ok {
+		return time.Time{}, false
+	}
+
+	expires, err := time.Parse(time.RFC3339, desc.Annotations[AnnotationTagExpires])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return expires, true
+}
+
+// SetTagExpiry makes a tag of the repository expire ttl from now, a ttl of zero clears its expiry.
+// It returns the expiry time. Immutable tags can't be given a TTL.
+func (is *ImageStore) SetTagExpiry(repo, tag string, ttl time.Duration) (time.Time, error) {
+	var expires time.Time
+
+	if ttl > 0 {
+		if is.isImmutableTag(repo, tag) {
+			return expires, errors.ErrImmutableTag
+		}
+
+		expires = time.Now().Add(ttl).UTC().Truncate(time.Second)
+	}
+
+	is.Lock()
+	defer is.Unlock()
+
+	index, err := is.readIndex(repo)
+	if err != nil {
+		return expires, err
+	}
+
+	found := false
+
+	for i, desc := range index.Manifests {
+		if desc.Annotations[ispec.AnnotationRefName] != tag {
+			continue
+		}
+
+		value := ""
+		if !expires.IsZero() {
+			value = expires.Format(time.RFC3339)
+		}
+
+		index.Manifests[i].Annotations = setTagExpiry(desc.Annotations, value)
+		found = true
+	}
+
+	if !found {
+		return expires, errors.ErrManifestNotFound
+	}
+
+	return expires, is.writeIndex(repo, index)
+}
+
+// GetTagExpiries returns when the tags of the repository which expire do.
+func (is *ImageStore) GetTagExpiries(repo string) (map[string]time.Time, error) {
+	is.RLock()
+	defer is.RUnlock()
+
+	index, err := is.readIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	expiries := make(map[string]time.Time)
+
+	for _, desc := range index.Manifests {
+		if expires, ok := getTagExpiry(desc); ok {
+			expiries[desc.Annotations[ispec.AnnotationRefName]] = expires
+		}
+	}
+
+	return expiries, nil
+}
+
+// SetPullRetention keeps the expired tags which were pulled within the window, by tag or by the digest of
+// their manifest, so that GC doesn't collect images still in use. Zero disables it.
+func (is *ImageStore) SetPullRetention(window time.Duration) {
+	atomic.StoreInt64(&is.retainPulledWithin, int64(window))
+}
+
+// PullRetention returns the window of SetPullRetention.
+func (is *ImageStore) PullRetention() time.Duration {
+	return time.Duration(atomic.LoadInt64(&is.retainPulledWithin))
+}
+
+// pulledSince tells whether the image of a tag descriptor was pulled after since, by tag or by digest.
+func pulledSince(stats RepoPullStats, desc ispec.Descriptor, since time.Time) bool {
+	for _, reference := range []string{desc.Annotations[ispec.AnnotationRefName], desc.Digest.String()} {
+		if count, ok := stats.Tags[reference]; ok && count.LastPull.After(since) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expiredTag returns when the tag of a descriptor expired, false if it doesn't expire, hasn't yet, or is kept
+// because it was pulled within the retention window.
+func expiredTag(desc ispec.Descriptor, stats RepoPullStats, now time.Time,
+	retainPulledWithin time.Duration) (time.Time, bool) {
+	expires, ok := getTagExpiry(desc)
+	if !ok || !expires.Before(now) {
+		return expires, false
+	}
+
+	if retainPulledWithin > 0 && pulledSince(stats, desc, now.Add(-retainPulledWithin)) {
+		return expires, false
+	}
+
+	return expires, true
+}
+
+// RemoveExpiredTags removes the tags of all repositories which expired before now, but not their
+// manifests and blobs, and returns how many were removed. Tags pulled within the retention window
+// are kept, as well as immutable tags.
+func (is *ImageStore) RemoveExpiredTags(now time.Time) (int, error) {
+	repos, err := is.GetRepositories()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+
+	for _, repo := range repos {
+		count, err := is.removeExpiredTags(repo, now)
+		if err != nil {
+			return removed, err
+		}
+
+		removed += count
+	}
+
+	return removed, nil
+}
+
+func (is *ImageStore) removeExpiredTags(repo string, now time.Time) (int, error) {
+	is.Lock()
+	defer is.Unlock()
+
+	var removed []ispec.Descriptor
+
+	stats := is.pullStats.Repo(repo)
+	retainPulledWithin := is.PullRetention()
+
+	if err := is.updateIndex(repo, func(index *ispec.Index) (bool, error) {
+		manifests := make([]ispec.Descriptor, 0, len(index.Manifests))
+		removed = []ispec.Descriptor{}
+
+		for _, desc := range index.Manifests {
+			if expires, ok := getTagExpiry(desc); ok && expires.Before(now) && !is.isImmutableDescriptor(repo, desc) {
+				if retainPulledWithin > 0 && pulledSince(stats, desc, now.Add(-retainPulledWithin)) {
+					is.log.Debug().Str("repo", repo).Str("tag", desc.Annotations[ispec.AnnotationRefName]).
+						Time("expires", expires).Msg("keeping expired tag pulled recently")
+
+					manifests = append(manifests, desc)
+
+					continue
+				}
+
+				is.log.Info().Str("repo", repo).Str("tag", desc.Annotations[ispec.AnnotationRefName]).
+					Time("expires", expires).Msg("removing expired tag")
+
+				removed = append(removed, desc)
+
+				continue
+			}
+
+			manifests = append(manifests, desc)
+		}
+
+		index.Manifests = manifests
+
+		return len(removed) > 0, nil
+	}); err != nil {
+		return 0, err
+	}
+
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	is.updateRepoMeta(repo)
+
+	for _, desc := range removed {
+		is.notifyChange(Change{Kind: ChangeDelete, Repo: repo, Tag: desc.Annotations[ispec.AnnotationRefName],
+			Digest: desc.Digest})
+	}
+
+	return len(removed), nil
+}
+
+type tagExpiryTask struct {
+	imgStore *ImageStore
+}
+
+// NewTagExpiryTask returns a scheduler task which removes the expired tags of an image store.
+func NewTagExpiryTask(imgStore *ImageStore) scheduler.Task {
+	return &tagExpiryTask{imgStore: imgStore}
+}
+
+func (t *tagExpiryTask) DoWork() error {
+	_, err := t.imgStore.RemoveExpiredTags(time.Now())
+
+	return err
+}
@@ -0,0 +1,420 @@
+package storage
+
+import (
+	"encoding/json"
+	goerrors "errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	zlog "github.com/anuvu/zot/pkg/log"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.etcd.io/bbolt"
+)
+
+// Kinds of the inconsistencies found by Fsck.
+const (
+	// FsckDanglingUpload is an upload left in a repository, none is in progress while zot is stopped.
+	FsckDanglingUpload = "danglingUpload"
+	// FsckOrphanBlob is a blob referenced by none of the manifests of its repository.
+	FsckOrphanBlob = "orphanBlob"
+	// FsckMissingManifest is an index.json entry of a manifest which isn't in the repository.
+	FsckMissingManifest = "missingManifest"
+	// FsckMissingBlob is a blob referenced by a manifest which isn't in the repository, it can't be fixed.
+	FsckMissingBlob = "missingBlob"
+	// FsckStaleDedupeRecord is a dedupe cache record of a blob file which doesn't exist anymore.
+	FsckStaleDedupeRecord = "staleDedupeRecord"
+	// FsckUnlinkedCopy is a file of a deduped blob which isn't a hard link of the file recorded in the
+	// dedupe cache, e.g. after the storage was copied to another filesystem.
+	FsckUnlinkedCopy = "unlinkedCopy"
+)
+
+// FsckIssue is an inconsistency of an image store, Path is relative to its root directory.
+type FsckIssue struct {
+	Kind   string          `json:"kind"`
+	Repo   string          `json:"repo,omitempty"`
+	Digest godigest.Digest `json:"digest,omitempty"`
+	Path   string          `json:"path"`
+	Fixed  bool            `json:"fixed"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// FsckReport reports the inconsistencies found in an image store, Summary counts them by kind.
+type FsckReport struct {
+	RootDir string         `json:"rootDir"`
+	Fix     bool           `json:"fix"`
+	Repos   int            `json:"repos"`
+	Blobs   int            `json:"blobs"`
+	Summary map[string]int `json:"summary"`
+	Fixed   int            `json:"fixed"`
+	Issues  []FsckIssue    `json:"issues"`
+}
+
+// Unfixed returns the number of issues which weren't fixed.
+func (r FsckReport) Unfixed() int {
+	return len(r.Issues) - r.Fixed
+}
+
+// fsckManifest has the descriptors of any kind of manifest, image manifests, indexes and artifacts.
+type fsckManifest struct {
+	Config    *ispec.Descriptor  `json:"config"`
+	Layers    []ispec.Descriptor `json:"layers"`
+	Manifests []ispec.Descriptor `json:"manifests"`
+	Blobs     []ispec.Descriptor `json:"blobs"`
+}
+
+// Fsck checks the consistency of the image store of a root directory while zot is stopped, and repairs
+// what can be if fix is true: dangling uploads and orphan blobs are removed, index.json entries of
+// missing manifests are dropped, and dedupe records and hard links are restored. The files of the blobs
+// of image stores deduped with reflinks aren't expected to be hard links.
+func Fsck(rootDir string, fix, reflink bool, log zlog.Logger) (FsckReport, error) {
+	report := FsckReport{RootDir: rootDir, Fix: fix, Summary: map[string]int{}, Issues: []FsckIssue{}}
+
+	if !dirExists(rootDir) {
+		return report, errors.ErrRepoNotFound
+	}
+
+	// holding the databases makes sure zot isn't running on the root directory
+	if _, err := openMetaDB(rootDir, log.Logger); err != nil {
+		if goerrors.Is(err, bbolt.ErrTimeout) {
+			return report, errors.ErrStorageInUse
+		}
+
+		return report, err
+	}
+
+	is := &ImageStore{rootDir: rootDir, lock: &sync.RWMutex{}, reflink: reflink, log: log.With().Caller().Logger()}
+
+	if _, err := os.Stat(path.Join(rootDir, "cache.db")); err == nil {
+		db, err := bbolt.Open(path.Join(rootDir, "cache.db"), 0600, &bbolt.Options{Timeout: time.Second})
+		if err != nil {
+			if goerrors.Is(err, bbolt.ErrTimeout) {
+				return report, errors.ErrStorageInUse
+			}
+
+			return report, err
+		}
+
+		is.cache = &Cache{rootDir: rootDir, db: db, log: log}
+		defer is.cache.Close()
+
+		// the blobs are deduped, except in the repositories the rules exempt
+		rules, err := readRepoRules(rootDir)
+		if err != nil {
+			return report, err
+		}
+
+		is.dedupe = true
+		is.setRepoRules(rules)
+	}
+
+	repos, err := is.getRepositories()
+	if err != nil {
+		return report, err
+	}
+
+	report.Repos = len(repos)
+
+	for _, repo := range repos {
+		if err := is.fsckRepo(repo, &report); err != nil {
+			return report, err
+		}
+	}
+
+	if is.cache != nil {
+		if err := is.fsckDedupe(&report); err != nil {
+			return report, err
+		}
+	}
+
+	for _, issue := range report.Issues {
+		report.Summary[issue.Kind]++
+
+		if issue.Fixed {
+			report.Fixed++
+		}
+	}
+
+	is.log.Info().Str("rootDir", rootDir).Int("issues", len(report.Issues)).Int("fixed", report.Fixed).
+		Msg("fsck done")
+
+	return report, nil
+}
+
+// addIssue records an issue, and fixes it unless fixing it isn't possible.
+func (r *FsckReport) addIssue(issue FsckIssue, repair func() error) {
+	if r.Fix && repair != nil {
+		if err := repair(); err != nil {
+			issue.Error = err.Error()
+		} else {
+			issue.Fixed = true
+		}
+	}
+
+	r.Issues = append(r.Issues, issue)
+}
+
+func (is *ImageStore) fsckRepo(repo string, report *FsckReport) error {
+	uploads, err := ioutil.ReadDir(path.Join(is.rootDir, repo, BlobUploadDir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, upload := range uploads {
+		file := path.Join(is.rootDir, repo, BlobUploadDir, upload.Name())
+
+		report.addIssue(FsckIssue{Kind: FsckDanglingUpload, Repo: repo, Path: is.relativePath(file)}, func() error {
+			return os.RemoveAll(file)
+		})
+	}
+
+	index, err := is.readIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[godigest.Digest]bool)
+	manifests := make([]ispec.Descriptor, 0, len(index.Manifests))
+	missing := []godigest.Digest{}
+
+	for _, desc := range index.Manifests {
+		if _, err := os.Stat(is.BlobPath(repo, desc.Digest)); err != nil {
+			if !containsDigest(missing, desc.Digest) {
+				missing = append(missing, desc.Digest)
+			}
+
+			continue
+		}
+
+		manifests = append(manifests, desc)
+
+		is.fsckManifest(repo, desc.Digest, referenced, report)
+	}
+
+	for _, digest := range missing {
+		report.addIssue(FsckIssue{Kind: FsckMissingManifest, Repo: repo, Digest: digest,
+			Path: path.Join(repo, "index.json")}, func() error {
+			index.Manifests = manifests
+
+			buf, err := json.Marshal(index)
+			if err != nil {
+				return err
+			}
+
+			return writeIndexFile(path.Join(is.rootDir, repo), buf)
+		})
+	}
+
+	return is.fsckOrphanBlobs(repo, referenced, report)
+}
+
+// fsckManifest marks the blobs of a manifest as referenced, and reports those which are missing.
+func (is *ImageStore) fsckManifest(repo string, digest godigest.Digest, referenced map[godigest.Digest]bool,
+	report *FsckReport) {
+	if referenced[digest] {
+		return
+	}
+
+	referenced[digest] = true
+
+	buf, err := ioutil.ReadFile(is.BlobPath(repo, digest))
+	if err != nil {
+		report.addIssue(FsckIssue{Kind: FsckMissingBlob, Repo: repo, Digest: digest,
+			Path: is.relativePath(is.BlobPath(repo, digest))}, nil)
+
+		return
+	}
+
+	var manifest fsckManifest
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		is.log.Warn().Err(err).Str("repo", repo).Str("digest", digest.String()).Msg("fsck: invalid manifest")
+		return
+	}
+
+	blobs := make([]ispec.Descriptor, 0, len(manifest.Layers)+len(manifest.Blobs)+1)
+	blobs = append(blobs, manifest.Layers...)
+	blobs = append(blobs, manifest.Blobs...)
+
+	if manifest.Config != nil {
+		blobs = append(blobs, *manifest.Config)
+	}
+
+	for _, desc := range blobs {
+		if referenced[desc.Digest] {
+			continue
+		}
+
+		referenced[desc.Digest] = true
+
+		if isForeignLayer(desc) {
+			continue
+		}
+
+		// cold blobs are symlinks, which must point to an existing file
+		if _, err := os.Stat(is.BlobPath(repo, desc.Digest)); err != nil {
+			report.addIssue(FsckIssue{Kind: FsckMissingBlob, Repo: repo, Digest: desc.Digest,
+				Path: is.relativePath(is.BlobPath(repo, desc.Digest))}, nil)
+		}
+	}
+
+	for _, desc := range manifest.Manifests {
+		is.fsckManifest(repo, desc.Digest, referenced, report)
+	}
+}
+
+func (is *ImageStore) fsckOrphanBlobs(repo string, referenced map[godigest.Digest]bool, report *FsckReport) error {
+	blobsDir := path.Join(is.rootDir, repo, "blobs")
+
+	algorithms, err := ioutil.ReadDir(blobsDir)
+	if err != nil {
+		return err
+	}
+
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() || !godigest.Algorithm(algorithm.Name()).Available() {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(path.Join(blobsDir, algorithm.Name()))
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			digest := godigest.NewDigestFromEncoded(godigest.Algorithm(algorithm.Name()), file.Name())
+			if digest.Validate() != nil {
+				continue
+			}
+
+			report.Blobs++
+
+			if referenced[digest] {
+				continue
+			}
+
+			blobPath := path.Join(blobsDir, algorithm.Name(), file.Name())
+
+			report.addIssue(FsckIssue{Kind: FsckOrphanBlob, Repo: repo, Digest: digest,
+				Path: is.relativePath(blobPath)}, func() error {
+				if err := os.Remove(blobPath); err != nil {
+					return err
+				}
+
+				if is.cache != nil && is.cache.HasBlob(digest.String(), is.relativePath(blobPath)) {
+					return is.cache.DeleteBlob(digest.String(), blobPath)
+				}
+
+				return nil
+			})
+		}
+	}
+
+	return nil
+}
+
+// fsckDedupe checks the dedupe records point to existing files, and the files of the deduped blobs
+// are hard links of the recorded ones, unless they're reflinks.
+func (is *ImageStore) fsckDedupe(report *FsckReport) error {
+	records, err := is.cache.Blobs()
+	if err != nil {
+		return err
+	}
+
+	digests := make([]string, 0, len(records))
+	for digest := range records {
+		digests = append(digests, digest)
+	}
+
+	sort.Strings(digests)
+
+	for _, digest := range digests {
+		digest := digest
+
+		for _, record := range records[digest] {
+			file := path.Join(is.rootDir, record)
+
+			if _, err := os.Lstat(file); err == nil {
+				continue
+			}
+
+			report.addIssue(FsckIssue{Kind: FsckStaleDedupeRecord, Digest: godigest.Digest(digest), Path: record},
+				func() error {
+					return is.cache.DeleteBlob(digest, file)
+				})
+		}
+	}
+
+	if is.reflink {
+		return nil
+	}
+
+	blobs, err := is.scanDedupedBlobs()
+	if err != nil {
+		return err
+	}
+
+	for digest, all := range blobs {
+		if _, ok := records[digest.String()]; !ok {
+			continue
+		}
+
+		// copies are only linked within their dedupe scope
+		for _, files := range is.byDedupeScope(all) {
+			is.fsckBlobCopies(report, digest, files)
+		}
+	}
+
+	return nil
+}
+
+// fsckBlobCopies checks the files of a deduped blob within a dedupe scope are hard links of the recorded one.
+func (is *ImageStore) fsckBlobCopies(report *FsckReport, digest godigest.Digest, files []blobFile) {
+	copies := blobCopies(files)
+	master := is.dedupeMaster(digest, copies)
+
+	for _, group := range copies {
+		if os.SameFile(group[0].info, master.info) {
+			continue
+		}
+
+		for _, file := range group {
+			file := file
+			issue := FsckIssue{Kind: FsckUnlinkedCopy, Repo: file.repo, Digest: digest,
+				Path: is.relativePath(file.path)}
+
+			if file.info.Size() != master.info.Size() {
+				issue.Error = "blob size doesn't match its digest"
+				report.addIssue(issue, nil)
+
+				continue
+			}
+
+			report.addIssue(issue, func() error {
+				if err := relink(master.path, file.path); err != nil {
+					return err
+				}
+
+				if !is.cache.HasBlob(digest.String(), is.relativePath(file.path)) {
+					return is.cache.PutBlob(digest.String(), file.path)
+				}
+
+				return nil
+			})
+		}
+	}
+}
+
+func containsDigest(digests []godigest.Digest, digest godigest.Digest) bool {
+	for _, d := range digests {
+		if d == digest {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	goerrors "errors"
+	"os"
+	"path"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/scheduler"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// SetGCOnDelete makes the image store remove the blobs of a deleted manifest which no other manifest of its
+// repository references as soon as it's deleted, rather than leaving them to the periodic GC, e.g. on
+// space-constrained edge deployments. A push reusing them at the same time may have to upload them again.
+func (is *ImageStore) SetGCOnDelete(enabled bool) {
+	is.gcOnDelete = enabled
+}
+
+// collectDeletedManifest removes a deleted manifest and its blobs, or a deleted index and the manifests it
+// references with their blobs, except those the manifests left in the repository still reference. Deduped
+// blobs are only unlinked from the repository and removed from the cache, the other repositories keep their
+// links to them. The image store lock must be held.
+func (is *ImageStore) collectDeletedManifest(repo string, digest godigest.Digest,
+	manifests []ispec.Descriptor) error {
+	referenced := map[godigest.Digest]bool{}
+
+	if err := is.markBlobs(repo, manifests, referenced, nil); err != nil {
+		return err
+	}
+
+	// the manifest is still referenced, e.g. by another tag or by an index
+	if referenced[digest] {
+		return nil
+	}
+
+	deleted := map[godigest.Digest]bool{}
+
+	if err := is.markBlobs(repo, []ispec.Descriptor{{Digest: digest}}, deleted, nil); err != nil {
+		return err
+	}
+
+	for blob := range deleted {
+		if referenced[blob] {
+			continue
+		}
+
+		blobPath := is.BlobPath(repo, blob)
+
+		if is.dedupeRepo(repo) {
+			if err := is.uncacheBlob(repo, blob); err != nil {
+				return err
+			}
+		}
+
+		if err := os.Remove(blobPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		is.log.Info().Str("repo", repo).Str("digest", blob.String()).Msg("removed blob of deleted manifest")
+	}
+
+	return nil
+}
+
+// uncacheBlob removes the blob of a repository from the dedupe cache before it's removed. If it's the copy
+// the cache records, which new copies are linked to, the record moves to the copy of another repository of
+// its dedupe scope, if any, so that they keep being deduped.
+func (is *ImageStore) uncacheBlob(repo string, digest godigest.Digest) error {
+	blobPath := is.BlobPath(repo, digest)
+
+	record, err := is.cachedBlob(repo, digest.String())
+	if err != nil {
+		if goerrors.Is(err, errors.ErrCacheMiss) {
+			return nil
+		}
+
+		return err
+	}
+
+	if path.Join(is.rootDir, record) == blobPath {
+		repos, err := is.getRepositories()
+		if err != nil {
+			return err
+		}
+
+		for _, other := range repos {
+			if other == repo || !is.dedupeRepo(other) || is.dedupeScope(other) != is.dedupeScope(repo) {
+				continue
+			}
+
+			if _, err := os.Stat(is.BlobPath(other, digest)); err == nil {
+				if err := is.cache.PutBlob(digest.String(), is.BlobPath(other, digest)); err != nil {
+					return err
+				}
+
+				break
+			}
+		}
+	}
+
+	if err := is.cache.DeleteBlob(digest.String(), blobPath); err != nil && !goerrors.Is(err, errors.ErrCacheMiss) {
+		return err
+	}
+
+	return nil
+}
+
+// RunGCRepo garbage-collects unreferenced blobs of a repository.
+func (is *ImageStore) RunGCRepo(repo string) error {
+	dir := path.Join(is.rootDir, repo)
+	if !dirExists(dir) {
+		return errors.ErrRepoNotFound
+	}
+
+	is.Lock()
+	defer is.Unlock()
+
+	if err := is.garbageCollect(dir, repo); err != nil {
+		is.log.Error().Err(err).Str("repo", repo).Msg("unable to run GC")
+		return err
+	}
+
+	return nil
+}
+
+type gcTask struct {
+	imgStore *ImageStore
+}
+
+// NewGCTask returns a scheduler task which garbage-collects all repositories of an image store.
+func NewGCTask(imgStore *ImageStore) scheduler.Task {
+	return &gcTask{imgStore: imgStore}
+}
+
+func (t *gcTask) DoWork() error {
+	repos, err := t.imgStore.GetRepositories()
+	if err != nil {
+		return err
+	}
+
+	t.imgStore.log.Info().Str("rootDir", t.imgStore.rootDir).Int("repos", len(repos)).Msg("running periodic GC")
+
+	for _, repo := range repos {
+		if err := t.imgStore.RunGCRepo(repo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// gcManifest has the references of manifests and indexes, whatever their media type.
+type gcManifest struct {
+	Config    *ispec.Descriptor  `json:"config"`
+	Layers    []ispec.Descriptor `json:"layers"`
+	Manifests []ispec.Descriptor `json:"manifests"`
+}
+
+// garbageCollect removes the blobs of a repository which aren't referenced anymore. Blobs are marked from
+// index.json whatever the media types of the manifests, OCI or Docker, and layers aren't opened, foreign
+// ones not being stored. The image store lock must be held.
+func (is *ImageStore) garbageCollect(dir, repo string) error {
+	buf, err := ioutil.ReadFile(path.Join(dir, "index.json"))
+	if err != nil {
+		return err
+	}
+
+	var index gcManifest
+	if err := json.Unmarshal(buf, &index); err != nil {
+		return err
+	}
+
+	referenced := map[godigest.Digest]bool{}
+
+	if err := is.markBlobs(repo, index.Manifests, referenced, nil); err != nil {
+		return err
+	}
+
+	policy := ifOlderThan(is, repo, gcDelay)
+	blobsDir := path.Join(dir, "blobs")
+
+	algorithms, err := ioutil.ReadDir(blobsDir)
+	if err != nil {
+		return err
+	}
+
+	for _, algorithm := range algorithms {
+		blobs, err := ioutil.ReadDir(path.Join(blobsDir, algorithm.Name()))
+		if err != nil {
+			return err
+		}
+
+		for _, blob := range blobs {
+			digest := godigest.NewDigestFromEncoded(godigest.Algorithm(algorithm.Name()), blob.Name())
+			if referenced[digest] {
+				continue
+			}
+
+			remove, err := policy(context.Background(), digest)
+			if err != nil {
+				return err
+			}
+
+			if remove {
+				if err := os.Remove(path.Join(blobsDir, algorithm.Name(), blob.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// markBlobs marks the blobs of manifests, and those of the manifests of indexes, as referenced. The manifests
+// and indexes themselves are also marked in marked, if not nil.
+func (is *ImageStore) markBlobs(repo string, manifests []ispec.Descriptor, referenced,
+	marked map[godigest.Digest]bool) error {
+	for _, desc := range manifests {
+		if referenced[desc.Digest] {
+			continue
+		}
+
+		referenced[desc.Digest] = true
+
+		if marked != nil {
+			marked[desc.Digest] = true
+		}
+
+		blob, _, err := is.openBlob(is.BlobPath(repo, desc.Digest))
+		if err != nil {
+			return err
+		}
+
+		var manifest gcManifest
+
+		err = json.NewDecoder(blob).Decode(&manifest)
+		blob.Close()
+
+		if err != nil {
+			return err
+		}
+
+		if manifest.Config != nil {
+			referenced[manifest.Config.Digest] = true
+		}
+
+		for _, layer := range manifest.Layers {
+			referenced[layer.Digest] = true
+		}
+
+		children := make([]ispec.Descriptor, 0, len(manifest.Manifests))
+
+		for _, child := range manifest.Manifests {
+			if !is.isSparseChild(repo, child.Digest) {
+				children = append(children, child)
+			}
+		}
+
+		if err := is.markBlobs(repo, children, referenced, marked); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
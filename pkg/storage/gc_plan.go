@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxPlannedManifestSize bounds the blobs read to tell untagged manifests from layers.
+const maxPlannedManifestSize = 4 * 1024 * 1024
+
+// GCPlan lists what tag expiry followed by GC would remove from an image store, for operators to review
+// before they run. Manifests are those of the expired tags and the manifests already untagged, Blobs the
+// other blobs no manifest references anymore, except those pushed within the GC delay, as GC does.
+// ReclaimedBytes is the space freed once hard links are accounted for, a blob also linked from a kept
+// repository freeing none. GC tells whether GC runs in this image store, the plan being computed either way.
+type GCPlan struct {
+	RootDir        string       `json:"rootDir"`
+	GC             bool         `json:"gc"`
+	Tags           []GCPlanTag  `json:"tags"`
+	Manifests      []GCPlanBlob `json:"manifests"`
+	Blobs          []GCPlanBlob `json:"blobs"`
+	ReclaimedBytes int64        `json:"reclaimedBytes"`
+}
+
+// GCPlanTag is an expired tag, to be removed by tag expiry.
+type GCPlanTag struct {
+	Repo    string          `json:"repo"`
+	Tag     string          `json:"tag"`
+	Digest  godigest.Digest `json:"digest"`
+	Expires time.Time       `json:"expires"`
+}
+
+// GCPlanBlob is a blob file of a repository GC would remove.
+type GCPlanBlob struct {
+	Repo   string          `json:"repo"`
+	Digest godigest.Digest `json:"digest"`
+	Size   int64           `json:"size"`
+}
+
+// PlanGC computes what tag expiry and GC would remove if they ran at now, without removing anything.
+func (is *ImageStore) PlanGC(now time.Time) (GCPlan, error) {
+	is.RLock()
+	defer is.RUnlock()
+
+	plan := GCPlan{RootDir: is.rootDir, GC: is.gc, Tags: []GCPlanTag{}, Manifests: []GCPlanBlob{},
+		Blobs: []GCPlanBlob{}}
+
+	repos, err := is.getRepositories()
+	if err != nil {
+		return plan, err
+	}
+
+	files, err := is.scanBlobs()
+	if err != nil {
+		return plan, err
+	}
+
+	retainPulledWithin := is.PullRetention()
+	referenced := make(map[string]map[godigest.Digest]bool, len(repos))
+	manifests := make(map[string]map[godigest.Digest]bool, len(repos))
+
+	for _, repo := range repos {
+		index, err := is.readIndex(repo)
+		if err != nil {
+			return plan, err
+		}
+
+		stats := is.pullStats.Repo(repo)
+		kept := make([]ispec.Descriptor, 0, len(index.Manifests))
+		expired := []ispec.Descriptor{}
+
+		for _, desc := range index.Manifests {
+			if expires, ok := expiredTag(desc, stats, now, retainPulledWithin); ok &&
+				!is.isImmutableDescriptor(repo, desc) {
+				plan.Tags = append(plan.Tags, GCPlanTag{Repo: repo, Tag: desc.Annotations[ispec.AnnotationRefName],
+					Digest: desc.Digest, Expires: expires})
+				expired = append(expired, desc)
+
+				continue
+			}
+
+			kept = append(kept, desc)
+		}
+
+		referenced[repo] = make(map[godigest.Digest]bool)
+		manifests[repo] = make(map[godigest.Digest]bool)
+
+		if err := is.markBlobs(repo, kept, referenced[repo], nil); err != nil {
+			return plan, err
+		}
+
+		// the manifests of the expired tags, and those of their indexes, are told apart from layers
+		if err := is.markBlobs(repo, expired, make(map[godigest.Digest]bool), manifests[repo]); err != nil {
+			return plan, err
+		}
+	}
+
+	for digest, blobFiles := range files {
+		for _, copies := range blobCopies(blobFiles) {
+			reclaimed := true
+
+			for _, file := range copies {
+				if referenced[file.repo][digest] || file.info.ModTime().Add(gcDelay).After(now) {
+					reclaimed = false
+
+					continue
+				}
+
+				blob := GCPlanBlob{Repo: file.repo, Digest: digest, Size: file.info.Size()}
+
+				if manifests[file.repo][digest] || isManifestFile(file) {
+					plan.Manifests = append(plan.Manifests, blob)
+				} else {
+					plan.Blobs = append(plan.Blobs, blob)
+				}
+			}
+
+			if reclaimed {
+				plan.ReclaimedBytes += copies[0].info.Size()
+			}
+		}
+	}
+
+	sort.Slice(plan.Tags, func(i, j int) bool {
+		if plan.Tags[i].Repo != plan.Tags[j].Repo {
+			return plan.Tags[i].Repo < plan.Tags[j].Repo
+		}
+
+		return plan.Tags[i].Tag < plan.Tags[j].Tag
+	})
+	sortGCPlanBlobs(plan.Manifests)
+	sortGCPlanBlobs(plan.Blobs)
+
+	return plan, nil
+}
+
+func sortGCPlanBlobs(blobs []GCPlanBlob) {
+	sort.Slice(blobs, func(i, j int) bool {
+		if blobs[i].Repo != blobs[j].Repo {
+			return blobs[i].Repo < blobs[j].Repo
+		}
+
+		return blobs[i].Digest < blobs[j].Digest
+	})
+}
+
+// isManifestFile tells whether a blob file is a manifest or an index, which unlike configs and layers are JSON
+// documents with a schema version.
+func isManifestFile(file blobFile) bool {
+	if file.info.Size() > maxPlannedManifestSize {
+		return false
+	}
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	var manifest struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+
+	return json.NewDecoder(f).Decode(&manifest) == nil && manifest.SchemaVersion > 0
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// SetImmutableTagCheck sets the function telling whether a tag of a repository is immutable. Immutable tags
+// can't be moved to another manifest nor deleted, which is checked while the store lock is held so that
+// concurrent first pushes of a tag can't both succeed.
+func (is *ImageStore) SetImmutableTagCheck(check func(repo, tag string) bool) {
+	is.immutableTag = check
+}
+
+// WithoutTagPolicy returns a view of the image store which doesn't enforce immutable tags, e.g. to roll back
+// the first push of a tag.
+func (is *ImageStore) WithoutTagPolicy() *ImageStore {
+	view := is.view()
+	view.immutableTag = nil
+
+	return view
+}
+
+func (is *ImageStore) isImmutableTag(repo, tag string) bool {
+	return is.immutableTag != nil && is.immutableTag(repo, tag)
+}
+
+// isImmutableDescriptor returns true if the descriptor of an index is tagged with an immutable tag.
+func (is *ImageStore) isImmutableDescriptor(repo string, desc ispec.Descriptor) bool {
+	tag, ok := desc.Annotations[ispec.AnnotationRefName]
+
+	return ok && is.isImmutableTag(repo, tag)
+}
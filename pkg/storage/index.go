@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/anuvu/zot/errors"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DefaultMaxIndexDepth is the default nesting depth of indexes, an index of image manifests being of depth 1
+// and an index of such indexes of depth 2.
+const DefaultMaxIndexDepth = 4
+
+// maxIndexUpdates is the number of times an update of an index.json is attempted, the index.json being read
+// again and the update applied anew each time another writer changed it meanwhile.
+const maxIndexUpdates = 16
+
+// indexLockFile is the lock file of a repository directory serializing the check that its index.json is
+// unchanged and the rename of its update over it, between all the image stores opened on its root directory,
+// in this process or another one.
+const indexLockFile = ".index.json.lock"
+
+// SetIndexPolicy bounds the nesting depth of the indexes pushed to the image store, DefaultMaxIndexDepth if
+// zero. With sparse, indexes may reference manifests which weren't pushed, e.g. the platforms left out when
+// mirroring a multi-arch image, the manifests which were pushed are still checked.
+func (is *ImageStore) SetIndexPolicy(maxDepth int, sparse bool) {
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxIndexDepth
+	}
+
+	is.maxIndexDepth = maxDepth
+	is.sparseIndexes = sparse
+}
+
+// isSparseChild tells whether a manifest referenced by an index is left out of the repository, which only
+// sparse indexes may do.
+func (is *ImageStore) isSparseChild(repo string, digest godigest.Digest) bool {
+	if !is.sparseIndexes {
+		return false
+	}
+
+	_, err := os.Lstat(is.BlobPath(repo, digest))
+
+	return os.IsNotExist(err)
+}
+
+// checkIndex checks the manifests of a pushed index, and those of the indexes it references: they must
+// have been pushed to the repository first, unless indexes are sparse, and be pulled from it rather than
+// from their URLs, and indexes must not be nested deeper than the maximum depth. It returns the digest of
+// the offending manifest, if any.
+func (is *ImageStore) checkIndex(repo string, index ispec.Index, depth int) (godigest.Digest, error) {
+	if depth > is.maxIndexDepth {
+		is.log.Error().Int("depth", depth).Int("maxDepth", is.maxIndexDepth).Msg("index nested too deeply")
+		return "", errors.ErrIndexTooDeep
+	}
+
+	for _, desc := range index.Manifests {
+		if err := desc.Digest.Validate(); err != nil {
+			is.log.Error().Err(err).Str("digest", desc.Digest.String()).Msg("invalid manifest digest in index")
+			return desc.Digest, errors.ErrBadManifest
+		}
+
+		if len(desc.URLs) > 0 {
+			is.log.Error().Str("digest", desc.Digest.String()).Strs("urls", desc.URLs).
+				Msg("index references a manifest outside the repository")
+			return desc.Digest, errors.ErrForeignManifest
+		}
+
+		blobPath := is.BlobPath(repo, desc.Digest)
+
+		if !IsIndexMediaType(desc.MediaType) {
+			if _, err := os.Stat(blobPath); err != nil && !(os.IsNotExist(err) && is.sparseIndexes) {
+				is.log.Error().Err(err).Str("blobPath", blobPath).Msg("unable to find manifest")
+				return desc.Digest, errors.ErrIndexManifestNotFound
+			}
+
+			continue
+		}
+
+		buf, err := ioutil.ReadFile(blobPath)
+		if err != nil {
+			if os.IsNotExist(err) && is.sparseIndexes {
+				continue
+			}
+
+			is.log.Error().Err(err).Str("blobPath", blobPath).Msg("unable to find manifest")
+
+			return desc.Digest, errors.ErrIndexManifestNotFound
+		}
+
+		var child ispec.Index
+		if err := json.Unmarshal(buf, &child); err != nil {
+			is.log.Error().Err(err).Str("blobPath", blobPath).Msg("unable to unmarshal JSON")
+			return desc.Digest, errors.ErrBadManifest
+		}
+
+		if digest, err := is.checkIndex(repo, child, depth+1); err != nil {
+			if digest == "" {
+				digest = desc.Digest
+			}
+
+			return digest, err
+		}
+	}
+
+	return "", nil
+}
+
+func (is *ImageStore) readIndex(repo string) (ispec.Index, error) {
+	index, _, err := is.readIndexFile(repo)
+
+	return index, err
+}
+
+// readIndexFile returns the index.json of the repository, along with its contents to tell whether it was
+// changed later on.
+func (is *ImageStore) readIndexFile(repo string) (ispec.Index, []byte, error) {
+	var index ispec.Index
+
+	dir := path.Join(is.rootDir, repo)
+	if !dirExists(dir) {
+		return index, nil, errors.ErrRepoNotFound
+	}
+
+	buf, err := ioutil.ReadFile(path.Join(dir, "index.json"))
+	if err != nil {
+		is.log.Error().Err(err).Str("dir", dir).Msg("failed to read index.json")
+		return index, nil, err
+	}
+
+	if err := json.Unmarshal(buf, &index); err != nil {
+		is.log.Error().Err(err).Str("dir", dir).Msg("invalid JSON")
+		return index, nil, errors.ErrRepoBadVersion
+	}
+
+	return index, buf, nil
+}
+
+// updateIndex applies update to the index.json of the repository, update tells whether it changed the index.
+// Writers don't lock each other out for the whole update: if another one changed the index.json after it was
+// read, e.g. an image store opened on the same root directory pushing another tag, it's read again and update
+// is applied anew, so update may be called several times and shouldn't have side effects it can't repeat.
+func (is *ImageStore) updateIndex(repo string, update func(index *ispec.Index) (bool, error)) error {
+	for attempt := 1; attempt <= maxIndexUpdates; attempt++ {
+		index, buf, err := is.readIndexFile(repo)
+		if err != nil {
+			return err
+		}
+
+		changed, err := update(&index)
+		if err != nil || !changed {
+			return err
+		}
+
+		committed, err := is.commitIndex(repo, buf, index)
+		if err != nil || committed {
+			return err
+		}
+
+		is.log.Debug().Str("repo", repo).Int("attempt", attempt).Msg("index.json changed meanwhile, updating it again")
+	}
+
+	is.log.Error().Str("repo", repo).Int("attempts", maxIndexUpdates).Msg("index.json keeps changing, giving up")
+
+	return errors.ErrIndexConflict
+}
+
+// commitIndex replaces the index.json of the repository with index, unless it isn't the one read, previous,
+// anymore. It tells whether it was replaced.
+func (is *ImageStore) commitIndex(repo string, previous []byte, index ispec.Index) (bool, error) {
+	buf, err := json.Marshal(index)
+	if err != nil {
+		return false, err
+	}
+
+	dir := path.Join(is.rootDir, repo)
+
+	tmp, err := stageIndexFile(dir, buf)
+	if err != nil {
+		is.log.Error().Err(err).Str("dir", dir).Msg("unable to write index.json")
+		return false, err
+	}
+	defer os.Remove(tmp)
+
+	unlock, err := lockFile(path.Join(dir, indexLockFile))
+	if err != nil {
+		is.log.Error().Err(err).Str("dir", dir).Msg("unable to lock index.json")
+		return false, err
+	}
+	defer unlock()
+
+	file := path.Join(dir, "index.json")
+
+	current, err := ioutil.ReadFile(file)
+	if err != nil {
+		is.log.Error().Err(err).Str("file", file).Msg("failed to read index.json")
+		return false, err
+	}
+
+	if !bytes.Equal(current, previous) {
+		return false, nil
+	}
+
+	if err := os.Rename(tmp, file); err != nil {
+		is.log.Error().Err(err).Str("file", file).Msg("unable to write")
+		return false, err
+	}
+
+	return true, nil
+}
+
+// writeIndex replaces the index.json of the repository, whatever it is now.
+func (is *ImageStore) writeIndex(repo string, index ispec.Index) error {
+	buf, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	dir := path.Join(is.rootDir, repo)
+
+	if err := writeIndexFile(dir, buf); err != nil {
+		is.log.Error().Err(err).Str("dir", dir).Msg("unable to write index.json")
+		return err
+	}
+
+	return nil
+}
+
+// writeIndexFile replaces the index.json of a repository directory with buf, it's renamed over the index.json
+// so that readers, and zot if it crashes, never see a partially written one.
+func writeIndexFile(dir string, buf []byte) error {
+	tmp, err := stageIndexFile(dir, buf)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path.Join(dir, "index.json")); err != nil {
+		_ = os.Remove(tmp)
+
+		return err
+	}
+
+	return nil
+}
+
+// createIndexFile creates the index.json of a repository directory with buf, unless it already exists, in which
+// case the error satisfies os.IsExist.
+func createIndexFile(dir string, buf []byte) error {
+	tmp, err := stageIndexFile(dir, buf)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	// unlike a rename, a link doesn't replace the index.json another writer created meanwhile
+	return os.Link(tmp, path.Join(dir, "index.json"))
+}
+
+// stageIndexFile writes buf to a temporary file of a repository directory, to be renamed over its index.json.
+func stageIndexFile(dir string, buf []byte) (string, error) {
+	f, err := ioutil.TempFile(dir, ".index.json-")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return "", err
+	}
+
+	// the contents must be on disk before the rename is
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	// nolint: gosec
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// Ways of linking the files of a blob deduped in several repositories.
+const (
+	// DedupeHardLink hard links them, they share their permissions and owner.
+	DedupeHardLink = "hardlink"
+	// DedupeReflink clones them, they share their extents until written but are files of their own, on
+	// filesystems supporting reflinks like XFS and btrfs.
+	DedupeReflink = "reflink"
+	// DedupeAuto clones them if the filesystem supports reflinks, and hard links them otherwise.
+	DedupeAuto = "auto"
+)
+
+// SetReflink dedupes blobs with reflinks instead of hard links.
+func (is *ImageStore) SetReflink(enabled bool) {
+	is.reflink = enabled
+}
+
+// linkBlob makes dst a hard link of src, or a reflink if the image store dedupes with reflinks, falling back
+// to a copy if it can't be cloned so that its permissions stay its own. dst must not exist.
+func (is *ImageStore) linkBlob(src, dst string) error {
+	if !is.reflink {
+		return hardLinkBlob(src, dst)
+	}
+
+	if cloneFile(src, dst) == nil {
+		return nil
+	}
+
+	return copyFile(src, dst)
+}
+
+// hardLinkBlob makes dst a hard link of src. Hard links aren't available everywhere, e.g. on FAT filesystems,
+// and their number per file is limited, to 1023 on NTFS, so a reflink, and then a copy, are made instead.
+// dst must not exist.
+func hardLinkBlob(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	// dst is there, or src isn't, a copy wouldn't do better
+	if os.IsExist(err) || os.IsNotExist(err) {
+		return err
+	}
+
+	if cloneFile(src, dst) == nil {
+		return nil
+	}
+
+	return copyFile(src, dst)
+}
+
+// ValidateReflink checks whether the filesystem of a root directory supports reflinks.
+func ValidateReflink(rootDir string) error {
+	src, dst := path.Join(rootDir, "reflinkcheck.txt"), path.Join(rootDir, "dupreflinkcheck.txt")
+
+	err := ioutil.WriteFile(src, []byte("check whether reflinks work on filesystem"), 0644) //nolint: gosec
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(src)
+
+	if err := cloneFile(src, dst); err != nil {
+		return err
+	}
+
+	return os.Remove(dst)
+}
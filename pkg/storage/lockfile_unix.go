@@ -0,0 +1,34 @@
+// +build !windows
+
+package storage
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/anuvu/zot/errors"
+)
+
+// lockFile takes an exclusive lock on a file, created if needed, which the other processes locking it wait for,
+// e.g. zot instances sharing the storage. The returned function releases it.
+func lockFile(file string) (func(), error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if !errors.Is(err, syscall.EINTR) {
+			break
+		}
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// closing the file releases the lock
+	return func() { f.Close() }, nil
+}
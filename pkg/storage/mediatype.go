@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"encoding/json"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Docker V2 schema2 media types, still pushed by many clients.
+const (
+	DockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+	DockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	DockerConfigMediaType       = "application/vnd.docker.container.image.v1+json"
+	DockerLayerMediaType        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	DockerTarLayerMediaType     = "application/vnd.docker.image.rootfs.diff.tar"
+	DockerForeignLayerMediaType = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+)
+
+// Formats of the manifests, which are converted to the other one for the clients which don't accept theirs.
+const (
+	OCIFormat    = "oci"
+	DockerFormat = "docker"
+)
+
+// dockerToOCI maps the Docker media types to their OCI equivalents, foreign layers to non-distributable ones.
+var dockerToOCI = map[string]string{
+	DockerManifestMediaType:     ispec.MediaTypeImageManifest,
+	DockerManifestListMediaType: ispec.MediaTypeImageIndex,
+	DockerConfigMediaType:       ispec.MediaTypeImageConfig,
+	DockerLayerMediaType:        ispec.MediaTypeImageLayerGzip,
+	DockerTarLayerMediaType:     ispec.MediaTypeImageLayer,
+	DockerForeignLayerMediaType: ispec.MediaTypeImageLayerNonDistributableGzip,
+}
+
+// ociToDocker maps the OCI media types to their Docker equivalents, the others having none.
+var ociToDocker = func() map[string]string {
+	types := make(map[string]string, len(dockerToOCI))
+	for docker, oci := range dockerToOCI {
+		types[oci] = docker
+	}
+
+	return types
+}()
+
+// ManifestFormat returns the format of the manifests of the media type.
+func ManifestFormat(mediaType string) string {
+	if _, ok := dockerToOCI[mediaType]; ok {
+		return DockerFormat
+	}
+
+	return OCIFormat
+}
+
+// EquivalentMediaType returns the media type of the other format which the media type converts to, if any.
+func EquivalentMediaType(mediaType string) (string, bool) {
+	if equivalent, ok := dockerToOCI[mediaType]; ok {
+		return equivalent, true
+	}
+
+	equivalent, ok := ociToDocker[mediaType]
+
+	return equivalent, ok
+}
+
+// IsImageManifestMediaType tells whether the media type is the one of an OCI or a Docker image manifest.
+func IsImageManifestMediaType(mediaType string) bool {
+	return mediaType == ispec.MediaTypeImageManifest || mediaType == DockerManifestMediaType
+}
+
+// IsIndexMediaType tells whether the media type is the one of an OCI index or a Docker manifest list.
+func IsIndexMediaType(mediaType string) bool {
+	return mediaType == ispec.MediaTypeImageIndex || mediaType == DockerManifestListMediaType
+}
+
+// IsManifestMediaType tells whether manifests of the media type can be pushed.
+func IsManifestMediaType(mediaType string) bool {
+	return IsImageManifestMediaType(mediaType) || IsIndexMediaType(mediaType)
+}
+
+// isForeignLayer tells whether the layer is pulled from its URLs rather than from the registry, as the base
+// layers of Windows images are.
+func isForeignLayer(layer ispec.Descriptor) bool {
+	if len(layer.URLs) == 0 {
+		return false
+	}
+
+	switch layer.MediaType {
+	case DockerForeignLayerMediaType, ispec.MediaTypeImageLayerNonDistributable,
+		ispec.MediaTypeImageLayerNonDistributableGzip, ispec.MediaTypeImageLayerNonDistributableZstd:
+		return true
+	default:
+		return false
+	}
+}
+
+// ConvertDockerManifest converts the media types of a Docker manifest to the OCI ones, and returns the
+// converted manifest.
+func ConvertDockerManifest(manifest *ispec.Manifest) ([]byte, error) {
+	if mediaType, ok := dockerToOCI[manifest.Config.MediaType]; ok {
+		manifest.Config.MediaType = mediaType
+	}
+
+	for i, layer := range manifest.Layers {
+		if mediaType, ok := dockerToOCI[layer.MediaType]; ok {
+			manifest.Layers[i].MediaType = mediaType
+		}
+	}
+
+	return json.Marshal(manifest)
+}
@@ -0,0 +1,1226 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/rs/zerolog"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// MetaDBFile holds the metadata of the repositories of an image store, under its root directory.
+	MetaDBFile = "meta.db"
+	// ReposMetaBucket records a RepoMeta for each repository.
+	ReposMetaBucket = "repos"
+	// DigestsMetaBucket indexes the tagged images by the digests of their manifest, config and layers,
+	// with a bucket for each digest holding "<repo>:<tag>" keys.
+	DigestsMetaBucket = "digests"
+	// GitVersionsMetaBucket indexes the tagged images by the git version of the sources they were built from,
+	// with a bucket for each version holding "<repo>:<tag>" keys.
+	GitVersionsMetaBucket = "gitversions"
+	// StackerYamlAnnotation holds the stacker.yaml an image was built with by stacker.
+	StackerYamlAnnotation = "ws.tycho.stacker.stacker_yaml"
+	// StackerGitVersionAnnotation holds the git version of the sources an image was built from by stacker.
+	StackerGitVersionAnnotation = "ws.tycho.stacker.git_version"
+	// NotationSignatureArtifactType identifies notation signatures stored as referrers of an image manifest.
+	NotationSignatureArtifactType = "application/vnd.cncf.notary.signature"
+	// TagHistoryMetaBucket records the changes of the tags, with a bucket for each repository holding
+	// a bucket for each tag, whose TagEvents are keyed by their sequence number.
+	TagHistoryMetaBucket = "taghistory"
+	// MaxTagHistory is the number of changes kept for each tag, older ones are dropped.
+	MaxTagHistory = 100
+	// MinDigestPrefixLen is the number of hex characters a short digest needs, as for git short hashes,
+	// so that tags such as "2021" aren't mistaken for digests.
+	MinDigestPrefixLen = 7
+)
+
+// TagEvent is a change of the manifest a tag points to, the tag being created if Previous is empty,
+// and deleted if Digest is empty.
+type TagEvent struct {
+	Digest   godigest.Digest `json:"digest,omitempty"`
+	Previous godigest.Digest `json:"previous,omitempty"`
+	Time     time.Time       `json:"time"`
+}
+
+// ScanSummary is the result of the last CVE scan of an image.
+type ScanSummary struct {
+	Scanned time.Time `json:"scanned"`
+	// CVEs lists the ids of the vulnerabilities found, sorted.
+	CVEs []string `json:"cves"`
+	// Severities counts the vulnerabilities found by severity.
+	Severities map[string]int `json:"severities"`
+	// CVESeverities maps the ids of the vulnerabilities found to their severity.
+	CVESeverities map[string]string `json:"cveSeverities,omitempty"`
+}
+
+// ProvenanceSummary is the result of the last verification of the provenance attestations of an image.
+type ProvenanceSummary struct {
+	// Checked is when the result was recorded, it's recorded again when it changes.
+	Checked time.Time `json:"checked"`
+	Valid   bool      `json:"valid"`
+	// Attestation is the digest of the referrer holding the valid attestation, if any.
+	Attestation   godigest.Digest `json:"attestation,omitempty"`
+	PredicateType string          `json:"predicateType,omitempty"`
+	Builder       string          `json:"builder,omitempty"`
+	// Reason tells why no attestation is valid.
+	Reason string `json:"reason,omitempty"`
+}
+
+// LintSummary is the result of the lint of an image when it was pushed.
+type LintSummary struct {
+	Checked time.Time `json:"checked"`
+	Passed  bool      `json:"passed"`
+	// Violations lists the rules the image breaks.
+	Violations []string `json:"violations,omitempty"`
+}
+
+// ManifestMeta describes an image manifest of a repository.
+type ManifestMeta struct {
+	Digest       godigest.Digest    `json:"digest"`
+	MediaType    string             `json:"mediaType,omitempty"`
+	ConfigDigest godigest.Digest    `json:"configDigest"`
+	Layers       []ispec.Descriptor `json:"layers"`
+	Annotations  map[string]string  `json:"annotations,omitempty"`
+	// Size is the size of the manifest, config and layers of the image.
+	Size int64 `json:"size"`
+	// Created is when the image was built, or pushed if its config doesn't say.
+	Created time.Time `json:"created"`
+	// Subject and ArtifactType are set on the manifests of referrers, such as signatures.
+	Subject      godigest.Digest    `json:"subject,omitempty"`
+	ArtifactType string             `json:"artifactType,omitempty"`
+	Scan         *ScanSummary       `json:"scan,omitempty"`
+	Provenance   *ProvenanceSummary `json:"provenance,omitempty"`
+	Lint         *LintSummary       `json:"lint,omitempty"`
+	// Manifests are the manifests of an index or a manifest list, one per platform.
+	Manifests []godigest.Digest `json:"manifests,omitempty"`
+}
+
+// BuildInfo describes how an image was built, from the annotations stacker sets on its manifest.
+type BuildInfo struct {
+	StackerYaml string
+	GitVersion  string
+}
+
+// BuildInfo returns how the image was built, nil if its manifest has no stacker annotations.
+func (mm ManifestMeta) BuildInfo() *BuildInfo {
+	stackerYaml, gitVersion := mm.Annotations[StackerYamlAnnotation], mm.Annotations[StackerGitVersionAnnotation]
+	if stackerYaml == "" && gitVersion == "" {
+		return nil
+	}
+
+	return &BuildInfo{StackerYaml: stackerYaml, GitVersion: gitVersion}
+}
+
+// RepoMeta describes the manifests and tags of a repository.
+type RepoMeta struct {
+	Name      string                           `json:"name"`
+	Tags      map[string]godigest.Digest       `json:"tags"`
+	Manifests map[godigest.Digest]ManifestMeta `json:"manifests"`
+	// Statistics are read from the pull statistics, they aren't saved in the database.
+	Statistics RepoPullStats `json:"-"`
+}
+
+// MatchDigests returns the digests of the manifests starting with the given prefix, which can leave out
+// the algorithm, sorted.
+func (rm RepoMeta) MatchDigests(prefix string) []godigest.Digest {
+	digests := []godigest.Digest{}
+
+	for digest := range rm.Manifests {
+		if strings.HasPrefix(digest.String(), prefix) || strings.HasPrefix(digest.Encoded(), prefix) {
+			digests = append(digests, digest)
+		}
+	}
+
+	sort.Slice(digests, func(i, j int) bool {
+		return digests[i] < digests[j]
+	})
+
+	return digests
+}
+
+// Referrers returns the manifests whose subject is the given digest, with the given artifact type
+// unless artifactType is empty.
+func (rm RepoMeta) Referrers(digest godigest.Digest, artifactType string) []ManifestMeta {
+	referrers := []ManifestMeta{}
+
+	for _, m := range rm.Manifests {
+		if m.Subject == digest && (artifactType == "" || m.ArtifactType == artifactType) {
+			referrers = append(referrers, m)
+		}
+	}
+
+	sort.Slice(referrers, func(i, j int) bool {
+		return referrers[i].Digest < referrers[j].Digest
+	})
+
+	return referrers
+}
+
+// IsSigned tells whether the manifest has notation signatures.
+func (rm RepoMeta) IsSigned(digest godigest.Digest) bool {
+	return len(rm.Referrers(digest, NotationSignatureArtifactType)) != 0
+}
+
+// Size returns the size of the manifests of the repository, the layers shared by several of them counted once.
+func (rm RepoMeta) Size() int64 {
+	size := int64(0)
+	layers := make(map[godigest.Digest]bool)
+
+	for _, m := range rm.Manifests {
+		size += m.Size
+
+		// the manifests of an index are counted on their own
+		for _, digest := range m.Manifests {
+			size -= rm.Manifests[digest].Size
+		}
+
+		for _, layer := range m.Layers {
+			if layers[layer.Digest] {
+				size -= layer.Size
+			}
+
+			layers[layer.Digest] = true
+		}
+	}
+
+	return size
+}
+
+// metaDB records the metadata of the repositories of an image store, so that extensions don't have to
+// parse the OCI layouts. It's updated whenever a repository's index.json is written, and each repository
+// is checked against its index.json once after the store is opened, in case it was changed while zot was down.
+type metaDB struct {
+	// incremented on each write, accessed atomically so it comes first to be 64-bit aligned
+	generation uint64
+	db         *bbolt.DB
+	lock       sync.Mutex
+	// repositories checked against their index.json
+	synced map[string]bool
+	// whether all the repositories of the root directory were checked, later ones are created by pushes
+	allSynced bool
+}
+
+type metaDBFile struct {
+	db   *bbolt.DB
+	file os.FileInfo
+}
+
+// metaDBFiles shares the database of a root directory between the image stores opened on it,
+// a bbolt database can't be opened twice.
+var (
+	metaDBFiles     = map[string]metaDBFile{} // nolint: gochecknoglobals
+	metaDBFilesLock sync.Mutex                // nolint: gochecknoglobals
+)
+
+func openMetaDB(rootDir string, log zerolog.Logger) (*metaDB, error) {
+	rootDir = filepath.Clean(rootDir)
+
+	metaDBFilesLock.Lock()
+	defer metaDBFilesLock.Unlock()
+
+	dbPath := path.Join(rootDir, MetaDBFile)
+
+	if f, ok := metaDBFiles[rootDir]; ok {
+		// unless the root directory was deleted and created again
+		if fi, err := os.Stat(dbPath); err == nil && os.SameFile(fi, f.file) {
+			return &metaDB{db: f.db, synced: make(map[string]bool)}, nil
+		}
+
+		f.db.Close()
+		delete(metaDBFiles, rootDir)
+	}
+
+	db, err := bbolt.Open(dbPath, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Error().Err(err).Str("dbPath", dbPath).Msg("unable to open metadata db")
+		return nil, err
+	}
+
+	file, err := os.Stat(dbPath)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range []string{ReposMetaBucket, DigestsMetaBucket, GitVersionsMetaBucket,
+			TagHistoryMetaBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		log.Error().Err(err).Str("dbPath", dbPath).Msg("unable to create a root bucket")
+		db.Close()
+
+		return nil, err
+	}
+
+	metaDBFiles[rootDir] = metaDBFile{db: db, file: file}
+
+	return &metaDB{db: db, synced: make(map[string]bool)}, nil
+}
+
+func (mdb *metaDB) isSynced(repo string) bool {
+	mdb.lock.Lock()
+	defer mdb.lock.Unlock()
+
+	return mdb.synced[repo]
+}
+
+func (mdb *metaDB) setSynced(repo string, synced bool) {
+	mdb.lock.Lock()
+	defer mdb.lock.Unlock()
+
+	if synced {
+		mdb.synced[repo] = true
+	} else {
+		delete(mdb.synced, repo)
+	}
+}
+
+func (mdb *metaDB) get(repo string) (RepoMeta, bool, error) {
+	var rm RepoMeta
+
+	found := false
+
+	err := mdb.db.View(func(tx *bbolt.Tx) error {
+		buf := tx.Bucket([]byte(ReposMetaBucket)).Get([]byte(repo))
+		if buf == nil {
+			return nil
+		}
+
+		found = true
+
+		return json.Unmarshal(buf, &rm)
+	})
+
+	return rm, found, err
+}
+
+func (mdb *metaDB) list() ([]RepoMeta, error) {
+	repos := []RepoMeta{}
+
+	err := mdb.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(ReposMetaBucket)).ForEach(func(k, v []byte) error {
+			var rm RepoMeta
+			if err := json.Unmarshal(v, &rm); err != nil {
+				return err
+			}
+
+			repos = append(repos, rm)
+
+			return nil
+		})
+	})
+
+	return repos, err
+}
+
+// touch increments the generation of the metadata.
+func (mdb *metaDB) touch() {
+	atomic.AddUint64(&mdb.generation, 1)
+}
+
+func (mdb *metaDB) put(rm RepoMeta) error {
+	defer mdb.touch()
+
+	buf, err := json.Marshal(rm)
+	if err != nil {
+		return err
+	}
+
+	return mdb.db.Update(func(tx *bbolt.Tx) error {
+		if err := recordTagChanges(tx, rm.Name, rm.Tags); err != nil {
+			return err
+		}
+
+		if err := removeRepoIndexes(tx, rm.Name); err != nil {
+			return err
+		}
+
+		if err := addRepoIndexes(tx, rm); err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(ReposMetaBucket)).Put([]byte(rm.Name), buf)
+	})
+}
+
+func (mdb *metaDB) delete(repo string) error {
+	defer mdb.touch()
+
+	return mdb.db.Update(func(tx *bbolt.Tx) error {
+		if err := recordTagChanges(tx, repo, nil); err != nil {
+			return err
+		}
+
+		if err := removeRepoIndexes(tx, repo); err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(ReposMetaBucket)).Delete([]byte(repo))
+	})
+}
+
+// prune deletes the repositories which aren't in the list.
+func (mdb *metaDB) prune(repoList []string) error {
+	defer mdb.touch()
+
+	keep := make(map[string]bool, len(repoList))
+	for _, repo := range repoList {
+		keep[repo] = true
+	}
+
+	return mdb.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(ReposMetaBucket))
+
+		stale := []string{}
+
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if !keep[string(k)] {
+				stale = append(stale, string(k))
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, repo := range stale {
+			if err := recordTagChanges(tx, repo, nil); err != nil {
+				return err
+			}
+
+			if err := removeRepoIndexes(tx, repo); err != nil {
+				return err
+			}
+
+			if err := bucket.Delete([]byte(repo)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// recordTagChanges records the tags of the repository which were created, moved to another manifest or deleted
+// since its saved metadata, tags are all deleted along with the repository if tags is nil.
+func recordTagChanges(tx *bbolt.Tx, repo string, tags map[string]godigest.Digest) error {
+	var saved RepoMeta
+
+	if buf := tx.Bucket([]byte(ReposMetaBucket)).Get([]byte(repo)); buf != nil {
+		// changes can't be told apart from the tags of unreadable metadata, which are recorded as created
+		_ = json.Unmarshal(buf, &saved)
+	}
+
+	now := time.Now().UTC()
+
+	for tag, digest := range tags {
+		if previous := saved.Tags[tag]; previous != digest {
+			if err := appendTagEvent(tx, repo, tag, TagEvent{Digest: digest, Previous: previous, Time: now}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for tag, previous := range saved.Tags {
+		if _, ok := tags[tag]; !ok {
+			if err := appendTagEvent(tx, repo, tag, TagEvent{Previous: previous, Time: now}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func appendTagEvent(tx *bbolt.Tx, repo, tag string, event TagEvent) error {
+	repoBucket, err := tx.Bucket([]byte(TagHistoryMetaBucket)).CreateBucketIfNotExists([]byte(repo))
+	if err != nil {
+		return err
+	}
+
+	tagBucket, err := repoBucket.CreateBucketIfNotExists([]byte(tag))
+	if err != nil {
+		return err
+	}
+
+	seq, err := tagBucket.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if err := tagBucket.Put(tagEventKey(seq), buf); err != nil {
+		return err
+	}
+
+	if seq > MaxTagHistory {
+		return tagBucket.Delete(tagEventKey(seq - MaxTagHistory))
+	}
+
+	return nil
+}
+
+// tagEventKey returns the key of a tag event, big endian so that events are sorted by their sequence number.
+func tagEventKey(seq uint64) []byte {
+	key := make([]byte, 8) //nolint: gomnd
+
+	binary.BigEndian.PutUint64(key, seq)
+
+	return key
+}
+
+func (mdb *metaDB) tagHistory(repo, tag string) ([]TagEvent, error) {
+	events := []TagEvent{}
+
+	err := mdb.db.View(func(tx *bbolt.Tx) error {
+		repoBucket := tx.Bucket([]byte(TagHistoryMetaBucket)).Bucket([]byte(repo))
+		if repoBucket == nil {
+			return nil
+		}
+
+		tagBucket := repoBucket.Bucket([]byte(tag))
+		if tagBucket == nil {
+			return nil
+		}
+
+		return tagBucket.ForEach(func(k, v []byte) error {
+			var event TagEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+
+			events = append(events, event)
+
+			return nil
+		})
+	})
+
+	return events, err
+}
+
+// forEachImageDigest calls fn with the digests of the manifest, config and layers of each tagged image.
+func forEachImageDigest(rm RepoMeta, fn func(digest godigest.Digest, tag string) error) error {
+	for tag, digest := range rm.Tags {
+		mm := rm.Manifests[digest]
+
+		digests := []godigest.Digest{digest, mm.ConfigDigest}
+		for _, layer := range mm.Layers {
+			digests = append(digests, layer.Digest)
+		}
+
+		for _, d := range digests {
+			if d == "" {
+				continue
+			}
+
+			if err := fn(d, tag); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// forEachIndexKey calls fn with each index of the tagged images and the keys of an image in it:
+// the digests of its manifest, config and layers, and the git version it was built from.
+func forEachIndexKey(tx *bbolt.Tx, rm RepoMeta, fn func(index *bbolt.Bucket, key []byte, tag string) error) error {
+	digests := tx.Bucket([]byte(DigestsMetaBucket))
+
+	if err := forEachImageDigest(rm, func(digest godigest.Digest, tag string) error {
+		return fn(digests, []byte(digest), tag)
+	}); err != nil {
+		return err
+	}
+
+	gitVersions := tx.Bucket([]byte(GitVersionsMetaBucket))
+
+	for tag, digest := range rm.Tags {
+		if build := rm.Manifests[digest].BuildInfo(); build != nil && build.GitVersion != "" {
+			if err := fn(gitVersions, []byte(build.GitVersion), tag); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func addRepoIndexes(tx *bbolt.Tx, rm RepoMeta) error {
+	return forEachIndexKey(tx, rm, func(index *bbolt.Bucket, key []byte, tag string) error {
+		bucket, err := index.CreateBucketIfNotExists(key)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(rm.Name+":"+tag), nil)
+	})
+}
+
+// removeRepoIndexes removes the saved metadata of the repository from the digest and git version indexes.
+func removeRepoIndexes(tx *bbolt.Tx, repo string) error {
+	buf := tx.Bucket([]byte(ReposMetaBucket)).Get([]byte(repo))
+	if buf == nil {
+		return nil
+	}
+
+	var rm RepoMeta
+	if err := json.Unmarshal(buf, &rm); err != nil {
+		// can't be cleaned up, search results are checked against the repository metadata anyway
+		return nil
+	}
+
+	return forEachIndexKey(tx, rm, func(index *bbolt.Bucket, key []byte, tag string) error {
+		bucket := index.Bucket(key)
+		if bucket == nil {
+			return nil
+		}
+
+		if err := bucket.Delete([]byte(repo + ":" + tag)); err != nil {
+			return err
+		}
+
+		if k, _ := bucket.Cursor().First(); k == nil {
+			return index.DeleteBucket(key)
+		}
+
+		return nil
+	})
+}
+
+// searchDigest returns the tags of each repository with an image matching the digest prefix,
+// the prefix can leave out the algorithm.
+func (mdb *metaDB) searchDigest(prefix string) (map[string][]string, error) {
+	prefixes := []string{prefix}
+
+	if !strings.Contains(prefix, ":") {
+		for _, alg := range []godigest.Algorithm{godigest.SHA256, godigest.SHA384, godigest.SHA512} {
+			prefixes = append(prefixes, alg.String()+":"+prefix)
+		}
+	}
+
+	found := map[string]map[string]bool{}
+
+	err := mdb.db.View(func(tx *bbolt.Tx) error {
+		digests := tx.Bucket([]byte(DigestsMetaBucket))
+		cursor := digests.Cursor()
+
+		for _, p := range prefixes {
+			for k, _ := cursor.Seek([]byte(p)); k != nil && bytes.HasPrefix(k, []byte(p)); k, _ = cursor.Next() {
+				if err := collectIndexedImages(digests.Bucket(k), found); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return sortedIndexedImages(found), err
+}
+
+// searchGitVersion returns the tags of each repository with an image built from the git version.
+func (mdb *metaDB) searchGitVersion(version string) (map[string][]string, error) {
+	found := map[string]map[string]bool{}
+
+	err := mdb.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(GitVersionsMetaBucket)).Bucket([]byte(version))
+		if bucket == nil {
+			return nil
+		}
+
+		return collectIndexedImages(bucket, found)
+	})
+
+	return sortedIndexedImages(found), err
+}
+
+// collectIndexedImages adds the "<repo>:<tag>" keys of an index bucket to the tags found for each repository.
+func collectIndexedImages(bucket *bbolt.Bucket, found map[string]map[string]bool) error {
+	return bucket.ForEach(func(image, _ []byte) error {
+		// repository names can't have a colon, tags might
+		i := bytes.IndexByte(image, ':')
+		repo, tag := string(image[:i]), string(image[i+1:])
+
+		if found[repo] == nil {
+			found[repo] = map[string]bool{}
+		}
+
+		found[repo][tag] = true
+
+		return nil
+	})
+}
+
+func sortedIndexedImages(found map[string]map[string]bool) map[string][]string {
+	images := make(map[string][]string, len(found))
+
+	for repo, tags := range found {
+		for tag := range tags {
+			images[repo] = append(images[repo], tag)
+		}
+
+		sort.Strings(images[repo])
+	}
+
+	return images
+}
+
+// GetRepoMeta returns the metadata of a repository.
+func (is *ImageStore) GetRepoMeta(repo string) (RepoMeta, error) {
+	is.RLock()
+	defer is.RUnlock()
+
+	rm, err := is.getRepoMeta(repo)
+	if err != nil {
+		return rm, err
+	}
+
+	rm.Statistics = is.pullStats.Repo(repo)
+
+	return rm, nil
+}
+
+func (is *ImageStore) getRepoMeta(repo string) (RepoMeta, error) {
+	if !is.metaDB.isSynced(repo) {
+		return is.syncRepoMeta(repo)
+	}
+
+	rm, found, err := is.metaDB.get(repo)
+	if err != nil {
+		is.log.Error().Err(err).Str("repo", repo).Msg("unable to read repository metadata")
+		return rm, err
+	}
+
+	if !found {
+		return rm, errors.ErrRepoNotFound
+	}
+
+	return rm, nil
+}
+
+// TagHistory returns the changes of the manifest a tag points to, the oldest first, they are kept for
+// deleted tags and repositories too. Only the last MaxTagHistory changes of a tag are kept.
+func (is *ImageStore) TagHistory(repo, tag string) ([]TagEvent, error) {
+	is.RLock()
+	defer is.RUnlock()
+
+	// the changes made while zot was down are recorded first
+	_, err := is.getRepoMeta(repo)
+	if err != nil && !goerrors.Is(err, errors.ErrRepoNotFound) {
+		return nil, err
+	}
+
+	events, herr := is.metaDB.tagHistory(repo, tag)
+	if herr != nil {
+		is.log.Error().Err(herr).Str("repo", repo).Str("tag", tag).Msg("unable to read tag history")
+		return nil, herr
+	}
+
+	if len(events) == 0 && err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// GetReposMeta returns the metadata of all repositories, sorted by name. The repositories are checked
+// against their index.json in parallel the first time, those which can't be read are skipped.
+func (is *ImageStore) GetReposMeta() ([]RepoMeta, error) {
+	is.RLock()
+	defer is.RUnlock()
+
+	if err := is.syncAllReposMeta(); err != nil {
+		return nil, err
+	}
+
+	repos, err := is.metaDB.list()
+	if err != nil {
+		is.log.Error().Err(err).Msg("unable to read repository metadata")
+		return nil, err
+	}
+
+	pullStats := is.pullStats.Repos()
+	result := make([]RepoMeta, 0, len(repos))
+
+	for _, rm := range repos {
+		// repositories which couldn't be read
+		if !is.metaDB.isSynced(rm.Name) {
+			continue
+		}
+
+		rm.Statistics = pullStats[rm.Name]
+		result = append(result, rm)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// Generation returns a number incremented on each change of the repositories, or of their metadata, so that
+// the results computed from them can be cached until it changes. It starts over when the store is opened.
+func (is *ImageStore) Generation() uint64 {
+	return atomic.LoadUint64(&is.metaDB.generation)
+}
+
+// SearchDigest returns the tags of each repository with an image whose manifest, config or a layer has a digest
+// starting with the given prefix, which can leave out the algorithm. It's served from an index of the digests.
+func (is *ImageStore) SearchDigest(prefix string) (map[string][]string, error) {
+	is.RLock()
+	defer is.RUnlock()
+
+	if err := is.syncAllReposMeta(); err != nil {
+		return nil, err
+	}
+
+	images, err := is.metaDB.searchDigest(prefix)
+	if err != nil {
+		is.log.Error().Err(err).Str("digest", prefix).Msg("unable to search digests")
+		return nil, err
+	}
+
+	for repo := range images {
+		// repositories which couldn't be read
+		if !is.metaDB.isSynced(repo) {
+			delete(images, repo)
+		}
+	}
+
+	return images, nil
+}
+
+// SearchGitVersion returns the tags of each repository with an image built by stacker from the given git version.
+// It's served from an index of the git versions.
+func (is *ImageStore) SearchGitVersion(version string) (map[string][]string, error) {
+	is.RLock()
+	defer is.RUnlock()
+
+	if err := is.syncAllReposMeta(); err != nil {
+		return nil, err
+	}
+
+	images, err := is.metaDB.searchGitVersion(version)
+	if err != nil {
+		is.log.Error().Err(err).Str("gitVersion", version).Msg("unable to search git versions")
+		return nil, err
+	}
+
+	for repo := range images {
+		// repositories which couldn't be read
+		if !is.metaDB.isSynced(repo) {
+			delete(images, repo)
+		}
+	}
+
+	return images, nil
+}
+
+// IsDigestPrefix returns whether the reference can be a short digest, at least MinDigestPrefixLen
+// hex characters, optionally following the algorithm.
+func IsDigestPrefix(reference string) bool {
+	encoded := reference
+
+	if i := strings.IndexByte(reference, ':'); i >= 0 {
+		if !godigest.Algorithm(reference[:i]).Available() {
+			return false
+		}
+
+		encoded = reference[i+1:]
+	}
+
+	if len(encoded) < MinDigestPrefixLen {
+		return false
+	}
+
+	for _, c := range encoded {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ResolveDigest returns the digest of the only manifest of the repository starting with the given short
+// digest. ErrAmbiguousDigest lists the candidates if several manifests match.
+func (is *ImageStore) ResolveDigest(repo, prefix string) (godigest.Digest, error) {
+	if !IsDigestPrefix(prefix) {
+		return "", errors.ErrManifestNotFound
+	}
+
+	is.RLock()
+	defer is.RUnlock()
+
+	rm, err := is.getRepoMeta(repo)
+	if err != nil {
+		return "", err
+	}
+
+	digests := rm.MatchDigests(prefix)
+
+	switch len(digests) {
+	case 0:
+		return "", errors.ErrManifestNotFound
+	case 1:
+		return digests[0], nil
+	default:
+		candidates := make([]string, 0, len(digests))
+		for _, digest := range digests {
+			candidates = append(candidates, digest.String())
+		}
+
+		return "", fmt.Errorf("%w: %s matches %s", errors.ErrAmbiguousDigest, prefix, strings.Join(candidates, ", "))
+	}
+}
+
+// syncAllReposMeta checks the repositories of the root directory against their index.json in parallel,
+// the first time only, and removes the metadata of those which don't exist anymore.
+func (is *ImageStore) syncAllReposMeta() error {
+	is.metaDB.lock.Lock()
+	allSynced := is.metaDB.allSynced
+	is.metaDB.lock.Unlock()
+
+	if allSynced {
+		return nil
+	}
+
+	repoList, err := is.getRepositories()
+	if err != nil {
+		return err
+	}
+
+	unsynced := []string{}
+
+	for _, repo := range repoList {
+		if !is.metaDB.isSynced(repo) {
+			unsynced = append(unsynced, repo)
+		}
+	}
+
+	if err := is.metaDB.prune(repoList); err != nil {
+		is.log.Error().Err(err).Msg("unable to delete the metadata of deleted repositories")
+		return err
+	}
+
+	is.syncReposMeta(unsynced)
+
+	is.metaDB.lock.Lock()
+	is.metaDB.allSynced = true
+	is.metaDB.lock.Unlock()
+
+	return nil
+}
+
+func (is *ImageStore) syncReposMeta(repoList []string) {
+	start := time.Now()
+	queue := make(chan string)
+
+	var wg sync.WaitGroup
+
+	workers := runtime.NumCPU()
+	if is.lowMemory {
+		workers = 1
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for repo := range queue {
+				if _, err := is.syncRepoMeta(repo); err != nil {
+					// a broken repository shouldn't hide all the others
+					is.log.Error().Err(err).Str("repo", repo).Msg("unable to read repository metadata, skipping")
+				}
+			}
+		}()
+	}
+
+	for _, repo := range repoList {
+		queue <- repo
+	}
+
+	close(queue)
+	wg.Wait()
+
+	is.log.Info().Int("repos", len(repoList)).Str("duration", time.Since(start).String()).
+		Msg("checked repository metadata")
+}
+
+// updateRepoMeta records the changes of a repository after its index.json was written,
+// the caller must hold the store lock.
+func (is *ImageStore) updateRepoMeta(repo string) {
+	if _, err := is.syncRepoMeta(repo); err != nil {
+		is.log.Error().Err(err).Str("repo", repo).Msg("unable to update repository metadata, reading it again on next use")
+	}
+}
+
+// syncRepoMeta records the manifests listed by the index.json of the repository, only those
+// not already known are read.
+func (is *ImageStore) syncRepoMeta(repo string) (RepoMeta, error) {
+	rm := RepoMeta{Name: repo, Tags: map[string]godigest.Digest{}, Manifests: map[godigest.Digest]ManifestMeta{}}
+
+	index, err := is.readIndex(repo)
+	if err != nil {
+		is.metaDB.setSynced(repo, false)
+
+		if goerrors.Is(err, errors.ErrRepoNotFound) {
+			_ = is.metaDB.delete(repo)
+		}
+
+		return rm, err
+	}
+
+	known, _, err := is.metaDB.get(repo)
+	if err != nil {
+		// start over
+		is.log.Error().Err(err).Str("repo", repo).Msg("unable to read repository metadata")
+	}
+
+	for _, desc := range index.Manifests {
+		mm, ok := known.Manifests[desc.Digest]
+		if !ok {
+			mm, err = is.readManifestMeta(repo, desc)
+			if err != nil {
+				is.metaDB.setSynced(repo, false)
+				return rm, err
+			}
+		}
+
+		rm.Manifests[desc.Digest] = mm
+
+		if tag, ok := desc.Annotations[ispec.AnnotationRefName]; ok {
+			rm.Tags[tag] = desc.Digest
+		}
+	}
+
+	if err := is.metaDB.put(rm); err != nil {
+		is.log.Error().Err(err).Str("repo", repo).Msg("unable to write repository metadata")
+		is.metaDB.setSynced(repo, false)
+
+		return rm, err
+	}
+
+	is.metaDB.setSynced(repo, true)
+
+	return rm, nil
+}
+
+type metaManifest struct {
+	ispec.Manifest
+	Subject      *ispec.Descriptor `json:"subject,omitempty"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+}
+
+func (is *ImageStore) readManifestMeta(repo string, desc ispec.Descriptor) (ManifestMeta, error) {
+	mm := ManifestMeta{Digest: desc.Digest, MediaType: desc.MediaType, Size: desc.Size}
+
+	manifestPath := is.BlobPath(repo, desc.Digest)
+
+	buf, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		is.log.Error().Err(err).Str("digest", desc.Digest.String()).Msg("failed to read manifest")
+		return mm, err
+	}
+
+	if IsIndexMediaType(desc.MediaType) {
+		return is.readIndexMeta(repo, mm, manifestPath, buf)
+	}
+
+	var m metaManifest
+	if err := json.Unmarshal(buf, &m); err != nil {
+		is.log.Error().Err(err).Str("digest", desc.Digest.String()).Msg("invalid JSON")
+		return mm, err
+	}
+
+	mm.ConfigDigest = m.Config.Digest
+	mm.Layers = m.Layers
+	mm.Annotations = m.Annotations
+	mm.ArtifactType = m.ArtifactType
+	mm.Size += m.Config.Size
+
+	if m.Subject != nil {
+		mm.Subject = m.Subject.Digest
+	}
+
+	for _, layer := range m.Layers {
+		// foreign layers aren't stored
+		if !isForeignLayer(layer) {
+			mm.Size += layer.Size
+		}
+	}
+
+	// fall back to the time the image was pushed if it doesn't say when it was created
+	var config ispec.Image
+
+	if buf, err := ioutil.ReadFile(is.BlobPath(repo, m.Config.Digest)); err == nil &&
+		json.Unmarshal(buf, &config) == nil {
+		switch {
+		case config.Created != nil:
+			mm.Created = *config.Created
+
+			return mm, nil
+		case len(config.History) != 0 && config.History[0].Created != nil:
+			mm.Created = *config.History[0].Created
+
+			return mm, nil
+		}
+	}
+
+	fi, err := os.Stat(manifestPath)
+	if err != nil {
+		return mm, err
+	}
+
+	mm.Created = fi.ModTime()
+
+	return mm, nil
+}
+
+// readIndexMeta reads the metadata of an index or a manifest list, its size includes the one of its manifests
+// and it was created along with the latest one of them.
+func (is *ImageStore) readIndexMeta(repo string, mm ManifestMeta, indexPath string, buf []byte) (ManifestMeta, error) {
+	var index ispec.Index
+	if err := json.Unmarshal(buf, &index); err != nil {
+		is.log.Error().Err(err).Str("digest", mm.Digest.String()).Msg("invalid JSON")
+		return mm, err
+	}
+
+	mm.Annotations = index.Annotations
+
+	for _, desc := range index.Manifests {
+		manifest, err := is.readManifestMeta(repo, desc)
+		if err != nil {
+			// the manifest was deleted since
+			continue
+		}
+
+		mm.Manifests = append(mm.Manifests, desc.Digest)
+		mm.Size += manifest.Size
+
+		if manifest.Created.After(mm.Created) {
+			mm.Created = manifest.Created
+		}
+	}
+
+	if mm.Created.IsZero() {
+		fi, err := os.Stat(indexPath)
+		if err != nil {
+			return mm, err
+		}
+
+		mm.Created = fi.ModTime()
+	}
+
+	return mm, nil
+}
+
+// SetScanSummary records the result of the CVE scan of an image manifest.
+func (is *ImageStore) SetScanSummary(repo string, digest godigest.Digest, summary *ScanSummary) error {
+	is.Lock()
+	defer is.Unlock()
+
+	rm, err := is.getRepoMeta(repo)
+	if err != nil {
+		return err
+	}
+
+	mm, ok := rm.Manifests[digest]
+	if !ok {
+		return errors.ErrManifestNotFound
+	}
+
+	mm.Scan = summary
+	rm.Manifests[digest] = mm
+
+	if err := is.metaDB.put(rm); err != nil {
+		is.log.Error().Err(err).Str("repo", repo).Msg("unable to write repository metadata")
+		return err
+	}
+
+	return nil
+}
+
+// SetProvenanceSummary records the result of the verification of the provenance attestations of a manifest,
+// it's kept until the manifest is deleted.
+func (is *ImageStore) SetProvenanceSummary(repo string, digest godigest.Digest, summary *ProvenanceSummary) error {
+	is.Lock()
+	defer is.Unlock()
+
+	rm, err := is.getRepoMeta(repo)
+	if err != nil {
+		return err
+	}
+
+	mm, ok := rm.Manifests[digest]
+	if !ok {
+		return errors.ErrManifestNotFound
+	}
+
+	mm.Provenance = summary
+	rm.Manifests[digest] = mm
+
+	if err := is.metaDB.put(rm); err != nil {
+		is.log.Error().Err(err).Str("repo", repo).Msg("unable to write repository metadata")
+		return err
+	}
+
+	return nil
+}
+
+// SetLintSummary records the result of the lint of a manifest, it's kept until the manifest is deleted.
+func (is *ImageStore) SetLintSummary(repo string, digest godigest.Digest, summary *LintSummary) error {
+	is.Lock()
+	defer is.Unlock()
+
+	rm, err := is.getRepoMeta(repo)
+	if err != nil {
+		return err
+	}
+
+	mm, ok := rm.Manifests[digest]
+	if !ok {
+		return errors.ErrManifestNotFound
+	}
+
+	mm.Lint = summary
+	rm.Manifests[digest] = mm
+
+	if err := is.metaDB.put(rm); err != nil {
+		is.log.Error().Err(err).Str("repo", repo).Msg("unable to write repository metadata")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/pkg/scheduler"
+	"github.com/rs/zerolog"
+)
+
+// PullStatsFile holds the pull statistics of an image store, under its root directory.
+const PullStatsFile = ".pullstats.json"
+
+// PullCount counts the pulls of a repository, tag or user.
+type PullCount struct {
+	Count    uint64    `json:"count"`
+	LastPull time.Time `json:"lastPull"`
+}
+
+func (pc *PullCount) record(now time.Time) {
+	pc.Count++
+	pc.LastPull = now
+}
+
+// RepoPullStats counts the pulls of a repository, and of each of its tags.
+// Images pulled by digest are counted under their digest.
+type RepoPullStats struct {
+	PullCount
+	Tags map[string]PullCount `json:"tags"`
+}
+
+// PullStats records the manifest downloads of an image store, they are kept in memory
+// and flushed to PullStatsFile periodically.
+type PullStats struct {
+	file  string
+	log   zerolog.Logger
+	lock  sync.Mutex
+	repos map[string]*RepoPullStats
+	users map[string]*PullCount
+	dirty bool
+}
+
+type pullStatsFile struct {
+	Repos map[string]*RepoPullStats `json:"repos"`
+	Users map[string]*PullCount     `json:"users"`
+}
+
+// newPullStats loads the pull statistics saved under the root directory, if any.
+func newPullStats(rootDir string, log zerolog.Logger) *PullStats {
+	ps := &PullStats{
+		file:  path.Join(rootDir, PullStatsFile),
+		log:   log,
+		repos: make(map[string]*RepoPullStats),
+		users: make(map[string]*PullCount),
+	}
+
+	buf, err := ioutil.ReadFile(ps.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error().Err(err).Str("file", ps.file).Msg("unable to read pull statistics")
+		}
+
+		return ps
+	}
+
+	var saved pullStatsFile
+	if err := json.Unmarshal(buf, &saved); err != nil {
+		// statistics are not worth refusing to start over
+		log.Error().Err(err).Str("file", ps.file).Msg("unable to parse pull statistics, starting over")
+		return ps
+	}
+
+	for repo, stats := range saved.Repos {
+		if stats.Tags == nil {
+			stats.Tags = make(map[string]PullCount)
+		}
+
+		ps.repos[repo] = stats
+	}
+
+	for user, count := range saved.Users {
+		ps.users[user] = count
+	}
+
+	return ps
+}
+
+// Record counts a pull of the reference of a repository by the user, empty if anonymous.
+func (ps *PullStats) Record(repo, reference, user string) {
+	now := time.Now()
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	stats, ok := ps.repos[repo]
+	if !ok {
+		stats = &RepoPullStats{Tags: make(map[string]PullCount)}
+		ps.repos[repo] = stats
+	}
+
+	stats.record(now)
+
+	tag := stats.Tags[reference]
+	tag.record(now)
+	stats.Tags[reference] = tag
+
+	if user != "" {
+		count, ok := ps.users[user]
+		if !ok {
+			count = &PullCount{}
+			ps.users[user] = count
+		}
+
+		count.record(now)
+	}
+
+	ps.dirty = true
+}
+
+// Repos returns a copy of the pull statistics of all repositories.
+func (ps *PullStats) Repos() map[string]RepoPullStats {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	repos := make(map[string]RepoPullStats, len(ps.repos))
+
+	for repo, stats := range ps.repos {
+		tags := make(map[string]PullCount, len(stats.Tags))
+		for tag, count := range stats.Tags {
+			tags[tag] = count
+		}
+
+		repos[repo] = RepoPullStats{PullCount: stats.PullCount, Tags: tags}
+	}
+
+	return repos
+}
+
+// Repo returns a copy of the pull statistics of a repository.
+func (ps *PullStats) Repo(repo string) RepoPullStats {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	stats, ok := ps.repos[repo]
+	if !ok {
+		return RepoPullStats{Tags: map[string]PullCount{}}
+	}
+
+	tags := make(map[string]PullCount, len(stats.Tags))
+	for tag, count := range stats.Tags {
+		tags[tag] = count
+	}
+
+	return RepoPullStats{PullCount: stats.PullCount, Tags: tags}
+}
+
+// Users returns a copy of the pull counts of all users.
+func (ps *PullStats) Users() map[string]PullCount {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	users := make(map[string]PullCount, len(ps.users))
+
+	for user, count := range ps.users {
+		users[user] = *count
+	}
+
+	return users
+}
+
+// Flush saves the pull statistics if they changed since the last flush.
+func (ps *PullStats) Flush() error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if !ps.dirty {
+		return nil
+	}
+
+	buf, err := json.Marshal(pullStatsFile{Repos: ps.repos, Users: ps.users})
+	if err != nil {
+		return err
+	}
+
+	if err := replaceFile(ps.file, func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	}); err != nil {
+		ps.log.Error().Err(err).Str("file", ps.file).Msg("unable to save pull statistics")
+		return err
+	}
+
+	ps.dirty = false
+
+	return nil
+}
+
+// PullStats returns the pull statistics of the image store.
+func (is *ImageStore) PullStats() *PullStats {
+	return is.pullStats
+}
+
+type pullStatsTask struct {
+	pullStats *PullStats
+}
+
+// NewPullStatsTask returns a scheduler task which saves the pull statistics of an image store.
+func NewPullStatsTask(imgStore *ImageStore) scheduler.Task {
+	return &pullStatsTask{pullStats: imgStore.pullStats}
+}
+
+func (t *pullStatsTask) DoWork() error {
+	return t.pullStats.Flush()
+}
@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	zlog "github.com/anuvu/zot/pkg/log"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// repoRulesFile keeps the repository rules the blobs of the image store were last migrated to.
+const repoRulesFile = "reporules.json"
+
+// RepoRule overrides the dedupe and the encryption at rest of the repositories under a prefix.
+type RepoRule struct {
+	// Dedupe links the blobs of the repositories with those of the other deduped ones, as the image store
+	// does if not set. Blobs of repositories which aren't deduped are never hard linked across repositories.
+	Dedupe *bool
+	// Plaintext stores the blobs of the repositories in plaintext, even if the image store encrypts blobs.
+	// They aren't deduped either, so that they aren't linked with encrypted ones.
+	Plaintext bool
+	// Isolated makes the prefix a trust boundary: the blobs of its repositories are only deduped with those of
+	// the other repositories under it, so that pushing or mounting a digest elsewhere never links one of them,
+	// and the other way around.
+	Isolated bool
+}
+
+// SetRepoRules applies rules to the repositories under each prefix, e.g. "secret" for "secret/app", the
+// longest matching prefix applies. The blobs stored before the rules changed are migrated to them: blobs
+// of the repositories which aren't deduped anymore, or which are linked across isolated prefixes, are copied,
+// so that they no longer share a file with other repositories, those of plaintext repositories are decrypted,
+// and the copies of the blobs of deduped repositories are linked. Blobs already stored in plaintext stay so when a repository is encrypted again.
+func (is *ImageStore) SetRepoRules(rules map[string]RepoRule) error {
+	is.setRepoRules(rules)
+
+	for _, rule := range is.repoRules {
+		if rule.Dedupe != nil && *rule.Dedupe && is.cache == nil {
+			is.cache = NewCache(is.rootDir, "cache", zlog.Logger{Logger: is.log})
+		}
+	}
+
+	return is.migrateRepoRules()
+}
+
+func (is *ImageStore) setRepoRules(rules map[string]RepoRule) {
+	is.repoRules = make(map[string]RepoRule, len(rules))
+	is.repoPrefixes = make([]string, 0, len(rules))
+
+	for prefix, rule := range rules {
+		prefix = strings.Trim(prefix, "/")
+		is.repoRules[prefix] = rule
+		is.repoPrefixes = append(is.repoPrefixes, prefix)
+	}
+
+	// the most specific (longest) prefix wins when several match a repository
+	sort.Slice(is.repoPrefixes, func(i, j int) bool {
+		return len(is.repoPrefixes[i]) > len(is.repoPrefixes[j])
+	})
+}
+
+// readRepoRules returns the repository rules the blobs of the root directory were last migrated to.
+func readRepoRules(rootDir string) (map[string]RepoRule, error) {
+	var rules map[string]RepoRule
+
+	buf, err := ioutil.ReadFile(path.Join(rootDir, repoRulesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(buf, &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// underPrefix tells whether the repository is under the prefix, the empty prefix being the root of all.
+func underPrefix(repo, prefix string) bool {
+	return prefix == "" || repo == prefix || strings.HasPrefix(repo, prefix+"/")
+}
+
+// repoRule returns the rule of the longest prefix the repository is under.
+func (is *ImageStore) repoRule(repo string) (RepoRule, bool) {
+	for _, prefix := range is.repoPrefixes {
+		if underPrefix(repo, prefix) {
+			return is.repoRules[prefix], true
+		}
+	}
+
+	return RepoRule{}, false
+}
+
+// dedupeScope returns the longest isolated prefix the repository is under, the blobs of the repositories of
+// the same scope are deduped together. Repositories under no isolated prefix share the empty scope.
+func (is *ImageStore) dedupeScope(repo string) string {
+	for _, prefix := range is.repoPrefixes {
+		if is.repoRules[prefix].Isolated && underPrefix(repo, prefix) {
+			return prefix
+		}
+	}
+
+	return ""
+}
+
+// blobRepo returns the repository of a blob path relative to the root directory, as the dedupe cache
+// records them.
+func blobRepo(blobPath string) string {
+	// <repo>/blobs/<algorithm>/<encoded>
+	return path.Dir(path.Dir(path.Dir(blobPath)))
+}
+
+// cachedBlob returns the path the dedupe cache records for a blob within the dedupe scope of the repository,
+// relative to the root directory.
+func (is *ImageStore) cachedBlob(repo string, digest string) (string, error) {
+	scope := is.dedupeScope(repo)
+
+	return is.cache.FindBlob(digest, func(blobPath string) bool {
+		return is.dedupeScope(blobRepo(blobPath)) == scope
+	})
+}
+
+// byDedupeScope splits the files of a blob by the dedupe scope of their repositories.
+func (is *ImageStore) byDedupeScope(files []blobFile) [][]blobFile {
+	scopes := make(map[string]int)
+
+	var split [][]blobFile
+
+	for _, file := range files {
+		scope := is.dedupeScope(file.repo)
+
+		i, ok := scopes[scope]
+		if !ok {
+			i = len(split)
+			scopes[scope] = i
+			split = append(split, nil)
+		}
+
+		split[i] = append(split[i], file)
+	}
+
+	return split
+}
+
+// dedupeRepo tells whether the blobs pushed to the repository are deduped.
+func (is *ImageStore) dedupeRepo(repo string) bool {
+	if is.cache == nil {
+		return false
+	}
+
+	rule, ok := is.repoRule(repo)
+	if !ok {
+		return is.dedupe
+	}
+
+	if rule.Plaintext {
+		return false
+	}
+
+	if rule.Dedupe != nil {
+		return *rule.Dedupe
+	}
+
+	return is.dedupe
+}
+
+// encryptRepo tells whether the blobs pushed to the repository are encrypted.
+func (is *ImageStore) encryptRepo(repo string) bool {
+	rule, _ := is.repoRule(repo)
+
+	return is.cipher != nil && !rule.Plaintext
+}
+
+// scanDedupedBlobs returns the files of each blob in the deduped repositories.
+func (is *ImageStore) scanDedupedBlobs() (map[godigest.Digest][]blobFile, error) {
+	blobs, err := is.scanBlobs()
+	if err != nil {
+		return nil, err
+	}
+
+	for digest, files := range blobs {
+		deduped := files[:0]
+
+		for _, file := range files {
+			if is.dedupeRepo(file.repo) {
+				deduped = append(deduped, file)
+			}
+		}
+
+		if len(deduped) == 0 {
+			delete(blobs, digest)
+			continue
+		}
+
+		blobs[digest] = deduped
+	}
+
+	return blobs, nil
+}
+
+// migrateRepoRules migrates the blobs to the repository rules if they changed since the last migration.
+func (is *ImageStore) migrateRepoRules() error {
+	applied, err := readRepoRules(is.rootDir)
+	if err != nil {
+		is.log.Error().Err(err).Str("rootDir", is.rootDir).Msg("unable to read the applied repository rules")
+		return err
+	}
+
+	if (len(applied) == 0 && len(is.repoRules) == 0) || reflect.DeepEqual(applied, is.repoRules) {
+		return nil
+	}
+
+	is.Lock()
+	defer is.Unlock()
+
+	blobs, err := is.scanBlobs()
+	if err != nil {
+		return err
+	}
+
+	copied, decrypted := 0, 0
+
+	for digest, files := range blobs {
+		for _, group := range blobCopies(files) {
+			n, err := is.separateScopes(group)
+			if err != nil {
+				return err
+			}
+
+			copied += n
+
+			for _, file := range group {
+				if is.dedupeRepo(file.repo) {
+					continue
+				}
+
+				if len(group) > 1 {
+					if err := separateFile(file.path); err != nil {
+						is.log.Error().Err(err).Str("blob", file.path).Msg("unable to copy deduped blob")
+						return err
+					}
+
+					copied++
+				}
+
+				if is.cache != nil && is.cache.HasBlob(digest.String(), is.relativePath(file.path)) {
+					if err := is.cache.DeleteBlob(digest.String(), file.path); err != nil {
+						return err
+					}
+				}
+
+				if is.cipher == nil || is.encryptRepo(file.repo) {
+					continue
+				}
+
+				if encrypted, err := isEncryptedBlob(file.path); err != nil || !encrypted {
+					if err != nil {
+						return err
+					}
+
+					continue
+				}
+
+				if err := is.cipher.decryptFile(file.path); err != nil {
+					is.log.Error().Err(err).Str("blob", file.path).Msg("unable to decrypt blob")
+					return err
+				}
+
+				decrypted++
+			}
+		}
+	}
+
+	result, err := is.rededupe()
+	if err != nil {
+		return err
+	}
+
+	is.log.Info().Str("rootDir", is.rootDir).Int("copied", copied).Int("decrypted", decrypted).
+		Int("linked", result.Linked).Msg("migrated blobs to the repository rules")
+
+	buf, err := json.Marshal(is.repoRules)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(is.rootDir, repoRulesFile), buf, 0600) //nolint: gosec
+}
+
+// separateScopes copies the deduped files of a group of links which are in another dedupe scope than the
+// first one, so that links don't cross isolated prefixes. It returns the number of files copied.
+func (is *ImageStore) separateScopes(group []blobFile) (int, error) {
+	copied := 0
+	scope, scoped := "", false
+
+	for _, file := range group {
+		if !is.dedupeRepo(file.repo) {
+			continue
+		}
+
+		if !scoped {
+			scope, scoped = is.dedupeScope(file.repo), true
+			continue
+		}
+
+		if is.dedupeScope(file.repo) == scope {
+			continue
+		}
+
+		if err := separateFile(file.path); err != nil {
+			is.log.Error().Err(err).Str("blob", file.path).Msg("unable to copy blob linked across isolated prefixes")
+			return copied, err
+		}
+
+		copied++
+	}
+
+	return copied, nil
+}
+
+// separateFile replaces a file sharing its contents with hard links by a copy of its own.
+func separateFile(file string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return replaceFile(file, func(dst io.Writer) error {
+		_, err := io.Copy(dst, src)
+
+		return err
+	})
+}
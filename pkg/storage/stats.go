@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// LockStats reports image store lock acquisitions and the time spent waiting for them.
+type LockStats struct {
+	ReadLocks      uint64        `json:"readLocks"`
+	WriteLocks     uint64        `json:"writeLocks"`
+	ReadWaitTotal  time.Duration `json:"readWaitTotalNs"`
+	WriteWaitTotal time.Duration `json:"writeWaitTotalNs"`
+	MaxWait        time.Duration `json:"maxWaitNs"`
+}
+
+// LockHolders reports who holds the image store lock right now, the writer being the function which took it.
+type LockHolders struct {
+	Readers     int64      `json:"readers"`
+	Writer      string     `json:"writer,omitempty"`
+	WriterSince *time.Time `json:"writerSince,omitempty"`
+}
+
+// CacheStats reports dedupe cache usage.
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Puts    uint64 `json:"puts"`
+	Deletes uint64 `json:"deletes"`
+	// bbolt counters
+	ReadTx     int           `json:"readTx"`
+	OpenReadTx int           `json:"openReadTx"`
+	PageWrites int           `json:"pageWrites"`
+	WriteTime  time.Duration `json:"writeTimeNs"`
+}
+
+// StoreStats is a snapshot of an image store's internal statistics.
+type StoreStats struct {
+	RootDir string      `json:"rootDir"`
+	Lock    LockStats   `json:"lock"`
+	Holders LockHolders `json:"holders"`
+	Cache   *CacheStats `json:"cache,omitempty"`
+}
+
+type lockCounters struct {
+	readLocks  uint64
+	writeLocks uint64
+	readWait   int64
+	writeWait  int64
+	maxWait    int64
+	// current holders
+	readers     int64
+	writer      atomic.Value
+	writerSince int64
+}
+
+func (lc *lockCounters) record(write bool, wait time.Duration) {
+	if write {
+		atomic.AddUint64(&lc.writeLocks, 1)
+		atomic.AddInt64(&lc.writeWait, int64(wait))
+	} else {
+		atomic.AddUint64(&lc.readLocks, 1)
+		atomic.AddInt64(&lc.readWait, int64(wait))
+	}
+
+	for {
+		max := atomic.LoadInt64(&lc.maxWait)
+		if int64(wait) <= max || atomic.CompareAndSwapInt64(&lc.maxWait, max, int64(wait)) {
+			return
+		}
+	}
+}
+
+// acquired records a holder of the lock, the caller of Lock for a writer.
+func (lc *lockCounters) acquired(write bool) {
+	if !write {
+		atomic.AddInt64(&lc.readers, 1)
+		return
+	}
+
+	caller := "unknown"
+
+	// skip acquired and Lock
+	if pc, _, _, ok := runtime.Caller(2); ok { // nolint: gomnd
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			caller = fn.Name()
+		}
+	}
+
+	lc.writer.Store(caller)
+	atomic.StoreInt64(&lc.writerSince, time.Now().UnixNano())
+}
+
+func (lc *lockCounters) released(write bool) {
+	if !write {
+		atomic.AddInt64(&lc.readers, -1)
+		return
+	}
+
+	atomic.StoreInt64(&lc.writerSince, 0)
+}
+
+func (lc *lockCounters) holders() LockHolders {
+	holders := LockHolders{Readers: atomic.LoadInt64(&lc.readers)}
+
+	if since := atomic.LoadInt64(&lc.writerSince); since != 0 {
+		writerSince := time.Unix(0, since)
+		holders.WriterSince = &writerSince
+		holders.Writer, _ = lc.writer.Load().(string)
+	}
+
+	return holders
+}
+
+type cacheCounters struct {
+	hits    uint64
+	misses  uint64
+	puts    uint64
+	deletes uint64
+}
+
+// Stats returns lock contention and cache statistics of the image store.
+func (is *ImageStore) Stats() StoreStats {
+	stats := StoreStats{
+		RootDir: is.rootDir,
+		Lock: LockStats{
+			ReadLocks:      atomic.LoadUint64(&is.lockStats.readLocks),
+			WriteLocks:     atomic.LoadUint64(&is.lockStats.writeLocks),
+			ReadWaitTotal:  time.Duration(atomic.LoadInt64(&is.lockStats.readWait)),
+			WriteWaitTotal: time.Duration(atomic.LoadInt64(&is.lockStats.writeWait)),
+			MaxWait:        time.Duration(atomic.LoadInt64(&is.lockStats.maxWait)),
+		},
+		Holders: is.lockStats.holders(),
+	}
+
+	if is.cache != nil {
+		cacheStats := is.cache.Stats()
+		stats.Cache = &cacheStats
+	}
+
+	return stats
+}
+
+// Stats returns usage statistics of the cache.
+func (c *Cache) Stats() CacheStats {
+	dbStats := c.db.Stats()
+
+	return CacheStats{
+		Hits:       atomic.LoadUint64(&c.stats.hits),
+		Misses:     atomic.LoadUint64(&c.stats.misses),
+		Puts:       atomic.LoadUint64(&c.stats.puts),
+		Deletes:    atomic.LoadUint64(&c.stats.deletes),
+		ReadTx:     dbStats.TxN,
+		OpenReadTx: dbStats.OpenTxN,
+		PageWrites: dbStats.TxStats.Write,
+		WriteTime:  dbStats.TxStats.WriteTime,
+	}
+}
@@ -2,7 +2,7 @@ package storage
 
 import (
 	"context"
-	"crypto/sha256"
+	_ "crypto/sha512" // makes sha384 and sha512 digests available, along with sha256
 	"encoding/json"
 	"fmt"
 	"io"
@@ -16,12 +16,9 @@ import (
 
 	"github.com/anuvu/zot/errors"
 	zlog "github.com/anuvu/zot/pkg/log"
-	apexlog "github.com/apex/log"
 	guuid "github.com/gofrs/uuid"
 	godigest "github.com/opencontainers/go-digest"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
-	"github.com/opencontainers/umoci"
-	"github.com/opencontainers/umoci/oci/casext"
 	"github.com/rs/zerolog"
 )
 
@@ -50,8 +47,28 @@ type ImageStore struct {
 	blobUploads map[string]BlobUpload
 	cache       *Cache
 	gc          bool
+	gcOnDelete  bool
 	dedupe      bool
+	directIO    bool
+	lowMemory   bool
+	reflink     bool
 	log         zerolog.Logger
+	lockStats   *lockCounters
+	cipher      *blobCipher
+	pullStats   *PullStats
+	diskSpace   *diskSpaceMonitor
+	tiering     *tiering
+	metaDB      *metaDB
+	// expired tags pulled within it are kept, a time.Duration changed at runtime by the admin API
+	retainPulledWithin int64
+	backupLock         *sync.Mutex
+	onChange           func(Change)
+	conversions        *conversionCache
+	maxIndexDepth      int
+	sparseIndexes      bool
+	repoRules          map[string]RepoRule
+	repoPrefixes       []string
+	immutableTag       func(repo, tag string) bool
 }
 
 func (is *ImageStore) RootDir() string {
@@ -97,52 +114,116 @@ func NewImageStore(rootDir string, gc bool, dedupe bool, log zlog.Logger) *Image
 	}
 
 	is := &ImageStore{
-		rootDir:     rootDir,
-		lock:        &sync.RWMutex{},
-		blobUploads: make(map[string]BlobUpload),
-		gc:          gc,
-		dedupe:      dedupe,
-		log:         log.With().Caller().Logger(),
+		rootDir:       rootDir,
+		lock:          &sync.RWMutex{},
+		blobUploads:   make(map[string]BlobUpload),
+		gc:            gc,
+		dedupe:        dedupe,
+		log:           log.With().Caller().Logger(),
+		lockStats:     &lockCounters{},
+		diskSpace:     &diskSpaceMonitor{},
+		backupLock:    &sync.Mutex{},
+		conversions:   newConversionCache(maxConvertedManifests),
+		maxIndexDepth: DefaultMaxIndexDepth,
+	}
+
+	is.pullStats = newPullStats(rootDir, is.log)
+
+	metaDB, err := openMetaDB(rootDir, is.log)
+	if err != nil {
+		return nil
 	}
 
+	is.metaDB = metaDB
+
 	if dedupe {
 		is.cache = NewCache(rootDir, "cache", log)
 	}
 
-	if gc {
-		// we use umoci GC to perform garbage-collection, but it uses its own logger
-		// - so capture those logs, could be useful
-		apexlog.SetLevel(apexlog.DebugLevel)
-		apexlog.SetHandler(apexlog.HandlerFunc(func(entry *apexlog.Entry) error {
-			e := log.Debug()
-			for k, v := range entry.Fields {
-				e = e.Interface(k, v)
-			}
-			e.Msg(entry.Message)
-			return nil
-		}))
+	return is
+}
+
+// WithLogger returns a view of the image store which logs to the given logger,
+// it shares locks, uploads, cache and stats with the original store.
+func (is *ImageStore) WithLogger(log zlog.Logger) *ImageStore {
+	view := is.view()
+	view.log = log.With().Caller().Logger()
+
+	return view
+}
+
+// view returns a copy of the image store sharing its locks, uploads, cache and stats.
+func (is *ImageStore) view() *ImageStore {
+	return &ImageStore{
+		rootDir:       is.rootDir,
+		lock:          is.lock,
+		blobUploads:   is.blobUploads,
+		cache:         is.cache,
+		gc:            is.gc,
+		gcOnDelete:    is.gcOnDelete,
+		dedupe:        is.dedupe,
+		log:           is.log,
+		lockStats:     is.lockStats,
+		cipher:        is.cipher,
+		pullStats:     is.pullStats,
+		diskSpace:     is.diskSpace,
+		tiering:       is.tiering,
+		directIO:      is.directIO,
+		lowMemory:     is.lowMemory,
+		reflink:       is.reflink,
+		metaDB:        is.metaDB,
+		backupLock:    is.backupLock,
+		onChange:      is.onChange,
+		conversions:   is.conversions,
+		maxIndexDepth: is.maxIndexDepth,
+		sparseIndexes: is.sparseIndexes,
+		repoRules:     is.repoRules,
+		repoPrefixes:  is.repoPrefixes,
+		immutableTag:  is.immutableTag,
 	}
+}
 
-	return is
+// SetLowMemory makes the image store use as little memory as it can, at the expense of speed: fewer converted
+// manifests are cached, and the metadata of the repositories is read one repository at a time.
+func (is *ImageStore) SetLowMemory(enabled bool) {
+	is.lowMemory = enabled
+
+	if enabled {
+		is.conversions = newConversionCache(lowMemoryConvertedManifests)
+	} else {
+		is.conversions = newConversionCache(maxConvertedManifests)
+	}
 }
 
 // RLock read-lock.
 func (is *ImageStore) RLock() {
+	start := time.Now()
+
 	is.lock.RLock()
+
+	is.lockStats.record(false, time.Since(start))
+	is.lockStats.acquired(false)
 }
 
 // RUnlock read-unlock.
 func (is *ImageStore) RUnlock() {
+	is.lockStats.released(false)
 	is.lock.RUnlock()
 }
 
 // Lock write-lock.
 func (is *ImageStore) Lock() {
+	start := time.Now()
+
 	is.lock.Lock()
+
+	is.lockStats.record(true, time.Since(start))
+	is.lockStats.acquired(true)
 }
 
 // Unlock write-unlock.
 func (is *ImageStore) Unlock() {
+	is.lockStats.released(true)
 	is.lock.Unlock()
 }
 func (is *ImageStore) initRepo(name string) error {
@@ -190,7 +271,8 @@ func (is *ImageStore) initRepo(name string) error {
 			is.log.Panic().Err(err).Msg("unable to marshal JSON")
 		}
 
-		if err := ioutil.WriteFile(indexPath, buf, 0644); err != nil { //nolint: gosec
+		// another image store may be creating the repository and pushing to it at the same time
+		if err := createIndexFile(repoDir, buf); err != nil && !os.IsExist(err) {
 			is.log.Error().Err(err).Str("file", indexPath).Msg("unable to write file")
 			return err
 		}
@@ -266,11 +348,16 @@ func (is *ImageStore) ValidateRepo(name string) (bool, error) {
 
 // GetRepositories returns a list of all the repositories under this store.
 func (is *ImageStore) GetRepositories() ([]string, error) {
-	dir := is.rootDir
-
 	is.RLock()
 	defer is.RUnlock()
 
+	return is.getRepositories()
+}
+
+// getRepositories lists the repositories, the caller must hold the lock.
+func (is *ImageStore) getRepositories() ([]string, error) {
+	dir := is.rootDir
+
 	_, err := ioutil.ReadDir(dir)
 	if err != nil {
 		is.log.Error().Err(err).Msg("failure walking storage root-dir")
@@ -419,6 +506,81 @@ func (is *ImageStore) GetImageManifest(repo string, reference string) ([]byte, s
 	return buf, digest.String(), mediaType, nil
 }
 
+// referrerManifest holds the fields of a manifest which link it to another manifest,
+// e.g. a signature pushed as an image manifest with a subject.
+type referrerManifest struct {
+	Config       ispec.Descriptor  `json:"config"`
+	Subject      *ispec.Descriptor `json:"subject,omitempty"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+}
+
+// GetReferrers returns the descriptors of the manifests in the repository whose subject is the given digest,
+// filtered by artifact type (or config media type, for older clients) unless artifactType is empty.
+func (is *ImageStore) GetReferrers(repo string, digest godigest.Digest,
+	artifactType string) ([]ispec.Descriptor, error) {
+	dir := path.Join(is.rootDir, repo)
+	if !dirExists(dir) {
+		return nil, errors.ErrRepoNotFound
+	}
+
+	is.RLock()
+	defer is.RUnlock()
+
+	buf, err := ioutil.ReadFile(path.Join(dir, "index.json"))
+	if err != nil {
+		is.log.Error().Err(err).Str("dir", dir).Msg("failed to read index.json")
+		return nil, err
+	}
+
+	var index ispec.Index
+	if err := json.Unmarshal(buf, &index); err != nil {
+		is.log.Error().Err(err).Str("dir", dir).Msg("invalid JSON")
+		return nil, err
+	}
+
+	referrers := []ispec.Descriptor{}
+
+	for _, desc := range index.Manifests {
+		if desc.MediaType != ispec.MediaTypeImageManifest {
+			continue
+		}
+
+		buf, err := ioutil.ReadFile(is.BlobPath(repo, desc.Digest))
+		if err != nil {
+			is.log.Error().Err(err).Str("digest", desc.Digest.String()).Msg("failed to read manifest")
+			return nil, err
+		}
+
+		var m referrerManifest
+		if err := json.Unmarshal(buf, &m); err != nil {
+			is.log.Error().Err(err).Str("digest", desc.Digest.String()).Msg("invalid JSON")
+			return nil, err
+		}
+
+		if m.Subject == nil || m.Subject.Digest != digest {
+			continue
+		}
+
+		if artifactType != "" && m.ArtifactType != artifactType && m.Config.MediaType != artifactType {
+			continue
+		}
+
+		referrers = append(referrers, desc)
+	}
+
+	return referrers, nil
+}
+
+// ManifestDigest returns the digest of a manifest pushed or pulled by reference, with the algorithm of the
+// reference if it is a digest, e.g. sha512, and the canonical sha256 if it is a tag.
+func ManifestDigest(reference string, body []byte) godigest.Digest {
+	if d, err := godigest.Parse(reference); err == nil {
+		return d.Algorithm().FromBytes(body)
+	}
+
+	return godigest.FromBytes(body)
+}
+
 // PutImageManifest adds an image manifest to the repository.
 func (is *ImageStore) PutImageManifest(repo string, reference string, mediaType string,
 	body []byte) (string, error) {
@@ -427,9 +589,8 @@ func (is *ImageStore) PutImageManifest(repo string, reference string, mediaType
 		return "", err
 	}
 
-	if mediaType != ispec.MediaTypeImageManifest {
-		is.log.Debug().Interface("actual", mediaType).
-			Interface("expected", ispec.MediaTypeImageManifest).Msg("bad manifest media type")
+	if !IsManifestMediaType(mediaType) {
+		is.log.Debug().Interface("actual", mediaType).Msg("bad manifest media type")
 		return "", errors.ErrBadManifest
 	}
 
@@ -449,7 +610,41 @@ func (is *ImageStore) PutImageManifest(repo string, reference string, mediaType
 		return "", errors.ErrBadManifest
 	}
 
+	// the media type in the manifest, if any, is the one it is pushed with
+	var typed struct {
+		MediaType string `json:"mediaType"`
+	}
+
+	if err := json.Unmarshal(body, &typed); err != nil || (typed.MediaType != "" && typed.MediaType != mediaType) {
+		is.log.Error().Str("actual", typed.MediaType).Str("expected", mediaType).Msg("manifest media type mismatch")
+		return "", errors.ErrBadManifest
+	}
+
+	expires, err := tagExpiry(m.Annotations, time.Now())
+	if err != nil {
+		is.log.Error().Str("ttl", m.Annotations[AnnotationTagTTL]).Msg("invalid tag TTL")
+		return "", err
+	}
+
+	if IsIndexMediaType(mediaType) {
+		var index ispec.Index
+		if err := json.Unmarshal(body, &index); err != nil {
+			is.log.Error().Err(err).Msg("unable to unmarshal JSON")
+			return "", errors.ErrBadManifest
+		}
+
+		// the manifests of an index are pushed first
+		if digest, err := is.checkIndex(repo, index, 1); err != nil {
+			return digest.String(), err
+		}
+	}
+
 	for _, l := range m.Layers {
+		// foreign layers are pulled from their URLs
+		if isForeignLayer(l) {
+			continue
+		}
+
 		digest := l.Digest
 		blobPath := is.BlobPath(repo, digest)
 		is.log.Info().Str("blobPath", blobPath).Str("reference", reference).Msg("manifest layers")
@@ -460,7 +655,7 @@ func (is *ImageStore) PutImageManifest(repo string, reference string, mediaType
 		}
 	}
 
-	mDigest := godigest.FromBytes(body)
+	mDigest := ManifestDigest(reference, body)
 	refIsDigest := false
 	d, err := godigest.Parse(reference)
 
@@ -477,103 +672,110 @@ func (is *ImageStore) PutImageManifest(repo string, reference string, mediaType
 	is.Lock()
 	defer is.Unlock()
 
-	dir := path.Join(is.rootDir, repo)
-	buf, err := ioutil.ReadFile(path.Join(dir, "index.json"))
-
-	if err != nil {
-		is.log.Error().Err(err).Str("dir", dir).Msg("failed to read index.json")
-		return "", err
+	// image configs stay readable by GC and search even if blobs are encrypted
+	if m.Config.Digest.Validate() == nil {
+		if err := is.unsealBlob(repo, m.Config.Digest); err != nil && !os.IsNotExist(err) {
+			is.log.Error().Err(err).Str("digest", m.Config.Digest.String()).Msg("unable to decrypt image config")
+			return "", err
+		}
 	}
 
-	var index ispec.Index
-	if err := json.Unmarshal(buf, &index); err != nil {
-		is.log.Error().Err(err).Str("dir", dir).Msg("invalid JSON")
-		return "", errors.ErrRepoBadVersion
-	}
+	var desc ispec.Descriptor
 
 	updateIndex := true
-	// create a new descriptor
-	desc := ispec.Descriptor{MediaType: mediaType, Size: int64(len(body)), Digest: mDigest,
-		Platform: &ispec.Platform{Architecture: "amd64", OS: "linux"}}
-	if !refIsDigest {
-		desc.Annotations = map[string]string{ispec.AnnotationRefName: reference}
-	}
-
-	for i, m := range index.Manifests {
-		if reference == m.Digest.String() {
-			// nothing changed, so don't update
-			desc = m
-			updateIndex = false
-
-			break
+	previous := godigest.Digest("")
+
+	if err := is.updateIndex(repo, func(index *ispec.Index) (bool, error) {
+		updateIndex = true
+		previous = ""
+		// create a new descriptor
+		desc = ispec.Descriptor{MediaType: mediaType, Size: int64(len(body)), Digest: mDigest}
+		if !IsIndexMediaType(mediaType) {
+			desc.Platform = &ispec.Platform{Architecture: "amd64", OS: "linux"}
+		}
+		if !refIsDigest {
+			desc.Annotations = map[string]string{ispec.AnnotationRefName: reference}
 		}
 
-		v, ok := m.Annotations[ispec.AnnotationRefName]
-		if ok && v == reference {
-			if m.Digest.String() == mDigest.String() {
+		for i, m := range index.Manifests {
+			if reference == m.Digest.String() {
 				// nothing changed, so don't update
 				desc = m
 				updateIndex = false
 
 				break
 			}
-			// manifest contents have changed for the same tag,
-			// so update index.json descriptor
-			is.log.Info().
-				Int64("old size", desc.Size).
-				Int64("new size", int64(len(body))).
-				Str("old digest", desc.Digest.String()).
-				Str("new digest", mDigest.String()).
-				Msg("updating existing tag with new manifest contents")
 
-			desc = m
-			desc.Size = int64(len(body))
-			desc.Digest = mDigest
+			v, ok := m.Annotations[ispec.AnnotationRefName]
+			if ok && v == reference {
+				if m.Digest.String() == mDigest.String() {
+					// nothing changed, so don't update
+					desc = m
+					updateIndex = false
+
+					break
+				}
+				if is.isImmutableTag(repo, reference) {
+					is.log.Error().Str("tag", reference).Str("digest", m.Digest.String()).
+						Msg("tag is immutable and can not be overwritten")
+
+					return false, errors.ErrImmutableTag
+				}
+
+				// manifest contents have changed for the same tag,
+				// so update index.json descriptor
+				is.log.Info().
+					Int64("old size", desc.Size).
+					Int64("new size", int64(len(body))).
+					Str("old digest", desc.Digest.String()).
+					Str("new digest", mDigest.String()).
+					Msg("updating existing tag with new manifest contents")
+
+				previous = m.Digest
+				desc = m
+				desc.Size = int64(len(body))
+				desc.Digest = mDigest
 
-			index.Manifests = append(index.Manifests[:i], index.Manifests[i+1:]...)
+				index.Manifests = append(index.Manifests[:i], index.Manifests[i+1:]...)
 
-			break
+				break
+			}
 		}
-	}
 
-	if !updateIndex {
-		return desc.Digest.String(), nil
-	}
+		if !updateIndex {
+			return false, nil
+		}
 
-	// write manifest to "blobs"
-	dir = path.Join(is.rootDir, repo, "blobs", mDigest.Algorithm().String())
-	_ = ensureDir(dir, is.log)
-	file := path.Join(dir, mDigest.Encoded())
+		// write manifest to "blobs"
+		dir := path.Join(is.rootDir, repo, "blobs", mDigest.Algorithm().String())
+		_ = ensureDir(dir, is.log)
+		file := path.Join(dir, mDigest.Encoded())
 
-	if err := ioutil.WriteFile(file, body, 0600); err != nil {
-		is.log.Error().Err(err).Str("file", file).Msg("unable to write")
-		return "", err
-	}
+		if err := ioutil.WriteFile(file, body, 0600); err != nil {
+			is.log.Error().Err(err).Str("file", file).Msg("unable to write")
+			return false, err
+		}
 
-	// now update "index.json"
-	index.Manifests = append(index.Manifests, desc)
-	dir = path.Join(is.rootDir, repo)
-	file = path.Join(dir, "index.json")
-	buf, err = json.Marshal(index)
+		// now update "index.json"
+		index.Manifests = append(index.Manifests, withTagExpiry(desc, expires))
 
-	if err != nil {
-		is.log.Error().Err(err).Str("file", file).Msg("unable to marshal JSON")
+		return true, nil
+	}); err != nil {
 		return "", err
 	}
 
-	if err := ioutil.WriteFile(file, buf, 0644); err != nil { //nolint: gosec
-		is.log.Error().Err(err).Str("file", file).Msg("unable to write")
-		return "", err
+	if !updateIndex {
+		return desc.Digest.String(), nil
 	}
 
-	if is.gc {
-		oci, err := umoci.OpenLayout(dir)
-		if err != nil {
-			return "", err
-		}
-		defer oci.Close()
+	dir := path.Join(is.rootDir, repo)
+
+	is.updateRepoMeta(repo)
+
+	is.notifyChange(pushChange(repo, reference, mDigest, previous))
 
-		if err := oci.GC(context.Background(), ifOlderThan(is, repo, gcDelay)); err != nil {
+	if is.gc {
+		if err := is.garbageCollect(dir, repo); err != nil {
 			return "", err
 		}
 	}
@@ -601,73 +803,77 @@ func (is *ImageStore) DeleteImageManifest(repo string, reference string) error {
 	is.Lock()
 	defer is.Unlock()
 
-	buf, err := ioutil.ReadFile(path.Join(dir, "index.json"))
+	var outIndex ispec.Index
 
-	if err != nil {
-		is.log.Error().Err(err).Str("dir", dir).Msg("failed to read index.json")
-		return err
-	}
+	if err := is.updateIndex(repo, func(index *ispec.Index) (bool, error) {
+		found := false
 
-	var index ispec.Index
-	if err := json.Unmarshal(buf, &index); err != nil {
-		is.log.Error().Err(err).Str("dir", dir).Msg("invalid JSON")
-		return err
-	}
+		// we are deleting, so keep only those manifests that don't match
+		manifests := []ispec.Descriptor{}
 
-	found := false
+		for _, m := range index.Manifests {
+			if isTag {
+				tag, ok := m.Annotations[ispec.AnnotationRefName]
+				if ok && tag == reference {
+					if is.isImmutableTag(repo, tag) {
+						return false, errors.ErrImmutableTag
+					}
 
-	var m ispec.Descriptor
+					is.log.Debug().Str("deleting tag", tag).Msg("")
 
-	// we are deleting, so keep only those manifests that don't match
-	outIndex := index
-	outIndex.Manifests = []ispec.Descriptor{}
+					digest = m.Digest
 
-	for _, m = range index.Manifests {
-		if isTag {
-			tag, ok := m.Annotations[ispec.AnnotationRefName]
-			if ok && tag == reference {
-				is.log.Debug().Str("deleting tag", tag).Msg("")
+					found = true
 
-				digest = m.Digest
+					continue
+				}
+			} else if reference == m.Digest.String() {
+				// deleting a digest deletes all its tags
+				if is.isImmutableDescriptor(repo, m) {
+					return false, errors.ErrImmutableTag
+				}
 
+				is.log.Debug().Str("deleting reference", reference).Msg("")
 				found = true
-
 				continue
 			}
-		} else if reference == m.Digest.String() {
-			is.log.Debug().Str("deleting reference", reference).Msg("")
-			found = true
-			continue
-		}
 
-		outIndex.Manifests = append(outIndex.Manifests, m)
-	}
+			manifests = append(manifests, m)
+		}
 
-	if !found {
-		return errors.ErrManifestNotFound
-	}
+		if !found {
+			return false, errors.ErrManifestNotFound
+		}
 
-	// now update "index.json"
-	dir = path.Join(is.rootDir, repo)
-	file := path.Join(dir, "index.json")
-	buf, err = json.Marshal(outIndex)
+		// now update "index.json"
+		index.Manifests = manifests
+		outIndex = *index
 
-	if err != nil {
+		return true, nil
+	}); err != nil {
 		return err
 	}
 
-	if err := ioutil.WriteFile(file, buf, 0644); err != nil { //nolint: gosec
-		return err
+	is.updateRepoMeta(repo)
+
+	change := Change{Kind: ChangeDelete, Repo: repo, Digest: digest}
+	if isTag {
+		change.Tag = reference
 	}
 
-	if is.gc {
-		oci, err := umoci.OpenLayout(dir)
-		if err != nil {
-			return err
+	is.notifyChange(change)
+
+	// before GC, which may remove the manifest if it's old enough
+	if is.gcOnDelete {
+		if err := is.collectDeletedManifest(repo, digest, outIndex.Manifests); err != nil {
+			// the periodic GC removes them later on
+			is.log.Error().Err(err).Str("repo", repo).Str("digest", digest.String()).
+				Msg("unable to remove the blobs of the deleted manifest")
 		}
-		defer oci.Close()
+	}
 
-		if err := oci.GC(context.Background(), ifOlderThan(is, repo, gcDelay)); err != nil {
+	if is.gc {
+		if err := is.garbageCollect(dir, repo); err != nil {
 			return err
 		}
 	}
@@ -676,7 +882,7 @@ func (is *ImageStore) DeleteImageManifest(repo string, reference string) error {
 	// e.g. 1.0.1 & 1.0.2 have same blob digest so if we delete 1.0.1, blob should not be removed.
 	toDelete := true
 
-	for _, m = range outIndex.Manifests {
+	for _, m := range outIndex.Manifests {
 		if digest.String() == m.Digest.String() {
 			toDelete = false
 			break
@@ -700,8 +906,53 @@ func (is *ImageStore) BlobUploadPath(repo string, uuid string) string {
 	return blobUploadPath
 }
 
+// UploadSession is a blob upload in progress.
+type UploadSession struct {
+	Repo      string    `json:"repo"`
+	UUID      string    `json:"uuid"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// UploadSessions returns the blob uploads in progress, without taking the lock so that they can be listed
+// while it is held, e.g. when debugging a hang.
+func (is *ImageStore) UploadSessions() ([]UploadSession, error) {
+	repos, err := is.getRepositories()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []UploadSession{}
+
+	for _, repo := range repos {
+		uploads, err := ioutil.ReadDir(path.Join(is.rootDir, repo, BlobUploadDir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		for _, upload := range uploads {
+			sessions = append(sessions, UploadSession{
+				Repo:      repo,
+				UUID:      upload.Name(),
+				Size:      upload.Size(),
+				UpdatedAt: upload.ModTime(),
+			})
+		}
+	}
+
+	return sessions, nil
+}
+
 // NewBlobUpload returns the unique ID for an upload in progress.
 func (is *ImageStore) NewBlobUpload(repo string) (string, error) {
+	if err := is.checkUploadSpace(); err != nil {
+		return "", err
+	}
+
 	if err := is.InitRepo(repo); err != nil {
 		is.log.Error().Err(err).Msg("error initializing repo")
 
@@ -743,7 +994,7 @@ func (is *ImageStore) GetBlobUpload(repo string, uuid string) (int64, error) {
 }
 
 // PutBlobChunkStreamed appends another chunk of data to the specified blob. It returns
-// the number of actual bytes to the blob.
+// the size of the blob uploaded so far.
 func (is *ImageStore) PutBlobChunkStreamed(repo string, uuid string, body io.Reader) (int64, error) {
 	if err := is.InitRepo(repo); err != nil {
 		return -1, err
@@ -766,17 +1017,18 @@ func (is *ImageStore) PutBlobChunkStreamed(repo string, uuid string, body io.Rea
 	}
 	defer file.Close()
 
-	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
 		is.log.Fatal().Err(err).Msg("failed to seek file")
 	}
 
 	n, err := io.Copy(file, body)
 
-	return n, err
+	return offset + n, err
 }
 
 // PutBlobChunk writes another chunk of data to the specified blob. It returns
-// the number of actual bytes to the blob.
+// the size of the blob uploaded so far.
 func (is *ImageStore) PutBlobChunk(repo string, uuid string, from int64, to int64,
 	body io.Reader) (int64, error) {
 	if err := is.InitRepo(repo); err != nil {
@@ -812,7 +1064,7 @@ func (is *ImageStore) PutBlobChunk(repo string, uuid string, from int64, to int6
 
 	n, err := io.Copy(file, body)
 
-	return n, err
+	return from + n, err
 }
 
 // BlobUploadInfo returns the current blob size in bytes.
@@ -852,7 +1104,7 @@ func (is *ImageStore) FinishBlobUpload(repo string, uuid string, body io.Reader,
 		return errors.ErrUploadNotFound
 	}
 
-	srcDigest, err := godigest.FromReader(f)
+	srcDigest, err := dstDigest.Algorithm().FromReader(f)
 	f.Close()
 
 	if err != nil {
@@ -866,6 +1118,11 @@ func (is *ImageStore) FinishBlobUpload(repo string, uuid string, body io.Reader,
 		return errors.ErrBadBlobDigest
 	}
 
+	if err := is.sealUpload(repo, src); err != nil {
+		is.log.Error().Err(err).Str("blob", src).Msg("unable to encrypt blob")
+		return err
+	}
+
 	dir := path.Join(is.rootDir, repo, "blobs", dstDigest.Algorithm().String())
 
 	is.Lock()
@@ -873,14 +1130,14 @@ func (is *ImageStore) FinishBlobUpload(repo string, uuid string, body io.Reader,
 
 	err = ensureDir(dir, is.log)
 	if err != nil {
-		is.log.Error().Err(err).Msg("error creating blobs/sha256 dir")
+		is.log.Error().Err(err).Msg("error creating blobs dir")
 
 		return err
 	}
 
 	dst := is.BlobPath(repo, dstDigest)
 
-	if is.dedupe && is.cache != nil {
+	if is.dedupeRepo(repo) {
 		if err := is.DedupeBlob(src, dstDigest, dst); err != nil {
 			is.log.Error().Err(err).Str("src", src).Str("dstDigest", dstDigest.String()).
 				Str("dst", dst).Msg("unable to dedupe blob")
@@ -899,6 +1156,10 @@ func (is *ImageStore) FinishBlobUpload(repo string, uuid string, body io.Reader,
 
 // FullBlobUpload handles a full blob upload, and no partial session is created.
 func (is *ImageStore) FullBlobUpload(repo string, body io.Reader, digest string) (string, int64, error) {
+	if err := is.checkUploadSpace(); err != nil {
+		return "", -1, err
+	}
+
 	if err := is.InitRepo(repo); err != nil {
 		return "", -1, err
 	}
@@ -926,21 +1187,30 @@ func (is *ImageStore) FullBlobUpload(repo string, body io.Reader, digest string)
 
 	defer f.Close()
 
-	digester := sha256.New()
-	mw := io.MultiWriter(f, digester)
+	digester := dstDigest.Algorithm().Digester()
+	mw := io.MultiWriter(f, digester.Hash())
 	n, err := io.Copy(mw, body)
 
 	if err != nil {
 		return "", -1, err
 	}
 
-	srcDigest := godigest.NewDigestFromEncoded(godigest.SHA256, fmt.Sprintf("%x", digester.Sum(nil)))
+	srcDigest := digester.Digest()
 	if srcDigest != dstDigest {
 		is.log.Error().Str("srcDigest", srcDigest.String()).
 			Str("dstDigest", dstDigest.String()).Msg("actual digest not equal to expected digest")
 		return "", -1, errors.ErrBadBlobDigest
 	}
 
+	if err := f.Sync(); err != nil {
+		return "", -1, err
+	}
+
+	if err := is.sealUpload(repo, src); err != nil {
+		is.log.Error().Err(err).Str("blob", src).Msg("unable to encrypt blob")
+		return "", -1, err
+	}
+
 	dir := path.Join(is.rootDir, repo, "blobs", dstDigest.Algorithm().String())
 
 	is.Lock()
@@ -949,7 +1219,7 @@ func (is *ImageStore) FullBlobUpload(repo string, body io.Reader, digest string)
 	_ = ensureDir(dir, is.log)
 	dst := is.BlobPath(repo, dstDigest)
 
-	if is.dedupe && is.cache != nil {
+	if is.dedupeRepo(repo) {
 		if err := is.DedupeBlob(src, dstDigest, dst); err != nil {
 			is.log.Error().Err(err).Str("src", src).Str("dstDigest", dstDigest.String()).
 				Str("dst", dst).Msg("unable to dedupe blob")
@@ -966,15 +1236,14 @@ func (is *ImageStore) FullBlobUpload(repo string, body io.Reader, digest string)
 	return uuid, n, nil
 }
 
-// nolint:interfacer
 func (is *ImageStore) DedupeBlob(src string, dstDigest godigest.Digest, dst string) error {
 retry:
 	is.log.Debug().Str("src", src).Str("dstDigest", dstDigest.String()).Str("dst", dst).Msg("dedupe: ENTER")
 
-	dstRecord, err := is.cache.GetBlob(dstDigest.String())
+	dstRecord, err := is.cachedBlob(blobRepo(is.relativePath(dst)), dstDigest.String())
 
 	// nolint:goerr113
-	if err != nil && err != errors.ErrCacheMiss {
+	if err != nil && !errors.Is(err, errors.ErrCacheMiss) {
 		is.log.Error().Err(err).Str("blobPath", dst).Msg("dedupe: unable to lookup blob record")
 		return err
 	}
@@ -1000,6 +1269,11 @@ retry:
 		// disk content and cache records may go out of sync
 		dstRecord = path.Join(is.rootDir, dstRecord)
 
+		// blobs of the cold tier are recalled before they're linked to another repository
+		if err := is.recallColdBlob(dstDigest); err != nil {
+			return err
+		}
+
 		dstRecordFi, err := os.Stat(dstRecord)
 		if err != nil {
 			is.log.Error().Err(err).Str("blobPath", dstRecord).Msg("dedupe: unable to stat")
@@ -1020,16 +1294,17 @@ retry:
 			return err
 		}
 
-		if !os.SameFile(dstFi, dstRecordFi) {
+		// reflinks are files of their own, a blob already there is taken as a clone of the record
+		if !os.SameFile(dstFi, dstRecordFi) && (dstFi == nil || !is.reflink) {
 			// blob lookup cache out of sync with actual disk contents
 			if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
 				is.log.Error().Err(err).Str("dst", dst).Msg("dedupe: unable to remove blob")
 				return err
 			}
 
-			is.log.Debug().Str("blobPath", dst).Msg("dedupe: creating hard link")
+			is.log.Debug().Str("blobPath", dst).Bool("reflink", is.reflink).Msg("dedupe: creating hard link")
 
-			if err := os.Link(dstRecord, dst); err != nil {
+			if err := is.linkBlob(dstRecord, dst); err != nil {
 				is.log.Error().Err(err).Str("blobPath", dst).Str("link", dstRecord).Msg("dedupe: unable to hard link")
 
 				return err
@@ -1072,7 +1347,7 @@ func (is *ImageStore) CheckBlob(repo string, digest string) (bool, int64, error)
 
 	blobPath := is.BlobPath(repo, d)
 
-	if is.dedupe && is.cache != nil {
+	if is.dedupeRepo(repo) {
 		is.Lock()
 		defer is.Unlock()
 	} else {
@@ -1084,19 +1359,30 @@ func (is *ImageStore) CheckBlob(repo string, digest string) (bool, int64, error)
 	if err == nil {
 		is.log.Debug().Str("blob path", blobPath).Msg("blob path found")
 
-		return true, blobInfo.Size(), nil
+		blobSize, err := is.blobSize(blobPath, blobInfo.Size())
+		if err != nil {
+			is.log.Error().Err(err).Str("blob", blobPath).Msg("failed to read blob")
+			return false, -1, err
+		}
+
+		return true, blobSize, nil
 	}
 
 	is.log.Error().Err(err).Str("blob", blobPath).Msg("failed to stat blob")
 
 	// Check blobs in cache
-	dstRecord, err := is.checkCacheBlob(digest)
+	dstRecord, err := is.checkCacheBlob(repo, digest)
 	if err != nil {
 		is.log.Error().Err(err).Str("digest", digest).Msg("cache: not found")
 
 		return false, -1, errors.ErrBlobNotFound
 	}
 
+	// blobs of the cold tier are recalled before they're linked to another repository
+	if err := is.recallColdBlob(d); err != nil {
+		return false, -1, err
+	}
+
 	// If found copy to location
 	blobSize, err := is.copyBlob(repo, blobPath, dstRecord)
 	if err != nil {
@@ -1112,12 +1398,12 @@ func (is *ImageStore) CheckBlob(repo string, digest string) (bool, int64, error)
 	return true, blobSize, nil
 }
 
-func (is *ImageStore) checkCacheBlob(digest string) (string, error) {
-	if !is.dedupe || is.cache == nil {
+func (is *ImageStore) checkCacheBlob(repo, digest string) (string, error) {
+	if !is.dedupeRepo(repo) {
 		return "", errors.ErrBlobNotFound
 	}
 
-	dstRecord, err := is.cache.GetBlob(digest)
+	dstRecord, err := is.cachedBlob(repo, digest)
 	if err != nil {
 		return "", err
 	}
@@ -1137,7 +1423,7 @@ func (is *ImageStore) copyBlob(repo string, blobPath string, dstRecord string) (
 
 	_ = ensureDir(filepath.Dir(blobPath), is.log)
 
-	if err := os.Link(dstRecord, blobPath); err != nil {
+	if err := is.linkBlob(dstRecord, blobPath); err != nil {
 		is.log.Error().Err(err).Str("blobPath", blobPath).Str("link", dstRecord).Msg("dedupe: unable to hard link")
 
 		return -1, errors.ErrBlobNotFound
@@ -1145,13 +1431,13 @@ func (is *ImageStore) copyBlob(repo string, blobPath string, dstRecord string) (
 
 	blobInfo, err := os.Stat(blobPath)
 	if err == nil {
-		return blobInfo.Size(), nil
+		return is.blobSize(blobPath, blobInfo.Size())
 	}
 
 	return -1, errors.ErrBlobNotFound
 }
 
-// GetBlob returns a stream to read the blob.
+// GetBlob returns a stream to read the blob, the caller should close it if it's an io.Closer.
 // FIXME: we should probably parse the manifest and use (digest, mediaType) as a
 // blob selector instead of directly downloading the blob.
 func (is *ImageStore) GetBlob(repo string, digest string, mediaType string) (io.Reader, int64, error) {
@@ -1163,22 +1449,27 @@ func (is *ImageStore) GetBlob(repo string, digest string, mediaType string) (io.
 
 	blobPath := is.BlobPath(repo, d)
 
+	if err := is.recallBlob(d); err != nil {
+		return nil, -1, err
+	}
+
+	is.recordBlobAccess(d)
+
 	is.RLock()
 	defer is.RUnlock()
 
-	blobInfo, err := os.Stat(blobPath)
-	if err != nil {
+	if _, err := os.Stat(blobPath); err != nil {
 		is.log.Error().Err(err).Str("blob", blobPath).Msg("failed to stat blob")
 		return nil, -1, errors.ErrBlobNotFound
 	}
 
-	blobReader, err := os.Open(blobPath)
+	blobReader, blobSize, err := is.openBlob(blobPath)
 	if err != nil {
 		is.log.Error().Err(err).Str("blob", blobPath).Msg("failed to open blob")
 		return nil, -1, err
 	}
 
-	return blobReader, blobInfo.Size(), nil
+	return blobReader, blobSize, nil
 }
 
 // DeleteBlob removes the blob from the repository.
@@ -1200,7 +1491,7 @@ func (is *ImageStore) DeleteBlob(repo string, digest string) error {
 		return errors.ErrBlobNotFound
 	}
 
-	if is.cache != nil {
+	if is.dedupeRepo(repo) {
 		if err := is.cache.DeleteBlob(digest, blobPath); err != nil {
 			is.log.Error().Err(err).Str("digest", digest).Str("blobPath", blobPath).Msg("unable to remove blob path from cache")
 			return err
@@ -1278,7 +1569,10 @@ func ensureDir(dir string, log zerolog.Logger) error {
 	return nil
 }
 
-func ifOlderThan(is *ImageStore, repo string, delay time.Duration) casext.GCPolicy {
+// gcPolicy tells whether an unreferenced blob may be removed.
+type gcPolicy func(ctx context.Context, digest godigest.Digest) (bool, error)
+
+func ifOlderThan(is *ImageStore, repo string, delay time.Duration) gcPolicy {
 	return func(ctx context.Context, digest godigest.Digest) (bool, error) {
 		blobPath := is.BlobPath(repo, digest)
 		fi, err := os.Stat(blobPath)
@@ -2,8 +2,12 @@ package storage_test
 
 import (
 	"bytes"
+	"crypto/rand"
 	_ "crypto/sha256"
 	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -11,11 +15,13 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/anuvu/zot/errors"
 	"github.com/anuvu/zot/pkg/log"
 	"github.com/anuvu/zot/pkg/storage"
 	godigest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/rs/zerolog"
 	. "github.com/smartystreets/goconvey/convey"
@@ -482,6 +488,58 @@ func TestAPIs(t *testing.T) {
 			So(os.SameFile(fi1, fi2), ShouldBeTrue)
 		})
 
+		Convey("Referrers", func() {
+			layer := []byte("this is a referred layer")
+			layerDigest := godigest.FromBytes(layer)
+			_, _, err := il.FullBlobUpload("referrers", bytes.NewReader(layer), layerDigest.String())
+			So(err, ShouldBeNil)
+
+			config := []byte("{}")
+			configDigest := godigest.FromBytes(config)
+			_, _, err = il.FullBlobUpload("referrers", bytes.NewReader(config), configDigest.String())
+			So(err, ShouldBeNil)
+
+			m := ispec.Manifest{
+				Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: configDigest, Size: 2},
+				Layers: []ispec.Descriptor{{Digest: layerDigest, Size: int64(len(layer))}},
+			}
+			m.SchemaVersion = 2
+			subject, _ := json.Marshal(m)
+			subjectDigest, err := il.PutImageManifest("referrers", "1.0", ispec.MediaTypeImageManifest, subject)
+			So(err, ShouldBeNil)
+
+			referrers, err := il.GetReferrers("referrers", godigest.Digest(subjectDigest), "")
+			So(err, ShouldBeNil)
+			So(referrers, ShouldBeEmpty)
+
+			referrer, _ := json.Marshal(map[string]interface{}{
+				"schemaVersion": 2,
+				"config": ispec.Descriptor{MediaType: "application/vnd.example.signature", Digest: configDigest,
+					Size: 2},
+				"layers":  m.Layers,
+				"subject": ispec.Descriptor{Digest: godigest.Digest(subjectDigest), Size: int64(len(subject))},
+			})
+			referrerDigest := godigest.FromBytes(referrer)
+			_, err = il.PutImageManifest("referrers", referrerDigest.String(), ispec.MediaTypeImageManifest, referrer)
+			So(err, ShouldBeNil)
+
+			referrers, err = il.GetReferrers("referrers", godigest.Digest(subjectDigest), "")
+			So(err, ShouldBeNil)
+			So(len(referrers), ShouldEqual, 1)
+			So(referrers[0].Digest, ShouldEqual, referrerDigest)
+
+			referrers, err = il.GetReferrers("referrers", godigest.Digest(subjectDigest), "application/vnd.example.signature")
+			So(err, ShouldBeNil)
+			So(len(referrers), ShouldEqual, 1)
+
+			referrers, err = il.GetReferrers("referrers", godigest.Digest(subjectDigest), "application/vnd.example.sbom")
+			So(err, ShouldBeNil)
+			So(referrers, ShouldBeEmpty)
+
+			_, err = il.GetReferrers("missing", godigest.Digest(subjectDigest), "")
+			So(err, ShouldEqual, errors.ErrRepoNotFound)
+		})
+
 		Convey("Locks", func() {
 			// in parallel, a mix of read and write locks - mainly for coverage
 			var wg sync.WaitGroup
@@ -846,3 +904,2501 @@ func TestStorageHandler(t *testing.T) {
 		So(is.RootDir(), ShouldEqual, firstRootDir)
 	})
 }
+
+func readBlob(is *storage.ImageStore, repo string, digest godigest.Digest) ([]byte, int64, error) {
+	reader, size, err := is.GetBlob(repo, digest.String(), "application/octet-stream")
+	if err != nil {
+		return nil, -1, err
+	}
+
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	buf, err := ioutil.ReadAll(reader)
+
+	return buf, size, err
+}
+
+func TestEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-repo-test")
+	if err != nil {
+		panic(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	log := log.Logger{Logger: zerolog.New(os.Stdout)}
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	is := storage.NewImageStore(dir, true, true, log)
+	if err := is.SetEncryptionKey(key); err != nil {
+		panic(err)
+	}
+
+	Convey("Invalid keys are rejected", t, func() {
+		err := storage.NewImageStore(dir, false, false, log).SetEncryptionKey([]byte("short"))
+		So(err, ShouldEqual, errors.ErrBadEncryptionKey)
+	})
+
+	Convey("Blobs are encrypted at rest", t, func() {
+		// spans several segments, the last one partial
+		content := make([]byte, 200*1024)
+		_, err := rand.Read(content)
+		So(err, ShouldBeNil)
+
+		digest := godigest.FromBytes(content)
+
+		_, size, err := is.FullBlobUpload("enc", bytes.NewReader(content), digest.String())
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, len(content))
+
+		onDisk, err := ioutil.ReadFile(is.BlobPath("enc", digest))
+		So(err, ShouldBeNil)
+		So(bytes.HasPrefix(onDisk, []byte("ZOTENC")), ShouldBeTrue)
+		So(bytes.Contains(onDisk, content[:1024]), ShouldBeFalse)
+
+		ok, size, err := is.CheckBlob("enc", digest.String())
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(size, ShouldEqual, len(content))
+
+		buf, size, err := readBlob(is, "enc", digest)
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, len(content))
+		So(buf, ShouldResemble, content)
+
+		Convey("Chunked uploads and empty blobs", func() {
+			for _, content := range [][]byte{[]byte("chunked upload"), {}} {
+				digest := godigest.FromBytes(content)
+
+				uuid, err := is.NewBlobUpload("enc")
+				So(err, ShouldBeNil)
+
+				_, err = is.PutBlobChunkStreamed("enc", uuid, bytes.NewReader(content))
+				So(err, ShouldBeNil)
+
+				err = is.FinishBlobUpload("enc", uuid, bytes.NewReader(content), digest.String())
+				So(err, ShouldBeNil)
+
+				buf, size, err := readBlob(is, "enc", digest)
+				So(err, ShouldBeNil)
+				So(size, ShouldEqual, len(content))
+				So(buf, ShouldResemble, content)
+			}
+		})
+
+		Convey("Tampered and truncated blobs are detected", func() {
+			blobPath := is.BlobPath("enc", digest)
+
+			tampered := append([]byte{}, onDisk...)
+			tampered[len(tampered)/2] ^= 0xff
+			So(ioutil.WriteFile(blobPath, tampered, 0600), ShouldBeNil)
+
+			_, _, err := readBlob(is, "enc", digest)
+			So(err, ShouldEqual, errors.ErrBadEncryptedBlob)
+
+			// drop the last segment, the previous one isn't flagged as the last
+			truncated := onDisk[:len(onDisk)-(len(content)%(64*1024))-16]
+			So(ioutil.WriteFile(blobPath, truncated, 0600), ShouldBeNil)
+
+			_, _, err = readBlob(is, "enc", digest)
+			So(err, ShouldEqual, errors.ErrBadEncryptedBlob)
+		})
+
+		Convey("Blobs can't be read with another key", func() {
+			other := storage.NewImageStore(dir, false, false, log)
+			So(other.SetEncryptionKey(bytes.Repeat([]byte{0x24}, 32)), ShouldBeNil)
+
+			_, _, err := readBlob(other, "enc", digest)
+			So(err, ShouldEqual, errors.ErrBadEncryptionKey)
+		})
+	})
+
+	Convey("Plaintext blobs stored before encryption are still readable", t, func() {
+		content := []byte("stored before encryption")
+		digest := godigest.FromBytes(content)
+
+		plain := storage.NewImageStore(dir, false, false, log)
+		_, _, err := plain.FullBlobUpload("legacy", bytes.NewReader(content), digest.String())
+		So(err, ShouldBeNil)
+
+		buf, size, err := readBlob(is, "legacy", digest)
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, len(content))
+		So(buf, ShouldResemble, content)
+	})
+
+	Convey("Image configs are stored in plaintext", t, func() {
+		config, err := json.Marshal(ispec.Image{Architecture: "amd64", OS: "linux"})
+		So(err, ShouldBeNil)
+
+		configDigest := godigest.FromBytes(config)
+		_, _, err = is.FullBlobUpload("config", bytes.NewReader(config), configDigest.String())
+		So(err, ShouldBeNil)
+
+		layer := []byte("layer")
+		layerDigest := godigest.FromBytes(layer)
+		_, _, err = is.FullBlobUpload("config", bytes.NewReader(layer), layerDigest.String())
+		So(err, ShouldBeNil)
+
+		m := ispec.Manifest{
+			Config: ispec.Descriptor{
+				MediaType: ispec.MediaTypeImageConfig,
+				Digest:    configDigest,
+				Size:      int64(len(config)),
+			},
+			Layers: []ispec.Descriptor{
+				{
+					MediaType: ispec.MediaTypeImageLayer,
+					Digest:    layerDigest,
+					Size:      int64(len(layer)),
+				},
+			},
+		}
+		m.SchemaVersion = 2
+		mb, _ := json.Marshal(m)
+
+		_, err = is.PutImageManifest("config", "1.0", ispec.MediaTypeImageManifest, mb)
+		So(err, ShouldBeNil)
+
+		onDisk, err := ioutil.ReadFile(is.BlobPath("config", configDigest))
+		So(err, ShouldBeNil)
+		So(onDisk, ShouldResemble, config)
+
+		onDisk, err = ioutil.ReadFile(is.BlobPath("config", layerDigest))
+		So(err, ShouldBeNil)
+		So(onDisk, ShouldNotResemble, layer)
+	})
+}
+
+func TestDigestAlgorithms(t *testing.T) {
+	Convey("Blobs and manifests are stored by sha512 digests", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, true, true, log.Logger{Logger: zerolog.New(ioutil.Discard)})
+
+		layer := []byte("this is a sha512 layer")
+		layerDigest := godigest.SHA512.FromBytes(layer)
+
+		_, _, err = imgStore.FullBlobUpload("a", bytes.NewReader(layer), layerDigest.String())
+		So(err, ShouldBeNil)
+		So(imgStore.BlobPath("a", layerDigest), ShouldEndWith, path.Join("blobs", "sha512", layerDigest.Encoded()))
+
+		// the sha256 digest of the same content is another blob
+		ok, _, err := imgStore.CheckBlob("a", godigest.FromBytes(layer).String())
+		So(err, ShouldNotBeNil)
+		So(ok, ShouldBeFalse)
+
+		_, _, err = imgStore.FullBlobUpload("a", bytes.NewReader(layer), godigest.SHA512.FromString("other").String())
+		So(err, ShouldEqual, errors.ErrBadBlobDigest)
+
+		_, _, err = imgStore.FullBlobUpload("a", bytes.NewReader(layer), "md5:"+strings.Repeat("0", 32))
+		So(err, ShouldEqual, errors.ErrBadBlobDigest)
+
+		config := []byte(`{"architecture":"amd64","os":"linux"}`)
+		configDigest := godigest.SHA512.FromBytes(config)
+
+		uuid, err := imgStore.NewBlobUpload("a")
+		So(err, ShouldBeNil)
+		_, err = imgStore.PutBlobChunkStreamed("a", uuid, bytes.NewReader(config))
+		So(err, ShouldBeNil)
+		So(imgStore.FinishBlobUpload("a", uuid, bytes.NewReader([]byte{}), configDigest.String()), ShouldBeNil)
+
+		ok, size, err := imgStore.CheckBlob("a", configDigest.String())
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(size, ShouldEqual, len(config))
+
+		manifest := ispec.Manifest{
+			Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: configDigest, Size: int64(len(config))},
+			Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: layerDigest, Size: int64(len(layer))}},
+		}
+		manifest.SchemaVersion = 2
+
+		content, err := json.Marshal(manifest)
+		So(err, ShouldBeNil)
+
+		manifestDigest := godigest.SHA512.FromBytes(content)
+
+		// manifests pushed by tag have the canonical sha256 digest
+		digest, err := imgStore.PutImageManifest("a", "1.0", ispec.MediaTypeImageManifest, content)
+		So(err, ShouldBeNil)
+		So(digest, ShouldEqual, godigest.FromBytes(content).String())
+
+		digest, err = imgStore.PutImageManifest("a", manifestDigest.String(), ispec.MediaTypeImageManifest, content)
+		So(err, ShouldBeNil)
+		So(digest, ShouldEqual, manifestDigest.String())
+
+		_, err = imgStore.PutImageManifest("a", godigest.SHA512.FromString("other").String(), ispec.MediaTypeImageManifest,
+			content)
+		So(err, ShouldEqual, errors.ErrBadManifest)
+
+		body, digest, _, err := imgStore.GetImageManifest("a", manifestDigest.String())
+		So(err, ShouldBeNil)
+		So(body, ShouldResemble, content)
+		So(digest, ShouldEqual, manifestDigest.String())
+
+		So(storage.ManifestDigest(manifestDigest.String(), content), ShouldEqual, manifestDigest)
+		So(storage.ManifestDigest("latest", content), ShouldEqual, godigest.FromBytes(content))
+
+		// sha512 blobs are deduped like sha256 ones
+		_, _, err = imgStore.FullBlobUpload("b", bytes.NewReader(layer), layerDigest.String())
+		So(err, ShouldBeNil)
+
+		first, err := os.Stat(imgStore.BlobPath("a", layerDigest))
+		So(err, ShouldBeNil)
+		second, err := os.Stat(imgStore.BlobPath("b", layerDigest))
+		So(err, ShouldBeNil)
+		So(os.SameFile(first, second), ShouldBeTrue)
+	})
+}
+
+func TestPullStats(t *testing.T) {
+	Convey("Pull statistics are counted and saved", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		il := log.NewLogger("debug", "")
+		imgStore := storage.NewImageStore(dir, false, false, il)
+		So(imgStore.PullStats().Repos(), ShouldBeEmpty)
+
+		// views of the store share the statistics
+		imgStore.WithLogger(il).PullStats().Record("test", "1.0", "alice")
+		imgStore.PullStats().Record("test", "1.0", "")
+		imgStore.PullStats().Record("test", "sha256:abcd", "bob")
+		imgStore.PullStats().Record("other", "latest", "alice")
+
+		repos := imgStore.PullStats().Repos()
+		So(len(repos), ShouldEqual, 2)
+		So(repos["test"].Count, ShouldEqual, 3)
+		So(repos["test"].LastPull.IsZero(), ShouldBeFalse)
+		So(repos["test"].Tags["1.0"].Count, ShouldEqual, 2)
+		So(repos["test"].Tags["sha256:abcd"].Count, ShouldEqual, 1)
+		So(repos["other"].Count, ShouldEqual, 1)
+
+		// anonymous pulls are not counted per user
+		users := imgStore.PullStats().Users()
+		So(len(users), ShouldEqual, 2)
+		So(users["alice"].Count, ShouldEqual, 2)
+		So(users["bob"].Count, ShouldEqual, 1)
+
+		// copies are not updated
+		imgStore.PullStats().Record("test", "1.0", "alice")
+		So(repos["test"].Tags["1.0"].Count, ShouldEqual, 2)
+
+		statsFile := path.Join(dir, storage.PullStatsFile)
+		_, err = os.Stat(statsFile)
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		So(imgStore.PullStats().Flush(), ShouldBeNil)
+
+		reloaded := storage.NewImageStore(dir, false, false, il).PullStats()
+		So(reloaded.Repos()["test"].Count, ShouldEqual, 4)
+		So(reloaded.Repos()["test"].Tags["1.0"].Count, ShouldEqual, 3)
+		So(reloaded.Users()["alice"].Count, ShouldEqual, 3)
+
+		// unchanged statistics are not written again
+		So(os.Remove(statsFile), ShouldBeNil)
+		So(imgStore.PullStats().Flush(), ShouldBeNil)
+		_, err = os.Stat(statsFile)
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		So(storage.NewPullStatsTask(imgStore).DoWork(), ShouldBeNil)
+
+		// corrupted statistics are discarded
+		So(ioutil.WriteFile(statsFile, []byte("{"), 0600), ShouldBeNil)
+		So(storage.NewImageStore(dir, false, false, il).PullStats().Repos(), ShouldBeEmpty)
+	})
+}
+
+func TestDeprecation(t *testing.T) {
+	Convey("Repositories and tags are deprecated", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+		So(imgStore.InitRepo("test"), ShouldBeNil)
+
+		content := []byte("this is a blob")
+		digest := godigest.FromBytes(content)
+		_, _, err = imgStore.FullBlobUpload("test", bytes.NewReader(content), digest.String())
+		So(err, ShouldBeNil)
+
+		manifest := ispec.Manifest{
+			Config: ispec.Descriptor{
+				MediaType: ispec.MediaTypeImageConfig,
+				Digest:    digest,
+				Size:      int64(len(content)),
+			},
+			Layers: []ispec.Descriptor{
+				{
+					MediaType: ispec.MediaTypeImageLayer,
+					Digest:    digest,
+					Size:      int64(len(content)),
+				},
+			},
+		}
+		manifest.SchemaVersion = 2
+		mb, err := json.Marshal(manifest)
+		So(err, ShouldBeNil)
+
+		manifestDigest, err := imgStore.PutImageManifest("test", "1.0", ispec.MediaTypeImageManifest, mb)
+		So(err, ShouldBeNil)
+
+		deprecation, err := imgStore.GetDeprecation("test", "1.0")
+		So(err, ShouldBeNil)
+		So(deprecation, ShouldBeNil)
+
+		So(imgStore.SetDeprecation("test", "1.0", &storage.Deprecation{Message: "vulnerable"}), ShouldBeNil)
+
+		deprecation, err = imgStore.GetDeprecation("test", manifestDigest)
+		So(err, ShouldBeNil)
+		So(deprecation, ShouldResemble, &storage.Deprecation{Message: "vulnerable"})
+
+		// the deprecation of a tag takes precedence over the one of the repository
+		So(imgStore.SetDeprecation("test", "", &storage.Deprecation{Message: "moved", Replacement: "other"}), ShouldBeNil)
+
+		deprecation, err = imgStore.GetDeprecation("test", "1.0")
+		So(err, ShouldBeNil)
+		So(deprecation.Message, ShouldEqual, "vulnerable")
+
+		deprecation, err = imgStore.GetDeprecation("test", "unknown")
+		So(err, ShouldBeNil)
+		So(deprecation, ShouldResemble, &storage.Deprecation{Message: "moved", Replacement: "other"})
+
+		deprecations, err := imgStore.GetDeprecations("test")
+		So(err, ShouldBeNil)
+		So(deprecations, ShouldResemble, map[string]storage.Deprecation{
+			"":    {Message: "moved", Replacement: "other"},
+			"1.0": {Message: "vulnerable"},
+		})
+
+		// the deprecation doesn't change the manifests
+		tags, err := imgStore.GetImageTags("test")
+		So(err, ShouldBeNil)
+		So(tags, ShouldResemble, []string{"1.0"})
+
+		So(imgStore.SetDeprecation("test", "1.0", nil), ShouldBeNil)
+		So(imgStore.SetDeprecation("test", "", nil), ShouldBeNil)
+
+		deprecations, err = imgStore.GetDeprecations("test")
+		So(err, ShouldBeNil)
+		So(deprecations, ShouldBeEmpty)
+
+		So(imgStore.SetDeprecation("test", "2.0", nil), ShouldEqual, errors.ErrManifestNotFound)
+		So(imgStore.SetDeprecation("unknown", "", nil), ShouldEqual, errors.ErrRepoNotFound)
+
+		_, err = imgStore.GetDeprecation("unknown", "1.0")
+		So(err, ShouldEqual, errors.ErrRepoNotFound)
+	})
+}
+
+func TestDedupeReport(t *testing.T) {
+	Convey("Duplicate blobs are reported and hard linked", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		// blobs pushed while dedupe is disabled are copied in each repository
+		imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+
+		shared, other := []byte("this is a shared blob"), []byte("this is a blob")
+		size := int64(len(shared))
+
+		for _, repo := range []string{"a", "b", "c/d"} {
+			So(imgStore.InitRepo(repo), ShouldBeNil)
+
+			_, _, err = imgStore.FullBlobUpload(repo, bytes.NewReader(shared), godigest.FromBytes(shared).String())
+			So(err, ShouldBeNil)
+		}
+
+		_, _, err = imgStore.FullBlobUpload("a", bytes.NewReader(other), godigest.FromBytes(other).String())
+		So(err, ShouldBeNil)
+
+		report, err := imgStore.DedupeReport(storage.DefaultDedupeReportTop)
+		So(err, ShouldBeNil)
+		So(report.Dedupe, ShouldBeFalse)
+		So(report.Blobs, ShouldEqual, 2)
+		So(report.BlobFiles, ShouldEqual, 4)
+		So(report.LogicalBytes, ShouldEqual, 3*size+int64(len(other)))
+		So(report.PhysicalBytes, ShouldEqual, report.LogicalBytes)
+		So(report.ReclaimableBytes, ShouldEqual, 2*size)
+		So(report.TopDuplicates, ShouldResemble, []storage.DuplicateBlob{{
+			Digest:     godigest.FromBytes(shared),
+			Size:       size,
+			Repos:      []string{"a", "b", "c/d"},
+			Copies:     3,
+			SavedBytes: 2 * size,
+		}})
+
+		report, err = imgStore.DedupeReport(0)
+		So(err, ShouldBeNil)
+		So(report.TopDuplicates, ShouldBeEmpty)
+
+		// nothing is linked unless dedupe is enabled
+		result, err := imgStore.Rededupe()
+		So(err, ShouldBeNil)
+		So(result.Linked, ShouldEqual, 0)
+
+		imgStore = storage.NewImageStore(dir, false, true, log.NewLogger("debug", ""))
+
+		result, err = imgStore.Rededupe()
+		So(err, ShouldBeNil)
+		So(result.Linked, ShouldEqual, 2)
+		So(result.ReclaimedBytes, ShouldEqual, 2*size)
+
+		report, err = imgStore.DedupeReport(storage.DefaultDedupeReportTop)
+		So(err, ShouldBeNil)
+		So(report.Dedupe, ShouldBeTrue)
+		So(report.PhysicalBytes, ShouldEqual, size+int64(len(other)))
+		So(report.ReclaimableBytes, ShouldEqual, 0)
+		So(report.TopDuplicates[0].Copies, ShouldEqual, 1)
+
+		for _, repo := range []string{"a", "b", "c/d"} {
+			blob, _, err := imgStore.GetBlob(repo, godigest.FromBytes(shared).String(), "")
+			So(err, ShouldBeNil)
+
+			content, err := ioutil.ReadAll(blob)
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, shared)
+		}
+
+		result, err = imgStore.Rededupe()
+		So(err, ShouldBeNil)
+		So(result.Linked, ShouldEqual, 0)
+
+		// blobs pushed from now on are linked to the deduped ones
+		So(imgStore.InitRepo("e"), ShouldBeNil)
+
+		_, _, err = imgStore.FullBlobUpload("e", bytes.NewReader(shared), godigest.FromBytes(shared).String())
+		So(err, ShouldBeNil)
+
+		report, err = imgStore.DedupeReport(storage.DefaultDedupeReportTop)
+		So(err, ShouldBeNil)
+		So(report.ReclaimableBytes, ShouldEqual, 0)
+		So(report.TopDuplicates[0].Repos, ShouldResemble, []string{"a", "b", "c/d", "e"})
+	})
+}
+
+func TestRepoRules(t *testing.T) {
+	Convey("Repository rules override dedupe and encryption", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, true, log.NewLogger("debug", ""))
+		So(imgStore.SetEncryptionKey(bytes.Repeat([]byte{0x42}, 32)), ShouldBeNil)
+
+		content := []byte("this is a shared blob")
+		digest := godigest.FromBytes(content)
+
+		push := func(repo string) {
+			So(imgStore.InitRepo(repo), ShouldBeNil)
+
+			_, _, err := imgStore.FullBlobUpload(repo, bytes.NewReader(content), digest.String())
+			So(err, ShouldBeNil)
+		}
+
+		sameFile := func(repo1, repo2 string) bool {
+			fi1, err := os.Stat(imgStore.BlobPath(repo1, digest))
+			So(err, ShouldBeNil)
+
+			fi2, err := os.Stat(imgStore.BlobPath(repo2, digest))
+			So(err, ShouldBeNil)
+
+			return os.SameFile(fi1, fi2)
+		}
+
+		encrypted := func(repo string) bool {
+			onDisk, err := ioutil.ReadFile(imgStore.BlobPath(repo, digest))
+			So(err, ShouldBeNil)
+
+			buf, _, err := readBlob(imgStore, repo, digest)
+			So(err, ShouldBeNil)
+			So(buf, ShouldResemble, content)
+
+			return bytes.HasPrefix(onDisk, []byte("ZOTENC"))
+		}
+
+		push("a")
+		push("secret/b")
+		So(sameFile("a", "secret/b"), ShouldBeTrue)
+
+		// the blobs of the repositories which aren't deduped anymore get a copy of their own
+		noDedupe := false
+		So(imgStore.SetRepoRules(map[string]storage.RepoRule{"secret/": {Dedupe: &noDedupe}}), ShouldBeNil)
+		So(sameFile("a", "secret/b"), ShouldBeFalse)
+		So(encrypted("secret/b"), ShouldBeTrue)
+
+		_, err = os.Stat(path.Join(dir, "reporules.json"))
+		So(err, ShouldBeNil)
+
+		push("secret/c")
+		So(sameFile("a", "secret/c"), ShouldBeFalse)
+		So(sameFile("secret/b", "secret/c"), ShouldBeFalse)
+
+		// nor are blobs of the other repositories mounted from the dedupe cache
+		ok, _, err := imgStore.CheckBlob("secret/d", digest.String())
+		So(err, ShouldNotBeNil)
+		So(ok, ShouldBeFalse)
+
+		ok, _, err = imgStore.CheckBlob("e", digest.String())
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(sameFile("a", "e"), ShouldBeTrue)
+
+		// the longest prefix applies, plaintext repositories are decrypted
+		So(imgStore.SetRepoRules(map[string]storage.RepoRule{
+			"secret":   {Dedupe: &noDedupe},
+			"secret/c": {Plaintext: true},
+		}), ShouldBeNil)
+		So(encrypted("secret/b"), ShouldBeTrue)
+		So(encrypted("secret/c"), ShouldBeFalse)
+
+		push("secret/c/f")
+		So(encrypted("secret/c/f"), ShouldBeFalse)
+
+		// the same rules again are already applied
+		So(imgStore.SetRepoRules(map[string]storage.RepoRule{
+			"secret":   {Dedupe: &noDedupe},
+			"secret/c": {Plaintext: true},
+		}), ShouldBeNil)
+
+		// without rules, the encrypted copies are linked again, blobs stored in plaintext stay so
+		So(imgStore.SetRepoRules(nil), ShouldBeNil)
+		So(sameFile("a", "secret/b"), ShouldBeTrue)
+		So(encrypted("secret/c"), ShouldBeFalse)
+
+		push("secret/g")
+		So(sameFile("a", "secret/g"), ShouldBeTrue)
+	})
+
+	Convey("Repository rules enable dedupe", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+		So(imgStore.DedupeEnabled(), ShouldBeFalse)
+
+		dedupe := true
+		So(imgStore.SetRepoRules(map[string]storage.RepoRule{"shared": {Dedupe: &dedupe}}), ShouldBeNil)
+		So(imgStore.DedupeEnabled(), ShouldBeTrue)
+
+		content := []byte("this is a shared blob")
+		digest := godigest.FromBytes(content)
+
+		for _, repo := range []string{"shared/a", "shared/b", "other/a", "other/b"} {
+			So(imgStore.InitRepo(repo), ShouldBeNil)
+
+			_, _, err := imgStore.FullBlobUpload(repo, bytes.NewReader(content), digest.String())
+			So(err, ShouldBeNil)
+		}
+
+		report, err := imgStore.DedupeReport(storage.DefaultDedupeReportTop)
+		So(err, ShouldBeNil)
+		So(report.TopDuplicates[0].Copies, ShouldEqual, 3)
+	})
+
+	Convey("Isolated prefixes are dedupe trust boundaries", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, true, log.NewLogger("debug", ""))
+
+		content := []byte("this is a shared blob")
+		digest := godigest.FromBytes(content)
+
+		push := func(repo string) {
+			So(imgStore.InitRepo(repo), ShouldBeNil)
+
+			_, _, err := imgStore.FullBlobUpload(repo, bytes.NewReader(content), digest.String())
+			So(err, ShouldBeNil)
+		}
+
+		sameFile := func(repo1, repo2 string) bool {
+			fi1, err := os.Stat(imgStore.BlobPath(repo1, digest))
+			So(err, ShouldBeNil)
+
+			fi2, err := os.Stat(imgStore.BlobPath(repo2, digest))
+			So(err, ShouldBeNil)
+
+			return os.SameFile(fi1, fi2)
+		}
+
+		push("a")
+		push("acme/b")
+		push("acme/c")
+		push("globex/d")
+		So(sameFile("a", "acme/b"), ShouldBeTrue)
+		So(sameFile("a", "globex/d"), ShouldBeTrue)
+
+		// the blobs linked across isolated prefixes are copied, and linked again within each of them
+		So(imgStore.SetRepoRules(map[string]storage.RepoRule{
+			"acme/":   {Isolated: true},
+			"globex/": {Isolated: true},
+		}), ShouldBeNil)
+		So(sameFile("a", "acme/b"), ShouldBeFalse)
+		So(sameFile("a", "globex/d"), ShouldBeFalse)
+		So(sameFile("acme/b", "globex/d"), ShouldBeFalse)
+		So(sameFile("acme/b", "acme/c"), ShouldBeTrue)
+
+		// pushes are deduped within the prefix of their repository only
+		push("acme/e")
+		push("globex/f")
+		push("g")
+		So(sameFile("acme/b", "acme/e"), ShouldBeTrue)
+		So(sameFile("globex/d", "globex/f"), ShouldBeTrue)
+		So(sameFile("a", "g"), ShouldBeTrue)
+		So(sameFile("acme/e", "globex/f"), ShouldBeFalse)
+
+		// blobs of other prefixes aren't mounted from the dedupe cache
+		other := []byte("this is a blob of acme")
+		otherDigest := godigest.FromBytes(other)
+		_, _, err = imgStore.FullBlobUpload("acme/b", bytes.NewReader(other), otherDigest.String())
+		So(err, ShouldBeNil)
+
+		ok, _, err := imgStore.CheckBlob("globex/d", otherDigest.String())
+		So(err, ShouldNotBeNil)
+		So(ok, ShouldBeFalse)
+
+		ok, _, err = imgStore.CheckBlob("h", otherDigest.String())
+		So(err, ShouldNotBeNil)
+		So(ok, ShouldBeFalse)
+
+		ok, _, err = imgStore.CheckBlob("acme/h", otherDigest.String())
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+
+		// rededupe keeps the boundaries
+		result, err := imgStore.Rededupe()
+		So(err, ShouldBeNil)
+		So(result.Linked, ShouldEqual, 0)
+		So(sameFile("a", "acme/b"), ShouldBeFalse)
+
+		// without rules, the copies are linked together again
+		So(imgStore.SetRepoRules(nil), ShouldBeNil)
+		So(sameFile("a", "acme/b"), ShouldBeTrue)
+		So(sameFile("a", "globex/f"), ShouldBeTrue)
+	})
+}
+
+func TestGCPlan(t *testing.T) {
+	Convey("Tag expiry and GC are planned without removing anything", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, true, log.NewLogger("debug", ""))
+
+		shared, other := []byte("this is a shared layer"), []byte("this is a layer")
+
+		expiredA := pushFsckImage(imgStore, "a", "1.0", shared)
+		pushFsckImage(imgStore, "b", "1.0", shared)
+		expiredC := pushFsckImage(imgStore, "c", "1.0", other)
+
+		for _, repo := range []string{"a", "c"} {
+			_, err := imgStore.SetTagExpiry(repo, "1.0", time.Minute)
+			So(err, ShouldBeNil)
+		}
+
+		plan, err := imgStore.PlanGC(time.Now())
+		So(err, ShouldBeNil)
+		So(plan.GC, ShouldBeFalse)
+		So(plan.Tags, ShouldBeEmpty)
+		So(plan.Manifests, ShouldBeEmpty)
+		So(plan.Blobs, ShouldBeEmpty)
+		So(plan.ReclaimedBytes, ShouldEqual, 0)
+
+		// past the expiry of the tags and the GC delay
+		plan, err = imgStore.PlanGC(time.Now().Add(2 * time.Hour))
+		So(err, ShouldBeNil)
+		So(len(plan.Tags), ShouldEqual, 2)
+		So(plan.Tags[0].Repo, ShouldEqual, "a")
+		So(plan.Tags[0].Tag, ShouldEqual, "1.0")
+		So(plan.Tags[0].Digest, ShouldEqual, expiredA)
+		So(plan.Tags[1].Repo, ShouldEqual, "c")
+
+		So(len(plan.Manifests), ShouldEqual, 2)
+		So(plan.Manifests[0].Digest, ShouldEqual, expiredA)
+		So(plan.Manifests[1].Digest, ShouldEqual, expiredC)
+
+		// the configs of both images and their layers, the shared one being kept by b
+		So(len(plan.Blobs), ShouldEqual, 4)
+
+		var listed int64
+
+		for _, blob := range append(plan.Manifests, plan.Blobs...) {
+			So(blob.Repo, ShouldNotEqual, "b")
+			listed += blob.Size
+		}
+
+		So(plan.ReclaimedBytes, ShouldEqual, listed-int64(len(shared)))
+
+		tags, err := imgStore.GetImageTags("a")
+		So(err, ShouldBeNil)
+		So(tags, ShouldContain, "1.0")
+	})
+}
+
+func TestGCOnDelete(t *testing.T) {
+	Convey("Blobs of deleted manifests are removed right away", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, true, log.NewLogger("debug", ""))
+
+		shared, other := []byte("this is a shared layer"), []byte("this is a layer")
+		sharedDigest, otherDigest := godigest.FromBytes(shared), godigest.FromBytes(other)
+
+		first := pushFsckImage(imgStore, "a", "1.0", other)
+		second := pushFsckImage(imgStore, "a", "2.0", shared)
+		pushFsckImage(imgStore, "b", "1.0", shared)
+
+		exists := func(repo string, digest godigest.Digest) bool {
+			_, err := os.Stat(imgStore.BlobPath(repo, digest))
+
+			return err == nil
+		}
+
+		Convey("Only with the option", func() {
+			So(imgStore.DeleteImageManifest("a", "1.0"), ShouldBeNil)
+			So(exists("a", otherDigest), ShouldBeTrue)
+		})
+
+		imgStore.SetGCOnDelete(true)
+
+		Convey("Unless other manifests of the repository reference them", func() {
+			content, _, mediaType, err := imgStore.GetImageManifest("a", "1.0")
+			So(err, ShouldBeNil)
+			_, err = imgStore.PutImageManifest("a", "latest", mediaType, content)
+			So(err, ShouldBeNil)
+
+			// the manifest is still tagged latest
+			So(imgStore.DeleteImageManifest("a", "1.0"), ShouldBeNil)
+			So(exists("a", first), ShouldBeTrue)
+			So(exists("a", otherDigest), ShouldBeTrue)
+
+			So(imgStore.DeleteImageManifest("a", "latest"), ShouldBeNil)
+			So(exists("a", first), ShouldBeFalse)
+			So(exists("a", otherDigest), ShouldBeFalse)
+			So(exists("a", sharedDigest), ShouldBeTrue)
+		})
+
+		Convey("Deduped blobs are kept by the other repositories", func() {
+			So(imgStore.DeleteImageManifest("a", second.String()), ShouldBeNil)
+			So(exists("a", second), ShouldBeFalse)
+			So(exists("a", sharedDigest), ShouldBeFalse)
+			So(exists("a", otherDigest), ShouldBeTrue)
+
+			ok, size, err := imgStore.CheckBlob("b", sharedDigest.String())
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(size, ShouldEqual, len(shared))
+
+			// the blob is deduped from b's copy, a's one isn't in the cache anymore
+			pushFsckImage(imgStore, "c", "1.0", shared)
+
+			ok, _, err = imgStore.CheckBlob("c", sharedDigest.String())
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+
+			fa, err := os.Stat(imgStore.BlobPath("b", sharedDigest))
+			So(err, ShouldBeNil)
+			fc, err := os.Stat(imgStore.BlobPath("c", sharedDigest))
+			So(err, ShouldBeNil)
+			So(os.SameFile(fa, fc), ShouldBeTrue)
+		})
+	})
+}
+
+func TestSharedLayers(t *testing.T) {
+	Convey("Layers shared by several images are counted", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, true, log.NewLogger("debug", ""))
+
+		shared, other := []byte("this is a shared layer"), []byte("this is a layer")
+		size := int64(len(shared))
+
+		pushFsckImage(imgStore, "a", "1.0", shared)
+		pushFsckImage(imgStore, "a", "2.0", shared)
+		pushFsckImage(imgStore, "b", "1.0", shared)
+		pushFsckImage(imgStore, "c", "1.0", other)
+
+		layers, err := imgStore.SharedLayers(storage.DefaultDedupeReportTop)
+		So(err, ShouldBeNil)
+		So(layers, ShouldResemble, []storage.SharedLayer{{
+			Digest:     godigest.FromBytes(shared),
+			Size:       size,
+			Images:     3,
+			Repos:      []string{"a", "b"},
+			Copies:     1,
+			SavedBytes: size,
+		}, {
+			Digest: godigest.FromBytes(other),
+			Size:   int64(len(other)),
+			Images: 1,
+			Repos:  []string{"c"},
+			Copies: 1,
+		}})
+
+		layers, err = imgStore.SharedLayers(1)
+		So(err, ShouldBeNil)
+		So(len(layers), ShouldEqual, 1)
+		So(layers[0].Digest, ShouldEqual, godigest.FromBytes(shared))
+
+		// copies made while dedupe is disabled save nothing
+		imgStore = storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+
+		pushFsckImage(imgStore, "d", "1.0", shared)
+
+		layers, err = imgStore.SharedLayers(1)
+		So(err, ShouldBeNil)
+		So(layers[0].Images, ShouldEqual, 4)
+		So(layers[0].Copies, ShouldEqual, 2)
+		So(layers[0].SavedBytes, ShouldEqual, size)
+	})
+}
+
+func TestDiskSpace(t *testing.T) {
+	Convey("Uploads are refused while low on disk space", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+		So(imgStore.InitRepo("a"), ShouldBeNil)
+
+		space, err := imgStore.CheckDiskSpace()
+		So(err, ShouldBeNil)
+		So(space.TotalBytes, ShouldBeGreaterThanOrEqualTo, space.FreeBytes)
+		So(space.MinFreeBytes, ShouldEqual, 0)
+		So(space.Low, ShouldBeFalse)
+
+		lows := 0
+		imgStore.SetDiskSpaceThreshold(1<<62, 0, func() { lows++ })
+
+		space, err = imgStore.CheckDiskSpace()
+		So(err, ShouldBeNil)
+		So(space.Low, ShouldBeTrue)
+		So(imgStore.DiskSpace(), ShouldResemble, space)
+
+		// onLow is only called when the free space falls below the threshold
+		_, err = imgStore.CheckDiskSpace()
+		So(err, ShouldBeNil)
+		So(lows, ShouldEqual, 1)
+
+		_, err = imgStore.NewBlobUpload("a")
+		So(err, ShouldEqual, errors.ErrInsufficientStorage)
+
+		blob := []byte("this is a blob")
+		_, _, err = imgStore.FullBlobUpload("a", bytes.NewReader(blob), godigest.FromBytes(blob).String())
+		So(err, ShouldEqual, errors.ErrInsufficientStorage)
+
+		// the larger of the thresholds applies
+		imgStore.SetDiskSpaceThreshold(1, 100, nil)
+
+		space, err = imgStore.CheckDiskSpace()
+		So(err, ShouldBeNil)
+		So(space.MinFreeBytes, ShouldEqual, space.TotalBytes)
+
+		imgStore.SetDiskSpaceThreshold(0, 0, nil)
+
+		space, err = imgStore.CheckDiskSpace()
+		So(err, ShouldBeNil)
+		So(space.Low, ShouldBeFalse)
+
+		_, err = imgStore.NewBlobUpload("a")
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestTiering(t *testing.T) {
+	Convey("Layers which weren't pulled recently are moved to the cold tier", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		coldDir, err := ioutil.TempDir("", "oci-cold-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(coldDir)
+
+		imgStore := storage.NewImageStore(dir, true, true, log.NewLogger("debug", ""))
+		So(imgStore.TieringEnabled(), ShouldBeFalse)
+		So(imgStore.EnableTiering(coldDir, time.Hour), ShouldBeNil)
+		So(imgStore.TieringEnabled(), ShouldBeTrue)
+
+		layer, config := []byte("this is a layer"), []byte("{}")
+		layerDigest, configDigest := godigest.FromBytes(layer), godigest.FromBytes(config)
+
+		m := ispec.Manifest{
+			Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: configDigest,
+				Size: int64(len(config))},
+			Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: layerDigest,
+				Size: int64(len(layer))}},
+		}
+		m.SchemaVersion = 2
+
+		manifest, err := json.Marshal(m)
+		So(err, ShouldBeNil)
+
+		for _, repo := range []string{"a", "b"} {
+			So(imgStore.InitRepo(repo), ShouldBeNil)
+
+			for _, blob := range [][]byte{layer, config} {
+				_, _, err = imgStore.FullBlobUpload(repo, bytes.NewReader(blob), godigest.FromBytes(blob).String())
+				So(err, ShouldBeNil)
+			}
+
+			_, err = imgStore.PutImageManifest(repo, "1.0", ispec.MediaTypeImageManifest, manifest)
+			So(err, ShouldBeNil)
+		}
+
+		// just pushed
+		result, err := imgStore.TierBlobs()
+		So(err, ShouldBeNil)
+		So(result.Moved, ShouldEqual, 0)
+
+		// the copies of the layer are hard links of each other
+		pushed := time.Now().Add(-2 * time.Hour)
+		So(os.Chtimes(imgStore.BlobPath("a", layerDigest), pushed, pushed), ShouldBeNil)
+		So(os.Chtimes(imgStore.BlobPath("a", configDigest), pushed, pushed), ShouldBeNil)
+
+		So(storage.NewTieringTask(imgStore).DoWork(), ShouldBeNil)
+
+		// image configs are never moved
+		for _, repo := range []string{"a", "b"} {
+			info, err := os.Lstat(imgStore.BlobPath(repo, layerDigest))
+			So(err, ShouldBeNil)
+			So(info.Mode()&os.ModeSymlink, ShouldNotEqual, 0)
+
+			info, err = os.Lstat(imgStore.BlobPath(repo, configDigest))
+			So(err, ShouldBeNil)
+			So(info.Mode().IsRegular(), ShouldBeTrue)
+		}
+
+		report, err := imgStore.TieringReport()
+		So(err, ShouldBeNil)
+		So(report.ColdDir, ShouldEqual, coldDir)
+		So(report.ColdAfter, ShouldEqual, time.Hour)
+		So(report.ColdBlobs, ShouldEqual, 1)
+		So(report.ColdBytes, ShouldEqual, len(layer))
+
+		// checks don't recall the layer
+		ok, size, err := imgStore.CheckBlob("a", layerDigest.String())
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(size, ShouldEqual, len(layer))
+
+		// pulls do, in all the repositories
+		reader, _, err := imgStore.GetBlob("b", layerDigest.String(), ispec.MediaTypeImageLayer)
+		So(err, ShouldBeNil)
+
+		content, err := ioutil.ReadAll(reader)
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, layer)
+
+		infoA, err := os.Lstat(imgStore.BlobPath("a", layerDigest))
+		So(err, ShouldBeNil)
+		infoB, err := os.Lstat(imgStore.BlobPath("b", layerDigest))
+		So(err, ShouldBeNil)
+		So(infoA.Mode().IsRegular(), ShouldBeTrue)
+		So(os.SameFile(infoA, infoB), ShouldBeTrue)
+
+		report, err = imgStore.TieringReport()
+		So(err, ShouldBeNil)
+		So(report.ColdBlobs, ShouldEqual, 0)
+
+		// just pulled
+		result, err = imgStore.TierBlobs()
+		So(err, ShouldBeNil)
+		So(result.Moved, ShouldEqual, 0)
+
+		Convey("Cold blobs removed from all repositories are dropped", func() {
+			So(imgStore.EnableTiering(coldDir, time.Nanosecond), ShouldBeNil)
+
+			result, err := imgStore.TierBlobs()
+			So(err, ShouldBeNil)
+			So(result.Moved, ShouldEqual, 1)
+			So(result.MovedBytes, ShouldEqual, len(layer))
+
+			So(imgStore.DeleteBlob("a", layerDigest.String()), ShouldBeNil)
+
+			_, err = imgStore.TierBlobs()
+			So(err, ShouldBeNil)
+
+			report, err := imgStore.TieringReport()
+			So(err, ShouldBeNil)
+			So(report.ColdBlobs, ShouldEqual, 1)
+
+			// e.g. garbage collected
+			So(os.Remove(imgStore.BlobPath("b", layerDigest)), ShouldBeNil)
+
+			_, err = imgStore.TierBlobs()
+			So(err, ShouldBeNil)
+
+			files, err := ioutil.ReadDir(path.Join(coldDir, "blobs", "sha256"))
+			So(err, ShouldBeNil)
+			So(files, ShouldBeEmpty)
+		})
+
+		Convey("Layers are recalled before being linked to other repositories", func() {
+			So(imgStore.EnableTiering(coldDir, time.Nanosecond), ShouldBeNil)
+
+			result, err := imgStore.TierBlobs()
+			So(err, ShouldBeNil)
+			So(result.Moved, ShouldEqual, 1)
+
+			ok, _, err := imgStore.CheckBlob("c", layerDigest.String())
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+
+			for _, repo := range []string{"a", "b", "c"} {
+				info, err := os.Lstat(imgStore.BlobPath(repo, layerDigest))
+				So(err, ShouldBeNil)
+				So(info.Mode().IsRegular(), ShouldBeTrue)
+			}
+		})
+	})
+}
+
+func TestDirectIO(t *testing.T) {
+	Convey("Blobs are read with O_DIRECT", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+		imgStore.SetDirectIO(true)
+
+		// not a multiple of the alignment of O_DIRECT reads
+		blob := make([]byte, 3<<20+123)
+		_, err = rand.Read(blob)
+		So(err, ShouldBeNil)
+
+		digest := godigest.FromBytes(blob).String()
+
+		_, _, err = imgStore.FullBlobUpload("test", bytes.NewReader(blob), digest)
+		So(err, ShouldBeNil)
+
+		reader, size, err := imgStore.GetBlob("test", digest, ispec.MediaTypeImageLayer)
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, len(blob))
+
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, reader)
+		So(err, ShouldBeNil)
+		So(buf.Bytes(), ShouldResemble, blob)
+		So(reader.(io.Closer).Close(), ShouldBeNil)
+
+		// in small reads
+		reader, _, err = imgStore.GetBlob("test", digest, ispec.MediaTypeImageLayer)
+		So(err, ShouldBeNil)
+
+		content, err := ioutil.ReadAll(io.LimitReader(reader, int64(len(blob))+1))
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, blob)
+		So(reader.(io.Closer).Close(), ShouldBeNil)
+
+		// encrypted blobs are read through the page cache
+		So(imgStore.SetEncryptionKey(bytes.Repeat([]byte{1}, 32)), ShouldBeNil)
+
+		_, _, err = imgStore.FullBlobUpload("encrypted", bytes.NewReader(blob), digest)
+		So(err, ShouldBeNil)
+
+		reader, size, err = imgStore.GetBlob("encrypted", digest, ispec.MediaTypeImageLayer)
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, len(blob))
+
+		content, err = ioutil.ReadAll(reader)
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, blob)
+	})
+}
+
+func TestCatalog(t *testing.T) {
+	Convey("Images are listed from the catalog", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+
+		created := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		config, err := json.Marshal(ispec.Image{Created: &created, Architecture: "amd64", OS: "linux"})
+		So(err, ShouldBeNil)
+
+		layer := []byte("this is a layer")
+
+		pushImage := func(repo, tag string) godigest.Digest {
+			for _, content := range [][]byte{config, layer} {
+				_, _, err := imgStore.FullBlobUpload(repo, bytes.NewReader(content), godigest.FromBytes(content).String())
+				So(err, ShouldBeNil)
+			}
+
+			manifest := ispec.Manifest{
+				Config: ispec.Descriptor{
+					MediaType: ispec.MediaTypeImageConfig,
+					Digest:    godigest.FromBytes(config),
+					Size:      int64(len(config)),
+				},
+				Layers: []ispec.Descriptor{
+					{
+						MediaType: ispec.MediaTypeImageLayer,
+						Digest:    godigest.FromBytes(layer),
+						Size:      int64(len(layer)),
+					},
+				},
+			}
+			manifest.SchemaVersion = 2
+			mb, err := json.Marshal(manifest)
+			So(err, ShouldBeNil)
+
+			_, err = imgStore.PutImageManifest(repo, tag, ispec.MediaTypeImageManifest, mb)
+			So(err, ShouldBeNil)
+
+			return godigest.FromBytes(mb)
+		}
+
+		digest := pushImage("b", "1.0")
+		pushImage("a/c", "2.0")
+		pushImage("a/c", "1.0")
+
+		images, err := imgStore.CatalogImages("")
+		So(err, ShouldBeNil)
+		So(len(images), ShouldEqual, 3)
+		So(images[0].Repo, ShouldEqual, "a/c")
+		So(images[0].Tag, ShouldEqual, "1.0")
+		So(images[1].Tag, ShouldEqual, "2.0")
+		So(images[2], ShouldResemble, storage.CatalogImage{
+			Repo:         "b",
+			Tag:          "1.0",
+			Digest:       digest,
+			ConfigDigest: godigest.FromBytes(config),
+			Size:         images[2].Size,
+			LastUpdated:  created,
+		})
+		So(images[2].Size, ShouldBeGreaterThan, len(config)+len(layer))
+
+		images, err = imgStore.CatalogImages("b")
+		So(err, ShouldBeNil)
+		So(len(images), ShouldEqual, 1)
+
+		images, err = imgStore.CatalogImages("unknown")
+		So(err, ShouldBeNil)
+		So(images, ShouldBeEmpty)
+
+		Convey("Pushes and deletes update the catalog", func() {
+			pushImage("b", "2.0")
+			So(imgStore.DeleteImageManifest("a/c", "1.0"), ShouldBeNil)
+
+			images, err := imgStore.CatalogImages("")
+			So(err, ShouldBeNil)
+			So(len(images), ShouldEqual, 3)
+			So(images[0].Repo, ShouldEqual, "a/c")
+			So(images[0].Tag, ShouldEqual, "2.0")
+			So(images[2].Tag, ShouldEqual, "2.0")
+		})
+
+		Convey("The catalog doesn't read index.json again", func() {
+			So(ioutil.WriteFile(path.Join(dir, "b", "index.json"), []byte("{}"), 0600), ShouldBeNil)
+
+			images, err := imgStore.CatalogImages("b")
+			So(err, ShouldBeNil)
+			So(len(images), ShouldEqual, 1)
+
+			// but it's loaded from disk when the store is opened
+			images, err = storage.NewImageStore(dir, false, false, log.NewLogger("debug", "")).CatalogImages("")
+			So(err, ShouldBeNil)
+			So(len(images), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestMetaDB(t *testing.T) {
+	Convey("Repository metadata is recorded on pushes", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+
+		content := []byte("this is a blob")
+		digest := godigest.FromBytes(content)
+		_, _, err = imgStore.FullBlobUpload("test", bytes.NewReader(content), digest.String())
+		So(err, ShouldBeNil)
+
+		pushManifest := func(reference string, subject *ispec.Descriptor) godigest.Digest {
+			manifest := struct {
+				ispec.Manifest
+				Subject      *ispec.Descriptor `json:"subject,omitempty"`
+				ArtifactType string            `json:"artifactType,omitempty"`
+			}{
+				Manifest: ispec.Manifest{
+					Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: digest,
+						Size: int64(len(content))},
+					Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: digest,
+						Size: int64(len(content))}},
+					Annotations: map[string]string{"key": reference},
+				},
+				Subject: subject,
+			}
+			manifest.SchemaVersion = 2
+
+			if subject != nil {
+				manifest.ArtifactType = storage.NotationSignatureArtifactType
+			}
+
+			mb, err := json.Marshal(manifest)
+			So(err, ShouldBeNil)
+
+			if reference == "" {
+				reference = godigest.FromBytes(mb).String()
+			}
+
+			_, err = imgStore.PutImageManifest("test", reference, ispec.MediaTypeImageManifest, mb)
+			So(err, ShouldBeNil)
+
+			return godigest.FromBytes(mb)
+		}
+
+		manifestDigest := pushManifest("1.0", nil)
+
+		repoMeta, err := imgStore.GetRepoMeta("test")
+		So(err, ShouldBeNil)
+		So(repoMeta.Name, ShouldEqual, "test")
+		So(repoMeta.Tags, ShouldResemble, map[string]godigest.Digest{"1.0": manifestDigest})
+		So(repoMeta.Manifests[manifestDigest].ConfigDigest, ShouldEqual, digest)
+		So(repoMeta.Manifests[manifestDigest].Annotations["key"], ShouldEqual, "1.0")
+		So(repoMeta.IsSigned(manifestDigest), ShouldBeFalse)
+
+		generation := imgStore.Generation()
+
+		signature := pushManifest("", &ispec.Descriptor{MediaType: ispec.MediaTypeImageManifest,
+			Digest: manifestDigest})
+		So(imgStore.Generation(), ShouldBeGreaterThan, generation)
+
+		// pulls don't change the repositories
+		generation = imgStore.Generation()
+		imgStore.PullStats().Record("test", "1.0", "")
+		So(imgStore.Generation(), ShouldEqual, generation)
+
+		repoMeta, err = imgStore.GetRepoMeta("test")
+		So(err, ShouldBeNil)
+		So(len(repoMeta.Manifests), ShouldEqual, 2)
+		So(repoMeta.IsSigned(manifestDigest), ShouldBeTrue)
+		So(repoMeta.Referrers(manifestDigest, "")[0].Digest, ShouldEqual, signature)
+		So(repoMeta.Statistics.Count, ShouldEqual, 1)
+
+		Convey("Scan summaries are kept until the manifest is deleted", func() {
+			summary := &storage.ScanSummary{Scanned: time.Now().UTC().Round(time.Second), CVEs: []string{"CVE-1"},
+				Severities: map[string]int{"HIGH": 1}}
+			So(imgStore.SetScanSummary("test", manifestDigest, summary), ShouldBeNil)
+			So(imgStore.SetScanSummary("test", digest, summary), ShouldEqual, errors.ErrManifestNotFound)
+			So(imgStore.SetScanSummary("unknown", digest, summary), ShouldEqual, errors.ErrRepoNotFound)
+
+			pushManifest("2.0", nil)
+
+			repoMeta, err := imgStore.GetRepoMeta("test")
+			So(err, ShouldBeNil)
+			So(repoMeta.Manifests[manifestDigest].Scan, ShouldResemble, summary)
+
+			// the database outlives the store
+			repoMeta, err = storage.NewImageStore(dir, false, false, log.NewLogger("debug", "")).GetRepoMeta("test")
+			So(err, ShouldBeNil)
+			So(repoMeta.Manifests[manifestDigest].Scan, ShouldResemble, summary)
+
+			So(imgStore.DeleteImageManifest("test", "1.0"), ShouldBeNil)
+
+			repoMeta, err = imgStore.GetRepoMeta("test")
+			So(err, ShouldBeNil)
+			So(repoMeta.Tags, ShouldNotContainKey, "1.0")
+			So(repoMeta.Manifests, ShouldNotContainKey, manifestDigest)
+		})
+
+		Convey("Tag changes are recorded", func() {
+			events, err := imgStore.TagHistory("test", "1.0")
+			So(err, ShouldBeNil)
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Digest, ShouldEqual, manifestDigest)
+			So(events[0].Previous, ShouldBeEmpty)
+			So(events[0].Time, ShouldNotBeZeroValue)
+
+			// re-pointing the tag to another manifest
+			moved := pushManifest("2.0", nil)
+			content, _, mediaType, err := imgStore.GetImageManifest("test", "2.0")
+			So(err, ShouldBeNil)
+			_, err = imgStore.PutImageManifest("test", "1.0", mediaType, content)
+			So(err, ShouldBeNil)
+
+			So(imgStore.DeleteImageManifest("test", "1.0"), ShouldBeNil)
+
+			events, err = imgStore.TagHistory("test", "1.0")
+			So(err, ShouldBeNil)
+			So(len(events), ShouldEqual, 3)
+			So(events[1].Digest, ShouldEqual, moved)
+			So(events[1].Previous, ShouldEqual, manifestDigest)
+			So(events[2].Digest, ShouldBeEmpty)
+			So(events[2].Previous, ShouldEqual, moved)
+
+			// the history of deleted repositories is kept
+			So(imgStore.DeleteImageManifest("test", "2.0"), ShouldBeNil)
+			So(os.RemoveAll(path.Join(dir, "test")), ShouldBeNil)
+
+			events, err = imgStore.TagHistory("test", "2.0")
+			So(err, ShouldBeNil)
+			So(len(events), ShouldEqual, 2)
+			So(events[1].Digest, ShouldBeEmpty)
+
+			events, err = imgStore.TagHistory("test", "3.0")
+			So(err, ShouldBeNil)
+			So(events, ShouldBeEmpty)
+
+			_, err = imgStore.TagHistory("unknown", "1.0")
+			So(err, ShouldEqual, errors.ErrRepoNotFound)
+		})
+
+		Convey("Images are found by digest prefix", func() {
+			images, err := imgStore.SearchDigest(manifestDigest.Encoded()[:8])
+			So(err, ShouldBeNil)
+			So(images, ShouldResemble, map[string][]string{"test": {"1.0"}})
+
+			pushManifest("v:2.0", nil)
+
+			// the layer is shared by both images
+			images, err = imgStore.SearchDigest(digest.String()[:12])
+			So(err, ShouldBeNil)
+			So(images, ShouldResemble, map[string][]string{"test": {"1.0", "v:2.0"}})
+
+			images, err = imgStore.SearchDigest("sha")
+			So(err, ShouldBeNil)
+			So(images, ShouldResemble, map[string][]string{"test": {"1.0", "v:2.0"}})
+
+			So(imgStore.DeleteImageManifest("test", "1.0"), ShouldBeNil)
+
+			images, err = imgStore.SearchDigest(manifestDigest.Encoded())
+			So(err, ShouldBeNil)
+			So(images, ShouldBeEmpty)
+
+			images, err = imgStore.SearchDigest(digest.Encoded())
+			So(err, ShouldBeNil)
+			So(images, ShouldResemble, map[string][]string{"test": {"v:2.0"}})
+
+			images, err = imgStore.SearchDigest("zzzz")
+			So(err, ShouldBeNil)
+			So(images, ShouldBeEmpty)
+		})
+
+		Convey("Images are found by git version", func() {
+			pushBuild := func(repo, tag, gitVersion string) {
+				_, _, err := imgStore.FullBlobUpload(repo, bytes.NewReader(content), digest.String())
+				So(err, ShouldBeNil)
+
+				manifest := ispec.Manifest{
+					Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: digest,
+						Size: int64(len(content))},
+					Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: digest,
+						Size: int64(len(content))}},
+					Annotations: map[string]string{
+						storage.StackerGitVersionAnnotation: gitVersion,
+						storage.StackerYamlAnnotation:       "build:\n  from: " + tag,
+					},
+				}
+				manifest.SchemaVersion = 2
+
+				mb, err := json.Marshal(manifest)
+				So(err, ShouldBeNil)
+
+				_, err = imgStore.PutImageManifest(repo, tag, ispec.MediaTypeImageManifest, mb)
+				So(err, ShouldBeNil)
+			}
+
+			pushBuild("test", "2.0", "v1.0-1-gabcdef0")
+			pushBuild("other", "1.0", "v1.0-1-gabcdef0")
+			pushBuild("other", "2.0", "v2.0")
+
+			images, err := imgStore.SearchGitVersion("v1.0-1-gabcdef0")
+			So(err, ShouldBeNil)
+			So(images, ShouldResemble, map[string][]string{"other": {"1.0"}, "test": {"2.0"}})
+
+			// only full versions match
+			images, err = imgStore.SearchGitVersion("v1.0")
+			So(err, ShouldBeNil)
+			So(images, ShouldBeEmpty)
+
+			catalog, err := imgStore.CatalogImages("other")
+			So(err, ShouldBeNil)
+			So(catalog[1].Build, ShouldResemble, &storage.BuildInfo{StackerYaml: "build:\n  from: 2.0",
+				GitVersion: "v2.0"})
+
+			// images without stacker annotations have no build info
+			catalog, err = imgStore.CatalogImages("test")
+			So(err, ShouldBeNil)
+			So(catalog[0].Build, ShouldBeNil)
+
+			pushBuild("other", "1.0", "v3.0")
+			So(imgStore.DeleteImageManifest("test", "2.0"), ShouldBeNil)
+
+			images, err = imgStore.SearchGitVersion("v1.0-1-gabcdef0")
+			So(err, ShouldBeNil)
+			So(images, ShouldBeEmpty)
+
+			images, err = imgStore.SearchGitVersion("v3.0")
+			So(err, ShouldBeNil)
+			So(images, ShouldResemble, map[string][]string{"other": {"1.0"}})
+		})
+
+		Convey("Manifests are resolved by short digest", func() {
+			resolved, err := imgStore.ResolveDigest("test", manifestDigest.Encoded()[:storage.MinDigestPrefixLen])
+			So(err, ShouldBeNil)
+			So(resolved, ShouldEqual, manifestDigest)
+
+			resolved, err = imgStore.ResolveDigest("test", manifestDigest.String()[:20])
+			So(err, ShouldBeNil)
+			So(resolved, ShouldEqual, manifestDigest)
+
+			// only manifests are resolved, not their layers
+			_, err = imgStore.ResolveDigest("test", digest.Encoded()[:12])
+			So(err, ShouldEqual, errors.ErrManifestNotFound)
+
+			_, err = imgStore.ResolveDigest("test", manifestDigest.Encoded()[:storage.MinDigestPrefixLen-1])
+			So(err, ShouldEqual, errors.ErrManifestNotFound)
+
+			_, err = imgStore.ResolveDigest("unknown", manifestDigest.Encoded()[:12])
+			So(err, ShouldEqual, errors.ErrRepoNotFound)
+
+			So(storage.IsDigestPrefix("sha256:"+manifestDigest.Encoded()[:8]), ShouldBeTrue)
+			So(storage.IsDigestPrefix("md5:0123456789"), ShouldBeFalse)
+			So(storage.IsDigestPrefix("1.0"), ShouldBeFalse)
+			So(storage.IsDigestPrefix("latest-build"), ShouldBeFalse)
+
+			rm := storage.RepoMeta{Manifests: map[godigest.Digest]storage.ManifestMeta{
+				"sha256:0123456789aa": {}, "sha256:0123456789bb": {}, "sha256:fedcba9876": {},
+			}}
+			So(rm.MatchDigests("01234567"), ShouldResemble,
+				[]godigest.Digest{"sha256:0123456789aa", "sha256:0123456789bb"})
+			So(rm.MatchDigests("sha256:0123456789b"), ShouldResemble, []godigest.Digest{"sha256:0123456789bb"})
+			So(rm.MatchDigests("abcdef0"), ShouldBeEmpty)
+		})
+
+		Convey("Broken repositories are skipped", func() {
+			So(imgStore.InitRepo("broken"), ShouldBeNil)
+			So(ioutil.WriteFile(path.Join(dir, "broken", "index.json"), []byte("{"), 0600), ShouldBeNil)
+
+			_, err := imgStore.GetRepoMeta("broken")
+			So(err, ShouldNotBeNil)
+
+			repos, err := imgStore.GetReposMeta()
+			So(err, ShouldBeNil)
+			So(len(repos), ShouldEqual, 1)
+			So(repos[0].Name, ShouldEqual, "test")
+
+			_, err = imgStore.GetRepoMeta("unknown")
+			So(err, ShouldEqual, errors.ErrRepoNotFound)
+		})
+	})
+}
+
+func BenchmarkGetBlob(b *testing.B) {
+	dir, err := ioutil.TempDir("", "oci-repo-test")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("error", ""))
+
+	blob := make([]byte, 64<<20)
+	if _, err := rand.Read(blob); err != nil {
+		b.Fatal(err)
+	}
+
+	digest := godigest.FromBytes(blob).String()
+
+	if _, _, err := imgStore.FullBlobUpload("test", bytes.NewReader(blob), digest); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, direct := range []bool{false, true} {
+		imgStore.SetDirectIO(direct)
+
+		b.Run(fmt.Sprintf("directIO=%t", direct), func(b *testing.B) {
+			b.SetBytes(int64(len(blob)))
+
+			for i := 0; i < b.N; i++ {
+				reader, _, err := imgStore.GetBlob("test", digest, ispec.MediaTypeImageLayer)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+					b.Fatal(err)
+				}
+
+				reader.(io.Closer).Close()
+			}
+		})
+	}
+}
+
+func BenchmarkSearchDigest(b *testing.B) {
+	dir, err := ioutil.TempDir("", "oci-repo-test")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("error", ""))
+
+	var last godigest.Digest
+
+	for i := 0; i < 500; i++ {
+		repo := fmt.Sprintf("repo%d", i)
+		content := []byte(repo)
+		digest := godigest.FromBytes(content)
+
+		if _, _, err := imgStore.FullBlobUpload(repo, bytes.NewReader(content), digest.String()); err != nil {
+			b.Fatal(err)
+		}
+
+		manifest := ispec.Manifest{
+			Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: digest, Size: int64(len(content))},
+			Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: digest, Size: int64(len(content))}},
+		}
+		manifest.SchemaVersion = 2
+
+		mb, err := json.Marshal(manifest)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if _, err := imgStore.PutImageManifest(repo, "1.0", ispec.MediaTypeImageManifest, mb); err != nil {
+			b.Fatal(err)
+		}
+
+		last = digest
+	}
+
+	// the first search checks all the repositories
+	if _, err := imgStore.SearchDigest(""); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		images, err := imgStore.SearchDigest(last.Encoded()[:8])
+		if err != nil || len(images) != 1 {
+			b.Fatal(err, images)
+		}
+	}
+}
+
+// pushFsckImage pushes an image made of a shared layer and its own config, and returns its manifest digest.
+func pushFsckImage(imgStore *storage.ImageStore, repo, tag string, layer []byte) godigest.Digest {
+	So(imgStore.InitRepo(repo), ShouldBeNil)
+
+	_, _, err := imgStore.FullBlobUpload(repo, bytes.NewReader(layer), godigest.FromBytes(layer).String())
+	So(err, ShouldBeNil)
+
+	config := []byte(`{"architecture":"amd64","os":"linux","tag":"` + repo + ":" + tag + `"}`)
+	_, _, err = imgStore.FullBlobUpload(repo, bytes.NewReader(config), godigest.FromBytes(config).String())
+	So(err, ShouldBeNil)
+
+	manifest := ispec.Manifest{
+		Config: ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    godigest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+		Layers: []ispec.Descriptor{{
+			MediaType: ispec.MediaTypeImageLayer,
+			Digest:    godigest.FromBytes(layer),
+			Size:      int64(len(layer)),
+		}},
+	}
+	manifest.SchemaVersion = 2
+
+	content, err := json.Marshal(manifest)
+	So(err, ShouldBeNil)
+
+	digest, err := imgStore.PutImageManifest(repo, tag, ispec.MediaTypeImageManifest, content)
+	So(err, ShouldBeNil)
+
+	return godigest.Digest(digest)
+}
+
+func TestFsck(t *testing.T) {
+	Convey("Storage inconsistencies are reported and fixed", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		logger := log.Logger{Logger: zerolog.New(ioutil.Discard)}
+		imgStore := storage.NewImageStore(dir, false, false, logger)
+
+		layer := []byte("this is a layer")
+		layerDigest := godigest.FromBytes(layer)
+
+		pushFsckImage(imgStore, "a", "1.0", layer)
+		pushFsckImage(imgStore, "b", "1.0", layer)
+
+		// the manifest of a:2.0 is lost, which leaves its config unreferenced
+		lost := pushFsckImage(imgStore, "a", "2.0", layer)
+		So(os.Remove(imgStore.BlobPath("a", lost)), ShouldBeNil)
+
+		other := []byte("this is an orphan blob")
+		_, _, err = imgStore.FullBlobUpload("a", bytes.NewReader(other), godigest.FromBytes(other).String())
+		So(err, ShouldBeNil)
+
+		_, err = imgStore.NewBlobUpload("a")
+		So(err, ShouldBeNil)
+
+		pushFsckImage(imgStore, "c", "1.0", []byte("this is a lost layer"))
+		So(os.Remove(imgStore.BlobPath("c", godigest.FromBytes([]byte("this is a lost layer")))), ShouldBeNil)
+
+		// the layer of a was deduped, but its copy in b isn't linked anymore
+		cache := storage.NewCache(dir, "cache", logger)
+		So(cache.PutBlob(layerDigest.String(), imgStore.BlobPath("a", layerDigest)), ShouldBeNil)
+		So(cache.PutBlob(godigest.FromBytes(other).String(), path.Join(dir, "d/blobs/sha256",
+			godigest.FromBytes(other).Encoded())), ShouldBeNil)
+		So(cache.Close(), ShouldBeNil)
+
+		report, err := storage.Fsck(dir, false, false, logger)
+		So(err, ShouldBeNil)
+		So(report.Repos, ShouldEqual, 3)
+		So(report.Summary, ShouldResemble, map[string]int{
+			storage.FsckDanglingUpload:    1,
+			storage.FsckOrphanBlob:        2,
+			storage.FsckMissingManifest:   1,
+			storage.FsckMissingBlob:       1,
+			storage.FsckStaleDedupeRecord: 1,
+			storage.FsckUnlinkedCopy:      1,
+		})
+		So(report.Fixed, ShouldEqual, 0)
+		So(report.Unfixed(), ShouldEqual, 7)
+
+		report, err = storage.Fsck(dir, true, false, logger)
+		So(err, ShouldBeNil)
+		So(report.Fixed, ShouldEqual, 6)
+
+		for _, issue := range report.Issues {
+			So(issue.Fixed, ShouldEqual, issue.Kind != storage.FsckMissingBlob)
+		}
+
+		tags, err := imgStore.GetImageTags("a")
+		So(err, ShouldBeNil)
+		So(tags, ShouldResemble, []string{"1.0"})
+
+		fileA, err := os.Stat(imgStore.BlobPath("a", layerDigest))
+		So(err, ShouldBeNil)
+		fileB, err := os.Stat(imgStore.BlobPath("b", layerDigest))
+		So(err, ShouldBeNil)
+		So(os.SameFile(fileA, fileB), ShouldBeTrue)
+
+		report, err = storage.Fsck(dir, false, false, logger)
+		So(err, ShouldBeNil)
+		So(report.Summary, ShouldResemble, map[string]int{storage.FsckMissingBlob: 1})
+		So(report.Issues[0].Path, ShouldEqual, path.Join("c/blobs/sha256",
+			godigest.FromBytes([]byte("this is a lost layer")).Encoded()))
+
+		_, err = storage.Fsck(path.Join(dir, "missing"), false, false, logger)
+		So(err, ShouldEqual, errors.ErrRepoNotFound)
+	})
+}
+
+func TestPatchManifestAnnotations(t *testing.T) {
+	Convey("Annotations of a tagged manifest are patched", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+		So(imgStore.InitRepo("test"), ShouldBeNil)
+
+		content := []byte("this is a blob")
+		digest := godigest.FromBytes(content)
+		_, _, err = imgStore.FullBlobUpload("test", bytes.NewReader(content), digest.String())
+		So(err, ShouldBeNil)
+
+		manifest := ispec.Manifest{
+			Config: ispec.Descriptor{
+				MediaType: ispec.MediaTypeImageConfig,
+				Digest:    digest,
+				Size:      int64(len(content)),
+			},
+			Layers: []ispec.Descriptor{
+				{
+					MediaType: ispec.MediaTypeImageLayer,
+					Digest:    digest,
+					Size:      int64(len(content)),
+				},
+			},
+			Annotations: map[string]string{ispec.AnnotationVendor: "zot"},
+		}
+		manifest.SchemaVersion = 2
+		mb, err := json.Marshal(manifest)
+		So(err, ShouldBeNil)
+
+		manifestDigest, err := imgStore.PutImageManifest("test", "1.0", ispec.MediaTypeImageManifest, mb)
+		So(err, ShouldBeNil)
+		_, err = imgStore.PutImageManifest("test", "latest", ispec.MediaTypeImageManifest, mb)
+		So(err, ShouldBeNil)
+
+		ticket := "https://example.com/tickets/42"
+		patch := map[string]*string{"com.example.ticket": &ticket, ispec.AnnotationVendor: nil}
+
+		patchedDigest, err := imgStore.PatchManifestAnnotations("test", "1.0", godigest.Digest(manifestDigest), patch)
+		So(err, ShouldBeNil)
+		So(patchedDigest.String(), ShouldNotEqual, manifestDigest)
+
+		buf, digestStr, _, err := imgStore.GetImageManifest("test", "1.0")
+		So(err, ShouldBeNil)
+		So(digestStr, ShouldEqual, patchedDigest.String())
+
+		var patched ispec.Manifest
+		So(json.Unmarshal(buf, &patched), ShouldBeNil)
+		So(patched.Annotations, ShouldResemble, map[string]string{"com.example.ticket": ticket})
+		So(patched.Layers, ShouldResemble, manifest.Layers)
+
+		// the other tags of the manifest don't move
+		_, digestStr, _, err = imgStore.GetImageManifest("test", "latest")
+		So(err, ShouldBeNil)
+		So(digestStr, ShouldEqual, manifestDigest)
+
+		// patching again with the same annotations changes nothing
+		digest2, err := imgStore.PatchManifestAnnotations("test", "1.0", "", patch)
+		So(err, ShouldBeNil)
+		So(digest2, ShouldEqual, patchedDigest)
+
+		_, err = imgStore.PatchManifestAnnotations("test", "1.0", godigest.Digest(manifestDigest), patch)
+		So(err, ShouldEqual, errors.ErrManifestChanged)
+
+		_, err = imgStore.PatchManifestAnnotations("test", "1.0", "", map[string]*string{"": &ticket})
+		So(err, ShouldEqual, errors.ErrBadManifest)
+
+		_, err = imgStore.PatchManifestAnnotations("test", "2.0", "", patch)
+		So(err, ShouldEqual, errors.ErrManifestNotFound)
+
+		_, err = imgStore.PatchManifestAnnotations("unknown", "1.0", "", patch)
+		So(err, ShouldEqual, errors.ErrRepoNotFound)
+	})
+}
+
+func TestTagExpiry(t *testing.T) {
+	Convey("Tags with a TTL are removed once expired", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+		So(imgStore.InitRepo("test"), ShouldBeNil)
+
+		content := []byte("this is a blob")
+		digest := godigest.FromBytes(content)
+		_, _, err = imgStore.FullBlobUpload("test", bytes.NewReader(content), digest.String())
+		So(err, ShouldBeNil)
+
+		putManifest := func(tag string, annotations map[string]string) error {
+			manifest := ispec.Manifest{
+				Config: ispec.Descriptor{
+					MediaType: ispec.MediaTypeImageConfig,
+					Digest:    digest,
+					Size:      int64(len(content)),
+				},
+				Layers: []ispec.Descriptor{
+					{
+						MediaType: ispec.MediaTypeImageLayer,
+						Digest:    digest,
+						Size:      int64(len(content)),
+					},
+				},
+				Annotations: annotations,
+			}
+			manifest.SchemaVersion = 2
+			mb, err := json.Marshal(manifest)
+			So(err, ShouldBeNil)
+
+			_, err = imgStore.PutImageManifest("test", tag, ispec.MediaTypeImageManifest, mb)
+
+			return err
+		}
+
+		So(putManifest("pr-1", map[string]string{storage.AnnotationTagTTL: "1h"}), ShouldBeNil)
+		So(putManifest("pr-2", nil), ShouldBeNil)
+		So(putManifest("1.0", nil), ShouldBeNil)
+		So(putManifest("pr-3", map[string]string{storage.AnnotationTagTTL: "one hour"}), ShouldEqual,
+			errors.ErrBadManifest)
+
+		expires, err := imgStore.SetTagExpiry("test", "pr-2", 2*time.Hour)
+		So(err, ShouldBeNil)
+
+		expiries, err := imgStore.GetTagExpiries("test")
+		So(err, ShouldBeNil)
+		So(expiries, ShouldHaveLength, 2)
+		So(expiries["pr-1"], ShouldHappenWithin, time.Minute, time.Now().Add(time.Hour))
+		So(expiries["pr-2"], ShouldResemble, expires)
+
+		removed, err := imgStore.RemoveExpiredTags(time.Now())
+		So(err, ShouldBeNil)
+		So(removed, ShouldEqual, 0)
+
+		removed, err = imgStore.RemoveExpiredTags(time.Now().Add(90 * time.Minute))
+		So(err, ShouldBeNil)
+		So(removed, ShouldEqual, 1)
+
+		tags, err := imgStore.GetImageTags("test")
+		So(err, ShouldBeNil)
+		So(tags, ShouldResemble, []string{"pr-2", "1.0"})
+
+		// the blobs are left to GC
+		_, _, err = imgStore.CheckBlob("test", digest.String())
+		So(err, ShouldBeNil)
+
+		_, err = imgStore.SetTagExpiry("test", "pr-2", 0)
+		So(err, ShouldBeNil)
+
+		removed, err = imgStore.RemoveExpiredTags(time.Now().Add(3 * time.Hour))
+		So(err, ShouldBeNil)
+		So(removed, ShouldEqual, 0)
+
+		_, err = imgStore.SetTagExpiry("test", "pr-1", time.Hour)
+		So(err, ShouldEqual, errors.ErrManifestNotFound)
+
+		_, err = imgStore.GetTagExpiries("unknown")
+		So(err, ShouldEqual, errors.ErrRepoNotFound)
+	})
+
+	Convey("Immutable tags don't expire", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+		imgStore.SetImmutableTagCheck(func(repo, tag string) bool {
+			return strings.HasPrefix(tag, "v")
+		})
+
+		content := []byte("this is a blob")
+		digest := godigest.FromBytes(content)
+		_, _, err = imgStore.FullBlobUpload("test", bytes.NewReader(content), digest.String())
+		So(err, ShouldBeNil)
+
+		for _, tag := range []string{"v1", "pr-1"} {
+			manifest := ispec.Manifest{
+				Config: ispec.Descriptor{
+					MediaType: ispec.MediaTypeImageConfig,
+					Digest:    digest,
+					Size:      int64(len(content)),
+				},
+				Annotations: map[string]string{storage.AnnotationTagTTL: "1h", "tag": tag},
+			}
+			manifest.SchemaVersion = 2
+			mb, err := json.Marshal(manifest)
+			So(err, ShouldBeNil)
+
+			_, err = imgStore.PutImageManifest("test", tag, ispec.MediaTypeImageManifest, mb)
+			So(err, ShouldBeNil)
+		}
+
+		_, err = imgStore.SetTagExpiry("test", "v1", time.Hour)
+		So(err, ShouldEqual, errors.ErrImmutableTag)
+
+		removed, err := imgStore.RemoveExpiredTags(time.Now().Add(2 * time.Hour))
+		So(err, ShouldBeNil)
+		So(removed, ShouldEqual, 1)
+
+		tags, err := imgStore.GetImageTags("test")
+		So(err, ShouldBeNil)
+		So(tags, ShouldResemble, []string{"v1"})
+
+		// their TTL can still be cleared
+		_, err = imgStore.SetTagExpiry("test", "v1", 0)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Expired tags pulled within the retention window are kept", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.NewLogger("debug", ""))
+		imgStore.SetPullRetention(24 * time.Hour)
+
+		content := []byte("this is a blob")
+		digest := godigest.FromBytes(content)
+		_, _, err = imgStore.FullBlobUpload("test", bytes.NewReader(content), digest.String())
+		So(err, ShouldBeNil)
+
+		digests := make(map[string]string)
+
+		for _, tag := range []string{"by-tag", "by-digest", "unused"} {
+			manifest := ispec.Manifest{
+				Config: ispec.Descriptor{
+					MediaType: ispec.MediaTypeImageConfig,
+					Digest:    digest,
+					Size:      int64(len(content)),
+				},
+				Annotations: map[string]string{storage.AnnotationTagTTL: "1h", "tag": tag},
+			}
+			manifest.SchemaVersion = 2
+			mb, err := json.Marshal(manifest)
+			So(err, ShouldBeNil)
+
+			digests[tag], err = imgStore.PutImageManifest("test", tag, ispec.MediaTypeImageManifest, mb)
+			So(err, ShouldBeNil)
+		}
+
+		imgStore.PullStats().Record("test", "by-tag", "")
+		imgStore.PullStats().Record("test", digests["by-digest"], "")
+
+		removed, err := imgStore.RemoveExpiredTags(time.Now().Add(2 * time.Hour))
+		So(err, ShouldBeNil)
+		So(removed, ShouldEqual, 1)
+
+		tags, err := imgStore.GetImageTags("test")
+		So(err, ShouldBeNil)
+		So(tags, ShouldResemble, []string{"by-tag", "by-digest"})
+
+		// once the window elapsed since their last pull
+		removed, err = imgStore.RemoveExpiredTags(time.Now().Add(25 * time.Hour))
+		So(err, ShouldBeNil)
+		So(removed, ShouldEqual, 2)
+	})
+}
+
+func TestBackup(t *testing.T) {
+	Convey("Snapshots of an image store are backed up incrementally and restored", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		logger := log.Logger{Logger: zerolog.New(ioutil.Discard)}
+		rootDir := path.Join(dir, "root")
+		backupDir := path.Join(dir, "backup")
+		imgStore := storage.NewImageStore(rootDir, false, true, logger)
+
+		layer := []byte("this is a shared layer")
+		pushFsckImage(imgStore, "a", "1.0", layer)
+		pushFsckImage(imgStore, "b", "1.0", layer)
+
+		report, err := imgStore.Backup(backupDir, false)
+		So(err, ShouldBeNil)
+		So(report.Repos, ShouldEqual, 2)
+		So(report.Blobs, ShouldEqual, 5)
+		So(report.CopiedBlobs, ShouldEqual, 5)
+		So(report.SkippedBlobs, ShouldEqual, 0)
+
+		first := report.Snapshot
+
+		pushFsckImage(imgStore, "a", "2.0", layer)
+
+		report, err = imgStore.Backup(backupDir, true)
+		So(err, ShouldBeNil)
+		So(report.Blobs, ShouldEqual, 7)
+		So(report.CopiedBlobs, ShouldEqual, 2)
+		So(report.SkippedBlobs, ShouldEqual, 5)
+
+		snapshots, err := storage.Snapshots(backupDir)
+		So(err, ShouldBeNil)
+		So(snapshots, ShouldResemble, []string{first, report.Snapshot})
+
+		Convey("The latest snapshot is restored with dedupe links", func() {
+			restoreDir := path.Join(dir, "restore")
+
+			restored, err := storage.Restore(backupDir, "", restoreDir, true, logger)
+			So(err, ShouldBeNil)
+			So(restored.Snapshot, ShouldEqual, report.Snapshot)
+			So(restored.Repos, ShouldEqual, 2)
+			So(restored.LinkedBlobs, ShouldEqual, 1)
+
+			restoredStore := storage.NewImageStore(restoreDir, false, true, logger)
+
+			tags, err := restoredStore.GetImageTags("a")
+			So(err, ShouldBeNil)
+			So(tags, ShouldResemble, []string{"1.0", "2.0"})
+
+			layerDigest := godigest.FromBytes(layer)
+			fa, err := os.Stat(restoredStore.BlobPath("a", layerDigest))
+			So(err, ShouldBeNil)
+			fb, err := os.Stat(restoredStore.BlobPath("b", layerDigest))
+			So(err, ShouldBeNil)
+			So(os.SameFile(fa, fb), ShouldBeTrue)
+
+			_, _, _, err = restoredStore.GetImageManifest("b", "1.0")
+			So(err, ShouldBeNil)
+
+			_, err = storage.Restore(backupDir, "", restoreDir, true, logger)
+			So(err, ShouldEqual, errors.ErrRestoreTargetNotEmpty)
+		})
+
+		Convey("An older snapshot is restored", func() {
+			restoreDir := path.Join(dir, "older")
+
+			_, err := storage.Restore(backupDir, first, restoreDir, false, logger)
+			So(err, ShouldBeNil)
+
+			tags, err := storage.NewImageStore(restoreDir, false, false, logger).GetImageTags("a")
+			So(err, ShouldBeNil)
+			So(tags, ShouldResemble, []string{"1.0"})
+		})
+
+		Convey("Unknown snapshots aren't restored", func() {
+			_, err := storage.Restore(backupDir, "20200101T000000.000Z", path.Join(dir, "unknown"), true, logger)
+			So(err, ShouldEqual, errors.ErrSnapshotNotFound)
+
+			_, err = storage.Restore(path.Join(dir, "none"), "", path.Join(dir, "unknown"), true, logger)
+			So(err, ShouldEqual, errors.ErrSnapshotNotFound)
+		})
+
+		Convey("Backups fail on missing blobs", func() {
+			So(os.Remove(imgStore.BlobPath("b", godigest.FromBytes(layer))), ShouldBeNil)
+
+			_, err := imgStore.Backup(backupDir, true)
+			So(err, ShouldEqual, errors.ErrBlobNotFound)
+		})
+	})
+}
+
+func TestReflinkDedupe(t *testing.T) {
+	Convey("Blobs deduped with reflinks are files of their own", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, true, log.NewLogger("debug", ""))
+		imgStore.SetReflink(true)
+
+		// blobs are copied on filesystems without reflinks
+		_ = storage.ValidateReflink(dir)
+
+		shared := []byte("this is a shared blob")
+		digest := godigest.FromBytes(shared)
+
+		for _, repo := range []string{"a", "b"} {
+			So(imgStore.InitRepo(repo), ShouldBeNil)
+
+			_, _, err = imgStore.FullBlobUpload(repo, bytes.NewReader(shared), digest.String())
+			So(err, ShouldBeNil)
+		}
+
+		a, err := os.Stat(imgStore.BlobPath("a", digest))
+		So(err, ShouldBeNil)
+		b, err := os.Stat(imgStore.BlobPath("b", digest))
+		So(err, ShouldBeNil)
+		So(os.SameFile(a, b), ShouldBeFalse)
+
+		So(os.Chmod(imgStore.BlobPath("a", digest), 0400), ShouldBeNil)
+
+		b, err = os.Stat(imgStore.BlobPath("b", digest))
+		So(err, ShouldBeNil)
+		So(b.Mode().Perm(), ShouldNotEqual, 0400)
+
+		for _, repo := range []string{"a", "b"} {
+			blob, _, err := imgStore.GetBlob(repo, digest.String(), "")
+			So(err, ShouldBeNil)
+
+			content, err := ioutil.ReadAll(blob)
+			So(err, ShouldBeNil)
+			So(content, ShouldResemble, shared)
+		}
+
+		// pushing the blob again keeps its file
+		_, _, err = imgStore.FullBlobUpload("b", bytes.NewReader(shared), digest.String())
+		So(err, ShouldBeNil)
+
+		again, err := os.Stat(imgStore.BlobPath("b", digest))
+		So(err, ShouldBeNil)
+		So(os.SameFile(b, again), ShouldBeTrue)
+
+		// reflinked blobs aren't hard linked
+		result, err := imgStore.Rededupe()
+		So(err, ShouldBeNil)
+		So(result.Linked, ShouldEqual, 0)
+
+		report, err := imgStore.DedupeReport(storage.DefaultDedupeReportTop)
+		So(err, ShouldBeNil)
+		So(report.Reflink, ShouldBeTrue)
+	})
+}
+
+func TestDockerManifests(t *testing.T) {
+	Convey("Docker manifests, manifest lists and foreign layers are stored", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		logger := log.Logger{Logger: zerolog.New(ioutil.Discard)}
+		imgStore := storage.NewImageStore(dir, true, false, logger)
+		So(imgStore.InitRepo("windows"), ShouldBeNil)
+
+		layer := []byte("this is a windows layer")
+		_, _, err = imgStore.FullBlobUpload("windows", bytes.NewReader(layer), godigest.FromBytes(layer).String())
+		So(err, ShouldBeNil)
+
+		config := []byte(`{"architecture":"amd64","os":"windows"}`)
+		_, _, err = imgStore.FullBlobUpload("windows", bytes.NewReader(config), godigest.FromBytes(config).String())
+		So(err, ShouldBeNil)
+
+		foreign := godigest.FromString("the base layer of windows")
+
+		manifest, err := json.Marshal(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     storage.DockerManifestMediaType,
+			"config": ispec.Descriptor{MediaType: storage.DockerConfigMediaType, Digest: godigest.FromBytes(config),
+				Size: int64(len(config))},
+			"layers": []ispec.Descriptor{
+				{MediaType: storage.DockerForeignLayerMediaType, Digest: foreign, Size: 1 << 30,
+					URLs: []string{"https://mcr.microsoft.com/v2/windows/blobs/" + foreign.String()}},
+				{MediaType: storage.DockerLayerMediaType, Digest: godigest.FromBytes(layer), Size: int64(len(layer))},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		// the media type of the manifest is the one it is pushed with
+		_, err = imgStore.PutImageManifest("windows", "ltsc", ispec.MediaTypeImageManifest, manifest)
+		So(err, ShouldEqual, errors.ErrBadManifest)
+
+		digest, err := imgStore.PutImageManifest("windows", "ltsc", storage.DockerManifestMediaType, manifest)
+		So(err, ShouldBeNil)
+
+		content, _, mediaType, err := imgStore.GetImageManifest("windows", "ltsc")
+		So(err, ShouldBeNil)
+		So(mediaType, ShouldEqual, storage.DockerManifestMediaType)
+		So(content, ShouldResemble, manifest)
+
+		missing := godigest.FromString("a manifest which wasn't pushed")
+		list := func(digests ...godigest.Digest) []byte {
+			manifests := []ispec.Descriptor{}
+			for _, d := range digests {
+				manifests = append(manifests, ispec.Descriptor{MediaType: storage.DockerManifestMediaType, Digest: d,
+					Size: int64(len(manifest)), Platform: &ispec.Platform{OS: "windows", Architecture: "amd64"}})
+			}
+
+			content, err := json.Marshal(map[string]interface{}{"schemaVersion": 2,
+				"mediaType": storage.DockerManifestListMediaType, "manifests": manifests})
+			So(err, ShouldBeNil)
+
+			return content
+		}
+
+		_, err = imgStore.PutImageManifest("windows", "latest", storage.DockerManifestListMediaType,
+			list(godigest.Digest(digest), missing))
+		So(err, ShouldEqual, errors.ErrIndexManifestNotFound)
+
+		listDigest, err := imgStore.PutImageManifest("windows", "latest", storage.DockerManifestListMediaType,
+			list(godigest.Digest(digest)))
+		So(err, ShouldBeNil)
+
+		rm, err := imgStore.GetRepoMeta("windows")
+		So(err, ShouldBeNil)
+
+		image := rm.Manifests[godigest.Digest(digest)]
+		So(image.MediaType, ShouldEqual, storage.DockerManifestMediaType)
+		So(image.Size, ShouldEqual, int64(len(manifest)+len(config)+len(layer)))
+
+		index := rm.Manifests[rm.Tags["latest"]]
+		So(rm.Tags["latest"], ShouldEqual, godigest.Digest(listDigest))
+		So(index.MediaType, ShouldEqual, storage.DockerManifestListMediaType)
+		So(index.Manifests, ShouldResemble, []godigest.Digest{godigest.Digest(digest)})
+		So(index.Size, ShouldEqual, int64(len(list(godigest.Digest(digest))))+image.Size)
+		So(rm.Size(), ShouldEqual, index.Size)
+
+		// the blobs of Docker manifests are referenced
+		old := time.Now().Add(-24 * time.Hour)
+		for _, d := range []godigest.Digest{godigest.FromBytes(config), godigest.FromBytes(layer)} {
+			So(os.Chtimes(imgStore.BlobPath("windows", d), old, old), ShouldBeNil)
+		}
+
+		So(imgStore.RunGCRepo("windows"), ShouldBeNil)
+
+		for _, d := range []godigest.Digest{godigest.FromBytes(config), godigest.FromBytes(layer)} {
+			_, err := os.Stat(imgStore.BlobPath("windows", d))
+			So(err, ShouldBeNil)
+		}
+
+		report, err := storage.Fsck(dir, false, false, logger)
+		So(err, ShouldBeNil)
+		So(report.Unfixed(), ShouldEqual, 0)
+	})
+}
+
+func TestConvertManifest(t *testing.T) {
+	Convey("Manifests are converted between the OCI and the Docker formats", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		logger := log.Logger{Logger: zerolog.New(ioutil.Discard)}
+		imgStore := storage.NewImageStore(dir, false, false, logger)
+		So(imgStore.InitRepo("app"), ShouldBeNil)
+
+		digest := pushFsckImage(imgStore, "app", "1.0", []byte("a layer"))
+		content, _, mediaType, err := imgStore.GetImageManifest("app", "1.0")
+		So(err, ShouldBeNil)
+		So(mediaType, ShouldEqual, ispec.MediaTypeImageManifest)
+
+		// manifests already in the format are left as is
+		same, sameDigest, _, err := imgStore.ConvertManifest("app", content, digest.String(), mediaType,
+			storage.OCIFormat)
+		So(err, ShouldBeNil)
+		So(same, ShouldResemble, content)
+		So(sameDigest, ShouldEqual, digest.String())
+
+		converted, convertedDigest, convertedMediaType, err := imgStore.ConvertManifest("app", content,
+			digest.String(), mediaType, storage.DockerFormat)
+		So(err, ShouldBeNil)
+		So(convertedMediaType, ShouldEqual, storage.DockerManifestMediaType)
+		So(convertedDigest, ShouldEqual, godigest.FromBytes(converted).String())
+
+		var manifest ispec.Manifest
+		So(json.Unmarshal(converted, &manifest), ShouldBeNil)
+		So(manifest.Config.MediaType, ShouldEqual, storage.DockerConfigMediaType)
+		So(manifest.Layers[0].MediaType, ShouldEqual, storage.DockerTarLayerMediaType)
+		So(manifest.Layers[0].Digest, ShouldEqual, godigest.FromBytes([]byte("a layer")))
+
+		// the stored manifest is untouched
+		stored, _, _, err := imgStore.GetImageManifest("app", digest.String())
+		So(err, ShouldBeNil)
+		So(stored, ShouldResemble, content)
+
+		cached, cachedMediaType, original, err := imgStore.GetConvertedManifest("app", convertedDigest)
+		So(err, ShouldBeNil)
+		So(cached, ShouldResemble, converted)
+		So(cachedMediaType, ShouldEqual, storage.DockerManifestMediaType)
+		So(original, ShouldEqual, digest.String())
+
+		_, _, _, err = imgStore.GetConvertedManifest("other", convertedDigest)
+		So(err, ShouldEqual, errors.ErrManifestNotFound)
+
+		Convey("Indexes reference the converted manifests", func() {
+			index, err := json.Marshal(ispec.Index{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				Manifests: []ispec.Descriptor{
+					{MediaType: ispec.MediaTypeImageManifest, Digest: digest, Size: int64(len(content))},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			indexDigest, err := imgStore.PutImageManifest("app", "latest", ispec.MediaTypeImageIndex, index)
+			So(err, ShouldBeNil)
+
+			list, _, listMediaType, err := imgStore.ConvertManifest("app", index, indexDigest,
+				ispec.MediaTypeImageIndex, storage.DockerFormat)
+			So(err, ShouldBeNil)
+			So(listMediaType, ShouldEqual, storage.DockerManifestListMediaType)
+
+			var converted ispec.Index
+			So(json.Unmarshal(list, &converted), ShouldBeNil)
+			So(converted.Manifests[0].MediaType, ShouldEqual, storage.DockerManifestMediaType)
+			So(converted.Manifests[0].Digest, ShouldEqual, godigest.Digest(convertedDigest))
+		})
+
+		Convey("Media types without a Docker equivalent aren't converted", func() {
+			var zstd ispec.Manifest
+			So(json.Unmarshal(content, &zstd), ShouldBeNil)
+			zstd.Layers[0].MediaType = ispec.MediaTypeImageLayerZstd
+
+			zstdContent, err := json.Marshal(zstd)
+			So(err, ShouldBeNil)
+
+			_, _, _, err = imgStore.ConvertManifest("app", zstdContent, godigest.FromBytes(zstdContent).String(),
+				ispec.MediaTypeImageManifest, storage.DockerFormat)
+			So(goerrors.Is(err, errors.ErrManifestNotConvertible), ShouldBeTrue)
+		})
+
+		Convey("Converted manifests of deleted manifests aren't found", func() {
+			So(imgStore.DeleteImageManifest("app", digest.String()), ShouldBeNil)
+
+			_, _, _, err := imgStore.GetConvertedManifest("app", convertedDigest)
+			So(err, ShouldEqual, errors.ErrManifestNotFound)
+		})
+	})
+}
+
+func TestConcurrentPushes(t *testing.T) {
+	Convey("Tags pushed at the same time by image stores of the same root directory are all kept", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		logger := log.Logger{Logger: zerolog.New(ioutil.Discard)}
+		// e.g. zot instances sharing the storage, each image store has its own locks
+		imgStores := []*storage.ImageStore{
+			storage.NewImageStore(dir, false, false, logger),
+			storage.NewImageStore(dir, false, false, logger),
+		}
+
+		pushFsckImage(imgStores[0], "app", "base", []byte("this is a layer"))
+
+		content, _, mediaType, err := imgStores[0].GetImageManifest("app", "base")
+		So(err, ShouldBeNil)
+
+		var manifest ispec.Manifest
+		So(json.Unmarshal(content, &manifest), ShouldBeNil)
+
+		const count = 32
+
+		var wg sync.WaitGroup
+
+		errs := make([]error, count)
+
+		for i := 0; i < count; i++ {
+			// each tag references its own manifest
+			manifest.Annotations = map[string]string{"tag": fmt.Sprint(i)}
+			body, err := json.Marshal(manifest)
+			So(err, ShouldBeNil)
+
+			wg.Add(1)
+
+			go func(i int, body []byte) {
+				defer wg.Done()
+
+				_, errs[i] = imgStores[i%len(imgStores)].PutImageManifest("app", fmt.Sprintf("%d.0", i), mediaType, body)
+			}(i, body)
+		}
+
+		wg.Wait()
+
+		for _, err := range errs {
+			So(err, ShouldBeNil)
+		}
+
+		tags, err := imgStores[1].GetImageTags("app")
+		So(err, ShouldBeNil)
+		So(len(tags), ShouldEqual, count+1)
+
+		for i := 0; i < count; i++ {
+			So(tags, ShouldContain, fmt.Sprintf("%d.0", i))
+		}
+
+		// concurrent deletes don't bring back the deleted tags either
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+
+				errs[i] = imgStores[i%len(imgStores)].DeleteImageManifest("app", fmt.Sprintf("%d.0", i))
+			}(i)
+		}
+
+		wg.Wait()
+
+		for _, err := range errs {
+			So(err, ShouldBeNil)
+		}
+
+		tags, err = imgStores[0].GetImageTags("app")
+		So(err, ShouldBeNil)
+		So(tags, ShouldResemble, []string{"base"})
+
+		// no temporary index.json is left behind
+		files, err := ioutil.ReadDir(path.Join(dir, "app"))
+		So(err, ShouldBeNil)
+
+		for _, file := range files {
+			So(file.Name(), ShouldNotStartWith, ".index.json-")
+		}
+	})
+}
+
+func TestImmutableTags(t *testing.T) {
+	Convey("Immutable tags are neither moved nor deleted", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.Logger{Logger: zerolog.New(ioutil.Discard)})
+		imgStore.SetImmutableTagCheck(func(repo, tag string) bool {
+			return strings.HasPrefix(tag, "v")
+		})
+
+		layer := []byte("this is a layer")
+		layerDigest := godigest.FromBytes(layer)
+		_, _, err = imgStore.FullBlobUpload("app", bytes.NewReader(layer), layerDigest.String())
+		So(err, ShouldBeNil)
+
+		manifest := ispec.Manifest{
+			Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: layerDigest, Size: int64(len(layer))},
+			Layers: []ispec.Descriptor{{MediaType: ispec.MediaTypeImageLayer, Digest: layerDigest, Size: int64(len(layer))}},
+		}
+		manifest.SchemaVersion = 2
+		mediaType := ispec.MediaTypeImageManifest
+
+		content, err := json.Marshal(manifest)
+		So(err, ShouldBeNil)
+		_, err = imgStore.PutImageManifest("app", "base", mediaType, content)
+		So(err, ShouldBeNil)
+
+		const count = 16
+
+		var wg sync.WaitGroup
+
+		errs := make([]error, count)
+
+		// only one of the concurrent first pushes of a tag succeeds
+		for i := 0; i < count; i++ {
+			manifest.Annotations = map[string]string{"push": fmt.Sprint(i)}
+			body, err := json.Marshal(manifest)
+			So(err, ShouldBeNil)
+
+			wg.Add(1)
+
+			go func(i int, body []byte) {
+				defer wg.Done()
+
+				_, errs[i] = imgStore.PutImageManifest("app", "v1", mediaType, body)
+			}(i, body)
+		}
+
+		wg.Wait()
+
+		pushed := 0
+
+		for _, err := range errs {
+			if err == nil {
+				pushed++
+			} else {
+				So(goerrors.Is(err, errors.ErrImmutableTag), ShouldBeTrue)
+			}
+		}
+
+		So(pushed, ShouldEqual, 1)
+
+		_, digest, _, err := imgStore.GetImageManifest("app", "v1")
+		So(err, ShouldBeNil)
+
+		// re-pushing the same manifest is allowed
+		tagged, _, _, err := imgStore.GetImageManifest("app", digest)
+		So(err, ShouldBeNil)
+		_, err = imgStore.PutImageManifest("app", "v1", mediaType, tagged)
+		So(err, ShouldBeNil)
+
+		err = imgStore.DeleteImageManifest("app", "v1")
+		So(goerrors.Is(err, errors.ErrImmutableTag), ShouldBeTrue)
+
+		// deleting the digest would delete the tag
+		err = imgStore.DeleteImageManifest("app", digest)
+		So(goerrors.Is(err, errors.ErrImmutableTag), ShouldBeTrue)
+
+		So(imgStore.WithoutTagPolicy().DeleteImageManifest("app", "v1"), ShouldBeNil)
+		So(imgStore.DeleteImageManifest("app", "base"), ShouldBeNil)
+
+		tags, err := imgStore.GetImageTags("app")
+		So(err, ShouldBeNil)
+		So(tags, ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,46 @@
+// +build !windows
+
+package storage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/rs/zerolog"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIndexLock(t *testing.T) {
+	Convey("Updates wait for the index.json to be unlocked by the other processes", t, func() {
+		dir, err := ioutil.TempDir("", "oci-repo-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		imgStore := storage.NewImageStore(dir, false, false, log.Logger{Logger: zerolog.New(ioutil.Discard)})
+		pushFsckImage(imgStore, "app", "base", []byte("this is a layer"))
+
+		lock, err := os.OpenFile(path.Join(dir, "app", ".index.json.lock"), os.O_RDWR, 0600)
+		So(err, ShouldBeNil)
+		defer lock.Close()
+
+		So(syscall.Flock(int(lock.Fd()), syscall.LOCK_EX), ShouldBeNil)
+
+		done := make(chan error, 1)
+
+		go func() {
+			done <- imgStore.DeleteImageManifest("app", "base")
+		}()
+
+		time.Sleep(200 * time.Millisecond)
+		So(done, ShouldBeEmpty)
+
+		So(syscall.Flock(int(lock.Fd()), syscall.LOCK_UN), ShouldBeNil)
+		So(<-done, ShouldBeNil)
+	})
+}
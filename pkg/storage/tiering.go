@@ -0,0 +1,437 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anuvu/zot/errors"
+	zlog "github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/scheduler"
+	godigest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// blobAccessResolution is how often the pulls of a blob are recorded in the cache db.
+const blobAccessResolution = time.Hour
+
+// TieringReport reports the blobs an image store moved to its cold tier.
+type TieringReport struct {
+	RootDir   string        `json:"rootDir"`
+	ColdDir   string        `json:"coldDir"`
+	ColdAfter time.Duration `json:"coldAfterNs"`
+	ColdBlobs int           `json:"coldBlobs"`
+	ColdBytes int64         `json:"coldBytes"`
+}
+
+// TieringResult reports the layers a tiering pass moved to the cold tier.
+type TieringResult struct {
+	RootDir    string `json:"rootDir"`
+	Moved      int    `json:"moved"`
+	MovedBytes int64  `json:"movedBytes"`
+}
+
+// tiering moves the layers which weren't pulled for a while to a cold tier, a directory usually on
+// cheaper storage. Their repository paths are replaced with symlinks to the cold tier, so that they
+// can still be read, and they're moved back on their next pull.
+type tiering struct {
+	coldDir string
+	after   time.Duration
+	// records the moved blobs and the last pulls, the dedupe cache if enabled
+	cache *Cache
+	lock  sync.Mutex
+	// last pulls recorded in the cache db
+	recorded map[godigest.Digest]time.Time
+}
+
+func (t *tiering) coldPath(digest godigest.Digest) string {
+	return path.Join(t.coldDir, "blobs", digest.Algorithm().String(), digest.Encoded())
+}
+
+// isColdLink tells whether the blob file is a symlink to the cold tier.
+func (t *tiering) isColdLink(blobPath string) bool {
+	info, err := os.Lstat(blobPath)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+
+	target, err := os.Readlink(blobPath)
+
+	return err == nil && strings.HasPrefix(target, t.coldDir+"/")
+}
+
+// EnableTiering moves the layers which weren't pulled within after to the cold directory on each
+// TierBlobs pass, and back to the image store on their next pull.
+func (is *ImageStore) EnableTiering(coldDir string, after time.Duration) error {
+	if err := os.MkdirAll(coldDir, 0700); err != nil {
+		is.log.Error().Err(err).Str("coldDir", coldDir).Msg("tiering: unable to create cold dir")
+		return err
+	}
+
+	cache := is.cache
+	if cache == nil {
+		if cache = NewCache(is.rootDir, "cache", zlog.Logger{Logger: is.log}); cache == nil {
+			return errors.ErrColdTierUnavailable
+		}
+	}
+
+	is.tiering = &tiering{
+		coldDir:  path.Clean(coldDir),
+		after:    after,
+		cache:    cache,
+		recorded: make(map[godigest.Digest]time.Time),
+	}
+
+	return nil
+}
+
+// TieringEnabled tells whether layers which weren't pulled recently are moved to a cold tier.
+func (is *ImageStore) TieringEnabled() bool {
+	return is.tiering != nil
+}
+
+// TieringReport reports the blobs of the cold tier, the zero report if tiering isn't enabled.
+func (is *ImageStore) TieringReport() (TieringReport, error) {
+	report := TieringReport{RootDir: is.rootDir}
+
+	if is.tiering == nil {
+		return report, nil
+	}
+
+	report.ColdDir = is.tiering.coldDir
+	report.ColdAfter = is.tiering.after
+
+	is.RLock()
+	defer is.RUnlock()
+
+	digests, err := is.tiering.cache.ColdBlobs()
+	if err != nil {
+		return report, err
+	}
+
+	for _, digest := range digests {
+		info, err := os.Stat(is.tiering.coldPath(godigest.Digest(digest)))
+		if err != nil {
+			continue
+		}
+
+		report.ColdBlobs++
+		report.ColdBytes += info.Size()
+	}
+
+	return report, nil
+}
+
+// recordBlobAccess records the pull of a blob, at most once per blobAccessResolution.
+func (is *ImageStore) recordBlobAccess(digest godigest.Digest) {
+	t := is.tiering
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+
+	t.lock.Lock()
+
+	if last, ok := t.recorded[digest]; ok && now.Sub(last) < blobAccessResolution {
+		t.lock.Unlock()
+		return
+	}
+
+	t.recorded[digest] = now
+
+	t.lock.Unlock()
+
+	if err := t.cache.PutBlobAccess(digest.String(), now); err != nil {
+		is.log.Error().Err(err).Str("digest", digest.String()).Msg("tiering: unable to record blob access")
+	}
+}
+
+// lastAccess returns when the blob was last pulled, or pushed if it never was.
+func (is *ImageStore) lastAccess(digest godigest.Digest, files []blobFile) time.Time {
+	last, _ := is.tiering.cache.GetBlobAccess(digest.String())
+
+	for _, file := range files {
+		if file.info.ModTime().After(last) {
+			last = file.info.ModTime()
+		}
+	}
+
+	return last
+}
+
+// layers returns the digests of the layers of all the image manifests, the caller must hold the lock.
+// Manifests and image configs are read from disk by GC and search, so they're never moved.
+func (is *ImageStore) layers() (map[godigest.Digest]bool, error) {
+	repos, err := is.getRepositories()
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make(map[godigest.Digest]bool)
+
+	for _, repo := range repos {
+		index, err := is.readIndex(repo)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, desc := range index.Manifests {
+			if !IsImageManifestMediaType(desc.MediaType) {
+				continue
+			}
+
+			buf, err := ioutil.ReadFile(is.BlobPath(repo, desc.Digest))
+			if err != nil {
+				is.log.Error().Err(err).Str("repo", repo).Str("digest", desc.Digest.String()).
+					Msg("tiering: unable to read manifest")
+
+				return nil, err
+			}
+
+			var manifest ispec.Manifest
+			if err := json.Unmarshal(buf, &manifest); err != nil {
+				is.log.Error().Err(err).Str("repo", repo).Str("digest", desc.Digest.String()).Msg("invalid JSON")
+				return nil, err
+			}
+
+			for _, layer := range manifest.Layers {
+				layers[layer.Digest] = true
+			}
+		}
+	}
+
+	return layers, nil
+}
+
+// TierBlobs moves the layers which weren't pulled recently to the cold tier, and drops the cold blobs
+// which were removed from all their repositories since. It does nothing unless tiering is enabled.
+func (is *ImageStore) TierBlobs() (TieringResult, error) {
+	result := TieringResult{RootDir: is.rootDir}
+
+	if is.tiering == nil {
+		return result, nil
+	}
+
+	is.Lock()
+	defer is.Unlock()
+
+	if err := is.pruneColdBlobs(); err != nil {
+		return result, err
+	}
+
+	layers, err := is.layers()
+	if err != nil {
+		return result, err
+	}
+
+	// blobs already in the cold tier are symlinks, scanned blobs are all in the image store
+	blobs, err := is.scanBlobs()
+	if err != nil {
+		return result, err
+	}
+
+	cutoff := time.Now().Add(-is.tiering.after)
+
+	for digest, files := range blobs {
+		if !layers[digest] || is.lastAccess(digest, files).After(cutoff) {
+			continue
+		}
+
+		if err := is.moveToColdTier(digest, files); err != nil {
+			return result, err
+		}
+
+		result.Moved++
+		result.MovedBytes += files[0].info.Size() * int64(len(blobCopies(files)))
+	}
+
+	is.log.Info().Str("rootDir", is.rootDir).Int("moved", result.Moved).Int64("movedBytes", result.MovedBytes).
+		Msg("tiering: pass done")
+
+	return result, nil
+}
+
+// moveToColdTier copies the blob to the cold tier, and replaces its repository files with symlinks to it.
+func (is *ImageStore) moveToColdTier(digest godigest.Digest, files []blobFile) error {
+	coldPath := is.tiering.coldPath(digest)
+
+	if err := ensureDir(path.Dir(coldPath), is.log); err != nil {
+		return err
+	}
+
+	// a previous pass may have copied it already
+	if info, err := os.Stat(coldPath); err != nil || info.Size() != files[0].info.Size() {
+		if err := copyFile(files[0].path, coldPath); err != nil {
+			is.log.Error().Err(err).Str("blob", files[0].path).Str("coldPath", coldPath).
+				Msg("tiering: unable to copy blob to the cold tier")
+
+			return err
+		}
+	}
+
+	for _, file := range files {
+		// recorded first, so that it isn't lost if the symlink is left behind
+		if err := is.tiering.cache.PutColdBlob(digest.String(), file.path); err != nil {
+			return err
+		}
+
+		tmp := file.path + ".cold"
+
+		_ = os.Remove(tmp)
+
+		if err := os.Symlink(coldPath, tmp); err != nil {
+			is.log.Error().Err(err).Str("blob", file.path).Msg("tiering: unable to symlink")
+			return err
+		}
+
+		if err := os.Rename(tmp, file.path); err != nil {
+			_ = os.Remove(tmp)
+
+			is.log.Error().Err(err).Str("blob", file.path).Msg("tiering: unable to symlink")
+
+			return err
+		}
+	}
+
+	is.log.Debug().Str("digest", digest.String()).Str("coldPath", coldPath).Msg("tiering: moved blob to the cold tier")
+
+	return nil
+}
+
+// pruneColdBlobs forgets the repository paths which aren't symlinks to the cold tier anymore, e.g.
+// removed by GC, and removes the cold blobs left without any, the caller must hold the lock.
+func (is *ImageStore) pruneColdBlobs() error {
+	digests, err := is.tiering.cache.ColdBlobs()
+	if err != nil {
+		return err
+	}
+
+	for _, digest := range digests {
+		paths, err := is.tiering.cache.GetColdBlob(digest)
+		if err != nil {
+			return err
+		}
+
+		linked := 0
+
+		for _, rel := range paths {
+			blobPath := path.Join(is.rootDir, rel)
+			if is.tiering.isColdLink(blobPath) {
+				linked++
+				continue
+			}
+
+			if err := is.tiering.cache.DeleteColdBlob(digest, blobPath); err != nil {
+				return err
+			}
+		}
+
+		if linked == 0 {
+			if err := os.Remove(is.tiering.coldPath(godigest.Digest(digest))); err != nil && !os.IsNotExist(err) {
+				is.log.Error().Err(err).Str("digest", digest).Msg("tiering: unable to remove cold blob")
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// recallBlob moves the blob back from the cold tier, if it's there.
+func (is *ImageStore) recallBlob(digest godigest.Digest) error {
+	if is.tiering == nil {
+		return nil
+	}
+
+	if _, err := is.tiering.cache.GetColdBlob(digest.String()); err != nil {
+		return nil
+	}
+
+	is.Lock()
+	defer is.Unlock()
+
+	return is.recallColdBlob(digest)
+}
+
+// recallColdBlob moves the blob back from the cold tier to all the repositories it was moved from,
+// the caller must hold the lock.
+func (is *ImageStore) recallColdBlob(digest godigest.Digest) error {
+	if is.tiering == nil {
+		return nil
+	}
+
+	// another request may have recalled it while waiting for the lock
+	paths, err := is.tiering.cache.GetColdBlob(digest.String())
+	if err != nil {
+		return nil
+	}
+
+	coldPath := is.tiering.coldPath(digest)
+	recalled := ""
+
+	for _, rel := range paths {
+		blobPath := path.Join(is.rootDir, rel)
+
+		if is.tiering.isColdLink(blobPath) {
+			// copies of the blob are hard links of each other if dedupe is enabled
+			if recalled == "" || !is.DedupeEnabled() || relink(recalled, blobPath) != nil {
+				if err := copyFile(coldPath, blobPath); err != nil {
+					is.log.Error().Err(err).Str("blob", blobPath).Str("coldPath", coldPath).
+						Msg("tiering: unable to recall blob")
+
+					return err
+				}
+			}
+
+			recalled = blobPath
+		}
+
+		if err := is.tiering.cache.DeleteColdBlob(digest.String(), blobPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(coldPath); err != nil && !os.IsNotExist(err) {
+		is.log.Error().Err(err).Str("coldPath", coldPath).Msg("tiering: unable to remove cold blob")
+	}
+
+	is.log.Info().Str("digest", digest.String()).Int("repos", len(paths)).Msg("tiering: recalled blob")
+
+	return nil
+}
+
+// copyFile atomically replaces dst with a copy of src.
+func copyFile(src, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return replaceFile(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, source)
+		return err
+	})
+}
+
+type tieringTask struct {
+	imgStore *ImageStore
+}
+
+// NewTieringTask returns a scheduler task which moves the layers of an image store which weren't
+// pulled recently to its cold tier.
+func NewTieringTask(imgStore *ImageStore) scheduler.Task {
+	return &tieringTask{imgStore: imgStore}
+}
+
+func (t *tieringTask) DoWork() error {
+	_, err := t.imgStore.TierBlobs()
+
+	return err
+}
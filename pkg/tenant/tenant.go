@@ -0,0 +1,269 @@
+// Package tenant isolates the tenants of a shared registry. A tenant owns the repositories under its
+// route prefix, e.g. "acme/app" for "acme", kept in an image store of its own: only its members access
+// them, and their total size is capped by the quota of the tenant.
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+)
+
+// File keeps the members and the quota of a tenant, once changed at runtime, in the root directory of
+// its image store. It overrides the configured ones.
+const File = "tenant.json"
+
+// accessFilterContextKey is the context key of the repositories the user of a request may access.
+type accessFilterContextKey struct{}
+
+// Tenant is the access policy of a tenant and its quota, in bytes, none if 0.
+type Tenant struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+	Quota   int64    `json:"quota,omitempty"`
+}
+
+// IsMember tells whether a user is a member of the tenant.
+func (t Tenant) IsMember(username string) bool {
+	for _, member := range t.Members {
+		if member == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Usage is the storage used by a tenant, the sum of the sizes of its repositories.
+type Usage struct {
+	Repositories int   `json:"repositories"`
+	Bytes        int64 `json:"bytes"`
+}
+
+// Status is a tenant with its storage usage.
+type Status struct {
+	Tenant
+	Usage Usage `json:"usage"`
+}
+
+// Tenants are the tenants of the registry with their image stores.
+type Tenants struct {
+	log        log.Logger
+	lock       sync.RWMutex
+	tenants    map[string]Tenant
+	stores     map[string]*storage.ImageStore
+	generation uint64
+}
+
+// NewTenants returns an empty list of tenants.
+func NewTenants(log log.Logger) *Tenants {
+	return &Tenants{
+		log:     log,
+		tenants: make(map[string]Tenant),
+		stores:  make(map[string]*storage.ImageStore),
+	}
+}
+
+// Add adds a tenant with its image store, the members and quota saved in the store taking precedence.
+func (t *Tenants) Add(tenant Tenant, imgStore *storage.ImageStore) error {
+	buf, err := ioutil.ReadFile(path.Join(imgStore.RootDir(), File))
+
+	switch {
+	case err == nil:
+		var saved Tenant
+		if err := json.Unmarshal(buf, &saved); err != nil {
+			return err
+		}
+
+		tenant.Members = saved.Members
+		tenant.Quota = saved.Quota
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	if tenant.Members == nil {
+		tenant.Members = []string{}
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.tenants[tenant.Name] = tenant
+	t.stores[tenant.Name] = imgStore
+
+	return nil
+}
+
+// Get returns the tenant of a repository, false if it belongs to none. It's safe on nil tenants.
+func (t *Tenants) Get(repo string) (Tenant, bool) {
+	if t == nil {
+		return Tenant{}, false
+	}
+
+	i := strings.Index(repo, "/")
+	if i < 0 {
+		return Tenant{}, false
+	}
+
+	return t.get(repo[:i])
+}
+
+func (t *Tenants) get(name string) (Tenant, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	tenant, ok := t.tenants[name]
+
+	return tenant, ok
+}
+
+// All returns the tenants by name, with their storage usage.
+func (t *Tenants) All() []Status {
+	t.lock.RLock()
+	tenants := make([]Tenant, 0, len(t.tenants))
+
+	for _, tenant := range t.tenants {
+		tenants = append(tenants, tenant)
+	}
+	t.lock.RUnlock()
+
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].Name < tenants[j].Name
+	})
+
+	statuses := make([]Status, 0, len(tenants))
+
+	for _, tenant := range tenants {
+		usage, err := t.Usage(tenant.Name)
+		if err != nil {
+			t.log.Error().Err(err).Str("tenant", tenant.Name).Msg("unable to compute tenant storage usage")
+		}
+
+		statuses = append(statuses, Status{Tenant: tenant, Usage: usage})
+	}
+
+	return statuses
+}
+
+// Update changes the members and the quota of a tenant and saves them.
+func (t *Tenants) Update(tenant Tenant) error {
+	if tenant.Quota < 0 {
+		return errors.ErrBadConfig
+	}
+
+	if tenant.Members == nil {
+		tenant.Members = []string{}
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	imgStore, ok := t.stores[tenant.Name]
+	if !ok {
+		return errors.ErrTenantNotFound
+	}
+
+	buf, err := json.MarshalIndent(tenant, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file := path.Join(imgStore.RootDir(), File)
+	tmp := file + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, file); err != nil {
+		return err
+	}
+
+	t.tenants[tenant.Name] = tenant
+	t.generation++
+
+	return nil
+}
+
+// Generation returns a number incremented on each update of the tenants, so that the results computed from
+// their members can be cached until it changes. It's safe on nil tenants.
+func (t *Tenants) Generation() uint64 {
+	if t == nil {
+		return 0
+	}
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.generation
+}
+
+// Usage returns the storage used by a tenant, repositories without metadata yet are not counted.
+func (t *Tenants) Usage(name string) (Usage, error) {
+	t.lock.RLock()
+	imgStore, ok := t.stores[name]
+	t.lock.RUnlock()
+
+	if !ok {
+		return Usage{}, errors.ErrTenantNotFound
+	}
+
+	repos, err := imgStore.GetRepositories()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage := Usage{}
+
+	for _, repo := range repos {
+		if !strings.HasPrefix(repo, name+"/") {
+			continue
+		}
+
+		usage.Repositories++
+
+		if rm, err := imgStore.GetRepoMeta(repo); err == nil {
+			usage.Bytes += rm.Size()
+		}
+	}
+
+	return usage, nil
+}
+
+// ExceedsQuota tells whether growing the storage of a tenant by some bytes would exceed its quota.
+func (t *Tenants) ExceedsQuota(name string, growth int64) bool {
+	tenant, ok := t.get(name)
+	if !ok || tenant.Quota == 0 || growth <= 0 {
+		return false
+	}
+
+	usage, err := t.Usage(name)
+	if err != nil {
+		t.log.Error().Err(err).Str("tenant", name).Msg("unable to compute tenant storage usage")
+		return false
+	}
+
+	return usage.Bytes+growth > tenant.Quota
+}
+
+// WithAccessFilter returns a copy of the context of a request which carries the repositories its user may
+// access, for the handlers listing the repositories of several tenants, e.g. search.
+func WithAccessFilter(ctx context.Context, allowed func(repo string) bool) context.Context {
+	return context.WithValue(ctx, accessFilterContextKey{}, allowed)
+}
+
+// IsAllowed tells whether the user of a request may access a repository, none if its context has no access filter.
+func IsAllowed(ctx context.Context, repo string) bool {
+	allowed, ok := ctx.Value(accessFilterContextKey{}).(func(repo string) bool)
+
+	return ok && allowed(repo)
+}
@@ -0,0 +1,78 @@
+package tenant_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/anuvu/zot/errors"
+	"github.com/anuvu/zot/pkg/log"
+	"github.com/anuvu/zot/pkg/storage"
+	"github.com/anuvu/zot/pkg/tenant"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTenants(t *testing.T) {
+	Convey("Tenants keep their members and quota in their image store", t, func() {
+		dir, err := ioutil.TempDir("", "oci-tenant-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		logger := log.NewLogger("debug", "")
+		imgStore := storage.NewImageStore(dir, false, false, logger)
+
+		tenants := tenant.NewTenants(logger)
+		So(tenants.Add(tenant.Tenant{Name: "acme", Members: []string{"alice"}}, imgStore), ShouldBeNil)
+
+		acme, ok := tenants.Get("acme/app")
+		So(ok, ShouldBeTrue)
+		So(acme.IsMember("alice"), ShouldBeTrue)
+		So(acme.IsMember("bob"), ShouldBeFalse)
+
+		_, ok = tenants.Get("acme")
+		So(ok, ShouldBeFalse)
+
+		_, ok = tenants.Get("other/app")
+		So(ok, ShouldBeFalse)
+
+		var none *tenant.Tenants
+		_, ok = none.Get("acme/app")
+		So(ok, ShouldBeFalse)
+
+		generation := tenants.Generation()
+		So(tenants.Update(tenant.Tenant{Name: "acme", Members: []string{"bob"}, Quota: 10}), ShouldBeNil)
+		So(tenants.Generation(), ShouldBeGreaterThan, generation)
+		So(tenants.Update(tenant.Tenant{Name: "acme", Quota: -1}), ShouldEqual, errors.ErrBadConfig)
+		So(tenants.Update(tenant.Tenant{Name: "other"}), ShouldEqual, errors.ErrTenantNotFound)
+
+		So(tenants.ExceedsQuota("acme", 5), ShouldBeFalse)
+		So(tenants.ExceedsQuota("acme", 11), ShouldBeTrue)
+
+		_, err = tenants.Usage("other")
+		So(err, ShouldEqual, errors.ErrTenantNotFound)
+
+		// the saved members and quota override the configured ones
+		reloaded := tenant.NewTenants(logger)
+		So(reloaded.Add(tenant.Tenant{Name: "acme", Members: []string{"alice"}}, imgStore), ShouldBeNil)
+		So(reloaded.All(), ShouldResemble, []tenant.Status{
+			{Tenant: tenant.Tenant{Name: "acme", Members: []string{"bob"}, Quota: 10}},
+		})
+
+		So(ioutil.WriteFile(path.Join(dir, tenant.File), []byte("{"), 0600), ShouldBeNil)
+		So(tenant.NewTenants(logger).Add(tenant.Tenant{Name: "acme"}, imgStore), ShouldNotBeNil)
+	})
+}
+
+func TestAccessFilter(t *testing.T) {
+	Convey("Only the repositories allowed by the access filter of the context are", t, func() {
+		So(tenant.IsAllowed(context.Background(), "app"), ShouldBeFalse)
+
+		ctx := tenant.WithAccessFilter(context.Background(), func(repo string) bool {
+			return repo == "app"
+		})
+		So(tenant.IsAllowed(ctx, "app"), ShouldBeTrue)
+		So(tenant.IsAllowed(ctx, "acme/app"), ShouldBeFalse)
+	})
+}